@@ -41,6 +41,28 @@ type GoogleOAuth struct {
 	RedirectURL  string `json:"callbackUrl"`
 }
 
+type BuildQueue struct {
+	Workers      int `json:"workers"`
+	MaxAttempts  int `json:"maxAttempts"`
+	LeaseSeconds int `json:"leaseSeconds"`
+}
+
+type Trash struct {
+	RetentionDays int `json:"retentionDays"`
+}
+
+type LinkCheck struct {
+	BeforeBuild  bool `json:"beforeBuild"`
+	BlockPublish bool `json:"blockPublish"`
+}
+
+type Translation struct {
+	Provider       string `json:"provider"`
+	URL            string `json:"url"`
+	APIKey         string `json:"apiKey"`
+	TimeoutSeconds int    `json:"timeoutSeconds"`
+}
+
 type Config struct {
 	Environment    string         `json:"environment"`
 	Port           int            `json:"port"`
@@ -55,6 +77,16 @@ type Config struct {
 	GithubOAuth    GithubOAuth    `json:"githubOAuth"`
 	MicrosoftOAuth MicrosoftOAuth `json:"microsoftOAuth"`
 	GoogleOAuth    GoogleOAuth    `json:"googleOAuth"`
+	BuildQueue     BuildQueue     `json:"buildQueue"`
+	Trash          Trash          `json:"trash"`
+	LinkCheck      LinkCheck      `json:"linkCheck"`
+	Translation    Translation    `json:"translation"`
+	// TrustedProxies are the addresses or CIDR ranges of the reverse proxies
+	// whose forwarding headers are believed.
+	TrustedProxies []string `json:"trustedProxies"`
+	// ImportRoot is the directory Markdown imports may read from the
+	// server, imports by path are refused when it's empty.
+	ImportRoot string `json:"importRoot"`
 }
 
 var ParsedConfig *Config
@@ -89,6 +121,26 @@ func ParseConfig(path string) *Config {
 		ParsedConfig.MaxFileSize = 10
 	}
 
+	if ParsedConfig.BuildQueue.Workers <= 0 {
+		ParsedConfig.BuildQueue.Workers = 2
+	}
+
+	if ParsedConfig.BuildQueue.MaxAttempts <= 0 {
+		ParsedConfig.BuildQueue.MaxAttempts = 3
+	}
+
+	if ParsedConfig.BuildQueue.LeaseSeconds <= 0 {
+		ParsedConfig.BuildQueue.LeaseSeconds = 120
+	}
+
+	if ParsedConfig.Trash.RetentionDays <= 0 {
+		ParsedConfig.Trash.RetentionDays = 30
+	}
+
+	if ParsedConfig.Translation.TimeoutSeconds <= 0 {
+		ParsedConfig.Translation.TimeoutSeconds = 30
+	}
+
 	return ParsedConfig
 }
 
@@ -7,6 +7,7 @@ import (
 	"log"
 	"path"
 	"strings"
+	"time"
 
 	"git.difuse.io/Difuse/kalmia/config"
 	"git.difuse.io/Difuse/kalmia/db/models"
@@ -44,13 +45,35 @@ func SetupDatabase(env string, database string, dataPath string) *gorm.DB {
 		db.Exec("PRAGMA synchronous = NORMAL")
 	}
 
+	seedMembers := !db.Migrator().HasTable(&models.DocumentationMember{})
+	seedPublished := !db.Migrator().HasColumn(&models.Page{}, "PublishedRevisionID")
+
 	err = db.AutoMigrate(
 		&models.User{},
 		&models.Token{},
+		&models.APIToken{},
+		&models.Group{},
+		&models.DocumentationGroupGrant{},
 		&models.Documentation{},
 		&models.BuildTriggers{},
+		&models.BuildStep{},
 		&models.PageGroup{},
 		&models.Page{},
+		&models.PageRevision{},
+		&models.SearchDocument{},
+		&models.Webhook{},
+		&models.WebhookDelivery{},
+		&models.DocumentationMember{},
+		&models.AuditLog{},
+		&models.ChangeRequest{},
+		&models.ChangeRequestChange{},
+		&models.ChangeRequestReview{},
+		&models.CommentThread{},
+		&models.Comment{},
+		&models.EditLock{},
+		&models.TrashItem{},
+		&models.PagePath{},
+		&models.Redirect{},
 	)
 
 	if err != nil {
@@ -59,6 +82,22 @@ func SetupDatabase(env string, database string, dataPath string) *gorm.DB {
 
 	db.Exec("UPDATE pages SET is_page = TRUE WHERE is_page IS NULL")
 	db.Exec("UPDATE page_groups SET is_page_group = TRUE WHERE is_page_group IS NULL")
+	db.Exec("UPDATE build_triggers SET status = 'succeeded' WHERE status IS NULL AND triggered = TRUE")
+	db.Exec("UPDATE build_triggers SET status = 'queued' WHERE status IS NULL")
+
+	if seedMembers {
+		seedDocumentationMembers(db)
+	}
+
+	if seedPublished {
+		seedPublishedContent(db)
+	}
+
+	err = setupSearchIndex(db)
+
+	if err != nil {
+		logger.Error("Search index setup failed", zap.Error(err))
+	}
 
 	err = updateUserPermissions(db)
 
@@ -119,6 +158,32 @@ func SetupBasicData(db *gorm.DB, admins []config.User) {
 	logger.Info("Database initialized")
 }
 
+// seedDocumentationMembers carries documentations created before per
+// documentation roles over: authors become owners and editors editors.
+func seedDocumentationMembers(db *gorm.DB) {
+	result := db.Exec(`
+		INSERT INTO documentation_members (documentation_id, user_id, role, created_at, updated_at)
+		SELECT id, author_id, 'owner', CURRENT_TIMESTAMP, CURRENT_TIMESTAMP
+		FROM documentations
+		WHERE cloned_from IS NULL AND author_id IS NOT NULL AND author_id <> 0
+	`)
+	if result.Error != nil {
+		logger.Error("Failed to seed documentation owners", zap.Error(result.Error))
+		return
+	}
+
+	result = db.Exec(`
+		INSERT INTO documentation_members (documentation_id, user_id, role, created_at, updated_at)
+		SELECT DISTINCT documentations.id, documentation_editors.user_id, 'editor', CURRENT_TIMESTAMP, CURRENT_TIMESTAMP
+		FROM documentation_editors
+		JOIN documentations ON documentations.id = documentation_editors.documentation_id
+		WHERE documentations.cloned_from IS NULL AND documentation_editors.user_id <> documentations.author_id
+	`)
+	if result.Error != nil {
+		logger.Error("Failed to seed documentation editors", zap.Error(result.Error))
+	}
+}
+
 func updateUserPermissions(db *gorm.DB) error {
 	var result *gorm.DB
 	dialectName := strings.ToLower(db.Dialector.Name())
@@ -153,3 +218,48 @@ func updateUserPermissions(db *gorm.DB) error {
 
 	return nil
 }
+
+// seedPublishedContent keeps everything that was live before drafts existed
+// live: every page group is published and every page publishes its newest
+// revision, which is created from the page when it has none.
+func seedPublishedContent(db *gorm.DB) {
+	if err := db.Exec("UPDATE page_groups SET published_name = name, published_at = CURRENT_TIMESTAMP WHERE published_at IS NULL").Error; err != nil {
+		logger.Error("Failed to publish existing page groups", zap.Error(err))
+	}
+
+	var pages []models.Page
+	if err := db.Where("published_revision_id IS NULL").Find(&pages).Error; err != nil {
+		logger.Error("Failed to get existing pages", zap.Error(err))
+		return
+	}
+
+	now := time.Now().UTC()
+	for _, page := range pages {
+		var revision models.PageRevision
+		err := db.Where("page_id = ?", page.ID).Order("id DESC").First(&revision).Error
+
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			revision = models.PageRevision{
+				PageID:          page.ID,
+				DocumentationID: page.DocumentationID,
+				EditorID:        page.AuthorID,
+				Title:           page.Title,
+				Slug:            page.Slug,
+				Content:         page.Content,
+			}
+			err = db.Create(&revision).Error
+		}
+
+		if err != nil {
+			logger.Error("Failed to get a revision to publish", zap.Uint("page_id", page.ID), zap.Error(err))
+			continue
+		}
+
+		if err := db.Model(&models.Page{}).Where("id = ?", page.ID).UpdateColumns(map[string]interface{}{
+			"published_revision_id": revision.ID,
+			"published_at":          now,
+		}).Error; err != nil {
+			logger.Error("Failed to publish an existing page", zap.Uint("page_id", page.ID), zap.Error(err))
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	jsonx "github.com/clarketm/json"
+	"gorm.io/gorm"
+)
+
+// AuditLog is an append-only record of a change made through the services.
+// Before and After hold JSON summaries of the entity around the change.
+type AuditLog struct {
+	ID         uint      `gorm:"primarykey" json:"id"`
+	ActorID    *uint     `gorm:"index" json:"actorId"`
+	ActorName  string    `json:"actorName"`
+	Action     string    `gorm:"index" json:"action"`
+	EntityType string    `gorm:"index" json:"entityType"`
+	EntityID   uint      `gorm:"index" json:"entityId"`
+	Before     string    `json:"before"`
+	After      string    `json:"after"`
+	IP         string    `json:"ip"`
+	UserAgent  string    `json:"userAgent"`
+	CreatedAt  time.Time `gorm:"autoCreateTime;index" json:"createdAt"`
+}
+
+var ErrAuditLogAppendOnly = errors.New("audit_log_append_only")
+
+func (AuditLog) BeforeUpdate(tx *gorm.DB) error {
+	return ErrAuditLogAppendOnly
+}
+
+func (AuditLog) BeforeDelete(tx *gorm.DB) error {
+	return ErrAuditLogAppendOnly
+}
+
+func (s AuditLog) MarshalJSON() ([]byte, error) {
+	type TmpStruct AuditLog
+	return jsonx.Marshal(TmpStruct(s))
+}
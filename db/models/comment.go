@@ -0,0 +1,44 @@
+package models
+
+import (
+	"time"
+
+	jsonx "github.com/clarketm/json"
+)
+
+// CommentThread is a discussion on a page, optionally about one of its
+// blocks. Comments live apart from the page content and are never built.
+type CommentThread struct {
+	ID              uint       `gorm:"primarykey" json:"id"`
+	PageID          uint       `gorm:"index" json:"pageId"`
+	DocumentationID uint       `gorm:"index" json:"documentationId"`
+	BlockID         string     `json:"blockId,omitempty"`
+	AuthorID        uint       `json:"authorId"`
+	Author          User       `gorm:"foreignKey:AuthorID" json:"author,omitempty"`
+	Resolved        bool       `gorm:"index;default:false" json:"resolved"`
+	ResolvedByID    *uint      `json:"resolvedById"`
+	ResolvedAt      *time.Time `json:"resolvedAt"`
+	Comments        []Comment  `gorm:"foreignKey:ThreadID;constraint:OnDelete:CASCADE" json:"comments,omitempty"`
+	CreatedAt       *time.Time `gorm:"autoCreateTime" json:"createdAt"`
+	UpdatedAt       *time.Time `gorm:"autoUpdateTime" json:"updatedAt"`
+}
+
+func (s CommentThread) MarshalJSON() ([]byte, error) {
+	type TmpStruct CommentThread
+	return jsonx.Marshal(TmpStruct(s))
+}
+
+type Comment struct {
+	ID        uint       `gorm:"primarykey" json:"id"`
+	ThreadID  uint       `gorm:"index" json:"threadId"`
+	AuthorID  uint       `json:"authorId"`
+	Author    User       `gorm:"foreignKey:AuthorID" json:"author,omitempty"`
+	Body      string     `json:"body"`
+	Mentions  []User     `gorm:"many2many:comment_mentions;" json:"mentions,omitempty"`
+	CreatedAt *time.Time `gorm:"autoCreateTime" json:"createdAt"`
+}
+
+func (s Comment) MarshalJSON() ([]byte, error) {
+	type TmpStruct Comment
+	return jsonx.Marshal(TmpStruct(s))
+}
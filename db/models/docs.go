@@ -4,45 +4,107 @@ import (
 	"time"
 
 	jsonx "github.com/clarketm/json"
+	"gorm.io/gorm"
 )
 
 type Page struct {
+	ID                  uint           `gorm:"primarykey" json:"id,omitempty"`
+	AuthorID            uint           `json:"authorId,omitempty"`
+	Author              User           `gorm:"foreignKey:AuthorID" json:"author,omitempty"`
+	DocumentationID     uint           `gorm:"index:idx_doc_slug,unique:true,composite:true" json:"documentationId,omitempty"`
+	PageGroupID         *uint          `json:"pageGroupId,omitempty" gorm:"foreignKey:PageGroupID"`
+	Title               string         `json:"title,omitempty"`
+	Slug                string         `gorm:"index:idx_doc_slug,unique:true,composite:true" json:"slug,omitempty"`
+	Content             string         `json:"content,omitempty"`
+	CreatedAt           *time.Time     `gorm:"autoCreateTime" json:"createdAt,omitempty"`
+	UpdatedAt           *time.Time     `gorm:"autoUpdateTime" json:"updatedAt,omitempty"`
+	Order               *uint          `json:"order,omitempty"`
+	Editors             []User         `gorm:"many2many:page_editors;" json:"editors,omitempty"`
+	LastEditorID        *uint          `json:"lastEditorId,omitempty"`
+	IsIntroPage         bool           `json:"isIntroPage,omitempty" gorm:"default:false"`
+	IsPage              bool           `json:"isPage" gorm:"default:true"`
+	PublishedRevisionID *uint          `gorm:"index" json:"publishedRevisionId"`
+	PublishedAt         *time.Time     `json:"publishedAt"`
+	PublishAt           *time.Time     `gorm:"index" json:"publishAt"`
+	UnpublishAt         *time.Time     `gorm:"index" json:"unpublishAt"`
+	Version             uint           `gorm:"default:1" json:"version"`
+	Language            string         `gorm:"index" json:"language,omitempty"`
+	TranslationOfID     *uint          `gorm:"index" json:"translationOfId,omitempty"`
+	SourceVersion       uint           `json:"sourceVersion,omitempty"`
+	Lock                *EditLock      `gorm:"-" json:"lock"`
+	DeletedAt           gorm.DeletedAt `gorm:"index" json:"-"`
+	TrashItemID         *uint          `gorm:"index" json:"-"`
+}
+
+// BeforeCreate starts every page at version 1, the version an edit has to
+// name to be accepted.
+func (s *Page) BeforeCreate(tx *gorm.DB) error {
+	if s.Version == 0 {
+		s.Version = 1
+	}
+	return nil
+}
+
+func (s Page) MarshalJSON() ([]byte, error) {
+	type TmpStruct Page
+	return jsonx.Marshal(TmpStruct(s))
+}
+
+type PageRevision struct {
 	ID              uint       `gorm:"primarykey" json:"id,omitempty"`
-	AuthorID        uint       `json:"authorId,omitempty"`
-	Author          User       `gorm:"foreignKey:AuthorID" json:"author,omitempty"`
-	DocumentationID uint       `gorm:"index:idx_doc_slug,unique:true,composite:true" json:"documentationId,omitempty"`
-	PageGroupID     *uint      `json:"pageGroupId,omitempty" gorm:"foreignKey:PageGroupID"`
+	PageID          uint       `gorm:"index" json:"pageId,omitempty"`
+	DocumentationID uint       `gorm:"index" json:"documentationId,omitempty"`
+	EditorID        uint       `json:"editorId,omitempty"`
+	Editor          User       `gorm:"foreignKey:EditorID" json:"editor,omitempty"`
 	Title           string     `json:"title,omitempty"`
-	Slug            string     `gorm:"index:idx_doc_slug,unique:true,composite:true" json:"slug,omitempty"`
+	Slug            string     `json:"slug,omitempty"`
 	Content         string     `json:"content,omitempty"`
+	RestoredFromID  *uint      `json:"restoredFromId,omitempty"`
 	CreatedAt       *time.Time `gorm:"autoCreateTime" json:"createdAt,omitempty"`
-	UpdatedAt       *time.Time `gorm:"autoUpdateTime" json:"updatedAt,omitempty"`
-	Order           *uint      `json:"order,omitempty"`
-	Editors         []User     `gorm:"many2many:page_editors;" json:"editors,omitempty"`
-	LastEditorID    *uint      `json:"lastEditorId,omitempty"`
-	IsIntroPage     bool       `json:"isIntroPage,omitempty" gorm:"default:false"`
-	IsPage          bool       `json:"isPage" gorm:"default:true"`
 }
 
-func (s Page) MarshalJSON() ([]byte, error) {
-	type TmpStruct Page
+func (s PageRevision) MarshalJSON() ([]byte, error) {
+	type TmpStruct PageRevision
 	return jsonx.Marshal(TmpStruct(s))
 }
 
-type PageGroup struct {
+type SearchDocument struct {
 	ID              uint       `gorm:"primarykey" json:"id,omitempty"`
-	DocumentationID uint       `json:"documentationId,omitempty"`
-	ParentID        *uint      `json:"parentId,omitempty"`
-	AuthorID        uint       `json:"authorId,omitempty"`
-	Author          User       `gorm:"foreignKey:AuthorID" json:"author,omitempty"`
-	Name            string     `json:"name,omitempty"`
-	CreatedAt       *time.Time `gorm:"autoCreateTime" json:"createdAt,omitempty"`
+	Kind            string     `gorm:"index:idx_search_ref,unique:true,composite:true" json:"kind,omitempty"`
+	RefID           uint       `gorm:"index:idx_search_ref,unique:true,composite:true" json:"refId,omitempty"`
+	DocumentationID uint       `gorm:"index" json:"documentationId,omitempty"`
+	AuthorID        uint       `gorm:"index" json:"authorId,omitempty"`
+	Title           string     `json:"title,omitempty"`
+	Slug            string     `json:"slug,omitempty"`
+	Body            string     `json:"body,omitempty"`
 	UpdatedAt       *time.Time `gorm:"autoUpdateTime" json:"updatedAt,omitempty"`
-	Order           *uint      `json:"order,omitempty"`
-	Editors         []User     `gorm:"many2many:pagegroup_editors;" json:"editors,omitempty"`
-	LastEditorID    *uint      `json:"lastEditorId,omitempty"`
-	Pages           []Page     `json:"pages,omitempty" gorm:"foreignKey:PageGroupID;constraint:OnDelete:CASCADE"`
-	IsPageGroup     bool       `json:"isPagGroup" gorm:"default:true"`
+}
+
+func (s SearchDocument) MarshalJSON() ([]byte, error) {
+	type TmpStruct SearchDocument
+	return jsonx.Marshal(TmpStruct(s))
+}
+
+type PageGroup struct {
+	ID              uint           `gorm:"primarykey" json:"id,omitempty"`
+	DocumentationID uint           `json:"documentationId,omitempty"`
+	ParentID        *uint          `json:"parentId,omitempty"`
+	AuthorID        uint           `json:"authorId,omitempty"`
+	Author          User           `gorm:"foreignKey:AuthorID" json:"author,omitempty"`
+	Name            string         `json:"name,omitempty"`
+	CreatedAt       *time.Time     `gorm:"autoCreateTime" json:"createdAt,omitempty"`
+	UpdatedAt       *time.Time     `gorm:"autoUpdateTime" json:"updatedAt,omitempty"`
+	Order           *uint          `json:"order,omitempty"`
+	Editors         []User         `gorm:"many2many:pagegroup_editors;" json:"editors,omitempty"`
+	LastEditorID    *uint          `json:"lastEditorId,omitempty"`
+	Pages           []Page         `json:"pages,omitempty" gorm:"foreignKey:PageGroupID;constraint:OnDelete:CASCADE"`
+	IsPageGroup     bool           `json:"isPagGroup" gorm:"default:true"`
+	PublishedName   string         `json:"publishedName,omitempty"`
+	PublishedAt     *time.Time     `gorm:"index" json:"publishedAt"`
+	PublishAt       *time.Time     `gorm:"index" json:"publishAt"`
+	UnpublishAt     *time.Time     `gorm:"index" json:"unpublishAt"`
+	DeletedAt       gorm.DeletedAt `gorm:"index" json:"-"`
+	TrashItemID     *uint          `gorm:"index" json:"-"`
 }
 
 func (s PageGroup) MarshalJSON() ([]byte, error) {
@@ -51,38 +113,46 @@ func (s PageGroup) MarshalJSON() ([]byte, error) {
 }
 
 type Documentation struct {
-	ID               uint        `gorm:"primarykey" json:"id,omitempty"`
-	Name             string      `gorm:"index:idx_name_root" json:"name,omitempty"`
-	Version          string      `json:"version,omitempty"`
-	URL              string      `json:"url,omitempty"`
-	OrganizationName string      `json:"organizationName,omitempty"`
-	ProjectName      string      `json:"projectName,omitempty"`
-	LanderDetails    string      `json:"landerDetails,omitempty"`
-	BaseURL          string      `json:"baseURL,omitempty"`
-	ClonedFrom       *uint       `gorm:"default:null" json:"clonedFrom"`
-	Description      string      `json:"description,omitempty"`
-	Favicon          string      `json:"favicon,omitempty"`
-	MetaImage        string      `json:"metaImage,omitempty"`
-	NavImage         string      `json:"navImage,omitempty"`
-	NavImageDark     string      `json:"navImageDark,omitempty"`
-	CustomCSS        string      `json:"customCSS,omitempty"`
-	FooterLabelLinks string      `json:"footerLabelLinks,omitempty"`
-	MoreLabelLinks   string      `json:"moreLabelLinks,omitempty"`
-	CopyrightText    string      `json:"copyrightText,omitempty"`
-	AuthorID         uint        `json:"authorId,omitempty"`
-	Author           User        `gorm:"foreignKey:AuthorID" json:"author,omitempty"`
-	CreatedAt        *time.Time  `gorm:"autoCreateTime" json:"createdAt,omitempty"`
-	UpdatedAt        *time.Time  `gorm:"autoUpdateTime" json:"updatedAt,omitempty"`
-	Editors          []User      `gorm:"many2many:documentation_editors;" json:"editors,omitempty"`
-	LastEditorID     *uint       `json:"lastEditorId,omitempty"`
-	PageGroups       []PageGroup `gorm:"foreignKey:DocumentationID;constraint:OnDelete:CASCADE" json:"pageGroups,omitempty"`
-	Pages            []Page      `gorm:"foreignKey:DocumentationID;constraint:OnDelete:CASCADE" json:"pages,omitempty"`
-	RequireAuth      bool        `json:"requireAuth" gorm:"default:false"`
-	GitRepo          string      `json:"gitRepo,omitempty"`
-	GitEmail         string      `json:"gitEmail,omitempty"`
-	GitUser          string      `json:"gitUser,omitempty"`
-	GitPassword      string      `json:"gitPassword,omitempty"`
-	GitBranch        string      `json:"gitBranch,omitempty"`
+	ID                uint           `gorm:"primarykey" json:"id,omitempty"`
+	Name              string         `gorm:"index:idx_name_root" json:"name,omitempty"`
+	Version           string         `json:"version,omitempty"`
+	URL               string         `json:"url,omitempty"`
+	OrganizationName  string         `json:"organizationName,omitempty"`
+	ProjectName       string         `json:"projectName,omitempty"`
+	LanderDetails     string         `json:"landerDetails,omitempty"`
+	BaseURL           string         `json:"baseURL,omitempty"`
+	ClonedFrom        *uint          `gorm:"default:null" json:"clonedFrom"`
+	Description       string         `json:"description,omitempty"`
+	Favicon           string         `json:"favicon,omitempty"`
+	MetaImage         string         `json:"metaImage,omitempty"`
+	NavImage          string         `json:"navImage,omitempty"`
+	NavImageDark      string         `json:"navImageDark,omitempty"`
+	CustomCSS         string         `json:"customCSS,omitempty"`
+	FooterLabelLinks  string         `json:"footerLabelLinks,omitempty"`
+	MoreLabelLinks    string         `json:"moreLabelLinks,omitempty"`
+	CopyrightText     string         `json:"copyrightText,omitempty"`
+	RobotsTxt         string         `json:"robotsTxt,omitempty"`
+	DefaultLanguage   string         `json:"defaultLanguage,omitempty" gorm:"default:en"`
+	Languages         string         `json:"languages,omitempty"`
+	AuthorID          uint           `json:"authorId,omitempty"`
+	Author            User           `gorm:"foreignKey:AuthorID" json:"author,omitempty"`
+	CreatedAt         *time.Time     `gorm:"autoCreateTime" json:"createdAt,omitempty"`
+	UpdatedAt         *time.Time     `gorm:"autoUpdateTime" json:"updatedAt,omitempty"`
+	Editors           []User         `gorm:"many2many:documentation_editors;" json:"editors,omitempty"`
+	LastEditorID      *uint          `json:"lastEditorId,omitempty"`
+	PageGroups        []PageGroup    `gorm:"foreignKey:DocumentationID;constraint:OnDelete:CASCADE" json:"pageGroups,omitempty"`
+	Pages             []Page         `gorm:"foreignKey:DocumentationID;constraint:OnDelete:CASCADE" json:"pages,omitempty"`
+	RequireAuth       bool           `json:"requireAuth" gorm:"default:false"`
+	GitRepo           string         `json:"gitRepo,omitempty"`
+	GitEmail          string         `json:"gitEmail,omitempty"`
+	GitUser           string         `json:"gitUser,omitempty"`
+	GitPassword       string         `json:"gitPassword,omitempty"`
+	GitBranch         string         `json:"gitBranch,omitempty"`
+	RequiredApprovals uint           `json:"requiredApprovals" gorm:"default:1"`
+	PublishAt         *time.Time     `gorm:"index" json:"publishAt"`
+	UnpublishAt       *time.Time     `gorm:"index" json:"unpublishAt"`
+	DeletedAt         gorm.DeletedAt `gorm:"index" json:"-"`
+	TrashItemID       *uint          `gorm:"index" json:"-"`
 }
 
 func (s Documentation) MarshalJSON() ([]byte, error) {
@@ -92,9 +162,21 @@ func (s Documentation) MarshalJSON() ([]byte, error) {
 
 type BuildTriggers struct {
 	ID              uint       `gorm:"primarykey" json:"id"`
-	DocumentationID uint       `json:"documentationId"`
-	Triggered       bool       `json:"triggered"`
+	DocumentationID uint       `gorm:"index" json:"documentationId"`
+	Triggered       bool       `gorm:"index" json:"triggered"`
 	IsDelete        bool       `json:"isDelete"`
+	Status          string     `gorm:"index" json:"status"`
+	Priority        int        `gorm:"default:0" json:"priority"`
+	Attempts        int        `gorm:"default:0" json:"attempts"`
+	NextAttemptAt   *time.Time `json:"nextAttemptAt"`
+	LeaseToken      string     `gorm:"index" json:"-"`
+	LeaseOwner      string     `json:"leaseOwner"`
+	LeaseExpiresAt  *time.Time `json:"leaseExpiresAt"`
+	StartedAt       *time.Time `json:"startedAt"`
+	CancelledAt     *time.Time `json:"cancelledAt"`
+	Error           string     `json:"error"`
+	DurationMs      int64      `json:"durationMs"`
+	CoalescedInto   *uint      `json:"coalescedInto"`
 	CreatedAt       *time.Time `gorm:"autoCreateTime" json:"createdAt"`
 	CompletedAt     *time.Time `json:"completedAt"`
 }
@@ -103,3 +185,38 @@ func (s BuildTriggers) MarshalJSON() ([]byte, error) {
 	type TmpStruct BuildTriggers
 	return jsonx.Marshal(TmpStruct(s))
 }
+
+type BuildStep struct {
+	ID         uint       `gorm:"primarykey" json:"id"`
+	TriggerID  uint       `gorm:"index" json:"triggerId"`
+	Attempt    int        `json:"attempt"`
+	Name       string     `json:"name"`
+	Status     string     `json:"status"`
+	Output     string     `json:"output"`
+	Error      string     `json:"error"`
+	StartedAt  *time.Time `json:"startedAt"`
+	FinishedAt *time.Time `json:"finishedAt"`
+	DurationMs int64      `json:"durationMs"`
+}
+
+func (s BuildStep) MarshalJSON() ([]byte, error) {
+	type TmpStruct BuildStep
+	return jsonx.Marshal(TmpStruct(s))
+}
+
+// DocumentationMember grants a user a role on a root documentation and, with
+// it, on every version cloned from it.
+type DocumentationMember struct {
+	ID              uint       `gorm:"primarykey" json:"id"`
+	DocumentationID uint       `gorm:"uniqueIndex:idx_documentation_member" json:"documentationId"`
+	UserID          uint       `gorm:"uniqueIndex:idx_documentation_member;index" json:"userId"`
+	User            User       `gorm:"foreignKey:UserID" json:"user,omitempty"`
+	Role            string     `json:"role"`
+	CreatedAt       *time.Time `gorm:"autoCreateTime" json:"createdAt"`
+	UpdatedAt       *time.Time `gorm:"autoUpdateTime" json:"updatedAt"`
+}
+
+func (s DocumentationMember) MarshalJSON() ([]byte, error) {
+	type TmpStruct DocumentationMember
+	return jsonx.Marshal(TmpStruct(s))
+}
@@ -0,0 +1,26 @@
+package models
+
+import (
+	"time"
+
+	jsonx "github.com/clarketm/json"
+)
+
+// EditLock is an advisory lock on a page or page group. The holder keeps it
+// alive with heartbeats, a lock past its expiry no longer counts.
+type EditLock struct {
+	ID              uint      `gorm:"primarykey" json:"id"`
+	Kind            string    `gorm:"index:idx_edit_lock_ref,unique:true,composite:true" json:"kind"`
+	RefID           uint      `gorm:"index:idx_edit_lock_ref,unique:true,composite:true" json:"refId"`
+	DocumentationID uint      `gorm:"index" json:"documentationId"`
+	HolderID        uint      `json:"holderId"`
+	Holder          User      `gorm:"foreignKey:HolderID" json:"holder,omitempty"`
+	AcquiredAt      time.Time `json:"acquiredAt"`
+	HeartbeatAt     time.Time `json:"heartbeatAt"`
+	ExpiresAt       time.Time `gorm:"index" json:"expiresAt"`
+}
+
+func (s EditLock) MarshalJSON() ([]byte, error) {
+	type TmpStruct EditLock
+	return jsonx.Marshal(TmpStruct(s))
+}
@@ -0,0 +1,41 @@
+package models
+
+import (
+	"time"
+
+	jsonx "github.com/clarketm/json"
+)
+
+// PagePath is where the last build put a page, relative to its
+// documentation version. Builds compare against it to notice pages moving.
+type PagePath struct {
+	ID              uint       `gorm:"primarykey" json:"id"`
+	PageID          uint       `gorm:"uniqueIndex" json:"pageId"`
+	DocumentationID uint       `gorm:"index" json:"documentationId"`
+	Path            string     `json:"path"`
+	UpdatedAt       *time.Time `gorm:"autoUpdateTime" json:"updatedAt"`
+}
+
+func (s PagePath) MarshalJSON() ([]byte, error) {
+	type TmpStruct PagePath
+	return jsonx.Marshal(TmpStruct(s))
+}
+
+// Redirect sends a path of a documentation version somewhere else. Automatic
+// redirects follow a page to wherever it is now, custom ones go to ToPath.
+type Redirect struct {
+	ID                  uint       `gorm:"primarykey" json:"id"`
+	DocumentationID     uint       `gorm:"index:idx_redirect_from,unique:true,composite:true" json:"documentationId"`
+	RootDocumentationID uint       `gorm:"index" json:"rootDocumentationId"`
+	FromPath            string     `gorm:"index:idx_redirect_from,unique:true,composite:true" json:"fromPath"`
+	ToPath              string     `json:"toPath"`
+	PageID              *uint      `gorm:"index" json:"pageId"`
+	Automatic           bool       `json:"automatic"`
+	CreatedAt           *time.Time `gorm:"autoCreateTime" json:"createdAt"`
+	UpdatedAt           *time.Time `gorm:"autoUpdateTime" json:"updatedAt"`
+}
+
+func (s Redirect) MarshalJSON() ([]byte, error) {
+	type TmpStruct Redirect
+	return jsonx.Marshal(TmpStruct(s))
+}
@@ -0,0 +1,66 @@
+package models
+
+import (
+	"time"
+
+	jsonx "github.com/clarketm/json"
+)
+
+// ChangeRequest proposes edits to the pages of a documentation. The changes
+// are applied once enough reviewers approve them.
+type ChangeRequest struct {
+	ID              uint                  `gorm:"primarykey" json:"id"`
+	DocumentationID uint                  `gorm:"index" json:"documentationId"`
+	AuthorID        uint                  `gorm:"index" json:"authorId"`
+	Author          User                  `gorm:"foreignKey:AuthorID" json:"author,omitempty"`
+	Title           string                `json:"title"`
+	Description     string                `json:"description"`
+	Status          string                `gorm:"index" json:"status"`
+	Changes         []ChangeRequestChange `gorm:"foreignKey:ChangeRequestID;constraint:OnDelete:CASCADE" json:"changes,omitempty"`
+	Reviews         []ChangeRequestReview `gorm:"foreignKey:ChangeRequestID;constraint:OnDelete:CASCADE" json:"reviews,omitempty"`
+	MergedByID      *uint                 `json:"mergedById"`
+	MergedAt        *time.Time            `json:"mergedAt"`
+	ClosedAt        *time.Time            `json:"closedAt"`
+	CreatedAt       *time.Time            `gorm:"autoCreateTime" json:"createdAt"`
+	UpdatedAt       *time.Time            `gorm:"autoUpdateTime" json:"updatedAt"`
+}
+
+func (s ChangeRequest) MarshalJSON() ([]byte, error) {
+	type TmpStruct ChangeRequest
+	return jsonx.Marshal(TmpStruct(s))
+}
+
+// ChangeRequestChange is the proposed state of one page. Without a PageID it
+// proposes a new page. BaseVersion is the version of the page the change was
+// proposed against, it only merges if the page is still at it.
+type ChangeRequestChange struct {
+	ID              uint       `gorm:"primarykey" json:"id"`
+	ChangeRequestID uint       `gorm:"index" json:"changeRequestId"`
+	PageID          *uint      `gorm:"index" json:"pageId"`
+	BaseVersion     uint       `json:"baseVersion,omitempty"`
+	PageGroupID     *uint      `json:"pageGroupId"`
+	Title           string     `json:"title"`
+	Slug            string     `json:"slug"`
+	Content         string     `json:"content,omitempty"`
+	CreatedAt       *time.Time `gorm:"autoCreateTime" json:"createdAt"`
+}
+
+func (s ChangeRequestChange) MarshalJSON() ([]byte, error) {
+	type TmpStruct ChangeRequestChange
+	return jsonx.Marshal(TmpStruct(s))
+}
+
+type ChangeRequestReview struct {
+	ID              uint       `gorm:"primarykey" json:"id"`
+	ChangeRequestID uint       `gorm:"index" json:"changeRequestId"`
+	ReviewerID      uint       `gorm:"index" json:"reviewerId"`
+	Reviewer        User       `gorm:"foreignKey:ReviewerID" json:"reviewer,omitempty"`
+	Verdict         string     `json:"verdict"`
+	Comment         string     `json:"comment"`
+	CreatedAt       *time.Time `gorm:"autoCreateTime" json:"createdAt"`
+}
+
+func (s ChangeRequestReview) MarshalJSON() ([]byte, error) {
+	type TmpStruct ChangeRequestReview
+	return jsonx.Marshal(TmpStruct(s))
+}
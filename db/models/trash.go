@@ -0,0 +1,32 @@
+package models
+
+import (
+	"time"
+
+	jsonx "github.com/clarketm/json"
+)
+
+// TrashItem is a deleted page, page group or documentation. Everything that
+// was deleted along with it points back at it through TrashItemID, so it can
+// be restored as it was until it's purged.
+type TrashItem struct {
+	ID                  uint      `gorm:"primarykey" json:"id"`
+	Kind                string    `gorm:"index" json:"kind"`
+	RefID               uint      `json:"refId"`
+	DocumentationID     uint      `gorm:"index" json:"documentationId"`
+	RootDocumentationID uint      `gorm:"index" json:"rootDocumentationId"`
+	Title               string    `json:"title"`
+	DeletedByID         *uint     `json:"deletedById"`
+	DeletedBy           User      `gorm:"foreignKey:DeletedByID" json:"deletedBy,omitempty"`
+	DeletedAt           time.Time `json:"deletedAt"`
+	PurgeAt             time.Time `gorm:"index" json:"purgeAt"`
+
+	// The versions cloned from a deleted documentation move up to the
+	// version it was cloned from, this is what they were cloned from before.
+	ReparentedVersions map[uint]uint `gorm:"serializer:json" json:"-"`
+}
+
+func (s TrashItem) MarshalJSON() ([]byte, error) {
+	type TmpStruct TrashItem
+	return jsonx.Marshal(TmpStruct(s))
+}
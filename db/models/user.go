@@ -37,3 +37,58 @@ func (s User) MarshalJSON() ([]byte, error) {
 	type TmpStruct User
 	return jsonx.Marshal(TmpStruct(s))
 }
+
+// APIToken is a long-lived token for automation. Only a hash of the token is
+// stored, the token itself is shown once when it is created.
+type APIToken struct {
+	ID             uint       `gorm:"primarykey" json:"id,omitempty"`
+	UserID         uint       `gorm:"index" json:"userId,omitempty"`
+	Name           string     `json:"name,omitempty"`
+	TokenHash      string     `gorm:"index:,unique" json:"-"`
+	Prefix         string     `json:"prefix,omitempty"`
+	Permissions    string     `json:"permissions,omitempty"`
+	Documentations string     `json:"documentations,omitempty"`
+	ExpiresAt      *time.Time `json:"expiresAt,omitempty"`
+	LastUsedAt     *time.Time `json:"lastUsedAt,omitempty"`
+	RevokedAt      *time.Time `json:"revokedAt,omitempty"`
+	CreatedAt      *time.Time `gorm:"autoCreateTime" json:"createdAt,omitempty"`
+}
+
+func (s APIToken) MarshalJSON() ([]byte, error) {
+	type TmpStruct APIToken
+	return jsonx.Marshal(TmpStruct(s))
+}
+
+// Group bundles users so permissions and documentation roles can be granted
+// to all of them at once.
+type Group struct {
+	ID          uint                      `gorm:"primarykey" json:"id,omitempty"`
+	Name        string                    `gorm:"unique" json:"name,omitempty"`
+	Description string                    `json:"description,omitempty"`
+	Permissions string                    `json:"permissions,omitempty"`
+	Users       []User                    `gorm:"many2many:group_users;" json:"users,omitempty"`
+	Grants      []DocumentationGroupGrant `gorm:"foreignKey:GroupID" json:"grants,omitempty"`
+	CreatedAt   *time.Time                `gorm:"autoCreateTime" json:"createdAt,omitempty"`
+	UpdatedAt   *time.Time                `gorm:"autoUpdateTime" json:"updatedAt,omitempty"`
+}
+
+func (s Group) MarshalJSON() ([]byte, error) {
+	type TmpStruct Group
+	return jsonx.Marshal(TmpStruct(s))
+}
+
+// DocumentationGroupGrant gives every member of a group a role on a root
+// documentation and its versions.
+type DocumentationGroupGrant struct {
+	ID              uint       `gorm:"primarykey" json:"id"`
+	DocumentationID uint       `gorm:"uniqueIndex:idx_documentation_group_grant" json:"documentationId"`
+	GroupID         uint       `gorm:"uniqueIndex:idx_documentation_group_grant;index" json:"groupId"`
+	Role            string     `json:"role"`
+	CreatedAt       *time.Time `gorm:"autoCreateTime" json:"createdAt"`
+	UpdatedAt       *time.Time `gorm:"autoUpdateTime" json:"updatedAt"`
+}
+
+func (s DocumentationGroupGrant) MarshalJSON() ([]byte, error) {
+	type TmpStruct DocumentationGroupGrant
+	return jsonx.Marshal(TmpStruct(s))
+}
@@ -0,0 +1,51 @@
+package models
+
+import (
+	"time"
+
+	jsonx "github.com/clarketm/json"
+)
+
+type Webhook struct {
+	ID              uint       `gorm:"primarykey" json:"id,omitempty"`
+	DocumentationID *uint      `gorm:"index" json:"documentationId"`
+	URL             string     `json:"url,omitempty"`
+	Secret          string     `json:"-"`
+	SecretSet       bool       `gorm:"-" json:"secretSet"`
+	Events          string     `json:"events,omitempty"`
+	Description     string     `json:"description,omitempty"`
+	Active          bool       `json:"active" gorm:"default:true"`
+	AuthorID        uint       `json:"authorId,omitempty"`
+	CreatedAt       *time.Time `gorm:"autoCreateTime" json:"createdAt,omitempty"`
+	UpdatedAt       *time.Time `gorm:"autoUpdateTime" json:"updatedAt,omitempty"`
+}
+
+// The secret is only ever shown once, when the webhook is created. After that
+// clients only get to know whether there is one.
+func (s Webhook) MarshalJSON() ([]byte, error) {
+	type TmpStruct Webhook
+	s.SecretSet = s.Secret != ""
+	return jsonx.Marshal(TmpStruct(s))
+}
+
+type WebhookDelivery struct {
+	ID              uint       `gorm:"primarykey" json:"id"`
+	WebhookID       uint       `gorm:"index" json:"webhookId"`
+	DocumentationID uint       `gorm:"index" json:"documentationId"`
+	Event           string     `gorm:"index" json:"event"`
+	Payload         string     `json:"payload"`
+	Status          string     `gorm:"index" json:"status"`
+	Attempts        int        `gorm:"default:0" json:"attempts"`
+	NextAttemptAt   *time.Time `gorm:"index" json:"nextAttemptAt"`
+	ResponseCode    int        `json:"responseCode"`
+	ResponseBody    string     `json:"responseBody"`
+	Error           string     `json:"error"`
+	DurationMs      int64      `json:"durationMs"`
+	CreatedAt       *time.Time `gorm:"autoCreateTime" json:"createdAt"`
+	DeliveredAt     *time.Time `json:"deliveredAt"`
+}
+
+func (s WebhookDelivery) MarshalJSON() ([]byte, error) {
+	type TmpStruct WebhookDelivery
+	return jsonx.Marshal(TmpStruct(s))
+}
@@ -0,0 +1,56 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// setupSearchIndex creates the dialect specific full-text index on top of the
+// search_documents table. SQLite gets an external content FTS5 table kept in
+// sync by triggers, Postgres gets a generated tsvector column with a GIN index.
+func setupSearchIndex(db *gorm.DB) error {
+	var statements []string
+	dialectName := strings.ToLower(db.Dialector.Name())
+
+	if dialectName == "postgres" {
+		statements = []string{
+			`ALTER TABLE search_documents ADD COLUMN IF NOT EXISTS search_vector tsvector
+				GENERATED ALWAYS AS (
+					setweight(to_tsvector('simple', coalesce(title, '')), 'A') ||
+					setweight(to_tsvector('simple', replace(coalesce(slug, ''), '-', ' ')), 'B') ||
+					setweight(to_tsvector('simple', coalesce(body, '')), 'C')
+				) STORED`,
+			`CREATE INDEX IF NOT EXISTS idx_search_documents_vector ON search_documents USING GIN (search_vector)`,
+		}
+	} else if dialectName == "sqlite" {
+		statements = []string{
+			`CREATE VIRTUAL TABLE IF NOT EXISTS search_documents_fts USING fts5(
+				title, slug, body,
+				content='search_documents', content_rowid='id',
+				tokenize='unicode61 remove_diacritics 2'
+			)`,
+			`CREATE TRIGGER IF NOT EXISTS search_documents_ai AFTER INSERT ON search_documents BEGIN
+				INSERT INTO search_documents_fts(rowid, title, slug, body) VALUES (new.id, new.title, new.slug, new.body);
+			END`,
+			`CREATE TRIGGER IF NOT EXISTS search_documents_ad AFTER DELETE ON search_documents BEGIN
+				INSERT INTO search_documents_fts(search_documents_fts, rowid, title, slug, body) VALUES ('delete', old.id, old.title, old.slug, old.body);
+			END`,
+			`CREATE TRIGGER IF NOT EXISTS search_documents_au AFTER UPDATE ON search_documents BEGIN
+				INSERT INTO search_documents_fts(search_documents_fts, rowid, title, slug, body) VALUES ('delete', old.id, old.title, old.slug, old.body);
+				INSERT INTO search_documents_fts(rowid, title, slug, body) VALUES (new.id, new.title, new.slug, new.body);
+			END`,
+		}
+	} else {
+		return fmt.Errorf("unsupported database dialect: %s", dialectName)
+	}
+
+	for _, statement := range statements {
+		if err := db.Exec(statement).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"git.difuse.io/Difuse/kalmia/logger"
+	"git.difuse.io/Difuse/kalmia/services"
+	"git.difuse.io/Difuse/kalmia/utils"
+	"go.uber.org/zap"
+)
+
+func auditActor(r *http.Request) services.AuditActor {
+	return services.AuditActorFromRequest(r)
+}
+
+// auditLogOptions reads the audit log filters from the query string. Times
+// are RFC 3339.
+func auditLogOptions(query url.Values) (services.AuditLogOptions, error) {
+	opts := services.AuditLogOptions{
+		Action:     query.Get("action"),
+		EntityType: query.Get("entityType"),
+	}
+
+	uints := map[string]*uint{"actorId": &opts.ActorID, "entityId": &opts.EntityID}
+	for key, target := range uints {
+		if value := query.Get(key); value != "" {
+			parsed, err := utils.StringToUint(value)
+			if err != nil {
+				return opts, fmt.Errorf("invalid_%s", key)
+			}
+			*target = parsed
+		}
+	}
+
+	ints := map[string]*int{"limit": &opts.Limit, "offset": &opts.Offset}
+	for key, target := range ints {
+		if value := query.Get(key); value != "" {
+			parsed, err := utils.StringToUint(value)
+			if err != nil {
+				return opts, fmt.Errorf("invalid_%s", key)
+			}
+			*target = int(parsed)
+		}
+	}
+
+	times := map[string]**time.Time{"since": &opts.Since, "until": &opts.Until}
+	for key, target := range times {
+		if value := query.Get(key); value != "" {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return opts, fmt.Errorf("invalid_%s", key)
+			}
+			*target = &parsed
+		}
+	}
+
+	return opts, nil
+}
+
+func GetAuditLogs(authService *services.AuthService, w http.ResponseWriter, r *http.Request) {
+	opts, err := auditLogOptions(r.URL.Query())
+	if err != nil {
+		SendJSONResponse(http.StatusBadRequest, w, map[string]string{"status": "error", "message": err.Error()})
+		return
+	}
+
+	entries, total, err := authService.GetAuditLogs(opts)
+	if err != nil {
+		SendJSONResponse(http.StatusInternalServerError, w, map[string]string{"status": "error", "message": err.Error()})
+		return
+	}
+
+	SendJSONResponse(http.StatusOK, w, map[string]interface{}{"total": total, "entries": entries})
+}
+
+func ExportAuditLogs(authService *services.AuthService, w http.ResponseWriter, r *http.Request) {
+	opts, err := auditLogOptions(r.URL.Query())
+	if err != nil {
+		SendJSONResponse(http.StatusBadRequest, w, map[string]string{"status": "error", "message": err.Error()})
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "csv"
+	}
+
+	contentType := "text/csv"
+	switch format {
+	case "csv":
+	case "jsonl":
+		contentType = "application/x-ndjson"
+	default:
+		SendJSONResponse(http.StatusBadRequest, w, map[string]string{"status": "error", "message": "invalid_format"})
+		return
+	}
+
+	filename := fmt.Sprintf("audit-%s.%s", time.Now().UTC().Format("20060102-150405"), format)
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+	// The body is streamed, so a failure half way can only be logged.
+	if err := authService.ExportAuditLogs(w, format, opts); err != nil {
+		logger.Error("Failed to export audit logs", zap.Error(err))
+	}
+}
@@ -8,8 +8,10 @@ import (
 	"io"
 	"net/http"
 	"strings"
+	"time"
 
 	"git.difuse.io/Difuse/kalmia/config"
+	"git.difuse.io/Difuse/kalmia/db/models"
 	"git.difuse.io/Difuse/kalmia/services"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/github"
@@ -28,6 +30,8 @@ var (
 )
 
 func CreateUser(authService *services.AuthService, w http.ResponseWriter, r *http.Request) {
+	authService = authService.WithActor(auditActor(r))
+
 	type Request struct {
 		Username    string   `json:"username" validate:"required,alphanum"`
 		Email       string   `json:"email" validate:"required,email"`
@@ -51,6 +55,8 @@ func CreateUser(authService *services.AuthService, w http.ResponseWriter, r *htt
 }
 
 func EditUser(authService *services.AuthService, w http.ResponseWriter, r *http.Request) {
+	authService = authService.WithActor(auditActor(r))
+
 	type Request struct {
 		ID          uint     `json:"id" validate:"required"`
 		Username    string   `json:"username" validate:"omitempty,alphanum"`
@@ -76,6 +82,8 @@ func EditUser(authService *services.AuthService, w http.ResponseWriter, r *http.
 }
 
 func DeleteUser(authService *services.AuthService, w http.ResponseWriter, r *http.Request) {
+	authService = authService.WithActor(auditActor(r))
+
 	type Request struct {
 		Username string `json:"username" validate:"required"`
 	}
@@ -227,6 +235,8 @@ func UploadAssetsFile(db *gorm.DB, w http.ResponseWriter, r *http.Request, cfg *
 }
 
 func CreateJWT(authService *services.AuthService, w http.ResponseWriter, r *http.Request) {
+	authService = authService.WithActor(auditActor(r))
+
 	type Request struct {
 		Username string `json:"username"`
 		Password string `json:"password"`
@@ -283,6 +293,8 @@ func ValidateJWT(authService *services.AuthService, w http.ResponseWriter, r *ht
 }
 
 func RevokeJWT(authService *services.AuthService, w http.ResponseWriter, r *http.Request) {
+	authService = authService.WithActor(auditActor(r))
+
 	token, err := GetTokenFromHeader(r)
 	if err != nil {
 		SendJSONResponse(http.StatusUnauthorized, w, map[string]string{"status": "error", "message": "invalid_request"})
@@ -325,6 +337,8 @@ func GithubLogin(aS *services.AuthService, w http.ResponseWriter, r *http.Reques
 }
 
 func GithubCallback(aS *services.AuthService, w http.ResponseWriter, r *http.Request) {
+	aS = aS.WithActor(auditActor(r))
+
 	if config.ParsedConfig.GithubOAuth.ClientID == "" || config.ParsedConfig.GithubOAuth.ClientSecret == "" {
 		http.Error(w, "Github OAuth not configured", http.StatusInternalServerError)
 		return
@@ -405,6 +419,8 @@ func MicrosoftLogin(aS *services.AuthService, w http.ResponseWriter, r *http.Req
 }
 
 func MicrosoftCallback(aS *services.AuthService, w http.ResponseWriter, r *http.Request) {
+	aS = aS.WithActor(auditActor(r))
+
 	if config.ParsedConfig.MicrosoftOAuth.ClientID == "" || config.ParsedConfig.MicrosoftOAuth.ClientSecret == "" {
 		http.Error(w, "Microsoft OAuth not configured", http.StatusInternalServerError)
 		return
@@ -484,6 +500,8 @@ func GoogleLogin(aS *services.AuthService, w http.ResponseWriter, r *http.Reques
 }
 
 func GoogleCallback(aS *services.AuthService, w http.ResponseWriter, r *http.Request) {
+	aS = aS.WithActor(auditActor(r))
+
 	if config.ParsedConfig.GoogleOAuth.ClientID == "" || config.ParsedConfig.GoogleOAuth.ClientSecret == "" {
 		http.Error(w, "Google OAuth not configured", http.StatusInternalServerError)
 		return
@@ -549,3 +567,113 @@ func GetOAuthProviders(aS *services.AuthService, w http.ResponseWriter, r *http.
 	providers := aS.OAuthProviders()
 	SendJSONResponse(http.StatusOK, w, providers)
 }
+
+// apiTokenOwner returns the user behind a session token. API tokens can't
+// manage API tokens, or a scoped token could mint itself a wider one.
+func apiTokenOwner(authService *services.AuthService, w http.ResponseWriter, r *http.Request) (models.User, bool) {
+	token, err := GetTokenFromHeader(r)
+	if err != nil {
+		SendJSONResponse(http.StatusUnauthorized, w, map[string]string{"status": "error", "message": "invalid_request"})
+		return models.User{}, false
+	}
+
+	if services.IsAPIToken(token) {
+		SendJSONResponse(http.StatusForbidden, w, map[string]string{"status": "error", "message": "session_token_required"})
+		return models.User{}, false
+	}
+
+	user, err := authService.GetUserFromToken(token)
+	if err != nil {
+		SendJSONResponse(http.StatusUnauthorized, w, map[string]string{"status": "error", "message": "invalid_request"})
+		return models.User{}, false
+	}
+
+	return user, true
+}
+
+func GetAPITokens(authService *services.AuthService, w http.ResponseWriter, r *http.Request) {
+	user, ok := apiTokenOwner(authService, w, r)
+	if !ok {
+		return
+	}
+
+	tokens, err := authService.GetAPITokens(user.ID)
+	if err != nil {
+		SendJSONResponse(http.StatusInternalServerError, w, map[string]string{"status": "error", "message": err.Error()})
+		return
+	}
+
+	SendJSONResponse(http.StatusOK, w, tokens)
+}
+
+func CreateAPIToken(authService *services.AuthService, w http.ResponseWriter, r *http.Request) {
+	authService = authService.WithActor(auditActor(r))
+
+	type Request struct {
+		Name             string     `json:"name" validate:"required"`
+		Permissions      []string   `json:"permissions" validate:"required"`
+		DocumentationIDs []uint     `json:"documentationIds"`
+		ExpiresAt        *time.Time `json:"expiresAt"`
+	}
+
+	req, err := ValidateRequest[Request](w, r)
+	if err != nil {
+		return
+	}
+
+	user, ok := apiTokenOwner(authService, w, r)
+	if !ok {
+		return
+	}
+
+	token, apiToken, err := authService.CreateAPIToken(user, req.Name, req.Permissions, req.DocumentationIDs, req.ExpiresAt)
+	if err != nil {
+		SendJSONResponse(http.StatusBadRequest, w, map[string]string{"status": "error", "message": err.Error()})
+		return
+	}
+
+	SendJSONResponse(http.StatusOK, w, map[string]interface{}{"status": "success", "message": "token_created", "token": token, "details": apiToken})
+}
+
+func RevokeAPIToken(authService *services.AuthService, w http.ResponseWriter, r *http.Request) {
+	authService = authService.WithActor(auditActor(r))
+
+	type Request struct {
+		ID uint `json:"id" validate:"required"`
+	}
+
+	req, err := ValidateRequest[Request](w, r)
+	if err != nil {
+		return
+	}
+
+	user, ok := apiTokenOwner(authService, w, r)
+	if !ok {
+		return
+	}
+
+	if err := authService.RevokeAPIToken(user, req.ID); err != nil {
+		SendJSONResponse(http.StatusBadRequest, w, map[string]string{"status": "error", "message": err.Error()})
+		return
+	}
+
+	SendJSONResponse(http.StatusOK, w, map[string]string{"status": "success", "message": "token_revoked"})
+}
+
+// CreateStreamTicket swaps the request's token for a single-use ticket the
+// client passes as ?ticket= to open an event stream or websocket.
+func CreateStreamTicket(authService *services.AuthService, w http.ResponseWriter, r *http.Request) {
+	token, err := GetTokenFromHeader(r)
+	if err != nil {
+		SendJSONResponse(http.StatusUnauthorized, w, map[string]string{"status": "error", "message": "invalid_request"})
+		return
+	}
+
+	ticket, expiresAt, err := authService.CreateStreamTicket(token)
+	if err != nil {
+		SendJSONResponse(http.StatusUnauthorized, w, map[string]string{"status": "error", "message": err.Error()})
+		return
+	}
+
+	SendJSONResponse(http.StatusOK, w, map[string]interface{}{"status": "success", "ticket": ticket, "expiresAt": expiresAt})
+}
@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"net/http"
+
+	"git.difuse.io/Difuse/kalmia/db/models"
+	"git.difuse.io/Difuse/kalmia/services"
+)
+
+func sendChangeRequestError(w http.ResponseWriter, err error) {
+	switch err.Error() {
+	case "change_request_not_found", "documentation_not_found", "page_not_found", "page_group_not_found":
+		SendJSONResponse(http.StatusNotFound, w, map[string]string{"status": "error", "message": err.Error()})
+	case "change_request_not_open", "slug_already_in_use":
+		SendJSONResponse(http.StatusConflict, w, map[string]string{"status": "error", "message": err.Error()})
+	case "cannot_review_own_change_request", "insufficient_documentation_role":
+		SendJSONResponse(http.StatusForbidden, w, map[string]string{"status": "error", "message": err.Error()})
+	case "no_changes", "invalid_change", "invalid_verdict", "comment_required", "invalid_required_approvals":
+		SendJSONResponse(http.StatusBadRequest, w, map[string]string{"status": "error", "message": err.Error()})
+	default:
+		SendJSONResponse(http.StatusInternalServerError, w, map[string]string{"status": "error", "message": err.Error()})
+	}
+}
+
+func GetChangeRequests(dS *services.DocService, w http.ResponseWriter, r *http.Request) {
+	type Request struct {
+		DocumentationID uint   `json:"documentationId" validate:"required"`
+		Status          string `json:"status" validate:"omitempty,oneof=open merged rejected closed"`
+	}
+
+	req, err := ValidateRequest[Request](w, r)
+	if err != nil {
+		return
+	}
+
+	requests, err := dS.GetChangeRequests(req.DocumentationID, req.Status)
+	if err != nil {
+		sendChangeRequestError(w, err)
+		return
+	}
+
+	SendJSONResponse(http.StatusOK, w, requests)
+}
+
+func GetChangeRequest(dS *services.DocService, w http.ResponseWriter, r *http.Request) {
+	type Request struct {
+		ID uint `json:"id" validate:"required"`
+	}
+
+	req, err := ValidateRequest[Request](w, r)
+	if err != nil {
+		return
+	}
+
+	request, err := dS.GetChangeRequest(req.ID)
+	if err != nil {
+		sendChangeRequestError(w, err)
+		return
+	}
+
+	SendJSONResponse(http.StatusOK, w, request)
+}
+
+func CreateChangeRequest(services *services.ServiceRegistry, w http.ResponseWriter, r *http.Request) {
+	services = services.WithActor(auditActor(r))
+
+	type Change struct {
+		PageID      *uint  `json:"pageId"`
+		PageGroupID *uint  `json:"pageGroupId"`
+		Title       string `json:"title" validate:"required"`
+		Slug        string `json:"slug" validate:"required"`
+		Content     string `json:"content"`
+	}
+
+	type Request struct {
+		DocumentationID uint     `json:"documentationId" validate:"required"`
+		Title           string   `json:"title" validate:"required"`
+		Description     string   `json:"description"`
+		Changes         []Change `json:"changes" validate:"required,min=1,dive"`
+	}
+
+	req, err := ValidateRequest[Request](w, r)
+	if err != nil {
+		return
+	}
+
+	user, ok := requestUser(services.AuthService, w, r)
+	if !ok {
+		return
+	}
+
+	changes := make([]models.ChangeRequestChange, 0, len(req.Changes))
+	for _, change := range req.Changes {
+		changes = append(changes, models.ChangeRequestChange{
+			PageID:      change.PageID,
+			PageGroupID: change.PageGroupID,
+			Title:       change.Title,
+			Slug:        change.Slug,
+			Content:     change.Content,
+		})
+	}
+
+	request, err := services.DocService.CreateChangeRequest(user, req.DocumentationID, req.Title, req.Description, changes)
+	if err != nil {
+		sendChangeRequestError(w, err)
+		return
+	}
+
+	SendJSONResponse(http.StatusOK, w, map[string]interface{}{"status": "success", "message": "change_request_created", "id": request.ID})
+}
+
+func ReviewChangeRequest(services *services.ServiceRegistry, w http.ResponseWriter, r *http.Request) {
+	services = services.WithActor(auditActor(r))
+
+	type Request struct {
+		ID      uint   `json:"id" validate:"required"`
+		Verdict string `json:"verdict" validate:"required,oneof=comment approve reject"`
+		Comment string `json:"comment"`
+	}
+
+	req, err := ValidateRequest[Request](w, r)
+	if err != nil {
+		return
+	}
+
+	user, ok := requestUser(services.AuthService, w, r)
+	if !ok {
+		return
+	}
+
+	request, err := services.DocService.ReviewChangeRequest(user, req.ID, req.Verdict, req.Comment)
+	if err != nil {
+		sendChangeRequestError(w, err)
+		return
+	}
+
+	SendJSONResponse(http.StatusOK, w, request)
+}
+
+func CloseChangeRequest(services *services.ServiceRegistry, w http.ResponseWriter, r *http.Request) {
+	services = services.WithActor(auditActor(r))
+
+	type Request struct {
+		ID uint `json:"id" validate:"required"`
+	}
+
+	req, err := ValidateRequest[Request](w, r)
+	if err != nil {
+		return
+	}
+
+	user, ok := requestUser(services.AuthService, w, r)
+	if !ok {
+		return
+	}
+
+	if err := services.DocService.CloseChangeRequest(user, req.ID); err != nil {
+		sendChangeRequestError(w, err)
+		return
+	}
+
+	SendJSONResponse(http.StatusOK, w, map[string]string{"status": "success", "message": "change_request_closed"})
+}
+
+func SetRequiredApprovals(services *services.ServiceRegistry, w http.ResponseWriter, r *http.Request) {
+	services = services.WithActor(auditActor(r))
+
+	type Request struct {
+		DocumentationID   uint `json:"documentationId" validate:"required"`
+		RequiredApprovals uint `json:"requiredApprovals" validate:"required,min=1,max=10"`
+	}
+
+	req, err := ValidateRequest[Request](w, r)
+	if err != nil {
+		return
+	}
+
+	user, ok := requestUser(services.AuthService, w, r)
+	if !ok {
+		return
+	}
+
+	if err := services.DocService.SetRequiredApprovals(user, req.DocumentationID, req.RequiredApprovals); err != nil {
+		sendChangeRequestError(w, err)
+		return
+	}
+
+	SendJSONResponse(http.StatusOK, w, map[string]string{"status": "success", "message": "required_approvals_updated"})
+}
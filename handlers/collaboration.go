@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"git.difuse.io/Difuse/kalmia/services"
+	"git.difuse.io/Difuse/kalmia/utils"
+	"golang.org/x/net/websocket"
+)
+
+const collaborationWriteTimeout = 10 * time.Second
+
+type websocketConn struct {
+	ws *websocket.Conn
+}
+
+func (conn websocketConn) Send(message services.CollaborationMessage) error {
+	if err := conn.ws.SetWriteDeadline(time.Now().Add(collaborationWriteTimeout)); err != nil {
+		return err
+	}
+
+	return websocket.JSON.Send(conn.ws, message)
+}
+
+func (conn websocketConn) Close() error {
+	return conn.ws.Close()
+}
+
+// Collaborate upgrades the request to a websocket on which the user edits a
+// page together with everyone else who has it open.
+func Collaborate(hub *services.CollaborationHub, service *services.ServiceRegistry, w http.ResponseWriter, r *http.Request) {
+	pageId, err := utils.StringToUint(r.URL.Query().Get("pageId"))
+	if err != nil {
+		SendJSONResponse(http.StatusBadRequest, w, map[string]string{"status": "error", "message": "invalid_page_id"})
+		return
+	}
+
+	user, ok := requestUser(service.AuthService, w, r)
+	if !ok {
+		return
+	}
+
+	handler := websocket.Handler(func(ws *websocket.Conn) {
+		defer ws.Close()
+		ws.MaxPayloadBytes = 4 << 20
+
+		conn := websocketConn{ws: ws}
+
+		session, err := hub.Join(pageId, user, conn)
+		if err != nil {
+			conn.Send(services.CollaborationMessage{Type: services.CollaborationError, Error: err.Error()})
+			return
+		}
+		defer session.Leave()
+
+		for {
+			var message services.CollaborationMessage
+			if err := websocket.JSON.Receive(ws, &message); err != nil {
+				return
+			}
+
+			session.Handle(message)
+		}
+	})
+
+	handler.ServeHTTP(w, r)
+}
@@ -0,0 +1,203 @@
+package handlers
+
+import (
+	"net/http"
+
+	"git.difuse.io/Difuse/kalmia/services"
+)
+
+func sendCommentError(w http.ResponseWriter, err error) {
+	switch err.Error() {
+	case "comment_thread_not_found", "page_not_found", "block_not_found":
+		SendJSONResponse(http.StatusNotFound, w, map[string]string{"status": "error", "message": err.Error()})
+	case "comment_thread_already_resolved", "comment_thread_not_resolved":
+		SendJSONResponse(http.StatusConflict, w, map[string]string{"status": "error", "message": err.Error()})
+	case "insufficient_documentation_role":
+		SendJSONResponse(http.StatusForbidden, w, map[string]string{"status": "error", "message": err.Error()})
+	case "comment_required":
+		SendJSONResponse(http.StatusBadRequest, w, map[string]string{"status": "error", "message": err.Error()})
+	default:
+		SendJSONResponse(http.StatusInternalServerError, w, map[string]string{"status": "error", "message": err.Error()})
+	}
+}
+
+func GetPageCommentThreads(dS *services.DocService, w http.ResponseWriter, r *http.Request) {
+	type Request struct {
+		PageID          uint `json:"pageId" validate:"required"`
+		IncludeResolved bool `json:"includeResolved"`
+	}
+
+	req, err := ValidateRequest[Request](w, r)
+	if err != nil {
+		return
+	}
+
+	threads, err := dS.GetPageCommentThreads(req.PageID, req.IncludeResolved)
+	if err != nil {
+		sendCommentError(w, err)
+		return
+	}
+
+	SendJSONResponse(http.StatusOK, w, threads)
+}
+
+func GetOpenCommentThreads(dS *services.DocService, w http.ResponseWriter, r *http.Request) {
+	type Request struct {
+		DocumentationID uint `json:"documentationId" validate:"required"`
+	}
+
+	req, err := ValidateRequest[Request](w, r)
+	if err != nil {
+		return
+	}
+
+	threads, err := dS.GetOpenCommentThreads(req.DocumentationID)
+	if err != nil {
+		sendCommentError(w, err)
+		return
+	}
+
+	SendJSONResponse(http.StatusOK, w, threads)
+}
+
+func GetMentionedCommentThreads(services *services.ServiceRegistry, w http.ResponseWriter, r *http.Request) {
+	user, ok := requestUser(services.AuthService, w, r)
+	if !ok {
+		return
+	}
+
+	visible, ok := visibleDocumentations(services.AuthService, w, r)
+	if !ok {
+		return
+	}
+
+	threads, err := services.DocService.GetMentionedCommentThreads(user.ID)
+	if err != nil {
+		sendCommentError(w, err)
+		return
+	}
+
+	if visible != nil {
+		filtered := threads[:0]
+		for _, thread := range threads {
+			if visible[thread.DocumentationID] {
+				filtered = append(filtered, thread)
+			}
+		}
+		threads = filtered
+	}
+
+	SendJSONResponse(http.StatusOK, w, threads)
+}
+
+func GetCommentThread(dS *services.DocService, w http.ResponseWriter, r *http.Request) {
+	type Request struct {
+		ID uint `json:"id" validate:"required"`
+	}
+
+	req, err := ValidateRequest[Request](w, r)
+	if err != nil {
+		return
+	}
+
+	thread, err := dS.GetCommentThread(req.ID)
+	if err != nil {
+		sendCommentError(w, err)
+		return
+	}
+
+	SendJSONResponse(http.StatusOK, w, thread)
+}
+
+func CreateCommentThread(services *services.ServiceRegistry, w http.ResponseWriter, r *http.Request) {
+	services = services.WithActor(auditActor(r))
+
+	type Request struct {
+		PageID  uint   `json:"pageId" validate:"required"`
+		BlockID string `json:"blockId"`
+		Body    string `json:"body" validate:"required"`
+	}
+
+	req, err := ValidateRequest[Request](w, r)
+	if err != nil {
+		return
+	}
+
+	user, ok := requestUser(services.AuthService, w, r)
+	if !ok {
+		return
+	}
+
+	thread, err := services.DocService.CreateCommentThread(user, req.PageID, req.BlockID, req.Body)
+	if err != nil {
+		sendCommentError(w, err)
+		return
+	}
+
+	SendJSONResponse(http.StatusOK, w, thread)
+}
+
+func ReplyToCommentThread(services *services.ServiceRegistry, w http.ResponseWriter, r *http.Request) {
+	services = services.WithActor(auditActor(r))
+
+	type Request struct {
+		ThreadID uint   `json:"threadId" validate:"required"`
+		Body     string `json:"body" validate:"required"`
+	}
+
+	req, err := ValidateRequest[Request](w, r)
+	if err != nil {
+		return
+	}
+
+	user, ok := requestUser(services.AuthService, w, r)
+	if !ok {
+		return
+	}
+
+	comment, err := services.DocService.ReplyToCommentThread(user, req.ThreadID, req.Body)
+	if err != nil {
+		sendCommentError(w, err)
+		return
+	}
+
+	SendJSONResponse(http.StatusOK, w, comment)
+}
+
+func setCommentThreadResolved(services *services.ServiceRegistry, w http.ResponseWriter, r *http.Request, resolved bool) {
+	services = services.WithActor(auditActor(r))
+
+	type Request struct {
+		ID uint `json:"id" validate:"required"`
+	}
+
+	req, err := ValidateRequest[Request](w, r)
+	if err != nil {
+		return
+	}
+
+	user, ok := requestUser(services.AuthService, w, r)
+	if !ok {
+		return
+	}
+
+	if err := services.DocService.SetCommentThreadResolved(user, req.ID, resolved); err != nil {
+		sendCommentError(w, err)
+		return
+	}
+
+	message := "comment_thread_resolved"
+	if !resolved {
+		message = "comment_thread_reopened"
+	}
+
+	SendJSONResponse(http.StatusOK, w, map[string]string{"status": "success", "message": message})
+}
+
+func ResolveCommentThread(services *services.ServiceRegistry, w http.ResponseWriter, r *http.Request) {
+	setCommentThreadResolved(services, w, r, true)
+}
+
+func UnresolveCommentThread(services *services.ServiceRegistry, w http.ResponseWriter, r *http.Request) {
+	setCommentThreadResolved(services, w, r, false)
+}
@@ -13,8 +13,13 @@ import (
 	"git.difuse.io/Difuse/kalmia/services"
 )
 
-func GetDocumentations(service *services.DocService, w http.ResponseWriter, r *http.Request) {
-	docs, err := service.GetDocumentations()
+func GetDocumentations(services *services.ServiceRegistry, w http.ResponseWriter, r *http.Request) {
+	visible, ok := visibleDocumentations(services.AuthService, w, r)
+	if !ok {
+		return
+	}
+
+	docs, err := services.DocService.GetDocumentations()
 	if err != nil {
 		SendJSONResponse(http.StatusInternalServerError, w, map[string]string{
 			"status":  "error",
@@ -23,6 +28,16 @@ func GetDocumentations(service *services.DocService, w http.ResponseWriter, r *h
 		return
 	}
 
+	if visible != nil {
+		filtered := docs[:0]
+		for _, doc := range docs {
+			if visible[doc.ID] {
+				filtered = append(filtered, doc)
+			}
+		}
+		docs = filtered
+	}
+
 	SendJSONResponse(http.StatusOK, w, docs)
 }
 
@@ -49,6 +64,8 @@ func GetDocumentation(service *services.DocService, w http.ResponseWriter, r *ht
 }
 
 func CreateDocumentation(service *services.ServiceRegistry, w http.ResponseWriter, r *http.Request) {
+	service = service.WithActor(auditActor(r))
+
 	token, err := GetTokenFromHeader(r)
 	if err != nil {
 		SendJSONResponse(http.StatusUnauthorized, w, map[string]string{"status": "error", "message": "invalid_token"})
@@ -84,6 +101,7 @@ func CreateDocumentation(service *services.ServiceRegistry, w http.ResponseWrite
 		GitUser          string `json:"gitUser"`
 		GitPassword      string `json:"gitPassword"`
 		GitEmail         string `json:"gitEmail"`
+		RobotsTxt        string `json:"robotsTxt"`
 
 		BucketFavicon      string `json:"bucketFavicon"`
 		BucketMetaImage    string `json:"bucketMetaImage"`
@@ -123,6 +141,7 @@ func CreateDocumentation(service *services.ServiceRegistry, w http.ResponseWrite
 		GitUser:          req.GitUser,
 		GitPassword:      req.GitPassword,
 		GitEmail:         req.GitEmail,
+		RobotsTxt:        req.RobotsTxt,
 	}
 
 	err = service.DocService.CreateDocumentation(documentation, user, map[string]string{
@@ -140,6 +159,8 @@ func CreateDocumentation(service *services.ServiceRegistry, w http.ResponseWrite
 }
 
 func EditDocumentation(services *services.ServiceRegistry, w http.ResponseWriter, r *http.Request) {
+	services = services.WithActor(auditActor(r))
+
 	type Request struct {
 		ID               uint   `json:"id" validate:"required"`
 		Name             string `json:"name" validate:"required"`
@@ -164,6 +185,7 @@ func EditDocumentation(services *services.ServiceRegistry, w http.ResponseWriter
 		GitEmail         string `json:"gitEmail"`
 		GitUser          string `json:"gitUser"`
 		GitPassword      string `json:"gitPassword"`
+		RobotsTxt        string `json:"robotsTxt"`
 
 		BucketFavicon      string `json:"bucketFavicon"`
 		BucketMetaImage    string `json:"bucketMetaImage"`
@@ -212,6 +234,7 @@ func EditDocumentation(services *services.ServiceRegistry, w http.ResponseWriter
 		req.GitUser,
 		req.GitPassword,
 		req.GitEmail,
+		req.RobotsTxt,
 		map[string]string{
 			"favicon":      req.BucketFavicon,
 			"metaImage":    req.BucketMetaImage,
@@ -227,7 +250,9 @@ func EditDocumentation(services *services.ServiceRegistry, w http.ResponseWriter
 	SendJSONResponse(http.StatusOK, w, map[string]string{"status": "success", "message": "documentation_updated", "id": fmt.Sprint(req.ID)})
 }
 
-func DeleteDocumentation(service *services.DocService, w http.ResponseWriter, r *http.Request) {
+func DeleteDocumentation(services *services.ServiceRegistry, w http.ResponseWriter, r *http.Request) {
+	services = services.WithActor(auditActor(r))
+
 	type Request struct {
 		ID uint `json:"id" validate:"required"`
 	}
@@ -237,9 +262,19 @@ func DeleteDocumentation(service *services.DocService, w http.ResponseWriter, r
 		return
 	}
 
-	err = service.DeleteDocumentation(req.ID)
+	user, ok := requestUser(services.AuthService, w, r)
+	if !ok {
+		return
+	}
+
+	err = services.DocService.DeleteDocumentation(user, req.ID)
 	if err != nil {
-		SendJSONResponse(http.StatusInternalServerError, w, map[string]string{"status": "error", "message": err.Error()})
+		switch err.Error() {
+		case "insufficient_documentation_role":
+			SendJSONResponse(http.StatusForbidden, w, map[string]string{"status": "error", "message": err.Error()})
+		default:
+			SendJSONResponse(http.StatusInternalServerError, w, map[string]string{"status": "error", "message": err.Error()})
+		}
 		return
 	}
 
@@ -247,6 +282,8 @@ func DeleteDocumentation(service *services.DocService, w http.ResponseWriter, r
 }
 
 func CreateDocumentationVersion(service *services.DocService, w http.ResponseWriter, r *http.Request) {
+	service = service.WithActor(auditActor(r))
+
 	type Request struct {
 		OriginalDocID uint   `json:"originalDocId" validate:"required"`
 		NewVersion    string `json:"version" validate:"required"`
@@ -266,13 +303,28 @@ func CreateDocumentationVersion(service *services.DocService, w http.ResponseWri
 	SendJSONResponse(http.StatusOK, w, map[string]string{"status": "success", "message": "version_created"})
 }
 
-func GetPages(service *services.DocService, w http.ResponseWriter, r *http.Request) {
-	pages, err := service.GetPages()
+func GetPages(services *services.ServiceRegistry, w http.ResponseWriter, r *http.Request) {
+	visible, ok := visibleDocumentations(services.AuthService, w, r)
+	if !ok {
+		return
+	}
+
+	pages, err := services.DocService.GetPages()
 	if err != nil {
 		SendJSONResponse(http.StatusInternalServerError, w, map[string]string{"status": "error", "message": err.Error()})
 		return
 	}
 
+	if visible != nil {
+		filtered := pages[:0]
+		for _, page := range pages {
+			if visible[page.DocumentationID] {
+				filtered = append(filtered, page)
+			}
+		}
+		pages = filtered
+	}
+
 	SendJSONResponse(http.StatusOK, w, pages)
 }
 
@@ -296,6 +348,8 @@ func GetPage(service *services.DocService, w http.ResponseWriter, r *http.Reques
 }
 
 func CreatePage(services *services.ServiceRegistry, w http.ResponseWriter, r *http.Request) {
+	services = services.WithActor(auditActor(r))
+
 	type Request struct {
 		Title           string `json:"title" validate:"required"`
 		Slug            string `json:"slug" validate:"required"`
@@ -351,6 +405,8 @@ func CreatePage(services *services.ServiceRegistry, w http.ResponseWriter, r *ht
 }
 
 func EditPage(services *services.ServiceRegistry, w http.ResponseWriter, r *http.Request) {
+	services = services.WithActor(auditActor(r))
+
 	type Request struct {
 		ID          uint   `json:"id" validate:"required"`
 		Title       string `json:"title" validate:"required"`
@@ -358,6 +414,7 @@ func EditPage(services *services.ServiceRegistry, w http.ResponseWriter, r *http
 		Content     string `json:"content"`
 		Order       *uint  `json:"order"`
 		PageGroupId *uint  `json:"pageGroupId"`
+		Version     *uint  `json:"version"`
 	}
 
 	req, err := ValidateRequest[Request](w, r)
@@ -377,16 +434,23 @@ func EditPage(services *services.ServiceRegistry, w http.ResponseWriter, r *http
 		return
 	}
 
-	err = services.DocService.EditPage(user, req.ID, req.Title, req.Slug, req.Content, req.Order, req.PageGroupId)
+	err = services.DocService.EditPage(user, req.ID, req.Title, req.Slug, req.Content, req.Order, req.PageGroupId, req.Version)
 	if err != nil {
-		SendJSONResponse(http.StatusInternalServerError, w, map[string]string{"status": "error", "message": err.Error()})
+		switch err.Error() {
+		case "page_version_conflict", "locked_by_another_user":
+			SendJSONResponse(http.StatusConflict, w, map[string]string{"status": "error", "message": err.Error()})
+		default:
+			SendJSONResponse(http.StatusInternalServerError, w, map[string]string{"status": "error", "message": err.Error()})
+		}
 		return
 	}
 
 	SendJSONResponse(http.StatusOK, w, map[string]string{"status": "success", "message": "page_updated", "id": fmt.Sprint(req.ID)})
 }
 
-func DeletePage(service *services.DocService, w http.ResponseWriter, r *http.Request) {
+func DeletePage(services *services.ServiceRegistry, w http.ResponseWriter, r *http.Request) {
+	services = services.WithActor(auditActor(r))
+
 	type Request struct {
 		ID uint `json:"id" validate:"required"`
 	}
@@ -396,11 +460,18 @@ func DeletePage(service *services.DocService, w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	err = service.DeletePage(req.ID)
+	user, ok := requestUser(services.AuthService, w, r)
+	if !ok {
+		return
+	}
+
+	err = services.DocService.DeletePage(user, req.ID)
 	if err != nil {
 		switch err.Error() {
 		case "page_not_found":
 			SendJSONResponse(http.StatusNotFound, w, map[string]string{"status": "error", "message": "Page not found"})
+		case "insufficient_documentation_role":
+			SendJSONResponse(http.StatusForbidden, w, map[string]string{"status": "error", "message": err.Error()})
 		default:
 			logger.Error(err.Error())
 			SendJSONResponse(http.StatusInternalServerError, w, map[string]string{"status": "error", "message": err.Error()})
@@ -411,14 +482,144 @@ func DeletePage(service *services.DocService, w http.ResponseWriter, r *http.Req
 	SendJSONResponse(http.StatusOK, w, map[string]string{"status": "success", "message": "page_deleted", "id": fmt.Sprint(req.ID)})
 }
 
-func GetPageGroups(service *services.DocService, w http.ResponseWriter, r *http.Request) {
-	pageGroups, err := service.GetPageGroups()
+func GetPageRevisions(service *services.DocService, w http.ResponseWriter, r *http.Request) {
+	type Request struct {
+		PageID uint `json:"pageId" validate:"required"`
+	}
+
+	req, err := ValidateRequest[Request](w, r)
+	if err != nil {
+		return
+	}
+
+	revisions, err := service.GetPageRevisions(req.PageID)
+	if err != nil {
+		switch err.Error() {
+		case "page_not_found":
+			SendJSONResponse(http.StatusNotFound, w, map[string]string{"status": "error", "message": err.Error()})
+		default:
+			SendJSONResponse(http.StatusInternalServerError, w, map[string]string{"status": "error", "message": err.Error()})
+		}
+		return
+	}
+
+	SendJSONResponse(http.StatusOK, w, revisions)
+}
+
+func GetPageRevision(service *services.DocService, w http.ResponseWriter, r *http.Request) {
+	type Request struct {
+		ID uint `json:"id" validate:"required"`
+	}
+
+	req, err := ValidateRequest[Request](w, r)
+	if err != nil {
+		return
+	}
+
+	revision, err := service.GetPageRevision(req.ID)
+	if err != nil {
+		switch err.Error() {
+		case "page_revision_not_found":
+			SendJSONResponse(http.StatusNotFound, w, map[string]string{"status": "error", "message": err.Error()})
+		default:
+			SendJSONResponse(http.StatusInternalServerError, w, map[string]string{"status": "error", "message": err.Error()})
+		}
+		return
+	}
+
+	SendJSONResponse(http.StatusOK, w, revision)
+}
+
+func DiffPageRevisions(service *services.DocService, w http.ResponseWriter, r *http.Request) {
+	type Request struct {
+		FromID uint `json:"fromId" validate:"required"`
+		ToID   uint `json:"toId" validate:"required"`
+	}
+
+	req, err := ValidateRequest[Request](w, r)
+	if err != nil {
+		return
+	}
+
+	diff, err := service.DiffPageRevisions(req.FromID, req.ToID)
+	if err != nil {
+		switch err.Error() {
+		case "page_revision_not_found":
+			SendJSONResponse(http.StatusNotFound, w, map[string]string{"status": "error", "message": err.Error()})
+		case "revisions_belong_to_different_pages":
+			SendJSONResponse(http.StatusBadRequest, w, map[string]string{"status": "error", "message": err.Error()})
+		default:
+			SendJSONResponse(http.StatusInternalServerError, w, map[string]string{"status": "error", "message": err.Error()})
+		}
+		return
+	}
+
+	SendJSONResponse(http.StatusOK, w, diff)
+}
+
+func RestorePageRevision(services *services.ServiceRegistry, w http.ResponseWriter, r *http.Request) {
+	services = services.WithActor(auditActor(r))
+
+	type Request struct {
+		ID uint `json:"id" validate:"required"`
+	}
+
+	req, err := ValidateRequest[Request](w, r)
+	if err != nil {
+		return
+	}
+
+	token, err := GetTokenFromHeader(r)
+	if err != nil {
+		SendJSONResponse(http.StatusUnauthorized, w, map[string]string{"status": "error", "message": "invalid_request"})
+		return
+	}
+
+	user, err := services.AuthService.GetUserFromToken(token)
+	if err != nil {
+		SendJSONResponse(http.StatusUnauthorized, w, map[string]string{"status": "error", "message": "invalid_request"})
+		return
+	}
+
+	err = services.DocService.RestorePageRevision(user, req.ID)
+	if err != nil {
+		switch err.Error() {
+		case "page_revision_not_found", "page_not_found":
+			SendJSONResponse(http.StatusNotFound, w, map[string]string{"status": "error", "message": err.Error()})
+		case "slug_already_in_use", "locked_by_another_user":
+			SendJSONResponse(http.StatusConflict, w, map[string]string{"status": "error", "message": err.Error()})
+		default:
+			SendJSONResponse(http.StatusInternalServerError, w, map[string]string{"status": "error", "message": err.Error()})
+		}
+		return
+	}
+
+	SendJSONResponse(http.StatusOK, w, map[string]string{"status": "success", "message": "page_revision_restored", "id": fmt.Sprint(req.ID)})
+}
+
+func GetPageGroups(services *services.ServiceRegistry, w http.ResponseWriter, r *http.Request) {
+	visible, ok := visibleDocumentations(services.AuthService, w, r)
+	if !ok {
+		return
+	}
+
+	pageGroups, err := services.DocService.GetPageGroups()
 	if err != nil {
 		logger.Error(err.Error())
 		SendJSONResponse(http.StatusInternalServerError, w, map[string]string{"status": "error", "message": err.Error()})
 		return
 	}
 
+	if visible != nil {
+		filtered := pageGroups[:0]
+		for _, group := range pageGroups {
+			if docId, ok := group["documentationId"].(uint); ok && visible[docId] {
+				filtered = append(filtered, group)
+			}
+		}
+		pageGroups = filtered
+	}
+
 	SendJSONResponse(http.StatusOK, w, pageGroups)
 }
 
@@ -448,6 +649,8 @@ func GetPageGroup(service *services.DocService, w http.ResponseWriter, r *http.R
 }
 
 func CreatePageGroup(services *services.ServiceRegistry, w http.ResponseWriter, r *http.Request) {
+	services = services.WithActor(auditActor(r))
+
 	type Request struct {
 		Name            string `json:"name" validate:"required"`
 		DocumentationID uint   `json:"documentationId" validate:"required"`
@@ -499,6 +702,8 @@ func CreatePageGroup(services *services.ServiceRegistry, w http.ResponseWriter,
 }
 
 func EditPageGroup(services *services.ServiceRegistry, w http.ResponseWriter, r *http.Request) {
+	services = services.WithActor(auditActor(r))
+
 	type Request struct {
 		ID              uint   `json:"id" validate:"required"`
 		Name            string `json:"name" validate:"required"`
@@ -526,14 +731,21 @@ func EditPageGroup(services *services.ServiceRegistry, w http.ResponseWriter, r
 
 	err = services.DocService.EditPageGroup(user, req.ID, req.Name, req.DocumentationID, req.ParentID, req.Order)
 	if err != nil {
-		SendJSONResponse(http.StatusInternalServerError, w, map[string]string{"status": "error", "message": err.Error()})
+		switch err.Error() {
+		case "locked_by_another_user":
+			SendJSONResponse(http.StatusConflict, w, map[string]string{"status": "error", "message": err.Error()})
+		default:
+			SendJSONResponse(http.StatusInternalServerError, w, map[string]string{"status": "error", "message": err.Error()})
+		}
 		return
 	}
 
 	SendJSONResponse(http.StatusOK, w, map[string]string{"status": "success", "message": "page_group_updated", "id": fmt.Sprint(req.ID)})
 }
 
-func DeletePageGroup(service *services.DocService, w http.ResponseWriter, r *http.Request) {
+func DeletePageGroup(services *services.ServiceRegistry, w http.ResponseWriter, r *http.Request) {
+	services = services.WithActor(auditActor(r))
+
 	type Request struct {
 		ID uint `json:"id" validate:"required"`
 	}
@@ -543,15 +755,69 @@ func DeletePageGroup(service *services.DocService, w http.ResponseWriter, r *htt
 		return
 	}
 
-	err = service.DeletePageGroup(req.ID)
+	user, ok := requestUser(services.AuthService, w, r)
+	if !ok {
+		return
+	}
+
+	err = services.DocService.DeletePageGroup(user, req.ID)
 	if err != nil {
-		SendJSONResponse(http.StatusInternalServerError, w, map[string]string{"status": "error", "message": err.Error()})
+		switch err.Error() {
+		case "insufficient_documentation_role":
+			SendJSONResponse(http.StatusForbidden, w, map[string]string{"status": "error", "message": err.Error()})
+		default:
+			SendJSONResponse(http.StatusInternalServerError, w, map[string]string{"status": "error", "message": err.Error()})
+		}
 		return
 	}
 
 	SendJSONResponse(http.StatusOK, w, map[string]string{"status": "success", "message": "page_group_deleted", "id": fmt.Sprint(req.ID)})
 }
 
+func Search(service *services.ServiceRegistry, w http.ResponseWriter, r *http.Request) {
+	type Request struct {
+		Query           string `json:"query" validate:"required"`
+		DocumentationID uint   `json:"documentationId"`
+		Version         string `json:"version"`
+		AuthorID        uint   `json:"authorId"`
+		Kind            string `json:"kind" validate:"omitempty,oneof=documentation page page_group"`
+		Limit           int    `json:"limit" validate:"omitempty,min=1,max=100"`
+		Offset          int    `json:"offset" validate:"omitempty,min=0"`
+	}
+
+	req, err := ValidateRequest[Request](w, r)
+	if err != nil {
+		return
+	}
+
+	visible, ok := visibleDocumentations(service.AuthService, w, r)
+	if !ok {
+		return
+	}
+
+	results, total, err := service.DocService.Search(services.SearchOptions{
+		Query:            req.Query,
+		DocumentationID:  req.DocumentationID,
+		DocumentationIDs: visibleDocumentationList(visible),
+		Version:          req.Version,
+		AuthorID:         req.AuthorID,
+		Kind:             req.Kind,
+		Limit:            req.Limit,
+		Offset:           req.Offset,
+	})
+	if err != nil {
+		switch err.Error() {
+		case "documentation_not_found":
+			SendJSONResponse(http.StatusNotFound, w, map[string]string{"status": "error", "message": err.Error()})
+		default:
+			SendJSONResponse(http.StatusInternalServerError, w, map[string]string{"status": "error", "message": err.Error()})
+		}
+		return
+	}
+
+	SendJSONResponse(http.StatusOK, w, map[string]interface{}{"total": total, "results": results})
+}
+
 func GetRsPress(service *services.DocService, w http.ResponseWriter, r *http.Request) {
 	fmt.Println("hit 2")
 	urlPath := r.URL.Path
@@ -572,6 +838,8 @@ func GetRsPress(service *services.DocService, w http.ResponseWriter, r *http.Req
 }
 
 func BulkReorderPageOrPageGroup(service *services.DocService, w http.ResponseWriter, r *http.Request) {
+	service = service.WithActor(auditActor(r))
+
 	type Request struct {
 		Order []struct {
 			ID          uint  `json:"id" validate:"required"`
@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"git.difuse.io/Difuse/kalmia/config"
+	"git.difuse.io/Difuse/kalmia/services"
+	"git.difuse.io/Difuse/kalmia/utils"
+)
+
+func ExportDocumentation(service *services.DocService, w http.ResponseWriter, r *http.Request, cfg *config.Config) {
+	type Request struct {
+		ID     uint   `json:"id" validate:"required"`
+		Format string `json:"format"`
+	}
+
+	req, err := ValidateRequest[Request](w, r)
+	if err != nil {
+		return
+	}
+
+	path, filename, err := service.ExportDocumentation(req.ID, req.Format, cfg)
+	if err != nil {
+		SendJSONResponse(http.StatusInternalServerError, w, map[string]string{"status": "error", "message": err.Error()})
+		return
+	}
+
+	defer os.Remove(path)
+
+	file, err := os.Open(path)
+	if err != nil {
+		SendJSONResponse(http.StatusInternalServerError, w, map[string]string{"status": "error", "message": "failed_to_read_archive"})
+		return
+	}
+
+	defer file.Close()
+
+	contentType := "application/zip"
+	if req.Format == utils.ArchiveFormatTarGz {
+		contentType = "application/gzip"
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.WriteHeader(http.StatusOK)
+	io.Copy(w, file)
+}
+
+func ImportDocumentation(service *services.ServiceRegistry, w http.ResponseWriter, r *http.Request, cfg *config.Config) {
+	service = service.WithActor(auditActor(r))
+
+	token, err := GetTokenFromHeader(r)
+	if err != nil {
+		SendJSONResponse(http.StatusUnauthorized, w, map[string]string{"status": "error", "message": "invalid_request"})
+		return
+	}
+
+	user, err := service.AuthService.GetUserFromToken(token)
+	if err != nil {
+		SendJSONResponse(http.StatusUnauthorized, w, map[string]string{"status": "error", "message": "invalid_request"})
+		return
+	}
+
+	// Capped at MaxFileSize set by the user
+	if err := r.ParseMultipartForm(cfg.MaxFileSize << 20); err != nil {
+		SendJSONResponse(http.StatusBadRequest, w, map[string]string{"status": "error", "message": "failed_to_parse_form"})
+		return
+	}
+
+	file, header, err := r.FormFile("upload")
+	if err != nil {
+		SendJSONResponse(http.StatusBadRequest, w, map[string]string{"status": "error", "message": "upload_required"})
+		return
+	}
+
+	defer file.Close()
+
+	if header.Size > cfg.MaxFileSize<<20 {
+		SendJSONResponse(http.StatusBadRequest, w, map[string]string{"status": "error", "message": "file_too_large"})
+		return
+	}
+
+	tempFile, err := os.CreateTemp("", "documentation-import-*")
+	if err != nil {
+		SendJSONResponse(http.StatusInternalServerError, w, map[string]string{"status": "error", "message": "failed_to_create_temp_file"})
+		return
+	}
+	defer os.Remove(tempFile.Name())
+	defer tempFile.Close()
+
+	if _, err := io.Copy(tempFile, file); err != nil {
+		SendJSONResponse(http.StatusInternalServerError, w, map[string]string{"status": "error", "message": "failed_to_read_file"})
+		return
+	}
+
+	docId, err := service.DocService.ImportDocumentationArchive(user, tempFile.Name(), r.FormValue("name"), r.FormValue("baseURL"), cfg)
+	if err != nil {
+		SendJSONResponse(http.StatusInternalServerError, w, map[string]string{"status": "error", "message": "documentation_import_failed", "error": err.Error()})
+		return
+	}
+
+	SendJSONResponse(http.StatusOK, w, map[string]interface{}{"status": "success", "documentationId": docId})
+}
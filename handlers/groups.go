@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"net/http"
+
+	"git.difuse.io/Difuse/kalmia/services"
+)
+
+func GetGroups(authService *services.AuthService, w http.ResponseWriter, r *http.Request) {
+	groups, err := authService.GetGroups()
+	if err != nil {
+		SendJSONResponse(http.StatusInternalServerError, w, map[string]string{"status": "error", "message": err.Error()})
+		return
+	}
+
+	SendJSONResponse(http.StatusOK, w, groups)
+}
+
+func CreateGroup(authService *services.AuthService, w http.ResponseWriter, r *http.Request) {
+	authService = authService.WithActor(auditActor(r))
+
+	type Request struct {
+		Name        string   `json:"name" validate:"required"`
+		Description string   `json:"description"`
+		Permissions []string `json:"permissions"`
+	}
+
+	req, err := ValidateRequest[Request](w, r)
+	if err != nil {
+		return
+	}
+
+	group, err := authService.CreateGroup(req.Name, req.Description, req.Permissions)
+	if err != nil {
+		SendJSONResponse(http.StatusBadRequest, w, map[string]string{"status": "error", "message": err.Error()})
+		return
+	}
+
+	SendJSONResponse(http.StatusOK, w, map[string]interface{}{"status": "success", "message": "group_created", "group": group})
+}
+
+func EditGroup(authService *services.AuthService, w http.ResponseWriter, r *http.Request) {
+	authService = authService.WithActor(auditActor(r))
+
+	type Request struct {
+		ID          uint     `json:"id" validate:"required"`
+		Name        string   `json:"name"`
+		Description string   `json:"description"`
+		Permissions []string `json:"permissions"`
+	}
+
+	req, err := ValidateRequest[Request](w, r)
+	if err != nil {
+		return
+	}
+
+	if err := authService.EditGroup(req.ID, req.Name, req.Description, req.Permissions); err != nil {
+		SendJSONResponse(http.StatusBadRequest, w, map[string]string{"status": "error", "message": err.Error()})
+		return
+	}
+
+	SendJSONResponse(http.StatusOK, w, map[string]string{"status": "success", "message": "group_updated"})
+}
+
+func DeleteGroup(authService *services.AuthService, w http.ResponseWriter, r *http.Request) {
+	authService = authService.WithActor(auditActor(r))
+
+	type Request struct {
+		ID uint `json:"id" validate:"required"`
+	}
+
+	req, err := ValidateRequest[Request](w, r)
+	if err != nil {
+		return
+	}
+
+	if err := authService.DeleteGroup(req.ID); err != nil {
+		SendJSONResponse(http.StatusBadRequest, w, map[string]string{"status": "error", "message": err.Error()})
+		return
+	}
+
+	SendJSONResponse(http.StatusOK, w, map[string]string{"status": "success", "message": "group_deleted"})
+}
+
+func AddGroupMember(authService *services.AuthService, w http.ResponseWriter, r *http.Request) {
+	authService = authService.WithActor(auditActor(r))
+
+	type Request struct {
+		GroupID uint `json:"groupId" validate:"required"`
+		UserID  uint `json:"userId" validate:"required"`
+	}
+
+	req, err := ValidateRequest[Request](w, r)
+	if err != nil {
+		return
+	}
+
+	if err := authService.AddGroupMember(req.GroupID, req.UserID); err != nil {
+		SendJSONResponse(http.StatusBadRequest, w, map[string]string{"status": "error", "message": err.Error()})
+		return
+	}
+
+	SendJSONResponse(http.StatusOK, w, map[string]string{"status": "success", "message": "group_member_added"})
+}
+
+func RemoveGroupMember(authService *services.AuthService, w http.ResponseWriter, r *http.Request) {
+	authService = authService.WithActor(auditActor(r))
+
+	type Request struct {
+		GroupID uint `json:"groupId" validate:"required"`
+		UserID  uint `json:"userId" validate:"required"`
+	}
+
+	req, err := ValidateRequest[Request](w, r)
+	if err != nil {
+		return
+	}
+
+	if err := authService.RemoveGroupMember(req.GroupID, req.UserID); err != nil {
+		SendJSONResponse(http.StatusBadRequest, w, map[string]string{"status": "error", "message": err.Error()})
+		return
+	}
+
+	SendJSONResponse(http.StatusOK, w, map[string]string{"status": "success", "message": "group_member_removed"})
+}
+
+func GrantGroupDocumentation(authService *services.AuthService, w http.ResponseWriter, r *http.Request) {
+	authService = authService.WithActor(auditActor(r))
+
+	type Request struct {
+		GroupID         uint   `json:"groupId" validate:"required"`
+		DocumentationID uint   `json:"documentationId" validate:"required"`
+		Role            string `json:"role" validate:"required,oneof=viewer editor maintainer owner"`
+	}
+
+	req, err := ValidateRequest[Request](w, r)
+	if err != nil {
+		return
+	}
+
+	if err := authService.GrantGroupDocumentation(req.GroupID, req.DocumentationID, req.Role); err != nil {
+		SendJSONResponse(http.StatusBadRequest, w, map[string]string{"status": "error", "message": err.Error()})
+		return
+	}
+
+	SendJSONResponse(http.StatusOK, w, map[string]string{"status": "success", "message": "group_grant_saved"})
+}
+
+func RevokeGroupDocumentation(authService *services.AuthService, w http.ResponseWriter, r *http.Request) {
+	authService = authService.WithActor(auditActor(r))
+
+	type Request struct {
+		GroupID         uint `json:"groupId" validate:"required"`
+		DocumentationID uint `json:"documentationId" validate:"required"`
+	}
+
+	req, err := ValidateRequest[Request](w, r)
+	if err != nil {
+		return
+	}
+
+	if err := authService.RevokeGroupDocumentation(req.GroupID, req.DocumentationID); err != nil {
+		SendJSONResponse(http.StatusBadRequest, w, map[string]string{"status": "error", "message": err.Error()})
+		return
+	}
+
+	SendJSONResponse(http.StatusOK, w, map[string]string{"status": "success", "message": "group_grant_revoked"})
+}
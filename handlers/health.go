@@ -1,9 +1,15 @@
 package handlers
 
 import (
+	"encoding/json"
+	"fmt"
 	"net/http"
+	"strconv"
+	"time"
 
 	"git.difuse.io/Difuse/kalmia/services"
+	"git.difuse.io/Difuse/kalmia/utils"
+	"github.com/gorilla/mux"
 )
 
 func HealthPing(w http.ResponseWriter, r *http.Request) {
@@ -21,3 +27,193 @@ func TriggerCheck(dS *services.DocService, w http.ResponseWriter, r *http.Reques
 
 	SendJSONResponse(http.StatusOK, w, lastTrigger)
 }
+
+func CancelBuild(dS *services.DocService, w http.ResponseWriter, r *http.Request) {
+	type Request struct {
+		ID              uint `json:"id"`
+		DocumentationID uint `json:"documentationId"`
+	}
+
+	req, err := ValidateRequest[Request](w, r)
+	if err != nil {
+		return
+	}
+
+	if req.ID == 0 && req.DocumentationID == 0 {
+		SendJSONResponse(http.StatusBadRequest, w, map[string]string{"status": "error", "message": "id_or_documentation_id_required"})
+		return
+	}
+
+	if req.ID != 0 {
+		if err := dS.CancelBuildTrigger(req.ID); err != nil {
+			SendJSONResponse(http.StatusBadRequest, w, map[string]string{"status": "error", "message": err.Error()})
+			return
+		}
+
+		SendJSONResponse(http.StatusOK, w, map[string]string{"status": "success", "message": "build_cancelled"})
+		return
+	}
+
+	count, err := dS.CancelDocumentationBuilds(req.DocumentationID)
+	if err != nil {
+		SendJSONResponse(http.StatusInternalServerError, w, map[string]string{"status": "error", "message": err.Error()})
+		return
+	}
+
+	SendJSONResponse(http.StatusOK, w, map[string]interface{}{"status": "success", "message": "build_cancelled", "cancelled": count})
+}
+
+func GetBuilds(service *services.ServiceRegistry, w http.ResponseWriter, r *http.Request) {
+	visible, ok := visibleDocumentations(service.AuthService, w, r)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	opts := services.BuildListOptions{Status: query.Get("status")}
+
+	if value := query.Get("limit"); value != "" {
+		limit, err := utils.StringToUint(value)
+		if err != nil {
+			SendJSONResponse(http.StatusBadRequest, w, map[string]string{"status": "error", "message": "invalid_limit"})
+			return
+		}
+		opts.Limit = int(limit)
+	}
+
+	if value := query.Get("offset"); value != "" {
+		offset, err := utils.StringToUint(value)
+		if err != nil {
+			SendJSONResponse(http.StatusBadRequest, w, map[string]string{"status": "error", "message": "invalid_offset"})
+			return
+		}
+		opts.Offset = int(offset)
+	}
+
+	if value := query.Get("documentationId"); value != "" {
+		id, err := utils.StringToUint(value)
+		if err != nil {
+			SendJSONResponse(http.StatusBadRequest, w, map[string]string{"status": "error", "message": "invalid_documentation_id"})
+			return
+		}
+		opts.DocumentationID = id
+	}
+
+	opts.DocumentationIDs = visibleDocumentationList(visible)
+
+	builds, total, err := service.DocService.GetBuilds(opts)
+	if err != nil {
+		SendJSONResponse(http.StatusInternalServerError, w, map[string]string{"status": "error", "message": err.Error()})
+		return
+	}
+
+	SendJSONResponse(http.StatusOK, w, map[string]interface{}{"total": total, "builds": builds})
+}
+
+func GetBuildLog(dS *services.DocService, w http.ResponseWriter, r *http.Request) {
+	id, err := utils.StringToUint(mux.Vars(r)["id"])
+	if err != nil {
+		SendJSONResponse(http.StatusBadRequest, w, map[string]string{"status": "error", "message": "invalid_id"})
+		return
+	}
+
+	log, err := dS.GetBuildLog(id)
+	if err != nil {
+		if err.Error() == "build_not_found" {
+			SendJSONResponse(http.StatusNotFound, w, map[string]string{"status": "error", "message": err.Error()})
+			return
+		}
+
+		SendJSONResponse(http.StatusInternalServerError, w, map[string]string{"status": "error", "message": err.Error()})
+		return
+	}
+
+	SendJSONResponse(http.StatusOK, w, log)
+}
+
+// BuildEvents streams build lifecycle events as Server-Sent Events, optionally
+// limited to one documentation. Reconnecting clients get the events they
+// missed through the Last-Event-ID header, as long as they're still buffered.
+func BuildEvents(service *services.ServiceRegistry, w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		SendJSONResponse(http.StatusInternalServerError, w, map[string]string{"status": "error", "message": "streaming_unsupported"})
+		return
+	}
+
+	var documentationId uint
+	if value := r.URL.Query().Get("documentationId"); value != "" {
+		id, err := utils.StringToUint(value)
+		if err != nil {
+			SendJSONResponse(http.StatusBadRequest, w, map[string]string{"status": "error", "message": "invalid_documentation_id"})
+			return
+		}
+		documentationId = id
+	}
+
+	visible, ok := visibleDocumentations(service.AuthService, w, r)
+	if !ok {
+		return
+	}
+
+	lastId, _ := strconv.ParseUint(r.Header.Get("Last-Event-ID"), 10, 64)
+
+	missed, events, unsubscribe := service.DocService.BuildEvents.Subscribe(lastId)
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	send := func(event services.BuildEvent) bool {
+		if documentationId != 0 && event.DocumentationID != documentationId {
+			return true
+		}
+
+		if visible != nil && !visible[event.DocumentationID] {
+			return true
+		}
+
+		data, err := json.Marshal(event)
+		if err != nil {
+			return true
+		}
+
+		if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data); err != nil {
+			return false
+		}
+
+		flusher.Flush()
+		return true
+	}
+
+	for _, event := range missed {
+		if !send(event) {
+			return
+		}
+	}
+
+	fmt.Fprint(w, ": connected\n\n")
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(15 * time.Second)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case event, ok := <-events:
+			if !ok || !send(event) {
+				return
+			}
+		}
+	}
+}
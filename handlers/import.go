@@ -2,13 +2,19 @@ package handlers
 
 import (
 	"encoding/json"
+	"io"
 	"net/http"
+	"os"
 
 	"git.difuse.io/Difuse/kalmia/config"
+	"git.difuse.io/Difuse/kalmia/db/models"
 	"git.difuse.io/Difuse/kalmia/services"
+	"git.difuse.io/Difuse/kalmia/utils"
 )
 
 func ImportGitbook(services *services.ServiceRegistry, w http.ResponseWriter, r *http.Request, cfg *config.Config) {
+	services = services.WithActor(auditActor(r))
+
 	var request struct {
 		URL      string `json:"url"`
 		Username string `json:"username"`
@@ -34,3 +40,126 @@ func ImportGitbook(services *services.ServiceRegistry, w http.ResponseWriter, r
 
 	SendJSONResponse(http.StatusOK, w, jsonString)
 }
+
+func ImportMarkdown(service *services.ServiceRegistry, w http.ResponseWriter, r *http.Request, cfg *config.Config) {
+	service = service.WithActor(auditActor(r))
+
+	token, err := GetTokenFromHeader(r)
+	if err != nil {
+		SendJSONResponse(http.StatusUnauthorized, w, map[string]string{"status": "error", "message": "invalid_request"})
+		return
+	}
+
+	user, err := service.AuthService.GetUserFromToken(token)
+	if err != nil {
+		SendJSONResponse(http.StatusUnauthorized, w, map[string]string{"status": "error", "message": "invalid_request"})
+		return
+	}
+
+	// Capped at MaxFileSize set by the user
+	if err := r.ParseMultipartForm(cfg.MaxFileSize << 20); err != nil {
+		SendJSONResponse(http.StatusBadRequest, w, map[string]string{"status": "error", "message": "failed_to_parse_form"})
+		return
+	}
+
+	opts := services.MarkdownImportOptions{}
+
+	if docId := r.FormValue("documentationId"); docId != "" {
+		id, err := utils.StringToUint(docId)
+		if err != nil {
+			SendJSONResponse(http.StatusBadRequest, w, map[string]string{"status": "error", "message": "invalid_documentation_id"})
+			return
+		}
+		opts.DocumentationID = id
+	}
+
+	if groupId := r.FormValue("pageGroupId"); groupId != "" {
+		id, err := utils.StringToUint(groupId)
+		if err != nil {
+			SendJSONResponse(http.StatusBadRequest, w, map[string]string{"status": "error", "message": "invalid_page_group_id"})
+			return
+		}
+		opts.PageGroupID = &id
+	}
+
+	if opts.DocumentationID == 0 {
+		name := r.FormValue("name")
+		version := r.FormValue("version")
+		baseURL := r.FormValue("baseURL")
+
+		if name == "" || version == "" || baseURL == "" {
+			SendJSONResponse(http.StatusBadRequest, w, map[string]string{"status": "error", "message": "name_version_and_base_url_required"})
+			return
+		}
+
+		opts.Documentation = &models.Documentation{
+			Name:         name,
+			Version:      version,
+			BaseURL:      baseURL,
+			Description:  r.FormValue("description"),
+			AuthorID:     user.ID,
+			Editors:      []models.User{user},
+			LastEditorID: &user.ID,
+		}
+	}
+
+	var result services.MarkdownImportResult
+
+	file, header, err := r.FormFile("upload")
+	if err == nil {
+		defer file.Close()
+
+		if header.Size > cfg.MaxFileSize<<20 {
+			SendJSONResponse(http.StatusBadRequest, w, map[string]string{"status": "error", "message": "file_too_large"})
+			return
+		}
+
+		tempFile, err := os.CreateTemp("", "markdown-import-*.zip")
+		if err != nil {
+			SendJSONResponse(http.StatusInternalServerError, w, map[string]string{"status": "error", "message": "failed_to_create_temp_file"})
+			return
+		}
+		defer os.Remove(tempFile.Name())
+		defer tempFile.Close()
+
+		if _, err := io.Copy(tempFile, file); err != nil {
+			SendJSONResponse(http.StatusInternalServerError, w, map[string]string{"status": "error", "message": "failed_to_read_file"})
+			return
+		}
+
+		result, err = service.DocService.ImportMarkdownArchive(user, tempFile.Name(), opts, cfg)
+		if err != nil {
+			SendJSONResponse(http.StatusInternalServerError, w, map[string]string{"status": "error", "message": "markdown_import_failed", "error": err.Error()})
+			return
+		}
+	} else if path := r.FormValue("path"); path != "" {
+		if cfg.ImportRoot == "" {
+			SendJSONResponse(http.StatusForbidden, w, map[string]string{"status": "error", "message": "path_import_disabled"})
+			return
+		}
+
+		// The path is taken relative to the import root and can't leave it.
+		dir, err := utils.SafeJoin(cfg.ImportRoot, path)
+		if err != nil {
+			SendJSONResponse(http.StatusBadRequest, w, map[string]string{"status": "error", "message": "invalid_path"})
+			return
+		}
+
+		info, statErr := os.Stat(dir)
+		if statErr != nil || !info.IsDir() {
+			SendJSONResponse(http.StatusBadRequest, w, map[string]string{"status": "error", "message": "invalid_path"})
+			return
+		}
+
+		result, err = service.DocService.ImportMarkdownDirectory(user, dir, opts, cfg)
+		if err != nil {
+			SendJSONResponse(http.StatusInternalServerError, w, map[string]string{"status": "error", "message": "markdown_import_failed", "error": err.Error()})
+			return
+		}
+	} else {
+		SendJSONResponse(http.StatusBadRequest, w, map[string]string{"status": "error", "message": "upload_or_path_required"})
+		return
+	}
+
+	SendJSONResponse(http.StatusOK, w, result)
+}
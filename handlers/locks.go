@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"net/http"
+
+	"git.difuse.io/Difuse/kalmia/services"
+)
+
+func sendLockError(w http.ResponseWriter, err error) {
+	switch err.Error() {
+	case "page_not_found", "page_group_not_found", "lock_not_found":
+		SendJSONResponse(http.StatusNotFound, w, map[string]string{"status": "error", "message": err.Error()})
+	case "locked_by_another_user", "lock_not_held":
+		SendJSONResponse(http.StatusConflict, w, map[string]string{"status": "error", "message": err.Error()})
+	case "insufficient_documentation_role":
+		SendJSONResponse(http.StatusForbidden, w, map[string]string{"status": "error", "message": err.Error()})
+	default:
+		SendJSONResponse(http.StatusInternalServerError, w, map[string]string{"status": "error", "message": err.Error()})
+	}
+}
+
+// AcquireLock takes or renews the lock on a page or page group, depending on
+// kind.
+func AcquireLock(services *services.ServiceRegistry, kind string, w http.ResponseWriter, r *http.Request) {
+	type Request struct {
+		ID uint `json:"id" validate:"required"`
+	}
+
+	req, err := ValidateRequest[Request](w, r)
+	if err != nil {
+		return
+	}
+
+	user, ok := requestUser(services.AuthService, w, r)
+	if !ok {
+		return
+	}
+
+	lock, err := services.DocService.AcquireLock(user, kind, req.ID)
+	if err != nil {
+		sendLockError(w, err)
+		return
+	}
+
+	SendJSONResponse(http.StatusOK, w, lock)
+}
+
+func HeartbeatLock(services *services.ServiceRegistry, kind string, w http.ResponseWriter, r *http.Request) {
+	type Request struct {
+		ID uint `json:"id" validate:"required"`
+	}
+
+	req, err := ValidateRequest[Request](w, r)
+	if err != nil {
+		return
+	}
+
+	user, ok := requestUser(services.AuthService, w, r)
+	if !ok {
+		return
+	}
+
+	lock, err := services.DocService.HeartbeatLock(user, kind, req.ID)
+	if err != nil {
+		sendLockError(w, err)
+		return
+	}
+
+	SendJSONResponse(http.StatusOK, w, lock)
+}
+
+func ReleaseLock(services *services.ServiceRegistry, kind string, w http.ResponseWriter, r *http.Request) {
+	type Request struct {
+		ID uint `json:"id" validate:"required"`
+	}
+
+	req, err := ValidateRequest[Request](w, r)
+	if err != nil {
+		return
+	}
+
+	user, ok := requestUser(services.AuthService, w, r)
+	if !ok {
+		return
+	}
+
+	if err := services.DocService.ReleaseLock(user, kind, req.ID); err != nil {
+		sendLockError(w, err)
+		return
+	}
+
+	SendJSONResponse(http.StatusOK, w, map[string]string{"status": "success", "message": "lock_released"})
+}
+
+func ForceUnlock(services *services.ServiceRegistry, kind string, w http.ResponseWriter, r *http.Request) {
+	services = services.WithActor(auditActor(r))
+
+	type Request struct {
+		ID uint `json:"id" validate:"required"`
+	}
+
+	req, err := ValidateRequest[Request](w, r)
+	if err != nil {
+		return
+	}
+
+	if err := services.DocService.ForceUnlock(kind, req.ID); err != nil {
+		sendLockError(w, err)
+		return
+	}
+
+	SendJSONResponse(http.StatusOK, w, map[string]string{"status": "success", "message": "lock_removed"})
+}
@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"net/http"
+
+	"git.difuse.io/Difuse/kalmia/services"
+)
+
+// visibleDocumentations returns the documentations the caller may see, nil
+// meaning all of them. On failure the response has already been written.
+func visibleDocumentations(authService *services.AuthService, w http.ResponseWriter, r *http.Request) (map[uint]bool, bool) {
+	token, err := GetTokenFromHeader(r)
+	if err != nil {
+		SendJSONResponse(http.StatusUnauthorized, w, map[string]string{"status": "error", "message": "invalid_request"})
+		return nil, false
+	}
+
+	visible, err := authService.VisibleDocumentationIDs(token)
+	if err != nil {
+		SendJSONResponse(http.StatusInternalServerError, w, map[string]string{"status": "error", "message": err.Error()})
+		return nil, false
+	}
+
+	return visible, true
+}
+
+func visibleDocumentationList(visible map[uint]bool) []uint {
+	if visible == nil {
+		return nil
+	}
+
+	ids := make([]uint, 0, len(visible))
+	for id := range visible {
+		ids = append(ids, id)
+	}
+
+	return ids
+}
+
+func sendMemberError(w http.ResponseWriter, err error) {
+	switch err.Error() {
+	case "insufficient_documentation_role":
+		SendJSONResponse(http.StatusForbidden, w, map[string]string{"status": "error", "message": err.Error()})
+	default:
+		SendJSONResponse(http.StatusBadRequest, w, map[string]string{"status": "error", "message": err.Error()})
+	}
+}
+
+func GetDocumentationMembers(dS *services.DocService, w http.ResponseWriter, r *http.Request) {
+	type Request struct {
+		DocumentationID uint `json:"documentationId" validate:"required"`
+	}
+
+	req, err := ValidateRequest[Request](w, r)
+	if err != nil {
+		return
+	}
+
+	members, err := dS.GetDocumentationMembers(req.DocumentationID)
+	if err != nil {
+		SendJSONResponse(http.StatusBadRequest, w, map[string]string{"status": "error", "message": err.Error()})
+		return
+	}
+
+	SendJSONResponse(http.StatusOK, w, members)
+}
+
+func AddDocumentationMember(services *services.ServiceRegistry, w http.ResponseWriter, r *http.Request) {
+	services = services.WithActor(auditActor(r))
+
+	type Request struct {
+		DocumentationID uint   `json:"documentationId" validate:"required"`
+		UserID          uint   `json:"userId" validate:"required"`
+		Role            string `json:"role" validate:"required,oneof=viewer editor maintainer owner"`
+	}
+
+	req, err := ValidateRequest[Request](w, r)
+	if err != nil {
+		return
+	}
+
+	user, ok := requestUser(services.AuthService, w, r)
+	if !ok {
+		return
+	}
+
+	if err := services.DocService.SetDocumentationMember(user, req.DocumentationID, req.UserID, req.Role); err != nil {
+		sendMemberError(w, err)
+		return
+	}
+
+	SendJSONResponse(http.StatusOK, w, map[string]string{"status": "success", "message": "member_saved"})
+}
+
+func RemoveDocumentationMember(services *services.ServiceRegistry, w http.ResponseWriter, r *http.Request) {
+	services = services.WithActor(auditActor(r))
+
+	type Request struct {
+		DocumentationID uint `json:"documentationId" validate:"required"`
+		UserID          uint `json:"userId" validate:"required"`
+	}
+
+	req, err := ValidateRequest[Request](w, r)
+	if err != nil {
+		return
+	}
+
+	user, ok := requestUser(services.AuthService, w, r)
+	if !ok {
+		return
+	}
+
+	if err := services.DocService.RemoveDocumentationMember(user, req.DocumentationID, req.UserID); err != nil {
+		sendMemberError(w, err)
+		return
+	}
+
+	SendJSONResponse(http.StatusOK, w, map[string]string{"status": "success", "message": "member_removed"})
+}
@@ -0,0 +1,290 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"git.difuse.io/Difuse/kalmia/db/models"
+	"git.difuse.io/Difuse/kalmia/services"
+	"git.difuse.io/Difuse/kalmia/utils"
+)
+
+// requestUser returns the user behind the request's token. On failure the
+// response has already been written.
+func requestUser(authService *services.AuthService, w http.ResponseWriter, r *http.Request) (models.User, bool) {
+	token, err := GetTokenFromHeader(r)
+	if err != nil {
+		SendJSONResponse(http.StatusUnauthorized, w, map[string]string{"status": "error", "message": "invalid_request"})
+		return models.User{}, false
+	}
+
+	user, err := authService.GetUserFromToken(token)
+	if err != nil {
+		SendJSONResponse(http.StatusUnauthorized, w, map[string]string{"status": "error", "message": "invalid_request"})
+		return models.User{}, false
+	}
+
+	return user, true
+}
+
+func sendPublishError(w http.ResponseWriter, err error) {
+	switch err.Error() {
+	case "page_not_found", "page_group_not_found", "documentation_not_found", "page_revision_not_found":
+		SendJSONResponse(http.StatusNotFound, w, map[string]string{"status": "error", "message": err.Error()})
+	case "page_not_published", "page_group_not_published", "broken_links":
+		SendJSONResponse(http.StatusConflict, w, map[string]string{"status": "error", "message": err.Error()})
+	default:
+		SendJSONResponse(http.StatusInternalServerError, w, map[string]string{"status": "error", "message": err.Error()})
+	}
+}
+
+func PublishPage(services *services.ServiceRegistry, w http.ResponseWriter, r *http.Request) {
+	services = services.WithActor(auditActor(r))
+
+	type Request struct {
+		ID uint `json:"id" validate:"required"`
+	}
+
+	req, err := ValidateRequest[Request](w, r)
+	if err != nil {
+		return
+	}
+
+	user, ok := requestUser(services.AuthService, w, r)
+	if !ok {
+		return
+	}
+
+	if err := services.DocService.PublishPage(user, req.ID); err != nil {
+		sendPublishError(w, err)
+		return
+	}
+
+	SendJSONResponse(http.StatusOK, w, map[string]string{"status": "success", "message": "page_published"})
+}
+
+func UnpublishPage(services *services.ServiceRegistry, w http.ResponseWriter, r *http.Request) {
+	services = services.WithActor(auditActor(r))
+
+	type Request struct {
+		ID uint `json:"id" validate:"required"`
+	}
+
+	req, err := ValidateRequest[Request](w, r)
+	if err != nil {
+		return
+	}
+
+	user, ok := requestUser(services.AuthService, w, r)
+	if !ok {
+		return
+	}
+
+	if err := services.DocService.UnpublishPage(user, req.ID); err != nil {
+		sendPublishError(w, err)
+		return
+	}
+
+	SendJSONResponse(http.StatusOK, w, map[string]string{"status": "success", "message": "page_unpublished"})
+}
+
+func PublishPageGroup(services *services.ServiceRegistry, w http.ResponseWriter, r *http.Request) {
+	services = services.WithActor(auditActor(r))
+
+	type Request struct {
+		ID uint `json:"id" validate:"required"`
+	}
+
+	req, err := ValidateRequest[Request](w, r)
+	if err != nil {
+		return
+	}
+
+	user, ok := requestUser(services.AuthService, w, r)
+	if !ok {
+		return
+	}
+
+	if err := services.DocService.PublishPageGroup(user, req.ID); err != nil {
+		sendPublishError(w, err)
+		return
+	}
+
+	SendJSONResponse(http.StatusOK, w, map[string]string{"status": "success", "message": "page_group_published"})
+}
+
+func UnpublishPageGroup(services *services.ServiceRegistry, w http.ResponseWriter, r *http.Request) {
+	services = services.WithActor(auditActor(r))
+
+	type Request struct {
+		ID uint `json:"id" validate:"required"`
+	}
+
+	req, err := ValidateRequest[Request](w, r)
+	if err != nil {
+		return
+	}
+
+	user, ok := requestUser(services.AuthService, w, r)
+	if !ok {
+		return
+	}
+
+	if err := services.DocService.UnpublishPageGroup(user, req.ID); err != nil {
+		sendPublishError(w, err)
+		return
+	}
+
+	SendJSONResponse(http.StatusOK, w, map[string]string{"status": "success", "message": "page_group_unpublished"})
+}
+
+func PublishDocumentation(services *services.ServiceRegistry, w http.ResponseWriter, r *http.Request) {
+	services = services.WithActor(auditActor(r))
+
+	type Request struct {
+		ID uint `json:"id" validate:"required"`
+	}
+
+	req, err := ValidateRequest[Request](w, r)
+	if err != nil {
+		return
+	}
+
+	user, ok := requestUser(services.AuthService, w, r)
+	if !ok {
+		return
+	}
+
+	result, err := services.DocService.PublishDocumentation(user, req.ID)
+	if err != nil {
+		sendPublishError(w, err)
+		return
+	}
+
+	SendJSONResponse(http.StatusOK, w, map[string]interface{}{"status": "success", "message": "documentation_published", "pages": result.Pages, "pageGroups": result.PageGroups})
+}
+
+func sendScheduleError(w http.ResponseWriter, err error) {
+	if err.Error() == "invalid_schedule" {
+		SendJSONResponse(http.StatusBadRequest, w, map[string]string{"status": "error", "message": err.Error()})
+		return
+	}
+
+	sendPublishError(w, err)
+}
+
+func SchedulePage(services *services.ServiceRegistry, w http.ResponseWriter, r *http.Request) {
+	services = services.WithActor(auditActor(r))
+
+	type Request struct {
+		ID          uint       `json:"id" validate:"required"`
+		PublishAt   *time.Time `json:"publishAt"`
+		UnpublishAt *time.Time `json:"unpublishAt"`
+	}
+
+	req, err := ValidateRequest[Request](w, r)
+	if err != nil {
+		return
+	}
+
+	user, ok := requestUser(services.AuthService, w, r)
+	if !ok {
+		return
+	}
+
+	if err := services.DocService.SchedulePage(user, req.ID, req.PublishAt, req.UnpublishAt); err != nil {
+		sendScheduleError(w, err)
+		return
+	}
+
+	SendJSONResponse(http.StatusOK, w, map[string]string{"status": "success", "message": "page_scheduled"})
+}
+
+func SchedulePageGroup(services *services.ServiceRegistry, w http.ResponseWriter, r *http.Request) {
+	services = services.WithActor(auditActor(r))
+
+	type Request struct {
+		ID          uint       `json:"id" validate:"required"`
+		PublishAt   *time.Time `json:"publishAt"`
+		UnpublishAt *time.Time `json:"unpublishAt"`
+	}
+
+	req, err := ValidateRequest[Request](w, r)
+	if err != nil {
+		return
+	}
+
+	user, ok := requestUser(services.AuthService, w, r)
+	if !ok {
+		return
+	}
+
+	if err := services.DocService.SchedulePageGroup(user, req.ID, req.PublishAt, req.UnpublishAt); err != nil {
+		sendScheduleError(w, err)
+		return
+	}
+
+	SendJSONResponse(http.StatusOK, w, map[string]string{"status": "success", "message": "page_group_scheduled"})
+}
+
+func ScheduleDocumentation(services *services.ServiceRegistry, w http.ResponseWriter, r *http.Request) {
+	services = services.WithActor(auditActor(r))
+
+	type Request struct {
+		ID          uint       `json:"id" validate:"required"`
+		PublishAt   *time.Time `json:"publishAt"`
+		UnpublishAt *time.Time `json:"unpublishAt"`
+	}
+
+	req, err := ValidateRequest[Request](w, r)
+	if err != nil {
+		return
+	}
+
+	user, ok := requestUser(services.AuthService, w, r)
+	if !ok {
+		return
+	}
+
+	if err := services.DocService.ScheduleDocumentation(user, req.ID, req.PublishAt, req.UnpublishAt); err != nil {
+		sendScheduleError(w, err)
+		return
+	}
+
+	SendJSONResponse(http.StatusOK, w, map[string]string{"status": "success", "message": "documentation_scheduled"})
+}
+
+func GetScheduled(services *services.ServiceRegistry, w http.ResponseWriter, r *http.Request) {
+	visible, ok := visibleDocumentations(services.AuthService, w, r)
+	if !ok {
+		return
+	}
+
+	var docId uint
+	if value := r.URL.Query().Get("documentationId"); value != "" {
+		id, err := utils.StringToUint(value)
+		if err != nil {
+			SendJSONResponse(http.StatusBadRequest, w, map[string]string{"status": "error", "message": "invalid_documentation_id"})
+			return
+		}
+		docId = id
+	}
+
+	items, err := services.DocService.GetScheduled(docId)
+	if err != nil {
+		SendJSONResponse(http.StatusInternalServerError, w, map[string]string{"status": "error", "message": err.Error()})
+		return
+	}
+
+	if visible != nil {
+		filtered := items[:0]
+		for _, item := range items {
+			if visible[item.DocumentationID] {
+				filtered = append(filtered, item)
+			}
+		}
+		items = filtered
+	}
+
+	SendJSONResponse(http.StatusOK, w, items)
+}
@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"net/http"
+
+	"git.difuse.io/Difuse/kalmia/services"
+	"git.difuse.io/Difuse/kalmia/utils"
+)
+
+func sendRedirectError(w http.ResponseWriter, err error) {
+	switch err.Error() {
+	case "redirect_not_found":
+		SendJSONResponse(http.StatusNotFound, w, map[string]string{"status": "error", "message": err.Error()})
+	case "invalid_documentation_id", "invalid_redirect_path", "redirect_loop":
+		SendJSONResponse(http.StatusBadRequest, w, map[string]string{"status": "error", "message": err.Error()})
+	case "redirect_already_exists":
+		SendJSONResponse(http.StatusConflict, w, map[string]string{"status": "error", "message": err.Error()})
+	default:
+		SendJSONResponse(http.StatusInternalServerError, w, map[string]string{"status": "error", "message": err.Error()})
+	}
+}
+
+func GetRedirects(service *services.DocService, w http.ResponseWriter, r *http.Request) {
+	docId, err := utils.StringToUint(r.URL.Query().Get("documentationId"))
+	if err != nil || docId == 0 {
+		SendJSONResponse(http.StatusBadRequest, w, map[string]string{"status": "error", "message": "invalid_documentation_id"})
+		return
+	}
+
+	redirects, err := service.GetRedirects(docId)
+	if err != nil {
+		sendRedirectError(w, err)
+		return
+	}
+
+	SendJSONResponse(http.StatusOK, w, redirects)
+}
+
+func CreateRedirect(service *services.DocService, w http.ResponseWriter, r *http.Request) {
+	service = service.WithActor(auditActor(r))
+
+	type Request struct {
+		DocumentationID uint   `json:"documentationId" validate:"required"`
+		FromPath        string `json:"fromPath" validate:"required"`
+		ToPath          string `json:"toPath" validate:"required"`
+	}
+
+	req, err := ValidateRequest[Request](w, r)
+	if err != nil {
+		return
+	}
+
+	redirect, err := service.CreateRedirect(req.DocumentationID, req.FromPath, req.ToPath)
+	if err != nil {
+		sendRedirectError(w, err)
+		return
+	}
+
+	SendJSONResponse(http.StatusOK, w, redirect)
+}
+
+func EditRedirect(service *services.DocService, w http.ResponseWriter, r *http.Request) {
+	service = service.WithActor(auditActor(r))
+
+	type Request struct {
+		ID       uint   `json:"id" validate:"required"`
+		FromPath string `json:"fromPath" validate:"required"`
+		ToPath   string `json:"toPath" validate:"required"`
+	}
+
+	req, err := ValidateRequest[Request](w, r)
+	if err != nil {
+		return
+	}
+
+	redirect, err := service.EditRedirect(req.ID, req.FromPath, req.ToPath)
+	if err != nil {
+		sendRedirectError(w, err)
+		return
+	}
+
+	SendJSONResponse(http.StatusOK, w, redirect)
+}
+
+func DeleteRedirect(service *services.DocService, w http.ResponseWriter, r *http.Request) {
+	service = service.WithActor(auditActor(r))
+
+	type Request struct {
+		ID uint `json:"id" validate:"required"`
+	}
+
+	req, err := ValidateRequest[Request](w, r)
+	if err != nil {
+		return
+	}
+
+	if err := service.DeleteRedirect(req.ID); err != nil {
+		sendRedirectError(w, err)
+		return
+	}
+
+	SendJSONResponse(http.StatusOK, w, map[string]string{"status": "success", "message": "redirect_deleted"})
+}
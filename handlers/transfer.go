@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"git.difuse.io/Difuse/kalmia/services"
+)
+
+func sendTransferError(w http.ResponseWriter, err error) {
+	switch err.Error() {
+	case "page_not_found", "page_group_not_found":
+		SendJSONResponse(http.StatusNotFound, w, map[string]string{"status": "error", "message": err.Error()})
+	case "invalid_documentation_id", "invalid_page_group_id", "invalid_parent_page_group_id":
+		SendJSONResponse(http.StatusBadRequest, w, map[string]string{"status": "error", "message": err.Error()})
+	case "insufficient_documentation_role":
+		SendJSONResponse(http.StatusForbidden, w, map[string]string{"status": "error", "message": err.Error()})
+	case "locked_by_another_user", "page_group_cannot_be_its_own_parent":
+		SendJSONResponse(http.StatusConflict, w, map[string]string{"status": "error", "message": err.Error()})
+	default:
+		SendJSONResponse(http.StatusInternalServerError, w, map[string]string{"status": "error", "message": err.Error()})
+	}
+}
+
+func CopyPage(services *services.ServiceRegistry, w http.ResponseWriter, r *http.Request) {
+	services = services.WithActor(auditActor(r))
+
+	type Request struct {
+		ID              uint  `json:"id" validate:"required"`
+		DocumentationID uint  `json:"documentationId" validate:"required"`
+		PageGroupID     *uint `json:"pageGroupId"`
+	}
+
+	req, err := ValidateRequest[Request](w, r)
+	if err != nil {
+		return
+	}
+
+	user, ok := requestUser(services.AuthService, w, r)
+	if !ok {
+		return
+	}
+
+	page, err := services.DocService.CopyPage(user, req.ID, req.DocumentationID, req.PageGroupID)
+	if err != nil {
+		sendTransferError(w, err)
+		return
+	}
+
+	SendJSONResponse(http.StatusOK, w, map[string]string{"status": "success", "message": "page_copied", "id": fmt.Sprint(page.ID), "slug": page.Slug})
+}
+
+func MovePage(services *services.ServiceRegistry, w http.ResponseWriter, r *http.Request) {
+	services = services.WithActor(auditActor(r))
+
+	type Request struct {
+		ID              uint  `json:"id" validate:"required"`
+		DocumentationID uint  `json:"documentationId" validate:"required"`
+		PageGroupID     *uint `json:"pageGroupId"`
+	}
+
+	req, err := ValidateRequest[Request](w, r)
+	if err != nil {
+		return
+	}
+
+	user, ok := requestUser(services.AuthService, w, r)
+	if !ok {
+		return
+	}
+
+	page, err := services.DocService.MovePage(user, req.ID, req.DocumentationID, req.PageGroupID)
+	if err != nil {
+		sendTransferError(w, err)
+		return
+	}
+
+	SendJSONResponse(http.StatusOK, w, map[string]string{"status": "success", "message": "page_moved", "id": fmt.Sprint(page.ID), "slug": page.Slug})
+}
+
+func CopyPageGroup(services *services.ServiceRegistry, w http.ResponseWriter, r *http.Request) {
+	services = services.WithActor(auditActor(r))
+
+	type Request struct {
+		ID              uint  `json:"id" validate:"required"`
+		DocumentationID uint  `json:"documentationId" validate:"required"`
+		ParentID        *uint `json:"parentId"`
+	}
+
+	req, err := ValidateRequest[Request](w, r)
+	if err != nil {
+		return
+	}
+
+	user, ok := requestUser(services.AuthService, w, r)
+	if !ok {
+		return
+	}
+
+	id, err := services.DocService.CopyPageGroup(user, req.ID, req.DocumentationID, req.ParentID)
+	if err != nil {
+		sendTransferError(w, err)
+		return
+	}
+
+	SendJSONResponse(http.StatusOK, w, map[string]string{"status": "success", "message": "page_group_copied", "id": fmt.Sprint(id)})
+}
+
+func MovePageGroup(services *services.ServiceRegistry, w http.ResponseWriter, r *http.Request) {
+	services = services.WithActor(auditActor(r))
+
+	type Request struct {
+		ID              uint  `json:"id" validate:"required"`
+		DocumentationID uint  `json:"documentationId" validate:"required"`
+		ParentID        *uint `json:"parentId"`
+	}
+
+	req, err := ValidateRequest[Request](w, r)
+	if err != nil {
+		return
+	}
+
+	user, ok := requestUser(services.AuthService, w, r)
+	if !ok {
+		return
+	}
+
+	if err := services.DocService.MovePageGroup(user, req.ID, req.DocumentationID, req.ParentID); err != nil {
+		sendTransferError(w, err)
+		return
+	}
+
+	SendJSONResponse(http.StatusOK, w, map[string]string{"status": "success", "message": "page_group_moved", "id": fmt.Sprint(req.ID)})
+}
@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"net/http"
+
+	"git.difuse.io/Difuse/kalmia/services"
+	"git.difuse.io/Difuse/kalmia/utils"
+)
+
+func sendTranslationError(w http.ResponseWriter, err error) {
+	switch err.Error() {
+	case "page_not_found", "documentation_not_found":
+		SendJSONResponse(http.StatusNotFound, w, map[string]string{"status": "error", "message": err.Error()})
+	case "invalid_language", "page_is_a_translation", "invalid_page_content":
+		SendJSONResponse(http.StatusBadRequest, w, map[string]string{"status": "error", "message": err.Error()})
+	case "insufficient_documentation_role":
+		SendJSONResponse(http.StatusForbidden, w, map[string]string{"status": "error", "message": err.Error()})
+	case "translation_already_exists", "page_version_conflict":
+		SendJSONResponse(http.StatusConflict, w, map[string]string{"status": "error", "message": err.Error()})
+	case "translator_not_configured":
+		SendJSONResponse(http.StatusNotImplemented, w, map[string]string{"status": "error", "message": err.Error()})
+	case "translation_failed":
+		SendJSONResponse(http.StatusBadGateway, w, map[string]string{"status": "error", "message": err.Error()})
+	default:
+		SendJSONResponse(http.StatusInternalServerError, w, map[string]string{"status": "error", "message": err.Error()})
+	}
+}
+
+func SetDocumentationLanguages(services *services.ServiceRegistry, w http.ResponseWriter, r *http.Request) {
+	services = services.WithActor(auditActor(r))
+
+	type Request struct {
+		ID              uint     `json:"id" validate:"required"`
+		DefaultLanguage string   `json:"defaultLanguage"`
+		Languages       []string `json:"languages"`
+	}
+
+	req, err := ValidateRequest[Request](w, r)
+	if err != nil {
+		return
+	}
+
+	user, ok := requestUser(services.AuthService, w, r)
+	if !ok {
+		return
+	}
+
+	if err := services.DocService.SetDocumentationLanguages(user, req.ID, req.DefaultLanguage, req.Languages); err != nil {
+		sendTranslationError(w, err)
+		return
+	}
+
+	SendJSONResponse(http.StatusOK, w, map[string]string{"status": "success", "message": "documentation_languages_updated"})
+}
+
+func GetTranslationStatus(service *services.DocService, w http.ResponseWriter, r *http.Request) {
+	docId, err := utils.StringToUint(r.URL.Query().Get("documentationId"))
+	if err != nil || docId == 0 {
+		SendJSONResponse(http.StatusBadRequest, w, map[string]string{"status": "error", "message": "invalid_documentation_id"})
+		return
+	}
+
+	statuses, err := service.GetTranslationStatus(docId)
+	if err != nil {
+		sendTranslationError(w, err)
+		return
+	}
+
+	SendJSONResponse(http.StatusOK, w, statuses)
+}
+
+func CreatePageTranslation(services *services.ServiceRegistry, w http.ResponseWriter, r *http.Request) {
+	services = services.WithActor(auditActor(r))
+
+	type Request struct {
+		ID       uint   `json:"id" validate:"required"`
+		Language string `json:"language" validate:"required"`
+		Title    string `json:"title"`
+		Content  string `json:"content"`
+	}
+
+	req, err := ValidateRequest[Request](w, r)
+	if err != nil {
+		return
+	}
+
+	user, ok := requestUser(services.AuthService, w, r)
+	if !ok {
+		return
+	}
+
+	page, err := services.DocService.CreatePageTranslation(user, req.ID, req.Language, req.Title, req.Content)
+	if err != nil {
+		sendTranslationError(w, err)
+		return
+	}
+
+	SendJSONResponse(http.StatusOK, w, map[string]interface{}{"status": "success", "message": "page_translation_created", "id": page.ID})
+}
+
+func PrefillPageTranslation(services *services.ServiceRegistry, w http.ResponseWriter, r *http.Request) {
+	services = services.WithActor(auditActor(r))
+
+	type Request struct {
+		ID       uint   `json:"id" validate:"required"`
+		Language string `json:"language" validate:"required"`
+	}
+
+	req, err := ValidateRequest[Request](w, r)
+	if err != nil {
+		return
+	}
+
+	user, ok := requestUser(services.AuthService, w, r)
+	if !ok {
+		return
+	}
+
+	page, err := services.DocService.PrefillPageTranslation(r.Context(), user, req.ID, req.Language)
+	if err != nil {
+		sendTranslationError(w, err)
+		return
+	}
+
+	SendJSONResponse(http.StatusOK, w, map[string]interface{}{"status": "success", "message": "page_translation_prefilled", "id": page.ID})
+}
@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"net/http"
+
+	"git.difuse.io/Difuse/kalmia/services"
+	"git.difuse.io/Difuse/kalmia/utils"
+)
+
+func sendTrashError(w http.ResponseWriter, err error) {
+	switch err.Error() {
+	case "trash_item_not_found", "documentation_not_found":
+		SendJSONResponse(http.StatusNotFound, w, map[string]string{"status": "error", "message": err.Error()})
+	case "restore_parent_first", "slug_already_in_use":
+		SendJSONResponse(http.StatusConflict, w, map[string]string{"status": "error", "message": err.Error()})
+	case "insufficient_documentation_role":
+		SendJSONResponse(http.StatusForbidden, w, map[string]string{"status": "error", "message": err.Error()})
+	default:
+		SendJSONResponse(http.StatusInternalServerError, w, map[string]string{"status": "error", "message": err.Error()})
+	}
+}
+
+func GetTrash(services *services.ServiceRegistry, w http.ResponseWriter, r *http.Request) {
+	visible, ok := visibleDocumentations(services.AuthService, w, r)
+	if !ok {
+		return
+	}
+
+	var docId uint
+	if value := r.URL.Query().Get("documentationId"); value != "" {
+		id, err := utils.StringToUint(value)
+		if err != nil {
+			SendJSONResponse(http.StatusBadRequest, w, map[string]string{"status": "error", "message": "invalid_documentation_id"})
+			return
+		}
+		docId = id
+	}
+
+	items, err := services.DocService.GetTrash(docId)
+	if err != nil {
+		sendTrashError(w, err)
+		return
+	}
+
+	if visible != nil {
+		filtered := items[:0]
+		for _, item := range items {
+			if visible[item.RootDocumentationID] {
+				filtered = append(filtered, item)
+			}
+		}
+		items = filtered
+	}
+
+	SendJSONResponse(http.StatusOK, w, items)
+}
+
+func RestoreTrashItem(services *services.ServiceRegistry, w http.ResponseWriter, r *http.Request) {
+	services = services.WithActor(auditActor(r))
+
+	type Request struct {
+		ID uint `json:"id" validate:"required"`
+	}
+
+	req, err := ValidateRequest[Request](w, r)
+	if err != nil {
+		return
+	}
+
+	user, ok := requestUser(services.AuthService, w, r)
+	if !ok {
+		return
+	}
+
+	if err := services.DocService.RestoreTrashItem(user, req.ID); err != nil {
+		sendTrashError(w, err)
+		return
+	}
+
+	SendJSONResponse(http.StatusOK, w, map[string]string{"status": "success", "message": "trash_item_restored"})
+}
+
+func PurgeTrashItem(services *services.ServiceRegistry, w http.ResponseWriter, r *http.Request) {
+	services = services.WithActor(auditActor(r))
+
+	type Request struct {
+		ID uint `json:"id" validate:"required"`
+	}
+
+	req, err := ValidateRequest[Request](w, r)
+	if err != nil {
+		return
+	}
+
+	user, ok := requestUser(services.AuthService, w, r)
+	if !ok {
+		return
+	}
+
+	if err := services.DocService.PurgeTrashItem(user, req.ID); err != nil {
+		sendTrashError(w, err)
+		return
+	}
+
+	SendJSONResponse(http.StatusOK, w, map[string]string{"status": "success", "message": "trash_item_purged"})
+}
@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"net/http"
+
+	"git.difuse.io/Difuse/kalmia/services"
+	"git.difuse.io/Difuse/kalmia/utils"
+)
+
+func GetWebhooks(dS *services.DocService, w http.ResponseWriter, r *http.Request) {
+	var documentationId uint
+	if value := r.URL.Query().Get("documentationId"); value != "" {
+		id, err := utils.StringToUint(value)
+		if err != nil {
+			SendJSONResponse(http.StatusBadRequest, w, map[string]string{"status": "error", "message": "invalid_documentation_id"})
+			return
+		}
+		documentationId = id
+	}
+
+	webhooks, err := dS.GetWebhooks(documentationId)
+	if err != nil {
+		SendJSONResponse(http.StatusInternalServerError, w, map[string]string{"status": "error", "message": err.Error()})
+		return
+	}
+
+	SendJSONResponse(http.StatusOK, w, webhooks)
+}
+
+func CreateWebhook(services *services.ServiceRegistry, w http.ResponseWriter, r *http.Request) {
+	services = services.WithActor(auditActor(r))
+
+	type Request struct {
+		DocumentationID *uint    `json:"documentationId"`
+		URL             string   `json:"url" validate:"required,url"`
+		Secret          string   `json:"secret"`
+		Description     string   `json:"description"`
+		Events          []string `json:"events"`
+	}
+
+	req, err := ValidateRequest[Request](w, r)
+	if err != nil {
+		return
+	}
+
+	token, err := GetTokenFromHeader(r)
+	if err != nil {
+		SendJSONResponse(http.StatusUnauthorized, w, map[string]string{"status": "error", "message": "invalid_request"})
+		return
+	}
+
+	user, err := services.AuthService.GetUserFromToken(token)
+	if err != nil {
+		SendJSONResponse(http.StatusUnauthorized, w, map[string]string{"status": "error", "message": "invalid_request"})
+		return
+	}
+
+	webhook, err := services.DocService.CreateWebhook(user, req.DocumentationID, req.URL, req.Secret, req.Description, req.Events)
+	if err != nil {
+		SendJSONResponse(http.StatusBadRequest, w, map[string]string{"status": "error", "message": err.Error()})
+		return
+	}
+
+	// The secret isn't part of the webhook's JSON, this is the one time it's
+	// handed out.
+	SendJSONResponse(http.StatusOK, w, map[string]interface{}{"status": "success", "message": "webhook_created", "webhook": webhook, "secret": webhook.Secret})
+}
+
+func EditWebhook(dS *services.DocService, w http.ResponseWriter, r *http.Request) {
+	dS = dS.WithActor(auditActor(r))
+
+	type Request struct {
+		ID          uint     `json:"id" validate:"required"`
+		URL         string   `json:"url"`
+		Secret      string   `json:"secret"`
+		Description string   `json:"description"`
+		Events      []string `json:"events"`
+		Active      *bool    `json:"active"`
+	}
+
+	req, err := ValidateRequest[Request](w, r)
+	if err != nil {
+		return
+	}
+
+	if err := dS.EditWebhook(req.ID, req.URL, req.Secret, req.Description, req.Events, req.Active); err != nil {
+		SendJSONResponse(http.StatusBadRequest, w, map[string]string{"status": "error", "message": err.Error()})
+		return
+	}
+
+	SendJSONResponse(http.StatusOK, w, map[string]string{"status": "success", "message": "webhook_updated"})
+}
+
+func DeleteWebhook(dS *services.DocService, w http.ResponseWriter, r *http.Request) {
+	dS = dS.WithActor(auditActor(r))
+
+	type Request struct {
+		ID uint `json:"id" validate:"required"`
+	}
+
+	req, err := ValidateRequest[Request](w, r)
+	if err != nil {
+		return
+	}
+
+	if err := dS.DeleteWebhook(req.ID); err != nil {
+		SendJSONResponse(http.StatusBadRequest, w, map[string]string{"status": "error", "message": err.Error()})
+		return
+	}
+
+	SendJSONResponse(http.StatusOK, w, map[string]string{"status": "success", "message": "webhook_deleted"})
+}
+
+func GetWebhookDeliveries(dS *services.DocService, w http.ResponseWriter, r *http.Request) {
+	type Request struct {
+		WebhookID uint   `json:"webhookId"`
+		Status    string `json:"status"`
+		Limit     int    `json:"limit"`
+		Offset    int    `json:"offset"`
+	}
+
+	req, err := ValidateRequest[Request](w, r)
+	if err != nil {
+		return
+	}
+
+	deliveries, total, err := dS.GetWebhookDeliveries(services.WebhookDeliveryOptions{
+		WebhookID: req.WebhookID,
+		Status:    req.Status,
+		Limit:     req.Limit,
+		Offset:    req.Offset,
+	})
+	if err != nil {
+		SendJSONResponse(http.StatusInternalServerError, w, map[string]string{"status": "error", "message": err.Error()})
+		return
+	}
+
+	SendJSONResponse(http.StatusOK, w, map[string]interface{}{"total": total, "deliveries": deliveries})
+}
+
+func GetWebhookDelivery(dS *services.DocService, w http.ResponseWriter, r *http.Request) {
+	type Request struct {
+		ID uint `json:"id" validate:"required"`
+	}
+
+	req, err := ValidateRequest[Request](w, r)
+	if err != nil {
+		return
+	}
+
+	delivery, err := dS.GetWebhookDelivery(req.ID)
+	if err != nil {
+		if err.Error() == "webhook_delivery_not_found" {
+			SendJSONResponse(http.StatusNotFound, w, map[string]string{"status": "error", "message": err.Error()})
+			return
+		}
+
+		SendJSONResponse(http.StatusInternalServerError, w, map[string]string{"status": "error", "message": err.Error()})
+		return
+	}
+
+	SendJSONResponse(http.StatusOK, w, delivery)
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"embed"
 	"fmt"
 	"io"
@@ -8,7 +9,6 @@ import (
 	"os"
 	"os/signal"
 	"sync"
-	"time"
 
 	"git.difuse.io/Difuse/kalmia/cmd"
 	"git.difuse.io/Difuse/kalmia/config"
@@ -45,16 +45,43 @@ func main() {
 	startupWg.Add(1)
 	go func() {
 		dS.StartupCheck()
+
+		if err := dS.EnsureSearchIndex(); err != nil {
+			logger.Error("Failed to build search index", zap.Error(err))
+		}
+
 		startupWg.Done()
 	}()
 
+	buildQueue := services.NewBuildQueue(dS, cfg.BuildQueue)
+	webhookDispatcher := services.NewWebhookDispatcher(dS)
+	publishScheduler := services.NewPublishScheduler(dS)
+	trashPurger := services.NewTrashPurger(dS)
+	collaborationHub := services.NewCollaborationHub(dS)
+
 	go func() {
 		startupWg.Wait()
-		for {
-			dS.DeleteJob()
-			dS.BuildJob()
-			time.Sleep(10 * time.Second)
-		}
+		buildQueue.Start(context.Background())
+	}()
+
+	go func() {
+		startupWg.Wait()
+		webhookDispatcher.Start(context.Background())
+	}()
+
+	go func() {
+		startupWg.Wait()
+		publishScheduler.Start(context.Background())
+	}()
+
+	go func() {
+		startupWg.Wait()
+		collaborationHub.Start(context.Background())
+	}()
+
+	go func() {
+		startupWg.Wait()
+		trashPurger.Start(context.Background())
 	}()
 
 	/* Setup router */
@@ -71,6 +98,19 @@ func main() {
 	healthRouter.HandleFunc("/ping", handlers.HealthPing).Methods("GET")
 	healthRouter.HandleFunc("/last-trigger", func(w http.ResponseWriter, r *http.Request) { handlers.TriggerCheck(dS, w, r) }).Methods("GET")
 
+	// INFO: EventSource and browser websockets can't set headers, streams are
+	// opened with a single-use ?ticket= from /auth/stream-ticket instead
+	streamAuth := func(handler http.HandlerFunc) http.Handler {
+		return middleware.StreamTicketFromQuery(aS)(middleware.EnsureAuthenticated(aS)(handler))
+	}
+
+	// INFO: build logs can contain paths and package output, keep them behind auth
+	healthRouter.Handle("/builds/events", streamAuth(func(w http.ResponseWriter, r *http.Request) { handlers.BuildEvents(serviceRegistry, w, r) })).Methods("GET")
+	buildsRouter := healthRouter.PathPrefix("/builds").Subrouter()
+	buildsRouter.Use(middleware.EnsureAuthenticated(aS))
+	buildsRouter.HandleFunc("", func(w http.ResponseWriter, r *http.Request) { handlers.GetBuilds(serviceRegistry, w, r) }).Methods("GET")
+	buildsRouter.HandleFunc("/{id}/log", func(w http.ResponseWriter, r *http.Request) { handlers.GetBuildLog(dS, w, r) }).Methods("GET")
+
 	oAuthRouter := kRouter.PathPrefix("/oauth").Subrouter()
 	oAuthRouter.HandleFunc("/github", func(w http.ResponseWriter, r *http.Request) { handlers.GithubLogin(aS, w, r) }).Methods("GET")
 	oAuthRouter.HandleFunc("/github/callback", func(w http.ResponseWriter, r *http.Request) { handlers.GithubCallback(aS, w, r) }).Methods("GET")
@@ -97,15 +137,69 @@ func main() {
 	authRouter.HandleFunc("/jwt/validate", func(w http.ResponseWriter, r *http.Request) { handlers.ValidateJWT(aS, w, r) }).Methods("POST")
 	authRouter.HandleFunc("/jwt/revoke", func(w http.ResponseWriter, r *http.Request) { handlers.RevokeJWT(aS, w, r) }).Methods("POST")
 
+	authRouter.HandleFunc("/tokens", func(w http.ResponseWriter, r *http.Request) { handlers.GetAPITokens(aS, w, r) }).Methods("GET")
+	authRouter.HandleFunc("/token/create", func(w http.ResponseWriter, r *http.Request) { handlers.CreateAPIToken(aS, w, r) }).Methods("POST")
+	authRouter.HandleFunc("/token/revoke", func(w http.ResponseWriter, r *http.Request) { handlers.RevokeAPIToken(aS, w, r) }).Methods("POST")
+	authRouter.HandleFunc("/stream-ticket", func(w http.ResponseWriter, r *http.Request) { handlers.CreateStreamTicket(aS, w, r) }).Methods("POST")
+
+	authRouter.HandleFunc("/groups", func(w http.ResponseWriter, r *http.Request) { handlers.GetGroups(aS, w, r) }).Methods("GET")
+	authRouter.HandleFunc("/groups/create", func(w http.ResponseWriter, r *http.Request) { handlers.CreateGroup(aS, w, r) }).Methods("POST")
+	authRouter.HandleFunc("/groups/edit", func(w http.ResponseWriter, r *http.Request) { handlers.EditGroup(aS, w, r) }).Methods("POST")
+	authRouter.HandleFunc("/groups/delete", func(w http.ResponseWriter, r *http.Request) { handlers.DeleteGroup(aS, w, r) }).Methods("POST")
+	authRouter.HandleFunc("/groups/members/add", func(w http.ResponseWriter, r *http.Request) { handlers.AddGroupMember(aS, w, r) }).Methods("POST")
+	authRouter.HandleFunc("/groups/members/remove", func(w http.ResponseWriter, r *http.Request) { handlers.RemoveGroupMember(aS, w, r) }).Methods("POST")
+	authRouter.HandleFunc("/groups/grants/add", func(w http.ResponseWriter, r *http.Request) { handlers.GrantGroupDocumentation(aS, w, r) }).Methods("POST")
+	authRouter.HandleFunc("/groups/grants/remove", func(w http.ResponseWriter, r *http.Request) { handlers.RevokeGroupDocumentation(aS, w, r) }).Methods("POST")
+
+	auditRouter := kRouter.PathPrefix("/audit").Subrouter()
+	auditRouter.Use(middleware.EnsureAuthenticated(aS))
+	auditRouter.HandleFunc("", func(w http.ResponseWriter, r *http.Request) { handlers.GetAuditLogs(aS, w, r) }).Methods("GET")
+	auditRouter.HandleFunc("/export", func(w http.ResponseWriter, r *http.Request) { handlers.ExportAuditLogs(aS, w, r) }).Methods("GET")
+
+	collabRouter := kRouter.PathPrefix("/collab").Subrouter()
+	collabRouter.Handle("/page", streamAuth(func(w http.ResponseWriter, r *http.Request) {
+		handlers.Collaborate(collaborationHub, serviceRegistry, w, r)
+	})).Methods("GET")
+
 	docsRouter := kRouter.PathPrefix("/docs").Subrouter()
 	docsRouter.Use(middleware.EnsureAuthenticated(aS))
-	docsRouter.HandleFunc("/documentations", func(w http.ResponseWriter, r *http.Request) { handlers.GetDocumentations(dS, w, r) }).Methods("GET")
+	docsRouter.HandleFunc("/documentations", func(w http.ResponseWriter, r *http.Request) { handlers.GetDocumentations(serviceRegistry, w, r) }).Methods("GET")
 	docsRouter.HandleFunc("/documentation", func(w http.ResponseWriter, r *http.Request) { handlers.GetDocumentation(dS, w, r) }).Methods("POST")
 	docsRouter.HandleFunc("/documentation/create", func(w http.ResponseWriter, r *http.Request) { handlers.CreateDocumentation(serviceRegistry, w, r) }).Methods("POST")
 	docsRouter.HandleFunc("/documentation/edit", func(w http.ResponseWriter, r *http.Request) { handlers.EditDocumentation(serviceRegistry, w, r) }).Methods("POST")
-	docsRouter.HandleFunc("/documentation/delete", func(w http.ResponseWriter, r *http.Request) { handlers.DeleteDocumentation(dS, w, r) }).Methods("POST")
+	docsRouter.HandleFunc("/documentation/delete", func(w http.ResponseWriter, r *http.Request) { handlers.DeleteDocumentation(serviceRegistry, w, r) }).Methods("POST")
 	docsRouter.HandleFunc("/documentation/version", func(w http.ResponseWriter, r *http.Request) { handlers.CreateDocumentationVersion(dS, w, r) }).Methods("POST")
 	docsRouter.HandleFunc("/documentation/reorder-bulk", func(w http.ResponseWriter, r *http.Request) { handlers.BulkReorderPageOrPageGroup(dS, w, r) }).Methods("POST")
+	docsRouter.HandleFunc("/documentation/export", func(w http.ResponseWriter, r *http.Request) {
+		handlers.ExportDocumentation(dS, w, r, config.ParsedConfig)
+	}).Methods("POST")
+	docsRouter.HandleFunc("/documentation/import", func(w http.ResponseWriter, r *http.Request) {
+		handlers.ImportDocumentation(serviceRegistry, w, r, config.ParsedConfig)
+	}).Methods("POST")
+	docsRouter.HandleFunc("/documentation/members", func(w http.ResponseWriter, r *http.Request) { handlers.GetDocumentationMembers(dS, w, r) }).Methods("POST")
+	docsRouter.HandleFunc("/documentation/members/add", func(w http.ResponseWriter, r *http.Request) { handlers.AddDocumentationMember(serviceRegistry, w, r) }).Methods("POST")
+	docsRouter.HandleFunc("/documentation/members/remove", func(w http.ResponseWriter, r *http.Request) {
+		handlers.RemoveDocumentationMember(serviceRegistry, w, r)
+	}).Methods("POST")
+	docsRouter.HandleFunc("/documentation/link-check", func(w http.ResponseWriter, r *http.Request) { handlers.CheckLinks(dS, w, r) }).Methods("POST")
+	docsRouter.HandleFunc("/documentation/publish", func(w http.ResponseWriter, r *http.Request) { handlers.PublishDocumentation(serviceRegistry, w, r) }).Methods("POST")
+	docsRouter.HandleFunc("/documentation/schedule", func(w http.ResponseWriter, r *http.Request) { handlers.ScheduleDocumentation(serviceRegistry, w, r) }).Methods("POST")
+	docsRouter.HandleFunc("/documentation/approvals", func(w http.ResponseWriter, r *http.Request) { handlers.SetRequiredApprovals(serviceRegistry, w, r) }).Methods("POST")
+	docsRouter.HandleFunc("/trash", func(w http.ResponseWriter, r *http.Request) { handlers.GetTrash(serviceRegistry, w, r) }).Methods("GET")
+	docsRouter.HandleFunc("/trash/restore", func(w http.ResponseWriter, r *http.Request) { handlers.RestoreTrashItem(serviceRegistry, w, r) }).Methods("POST")
+	docsRouter.HandleFunc("/trash/purge", func(w http.ResponseWriter, r *http.Request) { handlers.PurgeTrashItem(serviceRegistry, w, r) }).Methods("POST")
+	docsRouter.HandleFunc("/redirects", func(w http.ResponseWriter, r *http.Request) { handlers.GetRedirects(dS, w, r) }).Methods("GET")
+	docsRouter.HandleFunc("/redirect/create", func(w http.ResponseWriter, r *http.Request) { handlers.CreateRedirect(dS, w, r) }).Methods("POST")
+	docsRouter.HandleFunc("/redirect/edit", func(w http.ResponseWriter, r *http.Request) { handlers.EditRedirect(dS, w, r) }).Methods("POST")
+	docsRouter.HandleFunc("/redirect/delete", func(w http.ResponseWriter, r *http.Request) { handlers.DeleteRedirect(dS, w, r) }).Methods("POST")
+	docsRouter.HandleFunc("/documentation/languages", func(w http.ResponseWriter, r *http.Request) {
+		handlers.SetDocumentationLanguages(serviceRegistry, w, r)
+	}).Methods("POST")
+	docsRouter.HandleFunc("/documentation/translations", func(w http.ResponseWriter, r *http.Request) { handlers.GetTranslationStatus(dS, w, r) }).Methods("GET")
+	docsRouter.HandleFunc("/page/translate", func(w http.ResponseWriter, r *http.Request) { handlers.CreatePageTranslation(serviceRegistry, w, r) }).Methods("POST")
+	docsRouter.HandleFunc("/page/translate/prefill", func(w http.ResponseWriter, r *http.Request) { handlers.PrefillPageTranslation(serviceRegistry, w, r) }).Methods("POST")
+	docsRouter.HandleFunc("/search", func(w http.ResponseWriter, r *http.Request) { handlers.Search(serviceRegistry, w, r) }).Methods("POST")
+	docsRouter.HandleFunc("/build/cancel", func(w http.ResponseWriter, r *http.Request) { handlers.CancelBuild(dS, w, r) }).Methods("POST")
 	docsRouter.HandleFunc("/documentation/root-parent-id", func(w http.ResponseWriter, r *http.Request) { handlers.GetRootParentId(dS, w, r) }).Methods("GET")
 
 	importRouter := docsRouter.PathPrefix("/import").Subrouter()
@@ -113,18 +207,84 @@ func main() {
 	importRouter.HandleFunc("/gitbook", func(w http.ResponseWriter, r *http.Request) {
 		handlers.ImportGitbook(serviceRegistry, w, r, config.ParsedConfig)
 	}).Methods("POST")
+	importRouter.HandleFunc("/markdown", func(w http.ResponseWriter, r *http.Request) {
+		handlers.ImportMarkdown(serviceRegistry, w, r, config.ParsedConfig)
+	}).Methods("POST")
 
-	docsRouter.HandleFunc("/pages", func(w http.ResponseWriter, r *http.Request) { handlers.GetPages(dS, w, r) }).Methods("GET")
+	docsRouter.HandleFunc("/webhooks", func(w http.ResponseWriter, r *http.Request) { handlers.GetWebhooks(dS, w, r) }).Methods("GET")
+	docsRouter.HandleFunc("/webhook/create", func(w http.ResponseWriter, r *http.Request) { handlers.CreateWebhook(serviceRegistry, w, r) }).Methods("POST")
+	docsRouter.HandleFunc("/webhook/edit", func(w http.ResponseWriter, r *http.Request) { handlers.EditWebhook(dS, w, r) }).Methods("POST")
+	docsRouter.HandleFunc("/webhook/delete", func(w http.ResponseWriter, r *http.Request) { handlers.DeleteWebhook(dS, w, r) }).Methods("POST")
+	docsRouter.HandleFunc("/webhook/deliveries", func(w http.ResponseWriter, r *http.Request) { handlers.GetWebhookDeliveries(dS, w, r) }).Methods("POST")
+	docsRouter.HandleFunc("/webhook/delivery", func(w http.ResponseWriter, r *http.Request) { handlers.GetWebhookDelivery(dS, w, r) }).Methods("POST")
+
+	docsRouter.HandleFunc("/change-requests", func(w http.ResponseWriter, r *http.Request) { handlers.GetChangeRequests(dS, w, r) }).Methods("POST")
+	docsRouter.HandleFunc("/change-request", func(w http.ResponseWriter, r *http.Request) { handlers.GetChangeRequest(dS, w, r) }).Methods("POST")
+	docsRouter.HandleFunc("/change-request/create", func(w http.ResponseWriter, r *http.Request) { handlers.CreateChangeRequest(serviceRegistry, w, r) }).Methods("POST")
+	docsRouter.HandleFunc("/change-request/review", func(w http.ResponseWriter, r *http.Request) { handlers.ReviewChangeRequest(serviceRegistry, w, r) }).Methods("POST")
+	docsRouter.HandleFunc("/change-request/close", func(w http.ResponseWriter, r *http.Request) { handlers.CloseChangeRequest(serviceRegistry, w, r) }).Methods("POST")
+
+	docsRouter.HandleFunc("/page/threads", func(w http.ResponseWriter, r *http.Request) { handlers.GetPageCommentThreads(dS, w, r) }).Methods("POST")
+	docsRouter.HandleFunc("/documentation/threads", func(w http.ResponseWriter, r *http.Request) { handlers.GetOpenCommentThreads(dS, w, r) }).Methods("POST")
+	docsRouter.HandleFunc("/threads/mentions", func(w http.ResponseWriter, r *http.Request) {
+		handlers.GetMentionedCommentThreads(serviceRegistry, w, r)
+	}).Methods("GET")
+	docsRouter.HandleFunc("/thread", func(w http.ResponseWriter, r *http.Request) { handlers.GetCommentThread(dS, w, r) }).Methods("POST")
+	docsRouter.HandleFunc("/thread/create", func(w http.ResponseWriter, r *http.Request) { handlers.CreateCommentThread(serviceRegistry, w, r) }).Methods("POST")
+	docsRouter.HandleFunc("/thread/reply", func(w http.ResponseWriter, r *http.Request) { handlers.ReplyToCommentThread(serviceRegistry, w, r) }).Methods("POST")
+	docsRouter.HandleFunc("/thread/resolve", func(w http.ResponseWriter, r *http.Request) { handlers.ResolveCommentThread(serviceRegistry, w, r) }).Methods("POST")
+	docsRouter.HandleFunc("/thread/unresolve", func(w http.ResponseWriter, r *http.Request) { handlers.UnresolveCommentThread(serviceRegistry, w, r) }).Methods("POST")
+
+	docsRouter.HandleFunc("/pages", func(w http.ResponseWriter, r *http.Request) { handlers.GetPages(serviceRegistry, w, r) }).Methods("GET")
 	docsRouter.HandleFunc("/page", func(w http.ResponseWriter, r *http.Request) { handlers.GetPage(dS, w, r) }).Methods("POST")
 	docsRouter.HandleFunc("/page/create", func(w http.ResponseWriter, r *http.Request) { handlers.CreatePage(serviceRegistry, w, r) }).Methods("POST")
 	docsRouter.HandleFunc("/page/edit", func(w http.ResponseWriter, r *http.Request) { handlers.EditPage(serviceRegistry, w, r) }).Methods("POST")
-	docsRouter.HandleFunc("/page/delete", func(w http.ResponseWriter, r *http.Request) { handlers.DeletePage(dS, w, r) }).Methods("POST")
-
-	docsRouter.HandleFunc("/page-groups", func(w http.ResponseWriter, r *http.Request) { handlers.GetPageGroups(dS, w, r) }).Methods("GET")
+	docsRouter.HandleFunc("/page/lock", func(w http.ResponseWriter, r *http.Request) {
+		handlers.AcquireLock(serviceRegistry, services.ResourcePage, w, r)
+	}).Methods("POST")
+	docsRouter.HandleFunc("/page/lock/heartbeat", func(w http.ResponseWriter, r *http.Request) {
+		handlers.HeartbeatLock(serviceRegistry, services.ResourcePage, w, r)
+	}).Methods("POST")
+	docsRouter.HandleFunc("/page/unlock", func(w http.ResponseWriter, r *http.Request) {
+		handlers.ReleaseLock(serviceRegistry, services.ResourcePage, w, r)
+	}).Methods("POST")
+	docsRouter.HandleFunc("/page/force-unlock", func(w http.ResponseWriter, r *http.Request) {
+		handlers.ForceUnlock(serviceRegistry, services.ResourcePage, w, r)
+	}).Methods("POST")
+	docsRouter.HandleFunc("/page/copy", func(w http.ResponseWriter, r *http.Request) { handlers.CopyPage(serviceRegistry, w, r) }).Methods("POST")
+	docsRouter.HandleFunc("/page/move", func(w http.ResponseWriter, r *http.Request) { handlers.MovePage(serviceRegistry, w, r) }).Methods("POST")
+	docsRouter.HandleFunc("/page/delete", func(w http.ResponseWriter, r *http.Request) { handlers.DeletePage(serviceRegistry, w, r) }).Methods("POST")
+	docsRouter.HandleFunc("/page/revisions", func(w http.ResponseWriter, r *http.Request) { handlers.GetPageRevisions(dS, w, r) }).Methods("POST")
+	docsRouter.HandleFunc("/page/revision", func(w http.ResponseWriter, r *http.Request) { handlers.GetPageRevision(dS, w, r) }).Methods("POST")
+	docsRouter.HandleFunc("/page/revision/diff", func(w http.ResponseWriter, r *http.Request) { handlers.DiffPageRevisions(dS, w, r) }).Methods("POST")
+	docsRouter.HandleFunc("/page/revision/restore", func(w http.ResponseWriter, r *http.Request) { handlers.RestorePageRevision(serviceRegistry, w, r) }).Methods("POST")
+	docsRouter.HandleFunc("/page/publish", func(w http.ResponseWriter, r *http.Request) { handlers.PublishPage(serviceRegistry, w, r) }).Methods("POST")
+	docsRouter.HandleFunc("/page/unpublish", func(w http.ResponseWriter, r *http.Request) { handlers.UnpublishPage(serviceRegistry, w, r) }).Methods("POST")
+	docsRouter.HandleFunc("/page/schedule", func(w http.ResponseWriter, r *http.Request) { handlers.SchedulePage(serviceRegistry, w, r) }).Methods("POST")
+
+	docsRouter.HandleFunc("/page-groups", func(w http.ResponseWriter, r *http.Request) { handlers.GetPageGroups(serviceRegistry, w, r) }).Methods("GET")
 	docsRouter.HandleFunc("/page-group", func(w http.ResponseWriter, r *http.Request) { handlers.GetPageGroup(dS, w, r) }).Methods("POST")
 	docsRouter.HandleFunc("/page-group/create", func(w http.ResponseWriter, r *http.Request) { handlers.CreatePageGroup(serviceRegistry, w, r) }).Methods("POST")
 	docsRouter.HandleFunc("/page-group/edit", func(w http.ResponseWriter, r *http.Request) { handlers.EditPageGroup(serviceRegistry, w, r) }).Methods("POST")
-	docsRouter.HandleFunc("/page-group/delete", func(w http.ResponseWriter, r *http.Request) { handlers.DeletePageGroup(dS, w, r) }).Methods("POST")
+	docsRouter.HandleFunc("/page-group/lock", func(w http.ResponseWriter, r *http.Request) {
+		handlers.AcquireLock(serviceRegistry, services.ResourcePageGroup, w, r)
+	}).Methods("POST")
+	docsRouter.HandleFunc("/page-group/lock/heartbeat", func(w http.ResponseWriter, r *http.Request) {
+		handlers.HeartbeatLock(serviceRegistry, services.ResourcePageGroup, w, r)
+	}).Methods("POST")
+	docsRouter.HandleFunc("/page-group/unlock", func(w http.ResponseWriter, r *http.Request) {
+		handlers.ReleaseLock(serviceRegistry, services.ResourcePageGroup, w, r)
+	}).Methods("POST")
+	docsRouter.HandleFunc("/page-group/force-unlock", func(w http.ResponseWriter, r *http.Request) {
+		handlers.ForceUnlock(serviceRegistry, services.ResourcePageGroup, w, r)
+	}).Methods("POST")
+	docsRouter.HandleFunc("/page-group/copy", func(w http.ResponseWriter, r *http.Request) { handlers.CopyPageGroup(serviceRegistry, w, r) }).Methods("POST")
+	docsRouter.HandleFunc("/page-group/move", func(w http.ResponseWriter, r *http.Request) { handlers.MovePageGroup(serviceRegistry, w, r) }).Methods("POST")
+	docsRouter.HandleFunc("/page-group/delete", func(w http.ResponseWriter, r *http.Request) { handlers.DeletePageGroup(serviceRegistry, w, r) }).Methods("POST")
+	docsRouter.HandleFunc("/page-group/publish", func(w http.ResponseWriter, r *http.Request) { handlers.PublishPageGroup(serviceRegistry, w, r) }).Methods("POST")
+	docsRouter.HandleFunc("/page-group/unpublish", func(w http.ResponseWriter, r *http.Request) { handlers.UnpublishPageGroup(serviceRegistry, w, r) }).Methods("POST")
+	docsRouter.HandleFunc("/page-group/schedule", func(w http.ResponseWriter, r *http.Request) { handlers.SchedulePageGroup(serviceRegistry, w, r) }).Methods("POST")
+	docsRouter.HandleFunc("/scheduled", func(w http.ResponseWriter, r *http.Request) { handlers.GetScheduled(serviceRegistry, w, r) }).Methods("GET")
 	// rsPressMiddleware := middleware.RsPressMiddleware(dS)
 	// r.PathPrefix("/").Handler(rsPressMiddleware(spaHandler))
 
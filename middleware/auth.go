@@ -1,85 +1,257 @@
-package middleware
-
-import (
-	"net/http"
-
-	"git.difuse.io/Difuse/kalmia/handlers"
-	"git.difuse.io/Difuse/kalmia/services"
-	"git.difuse.io/Difuse/kalmia/utils"
-)
-
-func EnsureAuthenticated(authService *services.AuthService) func(http.Handler) http.Handler {
-	return func(next http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			if r.URL.Path == "/kal-api/auth/jwt/create" ||
-				r.URL.Path == "/kal-api/auth/jwt/validate" ||
-				r.URL.Path == "/admin/error" ||
-				r.URL.Path == "/admin/404" {
-				next.ServeHTTP(w, r)
-				return
-			}
-
-			token, err := handlers.GetTokenFromHeader(r)
-
-			if err != nil || !authService.VerifyTokenInDb(token, false) {
-				handlers.SendJSONResponse(http.StatusUnauthorized, w, map[string]string{"error": "invalid_token"})
-				return
-			}
-
-			isAdminToken := authService.IsTokenAdmin(token)
-			permissions, err := authService.GetUserPermissions(token)
-
-			if err != nil {
-				handlers.SendJSONResponse(http.StatusInternalServerError, w, map[string]string{"error": "user_permissions_error"})
-				return
-			}
-
-			if !hasPermissionForRoute(r.URL.Path, permissions, isAdminToken) {
-				handlers.SendJSONResponse(http.StatusUnauthorized, w, map[string]string{"error": "user_unauthorized_route"})
-				return
-			}
-
-			next.ServeHTTP(w, r)
-		})
-	}
-}
-
-func hasPermissionForRoute(path string, permissions []string, isAdmin bool) bool {
-	if isAdmin {
-		return true
-	}
-
-	routePermissions := map[string]string{
-		"/kal-api/auth/user":                       "read",
-		"/kal-api/auth/users":                      "read",
-		"/kal-api/auth/user/edit":                  "read",
-		"/kal-api/auth/jwt/revoke":                 "read",
-		"/kal-api/auth/jwt/validate":               "read",
-		"/kal-api/auth/user/upload-file":           "read",
-		"/kal-api/docs/documentations":             "read",
-		"/kal-api/docs/pages":                      "read",
-		"/kal-api/docs/page-groups":                "read",
-		"/kal-api/docs/documentation":              "read",
-		"/kal-api/docs/page":                       "read",
-		"/kal-api/docs/page-group":                 "read",
-		"/kal-api/docs/documentation/create":       "write",
-		"/kal-api/docs/documentation/edit":         "write",
-		"/kal-api/docs/documentation/version":      "write",
-		"/kal-api/docs/documentation/reorder-bulk": "write",
-		"/kal-api/docs/page/create":                "write",
-		"/kal-api/docs/page/edit":                  "write",
-		"/kal-api/docs/page-group/create":          "write",
-		"/kal-api/docs/page-group/edit":            "write",
-		"/kal-api/docs/documentation/delete":       "delete",
-		"/kal-api/docs/page/delete":                "delete",
-		"/kal-api/docs/page-group/delete":          "delete",
-	}
-
-	requiredPermission, exists := routePermissions[path]
-
-	if !exists {
-		return false
-	}
-
-	return utils.ArrayContains(permissions, requiredPermission)
-}
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"git.difuse.io/Difuse/kalmia/handlers"
+	"git.difuse.io/Difuse/kalmia/services"
+	"git.difuse.io/Difuse/kalmia/utils"
+)
+
+func EnsureAuthenticated(authService *services.AuthService) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/kal-api/auth/jwt/create" ||
+				r.URL.Path == "/kal-api/auth/jwt/validate" ||
+				r.URL.Path == "/admin/error" ||
+				r.URL.Path == "/admin/404" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			token, err := handlers.GetTokenFromHeader(r)
+
+			if err != nil || !authService.VerifyTokenInDb(token, false) {
+				handlers.SendJSONResponse(http.StatusUnauthorized, w, map[string]string{"error": "invalid_token"})
+				return
+			}
+
+			if services.IsAPIToken(token) && sessionOnlyRoute(r.URL.Path) {
+				handlers.SendJSONResponse(http.StatusForbidden, w, map[string]string{"error": "session_token_required"})
+				return
+			}
+
+			r = withAuditActor(authService, r, token)
+
+			isAdminToken := authService.IsTokenAdmin(token)
+			permissions, err := authService.GetUserPermissions(token)
+
+			if err != nil {
+				handlers.SendJSONResponse(http.StatusInternalServerError, w, map[string]string{"error": "user_permissions_error"})
+				return
+			}
+
+			requiredPermission, allowed := hasPermissionForRoute(r.URL.Path, permissions, isAdminToken)
+			if !allowed {
+				handlers.SendJSONResponse(http.StatusUnauthorized, w, map[string]string{"error": "user_unauthorized_route"})
+				return
+			}
+
+			if isAdminToken {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			docIds, err := requestDocumentations(authService, r)
+			if err != nil {
+				status := http.StatusBadRequest
+				if err.Error() == "documentation_not_found" {
+					status = http.StatusNotFound
+				}
+				handlers.SendJSONResponse(status, w, map[string]string{"error": err.Error()})
+				return
+			}
+
+			scope, err := authService.GetTokenDocumentations(token)
+			if err != nil {
+				handlers.SendJSONResponse(http.StatusInternalServerError, w, map[string]string{"error": "user_permissions_error"})
+				return
+			}
+
+			if !tokenCanAccessDocumentations(r.URL.Path, docIds, scope) {
+				handlers.SendJSONResponse(http.StatusUnauthorized, w, map[string]string{"error": "token_documentation_scope"})
+				return
+			}
+
+			if len(docIds) > 0 {
+				user, err := authService.GetUserFromToken(token)
+				if err != nil {
+					handlers.SendJSONResponse(http.StatusUnauthorized, w, map[string]string{"error": "invalid_token"})
+					return
+				}
+
+				role := documentationRoleForRoute(r.URL.Path, requiredPermission)
+				for _, docId := range docIds {
+					if !authService.HasDocumentationRole(user, docId, role) {
+						handlers.SendJSONResponse(http.StatusForbidden, w, map[string]string{"error": "insufficient_documentation_role"})
+						return
+					}
+				}
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// sessionOnlyRoutes change users, their sessions and their groups. API
+// tokens can't use them, whatever they hold, or a scoped token could take
+// over an account or make its user an admin.
+var sessionOnlyRoutes = []string{
+	"/kal-api/auth/user/create",
+	"/kal-api/auth/user/edit",
+	"/kal-api/auth/user/delete",
+	"/kal-api/auth/user/upload-file",
+	"/kal-api/auth/jwt/refresh",
+	"/kal-api/auth/jwt/revoke",
+}
+
+func sessionOnlyRoute(path string) bool {
+	return utils.ArrayContains(sessionOnlyRoutes, path) || strings.HasPrefix(path, "/kal-api/auth/groups/")
+}
+
+// hasPermissionForRoute returns the global permission a route needs and
+// whether the user holds it. Routes that aren't listed are admin only.
+func hasPermissionForRoute(path string, permissions []string, isAdmin bool) (string, bool) {
+	if isAdmin {
+		return "", true
+	}
+
+	routePermissions := map[string]string{
+		"/kal-api/auth/user":                         "read",
+		"/kal-api/auth/users":                        "read",
+		"/kal-api/auth/user/edit":                    "read",
+		"/kal-api/auth/jwt/revoke":                   "read",
+		"/kal-api/auth/jwt/validate":                 "read",
+		"/kal-api/auth/user/upload-file":             "read",
+		"/kal-api/auth/tokens":                       "read",
+		"/kal-api/auth/token/create":                 "read",
+		"/kal-api/auth/token/revoke":                 "read",
+		"/kal-api/auth/stream-ticket":                "read",
+		"/kal-api/docs/documentations":               "read",
+		"/kal-api/docs/pages":                        "read",
+		"/kal-api/docs/page-groups":                  "read",
+		"/kal-api/docs/documentation":                "read",
+		"/kal-api/docs/page":                         "read",
+		"/kal-api/docs/page-group":                   "read",
+		"/kal-api/docs/search":                       "read",
+		"/kal-api/docs/page/revisions":               "read",
+		"/kal-api/docs/page/revision":                "read",
+		"/kal-api/docs/page/revision/diff":           "read",
+		"/kal-api/docs/documentation/export":         "read",
+		"/kal-api/docs/documentation/link-check":     "read",
+		"/kal-api/docs/documentation/members":        "read",
+		"/kal-api/health/builds":                     "read",
+		"/kal-api/docs/documentation/create":         "write",
+		"/kal-api/docs/documentation/edit":           "write",
+		"/kal-api/docs/documentation/version":        "write",
+		"/kal-api/docs/documentation/reorder-bulk":   "write",
+		"/kal-api/docs/documentation/import":         "write",
+		"/kal-api/docs/documentation/members/add":    "write",
+		"/kal-api/docs/documentation/members/remove": "write",
+		"/kal-api/docs/build/cancel":                 "write",
+		"/kal-api/docs/page/create":                  "write",
+		"/kal-api/docs/page/edit":                    "write",
+		"/kal-api/docs/page/copy":                    "write",
+		"/kal-api/docs/page/move":                    "write",
+		"/kal-api/docs/page/revision/restore":        "write",
+		"/kal-api/docs/page-group/create":            "write",
+		"/kal-api/docs/page-group/edit":              "write",
+		"/kal-api/docs/page-group/copy":              "write",
+		"/kal-api/docs/page-group/move":              "write",
+		"/kal-api/docs/page/lock":                    "write",
+		"/kal-api/docs/page/lock/heartbeat":          "write",
+		"/kal-api/docs/page/unlock":                  "write",
+		"/kal-api/docs/page-group/lock":              "write",
+		"/kal-api/docs/page-group/lock/heartbeat":    "write",
+		"/kal-api/docs/page-group/unlock":            "write",
+		"/kal-api/docs/page/publish":                 "write",
+		"/kal-api/docs/page/unpublish":               "write",
+		"/kal-api/docs/page-group/publish":           "write",
+		"/kal-api/docs/page-group/unpublish":         "write",
+		"/kal-api/docs/documentation/publish":        "write",
+		"/kal-api/docs/documentation/schedule":       "write",
+		"/kal-api/docs/page/schedule":                "write",
+		"/kal-api/docs/page-group/schedule":          "write",
+		"/kal-api/docs/scheduled":                    "read",
+		"/kal-api/docs/page/threads":                 "read",
+		"/kal-api/docs/documentation/threads":        "read",
+		"/kal-api/docs/threads/mentions":             "read",
+		"/kal-api/docs/thread":                       "read",
+		"/kal-api/docs/thread/create":                "write",
+		"/kal-api/docs/thread/reply":                 "write",
+		"/kal-api/docs/thread/resolve":               "write",
+		"/kal-api/docs/thread/unresolve":             "write",
+		"/kal-api/docs/change-requests":              "read",
+		"/kal-api/docs/change-request":               "read",
+		"/kal-api/docs/change-request/create":        "write",
+		"/kal-api/docs/change-request/review":        "write",
+		"/kal-api/docs/change-request/close":         "write",
+		"/kal-api/docs/documentation/approvals":      "write",
+		"/kal-api/docs/documentation/delete":         "delete",
+		"/kal-api/docs/page/delete":                  "delete",
+		"/kal-api/docs/page-group/delete":            "delete",
+		"/kal-api/docs/trash":                        "read",
+		"/kal-api/docs/trash/restore":                "delete",
+		"/kal-api/docs/trash/purge":                  "delete",
+		"/kal-api/docs/documentation/languages":      "write",
+		"/kal-api/docs/documentation/translations":   "read",
+		"/kal-api/docs/page/translate":               "write",
+		"/kal-api/docs/page/translate/prefill":       "write",
+		"/kal-api/collab/page":                       "write",
+	}
+
+	requiredPermission, exists := routePermissions[path]
+
+	if !exists && strings.HasPrefix(path, "/kal-api/health/builds/") {
+		requiredPermission, exists = "read", true
+	}
+
+	if !exists {
+		return "", false
+	}
+
+	return requiredPermission, utils.ArrayContains(permissions, requiredPermission)
+}
+
+// StreamTicketFromQuery lets clients that can't set headers, like
+// EventSource and browser websockets, authenticate with a ?ticket= from
+// /auth/stream-ticket. The request then goes on as the ticket's token would.
+// Only the stream routes take it.
+func StreamTicketFromQuery(authService *services.AuthService) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if ticket := r.URL.Query().Get("ticket"); ticket != "" && r.Header.Get("Authorization") == "" {
+				token, err := authService.RedeemStreamTicket(ticket)
+				if err != nil {
+					handlers.SendJSONResponse(http.StatusUnauthorized, w, map[string]string{"error": err.Error()})
+					return
+				}
+
+				r.Header.Set("Authorization", "Bearer "+token)
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// withAuditActor attributes the changes a request makes to the token's
+// user. Reads change nothing, so they skip the lookup.
+func withAuditActor(authService *services.AuthService, r *http.Request, token string) *http.Request {
+	if r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions {
+		return r
+	}
+
+	user, err := authService.GetUserFromToken(token)
+	if err != nil {
+		return r
+	}
+
+	actor := services.AuditActorFromRequest(r)
+	actor.UserID = &user.ID
+	actor.Username = user.Username
+
+	return r.WithContext(services.ContextWithAuditActor(r.Context(), actor))
+}
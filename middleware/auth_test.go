@@ -0,0 +1,260 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"git.difuse.io/Difuse/kalmia/config"
+	"git.difuse.io/Difuse/kalmia/db"
+	"git.difuse.io/Difuse/kalmia/db/models"
+	"git.difuse.io/Difuse/kalmia/logger"
+	"git.difuse.io/Difuse/kalmia/services"
+	"git.difuse.io/Difuse/kalmia/utils"
+	"go.uber.org/zap"
+)
+
+var TestConfig *config.Config
+var TestAuthService *services.AuthService
+
+func TestMain(m *testing.M) {
+	configJson := `{
+		"environment": "debug",
+		"port": 3737,
+		"logLevel": "debug",
+		"database": "sqlite",
+		"sessionSecret": "test",
+		"dataPath": "./middleware_test_dir",
+		"users": [{"username": "admin", "email": "admin@kalmia.difuse.io", "password": "admin", "admin": true},
+				  {"username": "user", "email": "user@kalmia.difuse.io", "password": "user", "admin": false}]
+	}`
+
+	if err := utils.WriteToFile("./config.json", configJson); err != nil {
+		panic(err)
+	}
+
+	TestConfig = config.ParseConfig("./config.json")
+
+	logger.InitializeLogger("test", TestConfig.LogLevel, TestConfig.DataPath)
+
+	d := db.SetupDatabase(TestConfig.Environment, TestConfig.Database, TestConfig.DataPath)
+	db.SetupBasicData(d, TestConfig.Admins)
+	db.InitCache()
+
+	TestAuthService = services.NewServiceRegistry(d).AuthService
+
+	code := m.Run()
+
+	if err := utils.RemovePath(TestConfig.DataPath); err != nil {
+		logger.Error("Failed to remove test data path", zap.Error(err))
+	}
+
+	if err := utils.RemovePath("./config.json"); err != nil {
+		logger.Error("Failed to remove test config file", zap.Error(err))
+	}
+
+	os.Exit(code)
+}
+
+func TestAPITokensCantChangeUsers(t *testing.T) {
+	var user models.User
+	if err := TestAuthService.DB.Where("username = ?", "user").First(&user).Error; err != nil {
+		t.Fatalf("Failed to get user: %v", err)
+	}
+
+	doc := models.Documentation{Name: "Scoped", Version: "1.0.0", AuthorID: user.ID}
+	if err := TestAuthService.DB.Create(&doc).Error; err != nil {
+		t.Fatalf("Failed to create documentation: %v", err)
+	}
+
+	token, _, err := TestAuthService.CreateAPIToken(user, "ci", []string{"read"}, []uint{doc.ID}, nil)
+	if err != nil {
+		t.Fatalf("CreateAPIToken returned an error: %v", err)
+	}
+
+	reached := false
+	handler := EnsureAuthenticated(TestAuthService)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+	}))
+
+	for _, path := range []string{"/kal-api/auth/user/edit", "/kal-api/auth/user/upload-file", "/kal-api/auth/jwt/revoke", "/kal-api/auth/groups/create"} {
+		reached = false
+
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(`{"id": 1, "admin": true}`))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+
+		handler.ServeHTTP(rec, req)
+
+		if reached || (rec.Code != http.StatusUnauthorized && rec.Code != http.StatusForbidden) {
+			t.Errorf("Expected %s to be refused to an API token, got %d", path, rec.Code)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/kal-api/auth/user", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+
+	handler.ServeHTTP(rec, req)
+
+	if !reached {
+		t.Errorf("Expected the token to still read its user, got %d", rec.Code)
+	}
+}
+
+func TestDocumentationFieldsIgnoreCase(t *testing.T) {
+	var admin models.User
+	if err := TestAuthService.DB.Where("username = ?", "admin").First(&admin).Error; err != nil {
+		t.Fatalf("Failed to get admin: %v", err)
+	}
+
+	doc := models.Documentation{Name: "Not theirs", Version: "1.0.0", AuthorID: admin.ID}
+	if err := TestAuthService.DB.Create(&doc).Error; err != nil {
+		t.Fatalf("Failed to create documentation: %v", err)
+	}
+
+	jwt, err := TestAuthService.CreateJWT("user", "user")
+	if err != nil {
+		t.Fatalf("CreateJWT returned an error: %v", err)
+	}
+	token := jwt["token"].(string)
+
+	reached := false
+	handler := EnsureAuthenticated(TestAuthService)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+	}))
+
+	bodies := map[string]int{
+		fmt.Sprintf(`{"DocumentationId": %d, "userId": 2, "role": "owner"}`, doc.ID):                                http.StatusForbidden,
+		fmt.Sprintf(`{"documentationId": %d, "DOCUMENTATIONID": %d, "userId": 2, "role": "owner"}`, doc.ID, doc.ID): http.StatusBadRequest,
+		`{"userId": 2, "role": "owner"}`: http.StatusBadRequest,
+	}
+
+	for body, expected := range bodies {
+		reached = false
+
+		req := httptest.NewRequest(http.MethodPost, "/kal-api/docs/documentation/members/add", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+
+		handler.ServeHTTP(rec, req)
+
+		if reached || rec.Code != expected {
+			t.Errorf("Expected %s to be refused with %d, got %d", body, expected, rec.Code)
+		}
+	}
+}
+
+func TestTokenScopeFollowsTheHandlersFields(t *testing.T) {
+	var user models.User
+	if err := TestAuthService.DB.Where("username = ?", "user").First(&user).Error; err != nil {
+		t.Fatalf("Failed to get user: %v", err)
+	}
+
+	docs := []models.Documentation{
+		{Name: "In scope", Version: "1.0.0", AuthorID: user.ID},
+		{Name: "Out of scope", Version: "1.0.0", AuthorID: user.ID},
+	}
+
+	for i := range docs {
+		if err := TestAuthService.DB.Create(&docs[i]).Error; err != nil {
+			t.Fatalf("Failed to create documentation: %v", err)
+		}
+
+		member := models.DocumentationMember{DocumentationID: docs[i].ID, UserID: user.ID, Role: services.RoleOwner}
+		if err := TestAuthService.DB.Create(&member).Error; err != nil {
+			t.Fatalf("Failed to add member: %v", err)
+		}
+	}
+
+	token, _, err := TestAuthService.CreateAPIToken(user, "scoped", []string{"read"}, []uint{docs[0].ID}, nil)
+	if err != nil {
+		t.Fatalf("CreateAPIToken returned an error: %v", err)
+	}
+
+	reached := false
+	handler := EnsureAuthenticated(TestAuthService)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+	}))
+
+	request := func(body string) int {
+		reached = false
+
+		req := httptest.NewRequest(http.MethodPost, "/kal-api/docs/documentation/link-check", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+
+		handler.ServeHTTP(rec, req)
+
+		return rec.Code
+	}
+
+	for _, body := range []string{
+		fmt.Sprintf(`{"ID": %d}`, docs[1].ID),
+		fmt.Sprintf(`{"id": %d, "ID": %d}`, docs[0].ID, docs[1].ID),
+		fmt.Sprintf(`{"Id": %d, "id": %d}`, docs[1].ID, docs[0].ID),
+	} {
+		if code := request(body); reached {
+			t.Errorf("Expected %s to be refused, got %d", body, code)
+		}
+	}
+
+	if code := request(fmt.Sprintf(`{"Id": %d}`, docs[0].ID)); !reached {
+		t.Errorf("Expected the token to reach its own documentation, got %d", code)
+	}
+}
+
+func TestStreamTickets(t *testing.T) {
+	var user models.User
+	if err := TestAuthService.DB.Where("username = ?", "user").First(&user).Error; err != nil {
+		t.Fatalf("Failed to get user: %v", err)
+	}
+
+	token, _, err := TestAuthService.CreateAPIToken(user, "stream", []string{"read"}, nil, nil)
+	if err != nil {
+		t.Fatalf("CreateAPIToken returned an error: %v", err)
+	}
+
+	reached := false
+	handler := StreamTicketFromQuery(TestAuthService)(EnsureAuthenticated(TestAuthService)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+	})))
+
+	stream := func(query string) int {
+		reached = false
+
+		req := httptest.NewRequest(http.MethodGet, "/kal-api/health/builds/events?"+query, nil)
+		rec := httptest.NewRecorder()
+
+		handler.ServeHTTP(rec, req)
+
+		if reached != (rec.Code == http.StatusOK) {
+			t.Fatalf("Expected the handler to run only on success, got %d", rec.Code)
+		}
+		return rec.Code
+	}
+
+	if code := stream("token=" + token); code != http.StatusUnauthorized {
+		t.Errorf("Expected the token itself to be refused in the query, got %d", code)
+	}
+
+	ticket, _, err := TestAuthService.CreateStreamTicket(token)
+	if err != nil {
+		t.Fatalf("CreateStreamTicket returned an error: %v", err)
+	}
+
+	if code := stream("ticket=" + ticket); code != http.StatusOK {
+		t.Errorf("Expected the ticket to open the stream, got %d", code)
+	}
+
+	if code := stream("ticket=" + ticket); code != http.StatusUnauthorized {
+		t.Errorf("Expected the ticket to be used up, got %d", code)
+	}
+
+	if _, _, err := TestAuthService.CreateStreamTicket("not-a-token"); err == nil {
+		t.Error("Expected no ticket for an invalid token")
+	}
+}
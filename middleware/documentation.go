@@ -0,0 +1,336 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"git.difuse.io/Difuse/kalmia/services"
+	"git.difuse.io/Difuse/kalmia/utils"
+)
+
+// documentationRouteFields maps the request fields of a route to the kind of
+// record they refer to, so a request can be traced back to the documentations
+// it touches.
+var documentationRouteFields = map[string]map[string]string{
+	"/kal-api/docs/documentation":                {"id": services.ResourceDocumentation},
+	"/kal-api/docs/documentation/edit":           {"id": services.ResourceDocumentation},
+	"/kal-api/docs/documentation/delete":         {"id": services.ResourceDocumentation},
+	"/kal-api/docs/documentation/export":         {"id": services.ResourceDocumentation},
+	"/kal-api/docs/documentation/link-check":     {"id": services.ResourceDocumentation},
+	"/kal-api/docs/documentation/reorder-bulk":   {},
+	"/kal-api/docs/documentation/version":        {"originalDocId": services.ResourceDocumentation},
+	"/kal-api/docs/documentation/members":        {"documentationId": services.ResourceDocumentation},
+	"/kal-api/docs/documentation/members/add":    {"documentationId": services.ResourceDocumentation},
+	"/kal-api/docs/documentation/members/remove": {"documentationId": services.ResourceDocumentation},
+	"/kal-api/docs/documentation/publish":        {"id": services.ResourceDocumentation},
+	"/kal-api/docs/documentation/schedule":       {"id": services.ResourceDocumentation},
+	"/kal-api/docs/page":                         {"id": services.ResourcePage},
+	"/kal-api/docs/page/create":                  {"documentationId": services.ResourceDocumentation, "pageGroupId": services.ResourcePageGroup},
+	"/kal-api/docs/page/edit":                    {"id": services.ResourcePage, "pageGroupId": services.ResourcePageGroup},
+	"/kal-api/docs/page/copy":                    {"id": services.ResourcePage, "documentationId": services.ResourceDocumentation, "pageGroupId": services.ResourcePageGroup},
+	"/kal-api/docs/page/move":                    {"id": services.ResourcePage, "documentationId": services.ResourceDocumentation, "pageGroupId": services.ResourcePageGroup},
+	"/kal-api/docs/page/delete":                  {"id": services.ResourcePage},
+	"/kal-api/docs/page/revisions":               {"pageId": services.ResourcePage},
+	"/kal-api/docs/page/revision":                {"id": services.ResourcePageRevision},
+	"/kal-api/docs/page/revision/diff":           {"fromId": services.ResourcePageRevision, "toId": services.ResourcePageRevision},
+	"/kal-api/docs/page/revision/restore":        {"id": services.ResourcePageRevision},
+	"/kal-api/docs/page/publish":                 {"id": services.ResourcePage},
+	"/kal-api/docs/page/unpublish":               {"id": services.ResourcePage},
+	"/kal-api/docs/page/schedule":                {"id": services.ResourcePage},
+	"/kal-api/docs/page/lock":                    {"id": services.ResourcePage},
+	"/kal-api/docs/page/lock/heartbeat":          {"id": services.ResourcePage},
+	"/kal-api/docs/page/unlock":                  {"id": services.ResourcePage},
+	"/kal-api/docs/page-group":                   {"id": services.ResourcePageGroup},
+	"/kal-api/docs/page-group/create":            {"documentationId": services.ResourceDocumentation, "parentId": services.ResourcePageGroup},
+	"/kal-api/docs/page-group/edit":              {"id": services.ResourcePageGroup, "documentationId": services.ResourceDocumentation, "parentId": services.ResourcePageGroup},
+	"/kal-api/docs/page-group/copy":              {"id": services.ResourcePageGroup, "documentationId": services.ResourceDocumentation, "parentId": services.ResourcePageGroup},
+	"/kal-api/docs/page-group/move":              {"id": services.ResourcePageGroup, "documentationId": services.ResourceDocumentation, "parentId": services.ResourcePageGroup},
+	"/kal-api/docs/page-group/delete":            {"id": services.ResourcePageGroup},
+	"/kal-api/docs/page-group/publish":           {"id": services.ResourcePageGroup},
+	"/kal-api/docs/page-group/unpublish":         {"id": services.ResourcePageGroup},
+	"/kal-api/docs/page-group/schedule":          {"id": services.ResourcePageGroup},
+	"/kal-api/docs/page-group/lock":              {"id": services.ResourcePageGroup},
+	"/kal-api/docs/page-group/lock/heartbeat":    {"id": services.ResourcePageGroup},
+	"/kal-api/docs/page-group/unlock":            {"id": services.ResourcePageGroup},
+	"/kal-api/docs/scheduled":                    {"documentationId": services.ResourceDocumentation},
+	"/kal-api/docs/change-requests":              {"documentationId": services.ResourceDocumentation},
+	"/kal-api/docs/change-request":               {"id": services.ResourceChangeRequest},
+	"/kal-api/docs/change-request/create":        {"documentationId": services.ResourceDocumentation},
+	"/kal-api/docs/change-request/review":        {"id": services.ResourceChangeRequest},
+	"/kal-api/docs/change-request/close":         {"id": services.ResourceChangeRequest},
+	"/kal-api/docs/documentation/approvals":      {"documentationId": services.ResourceDocumentation},
+	"/kal-api/docs/page/threads":                 {"pageId": services.ResourcePage},
+	"/kal-api/docs/documentation/threads":        {"documentationId": services.ResourceDocumentation},
+	"/kal-api/docs/thread":                       {"id": services.ResourceCommentThread},
+	"/kal-api/docs/thread/create":                {"pageId": services.ResourcePage},
+	"/kal-api/docs/thread/reply":                 {"threadId": services.ResourceCommentThread},
+	"/kal-api/docs/thread/resolve":               {"id": services.ResourceCommentThread},
+	"/kal-api/docs/thread/unresolve":             {"id": services.ResourceCommentThread},
+	"/kal-api/docs/trash":                        {"documentationId": services.ResourceDocumentation},
+	"/kal-api/docs/trash/restore":                {"id": services.ResourceTrashItem},
+	"/kal-api/docs/trash/purge":                  {"id": services.ResourceTrashItem},
+	"/kal-api/docs/redirects":                    {"documentationId": services.ResourceDocumentation},
+	"/kal-api/docs/redirect/create":              {"documentationId": services.ResourceDocumentation},
+	"/kal-api/docs/redirect/edit":                {"id": services.ResourceRedirect},
+	"/kal-api/docs/redirect/delete":              {"id": services.ResourceRedirect},
+	"/kal-api/docs/documentation/languages":      {"id": services.ResourceDocumentation},
+	"/kal-api/docs/documentation/translations":   {"documentationId": services.ResourceDocumentation},
+	"/kal-api/docs/page/translate":               {"id": services.ResourcePage},
+	"/kal-api/docs/page/translate/prefill":       {"id": services.ResourcePage},
+	"/kal-api/docs/search":                       {"documentationId": services.ResourceDocumentation},
+	"/kal-api/docs/build/cancel":                 {"id": services.ResourceBuild, "documentationId": services.ResourceDocumentation},
+	"/kal-api/health/builds":                     {"documentationId": services.ResourceDocumentation},
+	"/kal-api/health/builds/events":              {"documentationId": services.ResourceDocumentation},
+	"/kal-api/collab/page":                       {"pageId": services.ResourcePage},
+}
+
+// optionalDocumentationRoutes list everything when no documentation is named,
+// their handlers limit what they return to the caller's documentations.
+var optionalDocumentationRoutes = []string{
+	"/kal-api/docs/documentation/reorder-bulk",
+	"/kal-api/docs/scheduled",
+	"/kal-api/docs/trash",
+	"/kal-api/docs/search",
+	"/kal-api/health/builds",
+	"/kal-api/health/builds/events",
+}
+
+type documentationReference struct {
+	Kind string
+	ID   uint
+}
+
+// bodyField looks a field of a JSON body up the way the handlers decode it,
+// matching its name regardless of case.
+func bodyField(values map[string]json.RawMessage, field string) (json.RawMessage, bool) {
+	for key, raw := range values {
+		if strings.EqualFold(key, field) {
+			return raw, true
+		}
+	}
+
+	return nil, false
+}
+
+// ambiguousBody reports whether a JSON body names the same field more than
+// once under different cases. Which one counts would depend on who reads it.
+func ambiguousBody(values map[string]json.RawMessage) bool {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		for _, seen := range keys {
+			if strings.EqualFold(key, seen) {
+				return true
+			}
+		}
+		keys = append(keys, key)
+	}
+
+	return false
+}
+
+// requestDocumentationReferences collects the records a request refers to
+// from its query string and JSON body. The body is put back for the handler.
+// ok is false when the body is ambiguous.
+func requestDocumentationReferences(r *http.Request) ([]documentationReference, bool) {
+	if id, ok := buildLogID(r.URL.Path); ok {
+		return []documentationReference{{Kind: services.ResourceBuild, ID: id}}, true
+	}
+
+	fields, exists := documentationRouteFields[r.URL.Path]
+	if !exists {
+		return nil, true
+	}
+
+	var references []documentationReference
+
+	query := r.URL.Query()
+	for field, kind := range fields {
+		if id, err := utils.StringToUint(query.Get(field)); err == nil && id != 0 {
+			references = append(references, documentationReference{Kind: kind, ID: id})
+		}
+	}
+
+	if r.Body == nil || r.Method == http.MethodGet {
+		return references, true
+	}
+
+	body, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	if err != nil || len(body) == 0 {
+		return references, true
+	}
+
+	var values map[string]json.RawMessage
+	if err := json.Unmarshal(body, &values); err != nil {
+		return references, true
+	}
+
+	if ambiguousBody(values) {
+		return nil, false
+	}
+
+	for field, kind := range fields {
+		var id uint
+		if raw, ok := bodyField(values, field); ok && json.Unmarshal(raw, &id) == nil && id != 0 {
+			references = append(references, documentationReference{Kind: kind, ID: id})
+		}
+	}
+
+	if raw, ok := bodyField(values, "order"); ok && r.URL.Path == "/kal-api/docs/documentation/reorder-bulk" {
+		references = append(references, reorderReferences(raw)...)
+	}
+
+	return references, true
+}
+
+// buildLogID picks the build out of /kal-api/health/builds/{id}/log.
+func buildLogID(path string) (uint, bool) {
+	if !strings.HasPrefix(path, "/kal-api/health/builds/") || !strings.HasSuffix(path, "/log") {
+		return 0, false
+	}
+
+	id, err := utils.StringToUint(strings.TrimSuffix(strings.TrimPrefix(path, "/kal-api/health/builds/"), "/log"))
+	if err != nil {
+		return 0, false
+	}
+
+	return id, true
+}
+
+// reorderReferences collects the pages and page groups a bulk reorder moves,
+// and the groups it moves them into.
+func reorderReferences(raw json.RawMessage) []documentationReference {
+	var items []struct {
+		ID          uint  `json:"id"`
+		ParentID    *uint `json:"parentId"`
+		PageGroupID *uint `json:"pageGroupId"`
+		IsPageGroup bool  `json:"isPageGroup"`
+	}
+
+	if err := json.Unmarshal(raw, &items); err != nil {
+		return nil
+	}
+
+	var references []documentationReference
+	for _, item := range items {
+		kind := services.ResourcePage
+		if item.IsPageGroup {
+			kind = services.ResourcePageGroup
+		}
+		references = append(references, documentationReference{Kind: kind, ID: item.ID})
+
+		for _, groupId := range []*uint{item.ParentID, item.PageGroupID} {
+			if groupId != nil && *groupId != 0 {
+				references = append(references, documentationReference{Kind: services.ResourcePageGroup, ID: *groupId})
+			}
+		}
+	}
+
+	return references
+}
+
+// routeDocumentationRoles lists the routes that need more than the role
+// matching their global permission, see documentationRoleForRoute.
+var routeDocumentationRoles = map[string]string{
+	"/kal-api/docs/documentation/edit":           services.RoleMaintainer,
+	"/kal-api/docs/documentation/version":        services.RoleMaintainer,
+	"/kal-api/docs/documentation/publish":        services.RoleMaintainer,
+	"/kal-api/docs/documentation/schedule":       services.RoleMaintainer,
+	"/kal-api/docs/documentation/languages":      services.RoleMaintainer,
+	"/kal-api/docs/thread/create":                services.RoleViewer,
+	"/kal-api/docs/thread/reply":                 services.RoleViewer,
+	"/kal-api/docs/thread/resolve":               services.RoleViewer,
+	"/kal-api/docs/thread/unresolve":             services.RoleViewer,
+	"/kal-api/docs/documentation/approvals":      services.RoleMaintainer,
+	"/kal-api/docs/documentation/delete":         services.RoleOwner,
+	"/kal-api/docs/documentation/members/add":    services.RoleOwner,
+	"/kal-api/docs/documentation/members/remove": services.RoleOwner,
+}
+
+// documentationRoleForRoute returns the role a user needs on the
+// documentations a route touches: viewer to read, editor to write and
+// maintainer to delete, unless the route asks for more.
+func documentationRoleForRoute(path, permission string) string {
+	if role, exists := routeDocumentationRoles[path]; exists {
+		return role
+	}
+
+	switch permission {
+	case "write":
+		return services.RoleEditor
+	case "delete":
+		return services.RoleMaintainer
+	default:
+		return services.RoleViewer
+	}
+}
+
+// requestDocumentations resolves the records a request refers to into their
+// root documentations. It fails with documentation_not_found when one of them
+// doesn't exist, ambiguous_request when the body can't be read one way only
+// and documentation_required when a route that acts on documentations names
+// none.
+func requestDocumentations(authService *services.AuthService, r *http.Request) ([]uint, error) {
+	references, ok := requestDocumentationReferences(r)
+	if !ok {
+		return nil, fmt.Errorf("ambiguous_request")
+	}
+
+	if _, exists := documentationRouteFields[r.URL.Path]; exists && len(references) == 0 &&
+		!utils.ArrayContains(optionalDocumentationRoutes, r.URL.Path) {
+		return nil, fmt.Errorf("documentation_required")
+	}
+
+	docIds := make([]uint, 0, len(references))
+
+	for _, reference := range references {
+		docId, err := authService.ResourceDocumentationID(reference.Kind, reference.ID)
+		if err != nil {
+			return nil, fmt.Errorf("documentation_not_found")
+		}
+		docIds = append(docIds, docId)
+	}
+
+	return docIds, nil
+}
+
+// tokenCanAccessDocumentations checks the documentation scope of an API token.
+// A token limited to some documentations may only call documentation routes
+// that name one, and every documentation they touch must be in its scope.
+// docIds must come from requestDocumentations, which reads the request the
+// way its handler will.
+func tokenCanAccessDocumentations(path string, docIds []uint, scope []uint) bool {
+	if len(scope) == 0 {
+		return true
+	}
+
+	if !strings.HasPrefix(path, "/kal-api/docs/") && !strings.HasPrefix(path, "/kal-api/health/builds") {
+		return true
+	}
+
+	if len(docIds) == 0 {
+		return false
+	}
+
+	for _, docId := range docIds {
+		allowed := false
+		for _, scopedId := range scope {
+			if scopedId == docId {
+				allowed = true
+				break
+			}
+		}
+
+		if !allowed {
+			return false
+		}
+	}
+
+	return true
+}
@@ -59,6 +59,14 @@ func RsPressMiddleware(dS *services.DocService) func(http.Handler) http.Handler
 			}
 
 			if _, err := os.Stat(fullPath); os.IsNotExist(err) {
+				// INFO: pages that moved, or paths with a custom rule, redirect to where they are now
+				if !reqAuth || cookieToken != "" {
+					if target, ok := dS.ResolveRedirect(docId, strings.TrimPrefix(urlPath, baseURL)); ok {
+						http.Redirect(w, r, target, http.StatusMovedPermanently)
+						return
+					}
+				}
+
 				fullPath = filepath.Join(docPath, "build", "index.html")
 			}
 
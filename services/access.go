@@ -0,0 +1,445 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+
+	"git.difuse.io/Difuse/kalmia/db/models"
+	"gorm.io/gorm"
+)
+
+const (
+	RoleViewer     = "viewer"
+	RoleEditor     = "editor"
+	RoleMaintainer = "maintainer"
+	RoleOwner      = "owner"
+)
+
+// Each role includes everything the roles before it can do.
+var documentationRoles = []string{RoleViewer, RoleEditor, RoleMaintainer, RoleOwner}
+
+func roleRank(role string) int {
+	for i, r := range documentationRoles {
+		if r == role {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+func IsValidRole(role string) bool {
+	return roleRank(role) > 0
+}
+
+// RoleAtLeast reports whether role grants everything required does.
+func RoleAtLeast(role, required string) bool {
+	return roleRank(role) >= roleRank(required) && roleRank(role) > 0
+}
+
+// Kinds of records a request can refer to, used to work out which
+// documentation a request touches.
+const (
+	ResourceDocumentation = "documentation"
+	ResourcePage          = "page"
+	ResourcePageGroup     = "page_group"
+	ResourcePageRevision  = "page_revision"
+	ResourceBuild         = "build"
+	ResourceChangeRequest = "change_request"
+	ResourceCommentThread = "comment_thread"
+	ResourceTrashItem     = "trash_item"
+	ResourceRedirect      = "redirect"
+)
+
+func rootDocumentationID(db *gorm.DB, docID uint) (uint, error) {
+	for {
+		var doc models.Documentation
+		if err := db.Unscoped().Select("id", "cloned_from").First(&doc, docID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return 0, fmt.Errorf("documentation with ID %d not found", docID)
+			}
+			return 0, fmt.Errorf("failed to fetch documentation with ID %d: %w", docID, err)
+		}
+
+		if doc.ClonedFrom == nil || *doc.ClonedFrom == 0 || *doc.ClonedFrom == doc.ID {
+			return doc.ID, nil
+		}
+
+		docID = *doc.ClonedFrom
+	}
+}
+
+// ResourceDocumentationID returns the root documentation a record belongs to,
+// versions resolve to the documentation they were cloned from.
+func (service *AuthService) ResourceDocumentationID(kind string, id uint) (uint, error) {
+	var model interface{}
+
+	switch kind {
+	case ResourceDocumentation:
+		return rootDocumentationID(service.DB, id)
+	case ResourcePage:
+		model = &models.Page{}
+	case ResourcePageGroup:
+		model = &models.PageGroup{}
+	case ResourcePageRevision:
+		model = &models.PageRevision{}
+	case ResourceBuild:
+		model = &models.BuildTriggers{}
+	case ResourceChangeRequest:
+		model = &models.ChangeRequest{}
+	case ResourceCommentThread:
+		model = &models.CommentThread{}
+	case ResourceTrashItem:
+		model = &models.TrashItem{}
+	case ResourceRedirect:
+		model = &models.Redirect{}
+	default:
+		return 0, fmt.Errorf("unknown_resource")
+	}
+
+	var docIds []uint
+	if err := service.DB.Model(model).Where("id = ?", id).Pluck("documentation_id", &docIds).Error; err != nil || len(docIds) == 0 {
+		return 0, fmt.Errorf("resource_not_found")
+	}
+
+	return rootDocumentationID(service.DB, docIds[0])
+}
+
+// documentationRole returns the highest role a user holds on a documentation,
+// directly or through a group. Roles are taken from the root so versions
+// inherit the roles of the documentation they were cloned from. Users without
+// a role get "".
+func documentationRole(db *gorm.DB, userId uint, docId uint) (string, error) {
+	rootId, err := rootDocumentationID(db, docId)
+	if err != nil {
+		return "", err
+	}
+
+	var member models.DocumentationMember
+	err = db.Where("documentation_id = ? AND user_id = ?", rootId, userId).First(&member).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", fmt.Errorf("failed_to_get_role")
+	}
+
+	groupRole, err := groupDocumentationRole(db, userId, rootId)
+	if err != nil {
+		return "", err
+	}
+
+	if roleRank(groupRole) > roleRank(member.Role) {
+		return groupRole, nil
+	}
+
+	return member.Role, nil
+}
+
+// requireDocumentationRole fails unless the user is an admin or holds at
+// least the required role on the documentation.
+func requireDocumentationRole(db *gorm.DB, userId uint, docId uint, required string) error {
+	var user models.User
+	if err := db.Select("id", "admin").First(&user, userId).Error; err != nil {
+		return fmt.Errorf("user_not_found")
+	}
+
+	if user.Admin {
+		return nil
+	}
+
+	role, err := documentationRole(db, userId, docId)
+	if err != nil {
+		return err
+	}
+
+	if !RoleAtLeast(role, required) {
+		return fmt.Errorf("insufficient_documentation_role")
+	}
+
+	return nil
+}
+
+func (service *AuthService) HasDocumentationRole(user models.User, docId uint, required string) bool {
+	return requireDocumentationRole(service.DB, user.ID, docId, required) == nil
+}
+
+// AccessibleDocumentationIDs returns the root documentations a user holds any
+// role on, directly or through a group. Admins get nil, meaning all of them.
+func (service *AuthService) AccessibleDocumentationIDs(user models.User) ([]uint, error) {
+	if user.Admin {
+		return nil, nil
+	}
+
+	ids := make([]uint, 0)
+	if err := service.DB.Model(&models.DocumentationMember{}).Where("user_id = ?", user.ID).Pluck("documentation_id", &ids).Error; err != nil {
+		return nil, fmt.Errorf("failed_to_get_documentations")
+	}
+
+	var granted []uint
+	if err := service.DB.Model(&models.DocumentationGroupGrant{}).
+		Joins("JOIN group_users ON group_users.group_id = documentation_group_grants.group_id").
+		Where("group_users.user_id = ?", user.ID).
+		Pluck("documentation_group_grants.documentation_id", &granted).Error; err != nil {
+		return nil, fmt.Errorf("failed_to_get_documentations")
+	}
+
+	for _, id := range granted {
+		seen := false
+		for _, existing := range ids {
+			if existing == id {
+				seen = true
+				break
+			}
+		}
+
+		if !seen {
+			ids = append(ids, id)
+		}
+	}
+
+	return ids, nil
+}
+
+func (service *DocService) requireDocumentationRole(userId uint, docId uint, required string) error {
+	return requireDocumentationRole(service.DB, userId, docId, required)
+}
+
+func (service *DocService) GetDocumentationMembers(docId uint) ([]models.DocumentationMember, error) {
+	rootId, err := rootDocumentationID(service.DB, docId)
+	if err != nil {
+		return nil, fmt.Errorf("documentation_not_found")
+	}
+
+	members := make([]models.DocumentationMember, 0)
+	if err := service.DB.Preload("User", func(db *gorm.DB) *gorm.DB {
+		return db.Select("ID", "Username", "Email", "Photo")
+	}).Where("documentation_id = ?", rootId).Order("id ASC").Find(&members).Error; err != nil {
+		return nil, fmt.Errorf("failed_to_get_members")
+	}
+
+	return members, nil
+}
+
+// SetDocumentationMember gives a user a role on a documentation, replacing
+// the role they held before.
+func (service *DocService) SetDocumentationMember(user models.User, docId uint, userId uint, role string) error {
+	if !IsValidRole(role) {
+		return fmt.Errorf("invalid_role")
+	}
+
+	rootId, err := rootDocumentationID(service.DB, docId)
+	if err != nil {
+		return fmt.Errorf("documentation_not_found")
+	}
+
+	if err := service.requireDocumentationRole(user.ID, rootId, RoleOwner); err != nil {
+		return err
+	}
+
+	var target models.User
+	if err := service.DB.Select("id").First(&target, userId).Error; err != nil {
+		return fmt.Errorf("user_not_found")
+	}
+
+	previousRole := ""
+	err = service.DB.Transaction(func(tx *gorm.DB) error {
+		var member models.DocumentationMember
+		err := tx.Where("documentation_id = ? AND user_id = ?", rootId, userId).First(&member).Error
+
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			member = models.DocumentationMember{DocumentationID: rootId, UserID: userId, Role: role}
+			if err := tx.Create(&member).Error; err != nil {
+				return fmt.Errorf("failed_to_add_member")
+			}
+			return nil
+		}
+
+		if err != nil {
+			return fmt.Errorf("failed_to_get_member")
+		}
+
+		if member.Role == RoleOwner && role != RoleOwner {
+			if err := ensureAnotherOwner(tx, rootId, userId); err != nil {
+				return err
+			}
+		}
+
+		previousRole = member.Role
+		if err := tx.Model(&member).Update("role", role).Error; err != nil {
+			return fmt.Errorf("failed_to_update_member")
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	var before interface{}
+	if previousRole != "" {
+		before = map[string]interface{}{"userId": userId, "role": previousRole}
+	}
+
+	service.audit("documentation.member_set", AuditEntityDocumentation, rootId, before, map[string]interface{}{"userId": userId, "role": role})
+
+	return nil
+}
+
+func (service *DocService) RemoveDocumentationMember(user models.User, docId uint, userId uint) error {
+	rootId, err := rootDocumentationID(service.DB, docId)
+	if err != nil {
+		return fmt.Errorf("documentation_not_found")
+	}
+
+	if err := service.requireDocumentationRole(user.ID, rootId, RoleOwner); err != nil {
+		return err
+	}
+
+	var member models.DocumentationMember
+	err = service.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("documentation_id = ? AND user_id = ?", rootId, userId).First(&member).Error; err != nil {
+			return fmt.Errorf("member_not_found")
+		}
+
+		if member.Role == RoleOwner {
+			if err := ensureAnotherOwner(tx, rootId, userId); err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Delete(&member).Error; err != nil {
+			return fmt.Errorf("failed_to_remove_member")
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	service.audit("documentation.member_remove", AuditEntityDocumentation, rootId, map[string]interface{}{"userId": userId, "role": member.Role}, nil)
+
+	return nil
+}
+
+// ensureAnotherOwner keeps a documentation from losing its last owner.
+func ensureAnotherOwner(tx *gorm.DB, rootId uint, userId uint) error {
+	var owners int64
+	if err := tx.Model(&models.DocumentationMember{}).
+		Where("documentation_id = ? AND role = ? AND user_id <> ?", rootId, RoleOwner, userId).
+		Count(&owners).Error; err != nil {
+		return fmt.Errorf("failed_to_get_members")
+	}
+
+	if owners == 0 {
+		return fmt.Errorf("last_owner")
+	}
+
+	return nil
+}
+
+// addDocumentationOwner makes the creator of a new root documentation its
+// owner.
+func addDocumentationOwner(tx *gorm.DB, docId uint, userId uint) error {
+	if userId == 0 {
+		return nil
+	}
+
+	member := models.DocumentationMember{DocumentationID: docId, UserID: userId, Role: RoleOwner}
+	if err := tx.Where("documentation_id = ? AND user_id = ?", docId, userId).
+		Assign(models.DocumentationMember{Role: RoleOwner}).
+		FirstOrCreate(&member).Error; err != nil {
+		return fmt.Errorf("failed_to_add_owner")
+	}
+
+	return nil
+}
+
+// documentationIDsUnder returns the given root documentations together with
+// every version cloned from them.
+func documentationIDsUnder(db *gorm.DB, roots []uint) ([]uint, error) {
+	var docs []models.Documentation
+	if err := db.Select("id", "cloned_from").Find(&docs).Error; err != nil {
+		return nil, fmt.Errorf("failed_to_get_documentations")
+	}
+
+	parents := make(map[uint]uint, len(docs))
+	for _, doc := range docs {
+		if doc.ClonedFrom != nil && *doc.ClonedFrom != doc.ID {
+			parents[doc.ID] = *doc.ClonedFrom
+		}
+	}
+
+	wanted := make(map[uint]bool, len(roots))
+	for _, root := range roots {
+		wanted[root] = true
+	}
+
+	ids := make([]uint, 0)
+	for _, doc := range docs {
+		root := doc.ID
+		for seen := 0; seen < len(docs); seen++ {
+			parent, ok := parents[root]
+			if !ok || parent == 0 {
+				break
+			}
+			root = parent
+		}
+
+		if wanted[root] {
+			ids = append(ids, doc.ID)
+		}
+	}
+
+	return ids, nil
+}
+
+// VisibleDocumentationIDs returns every documentation, versions included,
+// that the holder of token may see: the ones they hold a role on, narrowed
+// to the scope of an API token. A nil map means there's no restriction.
+func (service *AuthService) VisibleDocumentationIDs(token string) (map[uint]bool, error) {
+	user, err := service.GetUserFromToken(token)
+	if err != nil {
+		return nil, fmt.Errorf("user_not_found")
+	}
+
+	roots, err := service.AccessibleDocumentationIDs(user)
+	if err != nil {
+		return nil, err
+	}
+
+	scope, err := service.GetTokenDocumentations(token)
+	if err != nil {
+		return nil, err
+	}
+
+	if roots == nil && scope == nil {
+		return nil, nil
+	}
+
+	if roots == nil {
+		roots = scope
+	} else if scope != nil {
+		narrowed := make([]uint, 0, len(roots))
+		for _, root := range roots {
+			for _, scoped := range scope {
+				if root == scoped {
+					narrowed = append(narrowed, root)
+					break
+				}
+			}
+		}
+		roots = narrowed
+	}
+
+	ids, err := documentationIDsUnder(service.DB, roots)
+	if err != nil {
+		return nil, err
+	}
+
+	visible := make(map[uint]bool, len(ids))
+	for _, id := range ids {
+		visible[id] = true
+	}
+
+	return visible, nil
+}
@@ -0,0 +1,152 @@
+package services
+
+import (
+	"testing"
+
+	"git.difuse.io/Difuse/kalmia/db/models"
+)
+
+func TestRoleAtLeast(t *testing.T) {
+	tests := []struct {
+		role     string
+		required string
+		expected bool
+	}{
+		{RoleOwner, RoleViewer, true},
+		{RoleEditor, RoleEditor, true},
+		{RoleEditor, RoleMaintainer, false},
+		{RoleViewer, RoleEditor, false},
+		{"", RoleViewer, false},
+		{"unknown", RoleViewer, false},
+	}
+
+	for _, test := range tests {
+		if got := RoleAtLeast(test.role, test.required); got != test.expected {
+			t.Errorf("RoleAtLeast(%q, %q) = %v, expected %v", test.role, test.required, got, test.expected)
+		}
+	}
+}
+
+func TestDocumentationMembers(t *testing.T) {
+	if TestDocService == nil || TestAuthService == nil {
+		t.Fatal("TestDocService or TestAuthService is nil")
+	}
+
+	db := TestDocService.DB
+
+	var admin, user models.User
+	if err := db.Where("username = ?", "admin").First(&admin).Error; err != nil {
+		t.Fatalf("Failed to get admin: %v", err)
+	}
+	if err := db.Where("username = ?", "user").First(&user).Error; err != nil {
+		t.Fatalf("Failed to get user: %v", err)
+	}
+
+	doc := models.Documentation{Name: "Members", Version: "1.0.0", BaseURL: "/members", AuthorID: admin.ID}
+	if err := db.Create(&doc).Error; err != nil {
+		t.Fatalf("Failed to create documentation: %v", err)
+	}
+
+	version := models.Documentation{Name: "Members", Version: "2.0.0", BaseURL: "/members", AuthorID: admin.ID, ClonedFrom: &doc.ID}
+	if err := db.Create(&version).Error; err != nil {
+		t.Fatalf("Failed to create version: %v", err)
+	}
+
+	page := models.Page{DocumentationID: version.ID, Title: "Members", Slug: "/members", Content: "[]", AuthorID: admin.ID}
+	if err := TestDocService.CreatePage(&page); err != nil {
+		t.Fatalf("CreatePage returned an error: %v", err)
+	}
+
+	if err := TestDocService.SetDocumentationMember(admin, doc.ID, admin.ID, RoleOwner); err != nil {
+		t.Fatalf("SetDocumentationMember returned an error: %v", err)
+	}
+
+	t.Run("Non members", func(t *testing.T) {
+		if err := TestDocService.EditPage(user, page.ID, "Edited", "/members", "", nil, nil, nil); err == nil {
+			t.Error("Expected a user without a role to be refused")
+		}
+
+		if TestAuthService.HasDocumentationRole(user, version.ID, RoleViewer) {
+			t.Error("Expected a user without a role to not see the documentation")
+		}
+	})
+
+	t.Run("Inherited by versions", func(t *testing.T) {
+		if err := TestDocService.SetDocumentationMember(admin, version.ID, user.ID, RoleViewer); err != nil {
+			t.Fatalf("SetDocumentationMember returned an error: %v", err)
+		}
+
+		members, err := TestDocService.GetDocumentationMembers(doc.ID)
+		if err != nil || len(members) != 2 {
+			t.Fatalf("Expected 2 members on the root documentation, got %d (%v)", len(members), err)
+		}
+
+		if !TestAuthService.HasDocumentationRole(user, version.ID, RoleViewer) {
+			t.Error("Expected the viewer role to cover the version")
+		}
+
+		if err := TestDocService.EditPage(user, page.ID, "Edited", "/members", "", nil, nil, nil); err == nil {
+			t.Error("Expected a viewer to be refused an edit")
+		}
+
+		if err := TestDocService.SetDocumentationMember(admin, doc.ID, user.ID, RoleEditor); err != nil {
+			t.Fatalf("SetDocumentationMember returned an error: %v", err)
+		}
+
+		if err := TestDocService.EditPage(user, page.ID, "Edited", "/members", "", nil, nil, nil); err != nil {
+			t.Errorf("Expected an editor to edit the page, got %v", err)
+		}
+	})
+
+	t.Run("Visible documentations", func(t *testing.T) {
+		jwt, err := TestAuthService.CreateJWT("user", "user")
+		if err != nil {
+			t.Fatalf("CreateJWT returned an error: %v", err)
+		}
+
+		visible, err := TestAuthService.VisibleDocumentationIDs(jwt["token"].(string))
+		if err != nil {
+			t.Fatalf("VisibleDocumentationIDs returned an error: %v", err)
+		}
+
+		if !visible[doc.ID] || !visible[version.ID] || len(visible) != 2 {
+			t.Errorf("Expected documentations %d and %d to be visible, got %v", doc.ID, version.ID, visible)
+		}
+	})
+
+	t.Run("Editors can't manage members or delete", func(t *testing.T) {
+		if err := TestDocService.SetDocumentationMember(user, doc.ID, user.ID, RoleOwner); err == nil || err.Error() != "insufficient_documentation_role" {
+			t.Errorf("Expected insufficient_documentation_role, got %v", err)
+		}
+
+		if err := TestDocService.RemoveDocumentationMember(user, doc.ID, admin.ID); err == nil || err.Error() != "insufficient_documentation_role" {
+			t.Errorf("Expected insufficient_documentation_role, got %v", err)
+		}
+
+		if err := TestDocService.DeletePage(user, page.ID); err == nil || err.Error() != "insufficient_documentation_role" {
+			t.Errorf("Expected insufficient_documentation_role, got %v", err)
+		}
+
+		if err := TestDocService.DeleteDocumentation(user, version.ID); err == nil || err.Error() != "insufficient_documentation_role" {
+			t.Errorf("Expected insufficient_documentation_role, got %v", err)
+		}
+
+		if _, err := TestDocService.GetPage(page.ID); err != nil {
+			t.Errorf("Expected the page to be kept, got %v", err)
+		}
+	})
+
+	t.Run("Last owner", func(t *testing.T) {
+		if err := TestDocService.SetDocumentationMember(admin, doc.ID, admin.ID, RoleEditor); err == nil {
+			t.Error("Expected demoting the last owner to fail")
+		}
+
+		if err := TestDocService.RemoveDocumentationMember(admin, doc.ID, admin.ID); err == nil {
+			t.Error("Expected removing the last owner to fail")
+		}
+
+		if err := TestDocService.RemoveDocumentationMember(admin, doc.ID, user.ID); err != nil {
+			t.Errorf("RemoveDocumentationMember returned an error: %v", err)
+		}
+	})
+}
@@ -0,0 +1,394 @@
+package services
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"git.difuse.io/Difuse/kalmia/config"
+	"git.difuse.io/Difuse/kalmia/db/models"
+	"git.difuse.io/Difuse/kalmia/logger"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	AuditEntityUser          = "user"
+	AuditEntityToken         = "token"
+	AuditEntityAPIToken      = "api_token"
+	AuditEntityGroup         = "group"
+	AuditEntityDocumentation = "documentation"
+	AuditEntityPage          = "page"
+	AuditEntityPageGroup     = "page_group"
+	AuditEntityWebhook       = "webhook"
+	AuditEntityChangeRequest = "change_request"
+	AuditEntityCommentThread = "comment_thread"
+	AuditEntityRedirect      = "redirect"
+)
+
+// AuditActor is who a change is attributed to. Services without one record
+// their changes as made by the system.
+type AuditActor struct {
+	UserID    *uint
+	Username  string
+	IP        string
+	UserAgent string
+}
+
+type auditActorKey struct{}
+
+func ContextWithAuditActor(ctx context.Context, actor AuditActor) context.Context {
+	return context.WithValue(ctx, auditActorKey{}, actor)
+}
+
+// AuditActorFromRequest returns the actor the authentication middleware put
+// on the request, completed with the client's address and user agent.
+func AuditActorFromRequest(r *http.Request) AuditActor {
+	actor, _ := r.Context().Value(auditActorKey{}).(AuditActor)
+
+	if actor.IP == "" {
+		actor.IP = clientIP(r)
+	}
+
+	if actor.UserAgent == "" {
+		actor.UserAgent = r.UserAgent()
+	}
+
+	return actor
+}
+
+// clientIP is the address a request came from. Forwarding headers are only
+// believed when the peer is a trusted proxy, and then the client is the
+// rightmost hop that isn't one, the hops before it are whatever the client
+// chose to send.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	var proxies []string
+	if config.ParsedConfig != nil {
+		proxies = config.ParsedConfig.TrustedProxies
+	}
+
+	if !trustedProxy(proxies, host) {
+		return host
+	}
+
+	if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+		hops := strings.Split(strings.Join(forwarded, ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if hop != "" && !trustedProxy(proxies, hop) {
+				return hop
+			}
+		}
+	}
+
+	if realIP := strings.TrimSpace(r.Header.Get("X-Real-IP")); realIP != "" {
+		return realIP
+	}
+
+	return host
+}
+
+// trustedProxy reports whether address is one of proxies, given as addresses
+// or CIDR ranges.
+func trustedProxy(proxies []string, address string) bool {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+
+	for _, proxy := range proxies {
+		if _, network, err := net.ParseCIDR(proxy); err == nil {
+			if network.Contains(ip) {
+				return true
+			}
+			continue
+		}
+
+		if proxyIP := net.ParseIP(proxy); proxyIP != nil && proxyIP.Equal(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (registry *ServiceRegistry) WithActor(actor AuditActor) *ServiceRegistry {
+	return &ServiceRegistry{
+		AuthService: registry.AuthService.WithActor(actor),
+		DocService:  registry.DocService.WithActor(actor),
+	}
+}
+
+// WithActor returns a copy of the service that attributes the changes it
+// makes to actor. The copy shares everything else with the original.
+func (service *AuthService) WithActor(actor AuditActor) *AuthService {
+	clone := *service
+	clone.actor = &actor
+	return &clone
+}
+
+func (service *DocService) WithActor(actor AuditActor) *DocService {
+	clone := *service
+	clone.actor = &actor
+	return &clone
+}
+
+func (service *AuthService) audit(action, entityType string, entityId uint, before, after interface{}) {
+	recordAudit(service.DB, service.actor, action, entityType, entityId, before, after)
+}
+
+func (service *DocService) audit(action, entityType string, entityId uint, before, after interface{}) {
+	recordAudit(service.DB, service.actor, action, entityType, entityId, before, after)
+}
+
+func auditSummary(value interface{}) string {
+	if value == nil {
+		return ""
+	}
+
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return ""
+	}
+
+	return string(encoded)
+}
+
+// recordAudit appends an entry to the audit log. The change it describes has
+// already been made, so a failure is logged rather than returned.
+func recordAudit(db *gorm.DB, actor *AuditActor, action, entityType string, entityId uint, before, after interface{}) {
+	entry := models.AuditLog{
+		ActorName:  "system",
+		Action:     action,
+		EntityType: entityType,
+		EntityID:   entityId,
+		Before:     auditSummary(before),
+		After:      auditSummary(after),
+	}
+
+	if actor != nil {
+		entry.ActorID = actor.UserID
+		entry.IP = actor.IP
+		entry.UserAgent = actor.UserAgent
+		if actor.Username != "" {
+			entry.ActorName = actor.Username
+		}
+	}
+
+	if err := db.Create(&entry).Error; err != nil {
+		logger.Error("Failed to record audit log", zap.String("action", action), zap.Uint("entity_id", entityId), zap.Error(err))
+	}
+}
+
+func userAuditSummary(user models.User) map[string]interface{} {
+	return map[string]interface{}{
+		"username":    user.Username,
+		"email":       user.Email,
+		"admin":       user.Admin,
+		"permissions": user.Permissions,
+		"photo":       user.Photo,
+	}
+}
+
+func documentationAuditSummary(doc models.Documentation) map[string]interface{} {
+	summary := map[string]interface{}{
+		"name":        doc.Name,
+		"version":     doc.Version,
+		"baseURL":     doc.BaseURL,
+		"description": doc.Description,
+		"requireAuth": doc.RequireAuth,
+	}
+
+	if doc.ClonedFrom != nil {
+		summary["clonedFrom"] = *doc.ClonedFrom
+	}
+
+	return summary
+}
+
+func pageAuditSummary(page models.Page) map[string]interface{} {
+	summary := map[string]interface{}{
+		"documentationId": page.DocumentationID,
+		"title":           page.Title,
+		"slug":            page.Slug,
+		"contentLength":   len(page.Content),
+	}
+
+	if page.PageGroupID != nil {
+		summary["pageGroupId"] = *page.PageGroupID
+	}
+
+	if page.Order != nil {
+		summary["order"] = *page.Order
+	}
+
+	if page.TranslationOfID != nil {
+		summary["language"] = page.Language
+		summary["translationOfId"] = *page.TranslationOfID
+	}
+
+	return summary
+}
+
+func pageGroupAuditSummary(group models.PageGroup) map[string]interface{} {
+	summary := map[string]interface{}{
+		"documentationId": group.DocumentationID,
+		"name":            group.Name,
+	}
+
+	if group.ParentID != nil {
+		summary["parentId"] = *group.ParentID
+	}
+
+	if group.Order != nil {
+		summary["order"] = *group.Order
+	}
+
+	return summary
+}
+
+type AuditLogOptions struct {
+	ActorID    uint
+	Action     string
+	EntityType string
+	EntityID   uint
+	Since      *time.Time
+	Until      *time.Time
+	Limit      int
+	Offset     int
+}
+
+func (service *AuthService) auditLogQuery(opts AuditLogOptions) *gorm.DB {
+	query := service.DB.Model(&models.AuditLog{})
+
+	if opts.ActorID != 0 {
+		query = query.Where("actor_id = ?", opts.ActorID)
+	}
+
+	if opts.Action != "" {
+		query = query.Where("action = ?", opts.Action)
+	}
+
+	if opts.EntityType != "" {
+		query = query.Where("entity_type = ?", opts.EntityType)
+	}
+
+	if opts.EntityID != 0 {
+		query = query.Where("entity_id = ?", opts.EntityID)
+	}
+
+	if opts.Since != nil {
+		query = query.Where("created_at >= ?", opts.Since.UTC())
+	}
+
+	if opts.Until != nil {
+		query = query.Where("created_at < ?", opts.Until.UTC())
+	}
+
+	return query
+}
+
+func (service *AuthService) GetAuditLogs(opts AuditLogOptions) ([]models.AuditLog, int64, error) {
+	if opts.Limit <= 0 || opts.Limit > 100 {
+		opts.Limit = 50
+	}
+
+	if opts.Offset < 0 {
+		opts.Offset = 0
+	}
+
+	var total int64
+	if err := service.auditLogQuery(opts).Count(&total).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed_to_get_audit_logs")
+	}
+
+	entries := make([]models.AuditLog, 0)
+	if err := service.auditLogQuery(opts).Order("id DESC").Limit(opts.Limit).Offset(opts.Offset).Find(&entries).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed_to_get_audit_logs")
+	}
+
+	return entries, total, nil
+}
+
+// ExportAuditLogs writes every entry matching the filters, oldest first, as
+// CSV or JSON lines. Limit and Offset are ignored.
+func (service *AuthService) ExportAuditLogs(w io.Writer, format string, opts AuditLogOptions) error {
+	if format != "csv" && format != "jsonl" {
+		return fmt.Errorf("invalid_format")
+	}
+
+	var csvWriter *csv.Writer
+	if format == "csv" {
+		csvWriter = csv.NewWriter(w)
+		if err := csvWriter.Write([]string{"id", "created_at", "actor_id", "actor_name", "action", "entity_type", "entity_id", "before", "after", "ip", "user_agent"}); err != nil {
+			return fmt.Errorf("failed_to_export_audit_logs")
+		}
+	}
+
+	encoder := json.NewEncoder(w)
+	var batch []models.AuditLog
+
+	result := service.auditLogQuery(opts).Order("id ASC").FindInBatches(&batch, 500, func(tx *gorm.DB, _ int) error {
+		for _, entry := range batch {
+			if csvWriter == nil {
+				if err := encoder.Encode(entry); err != nil {
+					return err
+				}
+				continue
+			}
+
+			actorId := ""
+			if entry.ActorID != nil {
+				actorId = strconv.FormatUint(uint64(*entry.ActorID), 10)
+			}
+
+			if err := csvWriter.Write([]string{
+				strconv.FormatUint(uint64(entry.ID), 10),
+				entry.CreatedAt.UTC().Format(time.RFC3339),
+				actorId,
+				entry.ActorName,
+				entry.Action,
+				entry.EntityType,
+				strconv.FormatUint(uint64(entry.EntityID), 10),
+				entry.Before,
+				entry.After,
+				entry.IP,
+				entry.UserAgent,
+			}); err != nil {
+				return err
+			}
+		}
+
+		if csvWriter != nil {
+			csvWriter.Flush()
+			return csvWriter.Error()
+		}
+
+		return nil
+	})
+
+	if result.Error != nil {
+		return fmt.Errorf("failed_to_export_audit_logs")
+	}
+
+	if csvWriter != nil {
+		csvWriter.Flush()
+		if err := csvWriter.Error(); err != nil {
+			return fmt.Errorf("failed_to_export_audit_logs")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,149 @@
+package services
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"git.difuse.io/Difuse/kalmia/db/models"
+)
+
+func TestAuditLog(t *testing.T) {
+	if TestAuthService == nil || TestDocService == nil {
+		t.Fatal("TestAuthService or TestDocService is nil")
+	}
+
+	var admin models.User
+	if err := TestAuthService.DB.Where("username = ?", "admin").First(&admin).Error; err != nil {
+		t.Fatalf("Failed to get admin: %v", err)
+	}
+
+	request := httptest.NewRequest("POST", "/kal-api/auth/user/edit", nil)
+	request.Header.Set("X-Forwarded-For", "198.51.100.1, 203.0.113.7, 10.0.0.1")
+	request.Header.Set("User-Agent", "audit-test")
+	request = request.WithContext(ContextWithAuditActor(request.Context(), AuditActor{UserID: &admin.ID, Username: admin.Username}))
+
+	if actor := AuditActorFromRequest(request); actor.IP != "192.0.2.1" {
+		t.Fatalf("Expected the peer address without trusted proxies, got %q", actor.IP)
+	}
+
+	TestConfig.TrustedProxies = []string{"192.0.2.1", "10.0.0.0/8"}
+	defer func() {
+		TestConfig.TrustedProxies = nil
+	}()
+
+	actor := AuditActorFromRequest(request)
+	if actor.IP != "203.0.113.7" || actor.UserAgent != "audit-test" {
+		t.Fatalf("Expected the client address and agent, got %q and %q", actor.IP, actor.UserAgent)
+	}
+
+	authService := TestAuthService.WithActor(actor)
+
+	if err := authService.CreateUser("audited", "audited@kalmia.difuse.io", "audited", false, []string{"read"}); err != nil {
+		t.Fatalf("CreateUser returned an error: %v", err)
+	}
+
+	var audited models.User
+	if err := TestAuthService.DB.Where("username = ?", "audited").First(&audited).Error; err != nil {
+		t.Fatalf("Failed to get the created user: %v", err)
+	}
+
+	if err := authService.EditUser(audited.ID, "", "", "", "", 1, nil); err != nil {
+		t.Fatalf("EditUser returned an error: %v", err)
+	}
+
+	if err := authService.DeleteUser("audited"); err != nil {
+		t.Fatalf("DeleteUser returned an error: %v", err)
+	}
+
+	opts := AuditLogOptions{EntityType: AuditEntityUser, EntityID: audited.ID}
+
+	t.Run("Recorded", func(t *testing.T) {
+		entries, total, err := TestAuthService.GetAuditLogs(opts)
+		if err != nil || total != 3 {
+			t.Fatalf("Expected 3 entries, got %d (%v)", total, err)
+		}
+
+		if entries[0].Action != "user.delete" || entries[2].Action != "user.create" {
+			t.Errorf("Expected the newest entry first, got %s and %s", entries[0].Action, entries[2].Action)
+		}
+
+		edit := entries[1]
+		if edit.ActorID == nil || *edit.ActorID != admin.ID || edit.ActorName != "admin" || edit.IP != "203.0.113.7" {
+			t.Errorf("Expected the edit to be attributed to the admin, got %+v", edit)
+		}
+
+		var before, after map[string]interface{}
+		json.Unmarshal([]byte(edit.Before), &before)
+		json.Unmarshal([]byte(edit.After), &after)
+		if before["admin"] != false || after["admin"] != true {
+			t.Errorf("Expected the admin flag change in the summary, got %s -> %s", edit.Before, edit.After)
+		}
+	})
+
+	t.Run("Append only", func(t *testing.T) {
+		var entry models.AuditLog
+		if err := TestAuthService.DB.Where("entity_id = ?", audited.ID).First(&entry).Error; err != nil {
+			t.Fatalf("Failed to get an entry: %v", err)
+		}
+
+		if err := TestAuthService.DB.Model(&entry).Update("action", "tampered").Error; err == nil {
+			t.Error("Expected updating an entry to fail")
+		}
+
+		if err := TestAuthService.DB.Delete(&entry).Error; err == nil {
+			t.Error("Expected deleting an entry to fail")
+		}
+	})
+
+	t.Run("System actor", func(t *testing.T) {
+		doc := models.Documentation{Name: "Audit", Version: "1.0.0", BaseURL: "/audit-doc", AuthorID: admin.ID}
+		if err := TestDocService.DB.Create(&doc).Error; err != nil {
+			t.Fatalf("Failed to create documentation: %v", err)
+		}
+
+		page := models.Page{DocumentationID: doc.ID, Title: "Audit", Slug: "/audit", Content: "[]", AuthorID: admin.ID}
+		if err := TestDocService.CreatePage(&page); err != nil {
+			t.Fatalf("CreatePage returned an error: %v", err)
+		}
+
+		entries, _, err := TestAuthService.GetAuditLogs(AuditLogOptions{Action: "page.create", EntityID: page.ID})
+		if err != nil || len(entries) != 1 || entries[0].ActorName != "system" || entries[0].ActorID != nil {
+			t.Errorf("Expected one page.create entry by the system, got %+v (%v)", entries, err)
+		}
+	})
+
+	t.Run("Export", func(t *testing.T) {
+		var buffer bytes.Buffer
+		if err := TestAuthService.ExportAuditLogs(&buffer, "csv", opts); err != nil {
+			t.Fatalf("ExportAuditLogs returned an error: %v", err)
+		}
+
+		records, err := csv.NewReader(&buffer).ReadAll()
+		if err != nil || len(records) != 4 || records[1][4] != "user.create" {
+			t.Errorf("Expected a header and 3 rows oldest first, got %v (%v)", records, err)
+		}
+
+		buffer.Reset()
+		if err := TestAuthService.ExportAuditLogs(&buffer, "jsonl", opts); err != nil {
+			t.Fatalf("ExportAuditLogs returned an error: %v", err)
+		}
+
+		lines := strings.Split(strings.TrimSpace(buffer.String()), "\n")
+		if len(lines) != 3 {
+			t.Fatalf("Expected 3 lines, got %d", len(lines))
+		}
+
+		var entry models.AuditLog
+		if err := json.Unmarshal([]byte(lines[2]), &entry); err != nil || entry.Action != "user.delete" {
+			t.Errorf("Expected the last line to be the delete, got %s (%v)", lines[2], err)
+		}
+
+		if err := TestAuthService.ExportAuditLogs(&buffer, "xml", opts); err == nil {
+			t.Error("Expected an unknown format to be rejected")
+		}
+	})
+}
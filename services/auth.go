@@ -3,6 +3,7 @@ package services
 import (
 	"encoding/json"
 	"fmt"
+	"sync"
 
 	"git.difuse.io/Difuse/kalmia/config"
 	"git.difuse.io/Difuse/kalmia/db/models"
@@ -12,10 +13,19 @@ import (
 
 type AuthService struct {
 	DB *gorm.DB
+
+	// Stream tickets that haven't been used yet, by ticket.
+	streamTickets *sync.Map
+
+	// Who the changes made through this service are attributed to.
+	actor *AuditActor
 }
 
 func NewAuthService(db *gorm.DB) *AuthService {
-	return &AuthService{DB: db}
+	return &AuthService{
+		DB:            db,
+		streamTickets: &sync.Map{},
+	}
 }
 
 func (service *AuthService) GetUsers() ([]models.User, error) {
@@ -60,6 +70,8 @@ func (service *AuthService) CreateJWT(username, password string) (map[string]int
 		return nil, fmt.Errorf("invalid_jwt_created")
 	}
 
+	service.auditLogin(user, newToken)
+
 	return map[string]interface{}{
 		"token":       tokenString,
 		"expiry":      claims.ExpiresAt.Time.String(),
@@ -73,6 +85,12 @@ func (service *AuthService) CreateJWT(username, password string) (map[string]int
 }
 
 func (service *AuthService) VerifyTokenInDb(token string, needAdmin bool) bool {
+	if IsAPIToken(token) {
+		// API tokens never act as an admin, see IsTokenAdmin.
+		_, _, err := service.getAPIToken(token)
+		return err == nil && !needAdmin
+	}
+
 	var tokenRecord models.Token
 
 	query := service.DB.Joins("JOIN users ON users.id = tokens.user_id").Where("tokens.token = ?", token).First(&tokenRecord)
@@ -96,6 +114,10 @@ func (service *AuthService) VerifyTokenInDb(token string, needAdmin bool) bool {
 }
 
 func (service *AuthService) IsTokenAdmin(token string) bool {
+	if IsAPIToken(token) {
+		return false
+	}
+
 	var tokenRecord models.Token
 
 	query := service.DB.
@@ -116,22 +138,37 @@ func (service *AuthService) IsTokenAdmin(token string) bool {
 	return user.Admin
 }
 
+// GetUserPermissions returns the global permissions of the token's user,
+// including the ones granted through their groups.
 func (service *AuthService) GetUserPermissions(token string) ([]string, error) {
-	user, err := service.GetUserFromToken(token)
-	if err != nil {
-		return nil, err
+	if IsAPIToken(token) {
+		apiToken, user, err := service.getAPIToken(token)
+		if err != nil {
+			return nil, err
+		}
+
+		userPermissions, err := combinedUserPermissions(service.DB, user)
+		if err != nil {
+			return nil, err
+		}
+
+		return apiTokenEffectivePermissions(apiToken, user, userPermissions)
 	}
 
-	var permissions []string
-	err = json.Unmarshal([]byte(user.Permissions), &permissions)
+	user, err := service.GetUserFromToken(token)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse permissions: %w", err)
+		return nil, err
 	}
 
-	return permissions, nil
+	return combinedUserPermissions(service.DB, user)
 }
 
 func (service *AuthService) GetUserFromToken(token string) (models.User, error) {
+	if IsAPIToken(token) {
+		_, user, err := service.getAPIToken(token)
+		return user, err
+	}
+
 	var tokenRecord models.Token
 
 	query := service.DB.Where("token = ?", token).First(&tokenRecord)
@@ -177,6 +214,8 @@ func (service *AuthService) CreateUser(username, email, password string, admin b
 		return fmt.Errorf("failed_to_create_user")
 	}
 
+	service.audit("user.create", AuditEntityUser, user.ID, nil, userAuditSummary(user))
+
 	return nil
 }
 
@@ -187,6 +226,8 @@ func (service *AuthService) EditUser(id uint, username, email, password, photo s
 		return fmt.Errorf("user_not_found")
 	}
 
+	before := userAuditSummary(user)
+
 	if username != "" {
 		user.Username = username
 	}
@@ -228,6 +269,10 @@ func (service *AuthService) EditUser(id uint, username, email, password, photo s
 		return fmt.Errorf("failed_to_edit_user")
 	}
 
+	after := userAuditSummary(user)
+	after["passwordChanged"] = password != ""
+	service.audit("user.edit", AuditEntityUser, user.ID, before, after)
+
 	return nil
 }
 
@@ -238,10 +283,20 @@ func (service *AuthService) DeleteUser(username string) error {
 		return fmt.Errorf("user_not_found")
 	}
 
+	if err := service.DB.Exec("DELETE FROM group_users WHERE user_id = ?", user.ID).Error; err != nil {
+		return fmt.Errorf("failed_to_delete_user_groups")
+	}
+
+	if err := service.DB.Where("user_id = ?", user.ID).Delete(&models.DocumentationMember{}).Error; err != nil {
+		return fmt.Errorf("failed_to_delete_user_roles")
+	}
+
 	if err := service.DB.Delete(&user).Error; err != nil {
 		return fmt.Errorf("failed_to_delete_user")
 	}
 
+	service.audit("user.delete", AuditEntityUser, user.ID, userAuditSummary(user), nil)
+
 	return nil
 }
 
@@ -283,6 +338,8 @@ func (service *AuthService) CreateJWTFromEmail(email string) (string, error) {
 		return "", fmt.Errorf("invalid_jwt_created")
 	}
 
+	service.auditLogin(user, newToken)
+
 	return tokenString, nil
 }
 
@@ -365,6 +422,8 @@ func (service *AuthService) RevokeJWT(token string) error {
 		return fmt.Errorf("failed_to_delete_token")
 	}
 
+	service.audit("jwt.revoke", AuditEntityToken, tokenRecord.ID, map[string]interface{}{"userId": tokenRecord.UserID}, nil)
+
 	return nil
 }
 
@@ -397,3 +456,15 @@ func (service *AuthService) OAuthProviders() []string {
 
 	return providers
 }
+
+// auditLogin records a new session. Logins are unauthenticated, so the user
+// logging in is the actor.
+func (service *AuthService) auditLogin(user models.User, token models.Token) {
+	actor := AuditActor{UserID: &user.ID, Username: user.Username}
+	if service.actor != nil {
+		actor.IP = service.actor.IP
+		actor.UserAgent = service.actor.UserAgent
+	}
+
+	recordAudit(service.DB, &actor, "jwt.create", AuditEntityToken, token.ID, nil, map[string]interface{}{"userId": user.ID, "expiry": token.Expiry})
+}
@@ -0,0 +1,306 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"git.difuse.io/Difuse/kalmia/db/models"
+	"git.difuse.io/Difuse/kalmia/utils"
+	"gorm.io/gorm"
+)
+
+var groupPermissions = []string{"read", "write", "delete"}
+
+func marshalGroupPermissions(permissions []string) (string, error) {
+	for _, permission := range permissions {
+		if !utils.ArrayContains(groupPermissions, permission) {
+			return "", fmt.Errorf("invalid_permission")
+		}
+	}
+
+	if permissions == nil {
+		permissions = []string{}
+	}
+
+	encoded, err := json.Marshal(permissions)
+	if err != nil {
+		return "", fmt.Errorf("failed_to_marshal_permissions")
+	}
+
+	return string(encoded), nil
+}
+
+// combinedUserPermissions returns the user's own global permissions together
+// with the ones granted to the groups they belong to.
+func combinedUserPermissions(db *gorm.DB, user models.User) ([]string, error) {
+	var permissions []string
+	if err := json.Unmarshal([]byte(user.Permissions), &permissions); err != nil {
+		return nil, fmt.Errorf("failed to parse permissions: %w", err)
+	}
+
+	var groupPermissionSets []string
+	if err := db.Model(&models.Group{}).
+		Joins("JOIN group_users ON group_users.group_id = groups.id").
+		Where("group_users.user_id = ?", user.ID).
+		Pluck("groups.permissions", &groupPermissionSets).Error; err != nil {
+		return nil, fmt.Errorf("failed to get group permissions: %w", err)
+	}
+
+	for _, set := range groupPermissionSets {
+		var granted []string
+		if set == "" || json.Unmarshal([]byte(set), &granted) != nil {
+			continue
+		}
+
+		for _, permission := range granted {
+			if !utils.ArrayContains(permissions, permission) {
+				permissions = append(permissions, permission)
+			}
+		}
+	}
+
+	return permissions, nil
+}
+
+// groupDocumentationRole returns the highest role the user's groups hold on
+// a root documentation.
+func groupDocumentationRole(db *gorm.DB, userId uint, rootId uint) (string, error) {
+	var roles []string
+	if err := db.Model(&models.DocumentationGroupGrant{}).
+		Joins("JOIN group_users ON group_users.group_id = documentation_group_grants.group_id").
+		Where("documentation_group_grants.documentation_id = ? AND group_users.user_id = ?", rootId, userId).
+		Pluck("documentation_group_grants.role", &roles).Error; err != nil {
+		return "", fmt.Errorf("failed_to_get_role")
+	}
+
+	highest := ""
+	for _, role := range roles {
+		if roleRank(role) > roleRank(highest) {
+			highest = role
+		}
+	}
+
+	return highest, nil
+}
+
+func (service *AuthService) GetGroups() ([]models.Group, error) {
+	groups := make([]models.Group, 0)
+
+	if err := service.DB.Preload("Users", func(db *gorm.DB) *gorm.DB {
+		return db.Select("ID", "Username", "Email", "Photo")
+	}).Preload("Grants").Order("name ASC").Find(&groups).Error; err != nil {
+		return nil, fmt.Errorf("failed_to_get_groups")
+	}
+
+	return groups, nil
+}
+
+func (service *AuthService) getGroup(id uint) (models.Group, error) {
+	var group models.Group
+	if err := service.DB.First(&group, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return models.Group{}, fmt.Errorf("group_not_found")
+		}
+		return models.Group{}, fmt.Errorf("failed_to_get_group")
+	}
+
+	return group, nil
+}
+
+func (service *AuthService) CreateGroup(name, description string, permissions []string) (models.Group, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return models.Group{}, fmt.Errorf("name_required")
+	}
+
+	encoded, err := marshalGroupPermissions(permissions)
+	if err != nil {
+		return models.Group{}, err
+	}
+
+	var count int64
+	if err := service.DB.Model(&models.Group{}).Where("name = ?", name).Count(&count).Error; err != nil {
+		return models.Group{}, fmt.Errorf("failed_to_check_group_name")
+	}
+
+	if count > 0 {
+		return models.Group{}, fmt.Errorf("group_name_already_exists")
+	}
+
+	group := models.Group{Name: name, Description: description, Permissions: encoded}
+	if err := service.DB.Create(&group).Error; err != nil {
+		return models.Group{}, fmt.Errorf("failed_to_create_group")
+	}
+
+	service.audit("group.create", AuditEntityGroup, group.ID, nil, groupAuditSummary(group))
+
+	return group, nil
+}
+
+// EditGroup updates a group, leaving out fields that are empty or, for
+// permissions, nil.
+func (service *AuthService) EditGroup(id uint, name, description string, permissions []string) error {
+	group, err := service.getGroup(id)
+	if err != nil {
+		return err
+	}
+
+	before := groupAuditSummary(group)
+
+	if name = strings.TrimSpace(name); name != "" && name != group.Name {
+		var count int64
+		if err := service.DB.Model(&models.Group{}).Where("name = ? AND id <> ?", name, id).Count(&count).Error; err != nil {
+			return fmt.Errorf("failed_to_check_group_name")
+		}
+
+		if count > 0 {
+			return fmt.Errorf("group_name_already_exists")
+		}
+
+		group.Name = name
+	}
+
+	if description != "" {
+		group.Description = description
+	}
+
+	if permissions != nil {
+		encoded, err := marshalGroupPermissions(permissions)
+		if err != nil {
+			return err
+		}
+		group.Permissions = encoded
+	}
+
+	if err := service.DB.Save(&group).Error; err != nil {
+		return fmt.Errorf("failed_to_update_group")
+	}
+
+	service.audit("group.edit", AuditEntityGroup, group.ID, before, groupAuditSummary(group))
+
+	return nil
+}
+
+func (service *AuthService) DeleteGroup(id uint) error {
+	group, err := service.getGroup(id)
+	if err != nil {
+		return err
+	}
+
+	err = service.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&group).Association("Users").Clear(); err != nil {
+			return fmt.Errorf("failed_to_clear_group_members")
+		}
+
+		if err := tx.Where("group_id = ?", id).Delete(&models.DocumentationGroupGrant{}).Error; err != nil {
+			return fmt.Errorf("failed_to_delete_group_grants")
+		}
+
+		if err := tx.Delete(&group).Error; err != nil {
+			return fmt.Errorf("failed_to_delete_group")
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	service.audit("group.delete", AuditEntityGroup, group.ID, groupAuditSummary(group), nil)
+
+	return nil
+}
+
+func (service *AuthService) AddGroupMember(groupId uint, userId uint) error {
+	group, err := service.getGroup(groupId)
+	if err != nil {
+		return err
+	}
+
+	user, err := service.GetUser(userId)
+	if err != nil {
+		return fmt.Errorf("user_not_found")
+	}
+
+	if err := service.DB.Model(&group).Association("Users").Append(&user); err != nil {
+		return fmt.Errorf("failed_to_add_group_member")
+	}
+
+	service.audit("group.member_add", AuditEntityGroup, groupId, nil, map[string]interface{}{"userId": userId})
+
+	return nil
+}
+
+func (service *AuthService) RemoveGroupMember(groupId uint, userId uint) error {
+	group, err := service.getGroup(groupId)
+	if err != nil {
+		return err
+	}
+
+	if err := service.DB.Model(&group).Association("Users").Delete(&models.User{ID: userId}); err != nil {
+		return fmt.Errorf("failed_to_remove_group_member")
+	}
+
+	service.audit("group.member_remove", AuditEntityGroup, groupId, map[string]interface{}{"userId": userId}, nil)
+
+	return nil
+}
+
+// GrantGroupDocumentation gives the group a role on the documentation,
+// replacing any role it held on it before.
+func (service *AuthService) GrantGroupDocumentation(groupId uint, docId uint, role string) error {
+	if !IsValidRole(role) {
+		return fmt.Errorf("invalid_role")
+	}
+
+	if _, err := service.getGroup(groupId); err != nil {
+		return err
+	}
+
+	rootId, err := rootDocumentationID(service.DB, docId)
+	if err != nil {
+		return fmt.Errorf("documentation_not_found")
+	}
+
+	grant := models.DocumentationGroupGrant{DocumentationID: rootId, GroupID: groupId}
+	if err := service.DB.Where("documentation_id = ? AND group_id = ?", rootId, groupId).
+		Assign(models.DocumentationGroupGrant{Role: role}).
+		FirstOrCreate(&grant).Error; err != nil {
+		return fmt.Errorf("failed_to_grant_documentation")
+	}
+
+	service.audit("group.grant", AuditEntityGroup, groupId, nil, map[string]interface{}{"documentationId": rootId, "role": role})
+
+	return nil
+}
+
+func (service *AuthService) RevokeGroupDocumentation(groupId uint, docId uint) error {
+	rootId, err := rootDocumentationID(service.DB, docId)
+	if err != nil {
+		return fmt.Errorf("documentation_not_found")
+	}
+
+	result := service.DB.Where("documentation_id = ? AND group_id = ?", rootId, groupId).Delete(&models.DocumentationGroupGrant{})
+	if result.Error != nil {
+		return fmt.Errorf("failed_to_revoke_documentation")
+	}
+
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("grant_not_found")
+	}
+
+	service.audit("group.revoke", AuditEntityGroup, groupId, map[string]interface{}{"documentationId": rootId}, nil)
+
+	return nil
+}
+
+func groupAuditSummary(group models.Group) map[string]interface{} {
+	return map[string]interface{}{
+		"name":        group.Name,
+		"description": group.Description,
+		"permissions": group.Permissions,
+	}
+}
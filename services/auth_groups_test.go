@@ -0,0 +1,101 @@
+package services
+
+import (
+	"testing"
+
+	"git.difuse.io/Difuse/kalmia/db/models"
+	"git.difuse.io/Difuse/kalmia/utils"
+)
+
+func TestGroups(t *testing.T) {
+	if TestAuthService == nil {
+		t.Fatal("TestAuthService is nil")
+	}
+
+	db := TestAuthService.DB
+
+	var admin, user models.User
+	if err := db.Where("username = ?", "admin").First(&admin).Error; err != nil {
+		t.Fatalf("Failed to get admin: %v", err)
+	}
+	if err := db.Where("username = ?", "user").First(&user).Error; err != nil {
+		t.Fatalf("Failed to get user: %v", err)
+	}
+
+	original := user.Permissions
+	defer db.Model(&models.User{}).Where("id = ?", user.ID).Update("permissions", original)
+	db.Model(&models.User{}).Where("id = ?", user.ID).Update("permissions", `["read"]`)
+
+	doc := models.Documentation{Name: "Groups", Version: "1.0.0", BaseURL: "/groups", AuthorID: admin.ID}
+	if err := db.Create(&doc).Error; err != nil {
+		t.Fatalf("Failed to create documentation: %v", err)
+	}
+
+	if _, err := TestAuthService.CreateGroup("writers", "", []string{"all"}); err == nil {
+		t.Error("Expected an unknown permission to be rejected")
+	}
+
+	group, err := TestAuthService.CreateGroup("writers", "Can write", []string{"write"})
+	if err != nil {
+		t.Fatalf("CreateGroup returned an error: %v", err)
+	}
+
+	if _, err := TestAuthService.CreateGroup("writers", "", nil); err == nil {
+		t.Error("Expected a duplicate group name to be rejected")
+	}
+
+	jwt, err := TestAuthService.CreateJWT("user", "user")
+	if err != nil {
+		t.Fatalf("CreateJWT returned an error: %v", err)
+	}
+	token := jwt["token"].(string)
+
+	t.Run("Permissions", func(t *testing.T) {
+		if err := TestAuthService.AddGroupMember(group.ID, user.ID); err != nil {
+			t.Fatalf("AddGroupMember returned an error: %v", err)
+		}
+
+		permissions, err := TestAuthService.GetUserPermissions(token)
+		if err != nil || !utils.ArrayContains(permissions, "read") || !utils.ArrayContains(permissions, "write") {
+			t.Errorf("Expected read and write, got %v (%v)", permissions, err)
+		}
+	})
+
+	t.Run("Documentation grants", func(t *testing.T) {
+		if TestAuthService.HasDocumentationRole(user, doc.ID, RoleViewer) {
+			t.Fatal("Expected no role before the grant")
+		}
+
+		if err := TestAuthService.GrantGroupDocumentation(group.ID, doc.ID, "admin"); err == nil {
+			t.Error("Expected an unknown role to be rejected")
+		}
+
+		if err := TestAuthService.GrantGroupDocumentation(group.ID, doc.ID, RoleEditor); err != nil {
+			t.Fatalf("GrantGroupDocumentation returned an error: %v", err)
+		}
+
+		if !TestAuthService.HasDocumentationRole(user, doc.ID, RoleEditor) {
+			t.Error("Expected the group grant to give the editor role")
+		}
+
+		visible, err := TestAuthService.VisibleDocumentationIDs(token)
+		if err != nil || !visible[doc.ID] {
+			t.Errorf("Expected documentation %d to be visible, got %v (%v)", doc.ID, visible, err)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		if err := TestAuthService.DeleteGroup(group.ID); err != nil {
+			t.Fatalf("DeleteGroup returned an error: %v", err)
+		}
+
+		if TestAuthService.HasDocumentationRole(user, doc.ID, RoleViewer) {
+			t.Error("Expected the role to go away with the group")
+		}
+
+		permissions, err := TestAuthService.GetUserPermissions(token)
+		if err != nil || utils.ArrayContains(permissions, "write") {
+			t.Errorf("Expected write to go away with the group, got %v (%v)", permissions, err)
+		}
+	})
+}
@@ -0,0 +1,62 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// Stream tickets stand in for a token on the event stream and websocket
+// routes, whose browser clients can't set headers. Unlike the token they
+// replace, a ticket is only good for one connection and only for a short
+// while, so it's harmless once it ends up in a log or the browser history.
+const StreamTicketTTL = 30 * time.Second
+
+type streamTicket struct {
+	token     string
+	expiresAt time.Time
+}
+
+// CreateStreamTicket returns a single-use ticket that authenticates a stream
+// as the token would, and when it expires.
+func (service *AuthService) CreateStreamTicket(token string) (string, time.Time, error) {
+	if !service.VerifyTokenInDb(token, false) {
+		return "", time.Time{}, fmt.Errorf("invalid_token")
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", time.Time{}, fmt.Errorf("failed_to_create_stream_ticket")
+	}
+
+	now := time.Now().UTC()
+	service.streamTickets.Range(func(key, value interface{}) bool {
+		if !value.(streamTicket).expiresAt.After(now) {
+			service.streamTickets.Delete(key)
+		}
+		return true
+	})
+
+	ticket := hex.EncodeToString(secret)
+	expiresAt := now.Add(StreamTicketTTL)
+	service.streamTickets.Store(ticket, streamTicket{token: token, expiresAt: expiresAt})
+
+	return ticket, expiresAt, nil
+}
+
+// RedeemStreamTicket returns the token a ticket was made for and forgets the
+// ticket, so it can't be used again.
+func (service *AuthService) RedeemStreamTicket(ticket string) (string, error) {
+	value, ok := service.streamTickets.LoadAndDelete(ticket)
+	if !ok {
+		return "", fmt.Errorf("invalid_stream_ticket")
+	}
+
+	entry := value.(streamTicket)
+	if !entry.expiresAt.After(time.Now().UTC()) {
+		return "", fmt.Errorf("stream_ticket_expired")
+	}
+
+	return entry.token, nil
+}
@@ -0,0 +1,262 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"git.difuse.io/Difuse/kalmia/db/models"
+	"git.difuse.io/Difuse/kalmia/utils"
+	"gorm.io/gorm"
+)
+
+// API tokens carry this prefix so they can be told apart from session JWTs
+// without a database lookup.
+const APITokenPrefix = "kal_"
+
+var apiTokenPermissions = []string{"read", "write", "delete"}
+
+// How stale last_used_at may get before a request refreshes it, so every
+// request doesn't turn into a write.
+const apiTokenUsageInterval = time.Minute
+
+func IsAPIToken(token string) bool {
+	return strings.HasPrefix(token, APITokenPrefix)
+}
+
+func hashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func generateAPIToken() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return APITokenPrefix + hex.EncodeToString(secret), nil
+}
+
+// getAPIToken returns a token that is neither revoked nor expired, along with
+// its owner.
+func (service *AuthService) getAPIToken(token string) (models.APIToken, models.User, error) {
+	var apiToken models.APIToken
+	if err := service.DB.Where("token_hash = ?", hashAPIToken(token)).First(&apiToken).Error; err != nil {
+		return models.APIToken{}, models.User{}, fmt.Errorf("token_not_found")
+	}
+
+	now := time.Now().UTC()
+
+	if apiToken.RevokedAt != nil {
+		return models.APIToken{}, models.User{}, fmt.Errorf("token_revoked")
+	}
+
+	if apiToken.ExpiresAt != nil && !apiToken.ExpiresAt.After(now) {
+		return models.APIToken{}, models.User{}, fmt.Errorf("token_expired")
+	}
+
+	var user models.User
+	if err := service.DB.Where("id = ?", apiToken.UserID).First(&user).Error; err != nil {
+		return models.APIToken{}, models.User{}, fmt.Errorf("user_not_found")
+	}
+
+	if apiToken.LastUsedAt == nil || now.Sub(*apiToken.LastUsedAt) > apiTokenUsageInterval {
+		service.DB.Model(&apiToken).UpdateColumn("last_used_at", now)
+	}
+
+	return apiToken, user, nil
+}
+
+// apiTokenEffectivePermissions narrows the token's permissions to the ones
+// its owner still holds, so taking a permission away from a user also takes
+// it away from their tokens.
+func apiTokenEffectivePermissions(apiToken models.APIToken, user models.User, userPermissions []string) ([]string, error) {
+	var tokenPermissions []string
+	if err := json.Unmarshal([]byte(apiToken.Permissions), &tokenPermissions); err != nil {
+		return nil, fmt.Errorf("failed to parse permissions: %w", err)
+	}
+
+	if user.Admin || utils.ArrayContains(userPermissions, "all") {
+		return tokenPermissions, nil
+	}
+
+	permissions := make([]string, 0, len(tokenPermissions))
+	for _, permission := range tokenPermissions {
+		if utils.ArrayContains(userPermissions, permission) {
+			permissions = append(permissions, permission)
+		}
+	}
+
+	return permissions, nil
+}
+
+// GetTokenDocumentations returns the root documentations an API token is
+// limited to, or nil when the token may access every documentation.
+func (service *AuthService) GetTokenDocumentations(token string) ([]uint, error) {
+	if !IsAPIToken(token) {
+		return nil, nil
+	}
+
+	apiToken, _, err := service.getAPIToken(token)
+	if err != nil {
+		return nil, err
+	}
+
+	if apiToken.Documentations == "" {
+		return nil, nil
+	}
+
+	var documentations []uint
+	if err := json.Unmarshal([]byte(apiToken.Documentations), &documentations); err != nil {
+		return nil, fmt.Errorf("failed to parse documentations: %w", err)
+	}
+
+	return documentations, nil
+}
+
+func (service *AuthService) GetAPITokens(userId uint) ([]models.APIToken, error) {
+	tokens := make([]models.APIToken, 0)
+
+	if err := service.DB.Where("user_id = ?", userId).Order("id DESC").Find(&tokens).Error; err != nil {
+		return nil, fmt.Errorf("failed_to_get_tokens")
+	}
+
+	return tokens, nil
+}
+
+// CreateAPIToken creates a token for user and returns it in plain text, the
+// only time it is available. Documentations are stored as their root
+// documentation, so a token also covers every version of them.
+func (service *AuthService) CreateAPIToken(user models.User, name string, permissions []string, documentationIds []uint, expiresAt *time.Time) (string, models.APIToken, error) {
+	if strings.TrimSpace(name) == "" {
+		return "", models.APIToken{}, fmt.Errorf("name_required")
+	}
+
+	if len(permissions) == 0 {
+		return "", models.APIToken{}, fmt.Errorf("permissions_required")
+	}
+
+	userPermissions, err := combinedUserPermissions(service.DB, user)
+	if err != nil {
+		return "", models.APIToken{}, fmt.Errorf("failed_to_parse_permissions")
+	}
+
+	for _, permission := range permissions {
+		if !utils.ArrayContains(apiTokenPermissions, permission) {
+			return "", models.APIToken{}, fmt.Errorf("invalid_permission")
+		}
+
+		if !user.Admin && !utils.ArrayContains(userPermissions, "all") && !utils.ArrayContains(userPermissions, permission) {
+			return "", models.APIToken{}, fmt.Errorf("permission_not_held")
+		}
+	}
+
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		return "", models.APIToken{}, fmt.Errorf("invalid_expiry")
+	}
+
+	var documentationsJSON string
+	if len(documentationIds) > 0 {
+		roots := make([]uint, 0, len(documentationIds))
+		for _, docId := range documentationIds {
+			rootId, err := rootDocumentationID(service.DB, docId)
+			if err != nil {
+				return "", models.APIToken{}, fmt.Errorf("documentation_not_found")
+			}
+
+			seen := false
+			for _, root := range roots {
+				if root == rootId {
+					seen = true
+					break
+				}
+			}
+
+			if !seen {
+				roots = append(roots, rootId)
+			}
+		}
+
+		encoded, err := json.Marshal(roots)
+		if err != nil {
+			return "", models.APIToken{}, fmt.Errorf("failed_to_marshal_documentations")
+		}
+		documentationsJSON = string(encoded)
+	}
+
+	permissionsJSON, err := json.Marshal(permissions)
+	if err != nil {
+		return "", models.APIToken{}, fmt.Errorf("failed_to_marshal_permissions")
+	}
+
+	token, err := generateAPIToken()
+	if err != nil {
+		return "", models.APIToken{}, fmt.Errorf("failed_to_generate_token")
+	}
+
+	if expiresAt != nil {
+		utc := expiresAt.UTC()
+		expiresAt = &utc
+	}
+
+	apiToken := models.APIToken{
+		UserID:         user.ID,
+		Name:           strings.TrimSpace(name),
+		TokenHash:      hashAPIToken(token),
+		Prefix:         token[:len(APITokenPrefix)+8],
+		Permissions:    string(permissionsJSON),
+		Documentations: documentationsJSON,
+		ExpiresAt:      expiresAt,
+	}
+
+	if err := service.DB.Create(&apiToken).Error; err != nil {
+		return "", models.APIToken{}, fmt.Errorf("failed_to_create_token")
+	}
+
+	service.audit("api_token.create", AuditEntityAPIToken, apiToken.ID, nil, apiTokenAuditSummary(apiToken))
+
+	return token, apiToken, nil
+}
+
+// RevokeAPIToken revokes one of the user's tokens, admins may revoke anyone's.
+func (service *AuthService) RevokeAPIToken(user models.User, id uint) error {
+	var apiToken models.APIToken
+	if err := service.DB.First(&apiToken, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("token_not_found")
+		}
+		return fmt.Errorf("failed_to_get_token")
+	}
+
+	if apiToken.UserID != user.ID && !user.Admin {
+		return fmt.Errorf("token_not_found")
+	}
+
+	if apiToken.RevokedAt != nil {
+		return fmt.Errorf("token_already_revoked")
+	}
+
+	if err := service.DB.Model(&apiToken).Update("revoked_at", time.Now().UTC()).Error; err != nil {
+		return fmt.Errorf("failed_to_revoke_token")
+	}
+
+	service.audit("api_token.revoke", AuditEntityAPIToken, apiToken.ID, apiTokenAuditSummary(apiToken), nil)
+
+	return nil
+}
+
+func apiTokenAuditSummary(apiToken models.APIToken) map[string]interface{} {
+	return map[string]interface{}{
+		"userId":         apiToken.UserID,
+		"name":           apiToken.Name,
+		"prefix":         apiToken.Prefix,
+		"permissions":    apiToken.Permissions,
+		"documentations": apiToken.Documentations,
+		"expiresAt":      apiToken.ExpiresAt,
+	}
+}
@@ -0,0 +1,132 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"git.difuse.io/Difuse/kalmia/db/models"
+)
+
+func TestAPITokens(t *testing.T) {
+	if TestAuthService == nil {
+		t.Fatal("TestAuthService is nil")
+	}
+
+	var admin, user models.User
+	if err := TestAuthService.DB.Where("username = ?", "admin").First(&admin).Error; err != nil {
+		t.Fatalf("Failed to get admin: %v", err)
+	}
+	if err := TestAuthService.DB.Where("username = ?", "user").First(&user).Error; err != nil {
+		t.Fatalf("Failed to get user: %v", err)
+	}
+
+	doc := models.Documentation{Name: "API Tokens", Version: "1.0.0", BaseURL: "/api-tokens", AuthorID: admin.ID}
+	if err := TestAuthService.DB.Create(&doc).Error; err != nil {
+		t.Fatalf("Failed to create documentation: %v", err)
+	}
+
+	version := models.Documentation{Name: "API Tokens", Version: "2.0.0", BaseURL: "/api-tokens", AuthorID: admin.ID, ClonedFrom: &doc.ID}
+	if err := TestAuthService.DB.Create(&version).Error; err != nil {
+		t.Fatalf("Failed to create version: %v", err)
+	}
+
+	t.Run("Validation", func(t *testing.T) {
+		if _, _, err := TestAuthService.CreateAPIToken(admin, "ci", []string{"all"}, nil, nil); err == nil {
+			t.Error("Expected an unknown permission to be rejected")
+		}
+
+		past := time.Now().Add(-time.Hour)
+		if _, _, err := TestAuthService.CreateAPIToken(admin, "ci", []string{"read"}, nil, &past); err == nil {
+			t.Error("Expected an expiry in the past to be rejected")
+		}
+
+		if _, _, err := TestAuthService.CreateAPIToken(admin, "ci", []string{"read"}, []uint{999999}, nil); err == nil {
+			t.Error("Expected an unknown documentation to be rejected")
+		}
+	})
+
+	t.Run("Scoped", func(t *testing.T) {
+		token, apiToken, err := TestAuthService.CreateAPIToken(admin, "ci", []string{"read", "write"}, []uint{version.ID}, nil)
+		if err != nil {
+			t.Fatalf("CreateAPIToken returned an error: %v", err)
+		}
+
+		if !IsAPIToken(token) || apiToken.TokenHash == token {
+			t.Fatal("Expected a prefixed token stored as a hash")
+		}
+
+		if !TestAuthService.VerifyTokenInDb(token, false) {
+			t.Error("Expected the token to be valid")
+		}
+
+		if TestAuthService.VerifyTokenInDb(token, true) || TestAuthService.IsTokenAdmin(token) {
+			t.Error("Expected API tokens to never act as an admin")
+		}
+
+		permissions, err := TestAuthService.GetUserPermissions(token)
+		if err != nil || !reflect.DeepEqual(permissions, []string{"read", "write"}) {
+			t.Errorf("Expected [read write], got %v (%v)", permissions, err)
+		}
+
+		owner, err := TestAuthService.GetUserFromToken(token)
+		if err != nil || owner.ID != admin.ID {
+			t.Errorf("Expected the token to belong to the admin, got %d (%v)", owner.ID, err)
+		}
+
+		scope, err := TestAuthService.GetTokenDocumentations(token)
+		if err != nil || !reflect.DeepEqual(scope, []uint{doc.ID}) {
+			t.Errorf("Expected the scope to be the root documentation %d, got %v (%v)", doc.ID, scope, err)
+		}
+
+		if err := TestAuthService.RevokeAPIToken(user, apiToken.ID); err == nil {
+			t.Error("Expected another user's token to be out of reach")
+		}
+
+		if err := TestAuthService.RevokeAPIToken(admin, apiToken.ID); err != nil {
+			t.Fatalf("RevokeAPIToken returned an error: %v", err)
+		}
+
+		if TestAuthService.VerifyTokenInDb(token, false) {
+			t.Error("Expected a revoked token to be rejected")
+		}
+	})
+
+	t.Run("Permissions follow the owner", func(t *testing.T) {
+		original := user.Permissions
+		defer TestAuthService.DB.Model(&models.User{}).Where("id = ?", user.ID).Update("permissions", original)
+
+		user.Permissions = `["read"]`
+		TestAuthService.DB.Model(&models.User{}).Where("id = ?", user.ID).Update("permissions", user.Permissions)
+
+		if _, _, err := TestAuthService.CreateAPIToken(user, "ci", []string{"delete"}, nil, nil); err == nil {
+			t.Error("Expected a permission the user doesn't hold to be rejected")
+		}
+
+		token, _, err := TestAuthService.CreateAPIToken(user, "ci", []string{"read"}, nil, nil)
+		if err != nil {
+			t.Fatalf("CreateAPIToken returned an error: %v", err)
+		}
+
+		TestAuthService.DB.Model(&models.User{}).Where("id = ?", user.ID).Update("permissions", `["write"]`)
+
+		permissions, err := TestAuthService.GetUserPermissions(token)
+		if err != nil || len(permissions) != 0 {
+			t.Errorf("Expected no permissions once the user lost them, got %v (%v)", permissions, err)
+		}
+	})
+
+	t.Run("Expiry", func(t *testing.T) {
+		expiry := time.Now().Add(time.Hour)
+		token, apiToken, err := TestAuthService.CreateAPIToken(admin, "short", []string{"read"}, nil, &expiry)
+		if err != nil {
+			t.Fatalf("CreateAPIToken returned an error: %v", err)
+		}
+
+		TestAuthService.DB.Model(&apiToken).Update("expires_at", time.Now().UTC().Add(-time.Minute))
+
+		if TestAuthService.VerifyTokenInDb(token, false) {
+			t.Error("Expected an expired token to be rejected")
+		}
+	})
+}
@@ -0,0 +1,105 @@
+package services
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	BuildEventTriggerCreated = "trigger_created"
+	BuildEventBuildStarted   = "build_started"
+	BuildEventStepFinished   = "step_finished"
+	BuildEventCacheRefreshed = "cache_refreshed"
+	BuildEventGitDeploy      = "git_deploy"
+	BuildEventRetryScheduled = "retry_scheduled"
+	BuildEventBuildFinished  = "build_finished"
+)
+
+// How many past events are kept so a reconnecting client can catch up with
+// Last-Event-ID, and how many may queue up for a slow subscriber before it
+// starts missing them.
+const (
+	buildEventHistory = 256
+	buildEventBuffer  = 64
+)
+
+type BuildEvent struct {
+	ID              uint64     `json:"id"`
+	Type            string     `json:"type"`
+	DocumentationID uint       `json:"documentationId"`
+	TriggerID       uint       `json:"triggerId,omitempty"`
+	Attempt         int        `json:"attempt,omitempty"`
+	Step            string     `json:"step,omitempty"`
+	Status          string     `json:"status,omitempty"`
+	DurationMs      int64      `json:"durationMs,omitempty"`
+	Error           string     `json:"error,omitempty"`
+	NextAttemptAt   *time.Time `json:"nextAttemptAt,omitempty"`
+	Time            time.Time  `json:"time"`
+}
+
+type BuildEventBroker struct {
+	mutex       sync.Mutex
+	nextID      uint64
+	history     []BuildEvent
+	subscribers map[chan BuildEvent]struct{}
+}
+
+func NewBuildEventBroker() *BuildEventBroker {
+	return &BuildEventBroker{subscribers: make(map[chan BuildEvent]struct{})}
+}
+
+// Publish never blocks the build; subscribers that fall behind drop events.
+func (broker *BuildEventBroker) Publish(event BuildEvent) {
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+
+	broker.nextID++
+	event.ID = broker.nextID
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+
+	broker.history = append(broker.history, event)
+	if len(broker.history) > buildEventHistory {
+		broker.history = broker.history[len(broker.history)-buildEventHistory:]
+	}
+
+	for subscriber := range broker.subscribers {
+		select {
+		case subscriber <- event:
+		default:
+		}
+	}
+}
+
+// Subscribe returns the events published after lastID that are still in the
+// history, and a channel for the ones that follow. The returned function
+// must be called to unsubscribe.
+func (broker *BuildEventBroker) Subscribe(lastID uint64) ([]BuildEvent, <-chan BuildEvent, func()) {
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+
+	var missed []BuildEvent
+	if lastID > 0 {
+		for _, event := range broker.history {
+			if event.ID > lastID {
+				missed = append(missed, event)
+			}
+		}
+	}
+
+	subscriber := make(chan BuildEvent, buildEventBuffer)
+	broker.subscribers[subscriber] = struct{}{}
+
+	unsubscribe := func() {
+		broker.mutex.Lock()
+		defer broker.mutex.Unlock()
+
+		if _, ok := broker.subscribers[subscriber]; ok {
+			delete(broker.subscribers, subscriber)
+			close(subscriber)
+		}
+	}
+
+	return missed, subscriber, unsubscribe
+}
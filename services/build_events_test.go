@@ -0,0 +1,44 @@
+package services
+
+import (
+	"testing"
+)
+
+func TestBuildEventBroker(t *testing.T) {
+	broker := NewBuildEventBroker()
+
+	broker.Publish(BuildEvent{Type: BuildEventTriggerCreated, DocumentationID: 1})
+	broker.Publish(BuildEvent{Type: BuildEventBuildStarted, DocumentationID: 1})
+
+	missed, events, unsubscribe := broker.Subscribe(1)
+
+	if len(missed) != 1 || missed[0].ID != 2 || missed[0].Type != BuildEventBuildStarted {
+		t.Fatalf("Expected to catch up on event 2, got %+v", missed)
+	}
+
+	broker.Publish(BuildEvent{Type: BuildEventBuildFinished, DocumentationID: 1, Status: BuildStatusSucceeded})
+
+	event := <-events
+	if event.ID != 3 || event.Type != BuildEventBuildFinished || event.Time.IsZero() {
+		t.Errorf("Unexpected event: %+v", event)
+	}
+
+	// A subscriber that stops reading must not block publishing.
+	for i := 0; i < buildEventBuffer*2; i++ {
+		broker.Publish(BuildEvent{Type: BuildEventStepFinished})
+	}
+
+	unsubscribe()
+	unsubscribe()
+
+	if _, ok := <-events; !ok {
+		t.Errorf("Expected buffered events to remain readable after unsubscribe")
+	}
+
+	missed, _, unsubscribe = broker.Subscribe(0)
+	defer unsubscribe()
+
+	if len(missed) != 0 {
+		t.Errorf("Expected no replay without Last-Event-ID, got %d events", len(missed))
+	}
+}
@@ -0,0 +1,216 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+	"unicode/utf8"
+
+	"git.difuse.io/Difuse/kalmia/db/models"
+	"git.difuse.io/Difuse/kalmia/logger"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	BuildStatusQueued    = "queued"
+	BuildStatusRunning   = "running"
+	BuildStatusSucceeded = "succeeded"
+	BuildStatusFailed    = "failed"
+	BuildStatusCancelled = "cancelled"
+
+	// The documentation's site was removed and nothing was built, the
+	// documentation is gone.
+	BuildStatusDeleted = "deleted"
+)
+
+const (
+	BuildStepDelete    = "delete"
+	BuildStepLinkCheck = "link_check"
+	BuildStepWrite     = "write"
+	BuildStepInstall   = "install"
+	BuildStepTailwind  = "tailwind"
+	BuildStepRsPress   = "rspress_build"
+	BuildStepSiteFiles = "site_files"
+	BuildStepCache     = "cache"
+	BuildStepGitDeploy = "git_deploy"
+)
+
+// Output past this is cut from the front, the end of a log is what explains
+// a failure.
+const maxBuildStepOutput = 64 << 10
+
+type buildRecorder struct {
+	db              *gorm.DB
+	events          *BuildEventBroker
+	documentationID uint
+	triggerID       uint
+	attempt         int
+}
+
+func truncateBuildOutput(output string) string {
+	if len(output) <= maxBuildStepOutput {
+		return output
+	}
+
+	// Cut at the start of a rune, so what's kept is still valid UTF-8.
+	start := len(output) - maxBuildStepOutput
+	for start < len(output) && !utf8.RuneStart(output[start]) {
+		start++
+	}
+
+	return "[output truncated]\n" + output[start:]
+}
+
+func (recorder *buildRecorder) run(name string, fn func() (string, error)) error {
+	start := time.Now().UTC()
+
+	step := models.BuildStep{
+		TriggerID: recorder.triggerID,
+		Attempt:   recorder.attempt,
+		Name:      name,
+		Status:    BuildStatusRunning,
+		StartedAt: &start,
+	}
+
+	if err := recorder.db.Create(&step).Error; err != nil {
+		logger.Error("Failed to record build step", zap.String("step", name), zap.Error(err))
+	}
+
+	output, err := fn()
+
+	finished := time.Now().UTC()
+	step.FinishedAt = &finished
+	step.DurationMs = finished.Sub(start).Milliseconds()
+	step.Output = truncateBuildOutput(output)
+	step.Status = BuildStatusSucceeded
+
+	if err != nil {
+		step.Status = BuildStatusFailed
+		step.Error = err.Error()
+	}
+
+	if step.ID != 0 {
+		if err := recorder.db.Save(&step).Error; err != nil {
+			logger.Error("Failed to record build step", zap.String("step", name), zap.Error(err))
+		}
+	}
+
+	eventType := BuildEventStepFinished
+	switch name {
+	case BuildStepCache:
+		eventType = BuildEventCacheRefreshed
+	case BuildStepGitDeploy:
+		eventType = BuildEventGitDeploy
+	}
+
+	recorder.events.Publish(BuildEvent{
+		Type:            eventType,
+		DocumentationID: recorder.documentationID,
+		TriggerID:       recorder.triggerID,
+		Attempt:         recorder.attempt,
+		Step:            name,
+		Status:          step.Status,
+		DurationMs:      step.DurationMs,
+		Error:           step.Error,
+	})
+
+	return err
+}
+
+func (service *DocService) setBuildRecorder(docId uint, recorder *buildRecorder) {
+	service.buildRecorders.Store(docId, recorder)
+}
+
+func (service *DocService) clearBuildRecorder(docId uint) {
+	service.buildRecorders.Delete(docId)
+}
+
+// buildStep runs fn as a named step of the build the queue is running for
+// docId, recording its timing and output. Outside the queue fn just runs.
+func (service *DocService) buildStep(docId uint, name string, fn func() (string, error)) error {
+	recorder, ok := service.buildRecorders.Load(docId)
+	if !ok {
+		_, err := fn()
+		return err
+	}
+
+	return recorder.(*buildRecorder).run(name, fn)
+}
+
+type BuildListOptions struct {
+	DocumentationID uint
+	// When not nil, builds are limited to these documentations.
+	DocumentationIDs []uint
+	Status           string
+	Limit            int
+	Offset           int
+}
+
+func (service *DocService) GetBuilds(opts BuildListOptions) ([]models.BuildTriggers, int64, error) {
+	if opts.Limit <= 0 || opts.Limit > 100 {
+		opts.Limit = 20
+	}
+
+	if opts.Offset < 0 {
+		opts.Offset = 0
+	}
+
+	query := service.DB.Model(&models.BuildTriggers{})
+
+	if opts.DocumentationID != 0 {
+		query = query.Where("documentation_id = ?", opts.DocumentationID)
+	}
+
+	if opts.DocumentationIDs != nil {
+		if len(opts.DocumentationIDs) == 0 {
+			return make([]models.BuildTriggers, 0), 0, nil
+		}
+		query = query.Where("documentation_id IN ?", opts.DocumentationIDs)
+	}
+
+	if opts.Status != "" {
+		query = query.Where("status = ?", opts.Status)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed_to_get_builds")
+	}
+
+	builds := make([]models.BuildTriggers, 0)
+	if err := query.Order("id DESC").Limit(opts.Limit).Offset(opts.Offset).Find(&builds).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed_to_get_builds")
+	}
+
+	return builds, total, nil
+}
+
+type BuildLog struct {
+	Build models.BuildTriggers `json:"build"`
+	Steps []models.BuildStep   `json:"steps"`
+}
+
+// GetBuildLog returns a trigger with its recorded steps. Steps of a trigger
+// that was coalesced into another one are recorded on that one.
+func (service *DocService) GetBuildLog(id uint) (BuildLog, error) {
+	var build models.BuildTriggers
+	if err := service.DB.First(&build, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return BuildLog{}, fmt.Errorf("build_not_found")
+		}
+		return BuildLog{}, fmt.Errorf("failed_to_get_build")
+	}
+
+	stepsOf := build.ID
+	if build.CoalescedInto != nil {
+		stepsOf = *build.CoalescedInto
+	}
+
+	steps := make([]models.BuildStep, 0)
+	if err := service.DB.Where("trigger_id = ?", stepsOf).Order("id ASC").Find(&steps).Error; err != nil {
+		return BuildLog{}, fmt.Errorf("failed_to_get_build_steps")
+	}
+
+	return BuildLog{Build: build, Steps: steps}, nil
+}
@@ -0,0 +1,137 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"git.difuse.io/Difuse/kalmia/config"
+	"git.difuse.io/Difuse/kalmia/db/models"
+)
+
+func TestTruncateBuildOutput(t *testing.T) {
+	if got := truncateBuildOutput("short"); got != "short" {
+		t.Errorf("Expected short output to be kept, got %q", got)
+	}
+
+	long := strings.Repeat("a", maxBuildStepOutput) + "tail"
+	got := truncateBuildOutput(long)
+
+	if !strings.HasPrefix(got, "[output truncated]\n") || !strings.HasSuffix(got, "tail") {
+		t.Errorf("Expected output to be cut from the front")
+	}
+
+	// Every é is two bytes, the trailing byte puts the cut in the middle of one.
+	got = truncateBuildOutput(strings.Repeat("é", maxBuildStepOutput) + "!")
+	if !utf8.ValidString(got) || len(got) > len("[output truncated]\n")+maxBuildStepOutput {
+		t.Errorf("Expected output to be cut at a rune boundary")
+	}
+}
+
+func TestBuildLog(t *testing.T) {
+	if TestDocService == nil {
+		t.Fatal("TestDocService is nil")
+	}
+
+	db := TestDocService.DB
+	db.Model(&models.BuildTriggers{}).Where("triggered = ?", false).Update("triggered", true)
+
+	doc := models.Documentation{Name: "Build Log", Version: "1.0.0", BaseURL: "/build-log", AuthorID: 1}
+	if err := db.Create(&doc).Error; err != nil {
+		t.Fatalf("Failed to create documentation: %v", err)
+	}
+
+	queue := NewBuildQueue(TestDocService, config.BuildQueue{Workers: 1, MaxAttempts: 1, LeaseSeconds: 60})
+	queue.remove = func(docId uint) error { return nil }
+	queue.deploy = func(docId uint) {}
+	queue.build = func(docId uint) error {
+		if err := TestDocService.buildStep(docId, BuildStepInstall, func() (string, error) {
+			return "installed\n", nil
+		}); err != nil {
+			return err
+		}
+
+		return TestDocService.buildStep(docId, BuildStepRsPress, func() (string, error) {
+			return "boom\n", fmt.Errorf("rspress_failed")
+		})
+	}
+
+	TestDocService.AddBuildTrigger(doc.ID, true)
+	TestDocService.AddBuildTrigger(doc.ID, false)
+
+	var triggers []models.BuildTriggers
+	db.Where("documentation_id = ?", doc.ID).Order("id ASC").Find(&triggers)
+	if len(triggers) != 2 || triggers[0].Status != BuildStatusQueued {
+		t.Fatalf("Expected 2 queued triggers, got %+v", triggers)
+	}
+
+	_, events, unsubscribe := TestDocService.BuildEvents.Subscribe(0)
+	defer unsubscribe()
+
+	if processed, _ := queue.RunOnce(); !processed {
+		t.Fatalf("Expected RunOnce to process the triggers")
+	}
+
+	var types []string
+	for len(events) > 0 {
+		event := <-events
+		if event.DocumentationID == doc.ID {
+			types = append(types, event.Type+":"+event.Step+":"+event.Status)
+		}
+	}
+
+	expectedEvents := []string{
+		"build_started::running",
+		"step_finished:delete:succeeded",
+		"step_finished:install:succeeded",
+		"step_finished:rspress_build:failed",
+		"build_finished::failed",
+	}
+
+	if strings.Join(types, ",") != strings.Join(expectedEvents, ",") {
+		t.Errorf("Unexpected build events: %v", types)
+	}
+
+	builds, total, err := TestDocService.GetBuilds(BuildListOptions{DocumentationID: doc.ID, Status: BuildStatusFailed})
+	if err != nil {
+		t.Fatalf("GetBuilds returned an error: %v", err)
+	}
+
+	if total != 2 || len(builds) != 2 {
+		t.Fatalf("Expected 2 failed builds, got %d", total)
+	}
+
+	if builds[0].CoalescedInto == nil || *builds[0].CoalescedInto != triggers[0].ID {
+		t.Errorf("Expected newer trigger to be coalesced into %d, got %+v", triggers[0].ID, builds[0])
+	}
+
+	log, err := TestDocService.GetBuildLog(builds[0].ID)
+	if err != nil {
+		t.Fatalf("GetBuildLog returned an error: %v", err)
+	}
+
+	if len(log.Steps) != 3 {
+		t.Fatalf("Expected 3 steps, got %d", len(log.Steps))
+	}
+
+	expected := []struct{ name, status, output string }{
+		{BuildStepDelete, BuildStatusSucceeded, ""},
+		{BuildStepInstall, BuildStatusSucceeded, "installed\n"},
+		{BuildStepRsPress, BuildStatusFailed, "boom\n"},
+	}
+
+	for i, step := range log.Steps {
+		if step.Name != expected[i].name || step.Status != expected[i].status || step.Output != expected[i].output || step.Attempt != 1 || step.FinishedAt == nil {
+			t.Errorf("Unexpected step %d: %+v", i, step)
+		}
+	}
+
+	if log.Steps[2].Error != "rspress_failed" || log.Build.Error != "rspress_failed" {
+		t.Errorf("Expected the failure to be recorded, got %+v", log)
+	}
+
+	if _, err := TestDocService.GetBuildLog(0); err == nil || err.Error() != "build_not_found" {
+		t.Errorf("Expected build_not_found, got %v", err)
+	}
+}
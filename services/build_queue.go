@@ -0,0 +1,587 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"git.difuse.io/Difuse/kalmia/config"
+	"git.difuse.io/Difuse/kalmia/db/models"
+	"git.difuse.io/Difuse/kalmia/logger"
+	"git.difuse.io/Difuse/kalmia/utils"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	BuildPriorityLow    = -10
+	BuildPriorityNormal = 0
+	BuildPriorityHigh   = 10
+)
+
+var errBuildDocumentationMissing = errors.New("documentation_not_found")
+
+const (
+	buildRetryBaseDelay = 30 * time.Second
+	buildRetryMaxDelay  = 15 * time.Minute
+	buildQueuePoll      = 2 * time.Second
+)
+
+// BuildQueue runs the pending BuildTriggers on a pool of workers. Every
+// trigger of a documentation is claimed together under a lease, so edits made
+// while a build is queued are coalesced into it, and a documentation is never
+// built by two workers at once. A lease that isn't renewed (e.g. the process
+// crashed mid build) expires and the triggers are picked up again.
+type BuildQueue struct {
+	service       *DocService
+	workers       int
+	maxAttempts   int
+	leaseDuration time.Duration
+	owner         string
+	claimMutex    sync.Mutex
+
+	// Replaced in tests, so the queue can run without pnpm.
+	build  func(docId uint) error
+	deploy func(docId uint)
+	remove func(docId uint) error
+}
+
+func NewBuildQueue(service *DocService, cfg config.BuildQueue) *BuildQueue {
+	hostname, _ := os.Hostname()
+
+	queue := &BuildQueue{
+		service:       service,
+		workers:       cfg.Workers,
+		maxAttempts:   cfg.MaxAttempts,
+		leaseDuration: time.Duration(cfg.LeaseSeconds) * time.Second,
+		owner:         fmt.Sprintf("%s:%d", hostname, os.Getpid()),
+	}
+
+	if queue.workers <= 0 {
+		queue.workers = 1
+	}
+
+	if queue.maxAttempts <= 0 {
+		queue.maxAttempts = 1
+	}
+
+	if queue.leaseDuration <= 0 {
+		queue.leaseDuration = 2 * time.Minute
+	}
+
+	queue.build = service.UpdateWriteBuild
+	queue.deploy = service.runDeploy
+	queue.remove = service.runDelete
+
+	return queue
+}
+
+// Start runs the workers until ctx is cancelled.
+func (queue *BuildQueue) Start(ctx context.Context) {
+	var wg sync.WaitGroup
+
+	for i := 0; i < queue.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			queue.worker(ctx)
+		}()
+	}
+
+	wg.Wait()
+}
+
+func (queue *BuildQueue) worker(ctx context.Context) {
+	for {
+		processed, err := queue.RunOnce()
+		if err != nil {
+			logger.Error("Build queue failed to claim triggers", zap.Error(err))
+		}
+
+		if processed {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(buildQueuePoll):
+		}
+	}
+}
+
+// RunOnce claims the next documentation with pending triggers and processes
+// them. It returns false when there was nothing to do.
+func (queue *BuildQueue) RunOnce() (bool, error) {
+	token, triggers, err := queue.claim()
+	if err != nil || len(triggers) == 0 {
+		return false, err
+	}
+
+	queue.process(token, triggers)
+
+	return true, nil
+}
+
+func buildRetryDelay(attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+
+	delay := buildRetryBaseDelay
+	for i := 1; i < attempt; i++ {
+		delay *= 2
+		if delay >= buildRetryMaxDelay {
+			return buildRetryMaxDelay
+		}
+	}
+
+	return delay
+}
+
+// claimableTriggers returns the triggers that may be picked up right now.
+func claimableTriggers(tx *gorm.DB, now time.Time) *gorm.DB {
+	return tx.Model(&models.BuildTriggers{}).
+		Where("triggered = ? AND cancelled_at IS NULL", false).
+		Where("next_attempt_at IS NULL OR next_attempt_at <= ?", now).
+		Where("lease_expires_at IS NULL OR lease_expires_at <= ?", now)
+}
+
+func (queue *BuildQueue) claim() (string, []models.BuildTriggers, error) {
+	queue.claimMutex.Lock()
+	defer queue.claimMutex.Unlock()
+
+	db := queue.service.DB
+	now := time.Now().UTC()
+
+	busy := db.Model(&models.BuildTriggers{}).
+		Select("documentation_id").
+		Where("triggered = ? AND lease_expires_at > ?", false, now)
+
+	var candidate struct {
+		DocumentationID uint
+	}
+
+	err := claimableTriggers(db, now).
+		Select("documentation_id, MAX(priority) AS priority, MIN(id) AS first_id").
+		Where("documentation_id NOT IN (?)", busy).
+		Group("documentation_id").
+		Order("priority DESC, first_id ASC").
+		Limit(1).
+		Scan(&candidate).Error
+	if err != nil {
+		return "", nil, err
+	}
+
+	if candidate.DocumentationID == 0 {
+		return "", nil, nil
+	}
+
+	token := uuid.NewString()
+	leaseExpiresAt := now.Add(queue.leaseDuration)
+
+	result := claimableTriggers(db, now).
+		Where("documentation_id = ?", candidate.DocumentationID).
+		Updates(map[string]interface{}{
+			"status":           BuildStatusRunning,
+			"lease_token":      token,
+			"lease_owner":      queue.owner,
+			"lease_expires_at": leaseExpiresAt,
+			"started_at":       now,
+			"attempts":         gorm.Expr("attempts + 1"),
+		})
+	if result.Error != nil {
+		return "", nil, result.Error
+	}
+
+	var triggers []models.BuildTriggers
+	if err := db.Where("lease_token = ?", token).Order("id ASC").Find(&triggers).Error; err != nil {
+		return "", nil, err
+	}
+
+	return token, triggers, nil
+}
+
+// heartbeat keeps the lease alive until stop is closed.
+func (queue *BuildQueue) heartbeat(token string, stop <-chan struct{}) {
+	ticker := time.NewTicker(queue.leaseDuration / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			err := queue.service.DB.Model(&models.BuildTriggers{}).
+				Where("lease_token = ? AND triggered = ?", token, false).
+				Update("lease_expires_at", time.Now().UTC().Add(queue.leaseDuration)).Error
+			if err != nil {
+				logger.Error("Failed to renew build lease", zap.Error(err))
+			}
+		}
+	}
+}
+
+func (queue *BuildQueue) process(token string, triggers []models.BuildTriggers) {
+	docId := triggers[0].DocumentationID
+
+	stop := make(chan struct{})
+	go queue.heartbeat(token, stop)
+	defer close(stop)
+
+	attempts := 0
+	hasDelete, hasBuild := false, false
+	for _, trigger := range triggers {
+		if trigger.Attempts > attempts {
+			attempts = trigger.Attempts
+		}
+		if trigger.IsDelete {
+			hasDelete = true
+		} else {
+			hasBuild = true
+		}
+	}
+
+	// Attempts is bumped on every claim, so a build that keeps taking the
+	// process down runs out of attempts like one that fails normally.
+	if attempts > queue.maxAttempts {
+		queue.finish(triggers[0], token, BuildStatusFailed, fmt.Errorf("max_attempts_exceeded"), 0)
+		return
+	}
+
+	// Steps are recorded on the oldest trigger, the others point at it.
+	primary := triggers[0].ID
+	if len(triggers) > 1 {
+		if err := queue.service.DB.Model(&models.BuildTriggers{}).
+			Where("lease_token = ? AND id <> ?", token, primary).
+			Update("coalesced_into", primary).Error; err != nil {
+			logger.Error("Failed to coalesce build triggers", zap.Error(err))
+		}
+	}
+
+	recorder := &buildRecorder{
+		db:              queue.service.DB,
+		events:          queue.service.BuildEvents,
+		documentationID: docId,
+		triggerID:       primary,
+		attempt:         attempts,
+	}
+	queue.service.setBuildRecorder(docId, recorder)
+	defer queue.service.clearBuildRecorder(docId)
+
+	if rootId, err := queue.service.GetRootParentID(docId); err == nil && rootId != 0 && rootId != docId {
+		queue.service.setBuildRecorder(rootId, recorder)
+		defer queue.service.clearBuildRecorder(rootId)
+	}
+
+	queue.service.BuildEvents.Publish(BuildEvent{
+		Type:            BuildEventBuildStarted,
+		DocumentationID: docId,
+		TriggerID:       primary,
+		Attempt:         attempts,
+		Status:          BuildStatusRunning,
+	})
+
+	start := time.Now()
+	var err error
+
+	if hasDelete {
+		err = queue.service.buildStep(docId, BuildStepDelete, func() (string, error) {
+			return "", queue.remove(docId)
+		})
+	}
+
+	// A build coalesced with a delete has nothing to build once the
+	// documentation is gone, the run ends with the delete.
+	deleted := hasDelete && err == nil
+
+	if err == nil && hasBuild && !queue.cancelled(token) {
+		if queue.service.IsDocIdValid(docId) {
+			deleted = false
+			err = queue.build(docId)
+			if err == nil && !queue.cancelled(token) {
+				queue.deploy(docId)
+			}
+		} else if !hasDelete {
+			err = errBuildDocumentationMissing
+		}
+	}
+
+	elapsed := time.Since(start)
+
+	if queue.cancelled(token) {
+		logger.Info("Build cancelled", zap.Uint("doc_id", docId), zap.Int("trigger_count", len(triggers)))
+		queue.finish(triggers[0], token, BuildStatusCancelled, fmt.Errorf("cancelled"), elapsed)
+		return
+	}
+
+	if err != nil && attempts < queue.maxAttempts && !errors.Is(err, errBuildDocumentationMissing) && !errors.Is(err, errBrokenLinks) {
+		delay := buildRetryDelay(attempts)
+
+		logger.Error("Build failed, retrying",
+			zap.Uint("doc_id", docId),
+			zap.Error(err),
+			zap.Int("attempt", attempts),
+			zap.Duration("retry_in", delay),
+			zap.Duration("elapsed", elapsed),
+			zap.Int("trigger_count", len(triggers)))
+
+		queue.retry(triggers[0], token, err, delay)
+		return
+	}
+
+	status := BuildStatusSucceeded
+
+	if err != nil {
+		status = BuildStatusFailed
+
+		logger.Error("Build failed",
+			zap.Uint("doc_id", docId),
+			zap.Error(err),
+			zap.Int("attempt", attempts),
+			zap.Duration("elapsed", elapsed),
+			zap.Int("trigger_count", len(triggers)))
+	} else if deleted {
+		status = BuildStatusDeleted
+
+		logger.Info("Documentation site deleted",
+			zap.Uint("doc_id", docId),
+			zap.Duration("elapsed", elapsed),
+			zap.Int("trigger_count", len(triggers)))
+	} else {
+		logger.Info("Build triggers completed",
+			zap.Uint("doc_id", docId),
+			zap.Duration("elapsed", elapsed),
+			zap.Int("trigger_count", len(triggers)))
+	}
+
+	queue.finish(triggers[0], token, status, err, elapsed)
+}
+
+func (queue *BuildQueue) cancelled(token string) bool {
+	var count int64
+
+	queue.service.DB.Model(&models.BuildTriggers{}).
+		Where("lease_token = ? AND cancelled_at IS NULL", token).
+		Count(&count)
+
+	return count == 0
+}
+
+func (queue *BuildQueue) retry(primary models.BuildTriggers, token string, cause error, delay time.Duration) {
+	nextAttemptAt := time.Now().UTC().Add(delay)
+
+	err := queue.service.DB.Model(&models.BuildTriggers{}).
+		Where("lease_token = ? AND triggered = ?", token, false).
+		Updates(map[string]interface{}{
+			"status":           BuildStatusQueued,
+			"lease_token":      "",
+			"lease_owner":      "",
+			"lease_expires_at": nil,
+			"next_attempt_at":  nextAttemptAt,
+			"error":            cause.Error(),
+		}).Error
+	if err != nil {
+		logger.Error("Failed to reschedule build triggers", zap.Error(err))
+	}
+
+	queue.service.BuildEvents.Publish(BuildEvent{
+		Type:            BuildEventRetryScheduled,
+		DocumentationID: primary.DocumentationID,
+		TriggerID:       primary.ID,
+		Attempt:         primary.Attempts,
+		Status:          BuildStatusQueued,
+		Error:           cause.Error(),
+		NextAttemptAt:   &nextAttemptAt,
+	})
+}
+
+func (queue *BuildQueue) finish(primary models.BuildTriggers, token string, status string, cause error, elapsed time.Duration) {
+	message := ""
+	if cause != nil {
+		message = cause.Error()
+	}
+
+	err := queue.service.DB.Model(&models.BuildTriggers{}).
+		Where("lease_token = ? AND triggered = ?", token, false).
+		Updates(map[string]interface{}{
+			"triggered":        true,
+			"status":           status,
+			"completed_at":     time.Now().UTC(),
+			"lease_expires_at": nil,
+			"duration_ms":      elapsed.Milliseconds(),
+			"error":            message,
+		}).Error
+	if err != nil {
+		logger.Error("Failed to save build triggers", zap.Error(err))
+	}
+
+	queue.service.BuildEvents.Publish(BuildEvent{
+		Type:            BuildEventBuildFinished,
+		DocumentationID: primary.DocumentationID,
+		TriggerID:       primary.ID,
+		Attempt:         primary.Attempts,
+		Status:          status,
+		DurationMs:      elapsed.Milliseconds(),
+		Error:           message,
+	})
+
+	webhookEvent := ""
+	switch status {
+	case BuildStatusSucceeded:
+		webhookEvent = WebhookEventBuildSucceeded
+	case BuildStatusFailed:
+		webhookEvent = WebhookEventBuildFailed
+	}
+
+	if webhookEvent != "" {
+		queue.service.emitWebhookEvent(primary.DocumentationID, webhookEvent, map[string]interface{}{
+			"buildId":    primary.ID,
+			"attempt":    primary.Attempts,
+			"durationMs": elapsed.Milliseconds(),
+			"error":      message,
+		})
+	}
+}
+
+func (service *DocService) runDelete(docId uint) error {
+	docPath := filepath.Join(config.ParsedConfig.DataPath, "rspress_data", "doc_"+strconv.Itoa(int(docId)))
+	if !utils.PathExists(docPath) {
+		return nil
+	}
+
+	logger.Info("Deleting doc folder", zap.Uint("doc_id", docId))
+
+	return service.RemoveDocFolder(docId)
+}
+
+func (service *DocService) runDeploy(docId uint) {
+	gitTime := time.Now()
+	err := service.buildStep(docId, BuildStepGitDeploy, func() (string, error) {
+		return "", service.GitDeploy(docId)
+	})
+	if err != nil {
+		logger.Error("Failed to deploy to git", zap.Error(err))
+	} else {
+		logger.Info("Git Deploy completed", zap.Uint("doc_id", docId), zap.Duration("elapsed", time.Since(gitTime)))
+
+		var doc models.Documentation
+		if err := service.DB.Select("id", "git_repo", "git_branch").First(&doc, docId).Error; err == nil && doc.GitRepo != "" {
+			service.emitWebhookEvent(docId, WebhookEventGitDeployCompleted, map[string]interface{}{
+				"repo":       doc.GitRepo,
+				"branch":     doc.GitBranch,
+				"durationMs": time.Since(gitTime).Milliseconds(),
+			})
+		}
+	}
+
+	logger.Info(fmt.Sprintf("moving static assets to docs in doc_%d", docId))
+
+	docPath := utils.GetDocPathByID(docId, config.ParsedConfig)
+	docPublicAssetPath := filepath.Join(docPath, "public")
+	docsInternalPublicAssetPath := filepath.Join(docPath, "docs", "public")
+
+	if err := utils.CopyOrOveriteDir(docPublicAssetPath, docsInternalPublicAssetPath); err != nil {
+		logger.Error(fmt.Sprintf("error copying files from %s to %s", docPublicAssetPath, docsInternalPublicAssetPath), zap.Error(err))
+	} else {
+		logger.Info("successfully copied files to target", zap.Uint("doc_id", docId))
+	}
+}
+
+// CancelBuildTrigger cancels a queued or running trigger. Queued triggers are
+// closed right away; a running build finishes its current step but is then
+// neither pushed to git nor retried.
+func (service *DocService) CancelBuildTrigger(id uint) error {
+	var trigger models.BuildTriggers
+	if err := service.DB.First(&trigger, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("build_trigger_not_found")
+		}
+		return fmt.Errorf("failed_to_get_build_trigger")
+	}
+
+	if trigger.Triggered || trigger.CancelledAt != nil {
+		return fmt.Errorf("build_trigger_already_finished")
+	}
+
+	return service.cancelBuildTriggers("id", id)
+}
+
+// CancelDocumentationBuilds cancels every unfinished trigger of a
+// documentation and returns how many were cancelled.
+func (service *DocService) CancelDocumentationBuilds(docId uint) (int64, error) {
+	var count int64
+	if err := service.DB.Model(&models.BuildTriggers{}).
+		Where("documentation_id = ? AND triggered = ? AND cancelled_at IS NULL", docId, false).
+		Count(&count).Error; err != nil {
+		return 0, fmt.Errorf("failed_to_get_build_triggers")
+	}
+
+	if count == 0 {
+		return 0, nil
+	}
+
+	if err := service.cancelBuildTriggers("documentation_id", docId); err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+func (service *DocService) cancelBuildTriggers(column string, value uint) error {
+	now := time.Now().UTC()
+
+	var closed []models.BuildTriggers
+
+	err := service.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.BuildTriggers{}).
+			Where(column+" = ?", value).
+			Where("triggered = ? AND cancelled_at IS NULL", false).
+			Update("cancelled_at", now).Error; err != nil {
+			return fmt.Errorf("failed_to_cancel_build")
+		}
+
+		// Triggers without a live lease aren't being worked on, close them now.
+		idle := tx.Model(&models.BuildTriggers{}).
+			Where(column+" = ?", value).
+			Where("triggered = ? AND cancelled_at IS NOT NULL", false).
+			Where("lease_expires_at IS NULL OR lease_expires_at <= ?", now).
+			Session(&gorm.Session{})
+
+		if err := idle.Find(&closed).Error; err != nil {
+			return fmt.Errorf("failed_to_cancel_build")
+		}
+
+		if err := idle.Updates(map[string]interface{}{
+			"triggered":    true,
+			"status":       BuildStatusCancelled,
+			"completed_at": now,
+			"error":        "cancelled",
+		}).Error; err != nil {
+			return fmt.Errorf("failed_to_cancel_build")
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, trigger := range closed {
+		service.BuildEvents.Publish(BuildEvent{
+			Type:            BuildEventBuildFinished,
+			DocumentationID: trigger.DocumentationID,
+			TriggerID:       trigger.ID,
+			Status:          BuildStatusCancelled,
+			Error:           "cancelled",
+		})
+	}
+
+	return nil
+}
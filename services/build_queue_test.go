@@ -0,0 +1,241 @@
+package services
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"git.difuse.io/Difuse/kalmia/config"
+	"git.difuse.io/Difuse/kalmia/db/models"
+)
+
+func TestBuildRetryDelay(t *testing.T) {
+	tests := []struct {
+		attempt  int
+		expected time.Duration
+	}{
+		{0, 30 * time.Second},
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{10, 15 * time.Minute},
+	}
+
+	for _, test := range tests {
+		if got := buildRetryDelay(test.attempt); got != test.expected {
+			t.Errorf("buildRetryDelay(%d) = %v, expected %v", test.attempt, got, test.expected)
+		}
+	}
+}
+
+func TestBuildQueue(t *testing.T) {
+	if TestDocService == nil {
+		t.Fatal("TestDocService is nil")
+	}
+
+	db := TestDocService.DB
+
+	// Other tests leave triggers behind, keep them out of this queue.
+	db.Model(&models.BuildTriggers{}).Where("triggered = ?", false).Update("triggered", true)
+
+	doc := models.Documentation{Name: "Build Queue", Version: "1.0.0", BaseURL: "/build-queue", AuthorID: 1}
+	if err := db.Create(&doc).Error; err != nil {
+		t.Fatalf("Failed to create documentation: %v", err)
+	}
+
+	queue := NewBuildQueue(TestDocService, config.BuildQueue{Workers: 1, MaxAttempts: 2, LeaseSeconds: 60})
+
+	var built []uint
+	var buildErr error
+	queue.build = func(docId uint) error {
+		built = append(built, docId)
+		return buildErr
+	}
+	queue.deploy = func(docId uint) {}
+	queue.remove = func(docId uint) error { return nil }
+
+	pending := func() []models.BuildTriggers {
+		var triggers []models.BuildTriggers
+		db.Where("documentation_id = ?", doc.ID).Order("id ASC").Find(&triggers)
+		return triggers
+	}
+
+	t.Run("Dedup", func(t *testing.T) {
+		for i := 0; i < 3; i++ {
+			if err := TestDocService.AddBuildTrigger(doc.ID, false); err != nil {
+				t.Fatalf("AddBuildTrigger returned an error: %v", err)
+			}
+		}
+
+		if triggers := pending(); len(triggers) != 1 {
+			t.Fatalf("Expected 1 trigger after dedup, got %d", len(triggers))
+		}
+	})
+
+	t.Run("Success", func(t *testing.T) {
+		processed, err := queue.RunOnce()
+		if err != nil || !processed {
+			t.Fatalf("Expected RunOnce to process a trigger, got %v, %v", processed, err)
+		}
+
+		if len(built) != 1 || built[0] != doc.ID {
+			t.Fatalf("Expected documentation %d to be built, got %v", doc.ID, built)
+		}
+
+		trigger := pending()[0]
+		if !trigger.Triggered || trigger.Status != BuildStatusSucceeded || trigger.CompletedAt == nil || trigger.Error != "" || trigger.Attempts != 1 {
+			t.Errorf("Unexpected trigger after success: %+v", trigger)
+		}
+
+		if processed, _ := queue.RunOnce(); processed {
+			t.Errorf("Expected an empty queue")
+		}
+	})
+
+	t.Run("RetryAndFail", func(t *testing.T) {
+		built = nil
+		buildErr = fmt.Errorf("pnpm_failed")
+		defer func() { buildErr = nil }()
+
+		TestDocService.AddBuildTrigger(doc.ID, false)
+
+		queue.RunOnce()
+
+		triggers := pending()
+		trigger := triggers[len(triggers)-1]
+		if trigger.Triggered || trigger.NextAttemptAt == nil || trigger.Error != "pnpm_failed" || trigger.LeaseToken != "" {
+			t.Fatalf("Expected trigger to be rescheduled, got %+v", trigger)
+		}
+
+		if processed, _ := queue.RunOnce(); processed {
+			t.Fatalf("Expected the retry to wait for its backoff")
+		}
+
+		if err := TestDocService.AddBuildTrigger(doc.ID, false); err != nil {
+			t.Fatalf("AddBuildTrigger returned an error: %v", err)
+		}
+
+		if processed, _ := queue.RunOnce(); processed {
+			t.Fatalf("Expected a coalesced trigger to keep its backoff")
+		}
+
+		db.Model(&trigger).Update("next_attempt_at", time.Now().UTC().Add(-time.Second))
+		queue.RunOnce()
+
+		db.First(&trigger, trigger.ID)
+		if !trigger.Triggered || trigger.Attempts != 2 || trigger.Error != "pnpm_failed" {
+			t.Errorf("Expected trigger to fail after max attempts, got %+v", trigger)
+		}
+
+		if len(built) != 2 {
+			t.Errorf("Expected 2 build attempts, got %d", len(built))
+		}
+	})
+
+	t.Run("ExpiredLease", func(t *testing.T) {
+		built = nil
+
+		TestDocService.AddBuildTrigger(doc.ID, false)
+		triggers := pending()
+		trigger := triggers[len(triggers)-1]
+
+		db.Model(&trigger).Updates(map[string]interface{}{
+			"lease_token":      "crashed",
+			"lease_expires_at": time.Now().UTC().Add(time.Minute),
+		})
+
+		if processed, _ := queue.RunOnce(); processed {
+			t.Fatalf("Expected a live lease to block the documentation")
+		}
+
+		db.Model(&trigger).Update("lease_expires_at", time.Now().UTC().Add(-time.Second))
+
+		if processed, _ := queue.RunOnce(); !processed {
+			t.Fatalf("Expected an expired lease to be reclaimed")
+		}
+
+		db.First(&trigger, trigger.ID)
+		if !trigger.Triggered || len(built) != 1 {
+			t.Errorf("Expected reclaimed trigger to be built, got %+v", trigger)
+		}
+	})
+
+	t.Run("Cancel", func(t *testing.T) {
+		built = nil
+
+		TestDocService.AddBuildTrigger(doc.ID, false)
+		triggers := pending()
+		trigger := triggers[len(triggers)-1]
+
+		if err := TestDocService.CancelBuildTrigger(trigger.ID); err != nil {
+			t.Fatalf("CancelBuildTrigger returned an error: %v", err)
+		}
+
+		db.First(&trigger, trigger.ID)
+		if !trigger.Triggered || trigger.Status != BuildStatusCancelled || trigger.CancelledAt == nil {
+			t.Errorf("Expected trigger to be cancelled, got %+v", trigger)
+		}
+
+		if err := TestDocService.CancelBuildTrigger(trigger.ID); err == nil || err.Error() != "build_trigger_already_finished" {
+			t.Errorf("Expected build_trigger_already_finished, got %v", err)
+		}
+
+		if processed, _ := queue.RunOnce(); processed || len(built) != 0 {
+			t.Errorf("Expected cancelled trigger to be skipped")
+		}
+	})
+
+	t.Run("DeleteOnly", func(t *testing.T) {
+		built = nil
+
+		if err := TestDocService.AddBuildTrigger(doc.ID, true); err != nil {
+			t.Fatalf("AddBuildTrigger returned an error: %v", err)
+		}
+
+		if processed, _ := queue.RunOnce(); !processed {
+			t.Fatalf("Expected the delete to be processed")
+		}
+
+		triggers := pending()
+		trigger := triggers[len(triggers)-1]
+		if !trigger.Triggered || trigger.Status != BuildStatusDeleted || trigger.Error != "" || len(built) != 0 {
+			t.Errorf("Expected a deleted run without a build, got %+v", trigger)
+		}
+	})
+
+	t.Run("DeleteCoalescedWithBuildOfDeletedDocumentation", func(t *testing.T) {
+		built = nil
+
+		gone := models.Documentation{Name: "Build Queue Gone", Version: "1.0.0", BaseURL: "/build-queue-gone", AuthorID: 1}
+		if err := db.Create(&gone).Error; err != nil {
+			t.Fatalf("Failed to create documentation: %v", err)
+		}
+
+		TestDocService.AddBuildTrigger(gone.ID, false)
+		TestDocService.AddBuildTrigger(gone.ID, true)
+
+		if err := db.Delete(&gone).Error; err != nil {
+			t.Fatalf("Failed to delete documentation: %v", err)
+		}
+
+		if processed, _ := queue.RunOnce(); !processed {
+			t.Fatalf("Expected the triggers to be processed")
+		}
+
+		var triggers []models.BuildTriggers
+		db.Where("documentation_id = ?", gone.ID).Find(&triggers)
+		if len(triggers) != 2 {
+			t.Fatalf("Expected 2 triggers, got %d", len(triggers))
+		}
+
+		for _, trigger := range triggers {
+			if !trigger.Triggered || trigger.Status != BuildStatusDeleted || trigger.Error != "" {
+				t.Errorf("Expected a deleted run, got %+v", trigger)
+			}
+		}
+
+		if len(built) != 0 {
+			t.Errorf("Expected nothing to be built, got %v", built)
+		}
+	})
+}
@@ -0,0 +1,441 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"git.difuse.io/Difuse/kalmia/db/models"
+	"git.difuse.io/Difuse/kalmia/logger"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// Messages exchanged with collaborating clients. Updates and awareness are
+// opaque to the server (e.g. Yjs updates and awareness states): they are
+// relayed to the other clients of the page, and updates are kept so clients
+// joining later can catch up. Clients report the merged BlockNote document as
+// "state", which is saved to the page every so often.
+const (
+	CollaborationInit      = "init"
+	CollaborationUpdate    = "update"
+	CollaborationAwareness = "awareness"
+	CollaborationState     = "state"
+	CollaborationJoined    = "joined"
+	CollaborationLeft      = "left"
+	CollaborationSaved     = "saved"
+	CollaborationConflict  = "conflict"
+	CollaborationError     = "error"
+)
+
+const (
+	collaborationSaveInterval  = 10 * time.Second
+	collaborationMaxUpdateSize = 16 << 20
+	// A client this many messages behind is disconnected rather than
+	// waited for.
+	collaborationOutboxSize = 256
+)
+
+type CollaborationPeer struct {
+	ClientID  string          `json:"clientId"`
+	UserID    uint            `json:"userId"`
+	Username  string          `json:"username"`
+	Photo     string          `json:"photo,omitempty"`
+	Awareness json.RawMessage `json:"awareness,omitempty"`
+}
+
+type CollaborationMessage struct {
+	Type      string              `json:"type"`
+	ClientID  string              `json:"clientId,omitempty"`
+	Update    string              `json:"update,omitempty"`
+	Updates   []string            `json:"updates,omitempty"`
+	Awareness json.RawMessage     `json:"awareness,omitempty"`
+	Content   string              `json:"content,omitempty"`
+	Version   uint                `json:"version,omitempty"`
+	Peers     []CollaborationPeer `json:"peers,omitempty"`
+	Error     string              `json:"error,omitempty"`
+}
+
+// CollaborationConn sends messages to one client. Sends to a client are never
+// concurrent. Close disconnects the client, it's called once the last
+// message is sent.
+type CollaborationConn interface {
+	Send(message CollaborationMessage) error
+	Close() error
+}
+
+type collaborationRoom struct {
+	pageId      uint
+	mutex       sync.Mutex
+	sessions    map[string]*CollaborationSession
+	updates     []string
+	updatesSize int
+	content     string
+	version     uint
+	dirty       bool
+	lastEditor  models.User
+	editors     map[uint]models.User
+}
+
+// CollaborationSession is one client editing a page. Messages to the client
+// are queued in its outbox and written by a goroutine of its own, so a slow
+// client doesn't hold up the room. The outbox is guarded by room.mutex.
+type CollaborationSession struct {
+	ID        string
+	User      models.User
+	hub       *CollaborationHub
+	room      *collaborationRoom
+	conn      CollaborationConn
+	outbox    chan CollaborationMessage
+	closed    bool
+	awareness json.RawMessage
+}
+
+// CollaborationHub keeps a room per page being edited together. A room lives
+// while it has clients and is saved when the last one leaves.
+type CollaborationHub struct {
+	service *DocService
+	mutex   sync.Mutex
+	rooms   map[uint]*collaborationRoom
+}
+
+func NewCollaborationHub(service *DocService) *CollaborationHub {
+	return &CollaborationHub{
+		service: service,
+		rooms:   make(map[uint]*collaborationRoom),
+	}
+}
+
+// Start saves the rooms periodically until ctx is cancelled.
+func (hub *CollaborationHub) Start(ctx context.Context) {
+	ticker := time.NewTicker(collaborationSaveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			hub.SaveAll()
+		}
+	}
+}
+
+// SaveAll saves the rooms that changed since they were last saved.
+func (hub *CollaborationHub) SaveAll() {
+	hub.mutex.Lock()
+	rooms := make([]*collaborationRoom, 0, len(hub.rooms))
+	for _, room := range hub.rooms {
+		rooms = append(rooms, room)
+	}
+	hub.mutex.Unlock()
+
+	for _, room := range rooms {
+		room.mutex.Lock()
+		hub.save(room, false)
+		room.mutex.Unlock()
+	}
+}
+
+// Join adds a client to the page's room and sends it the document, the
+// updates made so far and who else is there.
+func (hub *CollaborationHub) Join(pageId uint, user models.User, conn CollaborationConn) (*CollaborationSession, error) {
+	var page models.Page
+	if err := hub.service.DB.Select("id", "documentation_id", "content", "version").First(&page, pageId).Error; err != nil {
+		return nil, fmt.Errorf("page_not_found")
+	}
+
+	if err := hub.service.requireDocumentationRole(user.ID, page.DocumentationID, RoleEditor); err != nil {
+		return nil, err
+	}
+
+	if err := checkEditLock(hub.service.DB, ResourcePage, page.ID, user.ID); err != nil {
+		return nil, err
+	}
+
+	hub.mutex.Lock()
+	room, exists := hub.rooms[pageId]
+	if !exists {
+		room = &collaborationRoom{
+			pageId:   pageId,
+			sessions: make(map[string]*CollaborationSession),
+			content:  page.Content,
+			version:  page.Version,
+			editors:  make(map[uint]models.User),
+		}
+		hub.rooms[pageId] = room
+	}
+
+	room.mutex.Lock()
+	hub.mutex.Unlock()
+	defer room.mutex.Unlock()
+
+	session := &CollaborationSession{
+		ID:     uuid.NewString(),
+		User:   user,
+		hub:    hub,
+		room:   room,
+		conn:   conn,
+		outbox: make(chan CollaborationMessage, collaborationOutboxSize),
+	}
+
+	go session.write()
+
+	room.sessions[session.ID] = session
+
+	session.send(CollaborationMessage{
+		Type:     CollaborationInit,
+		ClientID: session.ID,
+		Content:  room.content,
+		Version:  room.version,
+		Updates:  append([]string(nil), room.updates...),
+		Peers:    room.peers(),
+	})
+
+	room.broadcast(session.ID, CollaborationMessage{Type: CollaborationJoined, ClientID: session.ID, Peers: []CollaborationPeer{session.peer()}})
+
+	return session, nil
+}
+
+// Handle applies a message received from the session's client.
+func (session *CollaborationSession) Handle(message CollaborationMessage) {
+	room := session.room
+	room.mutex.Lock()
+	defer room.mutex.Unlock()
+
+	switch message.Type {
+	case CollaborationUpdate:
+		if message.Update == "" {
+			return
+		}
+
+		if room.updatesSize+len(message.Update) > collaborationMaxUpdateSize {
+			session.send(CollaborationMessage{Type: CollaborationError, Error: "too_many_updates"})
+			return
+		}
+
+		room.updates = append(room.updates, message.Update)
+		room.updatesSize += len(message.Update)
+		room.broadcast(session.ID, CollaborationMessage{Type: CollaborationUpdate, ClientID: session.ID, Update: message.Update})
+	case CollaborationAwareness:
+		session.awareness = message.Awareness
+		room.broadcast(session.ID, CollaborationMessage{Type: CollaborationAwareness, ClientID: session.ID, Awareness: message.Awareness})
+	case CollaborationState:
+		if message.Content == "" || message.Content == room.content {
+			return
+		}
+
+		room.content = message.Content
+		room.dirty = true
+		room.lastEditor = session.User
+		room.editors[session.User.ID] = session.User
+	default:
+		session.send(CollaborationMessage{Type: CollaborationError, Error: "unknown_message_type"})
+	}
+}
+
+// Leave removes the client from its room. The last client to leave closes
+// the room, saving what is left unsaved.
+func (session *CollaborationSession) Leave() {
+	hub := session.hub
+	room := session.room
+
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+
+	room.mutex.Lock()
+	defer room.mutex.Unlock()
+
+	if _, exists := room.sessions[session.ID]; !exists {
+		return
+	}
+
+	delete(room.sessions, session.ID)
+	session.close()
+	room.broadcast(session.ID, CollaborationMessage{Type: CollaborationLeft, ClientID: session.ID})
+
+	if len(room.sessions) > 0 {
+		return
+	}
+
+	// Saving while holding the hub keeps a client that joins now from
+	// starting off the content that is being replaced.
+	hub.save(room, true)
+	delete(hub.rooms, room.pageId)
+}
+
+// send queues a message for the client. A client whose outbox is full is
+// disconnected, it leaves the room once its connection closes. Callers hold
+// room.mutex.
+func (session *CollaborationSession) send(message CollaborationMessage) {
+	if session.closed {
+		return
+	}
+
+	select {
+	case session.outbox <- message:
+	default:
+		logger.Debug("Disconnecting slow collaboration client", zap.String("client_id", session.ID))
+		session.close()
+	}
+}
+
+// close stops queueing messages for the client. Callers hold room.mutex.
+func (session *CollaborationSession) close() {
+	if session.closed {
+		return
+	}
+
+	session.closed = true
+	close(session.outbox)
+}
+
+// write sends the client its queued messages, then closes the connection.
+func (session *CollaborationSession) write() {
+	for message := range session.outbox {
+		if err := session.conn.Send(message); err != nil {
+			logger.Debug("Failed to send collaboration message", zap.String("client_id", session.ID), zap.Error(err))
+		}
+	}
+
+	if err := session.conn.Close(); err != nil {
+		logger.Debug("Failed to close collaboration connection", zap.String("client_id", session.ID), zap.Error(err))
+	}
+}
+
+func (session *CollaborationSession) peer() CollaborationPeer {
+	return CollaborationPeer{
+		ClientID:  session.ID,
+		UserID:    session.User.ID,
+		Username:  session.User.Username,
+		Photo:     session.User.Photo,
+		Awareness: session.awareness,
+	}
+}
+
+func (room *collaborationRoom) peers() []CollaborationPeer {
+	peers := make([]CollaborationPeer, 0, len(room.sessions))
+	for _, session := range room.sessions {
+		peers = append(peers, session.peer())
+	}
+	return peers
+}
+
+// broadcast sends the message to every client but the one it came from.
+func (room *collaborationRoom) broadcast(from string, message CollaborationMessage) {
+	for id, session := range room.sessions {
+		if id != from {
+			session.send(message)
+		}
+	}
+}
+
+// save writes the room's content to the page if it changed. Saves only
+// succeed on top of the version the room started from and while nobody else
+// holds the page's lock; otherwise the clients are told to start over from
+// what was saved. The final save of a room also records a revision. Callers
+// hold room.mutex.
+func (hub *CollaborationHub) save(room *collaborationRoom, final bool) {
+	if !room.dirty {
+		return
+	}
+
+	service := hub.service
+	var page models.Page
+
+	err := service.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Preload("Editors").First(&page, room.pageId).Error; err != nil {
+			return fmt.Errorf("page_not_found")
+		}
+
+		if page.Version != room.version {
+			return fmt.Errorf("page_version_conflict")
+		}
+
+		// Someone may have taken the page's lock since the room's clients
+		// joined, what they wrote since isn't saved over it.
+		for _, editor := range room.editors {
+			if err := checkEditLock(tx, ResourcePage, page.ID, editor.ID); err != nil {
+				return err
+			}
+		}
+
+		if err := bumpPageVersion(tx, &page); err != nil {
+			return err
+		}
+
+		page.Content = room.content
+		page.LastEditorID = &room.lastEditor.ID
+
+		if err := tx.Model(&models.Page{}).Where("id = ?", page.ID).UpdateColumns(map[string]interface{}{
+			"content":        page.Content,
+			"last_editor_id": page.LastEditorID,
+			"updated_at":     time.Now().UTC(),
+		}).Error; err != nil {
+			return fmt.Errorf("failed_to_update_page")
+		}
+
+		editors := make([]models.User, 0, len(room.editors))
+		for _, editor := range room.editors {
+			known := false
+			for _, existing := range page.Editors {
+				known = known || existing.ID == editor.ID
+			}
+			if !known {
+				editors = append(editors, models.User{ID: editor.ID})
+			}
+		}
+
+		if len(editors) > 0 {
+			if err := tx.Omit("Editors.*").Model(&page).Association("Editors").Append(editors); err != nil {
+				return fmt.Errorf("failed_to_update_page")
+			}
+		}
+
+		if final {
+			if err := createPageRevision(tx, page, room.lastEditor.ID, nil); err != nil {
+				return err
+			}
+		}
+
+		return indexPage(tx, page)
+	})
+
+	if err != nil {
+		switch err.Error() {
+		case "page_version_conflict":
+		case "locked_by_another_user":
+			room.broadcast("", CollaborationMessage{Type: CollaborationError, Error: err.Error()})
+		default:
+			logger.Error("Failed to save collaborative edit", zap.Uint("page_id", room.pageId), zap.Error(err))
+			return
+		}
+
+		var current models.Page
+		if err := service.DB.Select("id", "content", "version").First(&current, room.pageId).Error; err != nil {
+			logger.Error("Failed to reload page after conflict", zap.Uint("page_id", room.pageId), zap.Error(err))
+			return
+		}
+
+		room.content = current.Content
+		room.version = current.Version
+		room.updates = nil
+		room.updatesSize = 0
+		room.dirty = false
+		room.broadcast("", CollaborationMessage{Type: CollaborationConflict, Content: current.Content, Version: current.Version})
+		return
+	}
+
+	room.version = page.Version
+	room.dirty = false
+	room.broadcast("", CollaborationMessage{Type: CollaborationSaved, Version: page.Version})
+
+	if final {
+		actor := AuditActor{UserID: &room.lastEditor.ID, Username: room.lastEditor.Username}
+		service.WithActor(actor).audit("page.edit", AuditEntityPage, page.ID, nil, pageAuditSummary(page))
+		service.emitWebhookEvent(page.DocumentationID, WebhookEventPageEdited, newWebhookPage(page, page.DocumentationID, room.lastEditor.ID))
+	}
+}
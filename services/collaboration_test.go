@@ -0,0 +1,302 @@
+package services
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"git.difuse.io/Difuse/kalmia/db/models"
+)
+
+type fakeCollaborationConn struct {
+	mutex    sync.Mutex
+	messages []CollaborationMessage
+	closed   bool
+	// When set, sends wait until it's closed, like a client that stopped
+	// reading.
+	blocked chan struct{}
+}
+
+func (conn *fakeCollaborationConn) Send(message CollaborationMessage) error {
+	if conn.blocked != nil {
+		<-conn.blocked
+	}
+
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	conn.messages = append(conn.messages, message)
+	return nil
+}
+
+func (conn *fakeCollaborationConn) Close() error {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	conn.closed = true
+	return nil
+}
+
+// wait is last for a message that's still on its way to the client.
+func (conn *fakeCollaborationConn) wait(messageType string) (CollaborationMessage, bool) {
+	deadline := time.Now().Add(time.Second)
+	for {
+		message, ok := conn.last(messageType)
+		if ok || time.Now().After(deadline) {
+			return message, ok
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func (conn *fakeCollaborationConn) last(messageType string) (CollaborationMessage, bool) {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	for i := len(conn.messages) - 1; i >= 0; i-- {
+		if conn.messages[i].Type == messageType {
+			return conn.messages[i], true
+		}
+	}
+	return CollaborationMessage{}, false
+}
+
+func TestCollaboration(t *testing.T) {
+	if TestDocService == nil {
+		t.Fatal("TestDocService is nil")
+	}
+
+	db := TestDocService.DB
+
+	var admin, user models.User
+	if err := db.Where("username = ?", "admin").First(&admin).Error; err != nil {
+		t.Fatalf("Failed to get admin: %v", err)
+	}
+	if err := db.Where("username = ?", "user").First(&user).Error; err != nil {
+		t.Fatalf("Failed to get user: %v", err)
+	}
+
+	doc := models.Documentation{Name: "Collaboration", Version: "1.0.0", BaseURL: "/collaboration", AuthorID: admin.ID}
+	if err := db.Create(&doc).Error; err != nil {
+		t.Fatalf("Failed to create documentation: %v", err)
+	}
+
+	page := models.Page{DocumentationID: doc.ID, Title: "Shared", Slug: "/shared", Content: "[]", AuthorID: admin.ID}
+	if err := TestDocService.CreatePage(&page); err != nil {
+		t.Fatalf("CreatePage returned an error: %v", err)
+	}
+
+	if page.Version != 1 {
+		t.Fatalf("Expected a new page at version 1, got %d", page.Version)
+	}
+
+	hub := NewCollaborationHub(TestDocService)
+
+	t.Run("Viewers can't join", func(t *testing.T) {
+		if err := TestDocService.SetDocumentationMember(admin, doc.ID, user.ID, RoleViewer); err != nil {
+			t.Fatalf("SetDocumentationMember returned an error: %v", err)
+		}
+
+		if _, err := hub.Join(page.ID, user, &fakeCollaborationConn{}); err == nil || err.Error() != "insufficient_documentation_role" {
+			t.Errorf("Expected insufficient_documentation_role, got %v", err)
+		}
+
+		if err := TestDocService.SetDocumentationMember(admin, doc.ID, user.ID, RoleEditor); err != nil {
+			t.Fatalf("SetDocumentationMember returned an error: %v", err)
+		}
+	})
+
+	first := &fakeCollaborationConn{}
+	firstSession, err := hub.Join(page.ID, admin, first)
+	if err != nil {
+		t.Fatalf("Join returned an error: %v", err)
+	}
+
+	firstSession.Handle(CollaborationMessage{Type: CollaborationUpdate, Update: "AQID"})
+
+	second := &fakeCollaborationConn{}
+	secondSession, err := hub.Join(page.ID, user, second)
+	if err != nil {
+		t.Fatalf("Join returned an error: %v", err)
+	}
+
+	t.Run("Late joiners catch up", func(t *testing.T) {
+		init, ok := second.wait(CollaborationInit)
+		if !ok {
+			t.Fatal("Expected an init message")
+		}
+
+		if init.Version != 1 || len(init.Updates) != 1 || init.Updates[0] != "AQID" || len(init.Peers) != 2 {
+			t.Errorf("Unexpected init message: %+v", init)
+		}
+
+		if _, ok := first.wait(CollaborationJoined); !ok {
+			t.Error("Expected the first client to hear about the second")
+		}
+	})
+
+	t.Run("Relays updates and awareness to the others", func(t *testing.T) {
+		secondSession.Handle(CollaborationMessage{Type: CollaborationUpdate, Update: "BAUG"})
+		secondSession.Handle(CollaborationMessage{Type: CollaborationAwareness, Awareness: []byte(`{"cursor":3}`)})
+
+		update, ok := first.wait(CollaborationUpdate)
+		if !ok || update.Update != "BAUG" || update.ClientID != secondSession.ID {
+			t.Errorf("Expected the update to be relayed, got %+v", update)
+		}
+
+		if _, ok := first.wait(CollaborationAwareness); !ok {
+			t.Error("Expected the awareness to be relayed")
+		}
+
+		if _, ok := second.last(CollaborationUpdate); ok {
+			t.Error("Expected updates not to be echoed back")
+		}
+	})
+
+	t.Run("Saves the state and bumps the version", func(t *testing.T) {
+		firstSession.Handle(CollaborationMessage{Type: CollaborationState, Content: `[{"id":"a","type":"paragraph","props":{},"content":[],"children":[]}]`})
+		hub.SaveAll()
+
+		var stored models.Page
+		if err := db.Preload("Editors").First(&stored, page.ID).Error; err != nil {
+			t.Fatalf("Failed to get page: %v", err)
+		}
+
+		if stored.Version != 2 || stored.Content == "[]" {
+			t.Errorf("Expected the state saved as version 2, got %+v", stored)
+		}
+
+		saved, ok := second.wait(CollaborationSaved)
+		if !ok || saved.Version != 2 {
+			t.Errorf("Expected a saved message for version 2, got %+v", saved)
+		}
+	})
+
+	t.Run("Rejects edits made on a stale version", func(t *testing.T) {
+		stale := uint(1)
+		if err := TestDocService.EditPage(admin, page.ID, "Shared", "/shared", "", nil, nil, &stale); err == nil || err.Error() != "page_version_conflict" {
+			t.Errorf("Expected page_version_conflict, got %v", err)
+		}
+	})
+
+	t.Run("Tells clients when the page changed elsewhere", func(t *testing.T) {
+		current := uint(2)
+		if err := TestDocService.EditPage(admin, page.ID, "Shared", "/shared", `[]`, nil, nil, &current); err != nil {
+			t.Fatalf("EditPage returned an error: %v", err)
+		}
+
+		firstSession.Handle(CollaborationMessage{Type: CollaborationState, Content: `[{"id":"b","type":"paragraph","props":{},"content":[],"children":[]}]`})
+		hub.SaveAll()
+
+		conflict, ok := first.wait(CollaborationConflict)
+		if !ok || conflict.Version != 3 || conflict.Content != "[]" {
+			t.Errorf("Expected a conflict at version 3, got %+v", conflict)
+		}
+	})
+
+	t.Run("Doesn't save over someone else's lock", func(t *testing.T) {
+		outsider := models.User{Username: "collaboration-outsider", Email: "outsider@example.com", Permissions: `["read","write"]`}
+		if err := db.Create(&outsider).Error; err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+
+		if err := TestDocService.SetDocumentationMember(admin, doc.ID, outsider.ID, RoleEditor); err != nil {
+			t.Fatalf("SetDocumentationMember returned an error: %v", err)
+		}
+
+		if _, err := TestDocService.AcquireLock(outsider, ResourcePage, page.ID); err != nil {
+			t.Fatalf("AcquireLock returned an error: %v", err)
+		}
+
+		secondSession.Handle(CollaborationMessage{Type: CollaborationState, Content: `[{"id":"locked","type":"paragraph","props":{},"content":[],"children":[]}]`})
+		hub.SaveAll()
+
+		if message, ok := second.wait(CollaborationError); !ok || message.Error != "locked_by_another_user" {
+			t.Errorf("Expected locked_by_another_user, got %+v", message)
+		}
+
+		var stored models.Page
+		if err := db.First(&stored, page.ID).Error; err != nil {
+			t.Fatalf("Failed to get page: %v", err)
+		}
+
+		if stored.Version != 3 || stored.Content != "[]" {
+			t.Errorf("Expected the page to be left at version 3, got %+v", stored)
+		}
+
+		if err := TestDocService.ReleaseLock(outsider, ResourcePage, page.ID); err != nil {
+			t.Fatalf("ReleaseLock returned an error: %v", err)
+		}
+	})
+
+	t.Run("Slow clients don't hold up the room", func(t *testing.T) {
+		slow := &fakeCollaborationConn{blocked: make(chan struct{})}
+		defer close(slow.blocked)
+
+		slowSession, err := hub.Join(page.ID, admin, slow)
+		if err != nil {
+			t.Fatalf("Join returned an error: %v", err)
+		}
+
+		done := make(chan struct{})
+		go func() {
+			for i := 0; i <= collaborationOutboxSize; i++ {
+				secondSession.Handle(CollaborationMessage{Type: CollaborationAwareness, Awareness: []byte(fmt.Sprintf(`{"cursor":%d}`, i))})
+			}
+			close(done)
+		}()
+
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Expected the room to keep going while a client is stuck")
+		}
+
+		room := slowSession.room
+		room.mutex.Lock()
+		closed := slowSession.closed
+		room.mutex.Unlock()
+
+		if !closed {
+			t.Error("Expected the slow client to be disconnected")
+		}
+
+		slowSession.Leave()
+	})
+
+	t.Run("The last client to leave records a revision", func(t *testing.T) {
+		secondSession.Handle(CollaborationMessage{Type: CollaborationState, Content: `[{"id":"c","type":"paragraph","props":{},"content":[],"children":[]}]`})
+
+		var before int64
+		db.Model(&models.PageRevision{}).Where("page_id = ?", page.ID).Count(&before)
+
+		firstSession.Leave()
+		if _, ok := second.wait(CollaborationLeft); !ok {
+			t.Error("Expected the second client to hear the first leave")
+		}
+
+		secondSession.Leave()
+
+		var after int64
+		db.Model(&models.PageRevision{}).Where("page_id = ?", page.ID).Count(&after)
+
+		if after != before+1 {
+			t.Errorf("Expected one new revision, got %d", after-before)
+		}
+
+		var stored models.Page
+		if err := db.Preload("Editors").First(&stored, page.ID).Error; err != nil {
+			t.Fatalf("Failed to get page: %v", err)
+		}
+
+		if stored.Version != 4 || stored.LastEditorID == nil || *stored.LastEditorID != user.ID {
+			t.Errorf("Expected version 4 last edited by user, got %+v", stored)
+		}
+
+		found := false
+		for _, editor := range stored.Editors {
+			found = found || editor.ID == user.ID
+		}
+		if !found {
+			t.Error("Expected user among the page's editors")
+		}
+	})
+}
@@ -0,0 +1,514 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"git.difuse.io/Difuse/kalmia/db/models"
+	"gorm.io/gorm"
+)
+
+const (
+	ChangeRequestOpen     = "open"
+	ChangeRequestMerged   = "merged"
+	ChangeRequestRejected = "rejected"
+	ChangeRequestClosed   = "closed"
+)
+
+const (
+	ReviewComment = "comment"
+	ReviewApprove = "approve"
+	ReviewReject  = "reject"
+)
+
+const maxRequiredApprovals = 10
+
+func selectReviewUser(db *gorm.DB) *gorm.DB {
+	return db.Select("ID", "Username", "Email", "Photo")
+}
+
+func (service *DocService) GetChangeRequests(docId uint, status string) ([]models.ChangeRequest, error) {
+	query := service.DB.Preload("Author", selectReviewUser).
+		Preload("Changes", func(db *gorm.DB) *gorm.DB {
+			return db.Select("ID", "ChangeRequestID", "PageID", "BaseVersion", "PageGroupID", "Title", "Slug", "CreatedAt")
+		}).
+		Preload("Reviews.Reviewer", selectReviewUser).
+		Where("documentation_id = ?", docId)
+
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	requests := make([]models.ChangeRequest, 0)
+	if err := query.Order("id DESC").Find(&requests).Error; err != nil {
+		return nil, fmt.Errorf("failed_to_get_change_requests")
+	}
+
+	return requests, nil
+}
+
+func (service *DocService) GetChangeRequest(id uint) (models.ChangeRequest, error) {
+	var request models.ChangeRequest
+	if err := service.DB.Preload("Author", selectReviewUser).
+		Preload("Changes").
+		Preload("Reviews.Reviewer", selectReviewUser).
+		First(&request, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return models.ChangeRequest{}, fmt.Errorf("change_request_not_found")
+		}
+		return models.ChangeRequest{}, fmt.Errorf("failed_to_get_change_request")
+	}
+
+	return request, nil
+}
+
+// checkChangeRequestSlugs makes sure every proposed slug is free, both in the
+// documentation and among the changes themselves.
+func checkChangeRequestSlugs(db *gorm.DB, docId uint, changes []models.ChangeRequestChange) error {
+	seen := make(map[string]bool, len(changes))
+
+	for _, change := range changes {
+		if seen[change.Slug] {
+			return fmt.Errorf("slug_already_in_use")
+		}
+		seen[change.Slug] = true
+
+		query := db.Model(&models.Page{}).Where("documentation_id = ? AND slug = ?", docId, change.Slug)
+		if change.PageID != nil {
+			query = query.Where("id <> ?", *change.PageID)
+		}
+
+		var count int64
+		if err := query.Count(&count).Error; err != nil {
+			return fmt.Errorf("failed_to_check_slug")
+		}
+
+		if count > 0 {
+			return fmt.Errorf("slug_already_in_use")
+		}
+	}
+
+	return nil
+}
+
+// CreateChangeRequest proposes changes to the pages of a documentation,
+// nothing is applied until the request is approved.
+func (service *DocService) CreateChangeRequest(user models.User, docId uint, title, description string, changes []models.ChangeRequestChange) (models.ChangeRequest, error) {
+	if !service.IsDocIdValid(docId) {
+		return models.ChangeRequest{}, fmt.Errorf("documentation_not_found")
+	}
+
+	if err := service.requireDocumentationRole(user.ID, docId, RoleEditor); err != nil {
+		return models.ChangeRequest{}, err
+	}
+
+	if len(changes) == 0 {
+		return models.ChangeRequest{}, fmt.Errorf("no_changes")
+	}
+
+	for i, change := range changes {
+		if change.Title == "" || change.Slug == "" {
+			return models.ChangeRequest{}, fmt.Errorf("invalid_change")
+		}
+
+		if change.PageID != nil {
+			var page models.Page
+			if err := service.DB.Select("id", "version").Where("id = ? AND documentation_id = ?", *change.PageID, docId).First(&page).Error; err != nil {
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					return models.ChangeRequest{}, fmt.Errorf("page_not_found")
+				}
+				return models.ChangeRequest{}, fmt.Errorf("failed_to_fetch_page")
+			}
+
+			changes[i].BaseVersion = page.Version
+		} else if change.Content == "" {
+			changes[i].Content = "[]"
+		}
+
+		if change.PageGroupID != nil {
+			var count int64
+			if err := service.DB.Model(&models.PageGroup{}).Where("id = ? AND documentation_id = ?", *change.PageGroupID, docId).Count(&count).Error; err != nil {
+				return models.ChangeRequest{}, fmt.Errorf("failed_to_fetch_page_group")
+			}
+
+			if count == 0 {
+				return models.ChangeRequest{}, fmt.Errorf("page_group_not_found")
+			}
+		}
+
+		changes[i].ID = 0
+		changes[i].ChangeRequestID = 0
+	}
+
+	if err := checkChangeRequestSlugs(service.DB, docId, changes); err != nil {
+		return models.ChangeRequest{}, err
+	}
+
+	request := models.ChangeRequest{
+		DocumentationID: docId,
+		AuthorID:        user.ID,
+		Title:           title,
+		Description:     description,
+		Status:          ChangeRequestOpen,
+		Changes:         changes,
+	}
+
+	if err := service.DB.Omit("Author").Create(&request).Error; err != nil {
+		return models.ChangeRequest{}, fmt.Errorf("failed_to_create_change_request")
+	}
+
+	service.audit("change_request.create", AuditEntityChangeRequest, request.ID, nil, map[string]interface{}{
+		"documentationId": docId,
+		"title":           title,
+		"changes":         len(changes),
+	})
+
+	return request, nil
+}
+
+// RequiredApprovals returns how many approvals a change request on the
+// documentation needs. The setting is shared by all versions.
+func (service *DocService) RequiredApprovals(docId uint) (uint, error) {
+	rootId, err := rootDocumentationID(service.DB, docId)
+	if err != nil {
+		return 0, fmt.Errorf("documentation_not_found")
+	}
+
+	var doc models.Documentation
+	if err := service.DB.Select("id", "required_approvals").First(&doc, rootId).Error; err != nil {
+		return 0, fmt.Errorf("documentation_not_found")
+	}
+
+	if doc.RequiredApprovals == 0 {
+		return 1, nil
+	}
+
+	return doc.RequiredApprovals, nil
+}
+
+func (service *DocService) SetRequiredApprovals(user models.User, docId uint, approvals uint) error {
+	if approvals == 0 || approvals > maxRequiredApprovals {
+		return fmt.Errorf("invalid_required_approvals")
+	}
+
+	rootId, err := rootDocumentationID(service.DB, docId)
+	if err != nil {
+		return fmt.Errorf("documentation_not_found")
+	}
+
+	if err := service.requireDocumentationRole(user.ID, rootId, RoleMaintainer); err != nil {
+		return err
+	}
+
+	previous, err := service.RequiredApprovals(rootId)
+	if err != nil {
+		return err
+	}
+
+	if err := service.DB.Model(&models.Documentation{}).Where("id = ?", rootId).UpdateColumn("required_approvals", approvals).Error; err != nil {
+		return fmt.Errorf("failed_to_update_documentation")
+	}
+
+	service.audit("documentation.required_approvals", AuditEntityDocumentation, rootId,
+		map[string]interface{}{"requiredApprovals": previous},
+		map[string]interface{}{"requiredApprovals": approvals})
+
+	return nil
+}
+
+// ReviewChangeRequest records a comment, an approval or a rejection. Only
+// maintainers approve or reject, and never their own requests. The request is
+// merged by the approval that brings it to the required count.
+func (service *DocService) ReviewChangeRequest(user models.User, id uint, verdict, comment string) (models.ChangeRequest, error) {
+	request, err := service.GetChangeRequest(id)
+	if err != nil {
+		return models.ChangeRequest{}, err
+	}
+
+	switch verdict {
+	case ReviewComment:
+		if comment == "" {
+			return models.ChangeRequest{}, fmt.Errorf("comment_required")
+		}
+
+		if err := service.requireDocumentationRole(user.ID, request.DocumentationID, RoleViewer); err != nil {
+			return models.ChangeRequest{}, err
+		}
+	case ReviewApprove, ReviewReject:
+		if request.AuthorID == user.ID {
+			return models.ChangeRequest{}, fmt.Errorf("cannot_review_own_change_request")
+		}
+
+		if err := service.requireDocumentationRole(user.ID, request.DocumentationID, RoleMaintainer); err != nil {
+			return models.ChangeRequest{}, err
+		}
+	default:
+		return models.ChangeRequest{}, fmt.Errorf("invalid_verdict")
+	}
+
+	if request.Status != ChangeRequestOpen {
+		return models.ChangeRequest{}, fmt.Errorf("change_request_not_open")
+	}
+
+	// The review, the count of approvals and the merge it may bring happen in
+	// one transaction that holds the request, so concurrent reviews can't
+	// merge it twice or merge one just rejected. A merge that fails takes the
+	// approval with it.
+	var merge *changeRequestMerge
+	err = service.DB.Transaction(func(tx *gorm.DB) error {
+		if err := holdOpenChangeRequest(tx, request.ID); err != nil {
+			return err
+		}
+
+		review := models.ChangeRequestReview{ChangeRequestID: request.ID, ReviewerID: user.ID, Verdict: verdict, Comment: comment}
+		if err := tx.Omit("Reviewer").Create(&review).Error; err != nil {
+			return fmt.Errorf("failed_to_create_review")
+		}
+
+		switch verdict {
+		case ReviewReject:
+			return setChangeRequestStatus(tx, request.ID, ChangeRequestRejected)
+		case ReviewApprove:
+			required, err := service.withDB(tx).RequiredApprovals(request.DocumentationID)
+			if err != nil {
+				return err
+			}
+
+			var approvals int64
+			if err := tx.Model(&models.ChangeRequestReview{}).
+				Where("change_request_id = ? AND verdict = ?", request.ID, ReviewApprove).
+				Distinct("reviewer_id").
+				Count(&approvals).Error; err != nil {
+				return fmt.Errorf("failed_to_count_approvals")
+			}
+
+			if approvals >= int64(required) {
+				applied, err := service.applyChangeRequest(tx, user, request)
+				if err != nil {
+					return err
+				}
+				merge = &applied
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return models.ChangeRequest{}, err
+	}
+
+	service.audit("change_request.review", AuditEntityChangeRequest, request.ID, nil, map[string]interface{}{"verdict": verdict, "comment": comment})
+
+	if merge != nil {
+		if err := service.changeRequestMerged(request, *merge); err != nil {
+			return models.ChangeRequest{}, err
+		}
+	}
+
+	return service.GetChangeRequest(request.ID)
+}
+
+// holdOpenChangeRequest touches an open request so the transaction holds it
+// until it ends, other reviews of it wait their turn.
+func holdOpenChangeRequest(tx *gorm.DB, id uint) error {
+	result := tx.Model(&models.ChangeRequest{}).Where("id = ? AND status = ?", id, ChangeRequestOpen).UpdateColumn("updated_at", time.Now().UTC())
+	if result.Error != nil {
+		return fmt.Errorf("failed_to_update_change_request")
+	}
+
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("change_request_not_open")
+	}
+
+	return nil
+}
+
+// setChangeRequestStatus moves a request out of open. It fails with
+// change_request_not_open when something else did first.
+func setChangeRequestStatus(tx *gorm.DB, id uint, status string) error {
+	result := tx.Model(&models.ChangeRequest{}).Where("id = ? AND status = ?", id, ChangeRequestOpen).Updates(map[string]interface{}{
+		"status":    status,
+		"closed_at": time.Now().UTC(),
+	})
+	if result.Error != nil {
+		return fmt.Errorf("failed_to_update_change_request")
+	}
+
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("change_request_not_open")
+	}
+
+	return nil
+}
+
+type editedPage struct {
+	previous models.Page
+	page     models.Page
+}
+
+// changeRequestMerge is what applying a request changed, for the hooks that
+// run once it's committed.
+type changeRequestMerge struct {
+	author  models.User
+	edited  []editedPage
+	created []models.Page
+	pageIds []uint
+}
+
+// applyChangeRequest applies the changes in the author's name, so they show
+// up as the page's editor, publishes the pages and marks the request merged.
+// A change that can't be applied, like one to a page edited since it was
+// proposed, fails the transaction and leaves the pages as they were.
+func (service *DocService) applyChangeRequest(tx *gorm.DB, user models.User, request models.ChangeRequest) (changeRequestMerge, error) {
+	merge := changeRequestMerge{pageIds: make([]uint, 0, len(request.Changes))}
+
+	if err := tx.First(&merge.author, request.AuthorID).Error; err != nil {
+		return merge, fmt.Errorf("user_not_found")
+	}
+	author := merge.author
+
+	if err := requireDocumentationRole(tx, author.ID, request.DocumentationID, RoleEditor); err != nil {
+		return merge, err
+	}
+
+	result := tx.Model(&models.ChangeRequest{}).Where("id = ? AND status = ?", request.ID, ChangeRequestOpen).Updates(map[string]interface{}{
+		"status":       ChangeRequestMerged,
+		"merged_by_id": user.ID,
+		"merged_at":    time.Now().UTC(),
+	})
+	if result.Error != nil {
+		return merge, fmt.Errorf("failed_to_update_change_request")
+	}
+
+	if result.RowsAffected == 0 {
+		return merge, fmt.Errorf("change_request_not_open")
+	}
+
+	if err := checkChangeRequestSlugs(tx, request.DocumentationID, request.Changes); err != nil {
+		return merge, err
+	}
+
+	for _, change := range request.Changes {
+		if change.PageID != nil {
+			var count int64
+			if err := tx.Model(&models.Page{}).Where("id = ? AND documentation_id = ?", *change.PageID, request.DocumentationID).Count(&count).Error; err != nil {
+				return merge, fmt.Errorf("failed_to_fetch_page")
+			}
+			if count == 0 {
+				return merge, fmt.Errorf("page_not_found")
+			}
+
+			if err := checkEditLock(tx, ResourcePage, *change.PageID, author.ID); err != nil {
+				return merge, err
+			}
+
+			var version *uint
+			if change.BaseVersion != 0 {
+				version = &change.BaseVersion
+			}
+
+			previous, page, err := editPageDraft(tx, author, *change.PageID, change.Title, change.Slug, change.Content, nil, change.PageGroupID, version)
+			if err != nil {
+				return merge, err
+			}
+
+			merge.edited = append(merge.edited, editedPage{previous: previous, page: page})
+			merge.pageIds = append(merge.pageIds, page.ID)
+			continue
+		}
+
+		page := models.Page{
+			DocumentationID: request.DocumentationID,
+			AuthorID:        author.ID,
+			PageGroupID:     change.PageGroupID,
+			Title:           change.Title,
+			Slug:            change.Slug,
+			Content:         change.Content,
+			IsPage:          true,
+		}
+
+		if err := createPageDraft(tx, &page); err != nil {
+			return merge, err
+		}
+
+		if err := tx.Model(&models.ChangeRequestChange{}).Where("id = ?", change.ID).UpdateColumn("page_id", page.ID).Error; err != nil {
+			return merge, fmt.Errorf("failed_to_update_change_request")
+		}
+
+		merge.created = append(merge.created, page)
+		merge.pageIds = append(merge.pageIds, page.ID)
+	}
+
+	var pages []models.Page
+	if err := tx.Where("id IN ?", merge.pageIds).Find(&pages).Error; err != nil {
+		return merge, fmt.Errorf("failed_to_get_pages")
+	}
+
+	if err := service.checkPublishLinks(tx, request.DocumentationID, pages); err != nil {
+		return merge, err
+	}
+
+	for _, page := range pages {
+		if _, err := publishPageContent(tx, page, author.ID); err != nil {
+			return merge, err
+		}
+	}
+
+	if err := checkPublishedSlugs(tx, request.DocumentationID); err != nil {
+		return merge, err
+	}
+
+	return merge, nil
+}
+
+// changeRequestMerged runs the page hooks for a committed merge and rebuilds
+// the site.
+func (service *DocService) changeRequestMerged(request models.ChangeRequest, merge changeRequestMerge) error {
+	for _, page := range merge.created {
+		if err := service.pageCreated(page); err != nil {
+			return err
+		}
+	}
+
+	for _, edit := range merge.edited {
+		if err := service.pageEdited(merge.author, edit.previous, edit.page); err != nil {
+			return err
+		}
+	}
+
+	service.audit("change_request.merge", AuditEntityChangeRequest, request.ID, nil, map[string]interface{}{"pageIds": merge.pageIds})
+
+	if err := service.triggerRootBuild(request.DocumentationID); err != nil {
+		return fmt.Errorf("failed_to_update_write_build")
+	}
+
+	return nil
+}
+
+// CloseChangeRequest withdraws an open request without applying it. Authors
+// close their own requests, maintainers close any.
+func (service *DocService) CloseChangeRequest(user models.User, id uint) error {
+	request, err := service.GetChangeRequest(id)
+	if err != nil {
+		return err
+	}
+
+	if request.AuthorID != user.ID {
+		if err := service.requireDocumentationRole(user.ID, request.DocumentationID, RoleMaintainer); err != nil {
+			return err
+		}
+	}
+
+	if request.Status != ChangeRequestOpen {
+		return fmt.Errorf("change_request_not_open")
+	}
+
+	if err := setChangeRequestStatus(service.DB, request.ID, ChangeRequestClosed); err != nil {
+		return err
+	}
+
+	service.audit("change_request.close", AuditEntityChangeRequest, request.ID, map[string]interface{}{"status": ChangeRequestOpen}, map[string]interface{}{"status": ChangeRequestClosed})
+
+	return nil
+}
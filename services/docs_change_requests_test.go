@@ -0,0 +1,290 @@
+package services
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"git.difuse.io/Difuse/kalmia/db/models"
+)
+
+func TestChangeRequests(t *testing.T) {
+	if TestDocService == nil {
+		t.Fatal("TestDocService is nil")
+	}
+
+	db := TestDocService.DB
+
+	var admin, user models.User
+	if err := db.Where("username = ?", "admin").First(&admin).Error; err != nil {
+		t.Fatalf("Failed to get admin: %v", err)
+	}
+	if err := db.Where("username = ?", "user").First(&user).Error; err != nil {
+		t.Fatalf("Failed to get user: %v", err)
+	}
+
+	doc := models.Documentation{Name: "Reviews", Version: "1.0.0", BaseURL: "/reviews", AuthorID: admin.ID}
+	if err := db.Create(&doc).Error; err != nil {
+		t.Fatalf("Failed to create documentation: %v", err)
+	}
+
+	if err := TestDocService.SetDocumentationMember(admin, doc.ID, user.ID, RoleEditor); err != nil {
+		t.Fatalf("SetDocumentationMember returned an error: %v", err)
+	}
+
+	page := models.Page{DocumentationID: doc.ID, Title: "Intro", Slug: "/intro", Content: "[]", AuthorID: admin.ID}
+	if err := TestDocService.CreatePage(&page); err != nil {
+		t.Fatalf("CreatePage returned an error: %v", err)
+	}
+
+	if err := TestDocService.PublishPage(admin, page.ID); err != nil {
+		t.Fatalf("PublishPage returned an error: %v", err)
+	}
+
+	changes := []models.ChangeRequestChange{
+		{PageID: &page.ID, Title: "Introduction", Slug: "/intro"},
+		{Title: "Setup", Slug: "/setup", Content: "[]"},
+	}
+
+	t.Run("Rejects slugs that are taken", func(t *testing.T) {
+		taken := []models.ChangeRequestChange{{Title: "Intro again", Slug: "/intro"}}
+		if _, err := TestDocService.CreateChangeRequest(user, doc.ID, "Taken", "", taken); err == nil || err.Error() != "slug_already_in_use" {
+			t.Errorf("Expected slug_already_in_use, got %v", err)
+		}
+	})
+
+	request, err := TestDocService.CreateChangeRequest(user, doc.ID, "Rework the intro", "", changes)
+	if err != nil {
+		t.Fatalf("CreateChangeRequest returned an error: %v", err)
+	}
+
+	t.Run("Nothing changes before approval", func(t *testing.T) {
+		var stored models.Page
+		if err := db.First(&stored, page.ID).Error; err != nil {
+			t.Fatalf("Failed to get page: %v", err)
+		}
+
+		if stored.Title != "Intro" {
+			t.Errorf("Expected the page to be untouched, got %q", stored.Title)
+		}
+	})
+
+	t.Run("Authors can't approve their own requests", func(t *testing.T) {
+		if _, err := TestDocService.ReviewChangeRequest(user, request.ID, ReviewApprove, ""); err == nil || err.Error() != "cannot_review_own_change_request" {
+			t.Errorf("Expected cannot_review_own_change_request, got %v", err)
+		}
+
+		if _, err := TestDocService.ReviewChangeRequest(user, request.ID, ReviewComment, "Ready for review"); err != nil {
+			t.Errorf("Expected the author to comment, got %v", err)
+		}
+	})
+
+	t.Run("Waits for the required approvals", func(t *testing.T) {
+		if err := TestDocService.SetRequiredApprovals(admin, doc.ID, 2); err != nil {
+			t.Fatalf("SetRequiredApprovals returned an error: %v", err)
+		}
+
+		reviewed, err := TestDocService.ReviewChangeRequest(admin, request.ID, ReviewApprove, "")
+		if err != nil {
+			t.Fatalf("ReviewChangeRequest returned an error: %v", err)
+		}
+
+		if reviewed.Status != ChangeRequestOpen || len(reviewed.Reviews) != 2 {
+			t.Errorf("Expected an open request with 2 reviews, got %s with %d", reviewed.Status, len(reviewed.Reviews))
+		}
+	})
+
+	t.Run("Approval applies and publishes the changes", func(t *testing.T) {
+		if err := TestDocService.SetRequiredApprovals(admin, doc.ID, 1); err != nil {
+			t.Fatalf("SetRequiredApprovals returned an error: %v", err)
+		}
+
+		reviewed, err := TestDocService.ReviewChangeRequest(admin, request.ID, ReviewApprove, "Looks good")
+		if err != nil {
+			t.Fatalf("ReviewChangeRequest returned an error: %v", err)
+		}
+
+		if reviewed.Status != ChangeRequestMerged || reviewed.MergedByID == nil || *reviewed.MergedByID != admin.ID {
+			t.Fatalf("Expected the request merged by admin, got %+v", reviewed)
+		}
+
+		published, err := TestDocService.getPublishedPage(page.ID)
+		if err != nil || published.Title != "Introduction" {
+			t.Errorf("Expected the edit to be published, got %+v (%v)", published, err)
+		}
+
+		var stored models.Page
+		if err := db.Preload("Editors").First(&stored, page.ID).Error; err != nil {
+			t.Fatalf("Failed to get page: %v", err)
+		}
+
+		isEditor := false
+		for _, editor := range stored.Editors {
+			isEditor = isEditor || editor.ID == user.ID
+		}
+
+		if !isEditor {
+			t.Error("Expected the author to be listed as an editor")
+		}
+
+		var created models.Page
+		if err := db.Where("documentation_id = ? AND slug = ?", doc.ID, "/setup").First(&created).Error; err != nil {
+			t.Fatalf("Expected the new page to exist: %v", err)
+		}
+
+		if created.AuthorID != user.ID || created.PublishedRevisionID == nil {
+			t.Errorf("Expected a published page by the author, got %+v", created)
+		}
+	})
+
+	t.Run("Stale requests fail without applying anything", func(t *testing.T) {
+		stale, err := TestDocService.CreateChangeRequest(user, doc.ID, "FAQ", "", []models.ChangeRequestChange{
+			{Title: "FAQ", Slug: "/faq", Content: "[]"},
+			{PageID: &page.ID, Title: "Outdated", Slug: "/intro"},
+		})
+		if err != nil {
+			t.Fatalf("CreateChangeRequest returned an error: %v", err)
+		}
+
+		if err := TestDocService.EditPage(admin, page.ID, "Edited since", "/intro", "", nil, nil, nil); err != nil {
+			t.Fatalf("EditPage returned an error: %v", err)
+		}
+
+		if _, err := TestDocService.ReviewChangeRequest(admin, stale.ID, ReviewApprove, ""); err == nil || err.Error() != "page_version_conflict" {
+			t.Fatalf("Expected page_version_conflict, got %v", err)
+		}
+
+		var count int64
+		db.Model(&models.Page{}).Where("documentation_id = ? AND slug = ?", doc.ID, "/faq").Count(&count)
+		if count != 0 {
+			t.Errorf("Expected the new page to not be created")
+		}
+
+		var stored models.Page
+		if err := db.First(&stored, page.ID).Error; err != nil {
+			t.Fatalf("Failed to get page: %v", err)
+		}
+		if stored.Title != "Edited since" {
+			t.Errorf("Expected the later edit to be kept, got %q", stored.Title)
+		}
+
+		reviewed, err := TestDocService.GetChangeRequest(stale.ID)
+		if err != nil || reviewed.Status != ChangeRequestOpen {
+			t.Errorf("Expected the request to stay open, got %+v (%v)", reviewed, err)
+		}
+
+		if len(reviewed.Reviews) != 0 {
+			t.Errorf("Expected the approval to be rolled back with the merge, got %d reviews", len(reviewed.Reviews))
+		}
+
+		if err := TestDocService.CloseChangeRequest(user, stale.ID); err != nil {
+			t.Fatalf("CloseChangeRequest returned an error: %v", err)
+		}
+	})
+
+	t.Run("Broken links block the merge", func(t *testing.T) {
+		TestConfig.LinkCheck.BlockPublish = true
+		defer func() {
+			TestConfig.LinkCheck.BlockPublish = false
+		}()
+
+		broken, err := TestDocService.CreateChangeRequest(user, doc.ID, "Links", "", []models.ChangeRequestChange{
+			{Title: "Links", Slug: "/links", Content: linkContent(t, []string{"/reviews/guides/missing"}, nil)},
+		})
+		if err != nil {
+			t.Fatalf("CreateChangeRequest returned an error: %v", err)
+		}
+
+		if _, err := TestDocService.ReviewChangeRequest(admin, broken.ID, ReviewApprove, ""); err == nil || err.Error() != "broken_links" {
+			t.Fatalf("Expected broken_links, got %v", err)
+		}
+
+		var count int64
+		db.Model(&models.Page{}).Where("documentation_id = ? AND slug = ?", doc.ID, "/links").Count(&count)
+		if count != 0 {
+			t.Errorf("Expected the new page to not be created")
+		}
+
+		reviewed, err := TestDocService.GetChangeRequest(broken.ID)
+		if err != nil || reviewed.Status != ChangeRequestOpen || reviewed.MergedByID != nil || reviewed.MergedAt != nil {
+			t.Errorf("Expected the request to stay open and unmerged, got %+v (%v)", reviewed, err)
+		}
+
+		if err := TestDocService.CloseChangeRequest(user, broken.ID); err != nil {
+			t.Fatalf("CloseChangeRequest returned an error: %v", err)
+		}
+	})
+
+	t.Run("Rejection and closing end a request", func(t *testing.T) {
+		rejected, err := TestDocService.CreateChangeRequest(user, doc.ID, "Rename", "", []models.ChangeRequestChange{{PageID: &page.ID, Title: "Welcome", Slug: "/intro"}})
+		if err != nil {
+			t.Fatalf("CreateChangeRequest returned an error: %v", err)
+		}
+
+		reviewed, err := TestDocService.ReviewChangeRequest(admin, rejected.ID, ReviewReject, "Keep the old title")
+		if err != nil || reviewed.Status != ChangeRequestRejected {
+			t.Fatalf("Expected the request to be rejected, got %+v (%v)", reviewed, err)
+		}
+
+		if _, err := TestDocService.ReviewChangeRequest(admin, rejected.ID, ReviewApprove, ""); err == nil || err.Error() != "change_request_not_open" {
+			t.Errorf("Expected change_request_not_open, got %v", err)
+		}
+
+		closed, err := TestDocService.CreateChangeRequest(user, doc.ID, "Withdrawn", "", []models.ChangeRequestChange{{PageID: &page.ID, Title: "Hello", Slug: "/intro"}})
+		if err != nil {
+			t.Fatalf("CreateChangeRequest returned an error: %v", err)
+		}
+
+		if err := TestDocService.CloseChangeRequest(user, closed.ID); err != nil {
+			t.Fatalf("CloseChangeRequest returned an error: %v", err)
+		}
+
+		open, err := TestDocService.GetChangeRequests(doc.ID, ChangeRequestOpen)
+		if err != nil {
+			t.Fatalf("GetChangeRequests returned an error: %v", err)
+		}
+
+		if len(open) != 0 {
+			t.Errorf("Expected no open requests, got %d", len(open))
+		}
+	})
+
+	t.Run("Concurrent approvals merge once", func(t *testing.T) {
+		if err := TestDocService.SetRequiredApprovals(admin, doc.ID, 1); err != nil {
+			t.Fatalf("SetRequiredApprovals returned an error: %v", err)
+		}
+
+		raced, err := TestDocService.CreateChangeRequest(user, doc.ID, "Race", "", []models.ChangeRequestChange{{Title: "Race", Slug: "/race", Content: "[]"}})
+		if err != nil {
+			t.Fatalf("CreateChangeRequest returned an error: %v", err)
+		}
+
+		var wg sync.WaitGroup
+		var merged atomic.Int32
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := TestDocService.ReviewChangeRequest(admin, raced.ID, ReviewApprove, ""); err == nil {
+					merged.Add(1)
+				}
+			}()
+		}
+		wg.Wait()
+
+		var count int64
+		db.Model(&models.Page{}).Where("documentation_id = ? AND slug = ?", doc.ID, "/race").Count(&count)
+		if merged.Load() > 1 || count != int64(merged.Load()) {
+			t.Errorf("Expected at most one merge and one page, got %d merges and %d pages", merged.Load(), count)
+		}
+
+		reviewed, err := TestDocService.GetChangeRequest(raced.ID)
+		if err != nil {
+			t.Fatalf("GetChangeRequest returned an error: %v", err)
+		}
+
+		if (reviewed.Status == ChangeRequestMerged) != (merged.Load() == 1) || len(reviewed.Reviews) != int(merged.Load()) {
+			t.Errorf("Expected the request to match its merges, got %s with %d reviews", reviewed.Status, len(reviewed.Reviews))
+		}
+	})
+}
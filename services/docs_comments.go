@@ -0,0 +1,304 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"git.difuse.io/Difuse/kalmia/db/models"
+	"git.difuse.io/Difuse/kalmia/utils"
+	"gorm.io/gorm"
+)
+
+// Usernames are alphanumeric, so a mention ends at the first other character.
+var mentionPattern = regexp.MustCompile(`(?:^|[^A-Za-z0-9])@([A-Za-z0-9]+)`)
+
+type webhookComment struct {
+	ThreadID        uint     `json:"threadId"`
+	CommentID       uint     `json:"commentId"`
+	PageID          uint     `json:"pageId"`
+	DocumentationID uint     `json:"documentationId"`
+	BlockID         string   `json:"blockId,omitempty"`
+	AuthorID        uint     `json:"authorId"`
+	Body            string   `json:"body"`
+	Mentions        []string `json:"mentions"`
+}
+
+// mentionedUsers returns the existing users mentioned in body as @username.
+// Anything after an @ that isn't a username is left alone.
+func mentionedUsers(db *gorm.DB, body string) ([]models.User, error) {
+	usernames := make([]string, 0)
+	seen := make(map[string]bool)
+
+	for _, match := range mentionPattern.FindAllStringSubmatch(body, -1) {
+		if !seen[match[1]] {
+			seen[match[1]] = true
+			usernames = append(usernames, match[1])
+		}
+	}
+
+	users := make([]models.User, 0)
+	if len(usernames) == 0 {
+		return users, nil
+	}
+
+	if err := db.Select("id", "username").Where("username IN ?", usernames).Find(&users).Error; err != nil {
+		return nil, fmt.Errorf("failed_to_get_users")
+	}
+
+	return users, nil
+}
+
+func commentThreadQuery(db *gorm.DB) *gorm.DB {
+	return db.Preload("Author", selectReviewUser).
+		Preload("Comments", func(db *gorm.DB) *gorm.DB {
+			return db.Order("id ASC")
+		}).
+		Preload("Comments.Author", selectReviewUser).
+		Preload("Comments.Mentions", func(db *gorm.DB) *gorm.DB {
+			return db.Select("users.id", "users.username")
+		})
+}
+
+func (service *DocService) GetCommentThread(id uint) (models.CommentThread, error) {
+	var thread models.CommentThread
+	if err := commentThreadQuery(service.DB).First(&thread, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return models.CommentThread{}, fmt.Errorf("comment_thread_not_found")
+		}
+		return models.CommentThread{}, fmt.Errorf("failed_to_get_comment_thread")
+	}
+
+	return thread, nil
+}
+
+// GetPageCommentThreads lists the threads on a page, resolved ones only when
+// asked for.
+func (service *DocService) GetPageCommentThreads(pageId uint, includeResolved bool) ([]models.CommentThread, error) {
+	query := commentThreadQuery(service.DB).Where("page_id = ?", pageId)
+	if !includeResolved {
+		query = query.Where("resolved = ?", false)
+	}
+
+	threads := make([]models.CommentThread, 0)
+	if err := query.Order("id ASC").Find(&threads).Error; err != nil {
+		return nil, fmt.Errorf("failed_to_get_comment_threads")
+	}
+
+	return threads, nil
+}
+
+// GetOpenCommentThreads lists the unresolved threads across a documentation
+// version, newest activity first.
+func (service *DocService) GetOpenCommentThreads(docId uint) ([]models.CommentThread, error) {
+	// Threads on pages in the trash come back when the page is restored.
+	live := service.DB.Model(&models.Page{}).Select("id").Where("documentation_id = ?", docId)
+
+	threads := make([]models.CommentThread, 0)
+	if err := commentThreadQuery(service.DB).
+		Where("documentation_id = ? AND resolved = ? AND page_id IN (?)", docId, false, live).
+		Order("updated_at DESC").
+		Find(&threads).Error; err != nil {
+		return nil, fmt.Errorf("failed_to_get_comment_threads")
+	}
+
+	return threads, nil
+}
+
+// GetMentionedCommentThreads lists the unresolved threads the user is
+// mentioned in.
+func (service *DocService) GetMentionedCommentThreads(userId uint) ([]models.CommentThread, error) {
+	mentioned := service.DB.Table("comments").
+		Select("comments.thread_id").
+		Joins("JOIN comment_mentions ON comment_mentions.comment_id = comments.id").
+		Where("comment_mentions.user_id = ?", userId)
+
+	threads := make([]models.CommentThread, 0)
+	if err := commentThreadQuery(service.DB).
+		Where("id IN (?) AND resolved = ? AND page_id IN (?)", mentioned, false, service.DB.Model(&models.Page{}).Select("id")).
+		Order("updated_at DESC").
+		Find(&threads).Error; err != nil {
+		return nil, fmt.Errorf("failed_to_get_comment_threads")
+	}
+
+	return threads, nil
+}
+
+func (service *DocService) addComment(thread models.CommentThread, user models.User, body string) (models.Comment, error) {
+	body = strings.TrimSpace(body)
+	if body == "" {
+		return models.Comment{}, fmt.Errorf("comment_required")
+	}
+
+	mentions, err := mentionedUsers(service.DB, body)
+	if err != nil {
+		return models.Comment{}, err
+	}
+
+	comment := models.Comment{ThreadID: thread.ID, AuthorID: user.ID, Body: body, Mentions: mentions}
+
+	err = service.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Author", "Mentions.*").Create(&comment).Error; err != nil {
+			return fmt.Errorf("failed_to_create_comment")
+		}
+
+		if err := tx.Model(&models.CommentThread{}).Where("id = ?", thread.ID).UpdateColumn("updated_at", time.Now().UTC()).Error; err != nil {
+			return fmt.Errorf("failed_to_update_comment_thread")
+		}
+
+		return nil
+	})
+	if err != nil {
+		return models.Comment{}, err
+	}
+
+	usernames := make([]string, 0, len(mentions))
+	for _, mention := range mentions {
+		usernames = append(usernames, mention.Username)
+	}
+
+	service.emitWebhookEvent(thread.DocumentationID, WebhookEventCommentCreated, webhookComment{
+		ThreadID:        thread.ID,
+		CommentID:       comment.ID,
+		PageID:          thread.PageID,
+		DocumentationID: thread.DocumentationID,
+		BlockID:         thread.BlockID,
+		AuthorID:        user.ID,
+		Body:            body,
+		Mentions:        usernames,
+	})
+
+	return comment, nil
+}
+
+// CreateCommentThread starts a discussion on a page. With a blockId the
+// thread is about that block, which has to be on the page.
+func (service *DocService) CreateCommentThread(user models.User, pageId uint, blockId string, body string) (models.CommentThread, error) {
+	var page models.Page
+	if err := service.DB.Select("id", "documentation_id", "content").First(&page, pageId).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return models.CommentThread{}, fmt.Errorf("page_not_found")
+		}
+		return models.CommentThread{}, fmt.Errorf("failed_to_fetch_page")
+	}
+
+	if err := service.requireDocumentationRole(user.ID, page.DocumentationID, RoleViewer); err != nil {
+		return models.CommentThread{}, err
+	}
+
+	if blockId != "" {
+		blocks, err := utils.ParseBlocks(page.Content)
+		if err != nil {
+			return models.CommentThread{}, fmt.Errorf("failed_to_parse_page_content")
+		}
+
+		if _, ok := utils.FindBlock(blocks, blockId); !ok {
+			return models.CommentThread{}, fmt.Errorf("block_not_found")
+		}
+	}
+
+	if strings.TrimSpace(body) == "" {
+		return models.CommentThread{}, fmt.Errorf("comment_required")
+	}
+
+	thread := models.CommentThread{PageID: page.ID, DocumentationID: page.DocumentationID, BlockID: blockId, AuthorID: user.ID}
+	if err := service.DB.Omit("Author").Create(&thread).Error; err != nil {
+		return models.CommentThread{}, fmt.Errorf("failed_to_create_comment_thread")
+	}
+
+	if _, err := service.addComment(thread, user, body); err != nil {
+		service.DB.Delete(&thread)
+		return models.CommentThread{}, err
+	}
+
+	service.audit("comment_thread.create", AuditEntityCommentThread, thread.ID, nil, map[string]interface{}{"pageId": page.ID, "blockId": blockId})
+
+	return service.GetCommentThread(thread.ID)
+}
+
+func (service *DocService) ReplyToCommentThread(user models.User, threadId uint, body string) (models.Comment, error) {
+	thread, err := service.GetCommentThread(threadId)
+	if err != nil {
+		return models.Comment{}, err
+	}
+
+	if err := service.requireDocumentationRole(user.ID, thread.DocumentationID, RoleViewer); err != nil {
+		return models.Comment{}, err
+	}
+
+	comment, err := service.addComment(thread, user, body)
+	if err != nil {
+		return models.Comment{}, err
+	}
+
+	service.audit("comment_thread.reply", AuditEntityCommentThread, thread.ID, nil, map[string]interface{}{"commentId": comment.ID})
+
+	return comment, nil
+}
+
+// SetCommentThreadResolved resolves or reopens a thread. The thread's author
+// and the documentation's editors may do either.
+func (service *DocService) SetCommentThreadResolved(user models.User, threadId uint, resolved bool) error {
+	thread, err := service.GetCommentThread(threadId)
+	if err != nil {
+		return err
+	}
+
+	required := RoleEditor
+	if thread.AuthorID == user.ID {
+		required = RoleViewer
+	}
+
+	if err := service.requireDocumentationRole(user.ID, thread.DocumentationID, required); err != nil {
+		return err
+	}
+
+	if thread.Resolved == resolved {
+		if resolved {
+			return fmt.Errorf("comment_thread_already_resolved")
+		}
+		return fmt.Errorf("comment_thread_not_resolved")
+	}
+
+	columns := map[string]interface{}{"resolved": resolved, "resolved_by_id": nil, "resolved_at": nil}
+	if resolved {
+		columns["resolved_by_id"] = user.ID
+		columns["resolved_at"] = time.Now().UTC()
+	}
+
+	if err := service.DB.Model(&models.CommentThread{}).Where("id = ?", thread.ID).Updates(columns).Error; err != nil {
+		return fmt.Errorf("failed_to_update_comment_thread")
+	}
+
+	action := "comment_thread.resolve"
+	if !resolved {
+		action = "comment_thread.unresolve"
+	}
+
+	service.audit(action, AuditEntityCommentThread, thread.ID, map[string]interface{}{"resolved": thread.Resolved}, map[string]interface{}{"resolved": resolved})
+
+	return nil
+}
+
+// deleteCommentThreads removes the threads matching the query, with their
+// comments and mentions.
+func deleteCommentThreads(tx *gorm.DB, query string, args ...interface{}) error {
+	threadIds := tx.Model(&models.CommentThread{}).Select("id").Where(query, args...)
+	commentIds := tx.Model(&models.Comment{}).Select("id").Where("thread_id IN (?)", threadIds)
+
+	if err := tx.Exec("DELETE FROM comment_mentions WHERE comment_id IN (?)", commentIds).Error; err != nil {
+		return fmt.Errorf("failed_to_delete_comments")
+	}
+
+	if err := tx.Where("thread_id IN (?)", threadIds).Delete(&models.Comment{}).Error; err != nil {
+		return fmt.Errorf("failed_to_delete_comments")
+	}
+
+	if err := tx.Where(query, args...).Delete(&models.CommentThread{}).Error; err != nil {
+		return fmt.Errorf("failed_to_delete_comment_threads")
+	}
+
+	return nil
+}
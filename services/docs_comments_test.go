@@ -0,0 +1,139 @@
+package services
+
+import (
+	"testing"
+
+	"git.difuse.io/Difuse/kalmia/db/models"
+)
+
+func TestCommentThreads(t *testing.T) {
+	if TestDocService == nil {
+		t.Fatal("TestDocService is nil")
+	}
+
+	db := TestDocService.DB
+
+	var admin, user models.User
+	if err := db.Where("username = ?", "admin").First(&admin).Error; err != nil {
+		t.Fatalf("Failed to get admin: %v", err)
+	}
+	if err := db.Where("username = ?", "user").First(&user).Error; err != nil {
+		t.Fatalf("Failed to get user: %v", err)
+	}
+
+	doc := models.Documentation{Name: "Comments", Version: "1.0.0", BaseURL: "/comments", AuthorID: admin.ID}
+	if err := db.Create(&doc).Error; err != nil {
+		t.Fatalf("Failed to create documentation: %v", err)
+	}
+
+	if err := TestDocService.SetDocumentationMember(admin, doc.ID, user.ID, RoleViewer); err != nil {
+		t.Fatalf("SetDocumentationMember returned an error: %v", err)
+	}
+
+	content := `[{"id":"outer","type":"paragraph","props":{},"content":[],"children":[{"id":"inner","type":"paragraph","props":{},"content":[],"children":[]}]}]`
+	page := models.Page{DocumentationID: doc.ID, Title: "Discussed", Slug: "/discussed", Content: content, AuthorID: admin.ID}
+	if err := TestDocService.CreatePage(&page); err != nil {
+		t.Fatalf("CreatePage returned an error: %v", err)
+	}
+
+	t.Run("Anchors threads to existing blocks", func(t *testing.T) {
+		if _, err := TestDocService.CreateCommentThread(user, page.ID, "missing", "Hello"); err == nil || err.Error() != "block_not_found" {
+			t.Errorf("Expected block_not_found, got %v", err)
+		}
+	})
+
+	thread, err := TestDocService.CreateCommentThread(user, page.ID, "inner", "@admin is this still accurate? cc @nobody")
+	if err != nil {
+		t.Fatalf("CreateCommentThread returned an error: %v", err)
+	}
+
+	t.Run("Records mentions of existing users", func(t *testing.T) {
+		if len(thread.Comments) != 1 || len(thread.Comments[0].Mentions) != 1 || thread.Comments[0].Mentions[0].ID != admin.ID {
+			t.Fatalf("Expected a comment mentioning admin, got %+v", thread.Comments)
+		}
+
+		mentioned, err := TestDocService.GetMentionedCommentThreads(admin.ID)
+		if err != nil {
+			t.Fatalf("GetMentionedCommentThreads returned an error: %v", err)
+		}
+
+		if len(mentioned) != 1 || mentioned[0].ID != thread.ID {
+			t.Errorf("Expected the thread to mention admin, got %+v", mentioned)
+		}
+	})
+
+	t.Run("Replies and resolves", func(t *testing.T) {
+		if _, err := TestDocService.ReplyToCommentThread(admin, thread.ID, "Fixed, thanks"); err != nil {
+			t.Fatalf("ReplyToCommentThread returned an error: %v", err)
+		}
+
+		if err := TestDocService.SetCommentThreadResolved(user, thread.ID, true); err != nil {
+			t.Fatalf("SetCommentThreadResolved returned an error: %v", err)
+		}
+
+		open, err := TestDocService.GetOpenCommentThreads(doc.ID)
+		if err != nil {
+			t.Fatalf("GetOpenCommentThreads returned an error: %v", err)
+		}
+
+		if len(open) != 0 {
+			t.Errorf("Expected no open threads, got %d", len(open))
+		}
+
+		threads, err := TestDocService.GetPageCommentThreads(page.ID, true)
+		if err != nil {
+			t.Fatalf("GetPageCommentThreads returned an error: %v", err)
+		}
+
+		if len(threads) != 1 || len(threads[0].Comments) != 2 || !threads[0].Resolved {
+			t.Errorf("Expected one resolved thread with 2 comments, got %+v", threads)
+		}
+
+		if err := TestDocService.SetCommentThreadResolved(admin, thread.ID, false); err != nil {
+			t.Fatalf("SetCommentThreadResolved returned an error: %v", err)
+		}
+	})
+
+	t.Run("Only authors and editors resolve", func(t *testing.T) {
+		other, err := TestDocService.CreateCommentThread(admin, page.ID, "", "Needs a screenshot")
+		if err != nil {
+			t.Fatalf("CreateCommentThread returned an error: %v", err)
+		}
+
+		if err := TestDocService.SetCommentThreadResolved(user, other.ID, true); err == nil {
+			t.Error("Expected a viewer to be refused")
+		}
+
+		open, err := TestDocService.GetOpenCommentThreads(doc.ID)
+		if err != nil {
+			t.Fatalf("GetOpenCommentThreads returned an error: %v", err)
+		}
+
+		if len(open) != 2 {
+			t.Errorf("Expected 2 open threads, got %d", len(open))
+		}
+	})
+
+	t.Run("Purging the page removes its threads", func(t *testing.T) {
+		if err := TestDocService.DeletePage(admin, page.ID); err != nil {
+			t.Fatalf("DeletePage returned an error: %v", err)
+		}
+
+		var item models.TrashItem
+		if err := db.Where("kind = ? AND ref_id = ?", ResourcePage, page.ID).First(&item).Error; err != nil {
+			t.Fatalf("Failed to get trash item: %v", err)
+		}
+
+		if err := TestDocService.PurgeTrashItem(admin, item.ID); err != nil {
+			t.Fatalf("PurgeTrashItem returned an error: %v", err)
+		}
+
+		var threads, comments int64
+		db.Model(&models.CommentThread{}).Where("page_id = ?", page.ID).Count(&threads)
+		db.Model(&models.Comment{}).Where("thread_id = ?", thread.ID).Count(&comments)
+
+		if threads != 0 || comments != 0 {
+			t.Errorf("Expected threads and comments to be gone, got %d and %d", threads, comments)
+		}
+	})
+}
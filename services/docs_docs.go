@@ -44,7 +44,7 @@ func (service *DocService) GetDocumentations() ([]models.Documentation, error) {
 	}).Preload("PageGroups.Pages.Editors", func(db *gorm.DB) *gorm.DB {
 		return db.Select("users.ID", "users.Username", "users.Email", "users.Photo")
 	}).Preload("Pages", func(db *gorm.DB) *gorm.DB {
-		return db.Select("ID", "DocumentationID", "Title", "Slug", "CreatedAt", "UpdatedAt", "AuthorID", "Order", "IsIntroPage").Where("page_group_id IS NULL")
+		return db.Select("ID", "DocumentationID", "Title", "Slug", "CreatedAt", "UpdatedAt", "AuthorID", "Order", "IsIntroPage").Where("page_group_id IS NULL AND translation_of_id IS NULL")
 	}).Preload("Pages.Author", func(db *gorm.DB) *gorm.DB {
 		return db.Select("ID", "Username", "Email", "Photo")
 	}).Preload("Pages.Editors", func(db *gorm.DB) *gorm.DB {
@@ -52,7 +52,7 @@ func (service *DocService) GetDocumentations() ([]models.Documentation, error) {
 	}).Select("ID", "Name", "Description", "CreatedAt", "UpdatedAt", "AuthorID", "Version", "ClonedFrom",
 		"LastEditorID", "Favicon", "MetaImage", "NavImage", "NavImageDark", "CustomCSS", "FooterLabelLinks", "MoreLabelLinks",
 		"URL", "OrganizationName", "LanderDetails", "ProjectName", "BaseURL", "RequireAuth",
-		"GitRepo", "GitEmail", "GitUser", "GitPassword", "GitBranch").
+		"GitRepo", "GitEmail", "GitUser", "GitPassword", "GitBranch", "RobotsTxt", "DefaultLanguage", "Languages").
 		Find(&documentations).Error; err != nil {
 		return nil, fmt.Errorf("failed_to_get_documentations")
 	}
@@ -91,7 +91,7 @@ func (service *DocService) GetDocumentation(id uint) (models.Documentation, erro
 	}).Preload("PageGroups.Pages.Editors", func(db *gorm.DB) *gorm.DB {
 		return db.Select("users.ID", "users.Username", "users.Email", "users.Photo")
 	}).Preload("Pages", func(db *gorm.DB) *gorm.DB {
-		return db.Select("ID", "DocumentationID", "Title", "Slug", "CreatedAt", "UpdatedAt", "AuthorID", "IsIntroPage", "Order").Where("page_group_id IS NULL")
+		return db.Select("ID", "DocumentationID", "Title", "Slug", "CreatedAt", "UpdatedAt", "AuthorID", "IsIntroPage", "Order").Where("page_group_id IS NULL AND translation_of_id IS NULL")
 	}).Preload("Pages.Author", func(db *gorm.DB) *gorm.DB {
 		return db.Select("ID", "Username", "Email", "Photo")
 	}).Preload("Pages.Editors", func(db *gorm.DB) *gorm.DB {
@@ -99,7 +99,7 @@ func (service *DocService) GetDocumentation(id uint) (models.Documentation, erro
 	}).Where("id = ?", id).Select("ID", "Name", "Description", "CreatedAt", "UpdatedAt", "AuthorID", "Version", "LastEditorID", "Favicon",
 		"MetaImage", "NavImage", "NavImageDark", "CustomCSS", "FooterLabelLinks", "MoreLabelLinks", "CopyrightText",
 		"BaseURL", "URL", "OrganizationName", "LanderDetails", "ProjectName", "ClonedFrom", "RequireAuth",
-		"GitRepo", "GitEmail", "GitUser", "GitPassword", "GitBranch").
+		"GitRepo", "GitEmail", "GitUser", "GitPassword", "GitBranch", "RobotsTxt", "DefaultLanguage", "Languages").
 		Find(&documentation).Error; err != nil {
 		return models.Documentation{}, fmt.Errorf("failed_to_get_documentation")
 	}
@@ -207,6 +207,8 @@ func (service *DocService) GetAllVersions(id uint) (string, []string, error) {
 	return latestVersion, versions, nil
 }
 
+const defaultIntroPageContent = `[{"id":"fa01e096-3187-4628-8f1e-77728cee3aa6","type":"heading","props":{"textColor":"default","backgroundColor":"default","textAlignment":"left","level":1},"content":[{"type":"text","text":"Introduction","styles":{}}],"children":[]},{"id":"64a26e8f-7733-4f8a-b3fb-f2c9a770d727","type":"paragraph","props":{"textColor":"default","backgroundColor":"default","textAlignment":"left"},"content":[{"type":"text","text":"Welcome to the ","styles":{}},{"type":"text","text":"introductory page","styles":{"bold":true}},{"type":"text","text":" of this documentation!","styles":{}}],"children":[]},{"id":"90f28c74-6195-4074-8861-35b82b9bfb1c","type":"paragraph","props":{"textColor":"default","backgroundColor":"default","textAlignment":"left"},"content":[],"children":[]}]`
+
 type BucketUploadMetadataFiles struct {
 	BucketFavicon      string `json:"bucketFavicon"`
 	BucketMetaImage    string `json:"bucketMetaImage"`
@@ -214,6 +216,61 @@ type BucketUploadMetadataFiles struct {
 	BucketNavImageDark string `json:"bucketNavImageDark"`
 }
 
+// discardDocumentation deletes a documentation that never came to be, with
+// everything written for it, for good. It's for undoing a creation that
+// failed half way, anything else goes through the trash.
+func discardDocumentation(tx *gorm.DB, docId uint) error {
+	var pageIds []uint
+	if err := tx.Unscoped().Model(&models.Page{}).Where("documentation_id = ?", docId).Pluck("id", &pageIds).Error; err != nil {
+		return fmt.Errorf("failed_to_fetch_pages")
+	}
+
+	if err := purgePages(tx, pageIds); err != nil {
+		return err
+	}
+
+	var groupIds []uint
+	if err := tx.Unscoped().Model(&models.PageGroup{}).Where("documentation_id = ?", docId).Pluck("id", &groupIds).Error; err != nil {
+		return fmt.Errorf("failed_to_fetch_page_groups")
+	}
+
+	if len(groupIds) > 0 {
+		if err := tx.Exec("DELETE FROM pagegroup_editors WHERE page_group_id IN ?", groupIds).Error; err != nil {
+			return fmt.Errorf("failed_to_clear_editors")
+		}
+
+		if err := tx.Unscoped().Where("id IN ?", groupIds).Delete(&models.PageGroup{}).Error; err != nil {
+			return fmt.Errorf("failed_to_delete_page_group")
+		}
+	}
+
+	if err := tx.Where("documentation_id = ?", docId).Delete(&models.SearchDocument{}).Error; err != nil {
+		return fmt.Errorf("failed_to_update_search_index")
+	}
+
+	if err := tx.Exec("DELETE FROM documentation_editors WHERE documentation_id = ?", docId).Error; err != nil {
+		return fmt.Errorf("failed_to_clear_documentation_editors_association")
+	}
+
+	if err := tx.Where("documentation_id = ?", docId).Delete(&models.DocumentationMember{}).Error; err != nil {
+		return fmt.Errorf("failed_to_delete_documentation_members")
+	}
+
+	if err := tx.Where("documentation_id = ?", docId).Delete(&models.DocumentationGroupGrant{}).Error; err != nil {
+		return fmt.Errorf("failed_to_delete_documentation_group_grants")
+	}
+
+	if err := tx.Where("documentation_id = ?", docId).Delete(&models.Redirect{}).Error; err != nil {
+		return fmt.Errorf("failed_to_delete_redirects")
+	}
+
+	if err := tx.Unscoped().Delete(&models.Documentation{}, docId).Error; err != nil {
+		return fmt.Errorf("failed_to_delete_documentation")
+	}
+
+	return nil
+}
+
 func (service *DocService) CreateDocumentation(documentation *models.Documentation, user models.User, bucketUploadedFiles map[string]string) error {
 	db := service.DB
 
@@ -231,34 +288,55 @@ func (service *DocService) CreateDocumentation(documentation *models.Documentati
 		return fmt.Errorf("invalid_base_url")
 	}
 
-	if err := db.Create(documentation).Error; err != nil {
-		return fmt.Errorf("failed_to_create_documentation")
-	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(documentation).Error; err != nil {
+			return fmt.Errorf("failed_to_create_documentation")
+		}
 
-	introPageContent := `[{"id":"fa01e096-3187-4628-8f1e-77728cee3aa6","type":"heading","props":{"textColor":"default","backgroundColor":"default","textAlignment":"left","level":1},"content":[{"type":"text","text":"Introduction","styles":{}}],"children":[]},{"id":"64a26e8f-7733-4f8a-b3fb-f2c9a770d727","type":"paragraph","props":{"textColor":"default","backgroundColor":"default","textAlignment":"left"},"content":[{"type":"text","text":"Welcome to the ","styles":{}},{"type":"text","text":"introductory page","styles":{"bold":true}},{"type":"text","text":" of this documentation!","styles":{}}],"children":[]},{"id":"90f28c74-6195-4074-8861-35b82b9bfb1c","type":"paragraph","props":{"textColor":"default","backgroundColor":"default","textAlignment":"left"},"content":[],"children":[]}]`
+		if err := addDocumentationOwner(tx, documentation.ID, user.ID); err != nil {
+			return err
+		}
 
-	introPage := models.Page{
-		Title:           "Introduction",
-		Slug:            "/",
-		Content:         introPageContent,
-		DocumentationID: documentation.ID,
-		AuthorID:        user.ID,
-		Author:          user,
-		Editors:         []models.User{user},
-		LastEditorID:    &user.ID,
-		Order:           utils.UintPtr(0),
-		IsIntroPage:     true,
-	}
+		if err := indexDocumentation(tx, *documentation); err != nil {
+			return err
+		}
+
+		introPage := models.Page{
+			Title:           "Introduction",
+			Slug:            "/",
+			Content:         defaultIntroPageContent,
+			DocumentationID: documentation.ID,
+			AuthorID:        user.ID,
+			Author:          user,
+			Editors:         []models.User{user},
+			LastEditorID:    &user.ID,
+			Order:           utils.UintPtr(0),
+			IsIntroPage:     true,
+		}
 
-	if err := db.Create(&introPage).Error; err != nil {
-		return fmt.Errorf("failed_to_create_documentation_intro_page")
+		if err := tx.Create(&introPage).Error; err != nil {
+			return fmt.Errorf("failed_to_create_documentation_intro_page")
+		}
+
+		if _, err := publishPageContent(tx, introPage, user.ID); err != nil {
+			return err
+		}
+
+		return indexPage(tx, introPage)
+	})
+	if err != nil {
+		return err
 	}
 
-	err := service.InitRsPress(documentation.ID)
+	err = initRsPress(service, documentation.ID)
 	if err != nil {
 		logger.Error("failed_to_init_rspress", zap.Error(err))
-		db.Delete(&documentation)
-		db.Delete(&introPage)
+
+		if err := db.Transaction(func(tx *gorm.DB) error {
+			return discardDocumentation(tx, documentation.ID)
+		}); err != nil {
+			logger.Error("failed_to_discard_documentation", zap.Uint("doc_id", documentation.ID), zap.Error(err))
+		}
 
 		return fmt.Errorf("failed_to_init_rspress")
 	}
@@ -336,6 +414,8 @@ func (service *DocService) CreateDocumentation(documentation *models.Documentati
 		return err
 	}
 
+	service.audit("documentation.create", AuditEntityDocumentation, documentation.ID, nil, documentationAuditSummary(*documentation))
+
 	err = service.AddBuildTrigger(documentation.ID, false)
 	if err != nil {
 		logger.Error("failed_to_add_build_trigger: " + err.Error())
@@ -370,9 +450,14 @@ func (service *DocService) EditDocumentation(
 	gitUser string,
 	gitPassword string,
 	gitEmail string,
+	robotsTxt string,
 
 	bucketUploadedFiles map[string]string,
 ) error {
+	if err := service.requireDocumentationRole(user.ID, id, RoleMaintainer); err != nil {
+		return err
+	}
+
 	tx := service.DB.Begin()
 	if !utils.IsBaseURLValid(baseURL) {
 		return fmt.Errorf("invalid_base_url")
@@ -401,6 +486,7 @@ func (service *DocService) EditDocumentation(
 		doc.GitUser = gitUser
 		doc.GitPassword = gitPassword
 		doc.GitEmail = gitEmail
+		doc.RobotsTxt = robotsTxt
 		if isTarget && version != "" {
 			doc.Version = version
 		}
@@ -419,7 +505,7 @@ func (service *DocService) EditDocumentation(
 		if err := tx.Save(doc).Error; err != nil {
 			return fmt.Errorf("failed_to_update_documentation")
 		}
-		return nil
+		return indexDocumentation(tx, *doc)
 	}
 
 	docPath := utils.GetDocPathByID(id, config.ParsedConfig)
@@ -507,6 +593,8 @@ func (service *DocService) EditDocumentation(
 		return fmt.Errorf("documentation_not_found")
 	}
 
+	before := documentationAuditSummary(targetDoc)
+
 	if err := updateDoc(&targetDoc, true); err != nil {
 		tx.Rollback()
 		return err
@@ -557,6 +645,8 @@ func (service *DocService) EditDocumentation(
 		return fmt.Errorf("failed_to_commit_changes")
 	}
 
+	service.audit("documentation.edit", AuditEntityDocumentation, targetDoc.ID, before, documentationAuditSummary(targetDoc))
+
 	rootID := id
 	for {
 		var doc models.Documentation
@@ -576,12 +666,16 @@ func (service *DocService) EditDocumentation(
 	return nil
 }
 
-func (service *DocService) DeleteDocumentation(id uint) error {
+func (service *DocService) DeleteDocumentation(user models.User, id uint) error {
 	doc, err := service.GetDocumentation(id)
 	if err != nil {
 		return fmt.Errorf("failed_to_get_documentation")
 	}
 
+	if err := service.requireDocumentationRole(user.ID, id, RoleOwner); err != nil {
+		return err
+	}
+
 	var count int64
 	if err := service.DB.Model(&models.Documentation{}).Where("cloned_from = ?", id).Count(&count).Error; err != nil {
 		return fmt.Errorf("failed_to_check_cloned_documentations")
@@ -592,78 +686,80 @@ func (service *DocService) DeleteDocumentation(id uint) error {
 		return fmt.Errorf("failed_to_get_parent_id")
 	}
 
-	if count > 0 {
-		if parentId == id {
-			return fmt.Errorf("root_parent_cant_be_deleted_as_it_has_children")
+	if count > 0 && parentId == id {
+		return fmt.Errorf("root_parent_cant_be_deleted_as_it_has_children")
+	}
+
+	// The documentation goes to the trash with its pages and page groups,
+	// those already in the trash keep their own trash items. The versions
+	// cloned from it move up to its parent, the trash item remembers them so
+	// a restore puts them back.
+	err = service.DB.Transaction(func(tx *gorm.DB) error {
+		item, err := service.createTrashItem(tx, ResourceDocumentation, id, id, doc.Name+" "+doc.Version)
+		if err != nil {
+			return err
 		}
-		var childDocs []models.Documentation
-		if err := service.DB.Where("cloned_from = ?", id).Find(&childDocs).Error; err != nil {
+
+		var childIds []uint
+		if err := tx.Model(&models.Documentation{}).Where("cloned_from = ?", id).Pluck("id", &childIds).Error; err != nil {
 			return fmt.Errorf("failed_to_get_child_documentations")
 		}
-		for _, childDoc := range childDocs {
-			childDoc.ClonedFrom = doc.ClonedFrom
-			if err := service.DB.Save(&childDoc).Error; err != nil {
+
+		if len(childIds) > 0 {
+			item.ReparentedVersions = make(map[uint]uint, len(childIds))
+			for _, childId := range childIds {
+				item.ReparentedVersions[childId] = id
+			}
+
+			if err := tx.Model(&item).Select("ReparentedVersions").Updates(&item).Error; err != nil {
+				return fmt.Errorf("failed_to_create_trash_item")
+			}
+
+			if err := tx.Model(&models.Documentation{}).Where("id IN ?", childIds).UpdateColumn("cloned_from", doc.ClonedFrom).Error; err != nil {
 				return fmt.Errorf("failed_to_update_child_documentation")
 			}
 		}
-	}
 
-	tx := service.DB.Begin()
-	if tx.Error != nil {
-		return fmt.Errorf("failed_to_start_transaction")
-	}
+		if err := trashPages(tx, item.ID, "documentation_id = ?", id); err != nil {
+			return err
+		}
 
-	var pageGroups []models.PageGroup
-	if err := tx.Where("documentation_id = ?", id).Find(&pageGroups).Error; err != nil {
-		tx.Rollback()
-		return fmt.Errorf("failed_to_fetch_page_groups: %v", err)
-	}
+		if err := tx.Model(&models.PageGroup{}).Where("documentation_id = ?", id).UpdateColumn("trash_item_id", item.ID).Error; err != nil {
+			return fmt.Errorf("failed_to_delete_page_groups: %v", err)
+		}
 
-	for _, pg := range pageGroups {
-		if err := tx.Model(&pg).Association("Editors").Clear(); err != nil {
-			tx.Rollback()
-			return fmt.Errorf("failed_to_clear_pagegroup_editors_association: %v", err)
+		if err := tx.Where("trash_item_id = ?", item.ID).Delete(&models.PageGroup{}).Error; err != nil {
+			return fmt.Errorf("failed_to_delete_page_groups: %v", err)
 		}
-	}
 
-	var pages []models.Page
-	if err := tx.Where("documentation_id = ?", id).Find(&pages).Error; err != nil {
-		tx.Rollback()
-		return fmt.Errorf("failed_to_fetch_pages: %v", err)
-	}
+		if err := tx.Model(&models.Documentation{}).Where("id = ?", id).UpdateColumn("trash_item_id", item.ID).Error; err != nil {
+			return fmt.Errorf("failed_to_delete_documentation: %v", err)
+		}
 
-	for _, page := range pages {
-		if err := tx.Model(&page).Association("Editors").Clear(); err != nil {
-			tx.Rollback()
-			return fmt.Errorf("failed_to_clear_page_editors_association: %v", err)
+		if err := tx.Delete(&models.Documentation{}, id).Error; err != nil {
+			return fmt.Errorf("failed_to_delete_documentation: %v", err)
 		}
-	}
 
-	if err := tx.Where("documentation_id = ?", id).Delete(&models.PageGroup{}).Error; err != nil {
-		tx.Rollback()
-		return fmt.Errorf("failed_to_delete_page_groups: %v", err)
-	}
+		if err := tx.Where("documentation_id = ?", id).Delete(&models.SearchDocument{}).Error; err != nil {
+			return fmt.Errorf("failed_to_update_search_index: %v", err)
+		}
 
-	if err := tx.Where("documentation_id = ?", id).Delete(&models.Page{}).Error; err != nil {
-		tx.Rollback()
-		return fmt.Errorf("failed_to_delete_pages: %v", err)
-	}
+		if err := tx.Where("documentation_id = ?", id).Delete(&models.EditLock{}).Error; err != nil {
+			return fmt.Errorf("failed_to_delete_locks: %v", err)
+		}
 
-	if err := tx.Model(&models.Documentation{ID: id}).Association("Editors").Clear(); err != nil {
-		tx.Rollback()
-		return fmt.Errorf("failed_to_clear_documentation_editors_association: %v", err)
-	}
+		return nil
+	})
 
-	if err := tx.Delete(&models.Documentation{ID: id}).Error; err != nil {
-		tx.Rollback()
-		return fmt.Errorf("failed_to_delete_documentation: %v", err)
+	if err != nil {
+		return err
 	}
 
-	if err := tx.Commit().Error; err != nil {
-		return fmt.Errorf("failed_to_commit_changes: %v", err)
-	}
+	service.audit("documentation.delete", AuditEntityDocumentation, id, documentationAuditSummary(doc), nil)
 
-	err = service.AddBuildTrigger(parentId, true)
+	// Deleting the root takes the site down, deleting a version rebuilds the
+	// site without it.
+	err = service.AddBuildTrigger(parentId, parentId == id)
 	if err != nil {
 		return fmt.Errorf("failed_to_add_build_trigger: %v", err)
 	}
@@ -673,7 +769,8 @@ func (service *DocService) DeleteDocumentation(id uint) error {
 
 func (service *DocService) CreateDocumentationVersion(originalDocId uint, newVersion string) error {
 	var originalDoc models.Documentation
-	if err := service.DB.Preload("PageGroups.Pages").Preload("Pages").First(&originalDoc, originalDocId).Error; err != nil {
+	// Translations aren't cloned, a new version starts out with them missing.
+	if err := service.DB.Preload("PageGroups.Pages").Preload("Pages", "translation_of_id IS NULL").First(&originalDoc, originalDocId).Error; err != nil {
 		return fmt.Errorf("documentation_not_found")
 	}
 
@@ -709,6 +806,7 @@ func (service *DocService) CreateDocumentationVersion(originalDocId uint, newVer
 		GitUser:          originalDoc.GitUser,
 		GitPassword:      originalDoc.GitPassword,
 		GitEmail:         originalDoc.GitEmail,
+		RobotsTxt:        originalDoc.RobotsTxt,
 	}
 
 	err = service.DB.Transaction(func(tx *gorm.DB) error {
@@ -741,6 +839,8 @@ func (service *DocService) CreateDocumentationVersion(originalDocId uint, newVer
 				AuthorID:        pg.AuthorID,
 				Name:            pg.Name,
 				Order:           pg.Order,
+				PublishedName:   pg.PublishedName,
+				PublishedAt:     pg.PublishedAt,
 			}
 			if err := tx.Create(&newPG).Error; err != nil {
 				return fmt.Errorf("failed_to_create_page_group")
@@ -771,6 +871,12 @@ func (service *DocService) CreateDocumentationVersion(originalDocId uint, newVer
 						return fmt.Errorf("failed_to_add_editor")
 					}
 				}
+				if err := copyPublishedRevision(tx, page, newPage); err != nil {
+					return err
+				}
+				if err := createPageRevision(tx, newPage, page.AuthorID, nil); err != nil {
+					return err
+				}
 			}
 		}
 
@@ -836,15 +942,29 @@ func (service *DocService) CreateDocumentationVersion(originalDocId uint, newVer
 						return fmt.Errorf("failed to append editor to page without group: %w", err)
 					}
 				}
+				if err := copyPublishedRevision(tx, page, newPage); err != nil {
+					return err
+				}
+				if err := createPageRevision(tx, newPage, page.AuthorID, nil); err != nil {
+					return err
+				}
 			}
 		}
 
-		return nil
+		return indexDocumentationContent(tx, newDoc.ID)
 	})
 	if err != nil {
 		return err
 	}
 
+	service.audit("documentation.version", AuditEntityDocumentation, newDoc.ID, nil, documentationAuditSummary(newDoc))
+
+	service.emitWebhookEvent(newDoc.ID, WebhookEventVersionCreated, map[string]interface{}{
+		"id":         newDoc.ID,
+		"version":    newDoc.Version,
+		"clonedFrom": originalDocId,
+	})
+
 	err = service.AddBuildTrigger(originalDocId, false)
 	if err != nil {
 		return fmt.Errorf("failed_to_add_build_trigger")
@@ -891,21 +1011,7 @@ func (service *DocService) GetParentDocId(docID uint) (uint, error) {
 }
 
 func (service *DocService) GetRootParentID(docID uint) (uint, error) {
-	for {
-		var doc models.Documentation
-		if err := service.DB.Unscoped().Select("id", "cloned_from").First(&doc, docID).Error; err != nil {
-			if errors.Is(err, gorm.ErrRecordNotFound) {
-				return 0, fmt.Errorf("documentation with ID %d not found", docID)
-			}
-			return 0, fmt.Errorf("failed to fetch documentation with ID %d: %w", docID, err)
-		}
-
-		if doc.ClonedFrom == nil || *doc.ClonedFrom == 0 || *doc.ClonedFrom == doc.ID {
-			return doc.ID, nil
-		}
-
-		docID = *doc.ClonedFrom
-	}
+	return rootDocumentationID(service.DB, docID)
 }
 
 func (service *DocService) GetLatestVersion(rootParentID uint) (*models.Documentation, error) {
@@ -991,6 +1097,8 @@ func (service *DocService) BulkReorderPageOrPageGroup(pageOrder []struct {
 		return err
 	}
 
+	service.audit("documentation.reorder", AuditEntityDocumentation, docId, nil, map[string]interface{}{"items": pageOrder})
+
 	parentDocId, err := service.GetRootParentID(docId)
 	if err != nil {
 		return fmt.Errorf("failed to get parent doc ID: %w", err)
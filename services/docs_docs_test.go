@@ -0,0 +1,73 @@
+package services
+
+import (
+	"testing"
+
+	"git.difuse.io/Difuse/kalmia/db/models"
+	"gorm.io/gorm"
+)
+
+func TestDiscardDocumentation(t *testing.T) {
+	if TestDocService == nil {
+		t.Fatal("TestDocService is nil")
+	}
+
+	db := TestDocService.DB
+
+	var admin models.User
+	if err := db.Where("username = ?", "admin").First(&admin).Error; err != nil {
+		t.Fatalf("Failed to get admin: %v", err)
+	}
+
+	doc := models.Documentation{Name: "Half made", Version: "1.0.0", BaseURL: "/half-made", AuthorID: admin.ID}
+	if err := db.Create(&doc).Error; err != nil {
+		t.Fatalf("Failed to create documentation: %v", err)
+	}
+
+	if err := addDocumentationOwner(db, doc.ID, admin.ID); err != nil {
+		t.Fatalf("addDocumentationOwner returned an error: %v", err)
+	}
+
+	if err := indexDocumentation(db, doc); err != nil {
+		t.Fatalf("indexDocumentation returned an error: %v", err)
+	}
+
+	page := models.Page{DocumentationID: doc.ID, Title: "Introduction", Slug: "/", Content: "[]", AuthorID: admin.ID, Editors: []models.User{admin}}
+	if err := db.Create(&page).Error; err != nil {
+		t.Fatalf("Failed to create page: %v", err)
+	}
+
+	if _, err := publishPageContent(db, page, admin.ID); err != nil {
+		t.Fatalf("publishPageContent returned an error: %v", err)
+	}
+
+	if err := indexPage(db, page); err != nil {
+		t.Fatalf("indexPage returned an error: %v", err)
+	}
+
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		return discardDocumentation(tx, doc.ID)
+	}); err != nil {
+		t.Fatalf("discardDocumentation returned an error: %v", err)
+	}
+
+	left := map[string]*gorm.DB{
+		"documentations": db.Unscoped().Model(&models.Documentation{}).Where("id = ?", doc.ID),
+		"pages":          db.Unscoped().Model(&models.Page{}).Where("documentation_id = ?", doc.ID),
+		"revisions":      db.Model(&models.PageRevision{}).Where("page_id = ?", page.ID),
+		"members":        db.Model(&models.DocumentationMember{}).Where("documentation_id = ?", doc.ID),
+		"search":         db.Model(&models.SearchDocument{}).Where("documentation_id = ?", doc.ID),
+		"page editors":   db.Table("page_editors").Where("page_id = ?", page.ID),
+	}
+
+	for name, query := range left {
+		var count int64
+		if err := query.Count(&count).Error; err != nil {
+			t.Fatalf("Failed to count %s: %v", name, err)
+		}
+
+		if count != 0 {
+			t.Errorf("Expected no %s left, got %d", name, count)
+		}
+	}
+}
@@ -0,0 +1,557 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+
+	"git.difuse.io/Difuse/kalmia/config"
+	"git.difuse.io/Difuse/kalmia/db/models"
+	"git.difuse.io/Difuse/kalmia/logger"
+	"git.difuse.io/Difuse/kalmia/utils"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// DocumentationExportFormatVersion is bumped whenever the archive layout
+// changes in a way older importers can't read.
+const DocumentationExportFormatVersion = 1
+
+const documentationManifestName = "manifest.json"
+
+type ExportedDocumentation struct {
+	Name             string `json:"name"`
+	Version          string `json:"version"`
+	URL              string `json:"url,omitempty"`
+	OrganizationName string `json:"organizationName,omitempty"`
+	ProjectName      string `json:"projectName,omitempty"`
+	LanderDetails    string `json:"landerDetails,omitempty"`
+	BaseURL          string `json:"baseURL"`
+	Description      string `json:"description,omitempty"`
+	Favicon          string `json:"favicon,omitempty"`
+	MetaImage        string `json:"metaImage,omitempty"`
+	NavImage         string `json:"navImage,omitempty"`
+	NavImageDark     string `json:"navImageDark,omitempty"`
+	CustomCSS        string `json:"customCSS,omitempty"`
+	FooterLabelLinks string `json:"footerLabelLinks,omitempty"`
+	MoreLabelLinks   string `json:"moreLabelLinks,omitempty"`
+	CopyrightText    string `json:"copyrightText,omitempty"`
+	RequireAuth      bool   `json:"requireAuth"`
+	GitRepo          string `json:"gitRepo,omitempty"`
+	GitBranch        string `json:"gitBranch,omitempty"`
+}
+
+type ExportedPageGroup struct {
+	ID       uint   `json:"id"`
+	ParentID *uint  `json:"parentId,omitempty"`
+	Name     string `json:"name"`
+	Order    *uint  `json:"order,omitempty"`
+}
+
+type ExportedPage struct {
+	ID           uint   `json:"id"`
+	PageGroupID  *uint  `json:"pageGroupId,omitempty"`
+	Title        string `json:"title"`
+	Slug         string `json:"slug"`
+	Order        *uint  `json:"order,omitempty"`
+	IsIntroPage  bool   `json:"isIntroPage"`
+	ContentFile  string `json:"contentFile"`
+	MarkdownFile string `json:"markdownFile,omitempty"`
+}
+
+type ExportedAsset struct {
+	URL         string `json:"url"`
+	File        string `json:"file"`
+	ContentType string `json:"contentType,omitempty"`
+}
+
+type DocumentationManifest struct {
+	FormatVersion int                   `json:"formatVersion"`
+	ExportedAt    time.Time             `json:"exportedAt"`
+	Documentation ExportedDocumentation `json:"documentation"`
+	PageGroups    []ExportedPageGroup   `json:"pageGroups"`
+	Pages         []ExportedPage        `json:"pages"`
+	Assets        []ExportedAsset       `json:"assets"`
+}
+
+var assetURLPattern = regexp.MustCompile(`(?:https?://|/kal-api/file/get/)[^\s"'()<>\\]+`)
+
+// referencedAssets returns the uploaded assets referenced by any of the given
+// strings, keyed by URL.
+func referencedAssets(cfg *config.Config, sources ...string) map[string]string {
+	assets := make(map[string]string)
+
+	for _, source := range sources {
+		for _, match := range assetURLPattern.FindAllString(source, -1) {
+			if key, ok := UploadedAssetKey(match, cfg); ok {
+				assets[match] = key
+			}
+		}
+	}
+
+	return assets
+}
+
+func (service *DocService) ExportDocumentation(docId uint, format string, cfg *config.Config) (string, string, error) {
+	if format == "" {
+		format = utils.ArchiveFormatZip
+	}
+
+	if format != utils.ArchiveFormatZip && format != utils.ArchiveFormatTarGz {
+		return "", "", fmt.Errorf("invalid_archive_format")
+	}
+
+	var doc models.Documentation
+	if err := service.DB.First(&doc, docId).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", "", fmt.Errorf("documentation_not_found")
+		}
+		return "", "", fmt.Errorf("failed_to_get_documentation")
+	}
+
+	var groups []models.PageGroup
+	if err := service.DB.Where("documentation_id = ?", docId).Order("id ASC").Find(&groups).Error; err != nil {
+		return "", "", fmt.Errorf("failed_to_get_page_groups")
+	}
+
+	var pages []models.Page
+	if err := service.DB.Where("documentation_id = ? AND translation_of_id IS NULL", docId).Order("id ASC").Find(&pages).Error; err != nil {
+		return "", "", fmt.Errorf("failed_to_get_pages")
+	}
+
+	manifest := DocumentationManifest{
+		FormatVersion: DocumentationExportFormatVersion,
+		ExportedAt:    time.Now().UTC(),
+		Documentation: ExportedDocumentation{
+			Name:             doc.Name,
+			Version:          doc.Version,
+			URL:              doc.URL,
+			OrganizationName: doc.OrganizationName,
+			ProjectName:      doc.ProjectName,
+			LanderDetails:    doc.LanderDetails,
+			BaseURL:          doc.BaseURL,
+			Description:      doc.Description,
+			Favicon:          doc.Favicon,
+			MetaImage:        doc.MetaImage,
+			NavImage:         doc.NavImage,
+			NavImageDark:     doc.NavImageDark,
+			CustomCSS:        doc.CustomCSS,
+			FooterLabelLinks: doc.FooterLabelLinks,
+			MoreLabelLinks:   doc.MoreLabelLinks,
+			CopyrightText:    doc.CopyrightText,
+			RequireAuth:      doc.RequireAuth,
+			GitRepo:          doc.GitRepo,
+			GitBranch:        doc.GitBranch,
+		},
+		PageGroups: make([]ExportedPageGroup, 0, len(groups)),
+		Pages:      make([]ExportedPage, 0, len(pages)),
+		Assets:     make([]ExportedAsset, 0),
+	}
+
+	tempFile, err := os.CreateTemp("", "documentation-export-*."+format)
+	if err != nil {
+		return "", "", fmt.Errorf("failed_to_create_temp_file")
+	}
+
+	archive, err := utils.NewArchiveWriter(tempFile, format)
+	if err != nil {
+		tempFile.Close()
+		os.Remove(tempFile.Name())
+		return "", "", fmt.Errorf("invalid_archive_format")
+	}
+
+	fail := func(message string) (string, string, error) {
+		archive.Close()
+		tempFile.Close()
+		os.Remove(tempFile.Name())
+		return "", "", fmt.Errorf("%s", message)
+	}
+
+	sources := []string{doc.LanderDetails, doc.Favicon, doc.MetaImage, doc.NavImage, doc.NavImageDark}
+
+	for _, group := range groups {
+		manifest.PageGroups = append(manifest.PageGroups, ExportedPageGroup{
+			ID:       group.ID,
+			ParentID: group.ParentID,
+			Name:     group.Name,
+			Order:    group.Order,
+		})
+	}
+
+	for _, page := range pages {
+		exported := ExportedPage{
+			ID:          page.ID,
+			PageGroupID: page.PageGroupID,
+			Title:       page.Title,
+			Slug:        page.Slug,
+			Order:       page.Order,
+			IsIntroPage: page.IsIntroPage,
+			ContentFile: fmt.Sprintf("pages/%d.json", page.ID),
+		}
+
+		if err := archive.WriteFile(exported.ContentFile, []byte(page.Content)); err != nil {
+			return fail("failed_to_write_archive")
+		}
+
+		markdown, err := service.CraftPage(page.ID, page.Title, page.Slug, page.Content)
+		if err != nil {
+			logger.Warn("Failed to render page markdown for export", zap.Uint("page_id", page.ID), zap.Error(err))
+		} else {
+			exported.MarkdownFile = fmt.Sprintf("pages/%d.md", page.ID)
+			if err := archive.WriteFile(exported.MarkdownFile, []byte(markdown)); err != nil {
+				return fail("failed_to_write_archive")
+			}
+		}
+
+		manifest.Pages = append(manifest.Pages, exported)
+		sources = append(sources, page.Content)
+	}
+
+	assets := referencedAssets(cfg, sources...)
+
+	urls := make([]string, 0, len(assets))
+	for assetURL := range assets {
+		urls = append(urls, assetURL)
+	}
+	sort.Strings(urls)
+
+	for _, assetURL := range urls {
+		key := assets[assetURL]
+
+		data, contentType, err := DownloadFromS3Storage(key, cfg)
+		if err != nil {
+			logger.Warn("Skipping asset missing from storage", zap.String("key", key), zap.Error(err))
+			continue
+		}
+
+		asset := ExportedAsset{
+			URL:         assetURL,
+			File:        "assets/" + key,
+			ContentType: contentType,
+		}
+
+		if err := archive.WriteFile(asset.File, data); err != nil {
+			return fail("failed_to_write_archive")
+		}
+
+		manifest.Assets = append(manifest.Assets, asset)
+	}
+
+	manifestJSON, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fail("failed_to_marshal_manifest")
+	}
+
+	if err := archive.WriteFile(documentationManifestName, manifestJSON); err != nil {
+		return fail("failed_to_write_archive")
+	}
+
+	if err := archive.Close(); err != nil {
+		tempFile.Close()
+		os.Remove(tempFile.Name())
+		return "", "", fmt.Errorf("failed_to_write_archive")
+	}
+
+	if err := tempFile.Close(); err != nil {
+		os.Remove(tempFile.Name())
+		return "", "", fmt.Errorf("failed_to_write_archive")
+	}
+
+	filename := fmt.Sprintf("%s-%s.%s", utils.StringToFileString(doc.Name), utils.StringToFileString(doc.Version), format)
+
+	return tempFile.Name(), filename, nil
+}
+
+func readArchiveFile(root, name string) ([]byte, error) {
+	path, err := utils.SafeJoin(root, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return os.ReadFile(path)
+}
+
+func sortExportedPageGroups(groups []ExportedPageGroup) ([]ExportedPageGroup, error) {
+	known := make(map[uint]bool, len(groups))
+	for _, group := range groups {
+		known[group.ID] = true
+	}
+
+	sorted := make([]ExportedPageGroup, 0, len(groups))
+	placed := make(map[uint]bool, len(groups))
+
+	for len(sorted) < len(groups) {
+		progressed := false
+
+		for _, group := range groups {
+			if placed[group.ID] {
+				continue
+			}
+
+			if group.ParentID != nil && known[*group.ParentID] && !placed[*group.ParentID] {
+				continue
+			}
+
+			sorted = append(sorted, group)
+			placed[group.ID] = true
+			progressed = true
+		}
+
+		if !progressed {
+			return nil, fmt.Errorf("invalid_page_group_tree")
+		}
+	}
+
+	return sorted, nil
+}
+
+// ImportDocumentationArchive recreates a documentation from an archive made by
+// ExportDocumentation. Name and baseURL override the ones in the manifest so
+// the archive can be imported next to the documentation it came from.
+//
+// The archive's assets are uploaded before the documentation is created, so
+// they're deleted again if the import doesn't go through, and so is the
+// documentation if its site can't be set up.
+func (service *DocService) ImportDocumentationArchive(user models.User, archivePath string, name string, baseURL string, cfg *config.Config) (uint, error) {
+	tempDir, err := os.MkdirTemp("", "documentation-import-")
+	if err != nil {
+		return 0, fmt.Errorf("failed_to_create_temp_dir")
+	}
+
+	defer os.RemoveAll(tempDir)
+
+	if err := utils.ExtractArchive(archivePath, tempDir, (cfg.MaxFileSize<<20)*10); err != nil {
+		logger.Error("Failed to extract documentation archive", zap.Error(err))
+		return 0, fmt.Errorf("failed_to_extract_archive")
+	}
+
+	manifestJSON, err := os.ReadFile(filepath.Join(tempDir, documentationManifestName))
+	if err != nil {
+		return 0, fmt.Errorf("manifest_not_found")
+	}
+
+	var manifest DocumentationManifest
+	if err := json.Unmarshal(manifestJSON, &manifest); err != nil {
+		return 0, fmt.Errorf("invalid_manifest")
+	}
+
+	if manifest.FormatVersion < 1 || manifest.FormatVersion > DocumentationExportFormatVersion {
+		return 0, fmt.Errorf("unsupported_archive_version")
+	}
+
+	groups, err := sortExportedPageGroups(manifest.PageGroups)
+	if err != nil {
+		return 0, err
+	}
+
+	var uploaded []string
+	imported := false
+
+	defer func() {
+		if imported {
+			return
+		}
+
+		for _, key := range uploaded {
+			if err := DeleteFromS3Storage(key, cfg); err != nil {
+				logger.Error("Failed to delete imported asset", zap.String("key", key), zap.Error(err))
+			}
+		}
+	}()
+
+	replacements := make(map[string]string)
+	for _, asset := range manifest.Assets {
+		data, err := readArchiveFile(tempDir, asset.File)
+		if err != nil {
+			return 0, fmt.Errorf("asset_not_found_in_archive")
+		}
+
+		newURL, err := UploadToS3Storage(bytes.NewReader(data), filepath.Base(asset.File), asset.ContentType, cfg)
+		if err != nil {
+			logger.Error("Failed to upload imported asset", zap.String("file", asset.File), zap.Error(err))
+			return 0, fmt.Errorf("failed_to_upload_asset")
+		}
+
+		if key, ok := UploadedAssetKey(newURL, cfg); ok {
+			uploaded = append(uploaded, key)
+		}
+
+		replacements[asset.URL] = newURL
+	}
+
+	rewrite := func(input string) string {
+		return utils.ReplaceMany(input, replacements)
+	}
+
+	exported := manifest.Documentation
+	if name != "" {
+		exported.Name = name
+	}
+	if baseURL != "" {
+		exported.BaseURL = baseURL
+	}
+
+	if exported.Name == "" || exported.Version == "" {
+		return 0, fmt.Errorf("invalid_manifest")
+	}
+
+	documentation := models.Documentation{
+		Name:             exported.Name,
+		Version:          exported.Version,
+		URL:              exported.URL,
+		OrganizationName: exported.OrganizationName,
+		ProjectName:      exported.ProjectName,
+		LanderDetails:    rewrite(exported.LanderDetails),
+		BaseURL:          exported.BaseURL,
+		Description:      exported.Description,
+		Favicon:          rewrite(exported.Favicon),
+		MetaImage:        rewrite(exported.MetaImage),
+		NavImage:         rewrite(exported.NavImage),
+		NavImageDark:     rewrite(exported.NavImageDark),
+		CustomCSS:        exported.CustomCSS,
+		FooterLabelLinks: exported.FooterLabelLinks,
+		MoreLabelLinks:   exported.MoreLabelLinks,
+		CopyrightText:    exported.CopyrightText,
+		RequireAuth:      exported.RequireAuth,
+		GitRepo:          exported.GitRepo,
+		GitBranch:        exported.GitBranch,
+		AuthorID:         user.ID,
+		Editors:          []models.User{user},
+		LastEditorID:     &user.ID,
+	}
+
+	err = service.DB.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&models.Documentation{}).Where("name = ?", documentation.Name).Count(&count).Error; err != nil {
+			return fmt.Errorf("failed_to_check_documentation_name")
+		}
+
+		if count > 0 {
+			return fmt.Errorf("documentation_name_already_exists")
+		}
+
+		if !utils.IsBaseURLValid(documentation.BaseURL) {
+			return fmt.Errorf("invalid_base_url")
+		}
+
+		if err := tx.Create(&documentation).Error; err != nil {
+			return fmt.Errorf("failed_to_create_documentation")
+		}
+
+		if err := addDocumentationOwner(tx, documentation.ID, user.ID); err != nil {
+			return err
+		}
+
+		if err := indexDocumentation(tx, documentation); err != nil {
+			return err
+		}
+
+		groupIds := make(map[uint]uint, len(groups))
+
+		for _, exportedGroup := range groups {
+			group := models.PageGroup{
+				DocumentationID: documentation.ID,
+				Name:            exportedGroup.Name,
+				Order:           exportedGroup.Order,
+				AuthorID:        user.ID,
+				Editors:         []models.User{user},
+				LastEditorID:    &user.ID,
+			}
+
+			if exportedGroup.ParentID != nil {
+				if parentId, ok := groupIds[*exportedGroup.ParentID]; ok {
+					group.ParentID = &parentId
+				}
+			}
+
+			if err := tx.Create(&group).Error; err != nil {
+				return fmt.Errorf("failed_to_create_page_group")
+			}
+
+			if err := indexPageGroup(tx, group); err != nil {
+				return err
+			}
+
+			groupIds[exportedGroup.ID] = group.ID
+		}
+
+		for _, exportedPage := range manifest.Pages {
+			content, err := readArchiveFile(tempDir, exportedPage.ContentFile)
+			if err != nil {
+				return fmt.Errorf("page_content_not_found_in_archive")
+			}
+
+			page := models.Page{
+				DocumentationID: documentation.ID,
+				Title:           exportedPage.Title,
+				Slug:            exportedPage.Slug,
+				Content:         rewrite(string(content)),
+				Order:           exportedPage.Order,
+				IsIntroPage:     exportedPage.IsIntroPage,
+				AuthorID:        user.ID,
+				Editors:         []models.User{user},
+				LastEditorID:    &user.ID,
+			}
+
+			if exportedPage.PageGroupID != nil {
+				if groupId, ok := groupIds[*exportedPage.PageGroupID]; ok {
+					page.PageGroupID = &groupId
+				}
+			}
+
+			if err := tx.Create(&page).Error; err != nil {
+				return fmt.Errorf("failed_to_create_page")
+			}
+
+			if err := createPageRevision(tx, page, user.ID, nil); err != nil {
+				return err
+			}
+
+			if _, err := publishPageContent(tx, page, user.ID); err != nil {
+				return err
+			}
+
+			if err := indexPage(tx, page); err != nil {
+				return err
+			}
+		}
+
+		if _, err := publishPageGroups(tx, "documentation_id = ?", documentation.ID); err != nil {
+			return err
+		}
+
+		return service.checkPublishLinks(tx, documentation.ID, nil)
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	if err := initRsPress(service, documentation.ID); err != nil {
+		logger.Error("failed_to_init_rspress", zap.Error(err))
+
+		if err := service.DB.Transaction(func(tx *gorm.DB) error {
+			return discardDocumentation(tx, documentation.ID)
+		}); err != nil {
+			logger.Error("failed_to_discard_documentation", zap.Uint("doc_id", documentation.ID), zap.Error(err))
+		}
+
+		return 0, fmt.Errorf("failed_to_init_rspress")
+	}
+
+	imported = true
+
+	service.audit("documentation.import", AuditEntityDocumentation, documentation.ID, nil, documentationAuditSummary(documentation))
+
+	if err := service.triggerRootBuild(documentation.ID); err != nil {
+		return documentation.ID, fmt.Errorf("failed_to_update_write_build")
+	}
+
+	return documentation.ID, nil
+}
@@ -0,0 +1,187 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"git.difuse.io/Difuse/kalmia/db/models"
+	"git.difuse.io/Difuse/kalmia/utils"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+func TestExportImportDocumentation(t *testing.T) {
+	if TestDocService == nil {
+		t.Fatal("TestDocService is nil")
+	}
+
+	db := TestDocService.DB
+
+	originalInitRsPress := initRsPress
+	initRsPress = func(service *DocService, docId uint) error { return nil }
+	defer func() {
+		initRsPress = originalInitRsPress
+	}()
+
+	doc := models.Documentation{Name: "Export Source", Version: "2.0.0", BaseURL: "/export-source", Description: "Exported", AuthorID: 1}
+	if err := db.Create(&doc).Error; err != nil {
+		t.Fatalf("Failed to create documentation: %v", err)
+	}
+
+	parent := models.PageGroup{DocumentationID: doc.ID, Name: "Guide", AuthorID: 1, Order: utils.UintPtr(1)}
+	if err := db.Create(&parent).Error; err != nil {
+		t.Fatalf("Failed to create page group: %v", err)
+	}
+
+	child := models.PageGroup{DocumentationID: doc.ID, Name: "Advanced", ParentID: &parent.ID, AuthorID: 1}
+	if err := db.Create(&child).Error; err != nil {
+		t.Fatalf("Failed to create page group: %v", err)
+	}
+
+	content := `[{"id":"a","type":"paragraph","props":{},"content":[{"type":"text","text":"Deep content","styles":{}}],"children":[]}]`
+	pages := []models.Page{
+		{DocumentationID: doc.ID, Title: "Intro", Slug: "/", Content: content, AuthorID: 1, IsIntroPage: true},
+		{DocumentationID: doc.ID, Title: "Deep", Slug: "/deep", Content: content, AuthorID: 1, PageGroupID: &child.ID},
+	}
+	for i := range pages {
+		if err := db.Create(&pages[i]).Error; err != nil {
+			t.Fatalf("Failed to create page: %v", err)
+		}
+	}
+
+	for _, format := range []string{utils.ArchiveFormatZip, utils.ArchiveFormatTarGz} {
+		path, filename, err := TestDocService.ExportDocumentation(doc.ID, format, TestConfig)
+		if err != nil {
+			t.Fatalf("ExportDocumentation(%s) returned an error: %v", format, err)
+		}
+
+		if filename != "export-source-2-0-0."+format {
+			t.Errorf("Unexpected export filename: %s", filename)
+		}
+
+		newId, err := TestDocService.ImportDocumentationArchive(models.User{ID: 1}, path, "Imported "+format, "/imported-"+utils.StringToFileString(format), TestConfig)
+		os.Remove(path)
+
+		if err != nil {
+			t.Fatalf("ImportDocumentationArchive(%s) returned an error: %v", format, err)
+		}
+
+		if newId == 0 || newId == doc.ID {
+			t.Fatalf("Expected a new documentation, got %d", newId)
+		}
+
+		var imported models.Documentation
+		if err := db.First(&imported, newId).Error; err != nil {
+			t.Fatalf("Failed to load imported documentation: %v", err)
+		}
+
+		if imported.Version != "2.0.0" || imported.Description != "Exported" {
+			t.Errorf("Unexpected imported settings: %+v", imported)
+		}
+
+		var groups []models.PageGroup
+		db.Where("documentation_id = ?", newId).Find(&groups)
+		if len(groups) != 2 {
+			t.Fatalf("Expected 2 page groups, got %d", len(groups))
+		}
+
+		var deep models.Page
+		if err := db.Where("documentation_id = ? AND slug = ?", newId, "/deep").First(&deep).Error; err != nil {
+			t.Fatalf("Imported page not found: %v", err)
+		}
+
+		if deep.Content != content || deep.PageGroupID == nil || *deep.PageGroupID == child.ID {
+			t.Fatalf("Page was not remapped: %+v", deep)
+		}
+
+		var revision models.PageRevision
+		if err := db.Where("page_id = ?", deep.ID).First(&revision).Error; err != nil || revision.Content != content {
+			t.Errorf("Expected the imported page to have a revision, got %+v (%v)", revision, err)
+		}
+
+		var deepGroup models.PageGroup
+		db.First(&deepGroup, *deep.PageGroupID)
+		if deepGroup.Name != "Advanced" || deepGroup.DocumentationID != newId || deepGroup.ParentID == nil {
+			t.Fatalf("Page group was not remapped: %+v", deepGroup)
+		}
+
+		var deepParent models.PageGroup
+		db.First(&deepParent, *deepGroup.ParentID)
+		if deepParent.Name != "Guide" || deepParent.DocumentationID != newId {
+			t.Errorf("Parent page group was not remapped: %+v", deepParent)
+		}
+	}
+
+	if _, _, err := TestDocService.ExportDocumentation(doc.ID, "rar", TestConfig); err == nil || err.Error() != "invalid_archive_format" {
+		t.Errorf("Expected invalid_archive_format, got %v", err)
+	}
+}
+
+func TestImportDocumentationCleansUp(t *testing.T) {
+	if TestDocService == nil {
+		t.Fatal("TestDocService is nil")
+	}
+
+	db := TestDocService.DB
+
+	storage := &fakeAssetStorage{keys: map[string]bool{"upload-source.png": true}}
+
+	originalNewS3Client := newS3Client
+	newS3Client = func(sess *session.Session) s3iface.S3API {
+		return storage
+	}
+	originalInitRsPress := initRsPress
+	defer func() {
+		newS3Client = originalNewS3Client
+		initRsPress = originalInitRsPress
+	}()
+
+	cfg := *TestConfig
+	cfg.S3.PublicUrlFormat = "https://cdn.example.com/%s/%s"
+
+	doc := models.Documentation{Name: "Import Cleanup", Version: "1.0.0", BaseURL: "/import-cleanup", AuthorID: 1}
+	if err := db.Create(&doc).Error; err != nil {
+		t.Fatalf("Failed to create documentation: %v", err)
+	}
+
+	page := models.Page{DocumentationID: doc.ID, Title: "Intro", Slug: "/", AuthorID: 1, IsIntroPage: true, Content: linkContent(t, nil, []string{"https://cdn.example.com/uploads/upload-source.png"})}
+	if err := db.Create(&page).Error; err != nil {
+		t.Fatalf("Failed to create page: %v", err)
+	}
+
+	path, _, err := TestDocService.ExportDocumentation(doc.ID, utils.ArchiveFormatZip, &cfg)
+	if err != nil {
+		t.Fatalf("ExportDocumentation returned an error: %v", err)
+	}
+	defer os.Remove(path)
+
+	t.Run("Deletes the assets when the import fails", func(t *testing.T) {
+		if _, err := TestDocService.ImportDocumentationArchive(models.User{ID: 1}, path, doc.Name, "/import-cleanup-copy", &cfg); err == nil || err.Error() != "documentation_name_already_exists" {
+			t.Fatalf("Expected documentation_name_already_exists, got %v", err)
+		}
+
+		if storage.uploads == 0 || len(storage.keys) != 1 {
+			t.Errorf("Expected the uploaded assets to be deleted, got %d uploads and %v", storage.uploads, storage.keys)
+		}
+	})
+
+	t.Run("Deletes the documentation and assets when the site can't be set up", func(t *testing.T) {
+		initRsPress = func(service *DocService, docId uint) error { return fmt.Errorf("npm_unavailable") }
+
+		docId, err := TestDocService.ImportDocumentationArchive(models.User{ID: 1}, path, "Import Cleanup Copy", "/import-cleanup-copy", &cfg)
+		if err == nil || err.Error() != "failed_to_init_rspress" || docId != 0 {
+			t.Fatalf("Expected failed_to_init_rspress, got %d, %v", docId, err)
+		}
+
+		if storage.uploads == 0 || len(storage.keys) != 1 {
+			t.Errorf("Expected the uploaded assets to be deleted, got %d uploads and %v", storage.uploads, storage.keys)
+		}
+
+		var count int64
+		db.Unscoped().Model(&models.Documentation{}).Where("name = ?", "Import Cleanup Copy").Count(&count)
+		if count != 0 {
+			t.Errorf("Expected the documentation to be discarded, got %d", count)
+		}
+	})
+}
@@ -0,0 +1,463 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"git.difuse.io/Difuse/kalmia/db/models"
+	"gorm.io/gorm"
+)
+
+const (
+	TranslationStatusMissing  = "missing"
+	TranslationStatusOutdated = "outdated"
+	TranslationStatusUpToDate = "up_to_date"
+)
+
+const defaultLanguage = "en"
+
+var languageCodePattern = regexp.MustCompile(`^[a-z]{2,3}(-[A-Za-z0-9]{2,8})*$`)
+
+// languageLabels are the names the language switcher shows, languages not in
+// here are shown by their code.
+var languageLabels = map[string]string{
+	"de": "Deutsch",
+	"en": "English",
+	"es": "Español",
+	"fr": "Français",
+	"it": "Italiano",
+	"ja": "日本語",
+	"ko": "한국어",
+	"nl": "Nederlands",
+	"pt": "Português",
+	"ru": "Русский",
+	"zh": "中文",
+}
+
+func languageLabel(language string) string {
+	if label, ok := languageLabels[language]; ok {
+		return label
+	}
+
+	return language
+}
+
+// documentationLanguages returns the language a documentation is written in
+// and the ones it's translated into.
+func documentationLanguages(doc models.Documentation) (string, []string) {
+	language := doc.DefaultLanguage
+	if language == "" {
+		language = defaultLanguage
+	}
+
+	translated := make([]string, 0)
+	if doc.Languages != "" {
+		if err := json.Unmarshal([]byte(doc.Languages), &translated); err != nil {
+			return language, nil
+		}
+	}
+
+	return language, translated
+}
+
+// siteLanguages returns the languages of the site a documentation is on.
+// They're set on the root documentation and shared by all its versions.
+func (service *DocService) siteLanguages(docId uint) (string, []string, error) {
+	rootId, err := rootDocumentationID(service.DB, docId)
+	if err != nil {
+		return "", nil, fmt.Errorf("documentation_not_found")
+	}
+
+	var root models.Documentation
+	if err := service.DB.Select("id", "default_language", "languages").First(&root, rootId).Error; err != nil {
+		return "", nil, fmt.Errorf("documentation_not_found")
+	}
+
+	language, translated := documentationLanguages(root)
+	return language, translated, nil
+}
+
+func isTranslatedLanguage(translated []string, language string) bool {
+	for _, candidate := range translated {
+		if candidate == language {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SetDocumentationLanguages sets the language a documentation is written in
+// and the languages it's translated into, for all its versions.
+func (service *DocService) SetDocumentationLanguages(user models.User, id uint, language string, languages []string) error {
+	if err := service.requireDocumentationRole(user.ID, id, RoleMaintainer); err != nil {
+		return err
+	}
+
+	rootId, err := rootDocumentationID(service.DB, id)
+	if err != nil {
+		return fmt.Errorf("documentation_not_found")
+	}
+
+	var root models.Documentation
+	if err := service.DB.First(&root, rootId).Error; err != nil {
+		return fmt.Errorf("documentation_not_found")
+	}
+
+	if language == "" {
+		language = defaultLanguage
+	}
+
+	if !languageCodePattern.MatchString(language) {
+		return fmt.Errorf("invalid_language")
+	}
+
+	translated := make([]string, 0, len(languages))
+	for _, candidate := range languages {
+		if !languageCodePattern.MatchString(candidate) {
+			return fmt.Errorf("invalid_language")
+		}
+
+		if candidate != language && !isTranslatedLanguage(translated, candidate) {
+			translated = append(translated, candidate)
+		}
+	}
+
+	encoded, err := json.Marshal(translated)
+	if err != nil {
+		return fmt.Errorf("invalid_language")
+	}
+
+	beforeLanguage, beforeTranslated := documentationLanguages(root)
+
+	if err := service.DB.Model(&root).UpdateColumns(map[string]interface{}{
+		"default_language": language,
+		"languages":        string(encoded),
+	}).Error; err != nil {
+		return fmt.Errorf("failed_to_update_documentation")
+	}
+
+	service.audit("documentation.languages", AuditEntityDocumentation, root.ID,
+		map[string]interface{}{"defaultLanguage": beforeLanguage, "languages": beforeTranslated},
+		map[string]interface{}{"defaultLanguage": language, "languages": translated},
+	)
+
+	if err := service.AddBuildTrigger(root.ID, false); err != nil {
+		return fmt.Errorf("failed_to_update_write_build")
+	}
+
+	return nil
+}
+
+// localizedPages puts the published translation of every page in its place,
+// where and in the order the page is. Pages without one stay as they are, so
+// every language of a site has the same pages.
+func (service *DocService) localizedPages(pages []models.Page, language string) ([]models.Page, error) {
+	if language == "" || len(pages) == 0 {
+		return pages, nil
+	}
+
+	ids := make([]uint, 0, len(pages))
+	for _, page := range pages {
+		ids = append(ids, page.ID)
+	}
+
+	var translations []models.Page
+	if err := service.DB.Where("translation_of_id IN ? AND language = ?", ids, language).Find(&translations).Error; err != nil {
+		return nil, fmt.Errorf("failed_to_get_translations")
+	}
+
+	translations, err := service.publishedPages(translations)
+	if err != nil {
+		return nil, err
+	}
+
+	bySource := make(map[uint]models.Page, len(translations))
+	for _, translation := range translations {
+		bySource[*translation.TranslationOfID] = translation
+	}
+
+	localized := make([]models.Page, 0, len(pages))
+	for _, page := range pages {
+		translation, ok := bySource[page.ID]
+		if !ok {
+			localized = append(localized, page)
+			continue
+		}
+
+		translation.PageGroupID = page.PageGroupID
+		translation.Order = page.Order
+		translation.IsIntroPage = page.IsIntroPage
+		localized = append(localized, translation)
+	}
+
+	return localized, nil
+}
+
+type TranslationStatus struct {
+	PageID            uint   `json:"pageId"`
+	Title             string `json:"title"`
+	Language          string `json:"language"`
+	Status            string `json:"status"`
+	TranslationID     *uint  `json:"translationId"`
+	SourceVersion     uint   `json:"sourceVersion"`
+	TranslatedVersion uint   `json:"translatedVersion"`
+}
+
+// translationStatus tells whether a translation is there and caught up with
+// the version of its source it was last edited against.
+func translationStatus(source models.Page, translation *models.Page) string {
+	if translation == nil {
+		return TranslationStatusMissing
+	}
+
+	if translation.SourceVersion < source.Version {
+		return TranslationStatusOutdated
+	}
+
+	return TranslationStatusUpToDate
+}
+
+// GetTranslationStatus lists how far every page of a documentation version is
+// translated into each of its languages.
+func (service *DocService) GetTranslationStatus(docId uint) ([]TranslationStatus, error) {
+	_, languages, err := service.siteLanguages(docId)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]TranslationStatus, 0)
+	if len(languages) == 0 {
+		return statuses, nil
+	}
+
+	var sources []models.Page
+	if err := service.DB.Where("documentation_id = ? AND translation_of_id IS NULL", docId).Order("id ASC").Find(&sources).Error; err != nil {
+		return nil, fmt.Errorf("failed_to_get_pages")
+	}
+
+	if len(sources) == 0 {
+		return statuses, nil
+	}
+
+	ids := make([]uint, 0, len(sources))
+	for _, source := range sources {
+		ids = append(ids, source.ID)
+	}
+
+	var translations []models.Page
+	if err := service.DB.Where("translation_of_id IN ?", ids).Find(&translations).Error; err != nil {
+		return nil, fmt.Errorf("failed_to_get_translations")
+	}
+
+	byLanguage := make(map[uint]map[string]models.Page)
+	for _, translation := range translations {
+		if byLanguage[*translation.TranslationOfID] == nil {
+			byLanguage[*translation.TranslationOfID] = make(map[string]models.Page)
+		}
+		byLanguage[*translation.TranslationOfID][translation.Language] = translation
+	}
+
+	for _, source := range sources {
+		for _, language := range languages {
+			status := TranslationStatus{
+				PageID:        source.ID,
+				Title:         source.Title,
+				Language:      language,
+				SourceVersion: source.Version,
+			}
+
+			translation, ok := byLanguage[source.ID][language]
+			if ok {
+				status.Status = translationStatus(source, &translation)
+				status.TranslationID = &translation.ID
+				status.TranslatedVersion = translation.SourceVersion
+			} else {
+				status.Status = translationStatus(source, nil)
+			}
+
+			statuses = append(statuses, status)
+		}
+	}
+
+	return statuses, nil
+}
+
+// CreatePageTranslation adds a translation of a page into one of the
+// languages of its documentation. It's a draft like any new page, and is up
+// to date with the page as it is now. Without a title or content it starts
+// out as a copy of the page.
+func (service *DocService) CreatePageTranslation(user models.User, id uint, language, title, content string) (models.Page, error) {
+	var source models.Page
+	if err := service.DB.First(&source, id).Error; err != nil {
+		return models.Page{}, fmt.Errorf("page_not_found")
+	}
+
+	if source.TranslationOfID != nil {
+		return models.Page{}, fmt.Errorf("page_is_a_translation")
+	}
+
+	if err := service.requireDocumentationRole(user.ID, source.DocumentationID, RoleEditor); err != nil {
+		return models.Page{}, err
+	}
+
+	_, languages, err := service.siteLanguages(source.DocumentationID)
+	if err != nil {
+		return models.Page{}, err
+	}
+
+	if !isTranslatedLanguage(languages, language) {
+		return models.Page{}, fmt.Errorf("invalid_language")
+	}
+
+	if strings.TrimSpace(title) == "" {
+		title = source.Title
+	}
+	if content == "" {
+		content = source.Content
+	}
+
+	translation := models.Page{
+		DocumentationID: source.DocumentationID,
+		AuthorID:        user.ID,
+		Title:           title,
+		Content:         content,
+		LastEditorID:    &user.ID,
+		Language:        language,
+		TranslationOfID: &source.ID,
+		SourceVersion:   source.Version,
+	}
+
+	err = service.DB.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Unscoped().Model(&models.Page{}).Where("translation_of_id = ? AND language = ?", source.ID, language).Count(&count).Error; err != nil {
+			return fmt.Errorf("failed_to_get_translations")
+		}
+		if count > 0 {
+			return fmt.Errorf("translation_already_exists")
+		}
+
+		slug, err := availablePageSlug(tx, source.DocumentationID, "/"+language+source.Slug, 0)
+		if err != nil {
+			return err
+		}
+		translation.Slug = slug
+
+		if err := tx.Create(&translation).Error; err != nil {
+			return fmt.Errorf("failed_to_create_page")
+		}
+
+		if err := tx.Model(&translation).Association("Editors").Append(&user); err != nil {
+			return fmt.Errorf("failed_to_add_editor")
+		}
+
+		if err := createPageRevision(tx, translation, user.ID, nil); err != nil {
+			return err
+		}
+
+		return indexPage(tx, translation)
+	})
+	if err != nil {
+		return models.Page{}, err
+	}
+
+	service.audit("page.translate", AuditEntityPage, translation.ID, nil, pageAuditSummary(translation))
+	service.emitWebhookEvent(source.DocumentationID, WebhookEventPageCreated, newWebhookPage(translation, source.DocumentationID, user.ID))
+
+	return translation, nil
+}
+
+// syncTranslation marks a translation as caught up with its source, which
+// is what editing it means.
+func syncTranslation(tx *gorm.DB, page *models.Page) error {
+	var versions []uint
+	if err := tx.Unscoped().Model(&models.Page{}).Where("id = ?", *page.TranslationOfID).Pluck("version", &versions).Error; err != nil {
+		return fmt.Errorf("failed_to_get_page")
+	}
+
+	if len(versions) > 0 {
+		page.SourceVersion = versions[0]
+	}
+
+	return nil
+}
+
+// moveTranslations takes the translations of a page along to the
+// documentation it moved to.
+func moveTranslations(tx *gorm.DB, pageId uint, docId uint) error {
+	var translations []models.Page
+	if err := tx.Where("translation_of_id = ? AND documentation_id <> ?", pageId, docId).Find(&translations).Error; err != nil {
+		return fmt.Errorf("failed_to_get_translations")
+	}
+
+	for _, translation := range translations {
+		slug, err := availablePageSlug(tx, docId, translation.Slug, translation.ID)
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Model(&models.Page{}).Where("id = ?", translation.ID).UpdateColumns(map[string]interface{}{
+			"documentation_id": docId,
+			"slug":             slug,
+		}).Error; err != nil {
+			return fmt.Errorf("failed_to_move_page")
+		}
+
+		if err := movePageRecords(tx, translation.ID, docId); err != nil {
+			return err
+		}
+
+		translation.DocumentationID = docId
+		translation.Slug = slug
+		if err := indexPage(tx, translation); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type RsPressLocale struct {
+	Lang        string `json:"lang"`
+	Label       string `json:"label"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+}
+
+// rsPressLocales returns the lang and locales options of the RsPress config,
+// which give a translated site its language switcher. Sites that aren't
+// translated get neither.
+func (service *DocService) rsPressLocales(rootId uint, doc models.Documentation) (string, error) {
+	language, languages, err := service.siteLanguages(rootId)
+	if err != nil {
+		return "", err
+	}
+
+	if len(languages) == 0 {
+		return "", nil
+	}
+
+	locales := make([]RsPressLocale, 0, len(languages)+1)
+	for _, lang := range append([]string{language}, languages...) {
+		locales = append(locales, RsPressLocale{
+			Lang:        lang,
+			Label:       languageLabel(lang),
+			Title:       doc.Name,
+			Description: doc.Description,
+		})
+	}
+
+	localesJSON, err := json.Marshal(locales)
+	if err != nil {
+		return "", err
+	}
+
+	langJSON, err := json.Marshal(language)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("lang: %s,\n  locales: %s,", langJSON, localesJSON), nil
+}
@@ -0,0 +1,188 @@
+package services
+
+import (
+	"strings"
+	"testing"
+
+	"git.difuse.io/Difuse/kalmia/db/models"
+)
+
+func TestTranslations(t *testing.T) {
+	if TestDocService == nil {
+		t.Fatal("TestDocService is nil")
+	}
+
+	db := TestDocService.DB
+
+	var admin models.User
+	if err := db.Where("username = ?", "admin").First(&admin).Error; err != nil {
+		t.Fatalf("Failed to get admin: %v", err)
+	}
+
+	doc := models.Documentation{Name: "Translated", Version: "1.0.0", BaseURL: "/translated", URL: "https://docs.example.com", AuthorID: admin.ID}
+	if err := db.Create(&doc).Error; err != nil {
+		t.Fatalf("Failed to create documentation: %v", err)
+	}
+
+	group := models.PageGroup{DocumentationID: doc.ID, Name: "Basics", AuthorID: admin.ID}
+	if _, err := TestDocService.CreatePageGroup(&group); err != nil {
+		t.Fatalf("CreatePageGroup returned an error: %v", err)
+	}
+	if err := TestDocService.PublishPageGroup(admin, group.ID); err != nil {
+		t.Fatalf("PublishPageGroup returned an error: %v", err)
+	}
+
+	source := models.Page{DocumentationID: doc.ID, PageGroupID: &group.ID, Title: "Setup", Slug: "/setup", Content: "[]", AuthorID: admin.ID}
+	if err := TestDocService.CreatePage(&source); err != nil {
+		t.Fatalf("CreatePage returned an error: %v", err)
+	}
+	if err := TestDocService.PublishPage(admin, source.ID); err != nil {
+		t.Fatalf("PublishPage returned an error: %v", err)
+	}
+
+	status := func(t *testing.T, expected string) {
+		t.Helper()
+
+		statuses, err := TestDocService.GetTranslationStatus(doc.ID)
+		if err != nil {
+			t.Fatalf("GetTranslationStatus returned an error: %v", err)
+		}
+		if len(statuses) != 1 || statuses[0].Language != "de" || statuses[0].Status != expected {
+			t.Errorf("Expected the page to be %s in de, got %+v", expected, statuses)
+		}
+	}
+
+	t.Run("Languages are validated", func(t *testing.T) {
+		if err := TestDocService.SetDocumentationLanguages(admin, doc.ID, "en", []string{"not a language"}); err == nil || err.Error() != "invalid_language" {
+			t.Errorf("Expected invalid_language, got %v", err)
+		}
+
+		if err := TestDocService.SetDocumentationLanguages(admin, doc.ID, "en", []string{"de", "en", "de"}); err != nil {
+			t.Fatalf("SetDocumentationLanguages returned an error: %v", err)
+		}
+
+		language, languages, err := TestDocService.siteLanguages(doc.ID)
+		if err != nil {
+			t.Fatalf("siteLanguages returned an error: %v", err)
+		}
+		if language != "en" || len(languages) != 1 || languages[0] != "de" {
+			t.Errorf("Expected en translated into de, got %s %v", language, languages)
+		}
+	})
+
+	t.Run("Untranslated pages are missing", func(t *testing.T) {
+		status(t, TranslationStatusMissing)
+	})
+
+	var translation models.Page
+
+	t.Run("Pages can be translated once per language", func(t *testing.T) {
+		if _, err := TestDocService.CreatePageTranslation(admin, source.ID, "fr", "Installation", "[]"); err == nil || err.Error() != "invalid_language" {
+			t.Errorf("Expected invalid_language, got %v", err)
+		}
+
+		var err error
+		translation, err = TestDocService.CreatePageTranslation(admin, source.ID, "de", "Einrichtung", "[]")
+		if err != nil {
+			t.Fatalf("CreatePageTranslation returned an error: %v", err)
+		}
+		if translation.TranslationOfID == nil || *translation.TranslationOfID != source.ID || translation.PageGroupID != nil || translation.Slug != "/de/setup" {
+			t.Errorf("Expected an ungrouped translation of the page, got %+v", translation)
+		}
+
+		if _, err := TestDocService.CreatePageTranslation(admin, source.ID, "de", "Einrichtung", "[]"); err == nil || err.Error() != "translation_already_exists" {
+			t.Errorf("Expected translation_already_exists, got %v", err)
+		}
+		if _, err := TestDocService.CreatePageTranslation(admin, translation.ID, "de", "Einrichtung", "[]"); err == nil || err.Error() != "page_is_a_translation" {
+			t.Errorf("Expected page_is_a_translation, got %v", err)
+		}
+
+		status(t, TranslationStatusUpToDate)
+	})
+
+	t.Run("Translations are kept out of the page tree", func(t *testing.T) {
+		fetched, err := TestDocService.GetDocumentation(doc.ID)
+		if err != nil {
+			t.Fatalf("GetDocumentation returned an error: %v", err)
+		}
+
+		for _, page := range fetched.Pages {
+			if page.ID == translation.ID {
+				t.Errorf("Expected the translation to not be a root page")
+			}
+		}
+	})
+
+	t.Run("Editing the page outdates its translation", func(t *testing.T) {
+		if err := TestDocService.EditPage(admin, source.ID, "Setup", "/setup", `[{"type":"paragraph"}]`, nil, &group.ID, nil); err != nil {
+			t.Fatalf("EditPage returned an error: %v", err)
+		}
+		status(t, TranslationStatusOutdated)
+
+		if err := TestDocService.EditPage(admin, translation.ID, "Einrichtung", "/de/setup", `[{"type":"paragraph"}]`, nil, &group.ID, nil); err != nil {
+			t.Fatalf("EditPage returned an error: %v", err)
+		}
+		status(t, TranslationStatusUpToDate)
+
+		var edited models.Page
+		if err := db.First(&edited, translation.ID).Error; err != nil {
+			t.Fatalf("Failed to get translation: %v", err)
+		}
+		if edited.PageGroupID != nil {
+			t.Errorf("Expected the translation to stay out of groups")
+		}
+	})
+
+	t.Run("Sites show the published translation in place of the page", func(t *testing.T) {
+		localized, err := TestDocService.localizedPages([]models.Page{source}, "de")
+		if err != nil {
+			t.Fatalf("localizedPages returned an error: %v", err)
+		}
+		if len(localized) != 1 || localized[0].ID != source.ID {
+			t.Errorf("Expected the page while the translation is a draft, got %+v", localized)
+		}
+
+		if err := TestDocService.PublishPage(admin, translation.ID); err != nil {
+			t.Fatalf("PublishPage returned an error: %v", err)
+		}
+
+		localized, err = TestDocService.localizedPages([]models.Page{source}, "de")
+		if err != nil {
+			t.Fatalf("localizedPages returned an error: %v", err)
+		}
+		if len(localized) != 1 || localized[0].Title != "Einrichtung" || localized[0].PageGroupID == nil || *localized[0].PageGroupID != group.ID {
+			t.Errorf("Expected the translation where the page is, got %+v", localized)
+		}
+
+		site, err := TestDocService.newLinkSite(doc.ID, 0)
+		if err != nil {
+			t.Fatalf("newLinkSite returned an error: %v", err)
+		}
+		sitemap, err := site.sitemap()
+		if err != nil {
+			t.Fatalf("sitemap returned an error: %v", err)
+		}
+		if !strings.Contains(string(sitemap), "<loc>https://docs.example.com/translated/de/guides/basics/einrichtung</loc>") ||
+			!strings.Contains(string(sitemap), `hreflang="de"`) {
+			t.Errorf("Expected the translation in the sitemap, got %s", sitemap)
+		}
+	})
+
+	t.Run("Translated sites get a language switcher", func(t *testing.T) {
+		locales, err := TestDocService.rsPressLocales(doc.ID, doc)
+		if err != nil {
+			t.Fatalf("rsPressLocales returned an error: %v", err)
+		}
+		if !strings.HasPrefix(locales, `lang: "en",`) || !strings.Contains(locales, `"lang":"de","label":"Deutsch"`) {
+			t.Errorf("Expected en and de locales, got %s", locales)
+		}
+
+		if err := TestDocService.SetDocumentationLanguages(admin, doc.ID, "en", nil); err != nil {
+			t.Fatalf("SetDocumentationLanguages returned an error: %v", err)
+		}
+
+		if locales, err := TestDocService.rsPressLocales(doc.ID, doc); err != nil || locales != "" {
+			t.Errorf("Expected no locales, got %q (%v)", locales, err)
+		}
+	})
+}
@@ -0,0 +1,505 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"git.difuse.io/Difuse/kalmia/config"
+	"git.difuse.io/Difuse/kalmia/db/models"
+	"git.difuse.io/Difuse/kalmia/utils"
+	"gorm.io/gorm"
+)
+
+const (
+	LinkIssuePageNotFound     = "page_not_found"
+	LinkIssueAssetNotFound    = "asset_not_found"
+	LinkIssueAssetCheckFailed = "asset_check_failed"
+)
+
+// errBrokenLinks stops a publish, or a build, that would put broken links
+// on the site. Retrying doesn't fix it, the content has to change.
+var errBrokenLinks = errors.New("broken_links")
+
+type LinkIssue struct {
+	PageID    uint   `json:"pageId"`
+	PageTitle string `json:"pageTitle"`
+	BlockID   string `json:"blockId"`
+	URL       string `json:"url"`
+	Asset     bool   `json:"asset"`
+	Reason    string `json:"reason"`
+}
+
+// LinkCheckReport is what the link checker found in one documentation
+// version.
+type LinkCheckReport struct {
+	DocumentationID uint        `json:"documentationId"`
+	Version         string      `json:"version"`
+	Pages           int         `json:"pages"`
+	Links           int         `json:"links"`
+	Assets          int         `json:"assets"`
+	Issues          []LinkIssue `json:"issues"`
+}
+
+// linkSiteVersion is one version of a site as the build writes it: the pages
+// on it, the paths they're at and the directories of its page groups.
+type linkSiteVersion struct {
+	documentationID uint
+	version         string
+	pages           []models.Page
+	pagePaths       map[uint]string
+	localizedPaths  map[string]map[uint]string
+	paths           map[string]bool
+	groupDirs       map[uint]string
+}
+
+// pagePath is the path writePagesToDirectory writes a page to. Pages in a
+// group that isn't built aren't on the site.
+func (version *linkSiteVersion) pagePath(page models.Page) (string, bool) {
+	dir := "/guides"
+	if page.PageGroupID != nil {
+		groupDir, ok := version.groupDirs[*page.PageGroupID]
+		if !ok {
+			return "", false
+		}
+		dir = groupDir
+	}
+
+	file := utils.StringToFileString(page.Title)
+	if page.IsIntroPage {
+		file = "index"
+	}
+
+	return dir + "/" + file, true
+}
+
+// addPage puts a page on the version.
+func (version *linkSiteVersion) addPage(page models.Page) bool {
+	pagePath, ok := version.pagePath(page)
+	if !ok {
+		return false
+	}
+
+	version.pages = append(version.pages, page)
+	version.pagePaths[page.ID] = pagePath
+	version.paths[pagePath] = true
+
+	// The page's slug is the path _meta.json lists it under.
+	if slug := strings.TrimSuffix(page.Slug, "/"); slug != "" {
+		version.paths[slug] = true
+		version.paths["/guides"+slug] = true
+	}
+
+	return true
+}
+
+// addLocalizedPage puts the page in a language a translated site has in
+// place of a page, see localizedPages, on the version.
+func (version *linkSiteVersion) addLocalizedPage(language string, sourceId uint, page models.Page) {
+	pagePath, ok := version.pagePath(page)
+	if !ok {
+		return
+	}
+
+	if version.localizedPaths[language] == nil {
+		version.localizedPaths[language] = make(map[uint]string)
+		for _, base := range []string{"", "/guides", "/guides/index"} {
+			version.paths["/"+language+base] = true
+		}
+	}
+
+	version.localizedPaths[language][sourceId] = "/" + language + pagePath
+	version.paths["/"+language+pagePath] = true
+}
+
+// linkSite is a documentation with all its versions as the build writes
+// them, what internal links are resolved against.
+type linkSite struct {
+	baseURL   string
+	siteURL   string
+	latest    string
+	language  string
+	languages []string
+	versions  []*linkSiteVersion
+	byName    map[string]*linkSiteVersion
+}
+
+// newLinkSite lays out the site of a root documentation from what is
+// published. The version draftsOf is laid out as if everything in it were
+// published, as publishing all of it does.
+func (service *DocService) newLinkSite(rootId uint, draftsOf uint) (*linkSite, error) {
+	root, err := service.GetDocumentation(rootId)
+	if err != nil {
+		return nil, err
+	}
+
+	latest, _, err := service.GetAllVersions(rootId)
+	if err != nil {
+		return nil, fmt.Errorf("documentation_not_found")
+	}
+
+	versionInfos, err := service.buildVersionTree(rootId)
+	if err != nil {
+		return nil, fmt.Errorf("documentation_not_found")
+	}
+
+	language, languages := documentationLanguages(root)
+
+	site := &linkSite{
+		baseURL:   strings.TrimSuffix(root.BaseURL, "/"),
+		siteURL:   strings.TrimSuffix(root.URL, "/"),
+		latest:    latest,
+		language:  language,
+		languages: languages,
+		byName:    make(map[string]*linkSiteVersion),
+	}
+
+	for _, info := range versionInfos {
+		drafts := info.DocId == draftsOf
+
+		var groups []models.PageGroup
+		if err := service.DB.Where("documentation_id = ?", info.DocId).Find(&groups).Error; err != nil {
+			return nil, fmt.Errorf("failed_to_get_page_groups")
+		}
+		if !drafts {
+			groups = publishedPageGroups(groups)
+		}
+
+		var pages []models.Page
+		if err := service.DB.Where("documentation_id = ? AND translation_of_id IS NULL", info.DocId).Order("id ASC").Find(&pages).Error; err != nil {
+			return nil, fmt.Errorf("failed_to_get_pages")
+		}
+		if !drafts {
+			if pages, err = service.publishedPages(pages); err != nil {
+				return nil, err
+			}
+		}
+
+		version := &linkSiteVersion{
+			documentationID: info.DocId,
+			version:         info.Version,
+			pagePaths:       make(map[uint]string),
+			localizedPaths:  make(map[string]map[uint]string),
+			paths:           map[string]bool{"/": true, "/guides": true, "/guides/index": true},
+			groupDirs:       pageGroupDirs(groups),
+		}
+
+		for _, page := range pages {
+			version.addPage(page)
+		}
+
+		for _, language := range languages {
+			localized, err := service.localizedPages(version.pages, language)
+			if err != nil {
+				return nil, err
+			}
+
+			for i, page := range localized {
+				version.addLocalizedPage(language, version.pages[i].ID, page)
+			}
+		}
+
+		site.versions = append(site.versions, version)
+		site.byName[info.Version] = version
+	}
+
+	return site, nil
+}
+
+// pageGroupDirs returns the directory every page group is written to,
+// leaving out the groups under one that isn't among groups.
+func pageGroupDirs(groups []models.PageGroup) map[uint]string {
+	byId := make(map[uint]models.PageGroup, len(groups))
+	for _, group := range groups {
+		byId[group.ID] = group
+	}
+
+	dirs := make(map[uint]string, len(groups))
+
+	var resolve func(id uint, depth int) (string, bool)
+	resolve = func(id uint, depth int) (string, bool) {
+		if dir, ok := dirs[id]; ok {
+			return dir, true
+		}
+
+		group, ok := byId[id]
+		if !ok || depth > len(groups) {
+			return "", false
+		}
+
+		parent := "/guides"
+		if group.ParentID != nil {
+			if parent, ok = resolve(*group.ParentID, depth+1); !ok {
+				return "", false
+			}
+		}
+
+		dirs[id] = parent + "/" + utils.StringToFileString(group.Name)
+		return dirs[id], true
+	}
+
+	for _, group := range groups {
+		resolve(group.ID, 0)
+	}
+
+	return dirs
+}
+
+func (site *linkSite) version(docId uint) *linkSiteVersion {
+	for _, version := range site.versions {
+		if version.documentationID == docId {
+			return version
+		}
+	}
+
+	return nil
+}
+
+// resolve tells whether an internal link points at a page of the site.
+// Links that leave the site, or point at files it can't know about, aren't
+// internal.
+func (site *linkSite) resolve(href string) (internal bool, found bool) {
+	if site.siteURL != "" && strings.HasPrefix(href, site.siteURL+"/") {
+		href = strings.TrimPrefix(href, site.siteURL)
+	}
+
+	if !strings.HasPrefix(href, "/") || strings.HasPrefix(href, "//") {
+		return false, false
+	}
+
+	if i := strings.IndexAny(href, "?#"); i >= 0 {
+		href = href[:i]
+	}
+
+	if site.baseURL != "" {
+		if href != site.baseURL && !strings.HasPrefix(href, site.baseURL+"/") {
+			return false, false
+		}
+		href = strings.TrimPrefix(href, site.baseURL)
+	}
+
+	if strings.HasPrefix(href, "/kal-api/") {
+		return false, false
+	}
+
+	if href != "/" {
+		href = strings.TrimSuffix(href, "/")
+	}
+
+	switch path.Ext(href) {
+	case ".html", ".md", ".mdx":
+		href = strings.TrimSuffix(href, path.Ext(href))
+	case "":
+	default:
+		return false, false
+	}
+
+	if href == "" {
+		href = "/"
+	}
+
+	version := site.byName[site.latest]
+	segments := strings.SplitN(strings.TrimPrefix(href, "/"), "/", 2)
+	if named, ok := site.byName[segments[0]]; ok {
+		version = named
+		href = "/"
+		if len(segments) > 1 {
+			href += segments[1]
+		}
+	}
+
+	if version == nil {
+		return true, false
+	}
+
+	return true, version.paths[href]
+}
+
+// assetChecker looks uploaded assets up in the configured storage, once
+// each.
+type assetChecker struct {
+	cfg    *config.Config
+	exists map[string]bool
+	failed map[string]bool
+}
+
+func newAssetChecker() *assetChecker {
+	return &assetChecker{cfg: config.ParsedConfig, exists: make(map[string]bool), failed: make(map[string]bool)}
+}
+
+// check returns whether url is an uploaded asset and, if it is, what's wrong
+// with it.
+func (checker *assetChecker) check(url string) (bool, string) {
+	if checker.cfg == nil {
+		return false, ""
+	}
+
+	key, ok := UploadedAssetKey(url, checker.cfg)
+	if !ok {
+		return false, ""
+	}
+
+	if _, seen := checker.exists[key]; !seen && !checker.failed[key] {
+		exists, err := S3ObjectExists(key, checker.cfg)
+		if err != nil {
+			checker.failed[key] = true
+		} else {
+			checker.exists[key] = exists
+		}
+	}
+
+	if checker.failed[key] {
+		return true, LinkIssueAssetCheckFailed
+	}
+
+	if !checker.exists[key] {
+		return true, LinkIssueAssetNotFound
+	}
+
+	return true, ""
+}
+
+// checkPage checks every link and embedded file of a page, adding what it
+// found to report.
+func (site *linkSite) checkPage(page models.Page, assets *assetChecker, report *LinkCheckReport) {
+	blocks, err := utils.ParseBlocks(page.Content)
+	if err != nil {
+		return
+	}
+
+	report.Pages++
+
+	for _, link := range utils.BlockLinks(blocks) {
+		reason := ""
+
+		if isAsset, problem := assets.check(link.URL); isAsset {
+			report.Assets++
+			reason = problem
+		} else if internal, found := site.resolve(link.URL); internal {
+			report.Links++
+			if !found {
+				reason = LinkIssuePageNotFound
+			}
+		}
+
+		if reason != "" {
+			report.Issues = append(report.Issues, LinkIssue{
+				PageID:    page.ID,
+				PageTitle: page.Title,
+				BlockID:   link.BlockID,
+				URL:       link.URL,
+				Asset:     link.Asset,
+				Reason:    reason,
+			})
+		}
+	}
+}
+
+// CheckLinks checks the internal links and uploaded assets of the published
+// pages of a documentation, with a report for each of its versions.
+func (service *DocService) CheckLinks(docId uint) ([]LinkCheckReport, error) {
+	rootId, err := rootDocumentationID(service.DB, docId)
+	if err != nil {
+		return nil, fmt.Errorf("documentation_not_found")
+	}
+
+	site, err := service.newLinkSite(rootId, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	assets := newAssetChecker()
+	reports := make([]LinkCheckReport, 0, len(site.versions))
+
+	for _, version := range site.versions {
+		report := LinkCheckReport{DocumentationID: version.documentationID, Version: version.version, Issues: make([]LinkIssue, 0)}
+		for _, page := range version.pages {
+			site.checkPage(page, assets, &report)
+		}
+		reports = append(reports, report)
+	}
+
+	return reports, nil
+}
+
+func linkCheckBlocksPublish() bool {
+	return config.ParsedConfig != nil && config.ParsedConfig.LinkCheck.BlockPublish
+}
+
+// checkPublishLinks refuses to publish content with broken links when the
+// link check is set to block publishing. With pages only those pages are
+// about to be published, without them the whole documentation version is.
+// db is what it reads through, the transaction the publish happens in.
+func (service *DocService) checkPublishLinks(db *gorm.DB, docId uint, pages []models.Page) error {
+	if !linkCheckBlocksPublish() {
+		return nil
+	}
+
+	rootId, err := rootDocumentationID(db, docId)
+	if err != nil {
+		return fmt.Errorf("documentation_not_found")
+	}
+
+	draftsOf := docId
+	if pages != nil {
+		draftsOf = 0
+	}
+
+	site, err := service.withDB(db).newLinkSite(rootId, draftsOf)
+	if err != nil {
+		return err
+	}
+
+	version := site.version(docId)
+	if version == nil {
+		return fmt.Errorf("documentation_not_found")
+	}
+
+	if pages == nil {
+		pages = version.pages
+	} else {
+		for _, page := range pages {
+			version.addPage(page)
+		}
+	}
+
+	report := LinkCheckReport{}
+	assets := newAssetChecker()
+	for _, page := range pages {
+		site.checkPage(page, assets, &report)
+	}
+
+	if len(report.Issues) > 0 {
+		return errBrokenLinks
+	}
+
+	return nil
+}
+
+// linkCheckBuildStep runs the link check before a build, listing what it
+// found as the step's output. Broken links only fail the build when the link
+// check blocks publishing.
+func (service *DocService) linkCheckBuildStep(rootId uint) (string, error) {
+	reports, err := service.CheckLinks(rootId)
+	if err != nil {
+		return "", err
+	}
+
+	var output strings.Builder
+	issues := 0
+
+	for _, report := range reports {
+		fmt.Fprintf(&output, "%s: %d pages, %d links, %d assets, %d issues\n", report.Version, report.Pages, report.Links, report.Assets, len(report.Issues))
+		for _, issue := range report.Issues {
+			fmt.Fprintf(&output, "  page %d (%s) block %s: %s %s\n", issue.PageID, issue.PageTitle, issue.BlockID, issue.Reason, issue.URL)
+		}
+		issues += len(report.Issues)
+	}
+
+	if issues > 0 && linkCheckBlocksPublish() {
+		return output.String(), errBrokenLinks
+	}
+
+	return output.String(), nil
+}
@@ -0,0 +1,216 @@
+package services
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+
+	"git.difuse.io/Difuse/kalmia/db/models"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+type fakeAssetStorage struct {
+	s3iface.S3API
+	keys    map[string]bool
+	uploads int
+}
+
+func (f *fakeAssetStorage) HeadObject(input *s3.HeadObjectInput) (*s3.HeadObjectOutput, error) {
+	if f.keys[aws.StringValue(input.Key)] {
+		return &s3.HeadObjectOutput{}, nil
+	}
+	return nil, awserr.New("NotFound", "Not Found", nil)
+}
+
+func (f *fakeAssetStorage) GetObject(input *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	if f.keys[aws.StringValue(input.Key)] {
+		return &s3.GetObjectOutput{Body: io.NopCloser(strings.NewReader("asset")), ContentType: aws.String("image/png")}, nil
+	}
+	return nil, awserr.New(s3.ErrCodeNoSuchKey, "Not Found", nil)
+}
+
+func (f *fakeAssetStorage) PutObject(input *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+	f.keys[aws.StringValue(input.Key)] = true
+	f.uploads++
+	return &s3.PutObjectOutput{}, nil
+}
+
+func (f *fakeAssetStorage) DeleteObject(input *s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error) {
+	delete(f.keys, aws.StringValue(input.Key))
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+func linkContent(t *testing.T, hrefs []string, images []string) string {
+	t.Helper()
+
+	inline := make([]interface{}, 0, len(hrefs))
+	for _, href := range hrefs {
+		inline = append(inline, map[string]interface{}{
+			"type":    "link",
+			"href":    href,
+			"content": []interface{}{map[string]interface{}{"type": "text", "text": href, "styles": map[string]interface{}{}}},
+		})
+	}
+
+	blocks := []map[string]interface{}{{"id": "links", "type": "paragraph", "props": map[string]interface{}{}, "content": inline, "children": []interface{}{}}}
+	for i, image := range images {
+		blocks = append(blocks, map[string]interface{}{
+			"id":       "image-" + string(rune('a'+i)),
+			"type":     "image",
+			"props":    map[string]interface{}{"url": image},
+			"content":  []interface{}{},
+			"children": []interface{}{},
+		})
+	}
+
+	content, err := json.Marshal(blocks)
+	if err != nil {
+		t.Fatalf("Failed to marshal content: %v", err)
+	}
+
+	return string(content)
+}
+
+func TestCheckLinks(t *testing.T) {
+	if TestDocService == nil {
+		t.Fatal("TestDocService is nil")
+	}
+
+	db := TestDocService.DB
+
+	originalNewS3Client := newS3Client
+	newS3Client = func(sess *session.Session) s3iface.S3API {
+		return &fakeAssetStorage{keys: map[string]bool{"upload-present.png": true}}
+	}
+	defer func() {
+		newS3Client = originalNewS3Client
+	}()
+
+	var admin models.User
+	if err := db.Where("username = ?", "admin").First(&admin).Error; err != nil {
+		t.Fatalf("Failed to get admin: %v", err)
+	}
+
+	doc := models.Documentation{Name: "Links", Version: "1.0.0", BaseURL: "/linked", URL: "https://docs.example.com", AuthorID: admin.ID}
+	if err := db.Create(&doc).Error; err != nil {
+		t.Fatalf("Failed to create documentation: %v", err)
+	}
+
+	group := models.PageGroup{DocumentationID: doc.ID, Name: "Getting Started", AuthorID: admin.ID}
+	if _, err := TestDocService.CreatePageGroup(&group); err != nil {
+		t.Fatalf("CreatePageGroup returned an error: %v", err)
+	}
+
+	install := models.Page{DocumentationID: doc.ID, PageGroupID: &group.ID, Title: "Install", Slug: "/install", Content: "[]", AuthorID: admin.ID}
+	if err := TestDocService.CreatePage(&install); err != nil {
+		t.Fatalf("CreatePage returned an error: %v", err)
+	}
+
+	content := linkContent(t, []string{
+		"/linked/guides/getting-started/install",
+		"https://docs.example.com/linked/guides/getting-started/install.html#step",
+		"/linked/install",
+		"/linked/guides/missing",
+		"https://example.org/elsewhere",
+		"/elsewhere",
+	}, []string{
+		"/kal-api/file/get/upload-present.png",
+		"/kal-api/file/get/upload-gone.png",
+	})
+
+	home := models.Page{DocumentationID: doc.ID, Title: "Overview", Slug: "/overview", Content: content, AuthorID: admin.ID}
+	if err := TestDocService.CreatePage(&home); err != nil {
+		t.Fatalf("CreatePage returned an error: %v", err)
+	}
+
+	if err := TestDocService.PublishPageGroup(admin, group.ID); err != nil {
+		t.Fatalf("PublishPageGroup returned an error: %v", err)
+	}
+
+	for _, id := range []uint{install.ID, home.ID} {
+		if err := TestDocService.PublishPage(admin, id); err != nil {
+			t.Fatalf("PublishPage returned an error: %v", err)
+		}
+	}
+
+	t.Run("Broken links and missing assets are reported", func(t *testing.T) {
+		reports, err := TestDocService.CheckLinks(doc.ID)
+		if err != nil {
+			t.Fatalf("CheckLinks returned an error: %v", err)
+		}
+
+		if len(reports) != 1 {
+			t.Fatalf("Expected one report, got %d", len(reports))
+		}
+
+		report := reports[0]
+		if report.Version != "1.0.0" || report.Pages != 2 || report.Links != 4 || report.Assets != 2 {
+			t.Errorf("Unexpected report counts: %+v", report)
+		}
+
+		reasons := make(map[string]string)
+		for _, issue := range report.Issues {
+			if issue.PageID != home.ID {
+				t.Errorf("Expected issues on the overview page only, got %+v", issue)
+			}
+			reasons[issue.URL] = issue.Reason
+		}
+
+		if len(reasons) != 2 || reasons["/linked/guides/missing"] != LinkIssuePageNotFound ||
+			reasons["/kal-api/file/get/upload-gone.png"] != LinkIssueAssetNotFound {
+			t.Errorf("Unexpected issues: %v", reasons)
+		}
+	})
+
+	t.Run("Renaming a page breaks links to it", func(t *testing.T) {
+		if err := TestDocService.EditPage(admin, install.ID, "Installation", "/installation", "", nil, nil, nil); err != nil {
+			t.Fatalf("EditPage returned an error: %v", err)
+		}
+
+		if err := TestDocService.PublishPage(admin, install.ID); err != nil {
+			t.Fatalf("PublishPage returned an error: %v", err)
+		}
+
+		reports, err := TestDocService.CheckLinks(doc.ID)
+		if err != nil {
+			t.Fatalf("CheckLinks returned an error: %v", err)
+		}
+
+		if len(reports[0].Issues) != 5 {
+			t.Errorf("Expected the three links to the old page to break, got %+v", reports[0].Issues)
+		}
+	})
+
+	t.Run("Broken links can block publishing", func(t *testing.T) {
+		TestConfig.LinkCheck.BlockPublish = true
+		defer func() {
+			TestConfig.LinkCheck.BlockPublish = false
+		}()
+
+		if err := TestDocService.PublishPage(admin, home.ID); err == nil || err.Error() != "broken_links" {
+			t.Errorf("Expected broken_links, got %v", err)
+		}
+
+		if _, err := TestDocService.PublishDocumentation(admin, doc.ID); err == nil || err.Error() != "broken_links" {
+			t.Errorf("Expected broken_links, got %v", err)
+		}
+
+		fixed := linkContent(t, []string{"/linked/guides/getting-started/installation", "/linked/installation"}, nil)
+		if err := TestDocService.EditPage(admin, home.ID, "Overview", "/overview", fixed, nil, nil, nil); err != nil {
+			t.Fatalf("EditPage returned an error: %v", err)
+		}
+
+		if err := TestDocService.PublishPage(admin, home.ID); err != nil {
+			t.Errorf("PublishPage returned an error: %v", err)
+		}
+
+		if _, err := TestDocService.linkCheckBuildStep(doc.ID); err != nil {
+			t.Errorf("Expected the build check to pass, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,226 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"git.difuse.io/Difuse/kalmia/db/models"
+	"gorm.io/gorm"
+)
+
+// EditLockTTL is how long a lock outlives its last heartbeat. Editors are
+// expected to heartbeat well within it while the page is open.
+const EditLockTTL = 2 * time.Minute
+
+// lockedDocumentationID returns the documentation of the page or page group
+// a lock is about.
+func lockedDocumentationID(db *gorm.DB, kind string, id uint) (uint, error) {
+	var docIds []uint
+
+	switch kind {
+	case ResourcePage:
+		if err := db.Model(&models.Page{}).Where("id = ?", id).Pluck("documentation_id", &docIds).Error; err != nil || len(docIds) == 0 {
+			return 0, fmt.Errorf("page_not_found")
+		}
+	case ResourcePageGroup:
+		if err := db.Model(&models.PageGroup{}).Where("id = ?", id).Pluck("documentation_id", &docIds).Error; err != nil || len(docIds) == 0 {
+			return 0, fmt.Errorf("page_group_not_found")
+		}
+	default:
+		return 0, fmt.Errorf("unknown_resource")
+	}
+
+	return docIds[0], nil
+}
+
+// activeLock returns the unexpired lock on a page or page group, nil when
+// there is none.
+func activeLock(db *gorm.DB, kind string, id uint) (*models.EditLock, error) {
+	var lock models.EditLock
+	if err := db.Preload("Holder", selectReviewUser).
+		Where("kind = ? AND ref_id = ? AND expires_at > ?", kind, id, time.Now().UTC()).
+		First(&lock).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed_to_get_lock")
+	}
+
+	return &lock, nil
+}
+
+// checkEditLock refuses a write to a page or page group that somebody else
+// holds the lock of.
+func checkEditLock(db *gorm.DB, kind string, id uint, userId uint) error {
+	lock, err := activeLock(db, kind, id)
+	if err != nil {
+		return err
+	}
+
+	if lock != nil && lock.HolderID != userId {
+		return fmt.Errorf("locked_by_another_user")
+	}
+
+	return nil
+}
+
+func removeEditLocks(tx *gorm.DB, kind string, refIds ...uint) error {
+	if len(refIds) == 0 {
+		return nil
+	}
+
+	if err := tx.Where("kind = ? AND ref_id IN ?", kind, refIds).Delete(&models.EditLock{}).Error; err != nil {
+		return fmt.Errorf("failed_to_delete_locks")
+	}
+
+	return nil
+}
+
+func (service *DocService) GetLock(kind string, id uint) (*models.EditLock, error) {
+	return activeLock(service.DB, kind, id)
+}
+
+// AcquireLock takes the lock on a page or page group for the user, or
+// renews it if they already hold it. Expired locks are taken over.
+func (service *DocService) AcquireLock(user models.User, kind string, id uint) (models.EditLock, error) {
+	docId, err := lockedDocumentationID(service.DB, kind, id)
+	if err != nil {
+		return models.EditLock{}, err
+	}
+
+	if err := service.requireDocumentationRole(user.ID, docId, RoleEditor); err != nil {
+		return models.EditLock{}, err
+	}
+
+	now := time.Now().UTC()
+
+	err = service.DB.Transaction(func(tx *gorm.DB) error {
+		var existing models.EditLock
+		err := tx.Where("kind = ? AND ref_id = ?", kind, id).First(&existing).Error
+
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			lock := models.EditLock{
+				Kind:            kind,
+				RefID:           id,
+				DocumentationID: docId,
+				HolderID:        user.ID,
+				AcquiredAt:      now,
+				HeartbeatAt:     now,
+				ExpiresAt:       now.Add(EditLockTTL),
+			}
+
+			if err := tx.Omit("Holder").Create(&lock).Error; err != nil {
+				return fmt.Errorf("failed_to_acquire_lock")
+			}
+			return nil
+		}
+
+		if err != nil {
+			return fmt.Errorf("failed_to_get_lock")
+		}
+
+		columns := map[string]interface{}{
+			"holder_id":    user.ID,
+			"heartbeat_at": now,
+			"expires_at":   now.Add(EditLockTTL),
+		}
+		if existing.HolderID != user.ID || !existing.ExpiresAt.After(now) {
+			columns["acquired_at"] = now
+		}
+
+		// Only the holder renews a live lock, anyone takes over an expired one.
+		result := tx.Model(&models.EditLock{}).
+			Where("id = ? AND (holder_id = ? OR expires_at <= ?)", existing.ID, user.ID, now).
+			Updates(columns)
+
+		if result.Error != nil {
+			return fmt.Errorf("failed_to_acquire_lock")
+		}
+
+		if result.RowsAffected == 0 {
+			return fmt.Errorf("locked_by_another_user")
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return models.EditLock{}, err
+	}
+
+	lock, err := activeLock(service.DB, kind, id)
+	if err != nil {
+		return models.EditLock{}, err
+	}
+
+	if lock == nil {
+		return models.EditLock{}, fmt.Errorf("failed_to_acquire_lock")
+	}
+
+	return *lock, nil
+}
+
+// HeartbeatLock keeps the user's lock alive for another EditLockTTL.
+func (service *DocService) HeartbeatLock(user models.User, kind string, id uint) (models.EditLock, error) {
+	now := time.Now().UTC()
+
+	result := service.DB.Model(&models.EditLock{}).
+		Where("kind = ? AND ref_id = ? AND holder_id = ? AND expires_at > ?", kind, id, user.ID, now).
+		Updates(map[string]interface{}{"heartbeat_at": now, "expires_at": now.Add(EditLockTTL)})
+
+	if result.Error != nil {
+		return models.EditLock{}, fmt.Errorf("failed_to_update_lock")
+	}
+
+	if result.RowsAffected == 0 {
+		return models.EditLock{}, fmt.Errorf("lock_not_held")
+	}
+
+	lock, err := activeLock(service.DB, kind, id)
+	if err != nil {
+		return models.EditLock{}, err
+	}
+
+	if lock == nil {
+		return models.EditLock{}, fmt.Errorf("lock_not_held")
+	}
+
+	return *lock, nil
+}
+
+// ReleaseLock gives up the user's lock.
+func (service *DocService) ReleaseLock(user models.User, kind string, id uint) error {
+	result := service.DB.Where("kind = ? AND ref_id = ? AND holder_id = ? AND expires_at > ?", kind, id, user.ID, time.Now().UTC()).
+		Delete(&models.EditLock{})
+
+	if result.Error != nil {
+		return fmt.Errorf("failed_to_delete_lock")
+	}
+
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("lock_not_held")
+	}
+
+	return nil
+}
+
+// ForceUnlock removes whoever's lock is on a page or page group.
+func (service *DocService) ForceUnlock(kind string, id uint) error {
+	lock, err := activeLock(service.DB, kind, id)
+	if err != nil {
+		return err
+	}
+
+	if lock == nil {
+		return fmt.Errorf("lock_not_found")
+	}
+
+	if err := service.DB.Delete(&models.EditLock{}, lock.ID).Error; err != nil {
+		return fmt.Errorf("failed_to_delete_lock")
+	}
+
+	service.audit(kind+".force_unlock", kind, id, map[string]interface{}{"holderId": lock.HolderID, "expiresAt": lock.ExpiresAt}, nil)
+
+	return nil
+}
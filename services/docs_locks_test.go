@@ -0,0 +1,147 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"git.difuse.io/Difuse/kalmia/db/models"
+)
+
+func TestEditLocks(t *testing.T) {
+	if TestDocService == nil {
+		t.Fatal("TestDocService is nil")
+	}
+
+	db := TestDocService.DB
+
+	var admin, user models.User
+	if err := db.Where("username = ?", "admin").First(&admin).Error; err != nil {
+		t.Fatalf("Failed to get admin: %v", err)
+	}
+	if err := db.Where("username = ?", "user").First(&user).Error; err != nil {
+		t.Fatalf("Failed to get user: %v", err)
+	}
+
+	doc := models.Documentation{Name: "Locks", Version: "1.0.0", BaseURL: "/locks", AuthorID: admin.ID}
+	if err := db.Create(&doc).Error; err != nil {
+		t.Fatalf("Failed to create documentation: %v", err)
+	}
+
+	if err := TestDocService.SetDocumentationMember(admin, doc.ID, user.ID, RoleEditor); err != nil {
+		t.Fatalf("SetDocumentationMember returned an error: %v", err)
+	}
+
+	page := models.Page{DocumentationID: doc.ID, Title: "Locked", Slug: "/locked", Content: "[]", AuthorID: admin.ID}
+	if err := TestDocService.CreatePage(&page); err != nil {
+		t.Fatalf("CreatePage returned an error: %v", err)
+	}
+
+	group := models.PageGroup{DocumentationID: doc.ID, Name: "Locked group", AuthorID: admin.ID}
+	if _, err := TestDocService.CreatePageGroup(&group); err != nil {
+		t.Fatalf("CreatePageGroup returned an error: %v", err)
+	}
+
+	t.Run("Only the holder can edit", func(t *testing.T) {
+		lock, err := TestDocService.AcquireLock(user, ResourcePage, page.ID)
+		if err != nil {
+			t.Fatalf("AcquireLock returned an error: %v", err)
+		}
+
+		if lock.HolderID != user.ID || lock.Holder.Username != "user" {
+			t.Errorf("Expected user to hold the lock, got %+v", lock)
+		}
+
+		if _, err := TestDocService.AcquireLock(admin, ResourcePage, page.ID); err == nil || err.Error() != "locked_by_another_user" {
+			t.Errorf("Expected locked_by_another_user, got %v", err)
+		}
+
+		if err := TestDocService.EditPage(admin, page.ID, "Taken", "/locked", "", nil, nil, nil); err == nil || err.Error() != "locked_by_another_user" {
+			t.Errorf("Expected locked_by_another_user, got %v", err)
+		}
+
+		if err := TestDocService.EditPage(user, page.ID, "Mine", "/locked", "", nil, nil, nil); err != nil {
+			t.Errorf("EditPage returned an error: %v", err)
+		}
+	})
+
+	t.Run("GetPage shows the lock", func(t *testing.T) {
+		stored, err := TestDocService.GetPage(page.ID)
+		if err != nil {
+			t.Fatalf("GetPage returned an error: %v", err)
+		}
+
+		if stored.Lock == nil || stored.Lock.HolderID != user.ID {
+			t.Errorf("Expected the lock held by user, got %+v", stored.Lock)
+		}
+	})
+
+	t.Run("Heartbeats extend the lock", func(t *testing.T) {
+		if err := db.Model(&models.EditLock{}).Where("kind = ? AND ref_id = ?", ResourcePage, page.ID).
+			Update("expires_at", time.Now().UTC().Add(10*time.Second)).Error; err != nil {
+			t.Fatalf("Failed to shorten lock: %v", err)
+		}
+
+		lock, err := TestDocService.HeartbeatLock(user, ResourcePage, page.ID)
+		if err != nil {
+			t.Fatalf("HeartbeatLock returned an error: %v", err)
+		}
+
+		if time.Until(lock.ExpiresAt) < EditLockTTL-time.Minute {
+			t.Errorf("Expected the lock to be extended, expires at %v", lock.ExpiresAt)
+		}
+
+		if _, err := TestDocService.HeartbeatLock(admin, ResourcePage, page.ID); err == nil || err.Error() != "lock_not_held" {
+			t.Errorf("Expected lock_not_held, got %v", err)
+		}
+	})
+
+	t.Run("Expired locks are taken over", func(t *testing.T) {
+		if err := db.Model(&models.EditLock{}).Where("kind = ? AND ref_id = ?", ResourcePage, page.ID).
+			Update("expires_at", time.Now().UTC().Add(-time.Second)).Error; err != nil {
+			t.Fatalf("Failed to expire lock: %v", err)
+		}
+
+		if err := TestDocService.EditPage(admin, page.ID, "Free again", "/locked", "", nil, nil, nil); err != nil {
+			t.Errorf("EditPage returned an error: %v", err)
+		}
+
+		lock, err := TestDocService.AcquireLock(admin, ResourcePage, page.ID)
+		if err != nil {
+			t.Fatalf("AcquireLock returned an error: %v", err)
+		}
+
+		if lock.HolderID != admin.ID {
+			t.Errorf("Expected admin to hold the lock, got %+v", lock)
+		}
+
+		if err := TestDocService.ReleaseLock(user, ResourcePage, page.ID); err == nil || err.Error() != "lock_not_held" {
+			t.Errorf("Expected lock_not_held, got %v", err)
+		}
+
+		if err := TestDocService.ReleaseLock(admin, ResourcePage, page.ID); err != nil {
+			t.Errorf("ReleaseLock returned an error: %v", err)
+		}
+	})
+
+	t.Run("Page group locks and force unlock", func(t *testing.T) {
+		if _, err := TestDocService.AcquireLock(user, ResourcePageGroup, group.ID); err != nil {
+			t.Fatalf("AcquireLock returned an error: %v", err)
+		}
+
+		if err := TestDocService.EditPageGroup(admin, group.ID, "Renamed", doc.ID, nil, nil); err == nil || err.Error() != "locked_by_another_user" {
+			t.Errorf("Expected locked_by_another_user, got %v", err)
+		}
+
+		if err := TestDocService.ForceUnlock(ResourcePageGroup, group.ID); err != nil {
+			t.Fatalf("ForceUnlock returned an error: %v", err)
+		}
+
+		if err := TestDocService.ForceUnlock(ResourcePageGroup, group.ID); err == nil || err.Error() != "lock_not_found" {
+			t.Errorf("Expected lock_not_found, got %v", err)
+		}
+
+		if err := TestDocService.EditPageGroup(admin, group.ID, "Renamed", doc.ID, nil, nil); err != nil {
+			t.Errorf("EditPageGroup returned an error: %v", err)
+		}
+	})
+}
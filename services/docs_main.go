@@ -8,9 +8,32 @@ import (
 
 type DocService struct {
 	DB          *gorm.DB
-	UWBMutexMap sync.Map
+	UWBMutexMap *sync.Map
+	BuildEvents *BuildEventBroker
+
+	// Machine translation provider, the one the config asks for when nil.
+	Translator Translator
+
+	// Build step recorders of the builds currently running, by doc ID.
+	buildRecorders *sync.Map
+
+	// Who the changes made through this service are attributed to.
+	actor *AuditActor
 }
 
 func NewDocService(db *gorm.DB) *DocService {
-	return &DocService{DB: db}
+	return &DocService{
+		DB:             db,
+		UWBMutexMap:    &sync.Map{},
+		BuildEvents:    NewBuildEventBroker(),
+		buildRecorders: &sync.Map{},
+	}
+}
+
+// withDB returns a copy of the service that goes through db, so what it
+// reads inside a transaction sees the transaction's writes.
+func (service *DocService) withDB(db *gorm.DB) *DocService {
+	copied := *service
+	copied.DB = db
+	return &copied
 }
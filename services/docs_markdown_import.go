@@ -0,0 +1,572 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"git.difuse.io/Difuse/kalmia/config"
+	"git.difuse.io/Difuse/kalmia/db/models"
+	"git.difuse.io/Difuse/kalmia/logger"
+	"git.difuse.io/Difuse/kalmia/utils"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+type MarkdownImportOptions struct {
+	// DocumentationID imports into an existing documentation. When it is zero
+	// Documentation is created instead, inside the same transaction.
+	DocumentationID uint
+	Documentation   *models.Documentation
+	PageGroupID     *uint
+}
+
+type MarkdownImportResult struct {
+	DocumentationID uint `json:"documentationId"`
+	PageGroups      int  `json:"pageGroups"`
+	Pages           int  `json:"pages"`
+}
+
+type markdownImportNode struct {
+	Name     string
+	Label    string
+	Path     string
+	IsDir    bool
+	Slug     string
+	Title    string
+	Content  string
+	IsIndex  bool
+	Children []*markdownImportNode
+}
+
+var markdownIndexNames = []string{"index", "readme"}
+
+func isMarkdownFile(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	return ext == ".md" || ext == ".mdx"
+}
+
+func trimMarkdownExt(name string) string {
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
+
+func isMarkdownIndex(name string) bool {
+	base := strings.ToLower(trimMarkdownExt(name))
+	for _, indexName := range markdownIndexNames {
+		if base == indexName {
+			return true
+		}
+	}
+	return false
+}
+
+func humanizeName(name string) string {
+	name = strings.NewReplacer("-", " ", "_", " ").Replace(trimMarkdownExt(name))
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return name
+	}
+	return strings.ToUpper(name[:1]) + name[1:]
+}
+
+// readMetaOrder reads an RsPress style _meta.json, which is an array of either
+// plain names or objects with a name and an optional label.
+func readMetaOrder(dir string) ([]string, map[string]string) {
+	var order []string
+	labels := make(map[string]string)
+
+	content, err := os.ReadFile(filepath.Join(dir, "_meta.json"))
+	if err != nil {
+		return order, labels
+	}
+
+	var entries []interface{}
+	if err := json.Unmarshal(content, &entries); err != nil {
+		logger.Warn("Ignoring invalid _meta.json", zap.String("dir", dir), zap.Error(err))
+		return order, labels
+	}
+
+	for _, entry := range entries {
+		switch v := entry.(type) {
+		case string:
+			order = append(order, trimMarkdownExt(v))
+		case map[string]interface{}:
+			name, _ := v["name"].(string)
+			if name == "" {
+				continue
+			}
+			name = trimMarkdownExt(name)
+			order = append(order, name)
+			if label, ok := v["label"].(string); ok && label != "" {
+				labels[name] = label
+			}
+		}
+	}
+
+	return order, labels
+}
+
+func scanMarkdownDir(dir string) ([]*markdownImportNode, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	order, labels := readMetaOrder(dir)
+	nodes := make([]*markdownImportNode, 0)
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") || name == "node_modules" {
+			continue
+		}
+
+		fullPath := filepath.Join(dir, name)
+
+		if entry.IsDir() {
+			children, err := scanMarkdownDir(fullPath)
+			if err != nil {
+				return nil, err
+			}
+
+			if len(children) == 0 {
+				continue
+			}
+
+			nodes = append(nodes, &markdownImportNode{
+				Name:     name,
+				Label:    labels[name],
+				Path:     fullPath,
+				IsDir:    true,
+				Children: children,
+			})
+		} else if isMarkdownFile(name) {
+			nodes = append(nodes, &markdownImportNode{
+				Name:    name,
+				Label:   labels[trimMarkdownExt(name)],
+				Path:    fullPath,
+				IsIndex: isMarkdownIndex(name),
+			})
+		}
+	}
+
+	// Only one index file per directory: prefer index.md over README.md.
+	var indexNode *markdownImportNode
+	for _, node := range nodes {
+		if !node.IsIndex {
+			continue
+		}
+		if indexNode == nil || strings.EqualFold(trimMarkdownExt(node.Name), "index") {
+			if indexNode != nil {
+				indexNode.IsIndex = false
+			}
+			indexNode = node
+		} else {
+			node.IsIndex = false
+		}
+	}
+
+	position := make(map[string]int, len(order))
+	for i, name := range order {
+		if _, exists := position[name]; !exists {
+			position[name] = i
+		}
+	}
+
+	rank := func(node *markdownImportNode) int {
+		if node.IsIndex {
+			return -1
+		}
+		if i, ok := position[trimMarkdownExt(node.Name)]; ok {
+			return i
+		}
+		return len(order)
+	}
+
+	sort.SliceStable(nodes, func(i, j int) bool {
+		ri, rj := rank(nodes[i]), rank(nodes[j])
+		if ri != rj {
+			return ri < rj
+		}
+		return nodes[i].Name < nodes[j].Name
+	})
+
+	return nodes, nil
+}
+
+// prepareMarkdownNodes converts every file into block JSON and works out its
+// slug and title. This runs before the database transaction since it may
+// upload assets to storage.
+func prepareMarkdownNodes(root string, nodes []*markdownImportNode, slugPrefix string, cfg *config.Config, uploaded map[string]string) error {
+	for _, node := range nodes {
+		if node.IsDir {
+			if node.Label == "" {
+				node.Label = humanizeName(node.Name)
+			}
+
+			if err := prepareMarkdownNodes(root, node.Children, slugPrefix+"/"+utils.StringToFileString(node.Name), cfg, uploaded); err != nil {
+				return err
+			}
+			continue
+		}
+
+		source, err := os.ReadFile(node.Path)
+		if err != nil {
+			return fmt.Errorf("failed_to_read_markdown_file: %v", err)
+		}
+
+		dir := filepath.Dir(node.Path)
+		resolver := func(src string) (string, error) {
+			return resolveImportAsset(root, dir, src, cfg, uploaded)
+		}
+
+		doc, err := utils.MarkdownToBlocks(source, resolver)
+		if err != nil {
+			return fmt.Errorf("failed_to_convert_markdown: %v", err)
+		}
+
+		content, err := json.Marshal(doc.Blocks)
+		if err != nil {
+			return fmt.Errorf("failed_to_convert_markdown: %v", err)
+		}
+
+		node.Content = string(content)
+
+		switch {
+		case doc.FrontMatter["title"] != "":
+			node.Title = doc.FrontMatter["title"]
+		case node.Label != "":
+			node.Title = node.Label
+		case doc.Title != "":
+			node.Title = doc.Title
+		default:
+			node.Title = humanizeName(node.Name)
+		}
+
+		if node.IsIndex && slugPrefix != "" {
+			node.Slug = slugPrefix
+		} else {
+			node.Slug = slugPrefix + "/" + utils.StringToFileString(trimMarkdownExt(node.Name))
+		}
+	}
+
+	return nil
+}
+
+func resolveImportAsset(root, dir, src string, cfg *config.Config, uploaded map[string]string) (string, error) {
+	decoded, err := url.PathUnescape(strings.SplitN(src, "?", 2)[0])
+	if err != nil {
+		return "", err
+	}
+
+	relDir, err := filepath.Rel(root, dir)
+	if err != nil {
+		return "", err
+	}
+
+	if strings.HasPrefix(decoded, "/") {
+		relDir = ""
+	}
+
+	absPath, err := utils.SafeJoin(root, filepath.Join(relDir, decoded))
+	if err != nil {
+		return "", err
+	}
+
+	if cached, ok := uploaded[absPath]; ok {
+		return cached, nil
+	}
+
+	file, err := os.Open(absPath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	assetURL, err := UploadToS3Storage(file, filepath.Base(absPath), utils.GetContentType(absPath), cfg)
+	if err != nil {
+		logger.Warn("Failed to upload imported asset", zap.String("path", absPath), zap.Error(err))
+		return "", err
+	}
+
+	uploaded[absPath] = assetURL
+
+	return assetURL, nil
+}
+
+func (service *DocService) createImportedNodes(tx *gorm.DB, user models.User, docId uint, parentId *uint, nodes []*markdownImportNode, isNewDoc bool, result *MarkdownImportResult, imported *[]models.Page) error {
+	for i, node := range nodes {
+		order := utils.UintPtr(uint(i))
+
+		if node.IsDir {
+			group := models.PageGroup{
+				DocumentationID: docId,
+				ParentID:        parentId,
+				AuthorID:        user.ID,
+				Name:            node.Label,
+				Order:           order,
+				Editors:         []models.User{user},
+				LastEditorID:    &user.ID,
+			}
+
+			if err := tx.Create(&group).Error; err != nil {
+				return fmt.Errorf("failed_to_create_page_group")
+			}
+
+			if err := indexPageGroup(tx, group); err != nil {
+				return err
+			}
+
+			if _, err := publishPageGroups(tx, "id = ?", group.ID); err != nil {
+				return err
+			}
+
+			result.PageGroups++
+
+			if err := service.createImportedNodes(tx, user, docId, &group.ID, node.Children, isNewDoc, result, imported); err != nil {
+				return err
+			}
+			continue
+		}
+
+		page := models.Page{
+			DocumentationID: docId,
+			PageGroupID:     parentId,
+			AuthorID:        user.ID,
+			Title:           node.Title,
+			Slug:            node.Slug,
+			Content:         node.Content,
+			Order:           order,
+			Editors:         []models.User{user},
+			LastEditorID:    &user.ID,
+		}
+
+		if isNewDoc && parentId == nil && node.IsIndex {
+			page.Slug = "/"
+			page.IsIntroPage = true
+		}
+
+		var count int64
+		if err := tx.Model(&models.Page{}).Where("documentation_id = ? AND slug = ?", docId, page.Slug).Count(&count).Error; err != nil {
+			return fmt.Errorf("failed_to_check_slug")
+		}
+
+		if count > 0 {
+			return fmt.Errorf("slug_already_in_use: %s", page.Slug)
+		}
+
+		if err := tx.Create(&page).Error; err != nil {
+			return fmt.Errorf("failed_to_create_page")
+		}
+
+		if err := createPageRevision(tx, page, user.ID, nil); err != nil {
+			return err
+		}
+
+		if _, err := publishPageContent(tx, page, user.ID); err != nil {
+			return err
+		}
+
+		if err := indexPage(tx, page); err != nil {
+			return err
+		}
+
+		*imported = append(*imported, page)
+		result.Pages++
+	}
+
+	return nil
+}
+
+// ImportMarkdownDirectory imports a tree of Markdown/MDX files: directories
+// become page groups, files become pages and _meta.json controls the order.
+func (service *DocService) ImportMarkdownDirectory(user models.User, dir string, opts MarkdownImportOptions, cfg *config.Config) (MarkdownImportResult, error) {
+	result := MarkdownImportResult{}
+
+	nodes, err := scanMarkdownDir(dir)
+	if err != nil {
+		return result, fmt.Errorf("failed_to_read_import_directory")
+	}
+
+	if len(nodes) == 0 {
+		return result, fmt.Errorf("no_markdown_files_found")
+	}
+
+	if err := prepareMarkdownNodes(dir, nodes, "", cfg, make(map[string]string)); err != nil {
+		return result, err
+	}
+
+	isNewDoc := opts.DocumentationID == 0
+
+	if isNewDoc && opts.Documentation == nil {
+		return result, fmt.Errorf("documentation_required")
+	}
+
+	err = service.DB.Transaction(func(tx *gorm.DB) error {
+		docId := opts.DocumentationID
+
+		if isNewDoc {
+			documentation := opts.Documentation
+
+			var count int64
+			if err := tx.Model(&models.Documentation{}).Where("name = ?", documentation.Name).Count(&count).Error; err != nil {
+				return fmt.Errorf("failed_to_check_documentation_name")
+			}
+
+			if count > 0 {
+				return fmt.Errorf("documentation_name_already_exists")
+			}
+
+			if !utils.IsBaseURLValid(documentation.BaseURL) {
+				return fmt.Errorf("invalid_base_url")
+			}
+
+			if err := tx.Create(documentation).Error; err != nil {
+				return fmt.Errorf("failed_to_create_documentation")
+			}
+
+			if err := addDocumentationOwner(tx, documentation.ID, user.ID); err != nil {
+				return err
+			}
+
+			if err := indexDocumentation(tx, *documentation); err != nil {
+				return err
+			}
+
+			docId = documentation.ID
+		} else {
+			var doc models.Documentation
+			if err := tx.First(&doc, docId).Error; err != nil {
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					return fmt.Errorf("documentation_not_found")
+				}
+				return fmt.Errorf("failed_to_get_documentation")
+			}
+
+			if err := requireDocumentationRole(tx, user.ID, docId, RoleEditor); err != nil {
+				return err
+			}
+
+			if opts.PageGroupID != nil {
+				var count int64
+				if err := tx.Model(&models.PageGroup{}).Where("id = ? AND documentation_id = ?", *opts.PageGroupID, docId).Count(&count).Error; err != nil {
+					return fmt.Errorf("failed_to_verify_parent_page_group")
+				}
+				if count == 0 {
+					return fmt.Errorf("invalid_parent_page_group_id")
+				}
+			}
+		}
+
+		result.DocumentationID = docId
+
+		imported := make([]models.Page, 0)
+		if err := service.createImportedNodes(tx, user, docId, opts.PageGroupID, nodes, isNewDoc, &result, &imported); err != nil {
+			return err
+		}
+
+		if isNewDoc {
+			var introCount int64
+			if err := tx.Model(&models.Page{}).Where("documentation_id = ? AND is_intro_page = ?", docId, true).Count(&introCount).Error; err != nil {
+				return fmt.Errorf("failed_to_check_intro_page")
+			}
+
+			if introCount == 0 {
+				introPage := models.Page{
+					Title:           "Introduction",
+					Slug:            "/",
+					Content:         defaultIntroPageContent,
+					DocumentationID: docId,
+					AuthorID:        user.ID,
+					Editors:         []models.User{user},
+					LastEditorID:    &user.ID,
+					Order:           utils.UintPtr(0),
+					IsIntroPage:     true,
+				}
+
+				if err := tx.Create(&introPage).Error; err != nil {
+					return fmt.Errorf("failed_to_create_documentation_intro_page")
+				}
+
+				if err := createPageRevision(tx, introPage, user.ID, nil); err != nil {
+					return err
+				}
+
+				if _, err := publishPageContent(tx, introPage, user.ID); err != nil {
+					return err
+				}
+
+				if err := indexPage(tx, introPage); err != nil {
+					return err
+				}
+
+				result.Pages++
+			}
+		}
+
+		if err := checkPublishedSlugs(tx, docId); err != nil {
+			return err
+		}
+
+		return service.checkPublishLinks(tx, docId, imported)
+	})
+
+	if err != nil {
+		return MarkdownImportResult{}, err
+	}
+
+	service.audit("documentation.import", AuditEntityDocumentation, result.DocumentationID, nil, result)
+
+	if isNewDoc {
+		if err := service.InitRsPress(result.DocumentationID); err != nil {
+			logger.Error("failed_to_init_rspress", zap.Error(err))
+			return result, fmt.Errorf("failed_to_init_rspress")
+		}
+	}
+
+	if err := service.triggerRootBuild(result.DocumentationID); err != nil {
+		return result, fmt.Errorf("failed_to_update_write_build")
+	}
+
+	return result, nil
+}
+
+// ImportMarkdownArchive extracts a zip upload and imports it with
+// ImportMarkdownDirectory. A single top level folder in the archive is
+// treated as the root.
+func (service *DocService) ImportMarkdownArchive(user models.User, zipPath string, opts MarkdownImportOptions, cfg *config.Config) (MarkdownImportResult, error) {
+	tempDir, err := os.MkdirTemp("", "markdown-import-")
+	if err != nil {
+		return MarkdownImportResult{}, fmt.Errorf("failed_to_create_temp_dir")
+	}
+
+	defer os.RemoveAll(tempDir)
+
+	if err := utils.ExtractZip(zipPath, tempDir, (cfg.MaxFileSize<<20)*10); err != nil {
+		logger.Error("Failed to extract markdown archive", zap.Error(err))
+		return MarkdownImportResult{}, fmt.Errorf("failed_to_extract_archive")
+	}
+
+	root := tempDir
+
+	entries, err := os.ReadDir(tempDir)
+	if err == nil {
+		var visible []os.DirEntry
+		for _, entry := range entries {
+			if !strings.HasPrefix(entry.Name(), ".") && entry.Name() != "__MACOSX" {
+				visible = append(visible, entry)
+			}
+		}
+
+		if len(visible) == 1 && visible[0].IsDir() {
+			root = filepath.Join(tempDir, visible[0].Name())
+		}
+	}
+
+	return service.ImportMarkdownDirectory(user, root, opts, cfg)
+}
@@ -0,0 +1,133 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"git.difuse.io/Difuse/kalmia/db/models"
+)
+
+func TestImportMarkdownDirectory(t *testing.T) {
+	if TestDocService == nil {
+		t.Fatal("TestDocService is nil")
+	}
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"index.md":               "# Welcome\n\nHello",
+		"zeta.md":                "---\ntitle: Zeta Page\n---\n\nLast",
+		"alpha.mdx":              "import X from 'x'\n\n# Alpha\n\nFirst",
+		"_meta.json":             `["guide", {"type": "file", "name": "alpha", "label": "Alpha Label"}, "zeta"]`,
+		"guide/_meta.json":       `["setup", "intro"]`,
+		"guide/intro.md":         "# Intro",
+		"guide/setup.md":         "# Setup\n\n- step",
+		"guide/nested/README.md": "# Nested readme",
+		"guide/nested/deep.md":   "# Deep",
+		"empty/notes.txt":        "not markdown",
+		".hidden/ignored.md":     "# Ignored",
+	}
+
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	db := TestDocService.DB
+
+	doc := models.Documentation{Name: "Markdown Import", Version: "1.0.0", BaseURL: "/markdown-import", AuthorID: 1}
+	if err := db.Create(&doc).Error; err != nil {
+		t.Fatalf("Failed to create documentation: %v", err)
+	}
+
+	user := models.User{ID: 1}
+
+	result, err := TestDocService.ImportMarkdownDirectory(user, dir, MarkdownImportOptions{DocumentationID: doc.ID}, TestConfig)
+	if err != nil {
+		t.Fatalf("ImportMarkdownDirectory returned an error: %v", err)
+	}
+
+	if result.PageGroups != 2 || result.Pages != 7 {
+		t.Errorf("Expected 2 page groups and 7 pages, got %+v", result)
+	}
+
+	var imported []models.Page
+	if err := db.Where("documentation_id = ?", doc.ID).Find(&imported).Error; err != nil {
+		t.Fatalf("Failed to fetch pages: %v", err)
+	}
+
+	for _, page := range imported {
+		var revisions []models.PageRevision
+		if err := db.Where("page_id = ?", page.ID).Find(&revisions).Error; err != nil {
+			t.Fatalf("Failed to fetch revisions: %v", err)
+		}
+		if len(revisions) != 1 || page.PublishedRevisionID == nil || *page.PublishedRevisionID != revisions[0].ID {
+			t.Errorf("Expected %s to have one published revision, got %d", page.Slug, len(revisions))
+		}
+	}
+
+	var rootPages []models.Page
+	if err := db.Where("documentation_id = ? AND page_group_id IS NULL", doc.ID).Order("`order`").Find(&rootPages).Error; err != nil {
+		t.Fatalf("Failed to fetch pages: %v", err)
+	}
+
+	expected := []struct{ title, slug string }{
+		{"Welcome", "/index"},
+		{"Alpha Label", "/alpha"},
+		{"Zeta Page", "/zeta"},
+	}
+
+	if len(rootPages) != len(expected) {
+		t.Fatalf("Expected %d root pages, got %d", len(expected), len(rootPages))
+	}
+
+	for i, page := range rootPages {
+		if page.Title != expected[i].title || page.Slug != expected[i].slug {
+			t.Errorf("Root page %d = (%q, %q), want (%q, %q)", i, page.Title, page.Slug, expected[i].title, expected[i].slug)
+		}
+	}
+
+	var guide models.PageGroup
+	if err := db.Where("documentation_id = ? AND name = ?", doc.ID, "Guide").First(&guide).Error; err != nil {
+		t.Fatalf("Guide page group was not created: %v", err)
+	}
+
+	if guide.Order == nil || *guide.Order != 1 {
+		t.Errorf("Expected guide to follow the index page, got %v", guide.Order)
+	}
+
+	var guidePages []models.Page
+	if err := db.Where("page_group_id = ?", guide.ID).Order("`order`").Find(&guidePages).Error; err != nil {
+		t.Fatalf("Failed to fetch guide pages: %v", err)
+	}
+
+	if len(guidePages) != 2 || guidePages[0].Slug != "/guide/setup" || guidePages[1].Slug != "/guide/intro" {
+		t.Errorf("Unexpected guide pages: %+v", guidePages)
+	}
+
+	var nestedIndex models.Page
+	if err := db.Where("documentation_id = ? AND slug = ?", doc.ID, "/guide/nested").First(&nestedIndex).Error; err != nil {
+		t.Errorf("Expected nested README to become the group index page: %v", err)
+	}
+
+	t.Run("Conflicting slugs roll back the whole import", func(t *testing.T) {
+		var before int64
+		db.Model(&models.Page{}).Where("documentation_id = ?", doc.ID).Count(&before)
+
+		if _, err := TestDocService.ImportMarkdownDirectory(user, dir, MarkdownImportOptions{DocumentationID: doc.ID}, TestConfig); err == nil {
+			t.Fatalf("Expected a slug conflict error")
+		}
+
+		var after int64
+		db.Model(&models.Page{}).Where("documentation_id = ?", doc.ID).Count(&after)
+
+		if before != after {
+			t.Errorf("Expected no pages to be created, before %d after %d", before, after)
+		}
+	})
+}
@@ -151,31 +151,32 @@ func (service *DocService) GetPageGroup(id uint) (map[string]interface{}, error)
 	groupMap := convertPageGroupToMap(pageGroup)
 	service.recursiveFetchPageGroups(groupMap)
 
+	lock, err := activeLock(service.DB, ResourcePageGroup, pageGroup.ID)
+	if err != nil {
+		return nil, err
+	}
+	groupMap["lock"] = lock
+
 	return groupMap, nil
 }
 
 func (service *DocService) CreatePageGroup(group *models.PageGroup) (uint, error) {
-	if err := service.DB.Create(&group).Error; err != nil {
-		return 0, fmt.Errorf("failed_to_create_page_group")
+	if err := service.requireDocumentationRole(group.AuthorID, group.DocumentationID, RoleEditor); err != nil {
+		return 0, err
 	}
 
-	docId, err := service.GetDocumentationIDOfPageGroup(group.ID)
-	if err != nil {
-		return 0, fmt.Errorf("failed_to_get_documentation_id")
+	if err := service.DB.Create(&group).Error; err != nil {
+		return 0, fmt.Errorf("failed_to_create_page_group")
 	}
 
-	parentDocId, _ := service.GetRootParentID(docId)
-
-	if parentDocId == 0 {
-		err = service.AddBuildTrigger(docId, false)
-	} else {
-		err = service.AddBuildTrigger(parentDocId, false)
+	if err := indexPageGroup(service.DB, *group); err != nil {
+		return 0, err
 	}
 
-	if err != nil {
-		return 0, fmt.Errorf("failed_to_update_write_build")
-	}
+	service.audit("page_group.create", AuditEntityPageGroup, group.ID, nil, pageGroupAuditSummary(*group))
 
+	// New page groups are drafts, the site doesn't change until they're
+	// published.
 	return group.ID, nil
 }
 func (service *DocService) EditPageGroup(user models.User, id uint, name string, documentationID uint, parentID *uint, order *uint) error {
@@ -184,6 +185,16 @@ func (service *DocService) EditPageGroup(user models.User, id uint, name string,
 		return fmt.Errorf("page_group_not_found")
 	}
 
+	for _, docId := range []uint{pageGroup.DocumentationID, documentationID} {
+		if err := service.requireDocumentationRole(user.ID, docId, RoleEditor); err != nil {
+			return err
+		}
+	}
+
+	if err := checkEditLock(service.DB, ResourcePageGroup, pageGroup.ID, user.ID); err != nil {
+		return err
+	}
+
 	var docCount int64
 	if err := service.DB.Model(&models.Documentation{}).Where("id = ?", documentationID).Count(&docCount).Error; err != nil {
 		return fmt.Errorf("failed_to_verify_documentation")
@@ -205,6 +216,9 @@ func (service *DocService) EditPageGroup(user models.User, id uint, name string,
 		}
 	}
 
+	before := pageGroupAuditSummary(pageGroup)
+	previous := pageGroup
+
 	pageGroup.Name = name
 	pageGroup.DocumentationID = documentationID
 	pageGroup.ParentID = parentID
@@ -213,6 +227,10 @@ func (service *DocService) EditPageGroup(user models.User, id uint, name string,
 		pageGroup.Order = order
 	}
 
+	moved := previous.DocumentationID != pageGroup.DocumentationID ||
+		!sameUint(previous.ParentID, pageGroup.ParentID) ||
+		!sameUint(previous.Order, pageGroup.Order)
+
 	pageGroup.LastEditorID = &user.ID
 
 	alreadyEditor := false
@@ -230,6 +248,18 @@ func (service *DocService) EditPageGroup(user models.User, id uint, name string,
 		return fmt.Errorf("failed_to_update_page_group")
 	}
 
+	if err := indexPageGroup(service.DB, pageGroup); err != nil {
+		return err
+	}
+
+	service.audit("page_group.edit", AuditEntityPageGroup, pageGroup.ID, before, pageGroupAuditSummary(pageGroup))
+
+	// A new name waits for the group to be published again, only moving a
+	// published group changes the site.
+	if pageGroup.PublishedAt == nil || !moved {
+		return nil
+	}
+
 	docId, err := service.GetDocumentationIDOfPageGroup(id)
 
 	if err != nil {
@@ -251,46 +281,49 @@ func (service *DocService) EditPageGroup(user models.User, id uint, name string,
 	return nil
 }
 
-func (service *DocService) deletePageGroupRecursive(tx *gorm.DB, id uint) error {
-	var pageGroup models.PageGroup
-	if err := tx.Preload("Pages").Preload("Editors").First(&pageGroup, id).Error; err != nil {
-		return fmt.Errorf("page_group_not_found")
+// trashPageGroupRecursive moves a page group into the trash item together
+// with its pages and sub groups, which keep their place in the tree.
+func trashPageGroupRecursive(tx *gorm.DB, trashId uint, id uint) error {
+	if err := trashPages(tx, trashId, "page_group_id = ?", id); err != nil {
+		return err
 	}
 
-	if err := tx.Model(&pageGroup).Association("Editors").Clear(); err != nil {
-		return fmt.Errorf("failed_to_clear_editors: %v", err)
+	var childIds []uint
+	if err := tx.Model(&models.PageGroup{}).Where("parent_id = ?", id).Pluck("id", &childIds).Error; err != nil {
+		return fmt.Errorf("failed_to_find_child_page_groups: %v", err)
 	}
 
-	for _, page := range pageGroup.Pages {
-		if err := tx.Model(&page).Association("Editors").Clear(); err != nil {
-			return fmt.Errorf("failed_to_clear_page_editors: %v", err)
+	for _, childId := range childIds {
+		if err := trashPageGroupRecursive(tx, trashId, childId); err != nil {
+			return err
 		}
 	}
 
-	if err := tx.Where("page_group_id = ?", id).Delete(&models.Page{}).Error; err != nil {
-		return fmt.Errorf("failed_to_delete_associated_pages: %v", err)
-	}
-
-	var childGroups []models.PageGroup
-	if err := tx.Where("parent_id = ?", id).Find(&childGroups).Error; err != nil {
-		return fmt.Errorf("failed_to_find_child_page_groups: %v", err)
+	if err := tx.Model(&models.PageGroup{}).Where("id = ?", id).UpdateColumn("trash_item_id", trashId).Error; err != nil {
+		return fmt.Errorf("failed_to_delete_page_group: %v", err)
 	}
 
-	for _, childGroup := range childGroups {
-		if err := service.deletePageGroupRecursive(tx, childGroup.ID); err != nil {
-			return err
-		}
+	if err := tx.Delete(&models.PageGroup{}, id).Error; err != nil {
+		return fmt.Errorf("failed_to_delete_page_group: %v", err)
 	}
 
-	if err := tx.Delete(&pageGroup).Error; err != nil {
-		return fmt.Errorf("failed_to_delete_page_group: %v", err)
+	if err := removeFromSearchIndex(tx, SearchKindPageGroup, id); err != nil {
+		return err
 	}
 
-	return nil
+	return removeEditLocks(tx, ResourcePageGroup, id)
 }
 
-func (service *DocService) DeletePageGroup(id uint) error {
+// DeletePageGroup moves a page group and everything in it to the trash.
+func (service *DocService) DeletePageGroup(user models.User, id uint) error {
+	if docId, err := service.GetDocumentationIDOfPageGroup(id); err == nil {
+		if err := service.requireDocumentationRole(user.ID, docId, RoleMaintainer); err != nil {
+			return err
+		}
+	}
+
 	var docId uint
+	var pageGroup models.PageGroup
 	var err error
 
 	err = service.DB.Transaction(func(tx *gorm.DB) error {
@@ -299,7 +332,16 @@ func (service *DocService) DeletePageGroup(id uint) error {
 			return fmt.Errorf("failed_to_get_documentation_id: %v", err)
 		}
 
-		if err := service.deletePageGroupRecursive(tx, id); err != nil {
+		if err := tx.First(&pageGroup, id).Error; err != nil {
+			return fmt.Errorf("page_group_not_found")
+		}
+
+		item, err := service.createTrashItem(tx, ResourcePageGroup, pageGroup.ID, pageGroup.DocumentationID, pageGroup.Name)
+		if err != nil {
+			return err
+		}
+
+		if err := trashPageGroupRecursive(tx, item.ID, id); err != nil {
 			return err
 		}
 
@@ -310,6 +352,8 @@ func (service *DocService) DeletePageGroup(id uint) error {
 		return err
 	}
 
+	service.audit("page_group.delete", AuditEntityPageGroup, id, pageGroupAuditSummary(pageGroup), nil)
+
 	parentDocId, _ := service.GetRootParentID(docId)
 	triggerDocId := docId
 	if parentDocId != 0 {
@@ -341,6 +385,8 @@ func (service *DocService) ReorderPageGroup(id uint, order *uint, parentID *uint
 		return fmt.Errorf("failed_to_fetch_page_group")
 	}
 
+	before := pageGroupAuditSummary(pageGroup)
+
 	pageGroup.Order = order
 	pageGroup.ParentID = parentID
 
@@ -348,6 +394,8 @@ func (service *DocService) ReorderPageGroup(id uint, order *uint, parentID *uint
 		return fmt.Errorf("failed_to_update_page_group")
 	}
 
+	service.audit("page_group.reorder", AuditEntityPageGroup, pageGroup.ID, before, pageGroupAuditSummary(pageGroup))
+
 	docId, err := service.GetDocumentationIDOfPageGroup(id)
 
 	if err != nil {
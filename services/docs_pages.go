@@ -15,7 +15,7 @@ func (service *DocService) GetPages() ([]models.Page, error) {
 		return service.DB.Select("ID", "Username", "Email", "Photo")
 	}).Preload("Editors", func(db *gorm.DB) *gorm.DB {
 		return service.DB.Select("users.ID", "users.Username", "users.Email", "users.Photo")
-	}).Select("ID", "Title", "Slug", "DocumentationID", "PageGroupID", "Order", "CreatedAt", "UpdatedAt", "AuthorID", "LastEditorID", "IsIntroPage", "IsPage").
+	}).Select("ID", "Title", "Slug", "DocumentationID", "PageGroupID", "Order", "CreatedAt", "UpdatedAt", "AuthorID", "LastEditorID", "IsIntroPage", "IsPage", "PublishedRevisionID", "PublishedAt", "PublishAt", "UnpublishAt", "Version", "Language", "TranslationOfID", "SourceVersion").
 		Find(&pages).Error; err != nil {
 		return nil, fmt.Errorf("failed_to_get_pages")
 	}
@@ -38,44 +38,102 @@ func (service *DocService) GetPage(id uint) (models.Page, error) {
 		}
 	}
 
+	lock, err := activeLock(service.DB, ResourcePage, page.ID)
+	if err != nil {
+		return models.Page{}, err
+	}
+	page.Lock = lock
+
 	return page, nil
 }
 
 func (service *DocService) CreatePage(page *models.Page) error {
-	if err := service.DB.Create(&page).Error; err != nil {
+	if err := service.requireDocumentationRole(page.AuthorID, page.DocumentationID, RoleEditor); err != nil {
+		return err
+	}
+
+	if err := createPageDraft(service.DB, page); err != nil {
+		return err
+	}
+
+	return service.pageCreated(*page)
+}
+
+// createPageDraft creates a page with its first revision.
+func createPageDraft(tx *gorm.DB, page *models.Page) error {
+	if err := tx.Create(page).Error; err != nil {
 		return fmt.Errorf("failed_to_create_page")
 	}
 
+	if err := createPageRevision(tx, *page, page.AuthorID, nil); err != nil {
+		return err
+	}
+
+	return indexPage(tx, *page)
+}
+
+// pageCreated tells the audit log and webhooks about a page once it's
+// created.
+func (service *DocService) pageCreated(page models.Page) error {
 	docId, err := service.GetDocumentationIDOfPage(page.ID)
 
 	if err != nil {
 		return fmt.Errorf("failed_to_get_documentation_id")
 	}
 
-	parentDocId, _ := service.GetRootParentID(docId)
+	service.audit("page.create", AuditEntityPage, page.ID, nil, pageAuditSummary(page))
+	service.emitWebhookEvent(docId, WebhookEventPageCreated, newWebhookPage(page, docId, page.AuthorID))
 
-	if parentDocId == 0 {
-		err = service.AddBuildTrigger(docId, false)
-	} else {
-		err = service.AddBuildTrigger(parentDocId, false)
+	// New pages are drafts, the site doesn't change until they're published.
+	return nil
+}
+
+// EditPage saves a new draft of a page. With a version the edit only goes
+// through if nobody saved the page since that version was read.
+func (service *DocService) EditPage(user models.User, id uint, title, slug, content string, order *uint, pageGroupId *uint, version *uint) error {
+	if docId, err := service.GetDocumentationIDOfPage(id); err == nil {
+		if err := service.requireDocumentationRole(user.ID, docId, RoleEditor); err != nil {
+			return err
+		}
 	}
 
+	if err := checkEditLock(service.DB, ResourcePage, id, user.ID); err != nil {
+		return err
+	}
+
+	tx := service.DB.Begin()
+
+	previous, page, err := editPageDraft(tx, user, id, title, slug, content, order, pageGroupId, version)
 	if err != nil {
-		return fmt.Errorf("failed_to_update_write_build")
+		tx.Rollback()
+		return err
 	}
 
-	return nil
-}
+	if err := tx.Commit().Error; err != nil {
+		return fmt.Errorf("failed_to_commit_changes")
+	}
 
-func (service *DocService) EditPage(user models.User, id uint, title, slug, content string, order *uint, pageGroupId *uint) error {
-	tx := service.DB.Begin()
+	return service.pageEdited(user, previous, page)
+}
 
+// editPageDraft saves the new draft of a page, see EditPage. It returns the
+// page as it was and as it is now.
+func editPageDraft(tx *gorm.DB, user models.User, id uint, title, slug, content string, order *uint, pageGroupId *uint, version *uint) (models.Page, models.Page, error) {
 	var page models.Page
 	if err := tx.Preload("Editors").First(&page, id).Error; err != nil {
-		tx.Rollback()
-		return fmt.Errorf("page_not_found")
+		return models.Page{}, models.Page{}, fmt.Errorf("page_not_found")
+	}
+
+	if version != nil && *version != page.Version {
+		return models.Page{}, models.Page{}, fmt.Errorf("page_version_conflict")
+	}
+
+	if err := bumpPageVersion(tx, &page); err != nil {
+		return models.Page{}, models.Page{}, err
 	}
 
+	previous := page
+
 	page.Title = title
 	page.Slug = slug
 
@@ -104,21 +162,49 @@ func (service *DocService) EditPage(user models.User, id uint, title, slug, cont
 		page.Editors = append(page.Editors, user)
 	}
 
+	if page.TranslationOfID != nil {
+		// Translations are built wherever their page is, never in a group.
+		page.PageGroupID = nil
+
+		if err := syncTranslation(tx, &page); err != nil {
+			return models.Page{}, models.Page{}, err
+		}
+	}
+
 	if err := tx.Save(&page).Error; err != nil {
-		tx.Rollback()
-		return fmt.Errorf("failed_to_update_page")
+		return models.Page{}, models.Page{}, fmt.Errorf("failed_to_update_page")
 	}
 
-	if err := tx.Commit().Error; err != nil {
-		return fmt.Errorf("failed_to_commit_changes")
+	if err := createPageRevision(tx, page, user.ID, nil); err != nil {
+		return models.Page{}, models.Page{}, err
 	}
 
-	docId, err := service.GetDocumentationIDOfPage(id)
+	if err := indexPage(tx, page); err != nil {
+		return models.Page{}, models.Page{}, err
+	}
+
+	return previous, page, nil
+}
+
+// pageEdited tells the audit log and webhooks about an edit once it's saved,
+// and rebuilds the site if the edit moved a published page.
+func (service *DocService) pageEdited(user models.User, previous, page models.Page) error {
+	docId, err := service.GetDocumentationIDOfPage(page.ID)
 
 	if err != nil {
 		return fmt.Errorf("failed_to_get_documentation_id")
 	}
 
+	service.audit("page.edit", AuditEntityPage, page.ID, pageAuditSummary(previous), pageAuditSummary(page))
+	service.emitWebhookEvent(docId, WebhookEventPageEdited, newWebhookPage(page, docId, user.ID))
+
+	// The edit is a draft until the page is published again, only moving a
+	// published page changes the site.
+	moved := !sameUint(previous.Order, page.Order) || !sameUint(previous.PageGroupID, page.PageGroupID)
+	if page.PublishedRevisionID == nil || !moved {
+		return nil
+	}
+
 	parentDocId, _ := service.GetRootParentID(docId)
 
 	if parentDocId == 0 {
@@ -134,8 +220,34 @@ func (service *DocService) EditPage(user models.User, id uint, title, slug, cont
 	return nil
 }
 
-func (service *DocService) DeletePage(id uint) error {
+// bumpPageVersion moves the page to its next version, failing if another
+// save got there first.
+func bumpPageVersion(tx *gorm.DB, page *models.Page) error {
+	result := tx.Model(&models.Page{}).
+		Where("id = ? AND version = ?", page.ID, page.Version).
+		UpdateColumn("version", gorm.Expr("version + 1"))
+
+	if result.Error != nil {
+		return fmt.Errorf("failed_to_update_page")
+	}
+
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("page_version_conflict")
+	}
+
+	page.Version++
+	return nil
+}
+
+// DeletePage moves a page to the trash, see PurgeTrashItem for deleting it
+// for good.
+func (service *DocService) DeletePage(user models.User, id uint) error {
 	docId, err := service.GetDocumentationIDOfPage(id)
+	if err == nil {
+		if err := service.requireDocumentationRole(user.ID, docId, RoleMaintainer); err != nil {
+			return err
+		}
+	}
 
 	tx := service.DB.Begin()
 	if tx.Error != nil {
@@ -151,23 +263,28 @@ func (service *DocService) DeletePage(id uint) error {
 		return fmt.Errorf("failed_to_fetch_page")
 	}
 
-	if err := tx.Model(&page).Association("Editors").Clear(); err != nil {
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed_to_get_documentation_id")
+	}
+
+	item, err := service.createTrashItem(tx, ResourcePage, page.ID, page.DocumentationID, page.Title)
+	if err != nil {
 		tx.Rollback()
-		return fmt.Errorf("failed_to_clear_page_associations")
+		return err
 	}
 
-	if err := tx.Delete(&page).Error; err != nil {
+	if err := trashPages(tx, item.ID, "id = ?", page.ID); err != nil {
 		tx.Rollback()
-		return fmt.Errorf("failed_to_delete_page")
+		return err
 	}
 
 	if err := tx.Commit().Error; err != nil {
 		return fmt.Errorf("transaction_commit_failed")
 	}
 
-	if err != nil {
-		return fmt.Errorf("failed_to_get_documentation_id")
-	}
+	service.audit("page.delete", AuditEntityPage, page.ID, pageAuditSummary(page), nil)
+	service.emitWebhookEvent(docId, WebhookEventPageDeleted, newWebhookPage(page, docId, 0))
 
 	parentDocId, _ := service.GetRootParentID(docId)
 
@@ -193,6 +310,8 @@ func (service *DocService) ReorderPage(id uint, pageGroupID *uint, order *uint)
 		return fmt.Errorf("failed_to_fetch_page")
 	}
 
+	before := pageAuditSummary(page)
+
 	page.PageGroupID = pageGroupID
 	page.Order = order
 
@@ -200,6 +319,8 @@ func (service *DocService) ReorderPage(id uint, pageGroupID *uint, order *uint)
 		return fmt.Errorf("failed_to_update_page")
 	}
 
+	service.audit("page.reorder", AuditEntityPage, page.ID, before, pageAuditSummary(page))
+
 	docId, err := service.GetDocumentationIDOfPage(id)
 
 	if err != nil {
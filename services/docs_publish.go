@@ -0,0 +1,433 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"git.difuse.io/Difuse/kalmia/db/models"
+	"gorm.io/gorm"
+)
+
+// publishPageContent publishes what the page holds right now. Every edit
+// records a revision, so that is normally the newest one; a page without a
+// matching revision gets one first.
+func publishPageContent(tx *gorm.DB, page models.Page, userId uint) (uint, error) {
+	var revision models.PageRevision
+	err := tx.Where("page_id = ?", page.ID).Order("id DESC").First(&revision).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, fmt.Errorf("failed_to_get_page_revision")
+	}
+
+	if err != nil || revision.Title != page.Title || revision.Slug != page.Slug || revision.Content != page.Content {
+		if err := createPageRevision(tx, page, userId, nil); err != nil {
+			return 0, err
+		}
+
+		if err := tx.Where("page_id = ?", page.ID).Order("id DESC").First(&revision).Error; err != nil {
+			return 0, fmt.Errorf("failed_to_get_page_revision")
+		}
+	}
+
+	if err := tx.Model(&models.Page{}).Where("id = ?", page.ID).UpdateColumns(map[string]interface{}{
+		"published_revision_id": revision.ID,
+		"published_at":          time.Now().UTC(),
+	}).Error; err != nil {
+		return 0, fmt.Errorf("failed_to_publish_page")
+	}
+
+	return revision.ID, nil
+}
+
+// checkPublishedSlugs makes sure no two published pages of a documentation
+// version share a slug. Draft slugs are unique, but a published slug comes
+// from a revision, so a page renamed since it was published keeps its old
+// slug on the site and another page can't be published under it.
+func checkPublishedSlugs(tx *gorm.DB, docId uint) error {
+	var slugs []string
+	if err := tx.Model(&models.Page{}).
+		Joins("JOIN page_revisions ON page_revisions.id = pages.published_revision_id").
+		Where("pages.documentation_id = ?", docId).
+		Group("page_revisions.slug").
+		Having("COUNT(*) > 1").
+		Limit(1).
+		Pluck("page_revisions.slug", &slugs).Error; err != nil {
+		return fmt.Errorf("failed_to_check_slug")
+	}
+
+	if len(slugs) > 0 {
+		return fmt.Errorf("slug_already_in_use")
+	}
+
+	return nil
+}
+
+func publishPageGroups(tx *gorm.DB, query string, args ...interface{}) (int64, error) {
+	result := tx.Model(&models.PageGroup{}).Where(query, args...).UpdateColumns(map[string]interface{}{
+		"published_name": gorm.Expr("name"),
+		"published_at":   time.Now().UTC(),
+	})
+
+	if result.Error != nil {
+		return 0, fmt.Errorf("failed_to_publish_page_group")
+	}
+
+	return result.RowsAffected, nil
+}
+
+// copyPublishedRevision gives a copied page its own copy of the revision the
+// original publishes, so both render the same content. The copy is published
+// under a slug of its own, see publishedSlug.
+func copyPublishedRevision(tx *gorm.DB, from models.Page, to models.Page) error {
+	if from.PublishedRevisionID == nil {
+		return nil
+	}
+
+	var revision models.PageRevision
+	if err := tx.First(&revision, *from.PublishedRevisionID).Error; err != nil {
+		return fmt.Errorf("page_revision_not_found")
+	}
+
+	slug, err := publishedSlug(tx, to.DocumentationID, to.ID, from.Slug, to.Slug, revision.Slug)
+	if err != nil {
+		return err
+	}
+
+	copied := models.PageRevision{
+		PageID:          to.ID,
+		DocumentationID: to.DocumentationID,
+		EditorID:        revision.EditorID,
+		Title:           revision.Title,
+		Slug:            slug,
+		Content:         revision.Content,
+	}
+
+	if err := tx.Omit("Editor").Create(&copied).Error; err != nil {
+		return fmt.Errorf("failed_to_create_page_revision")
+	}
+
+	if err := tx.Model(&models.Page{}).Where("id = ?", to.ID).UpdateColumns(map[string]interface{}{
+		"published_revision_id": copied.ID,
+		"published_at":          from.PublishedAt,
+	}).Error; err != nil {
+		return fmt.Errorf("failed_to_publish_page")
+	}
+
+	return nil
+}
+
+// publishedPages returns the published pages among pages, with the title,
+// slug and content of their published revision in place of the draft. They
+// were last updated when that revision was made, later draft edits don't
+// count.
+func (service *DocService) publishedPages(pages []models.Page) ([]models.Page, error) {
+	published := make([]models.Page, 0, len(pages))
+	if len(pages) == 0 {
+		return published, nil
+	}
+
+	ids := make([]uint, 0, len(pages))
+	for _, page := range pages {
+		ids = append(ids, page.ID)
+	}
+
+	if err := service.DB.Where("id IN ? AND published_revision_id IS NOT NULL", ids).Find(&published).Error; err != nil {
+		return nil, fmt.Errorf("failed_to_get_pages")
+	}
+
+	revisionIds := make([]uint, 0, len(published))
+	for _, page := range published {
+		revisionIds = append(revisionIds, *page.PublishedRevisionID)
+	}
+
+	var revisions []models.PageRevision
+	if err := service.DB.Where("id IN ?", revisionIds).Find(&revisions).Error; err != nil {
+		return nil, fmt.Errorf("failed_to_get_page_revisions")
+	}
+
+	byId := make(map[uint]models.PageRevision, len(revisions))
+	for _, revision := range revisions {
+		byId[revision.ID] = revision
+	}
+
+	for i := range published {
+		revision, ok := byId[*published[i].PublishedRevisionID]
+		if !ok {
+			return nil, fmt.Errorf("page_revision_not_found")
+		}
+
+		published[i].Title = revision.Title
+		published[i].Slug = revision.Slug
+		published[i].Content = revision.Content
+		published[i].UpdatedAt = revision.CreatedAt
+	}
+
+	return published, nil
+}
+
+func (service *DocService) getPublishedPage(id uint) (models.Page, error) {
+	pages, err := service.publishedPages([]models.Page{{ID: id}})
+	if err != nil {
+		return models.Page{}, err
+	}
+
+	if len(pages) == 0 {
+		return models.Page{}, fmt.Errorf("page_not_published")
+	}
+
+	return pages[0], nil
+}
+
+func sameUint(a, b *uint) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	return *a == *b
+}
+
+// publishedPageGroups drops the unpublished groups and names the rest by
+// their published name.
+func publishedPageGroups(groups []models.PageGroup) []models.PageGroup {
+	published := make([]models.PageGroup, 0, len(groups))
+	for _, group := range groups {
+		if group.PublishedAt == nil {
+			continue
+		}
+
+		group.Name = group.PublishedName
+		published = append(published, group)
+	}
+
+	return published
+}
+
+func (service *DocService) getPageForPublishing(user models.User, id uint) (models.Page, error) {
+	var page models.Page
+	if err := service.DB.First(&page, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return models.Page{}, fmt.Errorf("page_not_found")
+		}
+		return models.Page{}, fmt.Errorf("failed_to_fetch_page")
+	}
+
+	if err := service.requireDocumentationRole(user.ID, page.DocumentationID, RoleEditor); err != nil {
+		return models.Page{}, err
+	}
+
+	return page, nil
+}
+
+// PublishPage makes the page's current content the one the public site
+// shows.
+func (service *DocService) PublishPage(user models.User, id uint) error {
+	page, err := service.getPageForPublishing(user, id)
+	if err != nil {
+		return err
+	}
+
+	var revisionId uint
+	err = service.DB.Transaction(func(tx *gorm.DB) error {
+		if err := service.checkPublishLinks(tx, page.DocumentationID, []models.Page{page}); err != nil {
+			return err
+		}
+
+		if revisionId, err = publishPageContent(tx, page, user.ID); err != nil {
+			return err
+		}
+
+		return checkPublishedSlugs(tx, page.DocumentationID)
+	})
+	if err != nil {
+		return err
+	}
+
+	service.audit("page.publish", AuditEntityPage, page.ID,
+		map[string]interface{}{"publishedRevisionId": page.PublishedRevisionID},
+		map[string]interface{}{"publishedRevisionId": revisionId})
+	service.emitWebhookEvent(page.DocumentationID, WebhookEventPagePublished, newWebhookPage(page, page.DocumentationID, user.ID))
+
+	if err := service.triggerRootBuild(page.DocumentationID); err != nil {
+		return fmt.Errorf("failed_to_update_write_build")
+	}
+
+	return nil
+}
+
+// UnpublishPage takes the page off the public site, its draft is kept.
+func (service *DocService) UnpublishPage(user models.User, id uint) error {
+	page, err := service.getPageForPublishing(user, id)
+	if err != nil {
+		return err
+	}
+
+	if page.PublishedRevisionID == nil {
+		return fmt.Errorf("page_not_published")
+	}
+
+	if err := service.DB.Model(&models.Page{}).Where("id = ?", page.ID).UpdateColumns(map[string]interface{}{
+		"published_revision_id": nil,
+		"published_at":          nil,
+	}).Error; err != nil {
+		return fmt.Errorf("failed_to_unpublish_page")
+	}
+
+	service.audit("page.unpublish", AuditEntityPage, page.ID, map[string]interface{}{"publishedRevisionId": *page.PublishedRevisionID}, nil)
+	service.emitWebhookEvent(page.DocumentationID, WebhookEventPageUnpublished, newWebhookPage(page, page.DocumentationID, user.ID))
+
+	if err := service.triggerRootBuild(page.DocumentationID); err != nil {
+		return fmt.Errorf("failed_to_update_write_build")
+	}
+
+	return nil
+}
+
+func (service *DocService) getPageGroupForPublishing(user models.User, id uint) (models.PageGroup, error) {
+	var group models.PageGroup
+	if err := service.DB.First(&group, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return models.PageGroup{}, fmt.Errorf("page_group_not_found")
+		}
+		return models.PageGroup{}, fmt.Errorf("failed_to_fetch_page_group")
+	}
+
+	if err := service.requireDocumentationRole(user.ID, group.DocumentationID, RoleEditor); err != nil {
+		return models.PageGroup{}, err
+	}
+
+	return group, nil
+}
+
+// PublishPageGroup shows the group on the public site under its current
+// name. The pages in it are published on their own.
+func (service *DocService) PublishPageGroup(user models.User, id uint) error {
+	group, err := service.getPageGroupForPublishing(user, id)
+	if err != nil {
+		return err
+	}
+
+	if _, err := publishPageGroups(service.DB, "id = ?", group.ID); err != nil {
+		return err
+	}
+
+	service.audit("page_group.publish", AuditEntityPageGroup, group.ID,
+		map[string]interface{}{"publishedName": group.PublishedName, "published": group.PublishedAt != nil},
+		map[string]interface{}{"publishedName": group.Name, "published": true})
+
+	if err := service.triggerRootBuild(group.DocumentationID); err != nil {
+		return fmt.Errorf("failed_to_update_write_build")
+	}
+
+	return nil
+}
+
+// UnpublishPageGroup takes the group, and with it everything inside it, off
+// the public site.
+func (service *DocService) UnpublishPageGroup(user models.User, id uint) error {
+	group, err := service.getPageGroupForPublishing(user, id)
+	if err != nil {
+		return err
+	}
+
+	if group.PublishedAt == nil {
+		return fmt.Errorf("page_group_not_published")
+	}
+
+	if err := service.DB.Model(&models.PageGroup{}).Where("id = ?", group.ID).UpdateColumns(map[string]interface{}{
+		"published_at": nil,
+	}).Error; err != nil {
+		return fmt.Errorf("failed_to_unpublish_page_group")
+	}
+
+	service.audit("page_group.unpublish", AuditEntityPageGroup, group.ID, map[string]interface{}{"publishedName": group.PublishedName}, nil)
+
+	if err := service.triggerRootBuild(group.DocumentationID); err != nil {
+		return fmt.Errorf("failed_to_update_write_build")
+	}
+
+	return nil
+}
+
+type PublishResult struct {
+	Pages      int   `json:"pages"`
+	PageGroups int64 `json:"pageGroups"`
+}
+
+// publishDocumentationContent publishes every page and page group of a
+// documentation version, unless the link check blocks it.
+func (service *DocService) publishDocumentationContent(tx *gorm.DB, docId uint, userId uint) (PublishResult, error) {
+	if err := service.checkPublishLinks(tx, docId, nil); err != nil {
+		return PublishResult{}, err
+	}
+
+	var pages []models.Page
+	if err := tx.Where("documentation_id = ?", docId).Find(&pages).Error; err != nil {
+		return PublishResult{}, fmt.Errorf("failed_to_get_pages")
+	}
+
+	for _, page := range pages {
+		if _, err := publishPageContent(tx, page, userId); err != nil {
+			return PublishResult{}, err
+		}
+	}
+
+	if err := checkPublishedSlugs(tx, docId); err != nil {
+		return PublishResult{}, err
+	}
+
+	groups, err := publishPageGroups(tx, "documentation_id = ?", docId)
+	if err != nil {
+		return PublishResult{}, err
+	}
+
+	return PublishResult{Pages: len(pages), PageGroups: groups}, nil
+}
+
+// unpublishDocumentationContent takes every page and page group of a
+// documentation version off the site.
+func unpublishDocumentationContent(tx *gorm.DB, docId uint) (PublishResult, error) {
+	pages := tx.Model(&models.Page{}).Where("documentation_id = ? AND published_revision_id IS NOT NULL", docId).UpdateColumns(map[string]interface{}{
+		"published_revision_id": nil,
+		"published_at":          nil,
+	})
+	if pages.Error != nil {
+		return PublishResult{}, fmt.Errorf("failed_to_unpublish_page")
+	}
+
+	groups := tx.Model(&models.PageGroup{}).Where("documentation_id = ? AND published_at IS NOT NULL", docId).UpdateColumn("published_at", nil)
+	if groups.Error != nil {
+		return PublishResult{}, fmt.Errorf("failed_to_unpublish_page_group")
+	}
+
+	return PublishResult{Pages: int(pages.RowsAffected), PageGroups: groups.RowsAffected}, nil
+}
+
+// PublishDocumentation publishes every page and page group of one
+// documentation version at once.
+func (service *DocService) PublishDocumentation(user models.User, docId uint) (PublishResult, error) {
+	if !service.IsDocIdValid(docId) {
+		return PublishResult{}, fmt.Errorf("documentation_not_found")
+	}
+
+	if err := service.requireDocumentationRole(user.ID, docId, RoleMaintainer); err != nil {
+		return PublishResult{}, err
+	}
+
+	var result PublishResult
+	err := service.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		result, err = service.publishDocumentationContent(tx, docId, user.ID)
+		return err
+	})
+	if err != nil {
+		return PublishResult{}, err
+	}
+
+	service.audit("documentation.publish", AuditEntityDocumentation, docId, nil, result)
+
+	if err := service.triggerRootBuild(docId); err != nil {
+		return result, fmt.Errorf("failed_to_update_write_build")
+	}
+
+	return result, nil
+}
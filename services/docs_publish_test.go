@@ -0,0 +1,201 @@
+package services
+
+import (
+	"testing"
+
+	"git.difuse.io/Difuse/kalmia/db/models"
+)
+
+func TestPublishing(t *testing.T) {
+	if TestDocService == nil {
+		t.Fatal("TestDocService is nil")
+	}
+
+	db := TestDocService.DB
+
+	doc := models.Documentation{Name: "Publishing", Version: "1.0.0", BaseURL: "/publishing", AuthorID: 1}
+	if err := db.Create(&doc).Error; err != nil {
+		t.Fatalf("Failed to create documentation: %v", err)
+	}
+
+	user := models.User{ID: 1}
+
+	page := models.Page{DocumentationID: doc.ID, Title: "Draft", Slug: "/draft", Content: "[]", AuthorID: 1}
+	if err := TestDocService.CreatePage(&page); err != nil {
+		t.Fatalf("CreatePage returned an error: %v", err)
+	}
+
+	group := models.PageGroup{DocumentationID: doc.ID, Name: "Guides", AuthorID: 1}
+	if _, err := TestDocService.CreatePageGroup(&group); err != nil {
+		t.Fatalf("CreatePageGroup returned an error: %v", err)
+	}
+
+	t.Run("New content is a draft", func(t *testing.T) {
+		if _, err := TestDocService.getPublishedPage(page.ID); err == nil || err.Error() != "page_not_published" {
+			t.Errorf("Expected page_not_published, got %v", err)
+		}
+
+		var stored models.PageGroup
+		if err := db.First(&stored, group.ID).Error; err != nil {
+			t.Fatalf("Failed to get page group: %v", err)
+		}
+
+		if len(publishedPageGroups([]models.PageGroup{stored})) != 0 {
+			t.Error("Expected a new page group to be unpublished")
+		}
+	})
+
+	t.Run("Published content survives later edits", func(t *testing.T) {
+		if err := TestDocService.PublishPage(user, page.ID); err != nil {
+			t.Fatalf("PublishPage returned an error: %v", err)
+		}
+
+		if err := TestDocService.EditPage(user, page.ID, "Work in progress", "/draft", "[]", nil, nil, nil); err != nil {
+			t.Fatalf("EditPage returned an error: %v", err)
+		}
+
+		published, err := TestDocService.getPublishedPage(page.ID)
+		if err != nil {
+			t.Fatalf("getPublishedPage returned an error: %v", err)
+		}
+
+		if published.Title != "Draft" {
+			t.Errorf("Expected the published title to stay Draft, got %q", published.Title)
+		}
+
+		if err := TestDocService.PublishPage(user, page.ID); err != nil {
+			t.Fatalf("PublishPage returned an error: %v", err)
+		}
+
+		published, err = TestDocService.getPublishedPage(page.ID)
+		if err != nil {
+			t.Fatalf("getPublishedPage returned an error: %v", err)
+		}
+
+		if published.Title != "Work in progress" {
+			t.Errorf("Expected the republished title, got %q", published.Title)
+		}
+	})
+
+	t.Run("Unpublish keeps the draft", func(t *testing.T) {
+		if err := TestDocService.UnpublishPage(user, page.ID); err != nil {
+			t.Fatalf("UnpublishPage returned an error: %v", err)
+		}
+
+		if err := TestDocService.UnpublishPage(user, page.ID); err == nil || err.Error() != "page_not_published" {
+			t.Errorf("Expected page_not_published, got %v", err)
+		}
+
+		var stored models.Page
+		if err := db.First(&stored, page.ID).Error; err != nil {
+			t.Fatalf("Failed to get page: %v", err)
+		}
+
+		if stored.PublishedRevisionID != nil || stored.Title != "Work in progress" {
+			t.Errorf("Expected an unpublished draft, got %+v", stored)
+		}
+	})
+
+	t.Run("Page groups publish under their current name", func(t *testing.T) {
+		if err := TestDocService.PublishPageGroup(user, group.ID); err != nil {
+			t.Fatalf("PublishPageGroup returned an error: %v", err)
+		}
+
+		if err := db.Model(&models.PageGroup{}).Where("id = ?", group.ID).Update("name", "Renamed").Error; err != nil {
+			t.Fatalf("Failed to rename page group: %v", err)
+		}
+
+		var stored models.PageGroup
+		if err := db.First(&stored, group.ID).Error; err != nil {
+			t.Fatalf("Failed to get page group: %v", err)
+		}
+
+		published := publishedPageGroups([]models.PageGroup{stored})
+		if len(published) != 1 || published[0].Name != "Guides" {
+			t.Errorf("Expected the group published as Guides, got %+v", published)
+		}
+
+		if err := TestDocService.UnpublishPageGroup(user, group.ID); err != nil {
+			t.Fatalf("UnpublishPageGroup returned an error: %v", err)
+		}
+	})
+
+	t.Run("Publishes a whole documentation", func(t *testing.T) {
+		result, err := TestDocService.PublishDocumentation(user, doc.ID)
+		if err != nil {
+			t.Fatalf("PublishDocumentation returned an error: %v", err)
+		}
+
+		if result.Pages != 1 || result.PageGroups != 1 {
+			t.Errorf("Expected 1 page and 1 page group, got %+v", result)
+		}
+
+		published, err := TestDocService.getPublishedPage(page.ID)
+		if err != nil || published.Title != "Work in progress" {
+			t.Errorf("Expected the page to be published, got %+v (%v)", published, err)
+		}
+	})
+
+	t.Run("New versions keep the draft and what's published", func(t *testing.T) {
+		if err := TestDocService.EditPage(user, page.ID, "Unreleased", "/draft", "[]", nil, nil, nil); err != nil {
+			t.Fatalf("EditPage returned an error: %v", err)
+		}
+
+		if err := TestDocService.CreateDocumentationVersion(doc.ID, "2.0.0"); err != nil {
+			t.Fatalf("CreateDocumentationVersion returned an error: %v", err)
+		}
+
+		var cloned models.Page
+		if err := db.Joins("JOIN documentations ON documentations.id = pages.documentation_id").
+			Where("documentations.cloned_from = ? AND pages.slug = ?", doc.ID, "/draft").First(&cloned).Error; err != nil {
+			t.Fatalf("Failed to get cloned page: %v", err)
+		}
+
+		var latest models.PageRevision
+		if err := db.Where("page_id = ?", cloned.ID).Order("id DESC").First(&latest).Error; err != nil || latest.Title != "Unreleased" {
+			t.Errorf("Expected the draft to be the latest revision, got %+v (%v)", latest, err)
+		}
+
+		published, err := TestDocService.getPublishedPage(cloned.ID)
+		if err != nil || published.Title != "Work in progress" {
+			t.Errorf("Expected the clone to publish what the original does, got %+v (%v)", published, err)
+		}
+	})
+
+	t.Run("Refuses to publish under a slug that is still published", func(t *testing.T) {
+		renamed := models.Page{DocumentationID: doc.ID, Title: "Old home", Slug: "/home", Content: "[]", AuthorID: 1}
+		if err := TestDocService.CreatePage(&renamed); err != nil {
+			t.Fatalf("CreatePage returned an error: %v", err)
+		}
+
+		if err := TestDocService.PublishPage(user, renamed.ID); err != nil {
+			t.Fatalf("PublishPage returned an error: %v", err)
+		}
+
+		if err := TestDocService.EditPage(user, renamed.ID, "Old home", "/old-home", "", nil, nil, nil); err != nil {
+			t.Fatalf("EditPage returned an error: %v", err)
+		}
+
+		home := models.Page{DocumentationID: doc.ID, Title: "Home", Slug: "/home", Content: "[]", AuthorID: 1}
+		if err := TestDocService.CreatePage(&home); err != nil {
+			t.Fatalf("CreatePage returned an error: %v", err)
+		}
+
+		if err := TestDocService.PublishPage(user, home.ID); err == nil || err.Error() != "slug_already_in_use" {
+			t.Errorf("Expected slug_already_in_use, got %v", err)
+		}
+
+		if _, err := TestDocService.getPublishedPage(home.ID); err == nil || err.Error() != "page_not_published" {
+			t.Errorf("Expected the page to stay unpublished, got %v", err)
+		}
+
+		if _, err := TestDocService.PublishDocumentation(user, doc.ID); err != nil {
+			t.Fatalf("Expected publishing both pages to free the slug, got %v", err)
+		}
+
+		published, err := TestDocService.getPublishedPage(home.ID)
+		if err != nil || published.Slug != "/home" {
+			t.Errorf("Expected the page to be published under /home, got %+v (%v)", published, err)
+		}
+	})
+}
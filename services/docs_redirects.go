@@ -0,0 +1,373 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"git.difuse.io/Difuse/kalmia/db/models"
+	"gorm.io/gorm"
+)
+
+// normalizeSitePath turns a request path, relative to the base URL of a
+// site, into the form page paths and redirects are stored in.
+func normalizeSitePath(p string) string {
+	if i := strings.IndexAny(p, "?#"); i >= 0 {
+		p = p[:i]
+	}
+
+	p = "/" + strings.Trim(p, "/")
+	if path.Ext(p) == ".html" {
+		p = strings.TrimSuffix(p, ".html")
+	}
+
+	return p
+}
+
+// addAutomaticRedirect sends a path a page was at to wherever the page is
+// now. A custom rule for the path wins over it.
+func addAutomaticRedirect(tx *gorm.DB, docId uint, fromPath string, pageId uint) error {
+	rootId, err := rootDocumentationID(tx, docId)
+	if err != nil {
+		return fmt.Errorf("documentation_not_found")
+	}
+
+	var redirect models.Redirect
+	err = tx.Where("documentation_id = ? AND from_path = ?", docId, fromPath).First(&redirect).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return fmt.Errorf("failed_to_get_redirect")
+	}
+
+	if err == nil && !redirect.Automatic {
+		return nil
+	}
+
+	redirect.DocumentationID = docId
+	redirect.RootDocumentationID = rootId
+	redirect.FromPath = fromPath
+	redirect.ToPath = ""
+	redirect.PageID = &pageId
+	redirect.Automatic = true
+
+	if err := tx.Save(&redirect).Error; err != nil {
+		return fmt.Errorf("failed_to_save_redirect")
+	}
+
+	return nil
+}
+
+// recordPagePaths remembers where a build puts the pages of a site. A page
+// that was somewhere else before leaves an automatic redirect behind, so
+// links to where it was keep working.
+func (service *DocService) recordPagePaths(rootId uint) error {
+	site, err := service.newLinkSite(rootId, 0)
+	if err != nil {
+		return err
+	}
+
+	return service.DB.Transaction(func(tx *gorm.DB) error {
+		for _, version := range site.versions {
+			built := make([]uint, 0, len(version.pagePaths))
+			paths := make([]string, 0, len(version.pagePaths))
+
+			for pageId, pagePath := range version.pagePaths {
+				built = append(built, pageId)
+				paths = append(paths, pagePath)
+
+				var previous models.PagePath
+				err := tx.Where("page_id = ?", pageId).First(&previous).Error
+				if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+					return fmt.Errorf("failed_to_get_page_path")
+				}
+
+				if err == nil && previous.DocumentationID == version.documentationID && previous.Path == pagePath {
+					continue
+				}
+
+				if err == nil {
+					if err := addAutomaticRedirect(tx, previous.DocumentationID, previous.Path, pageId); err != nil {
+						return err
+					}
+				}
+
+				previous.PageID = pageId
+				previous.DocumentationID = version.documentationID
+				previous.Path = pagePath
+
+				if err := tx.Save(&previous).Error; err != nil {
+					return fmt.Errorf("failed_to_save_page_path")
+				}
+			}
+
+			// A page that is at a path now takes it over from the page that
+			// moved away from it.
+			if len(paths) > 0 {
+				if err := tx.Where("documentation_id = ? AND automatic = ? AND from_path IN ?", version.documentationID, true, paths).
+					Delete(&models.Redirect{}).Error; err != nil {
+					return fmt.Errorf("failed_to_delete_redirects")
+				}
+			}
+
+			// Pages of the version that weren't built, unpublished ones, aren't
+			// anywhere anymore.
+			unbuilt := tx.Model(&models.Page{}).Select("id").Where("documentation_id = ?", version.documentationID)
+			if len(built) > 0 {
+				unbuilt = unbuilt.Where("id NOT IN ?", built)
+			}
+
+			if err := tx.Where("documentation_id = ? AND page_id IN (?)", version.documentationID, unbuilt).
+				Delete(&models.PagePath{}).Error; err != nil {
+				return fmt.Errorf("failed_to_delete_page_paths")
+			}
+		}
+
+		return nil
+	})
+}
+
+// sitePageURL returns the URL of a path of a documentation version, under
+// the base URL of its site. The latest version is at the root of the site.
+func (service *DocService) sitePageURL(docId uint, pagePath string) (string, error) {
+	rootId, err := rootDocumentationID(service.DB, docId)
+	if err != nil {
+		return "", fmt.Errorf("documentation_not_found")
+	}
+
+	var root, doc models.Documentation
+	if err := service.DB.Select("id", "base_url").First(&root, rootId).Error; err != nil {
+		return "", fmt.Errorf("documentation_not_found")
+	}
+
+	if err := service.DB.Select("id", "version").First(&doc, docId).Error; err != nil {
+		return "", fmt.Errorf("documentation_not_found")
+	}
+
+	latest, _, err := service.GetAllVersions(rootId)
+	if err != nil {
+		return "", fmt.Errorf("documentation_not_found")
+	}
+
+	prefix := strings.TrimSuffix(root.BaseURL, "/")
+	if doc.Version != latest {
+		prefix += "/" + doc.Version
+	}
+
+	return prefix + pagePath, nil
+}
+
+// redirectTarget returns where a redirect sends its path. ok is false for
+// an automatic redirect whose page isn't on the site anymore.
+func (service *DocService) redirectTarget(redirect models.Redirect) (string, bool) {
+	if !redirect.Automatic {
+		if strings.Contains(redirect.ToPath, "://") {
+			return redirect.ToPath, true
+		}
+
+		// Custom rules name the whole path on the site, version included.
+		var root models.Documentation
+		if err := service.DB.Select("id", "base_url").First(&root, redirect.RootDocumentationID).Error; err != nil {
+			return "", false
+		}
+
+		return strings.TrimSuffix(root.BaseURL, "/") + redirect.ToPath, true
+	}
+
+	if redirect.PageID == nil {
+		return "", false
+	}
+
+	var pagePath models.PagePath
+	if err := service.DB.Where("page_id = ? AND page_id IN (?)", *redirect.PageID, service.DB.Model(&models.Page{}).Select("id")).
+		First(&pagePath).Error; err != nil {
+		return "", false
+	}
+
+	url, err := service.sitePageURL(pagePath.DocumentationID, pagePath.Path)
+	if err != nil {
+		return "", false
+	}
+
+	return url, true
+}
+
+// ResolveRedirect looks up a redirect for a path of a site, relative to
+// its base URL, and returns where it sends it.
+func (service *DocService) ResolveRedirect(rootId uint, requestPath string) (string, bool) {
+	requested := normalizeSitePath(requestPath)
+	candidates := []string{requested}
+
+	versionName, rest := "", ""
+	if segments := strings.SplitN(strings.TrimPrefix(requested, "/"), "/", 2); len(segments) == 2 {
+		versionName, rest = segments[0], "/"+segments[1]
+		candidates = append(candidates, rest)
+	}
+
+	var redirects []models.Redirect
+	if err := service.DB.Where("root_documentation_id = ? AND from_path IN ?", rootId, candidates).Find(&redirects).Error; err != nil || len(redirects) == 0 {
+		return "", false
+	}
+
+	latest, _, err := service.GetAllVersions(rootId)
+	if err != nil {
+		return "", false
+	}
+
+	for _, redirect := range redirects {
+		var doc models.Documentation
+		if err := service.DB.Select("id", "version").First(&doc, redirect.DocumentationID).Error; err != nil {
+			continue
+		}
+
+		unversioned := redirect.FromPath == requested && doc.Version == latest
+		versioned := rest != "" && redirect.FromPath == rest && doc.Version == versionName
+
+		if !unversioned && !versioned {
+			continue
+		}
+
+		if target, ok := service.redirectTarget(redirect); ok {
+			return target, true
+		}
+	}
+
+	return "", false
+}
+
+// GetRedirects lists the redirects of a documentation version. Automatic
+// ones are listed with where their page is now.
+func (service *DocService) GetRedirects(docId uint) ([]models.Redirect, error) {
+	redirects := make([]models.Redirect, 0)
+	if err := service.DB.Where("documentation_id = ?", docId).Order("from_path ASC").Find(&redirects).Error; err != nil {
+		return nil, fmt.Errorf("failed_to_get_redirects")
+	}
+
+	for i := range redirects {
+		if redirects[i].Automatic {
+			redirects[i].ToPath, _ = service.redirectTarget(redirects[i])
+		}
+	}
+
+	return redirects, nil
+}
+
+// checkRedirectPaths normalizes the paths of a custom redirect rule.
+func checkRedirectPaths(fromPath, toPath string) (string, string, error) {
+	if strings.TrimSpace(fromPath) == "" || strings.TrimSpace(toPath) == "" {
+		return "", "", fmt.Errorf("invalid_redirect_path")
+	}
+
+	fromPath = normalizeSitePath(fromPath)
+	if !strings.Contains(toPath, "://") {
+		toPath = normalizeSitePath(toPath)
+	}
+
+	if fromPath == toPath {
+		return "", "", fmt.Errorf("redirect_loop")
+	}
+
+	return fromPath, toPath, nil
+}
+
+// saveCustomRedirect saves a custom redirect rule. An automatic redirect
+// for the same path gives way to it.
+func saveCustomRedirect(tx *gorm.DB, redirect *models.Redirect) error {
+	var existing models.Redirect
+	err := tx.Where("documentation_id = ? AND from_path = ? AND id <> ?", redirect.DocumentationID, redirect.FromPath, redirect.ID).First(&existing).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return fmt.Errorf("failed_to_get_redirect")
+	}
+
+	if err == nil {
+		if !existing.Automatic {
+			return fmt.Errorf("redirect_already_exists")
+		}
+
+		if err := tx.Delete(&existing).Error; err != nil {
+			return fmt.Errorf("failed_to_delete_redirect")
+		}
+	}
+
+	redirect.PageID = nil
+	redirect.Automatic = false
+
+	if err := tx.Save(redirect).Error; err != nil {
+		return fmt.Errorf("failed_to_save_redirect")
+	}
+
+	return nil
+}
+
+// CreateRedirect adds a custom redirect rule to a documentation version.
+// fromPath is relative to the version, toPath to the site or a full URL.
+func (service *DocService) CreateRedirect(docId uint, fromPath, toPath string) (models.Redirect, error) {
+	fromPath, toPath, err := checkRedirectPaths(fromPath, toPath)
+	if err != nil {
+		return models.Redirect{}, err
+	}
+
+	rootId, err := rootDocumentationID(service.DB, docId)
+	if err != nil {
+		return models.Redirect{}, fmt.Errorf("invalid_documentation_id")
+	}
+
+	redirect := models.Redirect{
+		DocumentationID:     docId,
+		RootDocumentationID: rootId,
+		FromPath:            fromPath,
+		ToPath:              toPath,
+	}
+
+	if err := service.DB.Transaction(func(tx *gorm.DB) error {
+		return saveCustomRedirect(tx, &redirect)
+	}); err != nil {
+		return models.Redirect{}, err
+	}
+
+	service.audit("redirect.create", AuditEntityRedirect, redirect.ID, nil, redirect)
+
+	return redirect, nil
+}
+
+// EditRedirect changes the paths of a redirect. An automatic redirect that
+// is edited becomes a custom rule and stops following its page.
+func (service *DocService) EditRedirect(id uint, fromPath, toPath string) (models.Redirect, error) {
+	var redirect models.Redirect
+	if err := service.DB.First(&redirect, id).Error; err != nil {
+		return models.Redirect{}, fmt.Errorf("redirect_not_found")
+	}
+
+	fromPath, toPath, err := checkRedirectPaths(fromPath, toPath)
+	if err != nil {
+		return models.Redirect{}, err
+	}
+
+	before := redirect
+	redirect.FromPath = fromPath
+	redirect.ToPath = toPath
+
+	if err := service.DB.Transaction(func(tx *gorm.DB) error {
+		return saveCustomRedirect(tx, &redirect)
+	}); err != nil {
+		return models.Redirect{}, err
+	}
+
+	service.audit("redirect.edit", AuditEntityRedirect, redirect.ID, before, redirect)
+
+	return redirect, nil
+}
+
+func (service *DocService) DeleteRedirect(id uint) error {
+	var redirect models.Redirect
+	if err := service.DB.First(&redirect, id).Error; err != nil {
+		return fmt.Errorf("redirect_not_found")
+	}
+
+	if err := service.DB.Delete(&redirect).Error; err != nil {
+		return fmt.Errorf("failed_to_delete_redirect")
+	}
+
+	service.audit("redirect.delete", AuditEntityRedirect, redirect.ID, redirect, nil)
+
+	return nil
+}
@@ -0,0 +1,176 @@
+package services
+
+import (
+	"testing"
+
+	"git.difuse.io/Difuse/kalmia/db/models"
+)
+
+func TestRedirects(t *testing.T) {
+	if TestDocService == nil {
+		t.Fatal("TestDocService is nil")
+	}
+
+	db := TestDocService.DB
+
+	var admin models.User
+	if err := db.Where("username = ?", "admin").First(&admin).Error; err != nil {
+		t.Fatalf("Failed to get admin: %v", err)
+	}
+
+	doc := models.Documentation{Name: "Redirected", Version: "1.0.0", BaseURL: "/redirected", AuthorID: admin.ID}
+	if err := db.Create(&doc).Error; err != nil {
+		t.Fatalf("Failed to create documentation: %v", err)
+	}
+
+	groups := make([]models.PageGroup, 0, 2)
+	for _, name := range []string{"Basics", "Advanced"} {
+		group := models.PageGroup{DocumentationID: doc.ID, Name: name, AuthorID: admin.ID}
+		if _, err := TestDocService.CreatePageGroup(&group); err != nil {
+			t.Fatalf("CreatePageGroup returned an error: %v", err)
+		}
+		if err := TestDocService.PublishPageGroup(admin, group.ID); err != nil {
+			t.Fatalf("PublishPageGroup returned an error: %v", err)
+		}
+		groups = append(groups, group)
+	}
+
+	page := models.Page{DocumentationID: doc.ID, PageGroupID: &groups[0].ID, Title: "Setup", Slug: "/setup", Content: "[]", AuthorID: admin.ID}
+	if err := TestDocService.CreatePage(&page); err != nil {
+		t.Fatalf("CreatePage returned an error: %v", err)
+	}
+	if err := TestDocService.PublishPage(admin, page.ID); err != nil {
+		t.Fatalf("PublishPage returned an error: %v", err)
+	}
+
+	if err := TestDocService.recordPagePaths(doc.ID); err != nil {
+		t.Fatalf("recordPagePaths returned an error: %v", err)
+	}
+
+	resolve := func(t *testing.T, requestPath, expected string) {
+		t.Helper()
+
+		target, ok := TestDocService.ResolveRedirect(doc.ID, requestPath)
+		if expected == "" {
+			if ok {
+				t.Errorf("Expected no redirect for %s, got %s", requestPath, target)
+			}
+			return
+		}
+
+		if !ok || target != expected {
+			t.Errorf("Expected %s to redirect to %s, got %q (%v)", requestPath, expected, target, ok)
+		}
+	}
+
+	t.Run("Pages that didn't move don't redirect", func(t *testing.T) {
+		resolve(t, "/guides/basics/setup", "")
+	})
+
+	t.Run("Renamed pages redirect to their new path", func(t *testing.T) {
+		if err := TestDocService.EditPage(admin, page.ID, "Installation", "/installation", "[]", nil, page.PageGroupID, nil); err != nil {
+			t.Fatalf("EditPage returned an error: %v", err)
+		}
+		if err := TestDocService.PublishPage(admin, page.ID); err != nil {
+			t.Fatalf("PublishPage returned an error: %v", err)
+		}
+		if err := TestDocService.recordPagePaths(doc.ID); err != nil {
+			t.Fatalf("recordPagePaths returned an error: %v", err)
+		}
+
+		resolve(t, "/guides/basics/setup", "/redirected/guides/basics/installation")
+		resolve(t, "/guides/basics/setup.html", "/redirected/guides/basics/installation")
+		resolve(t, "/guides/basics/installation", "")
+	})
+
+	t.Run("Moved pages redirect from every path they had", func(t *testing.T) {
+		if _, err := TestDocService.MovePage(admin, page.ID, doc.ID, &groups[1].ID); err != nil {
+			t.Fatalf("MovePage returned an error: %v", err)
+		}
+		if err := TestDocService.recordPagePaths(doc.ID); err != nil {
+			t.Fatalf("recordPagePaths returned an error: %v", err)
+		}
+
+		resolve(t, "/guides/basics/setup", "/redirected/guides/advanced/installation")
+		resolve(t, "/guides/basics/installation", "/redirected/guides/advanced/installation")
+	})
+
+	t.Run("Older versions redirect under their version", func(t *testing.T) {
+		newer := models.Documentation{Name: "Redirected", Version: "2.0.0", BaseURL: "/redirected", AuthorID: admin.ID, ClonedFrom: &doc.ID}
+		if err := db.Create(&newer).Error; err != nil {
+			t.Fatalf("Failed to create documentation version: %v", err)
+		}
+
+		resolve(t, "/1.0.0/guides/basics/setup", "/redirected/1.0.0/guides/advanced/installation")
+		resolve(t, "/guides/basics/setup", "")
+	})
+
+	t.Run("Custom rules redirect and win over automatic ones", func(t *testing.T) {
+		redirect, err := TestDocService.CreateRedirect(doc.ID, "/guides/basics/setup/", "https://example.org/setup")
+		if err != nil {
+			t.Fatalf("CreateRedirect returned an error: %v", err)
+		}
+		if redirect.Automatic || redirect.FromPath != "/guides/basics/setup" {
+			t.Errorf("Expected a custom rule for /guides/basics/setup, got %+v", redirect)
+		}
+
+		resolve(t, "/1.0.0/guides/basics/setup", "https://example.org/setup")
+
+		if _, err := TestDocService.CreateRedirect(doc.ID, "/guides/basics/setup", "/elsewhere"); err == nil || err.Error() != "redirect_already_exists" {
+			t.Errorf("Expected redirect_already_exists, got %v", err)
+		}
+
+		if _, err := TestDocService.CreateRedirect(doc.ID, "/loop", "/loop/"); err == nil || err.Error() != "redirect_loop" {
+			t.Errorf("Expected redirect_loop, got %v", err)
+		}
+
+		edited, err := TestDocService.EditRedirect(redirect.ID, "/guides/basics/setup", "/2.0.0/guides")
+		if err != nil {
+			t.Fatalf("EditRedirect returned an error: %v", err)
+		}
+
+		resolve(t, "/1.0.0/guides/basics/setup", "/redirected/2.0.0/guides")
+
+		if err := TestDocService.recordPagePaths(doc.ID); err != nil {
+			t.Fatalf("recordPagePaths returned an error: %v", err)
+		}
+		resolve(t, "/1.0.0/guides/basics/setup", "/redirected/2.0.0/guides")
+
+		redirects, err := TestDocService.GetRedirects(doc.ID)
+		if err != nil {
+			t.Fatalf("GetRedirects returned an error: %v", err)
+		}
+		if len(redirects) != 2 {
+			t.Fatalf("Expected 2 redirects, got %d", len(redirects))
+		}
+		for _, listed := range redirects {
+			if listed.Automatic && listed.ToPath != "/redirected/1.0.0/guides/advanced/installation" {
+				t.Errorf("Expected automatic redirects to list where their page is, got %s", listed.ToPath)
+			}
+		}
+
+		if err := TestDocService.DeleteRedirect(edited.ID); err != nil {
+			t.Fatalf("DeleteRedirect returned an error: %v", err)
+		}
+		resolve(t, "/1.0.0/guides/basics/setup", "")
+	})
+
+	t.Run("Paths of unpublished pages are forgotten", func(t *testing.T) {
+		if err := TestDocService.UnpublishPage(admin, page.ID); err != nil {
+			t.Fatalf("UnpublishPage returned an error: %v", err)
+		}
+		if err := TestDocService.recordPagePaths(doc.ID); err != nil {
+			t.Fatalf("recordPagePaths returned an error: %v", err)
+		}
+
+		resolve(t, "/1.0.0/guides/basics/installation", "")
+
+		var count int64
+		if err := db.Model(&models.PagePath{}).Where("page_id = ?", page.ID).Count(&count).Error; err != nil {
+			t.Fatalf("Failed to count page paths: %v", err)
+		}
+		if count != 0 {
+			t.Errorf("Expected the page path to be forgotten, got %d", count)
+		}
+	})
+}
@@ -0,0 +1,192 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+
+	"git.difuse.io/Difuse/kalmia/db/models"
+	"git.difuse.io/Difuse/kalmia/utils"
+	"gorm.io/gorm"
+)
+
+func createPageRevision(tx *gorm.DB, page models.Page, editorID uint, restoredFromID *uint) error {
+	revision := models.PageRevision{
+		PageID:          page.ID,
+		DocumentationID: page.DocumentationID,
+		EditorID:        editorID,
+		Title:           page.Title,
+		Slug:            page.Slug,
+		Content:         page.Content,
+		RestoredFromID:  restoredFromID,
+	}
+
+	if err := tx.Omit("Editor").Create(&revision).Error; err != nil {
+		return fmt.Errorf("failed_to_create_page_revision")
+	}
+
+	return nil
+}
+
+func (service *DocService) GetPageRevisions(pageId uint) ([]models.PageRevision, error) {
+	var count int64
+	if err := service.DB.Model(&models.Page{}).Where("id = ?", pageId).Count(&count).Error; err != nil {
+		return nil, fmt.Errorf("failed_to_fetch_page")
+	}
+
+	if count == 0 {
+		return nil, fmt.Errorf("page_not_found")
+	}
+
+	var revisions []models.PageRevision
+
+	if err := service.DB.Preload("Editor", func(db *gorm.DB) *gorm.DB {
+		return db.Select("ID", "Username", "Email", "Photo")
+	}).Select("ID", "PageID", "DocumentationID", "EditorID", "Title", "Slug", "RestoredFromID", "CreatedAt").
+		Where("page_id = ?", pageId).
+		Order("id DESC").
+		Find(&revisions).Error; err != nil {
+		return nil, fmt.Errorf("failed_to_get_page_revisions")
+	}
+
+	return revisions, nil
+}
+
+func (service *DocService) GetPageRevision(id uint) (models.PageRevision, error) {
+	var revision models.PageRevision
+
+	if err := service.DB.Preload("Editor", func(db *gorm.DB) *gorm.DB {
+		return db.Select("ID", "Username", "Email", "Photo")
+	}).First(&revision, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return models.PageRevision{}, fmt.Errorf("page_revision_not_found")
+		}
+		return models.PageRevision{}, fmt.Errorf("failed_to_get_page_revision")
+	}
+
+	return revision, nil
+}
+
+func (service *DocService) DiffPageRevisions(fromId, toId uint) (map[string]interface{}, error) {
+	from, err := service.GetPageRevision(fromId)
+	if err != nil {
+		return nil, err
+	}
+
+	to, err := service.GetPageRevision(toId)
+	if err != nil {
+		return nil, err
+	}
+
+	if from.PageID != to.PageID {
+		return nil, fmt.Errorf("revisions_belong_to_different_pages")
+	}
+
+	fromBlocks, err := utils.ParseBlocks(from.Content)
+	if err != nil {
+		return nil, fmt.Errorf("failed_to_parse_revision_content")
+	}
+
+	toBlocks, err := utils.ParseBlocks(to.Content)
+	if err != nil {
+		return nil, fmt.Errorf("failed_to_parse_revision_content")
+	}
+
+	return map[string]interface{}{
+		"pageId":       from.PageID,
+		"fromId":       from.ID,
+		"toId":         to.ID,
+		"titleChanged": from.Title != to.Title,
+		"slugChanged":  from.Slug != to.Slug,
+		"fromTitle":    from.Title,
+		"toTitle":      to.Title,
+		"fromSlug":     from.Slug,
+		"toSlug":       to.Slug,
+		"blocks":       utils.DiffBlocks(fromBlocks, toBlocks),
+	}, nil
+}
+
+func (service *DocService) RestorePageRevision(user models.User, id uint) error {
+	revision, err := service.GetPageRevision(id)
+	if err != nil {
+		return err
+	}
+
+	if err := service.requireDocumentationRole(user.ID, revision.DocumentationID, RoleEditor); err != nil {
+		return err
+	}
+
+	if err := checkEditLock(service.DB, ResourcePage, revision.PageID, user.ID); err != nil {
+		return err
+	}
+
+	tx := service.DB.Begin()
+
+	var page models.Page
+	if err := tx.Preload("Editors").First(&page, revision.PageID).Error; err != nil {
+		tx.Rollback()
+		return fmt.Errorf("page_not_found")
+	}
+
+	var slugCount int64
+	if err := tx.Model(&models.Page{}).
+		Where("documentation_id = ? AND slug = ? AND id <> ?", page.DocumentationID, revision.Slug, page.ID).
+		Count(&slugCount).Error; err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed_to_check_slug")
+	}
+
+	if slugCount > 0 {
+		tx.Rollback()
+		return fmt.Errorf("slug_already_in_use")
+	}
+
+	if err := bumpPageVersion(tx, &page); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	before := pageAuditSummary(page)
+
+	page.Title = revision.Title
+	page.Slug = revision.Slug
+	page.Content = revision.Content
+	page.LastEditorID = &user.ID
+
+	alreadyEditor := false
+	for _, editor := range page.Editors {
+		if editor.ID == user.ID {
+			alreadyEditor = true
+			break
+		}
+	}
+
+	if !alreadyEditor {
+		page.Editors = append(page.Editors, user)
+	}
+
+	if err := tx.Save(&page).Error; err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed_to_update_page")
+	}
+
+	if err := createPageRevision(tx, page, user.ID, &revision.ID); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := indexPage(tx, page); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return fmt.Errorf("failed_to_commit_changes")
+	}
+
+	after := pageAuditSummary(page)
+	after["restoredFromRevisionId"] = revision.ID
+	service.audit("page.restore", AuditEntityPage, page.ID, before, after)
+
+	// Like an edit, the restored content is a draft until it's published.
+	return nil
+}
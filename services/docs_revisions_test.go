@@ -0,0 +1,154 @@
+package services
+
+import (
+	"testing"
+
+	"git.difuse.io/Difuse/kalmia/db/models"
+	"git.difuse.io/Difuse/kalmia/utils"
+)
+
+func TestPageRevisions(t *testing.T) {
+	if TestDocService == nil {
+		t.Fatal("TestDocService is nil")
+	}
+
+	db := TestDocService.DB
+
+	var admin, user models.User
+	if err := db.Where("username = ?", "admin").First(&admin).Error; err != nil {
+		t.Fatalf("Failed to get admin: %v", err)
+	}
+	if err := db.Where("username = ?", "user").First(&user).Error; err != nil {
+		t.Fatalf("Failed to get user: %v", err)
+	}
+
+	doc := models.Documentation{Name: "Revisions", Version: "1.0.0", BaseURL: "/revisions", AuthorID: admin.ID}
+	if err := db.Create(&doc).Error; err != nil {
+		t.Fatalf("Failed to create documentation: %v", err)
+	}
+
+	if err := TestDocService.SetDocumentationMember(admin, doc.ID, user.ID, RoleEditor); err != nil {
+		t.Fatalf("SetDocumentationMember returned an error: %v", err)
+	}
+
+	first := `[{"id":"intro","type":"paragraph","props":{},"content":[{"type":"text","text":"Hello","styles":{}}],"children":[]}]`
+	second := `[{"id":"intro","type":"paragraph","props":{},"content":[{"type":"text","text":"Hello there","styles":{}}],"children":[]},` +
+		`{"id":"more","type":"paragraph","props":{},"content":[{"type":"text","text":"More","styles":{}}],"children":[]}]`
+
+	page := models.Page{DocumentationID: doc.ID, Title: "History", Slug: "/history", Content: first, AuthorID: admin.ID}
+	if err := TestDocService.CreatePage(&page); err != nil {
+		t.Fatalf("CreatePage returned an error: %v", err)
+	}
+
+	if err := TestDocService.EditPage(admin, page.ID, "History, revised", "/history-revised", second, nil, nil, nil); err != nil {
+		t.Fatalf("EditPage returned an error: %v", err)
+	}
+
+	revisions, err := TestDocService.GetPageRevisions(page.ID)
+	if err != nil {
+		t.Fatalf("GetPageRevisions returned an error: %v", err)
+	}
+
+	t.Run("Lists revisions newest first", func(t *testing.T) {
+		if len(revisions) != 2 {
+			t.Fatalf("Expected 2 revisions, got %d", len(revisions))
+		}
+
+		if revisions[0].Title != "History, revised" || revisions[1].Title != "History" {
+			t.Errorf("Expected the newest revision first, got %q and %q", revisions[0].Title, revisions[1].Title)
+		}
+
+		if revisions[0].Editor.Username != "admin" || revisions[0].Content != "" {
+			t.Errorf("Expected the editor without the content, got %+v", revisions[0])
+		}
+	})
+
+	t.Run("Diffs two revisions", func(t *testing.T) {
+		diff, err := TestDocService.DiffPageRevisions(revisions[1].ID, revisions[0].ID)
+		if err != nil {
+			t.Fatalf("DiffPageRevisions returned an error: %v", err)
+		}
+
+		if diff["titleChanged"] != true || diff["slugChanged"] != true || diff["toSlug"] != "/history-revised" {
+			t.Errorf("Expected the title and slug to change, got %v", diff)
+		}
+
+		changes := make(map[string]string)
+		for _, change := range diff["blocks"].([]utils.BlockChange) {
+			changes[change.BlockID] = change.Change
+		}
+
+		if changes["intro"] != utils.BlockModified || changes["more"] != utils.BlockAdded {
+			t.Errorf("Expected intro modified and more added, got %v", changes)
+		}
+	})
+
+	t.Run("Restoring writes a new revision", func(t *testing.T) {
+		var before models.Page
+		if err := db.First(&before, page.ID).Error; err != nil {
+			t.Fatalf("Failed to get page: %v", err)
+		}
+
+		if err := TestDocService.RestorePageRevision(user, revisions[1].ID); err != nil {
+			t.Fatalf("RestorePageRevision returned an error: %v", err)
+		}
+
+		var stored models.Page
+		if err := db.First(&stored, page.ID).Error; err != nil {
+			t.Fatalf("Failed to get page: %v", err)
+		}
+
+		if stored.Title != "History" || stored.Slug != "/history" || stored.Content != first {
+			t.Errorf("Expected the first revision's content, got %+v", stored)
+		}
+
+		if stored.Version != before.Version+1 {
+			t.Errorf("Expected version %d, got %d", before.Version+1, stored.Version)
+		}
+
+		restored, err := TestDocService.GetPageRevisions(page.ID)
+		if err != nil {
+			t.Fatalf("GetPageRevisions returned an error: %v", err)
+		}
+
+		if len(restored) != 3 || restored[0].RestoredFromID == nil || *restored[0].RestoredFromID != revisions[1].ID || restored[0].EditorID != user.ID {
+			t.Errorf("Expected a new revision restored from %d by user, got %+v", revisions[1].ID, restored[0])
+		}
+	})
+
+	t.Run("Refuses a restore to a slug in use", func(t *testing.T) {
+		other := models.Page{DocumentationID: doc.ID, Title: "Revised elsewhere", Slug: "/history-revised", Content: "[]", AuthorID: admin.ID}
+		if err := TestDocService.CreatePage(&other); err != nil {
+			t.Fatalf("CreatePage returned an error: %v", err)
+		}
+
+		if err := TestDocService.RestorePageRevision(admin, revisions[0].ID); err == nil || err.Error() != "slug_already_in_use" {
+			t.Errorf("Expected slug_already_in_use, got %v", err)
+		}
+
+		var stored models.Page
+		if err := db.First(&stored, page.ID).Error; err != nil {
+			t.Fatalf("Failed to get page: %v", err)
+		}
+
+		if stored.Slug != "/history" {
+			t.Errorf("Expected the page to be untouched, got %q", stored.Slug)
+		}
+	})
+
+	t.Run("Refuses a restore while another user holds the lock", func(t *testing.T) {
+		if _, err := TestDocService.AcquireLock(user, ResourcePage, page.ID); err != nil {
+			t.Fatalf("AcquireLock returned an error: %v", err)
+		}
+		defer TestDocService.ReleaseLock(user, ResourcePage, page.ID)
+
+		if err := TestDocService.RestorePageRevision(admin, revisions[1].ID); err == nil || err.Error() != "locked_by_another_user" {
+			t.Errorf("Expected locked_by_another_user, got %v", err)
+		}
+
+		unchanged, err := TestDocService.GetPageRevisions(page.ID)
+		if err != nil || len(unchanged) != 3 {
+			t.Errorf("Expected no new revision, got %d (%v)", len(unchanged), err)
+		}
+	})
+}
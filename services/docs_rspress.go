@@ -3,6 +3,7 @@ package services
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"math"
 	"os"
@@ -122,7 +123,7 @@ func (service *DocService) GenerateHead(docID uint, pageId uint, pageType string
 
 		return buffer.String(), nil
 	} else {
-		page, err := service.GetPage(pageId)
+		page, err := service.getPublishedPage(pageId)
 		if err != nil {
 			return "", err
 		}
@@ -238,29 +239,44 @@ func (service *DocService) UpdateWriteBuild(docId uint) error {
 		return err
 	}
 
-	preHashDocs, err := utils.DirHash(docsPath + "/docs")
-	if err != nil && !os.IsNotExist(err) {
-		return err
-	}
-
-	if utils.PathExists(filepath.Join(docsPath, "docs", doc.Version)) {
-		if err := utils.RemovePath(filepath.Join(docsPath, "docs", doc.Version)); err != nil {
+	if config.ParsedConfig.LinkCheck.BeforeBuild {
+		err = service.buildStep(rootParentId, BuildStepLinkCheck, func() (string, error) {
+			return service.linkCheckBuildStep(rootParentId)
+		})
+		if err != nil {
 			return err
 		}
 	}
 
-	configHash, err := service.StartUpdate(docId, rootParentId)
-	if err != nil {
-		return err
-	}
+	needRebuild := false
 
-	preHash := utils.HashStrings([]string{preHashDocs, configHash})
+	err = service.buildStep(rootParentId, BuildStepWrite, func() (string, error) {
+		preHashDocs, err := utils.DirHash(docsPath + "/docs")
+		if err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+
+		if utils.PathExists(filepath.Join(docsPath, "docs", doc.Version)) {
+			if err := utils.RemovePath(filepath.Join(docsPath, "docs", doc.Version)); err != nil {
+				return "", err
+			}
+		}
 
-	if err := service.WriteContents(docId, rootParentId, preHash); err != nil {
+		configHash, err := service.StartUpdate(docId, rootParentId)
+		if err != nil {
+			return "", err
+		}
+
+		preHash := utils.HashStrings([]string{preHashDocs, configHash})
+
+		needRebuild, err = service.writeContents(docId, rootParentId, preHash)
+		return "", err
+	})
+	if err != nil {
 		return err
 	}
 
-	return nil
+	return service.RsPressBuild(rootParentId, needRebuild)
 }
 
 func checkAndDeleteIfNoLockFile(folderPath string) error {
@@ -322,7 +338,7 @@ func (service *DocService) StartupCheck() error {
 			return err
 		}
 
-		err = service.AddBuildTrigger(doc.ID, false)
+		err = service.addBuildTrigger(doc.ID, false, BuildPriorityLow)
 		if err != nil {
 			logger.Error("Failed to add build trigger", zap.Uint("doc_id", doc.ID), zap.Error(err))
 		}
@@ -362,6 +378,12 @@ func (service *DocService) InitRsPressPackageCache() error {
 	return nil
 }
 
+// initRsPress sets up a new documentation's site. Tests swap it out, they
+// can't count on npm being around.
+var initRsPress = func(service *DocService, docId uint) error {
+	return service.InitRsPress(docId)
+}
+
 func (service *DocService) InitRsPress(docId uint) error {
 	cfg := config.ParsedConfig
 	rsPressData := filepath.Join(cfg.DataPath, "rspress_data")
@@ -521,6 +543,13 @@ func (service *DocService) StartUpdate(docId uint, rootParentId uint) (string, e
 
 	replacements["__MULTI_VERSIONS__"] = "multiVersion: " + string(multiVersionsJSON)
 
+	locales, err := service.rsPressLocales(rootParentId, doc)
+	if err != nil {
+		return "", err
+	}
+
+	replacements["__LOCALES__"] = locales
+
 	err = utils.WriteToFile(docConfig, utils.ReplaceMany(string(docConfigTemplate), replacements))
 	if err != nil {
 		return "", err
@@ -585,6 +614,8 @@ func (service *DocService) CraftPage(pageID uint, title string, slug string, con
 	return fmt.Sprintf("%s%s", top, markdown), nil
 }
 
+// writePagesToDirectory writes pages as they are given, callers pass the
+// published version of each page.
 func (service *DocService) writePagesToDirectory(pages []models.Page, dirPath string) error {
 	var metaElements []MetaElement
 
@@ -595,14 +626,9 @@ func (service *DocService) writePagesToDirectory(pages []models.Page, dirPath st
 		return *pages[i].Order < *pages[j].Order
 	})
 
-	for _, page := range pages {
-		fullPage, err := service.GetPage(page.ID)
-		if err != nil {
-			return err
-		}
-
+	for _, fullPage := range pages {
 		var fileName, content string
-		content, err = service.CraftPage(fullPage.ID, fullPage.Title, fullPage.Slug, fullPage.Content)
+		content, err := service.CraftPage(fullPage.ID, fullPage.Title, fullPage.Slug, fullPage.Content)
 		if err != nil {
 			return err
 		}
@@ -647,7 +673,7 @@ func (service *DocService) writePagesToDirectory(pages []models.Page, dirPath st
 	return writeMetaJSON(metaElements, dirPath)
 }
 
-func (service *DocService) writePageGroupsToDirectory(pageGroups []models.PageGroup, dirPath string, docId uint) error {
+func (service *DocService) writePageGroupsToDirectory(pageGroups []models.PageGroup, dirPath string, docId uint, language string) error {
 	for _, pageGroup := range pageGroups {
 		if pageGroup.DocumentationID != docId {
 			continue
@@ -669,6 +695,16 @@ func (service *DocService) writePageGroupsToDirectory(pageGroups []models.PageGr
 			return err
 		}
 
+		pages, err = service.publishedPages(pages)
+		if err != nil {
+			return err
+		}
+
+		pages, err = service.localizedPages(pages, language)
+		if err != nil {
+			return err
+		}
+
 		if err := service.writePagesToDirectory(pages, fullPath); err != nil {
 			return err
 		}
@@ -693,6 +729,7 @@ func (service *DocService) writePageGroupsToDirectory(pageGroups []models.PageGr
 		if err := service.DB.Where("parent_id = ?", pageGroup.ID).Find(&nestedPageGroups).Error; err != nil {
 			return err
 		}
+		nestedPageGroups = publishedPageGroups(nestedPageGroups)
 
 		for _, nestedGroup := range nestedPageGroups {
 			nestedGroupDir := utils.StringToFileString(nestedGroup.Name)
@@ -703,7 +740,7 @@ func (service *DocService) writePageGroupsToDirectory(pageGroups []models.PageGr
 				}
 			}
 
-			if err := service.writePageGroupsToDirectory([]models.PageGroup{nestedGroup}, fullPath, docId); err != nil {
+			if err := service.writePageGroupsToDirectory([]models.PageGroup{nestedGroup}, fullPath, docId, language); err != nil {
 				return err
 			}
 
@@ -787,58 +824,65 @@ func (service *DocService) buildVersionTree(docId uint) ([]VersionInfo, error) {
 }
 
 func (service *DocService) WriteContents(docId uint, rootParentId uint, preHash string) error {
+	needRebuild, err := service.writeContents(docId, rootParentId, preHash)
+	if err != nil {
+		return err
+	}
+
+	return service.RsPressBuild(rootParentId, needRebuild)
+}
+
+// writeContents writes the pages of every version to disk and reports
+// whether anything changed since preHash, i.e. whether RsPress has to rebuild.
+func (service *DocService) writeContents(docId uint, rootParentId uint, preHash string) (bool, error) {
 	docIdPath := filepath.Join(config.ParsedConfig.DataPath, "rspress_data", "doc_"+strconv.Itoa(int(rootParentId)))
 	_, err := service.GetDocumentation(docId)
 	if err != nil {
 		if err.Error() == "documentation_not_found" {
 			if err := utils.RemovePath(docIdPath); err != nil {
-				return err
+				return false, err
 			}
 		}
-		return err
+		return false, err
 	}
 
 	docsPath := filepath.Join(docIdPath, "docs")
 
 	for _, path := range []string{docIdPath, docsPath} {
 		if err := utils.MakeDir(path); err != nil {
-			return err
+			return false, err
 		}
 	}
 
 	versionInfos, err := service.buildVersionTree(rootParentId)
 	if err != nil {
-		return err
+		return false, err
 	}
 
 	for _, versionInfo := range versionInfos {
 		versionDoc, err := service.GetDocumentation(versionInfo.DocId)
 		if err != nil {
-			return err
+			return false, err
 		}
 
 		versionedDocPath := filepath.Join(docsPath, versionInfo.Version)
 
 		if !utils.PathExists(versionedDocPath) {
 			if err := utils.MakeDir(versionedDocPath); err != nil {
-				return err
+				return false, err
 			}
 		}
 
 		var rootPageGroups []models.PageGroup
 
 		if err := service.DB.Where("parent_id IS NULL AND documentation_id = ?", versionDoc.ID).Preload("Pages").Find(&rootPageGroups).Error; err != nil {
-			return err
+			return false, err
 		}
+		rootPageGroups = publishedPageGroups(rootPageGroups)
 
-		cleanedBase := "guides"
-
-		var rootMeta string
-
-		if versionDoc.LanderDetails != "" && versionDoc.LanderDetails != "{}" {
-			rootMeta = `[{"text": "Home", "link": "/", "activeMatch": "^(?!.*guides).*$"}, {"text": "Documentation", "link": "/guides", "activeMatch": ".*guides.*"}]`
-		} else {
-			rootMeta = fmt.Sprintf(`[{"text": "Documentation","link": "/%s/index","activeMatch": "/%s/"}]`, cleanedBase, cleanedBase)
+		rootPages, err := service.publishedPages(versionDoc.Pages)
+		if err != nil {
+			return false, err
 		}
 
 		var customCSS strings.Builder
@@ -852,108 +896,201 @@ func (service *DocService) WriteContents(docId uint, rootParentId uint, preHash
 		}
 
 		if err := utils.WriteToFile(filepath.Join(versionedDocPath, "../../", "styles", "input.css"), customCSS.String()); err != nil {
-			return err
+			return false, err
 		}
 
-		if err := utils.WriteToFile(filepath.Join(versionedDocPath, "_meta.json"), rootMeta); err != nil {
-			return err
+		defaultLanguage, languages, err := service.siteLanguages(versionDoc.ID)
+		if err != nil {
+			return false, err
 		}
 
-		userContentPath := filepath.Join(versionedDocPath, cleanedBase)
+		// A translated site has a directory for every language, the default one
+		// included, and nothing else.
+		if len(languages) == 0 {
+			if err := cleanVersionLayout(versionedDocPath, []string{"guides", "_meta.json", "index.mdx"}); err != nil {
+				return false, err
+			}
 
-		if !utils.PathExists(userContentPath) {
-			if err := utils.MakeDir(userContentPath); err != nil {
-				return err
+			if err := service.writeVersionContents(versionDoc, rootPageGroups, rootPages, versionedDocPath, ""); err != nil {
+				return false, err
 			}
+			continue
 		}
 
-		if err := service.WriteHomePage(versionDoc, userContentPath); err != nil {
-			return err
+		siteLanguages := append([]string{defaultLanguage}, languages...)
+		if err := cleanVersionLayout(versionedDocPath, siteLanguages); err != nil {
+			return false, err
 		}
 
-		var rootMetaElements []MetaElement
+		for _, language := range siteLanguages {
+			localized := language
+			if language == defaultLanguage {
+				localized = ""
+			}
 
-		// Write pages directly in the userContentPath
-		if err := service.writePagesToDirectory(versionDoc.Pages, userContentPath); err != nil {
-			return err
+			if err := service.writeVersionContents(versionDoc, rootPageGroups, rootPages, filepath.Join(versionedDocPath, language), localized); err != nil {
+				return false, err
+			}
 		}
+	}
 
-		// Add pages to root meta elements
-		for _, page := range versionDoc.Pages {
-			order := uint(0)
-			if page.Order != nil {
-				order = *page.Order
-			}
+	if err := service.recordPagePaths(rootParentId); err != nil {
+		logger.Error("Failed to record page paths", zap.Uint("doc_id", rootParentId), zap.Error(err))
+	}
 
-			if page.IsIntroPage {
-				rootMetaElements = append(rootMetaElements, MetaElement{
-					Type:  "file",
-					Name:  "index",
-					Label: page.Title,
-					Path:  "/",
-					Order: order,
-				})
-			} else {
-				rootMetaElements = append(rootMetaElements, MetaElement{
-					Type:  "file",
-					Name:  utils.StringToFileString(page.Title),
-					Label: page.Title,
-					Path:  page.Slug,
-					Order: order,
-				})
-			}
+	newDocsHash, err := utils.DirHash(docsPath)
+	if err != nil {
+		return false, err
+	}
+
+	newConfigHash, err := utils.FileHash(filepath.Join(docIdPath, "rspress.config.ts"))
+	if err != nil {
+		return false, err
+	}
+
+	newHash := utils.HashStrings([]string{newDocsHash, newConfigHash})
+	deletionsOccurred, err := service.PreBuildCleanup(rootParentId)
+	if err != nil {
+		return false, err
+	}
+
+	needRebuild := false
+	if newHash != preHash || deletionsOccurred {
+		needRebuild = true
+	}
+
+	return needRebuild, nil
+}
+
+// writeVersionContents writes the pages of a documentation version into
+// contentPath, in a language it's translated into or, with language empty,
+// as they are.
+func (service *DocService) writeVersionContents(versionDoc models.Documentation, rootPageGroups []models.PageGroup, rootPages []models.Page, contentPath string, language string) error {
+	rootPages, err := service.localizedPages(rootPages, language)
+	if err != nil {
+		return err
+	}
+
+	// Links in the navigation are to the language's own pages.
+	linkPrefix := ""
+	if language != "" {
+		linkPrefix = "/" + language
+	}
+
+	if !utils.PathExists(contentPath) {
+		if err := utils.MakeDir(contentPath); err != nil {
+			return err
 		}
+	}
+
+	cleanedBase := "guides"
+
+	var rootMeta string
+
+	if versionDoc.LanderDetails != "" && versionDoc.LanderDetails != "{}" {
+		rootMeta = fmt.Sprintf(`[{"text": "Home", "link": "%s/", "activeMatch": "^(?!.*guides).*$"}, {"text": "Documentation", "link": "%s/guides", "activeMatch": ".*guides.*"}]`, linkPrefix, linkPrefix)
+	} else {
+		rootMeta = fmt.Sprintf(`[{"text": "Documentation","link": "%s/%s/index","activeMatch": "/%s/"}]`, linkPrefix, cleanedBase, cleanedBase)
+	}
+
+	if err := utils.WriteToFile(filepath.Join(contentPath, "_meta.json"), rootMeta); err != nil {
+		return err
+	}
+
+	userContentPath := filepath.Join(contentPath, cleanedBase)
 
-		// Write page groups
-		if err := service.writePageGroupsToDirectory(rootPageGroups, userContentPath, versionDoc.ID); err != nil {
+	if !utils.PathExists(userContentPath) {
+		if err := utils.MakeDir(userContentPath); err != nil {
 			return err
 		}
+	}
 
-		// Add page groups to root meta elements
-		for _, group := range rootPageGroups {
-			order := uint(0)
-			if group.Order != nil {
-				order = *group.Order
-			}
-			rootMetaElements = append(rootMetaElements, MetaElement{
-				Type:        "dir",
-				Name:        utils.StringToFileString(group.Name),
-				Label:       group.Name,
-				Path:        utils.StringToFileString(group.Name),
-				Order:       order,
-				Collapsible: &[]bool{true}[0],
-				Collapsed:   &[]bool{true}[0],
-			})
+	if err := service.WriteHomePage(versionDoc, userContentPath); err != nil {
+		return err
+	}
+
+	var rootMetaElements []MetaElement
+
+	// Write pages directly in the userContentPath
+	if err := service.writePagesToDirectory(rootPages, userContentPath); err != nil {
+		return err
+	}
+
+	// Add pages to root meta elements
+	for _, page := range rootPages {
+		order := uint(0)
+		if page.Order != nil {
+			order = *page.Order
 		}
 
-		// Write root _meta.json
-		if err := writeMetaJSON(rootMetaElements, userContentPath); err != nil {
-			return err
+		if page.IsIntroPage {
+			rootMetaElements = append(rootMetaElements, MetaElement{
+				Type:  "file",
+				Name:  "index",
+				Label: page.Title,
+				Path:  "/",
+				Order: order,
+			})
+		} else {
+			rootMetaElements = append(rootMetaElements, MetaElement{
+				Type:  "file",
+				Name:  utils.StringToFileString(page.Title),
+				Label: page.Title,
+				Path:  page.Slug,
+				Order: order,
+			})
 		}
 	}
 
-	newDocsHash, err := utils.DirHash(docsPath)
-	if err != nil {
+	// Write page groups
+	if err := service.writePageGroupsToDirectory(rootPageGroups, userContentPath, versionDoc.ID, language); err != nil {
 		return err
 	}
 
-	newConfigHash, err := utils.FileHash(filepath.Join(docIdPath, "rspress.config.ts"))
-	if err != nil {
+	// Add page groups to root meta elements
+	for _, group := range rootPageGroups {
+		order := uint(0)
+		if group.Order != nil {
+			order = *group.Order
+		}
+		rootMetaElements = append(rootMetaElements, MetaElement{
+			Type:        "dir",
+			Name:        utils.StringToFileString(group.Name),
+			Label:       group.Name,
+			Path:        utils.StringToFileString(group.Name),
+			Order:       order,
+			Collapsible: &[]bool{true}[0],
+			Collapsed:   &[]bool{true}[0],
+		})
+	}
+
+	// Write root _meta.json
+	if err := writeMetaJSON(rootMetaElements, userContentPath); err != nil {
 		return err
 	}
 
-	newHash := utils.HashStrings([]string{newDocsHash, newConfigHash})
-	deletionsOccurred, err := service.PreBuildCleanup(rootParentId)
+	return nil
+}
+
+// cleanVersionLayout removes what a version directory has besides the
+// entries given, left over from when the site was or wasn't translated.
+func cleanVersionLayout(versionedDocPath string, keep []string) error {
+	entries, err := os.ReadDir(versionedDocPath)
 	if err != nil {
 		return err
 	}
 
-	needRebuild := false
-	if newHash != preHash || deletionsOccurred {
-		needRebuild = true
+	for _, entry := range entries {
+		if utils.ArrayContains(keep, entry.Name()) {
+			continue
+		}
+
+		if err := utils.RemovePath(filepath.Join(versionedDocPath, entry.Name())); err != nil {
+			return err
+		}
 	}
 
-	return service.RsPressBuild(rootParentId, needRebuild)
+	return nil
 }
 
 func (service *DocService) WriteHomePage(documentation models.Documentation, contentPath string) error {
@@ -1113,17 +1250,20 @@ func (service *DocService) RsPressBuild(docId uint, rebuild bool) error {
 	if rebuild {
 		tmpBuildPath := filepath.Join(docPath, "build_tmp")
 
-		npmPinged := utils.NpmPing()
-		if !npmPinged {
-			return fmt.Errorf("npm_or_ping_failed")
-		}
+		err := service.buildStep(docId, BuildStepInstall, func() (string, error) {
+			if !utils.NpmPing() {
+				return "", fmt.Errorf("npm_or_ping_failed")
+			}
 
-		err := utils.RunNpmCommand(docPath, "install")
+			return utils.RunNpmCommandOutput(docPath, "install")
+		})
 		if err != nil {
 			return err
 		}
 
-		err = utils.RunNpxCommand(docPath, "tailwindcss", "build", "-i", "styles/input.css", "-o", "styles/output.css")
+		err = service.buildStep(docId, BuildStepTailwind, func() (string, error) {
+			return utils.RunNpxCommandOutput(docPath, "tailwindcss", "build", "-i", "styles/input.css", "-o", "styles/output.css")
+		})
 		if err != nil {
 			return err
 		}
@@ -1133,7 +1273,9 @@ func (service *DocService) RsPressBuild(docId uint, rebuild bool) error {
 			return err
 		}
 
-		err = utils.RunNpmCommand(docPath, "run", "build")
+		err = service.buildStep(docId, BuildStepRsPress, func() (string, error) {
+			return utils.RunNpmCommandOutput(docPath, "run", "build")
+		})
 		if err != nil {
 			return err
 		}
@@ -1157,28 +1299,39 @@ func (service *DocService) RsPressBuild(docId uint, rebuild bool) error {
 		}
 	}
 
-	filesContent, err := utils.Tree(buildPath)
-	if err != nil {
-		return err
+	if utils.PathExists(buildPath) {
+		err := service.buildStep(docId, BuildStepSiteFiles, func() (string, error) {
+			return service.writeSiteFiles(docId, buildPath)
+		})
+		if err != nil {
+			return err
+		}
 	}
 
-	err = db.ClearCacheByPrefix(fmt.Sprintf("rs|doc_%d", docId))
-	if err != nil {
-		return err
-	}
+	return service.buildStep(docId, BuildStepCache, func() (string, error) {
+		filesContent, err := utils.Tree(buildPath)
+		if err != nil {
+			return "", err
+		}
 
-	err = db.ClearCacheByPrefix(fmt.Sprintf("burl|doc_%d", docId))
-	if err != nil {
-		return err
-	}
+		err = db.ClearCacheByPrefix(fmt.Sprintf("rs|doc_%d", docId))
+		if err != nil {
+			return "", err
+		}
 
-	for fileName, content := range filesContent {
-		if err := db.SetKey([]byte(fmt.Sprintf("rs|doc_%d|%s", docId, fileName)), content, utils.GetContentType(fileName)); err != nil {
-			return err
+		err = db.ClearCacheByPrefix(fmt.Sprintf("burl|doc_%d", docId))
+		if err != nil {
+			return "", err
 		}
-	}
 
-	return nil
+		for fileName, content := range filesContent {
+			if err := db.SetKey([]byte(fmt.Sprintf("rs|doc_%d|%s", docId, fileName)), content, utils.GetContentType(fileName)); err != nil {
+				return "", err
+			}
+		}
+
+		return fmt.Sprintf("cached %d files\n", len(filesContent)), nil
+	})
 }
 
 func (service *DocService) GetRsPress(urlPath string) (uint, string, string, bool, error) {
@@ -1257,140 +1410,67 @@ func (service *DocService) GetRsPress(urlPath string) (uint, string, string, boo
 }
 
 func (service *DocService) AddBuildTrigger(docId uint, isDelete bool) error {
-	trigger := models.BuildTriggers{
-		DocumentationID: docId,
-		Triggered:       false,
-		CompletedAt:     nil,
-		IsDelete:        isDelete,
-	}
-	if err := service.DB.Create(&trigger).Error; err != nil {
-		return err
+	priority := BuildPriorityNormal
+	if isDelete {
+		priority = BuildPriorityHigh
 	}
-	return nil
-}
 
-func (service *DocService) DeleteJob() {
-	var triggers []models.BuildTriggers
-
-	if err := service.DB.
-		Where("triggered = ? AND is_delete = ?", false, true).
-		Find(&triggers).Error; err != nil {
-		logger.Error("Failed to fetch delete triggers", zap.Error(err))
-		return
-	}
+	return service.addBuildTrigger(docId, isDelete, priority)
+}
 
-	if len(triggers) == 0 {
-		return
-	}
+// addBuildTrigger queues a trigger unless an equivalent one is already
+// waiting to be claimed, in which case that one is reused. A reused trigger
+// keeps its attempts and backoff, so a failing build isn't retried right away.
+func (service *DocService) addBuildTrigger(docId uint, isDelete bool, priority int) error {
+	var pending models.BuildTriggers
 
-	skipSave := false
+	err := service.DB.
+		Where("documentation_id = ? AND is_delete = ? AND triggered = ? AND cancelled_at IS NULL AND lease_token = ?", docId, isDelete, false, "").
+		Order("id ASC").
+		First(&pending).Error
 
-	for _, trigger := range triggers {
-		docPath := filepath.Join(config.ParsedConfig.DataPath, "rspress_data", "doc_"+strconv.Itoa(int(trigger.DocumentationID)))
-		if utils.PathExists(docPath) {
-			logger.Info("Deleting doc folder", zap.Uint("doc_id", trigger.DocumentationID))
-			err := service.RemoveDocFolder(trigger.DocumentationID)
-			if err != nil {
-				logger.Error("(DeleteJob) Failed to remove doc folder", zap.Error(err))
-				skipSave = true
-			}
+	if err == nil {
+		if priority <= pending.Priority {
+			return nil
 		}
-	}
 
-	if !skipSave {
-		for i := range triggers {
-			triggers[i].Triggered = true
-			triggers[i].CompletedAt = utils.TimePtr(time.Now())
-		}
-
-		if err := service.DB.Save(&triggers).Error; err != nil {
-			logger.Error("(DeleteJob) Failed to save delete triggers", zap.Error(err))
-		}
-	}
-}
-
-func (service *DocService) BuildJob() {
-	var triggers []models.BuildTriggers
-
-	if err := service.DB.
-		Where("triggered = ? AND is_delete = ?", false, false).
-		Find(&triggers).Error; err != nil {
-		logger.Error("Failed to fetch build triggers", zap.Error(err))
-		return
+		return service.DB.Model(&pending).Update("priority", priority).Error
 	}
 
-	if len(triggers) == 0 {
-		return
-	}
-
-	validTriggers := triggers[:0]
-	for _, trigger := range triggers {
-		if service.IsDocIdValid(trigger.DocumentationID) {
-			validTriggers = append(validTriggers, trigger)
-		}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
 	}
 
-	if len(validTriggers) == 0 {
-		return
+	trigger := models.BuildTriggers{
+		DocumentationID: docId,
+		Triggered:       false,
+		CompletedAt:     nil,
+		IsDelete:        isDelete,
+		Status:          BuildStatusQueued,
+		Priority:        priority,
 	}
-
-	triggerGroups := make(map[uint][]models.BuildTriggers)
-	for _, trigger := range validTriggers {
-		triggerGroups[trigger.DocumentationID] = append(triggerGroups[trigger.DocumentationID], trigger)
+	if err := service.DB.Create(&trigger).Error; err != nil {
+		return err
 	}
 
-	for docID, groupTriggers := range triggerGroups {
-		start := time.Now()
-		err := service.UpdateWriteBuild(docID)
-		elapsed := time.Since(start)
-
-		if err != nil {
-			logger.Error("Failed to update write build",
-				zap.Uint("doc_id", docID),
-				zap.Error(err),
-				zap.Duration("elapsed", elapsed),
-				zap.Int("trigger_count", len(groupTriggers)))
-		} else {
-			logger.Info("RsPress Build completed",
-				zap.Uint("doc_id", docID),
-				zap.Duration("elapsed", elapsed),
-				zap.Int("trigger_count", len(groupTriggers)))
-
-			gitTime := time.Now()
-			err := service.GitDeploy(docID)
-			gitElapsed := time.Since(gitTime)
-
-			if err != nil {
-				logger.Error("Failed to deploy to git", zap.Error(err))
-			} else {
-				logger.Info("Git Deploy completed", zap.Uint("doc_id", docID), zap.Duration("elapsed", gitElapsed), zap.Int("trigger_count", len(groupTriggers)))
-			}
-			logger.Info(fmt.Sprintf("moving static assets to docs in doc_%d", docID))
-
-			docPath := utils.GetDocPathByID(docID, config.ParsedConfig)
-			docPublicAssetPath := filepath.Join(docPath, "public")
-			docsInternalPublicAssetPath := filepath.Join(docPath, "docs", "public")
-			err = utils.CopyOrOveriteDir(docPublicAssetPath, docsInternalPublicAssetPath)
+	service.BuildEvents.Publish(BuildEvent{
+		Type:            BuildEventTriggerCreated,
+		DocumentationID: docId,
+		TriggerID:       trigger.ID,
+		Status:          BuildStatusQueued,
+	})
 
-			if err != nil {
-				logger.Error(fmt.Sprintf("error copying files from %s to %s", docPublicAssetPath, docsInternalPublicAssetPath), zap.Error(err))
-			} else {
-				logger.Info("successfully copied files to target", zap.Uint("doc_id", docID))
-			}
-		}
+	return nil
+}
 
-		for i := range groupTriggers {
-			groupTriggers[i].Triggered = true
-			groupTriggers[i].CompletedAt = utils.TimePtr(time.Now())
-		}
+func (service *DocService) triggerRootBuild(docId uint) error {
+	parentDocId, _ := service.GetRootParentID(docId)
 
-		if err := service.DB.Save(&groupTriggers).Error; err != nil {
-			logger.Error("Failed to save build triggers",
-				zap.Uint("doc_id", docID),
-				zap.Error(err),
-				zap.Int("trigger_count", len(groupTriggers)))
-		}
+	if parentDocId == 0 {
+		return service.AddBuildTrigger(docId, false)
 	}
+
+	return service.AddBuildTrigger(parentDocId, false)
 }
 
 func (service *DocService) GetLastTrigger() ([]models.BuildTriggers, error) {
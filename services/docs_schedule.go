@@ -0,0 +1,491 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"git.difuse.io/Difuse/kalmia/db/models"
+	"git.difuse.io/Difuse/kalmia/logger"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const publishSchedulerPoll = 15 * time.Second
+
+// ScheduledItem is a page, page group or documentation version waiting to be
+// published or unpublished. Kind is one of the Resource kinds.
+type ScheduledItem struct {
+	Kind            string     `json:"kind"`
+	ID              uint       `json:"id"`
+	DocumentationID uint       `json:"documentationId"`
+	Title           string     `json:"title"`
+	PublishAt       *time.Time `json:"publishAt"`
+	UnpublishAt     *time.Time `json:"unpublishAt"`
+}
+
+func (item ScheduledItem) next() time.Time {
+	if item.PublishAt == nil || (item.UnpublishAt != nil && item.UnpublishAt.Before(*item.PublishAt)) {
+		return *item.UnpublishAt
+	}
+
+	return *item.PublishAt
+}
+
+// scheduleColumns refuses publish times that have already passed, publishing
+// right away is what the publish endpoints are for, and unpublish times that
+// don't come after the publish time.
+func scheduleColumns(publishAt, unpublishAt *time.Time) (map[string]interface{}, error) {
+	if publishAt != nil && publishAt.Before(time.Now()) {
+		return nil, fmt.Errorf("invalid_schedule")
+	}
+
+	if publishAt != nil && unpublishAt != nil && !unpublishAt.After(*publishAt) {
+		return nil, fmt.Errorf("invalid_schedule")
+	}
+
+	columns := map[string]interface{}{"publish_at": nil, "unpublish_at": nil}
+	if publishAt != nil {
+		columns["publish_at"] = publishAt.UTC()
+	}
+	if unpublishAt != nil {
+		columns["unpublish_at"] = unpublishAt.UTC()
+	}
+
+	return columns, nil
+}
+
+// SchedulePage sets when the page goes live and when it comes down again,
+// either may be nil. A page is published with the content it has then.
+func (service *DocService) SchedulePage(user models.User, id uint, publishAt, unpublishAt *time.Time) error {
+	page, err := service.getPageForPublishing(user, id)
+	if err != nil {
+		return err
+	}
+
+	columns, err := scheduleColumns(publishAt, unpublishAt)
+	if err != nil {
+		return err
+	}
+
+	if err := service.DB.Model(&models.Page{}).Where("id = ?", page.ID).UpdateColumns(columns).Error; err != nil {
+		return fmt.Errorf("failed_to_schedule_page")
+	}
+
+	service.audit("page.schedule", AuditEntityPage, page.ID,
+		map[string]interface{}{"publishAt": page.PublishAt, "unpublishAt": page.UnpublishAt},
+		map[string]interface{}{"publishAt": publishAt, "unpublishAt": unpublishAt})
+
+	return nil
+}
+
+func (service *DocService) SchedulePageGroup(user models.User, id uint, publishAt, unpublishAt *time.Time) error {
+	group, err := service.getPageGroupForPublishing(user, id)
+	if err != nil {
+		return err
+	}
+
+	columns, err := scheduleColumns(publishAt, unpublishAt)
+	if err != nil {
+		return err
+	}
+
+	if err := service.DB.Model(&models.PageGroup{}).Where("id = ?", group.ID).UpdateColumns(columns).Error; err != nil {
+		return fmt.Errorf("failed_to_schedule_page_group")
+	}
+
+	service.audit("page_group.schedule", AuditEntityPageGroup, group.ID,
+		map[string]interface{}{"publishAt": group.PublishAt, "unpublishAt": group.UnpublishAt},
+		map[string]interface{}{"publishAt": publishAt, "unpublishAt": unpublishAt})
+
+	return nil
+}
+
+// ScheduleDocumentation publishes or unpublishes every page and page group of
+// a documentation version at the given times.
+func (service *DocService) ScheduleDocumentation(user models.User, docId uint, publishAt, unpublishAt *time.Time) error {
+	var doc models.Documentation
+	if err := service.DB.Select("id", "publish_at", "unpublish_at").First(&doc, docId).Error; err != nil {
+		return fmt.Errorf("documentation_not_found")
+	}
+
+	if err := service.requireDocumentationRole(user.ID, docId, RoleMaintainer); err != nil {
+		return err
+	}
+
+	columns, err := scheduleColumns(publishAt, unpublishAt)
+	if err != nil {
+		return err
+	}
+
+	if err := service.DB.Model(&models.Documentation{}).Where("id = ?", docId).UpdateColumns(columns).Error; err != nil {
+		return fmt.Errorf("failed_to_schedule_documentation")
+	}
+
+	service.audit("documentation.schedule", AuditEntityDocumentation, docId,
+		map[string]interface{}{"publishAt": doc.PublishAt, "unpublishAt": doc.UnpublishAt},
+		map[string]interface{}{"publishAt": publishAt, "unpublishAt": unpublishAt})
+
+	return nil
+}
+
+// GetScheduled lists everything with a pending publish or unpublish time,
+// soonest first. A docId of 0 lists all documentations.
+func (service *DocService) GetScheduled(docId uint) ([]ScheduledItem, error) {
+	scheduled := "(publish_at IS NOT NULL OR unpublish_at IS NOT NULL)"
+
+	scope := func(column string) func(db *gorm.DB) *gorm.DB {
+		return func(db *gorm.DB) *gorm.DB {
+			db = db.Where(scheduled)
+			if docId != 0 {
+				db = db.Where(column+" = ?", docId)
+			}
+			return db
+		}
+	}
+
+	var pages []models.Page
+	if err := service.DB.Scopes(scope("documentation_id")).
+		Select("id", "documentation_id", "title", "publish_at", "unpublish_at").
+		Find(&pages).Error; err != nil {
+		return nil, fmt.Errorf("failed_to_get_pages")
+	}
+
+	var groups []models.PageGroup
+	if err := service.DB.Scopes(scope("documentation_id")).
+		Select("id", "documentation_id", "name", "publish_at", "unpublish_at").
+		Find(&groups).Error; err != nil {
+		return nil, fmt.Errorf("failed_to_get_page_groups")
+	}
+
+	var docs []models.Documentation
+	if err := service.DB.Scopes(scope("id")).
+		Select("id", "name", "version", "publish_at", "unpublish_at").
+		Find(&docs).Error; err != nil {
+		return nil, fmt.Errorf("failed_to_get_documentations")
+	}
+
+	items := make([]ScheduledItem, 0, len(pages)+len(groups)+len(docs))
+	for _, page := range pages {
+		items = append(items, ScheduledItem{Kind: ResourcePage, ID: page.ID, DocumentationID: page.DocumentationID, Title: page.Title, PublishAt: page.PublishAt, UnpublishAt: page.UnpublishAt})
+	}
+	for _, group := range groups {
+		items = append(items, ScheduledItem{Kind: ResourcePageGroup, ID: group.ID, DocumentationID: group.DocumentationID, Title: group.Name, PublishAt: group.PublishAt, UnpublishAt: group.UnpublishAt})
+	}
+	for _, doc := range docs {
+		items = append(items, ScheduledItem{Kind: ResourceDocumentation, ID: doc.ID, DocumentationID: doc.ID, Title: doc.Name + " " + doc.Version, PublishAt: doc.PublishAt, UnpublishAt: doc.UnpublishAt})
+	}
+
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].next().Before(items[j].next())
+	})
+
+	return items, nil
+}
+
+// PublishScheduler publishes and unpublishes what is due. Each item is
+// claimed by clearing its time in the same statement that checks it, so
+// several instances can run side by side. An item the link check refuses to
+// publish stays scheduled and is tried again on the next run.
+type PublishScheduler struct {
+	service *DocService
+}
+
+func NewPublishScheduler(service *DocService) *PublishScheduler {
+	return &PublishScheduler{service: service}
+}
+
+// Start runs the scheduler until ctx is cancelled.
+func (scheduler *PublishScheduler) Start(ctx context.Context) {
+	for {
+		if _, err := scheduler.RunOnce(); err != nil {
+			logger.Error("Publish scheduler failed", zap.Error(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(publishSchedulerPoll):
+		}
+	}
+}
+
+// RunOnce handles everything that is due and returns how many items changed.
+// Every documentation that changed gets a build trigger.
+func (scheduler *PublishScheduler) RunOnce() (int, error) {
+	service := scheduler.service
+	now := time.Now().UTC()
+	changed := make(map[uint]bool)
+	count := 0
+
+	steps := []func(time.Time) ([]uint, error){
+		scheduler.publishPages,
+		scheduler.unpublishPages,
+		scheduler.publishPageGroups,
+		scheduler.unpublishPageGroups,
+		scheduler.publishDocumentations,
+		scheduler.unpublishDocumentations,
+	}
+
+	var firstErr error
+	for _, step := range steps {
+		docIds, err := step(now)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+
+		for _, docId := range docIds {
+			changed[docId] = true
+		}
+		count += len(docIds)
+	}
+
+	for docId := range changed {
+		if err := service.triggerRootBuild(docId); err != nil {
+			logger.Error("Failed to add build trigger for scheduled change", zap.Uint("documentation_id", docId), zap.Error(err))
+		}
+	}
+
+	return count, firstErr
+}
+
+func (scheduler *PublishScheduler) publishPages(now time.Time) ([]uint, error) {
+	service := scheduler.service
+
+	var due []models.Page
+	if err := service.DB.Where("publish_at <= ?", now).Find(&due).Error; err != nil {
+		return nil, err
+	}
+
+	docIds := make([]uint, 0, len(due))
+	for _, page := range due {
+		editorId := page.AuthorID
+		if page.LastEditorID != nil {
+			editorId = *page.LastEditorID
+		}
+
+		var revisionId uint
+		err := service.DB.Transaction(func(tx *gorm.DB) error {
+			claim := tx.Model(&models.Page{}).Where("id = ? AND publish_at <= ?", page.ID, now).UpdateColumn("publish_at", nil)
+			if claim.Error != nil {
+				return claim.Error
+			}
+			if claim.RowsAffected == 0 {
+				return nil
+			}
+
+			if err := service.checkPublishLinks(tx, page.DocumentationID, []models.Page{page}); err != nil {
+				return err
+			}
+
+			var err error
+			if revisionId, err = publishPageContent(tx, page, editorId); err != nil {
+				return err
+			}
+
+			return checkPublishedSlugs(tx, page.DocumentationID)
+		})
+		if err != nil {
+			logger.Error("Failed to publish scheduled page", zap.Uint("page_id", page.ID), zap.Error(err))
+			continue
+		}
+		if revisionId == 0 {
+			continue
+		}
+
+		service.audit("page.publish", AuditEntityPage, page.ID,
+			map[string]interface{}{"publishedRevisionId": page.PublishedRevisionID},
+			map[string]interface{}{"publishedRevisionId": revisionId, "scheduled": true})
+		service.emitWebhookEvent(page.DocumentationID, WebhookEventPagePublished, newWebhookPage(page, page.DocumentationID, editorId))
+
+		docIds = append(docIds, page.DocumentationID)
+	}
+
+	return docIds, nil
+}
+
+func (scheduler *PublishScheduler) unpublishPages(now time.Time) ([]uint, error) {
+	service := scheduler.service
+
+	var due []models.Page
+	if err := service.DB.Where("unpublish_at <= ?", now).Find(&due).Error; err != nil {
+		return nil, err
+	}
+
+	docIds := make([]uint, 0, len(due))
+	for _, page := range due {
+		claim := service.DB.Model(&models.Page{}).Where("id = ? AND unpublish_at <= ?", page.ID, now).UpdateColumns(map[string]interface{}{
+			"published_revision_id": nil,
+			"published_at":          nil,
+			"unpublish_at":          nil,
+		})
+		if claim.Error != nil {
+			logger.Error("Failed to unpublish scheduled page", zap.Uint("page_id", page.ID), zap.Error(claim.Error))
+			continue
+		}
+		if claim.RowsAffected == 0 {
+			continue
+		}
+
+		service.audit("page.unpublish", AuditEntityPage, page.ID,
+			map[string]interface{}{"publishedRevisionId": page.PublishedRevisionID},
+			map[string]interface{}{"scheduled": true})
+		service.emitWebhookEvent(page.DocumentationID, WebhookEventPageUnpublished, newWebhookPage(page, page.DocumentationID, page.AuthorID))
+
+		docIds = append(docIds, page.DocumentationID)
+	}
+
+	return docIds, nil
+}
+
+func (scheduler *PublishScheduler) publishPageGroups(now time.Time) ([]uint, error) {
+	service := scheduler.service
+
+	var due []models.PageGroup
+	if err := service.DB.Where("publish_at <= ?", now).Find(&due).Error; err != nil {
+		return nil, err
+	}
+
+	docIds := make([]uint, 0, len(due))
+	for _, group := range due {
+		claim := service.DB.Model(&models.PageGroup{}).Where("id = ? AND publish_at <= ?", group.ID, now).UpdateColumns(map[string]interface{}{
+			"published_name": gorm.Expr("name"),
+			"published_at":   now,
+			"publish_at":     nil,
+		})
+		if claim.Error != nil {
+			logger.Error("Failed to publish scheduled page group", zap.Uint("page_group_id", group.ID), zap.Error(claim.Error))
+			continue
+		}
+		if claim.RowsAffected == 0 {
+			continue
+		}
+
+		service.audit("page_group.publish", AuditEntityPageGroup, group.ID,
+			map[string]interface{}{"publishedName": group.PublishedName, "published": group.PublishedAt != nil},
+			map[string]interface{}{"publishedName": group.Name, "published": true, "scheduled": true})
+
+		docIds = append(docIds, group.DocumentationID)
+	}
+
+	return docIds, nil
+}
+
+func (scheduler *PublishScheduler) unpublishPageGroups(now time.Time) ([]uint, error) {
+	service := scheduler.service
+
+	var due []models.PageGroup
+	if err := service.DB.Where("unpublish_at <= ?", now).Find(&due).Error; err != nil {
+		return nil, err
+	}
+
+	docIds := make([]uint, 0, len(due))
+	for _, group := range due {
+		claim := service.DB.Model(&models.PageGroup{}).Where("id = ? AND unpublish_at <= ?", group.ID, now).UpdateColumns(map[string]interface{}{
+			"published_at": nil,
+			"unpublish_at": nil,
+		})
+		if claim.Error != nil {
+			logger.Error("Failed to unpublish scheduled page group", zap.Uint("page_group_id", group.ID), zap.Error(claim.Error))
+			continue
+		}
+		if claim.RowsAffected == 0 {
+			continue
+		}
+
+		service.audit("page_group.unpublish", AuditEntityPageGroup, group.ID,
+			map[string]interface{}{"publishedName": group.PublishedName},
+			map[string]interface{}{"scheduled": true})
+
+		docIds = append(docIds, group.DocumentationID)
+	}
+
+	return docIds, nil
+}
+
+func (scheduler *PublishScheduler) publishDocumentations(now time.Time) ([]uint, error) {
+	service := scheduler.service
+
+	var due []models.Documentation
+	if err := service.DB.Select("id", "author_id").Where("publish_at <= ?", now).Find(&due).Error; err != nil {
+		return nil, err
+	}
+
+	docIds := make([]uint, 0, len(due))
+	for _, doc := range due {
+		claimed := false
+		var result PublishResult
+
+		err := service.DB.Transaction(func(tx *gorm.DB) error {
+			claim := tx.Model(&models.Documentation{}).Where("id = ? AND publish_at <= ?", doc.ID, now).UpdateColumn("publish_at", nil)
+			if claim.Error != nil {
+				return claim.Error
+			}
+			if claim.RowsAffected == 0 {
+				return nil
+			}
+
+			claimed = true
+			var err error
+			result, err = service.publishDocumentationContent(tx, doc.ID, doc.AuthorID)
+			return err
+		})
+		if err != nil {
+			logger.Error("Failed to publish scheduled documentation", zap.Uint("documentation_id", doc.ID), zap.Error(err))
+			continue
+		}
+		if !claimed {
+			continue
+		}
+
+		service.audit("documentation.publish", AuditEntityDocumentation, doc.ID, nil, map[string]interface{}{"pages": result.Pages, "pageGroups": result.PageGroups, "scheduled": true})
+
+		docIds = append(docIds, doc.ID)
+	}
+
+	return docIds, nil
+}
+
+func (scheduler *PublishScheduler) unpublishDocumentations(now time.Time) ([]uint, error) {
+	service := scheduler.service
+
+	var due []models.Documentation
+	if err := service.DB.Select("id").Where("unpublish_at <= ?", now).Find(&due).Error; err != nil {
+		return nil, err
+	}
+
+	docIds := make([]uint, 0, len(due))
+	for _, doc := range due {
+		claimed := false
+		var result PublishResult
+
+		err := service.DB.Transaction(func(tx *gorm.DB) error {
+			claim := tx.Model(&models.Documentation{}).Where("id = ? AND unpublish_at <= ?", doc.ID, now).UpdateColumn("unpublish_at", nil)
+			if claim.Error != nil {
+				return claim.Error
+			}
+			if claim.RowsAffected == 0 {
+				return nil
+			}
+
+			claimed = true
+			var err error
+			result, err = unpublishDocumentationContent(tx, doc.ID)
+			return err
+		})
+		if err != nil {
+			logger.Error("Failed to unpublish scheduled documentation", zap.Uint("documentation_id", doc.ID), zap.Error(err))
+			continue
+		}
+		if !claimed {
+			continue
+		}
+
+		service.audit("documentation.unpublish", AuditEntityDocumentation, doc.ID, nil, map[string]interface{}{"pages": result.Pages, "pageGroups": result.PageGroups, "scheduled": true})
+
+		docIds = append(docIds, doc.ID)
+	}
+
+	return docIds, nil
+}
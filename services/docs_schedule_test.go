@@ -0,0 +1,186 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"git.difuse.io/Difuse/kalmia/db/models"
+)
+
+func TestPublishScheduler(t *testing.T) {
+	if TestDocService == nil {
+		t.Fatal("TestDocService is nil")
+	}
+
+	db := TestDocService.DB
+	user := models.User{ID: 1}
+
+	doc := models.Documentation{Name: "Scheduled", Version: "1.0.0", BaseURL: "/scheduled", AuthorID: 1}
+	if err := db.Create(&doc).Error; err != nil {
+		t.Fatalf("Failed to create documentation: %v", err)
+	}
+
+	page := models.Page{DocumentationID: doc.ID, Title: "Launch", Slug: "/launch", Content: "[]", AuthorID: 1}
+	if err := TestDocService.CreatePage(&page); err != nil {
+		t.Fatalf("CreatePage returned an error: %v", err)
+	}
+
+	group := models.PageGroup{DocumentationID: doc.ID, Name: "Launch guides", AuthorID: 1}
+	if _, err := TestDocService.CreatePageGroup(&group); err != nil {
+		t.Fatalf("CreatePageGroup returned an error: %v", err)
+	}
+
+	scheduler := NewPublishScheduler(TestDocService)
+	past := time.Now().Add(-time.Minute)
+	future := time.Now().Add(time.Hour)
+
+	// due moves a publish time into the past, as if it had come.
+	due := func(model interface{}, id uint) {
+		t.Helper()
+		if err := db.Model(model).Where("id = ?", id).UpdateColumn("publish_at", past).Error; err != nil {
+			t.Fatalf("Failed to backdate the schedule: %v", err)
+		}
+	}
+
+	t.Run("Rejects unpublishing before publishing", func(t *testing.T) {
+		if err := TestDocService.SchedulePage(user, page.ID, &future, &past); err == nil || err.Error() != "invalid_schedule" {
+			t.Errorf("Expected invalid_schedule, got %v", err)
+		}
+
+		if err := TestDocService.SchedulePageGroup(user, group.ID, &future, &future); err == nil || err.Error() != "invalid_schedule" {
+			t.Errorf("Expected invalid_schedule, got %v", err)
+		}
+	})
+
+	t.Run("Rejects publishing in the past", func(t *testing.T) {
+		if err := TestDocService.SchedulePage(user, page.ID, &past, nil); err == nil || err.Error() != "invalid_schedule" {
+			t.Errorf("Expected invalid_schedule for the page, got %v", err)
+		}
+
+		if err := TestDocService.SchedulePageGroup(user, group.ID, &past, nil); err == nil || err.Error() != "invalid_schedule" {
+			t.Errorf("Expected invalid_schedule for the page group, got %v", err)
+		}
+
+		if err := TestDocService.ScheduleDocumentation(user, doc.ID, &past, &future); err == nil || err.Error() != "invalid_schedule" {
+			t.Errorf("Expected invalid_schedule for the documentation, got %v", err)
+		}
+	})
+
+	t.Run("Lists scheduled items soonest first", func(t *testing.T) {
+		soon := time.Now().Add(time.Minute)
+		if err := TestDocService.SchedulePage(user, page.ID, &soon, nil); err != nil {
+			t.Fatalf("SchedulePage returned an error: %v", err)
+		}
+
+		if err := TestDocService.SchedulePageGroup(user, group.ID, &future, nil); err != nil {
+			t.Fatalf("SchedulePageGroup returned an error: %v", err)
+		}
+
+		items, err := TestDocService.GetScheduled(doc.ID)
+		if err != nil {
+			t.Fatalf("GetScheduled returned an error: %v", err)
+		}
+
+		if len(items) != 2 || items[0].Kind != ResourcePage || items[1].Kind != ResourcePageGroup {
+			t.Errorf("Expected the page then the page group, got %+v", items)
+		}
+	})
+
+	t.Run("Publishes what is due", func(t *testing.T) {
+		due(&models.Page{}, page.ID)
+
+		if _, err := scheduler.RunOnce(); err != nil {
+			t.Fatalf("RunOnce returned an error: %v", err)
+		}
+
+		var stored models.Page
+		if err := db.First(&stored, page.ID).Error; err != nil {
+			t.Fatalf("Failed to get page: %v", err)
+		}
+
+		if stored.PublishedRevisionID == nil || stored.PublishAt != nil {
+			t.Errorf("Expected the page to be published and unscheduled, got %+v", stored)
+		}
+
+		var storedGroup models.PageGroup
+		if err := db.First(&storedGroup, group.ID).Error; err != nil {
+			t.Fatalf("Failed to get page group: %v", err)
+		}
+
+		if storedGroup.PublishedAt != nil || storedGroup.PublishAt == nil {
+			t.Errorf("Expected the page group to stay scheduled, got %+v", storedGroup)
+		}
+
+		var triggers int64
+		if err := db.Model(&models.BuildTriggers{}).Where("documentation_id = ?", doc.ID).Count(&triggers).Error; err != nil {
+			t.Fatalf("Failed to count build triggers: %v", err)
+		}
+
+		if triggers == 0 {
+			t.Error("Expected a build trigger")
+		}
+
+		changed, err := scheduler.RunOnce()
+		if err != nil || changed != 0 {
+			t.Errorf("Expected nothing left to do, got %d (%v)", changed, err)
+		}
+	})
+
+	t.Run("Unpublishes a documentation version", func(t *testing.T) {
+		if err := TestDocService.SchedulePageGroup(user, group.ID, nil, nil); err != nil {
+			t.Fatalf("SchedulePageGroup returned an error: %v", err)
+		}
+
+		if err := TestDocService.ScheduleDocumentation(user, doc.ID, nil, &past); err != nil {
+			t.Fatalf("ScheduleDocumentation returned an error: %v", err)
+		}
+
+		if _, err := scheduler.RunOnce(); err != nil {
+			t.Fatalf("RunOnce returned an error: %v", err)
+		}
+
+		if _, err := TestDocService.getPublishedPage(page.ID); err == nil || err.Error() != "page_not_published" {
+			t.Errorf("Expected the page to be unpublished, got %v", err)
+		}
+
+		items, err := TestDocService.GetScheduled(doc.ID)
+		if err != nil {
+			t.Fatalf("GetScheduled returned an error: %v", err)
+		}
+
+		if len(items) != 0 {
+			t.Errorf("Expected nothing scheduled, got %+v", items)
+		}
+	})
+
+	t.Run("Broken links keep a page scheduled", func(t *testing.T) {
+		TestConfig.LinkCheck.BlockPublish = true
+		defer func() {
+			TestConfig.LinkCheck.BlockPublish = false
+		}()
+
+		broken := models.Page{DocumentationID: doc.ID, Title: "Broken", Slug: "/broken", Content: linkContent(t, []string{"/scheduled/guides/missing"}, nil), AuthorID: 1}
+		if err := TestDocService.CreatePage(&broken); err != nil {
+			t.Fatalf("CreatePage returned an error: %v", err)
+		}
+
+		if err := TestDocService.SchedulePage(user, broken.ID, &future, nil); err != nil {
+			t.Fatalf("SchedulePage returned an error: %v", err)
+		}
+		due(&models.Page{}, broken.ID)
+
+		changed, err := scheduler.RunOnce()
+		if err != nil || changed != 0 {
+			t.Errorf("Expected nothing to change, got %d (%v)", changed, err)
+		}
+
+		var stored models.Page
+		if err := db.First(&stored, broken.ID).Error; err != nil {
+			t.Fatalf("Failed to get page: %v", err)
+		}
+
+		if stored.PublishedRevisionID != nil || stored.PublishAt == nil {
+			t.Errorf("Expected the page to stay unpublished and scheduled, got %+v", stored)
+		}
+	})
+}
@@ -0,0 +1,338 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"strings"
+
+	"git.difuse.io/Difuse/kalmia/db/models"
+	"git.difuse.io/Difuse/kalmia/logger"
+	"git.difuse.io/Difuse/kalmia/utils"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	SearchKindDocumentation = "documentation"
+	SearchKindPage          = "page"
+	SearchKindPageGroup     = "page_group"
+)
+
+// The database marks matches in snippets with these, they're swapped for
+// <mark> once the rest of the snippet is escaped.
+const (
+	searchMarkStart = "\x02"
+	searchMarkStop  = "\x03"
+)
+
+var searchMarks = strings.NewReplacer(searchMarkStart, "<mark>", searchMarkStop, "</mark>")
+
+type SearchOptions struct {
+	Query           string
+	DocumentationID uint
+	// When not nil, results are limited to these documentations.
+	DocumentationIDs []uint
+	Version          string
+	AuthorID         uint
+	Kind             string
+	Limit            int
+	Offset           int
+}
+
+// SearchResult is a match of a search. Its snippet is HTML, escaped text with
+// the matches in <mark>.
+type SearchResult struct {
+	Kind            string  `json:"kind"`
+	ID              uint    `json:"id"`
+	DocumentationID uint    `json:"documentationId"`
+	Version         string  `json:"version"`
+	AuthorID        uint    `json:"authorId"`
+	Title           string  `json:"title"`
+	Slug            string  `json:"slug,omitempty"`
+	Snippet         string  `json:"snippet"`
+	Rank            float64 `gorm:"column:search_rank" json:"rank"`
+}
+
+func upsertSearchDocument(tx *gorm.DB, document models.SearchDocument) error {
+	var existing models.SearchDocument
+
+	err := tx.Where("kind = ? AND ref_id = ?", document.Kind, document.RefID).First(&existing).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return fmt.Errorf("failed_to_update_search_index")
+	}
+
+	document.ID = existing.ID
+
+	if err := tx.Save(&document).Error; err != nil {
+		return fmt.Errorf("failed_to_update_search_index")
+	}
+
+	return nil
+}
+
+func indexPage(tx *gorm.DB, page models.Page) error {
+	body := ""
+
+	blocks, err := utils.ParseBlocks(page.Content)
+	if err == nil {
+		body = utils.BlocksToPlainText(blocks)
+	}
+
+	return upsertSearchDocument(tx, models.SearchDocument{
+		Kind:            SearchKindPage,
+		RefID:           page.ID,
+		DocumentationID: page.DocumentationID,
+		AuthorID:        page.AuthorID,
+		Title:           page.Title,
+		Slug:            page.Slug,
+		Body:            body,
+	})
+}
+
+func indexPageGroup(tx *gorm.DB, group models.PageGroup) error {
+	return upsertSearchDocument(tx, models.SearchDocument{
+		Kind:            SearchKindPageGroup,
+		RefID:           group.ID,
+		DocumentationID: group.DocumentationID,
+		AuthorID:        group.AuthorID,
+		Title:           group.Name,
+	})
+}
+
+// indexDocumentation indexes a documentation version by its name and
+// description.
+func indexDocumentation(tx *gorm.DB, doc models.Documentation) error {
+	return upsertSearchDocument(tx, models.SearchDocument{
+		Kind:            SearchKindDocumentation,
+		RefID:           doc.ID,
+		DocumentationID: doc.ID,
+		AuthorID:        doc.AuthorID,
+		Title:           doc.Name,
+		Body:            doc.Description,
+	})
+}
+
+// indexDocumentationContent indexes a documentation version along with all
+// of its pages and page groups.
+func indexDocumentationContent(tx *gorm.DB, docId uint) error {
+	var doc models.Documentation
+	if err := tx.First(&doc, docId).Error; err != nil {
+		return fmt.Errorf("documentation_not_found")
+	}
+
+	if err := indexDocumentation(tx, doc); err != nil {
+		return err
+	}
+
+	var pages []models.Page
+	if err := tx.Where("documentation_id = ?", docId).Find(&pages).Error; err != nil {
+		return fmt.Errorf("failed_to_get_pages")
+	}
+
+	for _, page := range pages {
+		if err := indexPage(tx, page); err != nil {
+			return err
+		}
+	}
+
+	var groups []models.PageGroup
+	if err := tx.Where("documentation_id = ?", docId).Find(&groups).Error; err != nil {
+		return fmt.Errorf("failed_to_get_page_groups")
+	}
+
+	for _, group := range groups {
+		if err := indexPageGroup(tx, group); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func removeFromSearchIndex(tx *gorm.DB, kind string, refIds ...uint) error {
+	if len(refIds) == 0 {
+		return nil
+	}
+
+	if err := tx.Where("kind = ? AND ref_id IN ?", kind, refIds).Delete(&models.SearchDocument{}).Error; err != nil {
+		return fmt.Errorf("failed_to_update_search_index")
+	}
+
+	return nil
+}
+
+// EnsureSearchIndex rebuilds the search index when it has drifted from the
+// documentations, pages and page groups it covers, e.g. on the first start
+// after upgrading.
+func (service *DocService) EnsureSearchIndex() error {
+	var indexed, docs, pages, groups int64
+
+	if err := service.DB.Model(&models.SearchDocument{}).Count(&indexed).Error; err != nil {
+		return fmt.Errorf("failed_to_count_search_documents")
+	}
+
+	if err := service.DB.Model(&models.Documentation{}).Count(&docs).Error; err != nil {
+		return fmt.Errorf("failed_to_count_documentations")
+	}
+
+	if err := service.DB.Model(&models.Page{}).Count(&pages).Error; err != nil {
+		return fmt.Errorf("failed_to_count_pages")
+	}
+
+	if err := service.DB.Model(&models.PageGroup{}).Count(&groups).Error; err != nil {
+		return fmt.Errorf("failed_to_count_page_groups")
+	}
+
+	if indexed == docs+pages+groups {
+		return nil
+	}
+
+	logger.Info("Rebuilding search index", zap.Int64("indexed", indexed), zap.Int64("expected", docs+pages+groups))
+
+	return service.RebuildSearchIndex()
+}
+
+func (service *DocService) RebuildSearchIndex() error {
+	return service.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("1 = 1").Delete(&models.SearchDocument{}).Error; err != nil {
+			return fmt.Errorf("failed_to_clear_search_index")
+		}
+
+		var docIds []uint
+		if err := tx.Model(&models.Documentation{}).Pluck("id", &docIds).Error; err != nil {
+			return fmt.Errorf("failed_to_get_documentations")
+		}
+
+		for _, docId := range docIds {
+			if err := indexDocumentationContent(tx, docId); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (service *DocService) searchDocumentationIDs(documentationId uint, version string) ([]uint, error) {
+	query := service.DB.Model(&models.Documentation{})
+
+	if documentationId != 0 {
+		rootId, err := service.GetRootParentID(documentationId)
+		if err != nil {
+			return nil, fmt.Errorf("documentation_not_found")
+		}
+
+		if version == "" {
+			return []uint{documentationId}, nil
+		}
+
+		query = query.Where("id = ? OR cloned_from = ?", rootId, rootId)
+	}
+
+	var ids []uint
+	if err := query.Where("version = ?", version).Pluck("id", &ids).Error; err != nil {
+		return nil, fmt.Errorf("failed_to_get_documentations")
+	}
+
+	return ids, nil
+}
+
+func (service *DocService) Search(opts SearchOptions) ([]SearchResult, int64, error) {
+	results := make([]SearchResult, 0)
+
+	if len(utils.SearchTerms(opts.Query)) == 0 {
+		return results, 0, nil
+	}
+
+	if opts.Limit <= 0 || opts.Limit > 100 {
+		opts.Limit = 20
+	}
+
+	if opts.Offset < 0 {
+		opts.Offset = 0
+	}
+
+	var conditions []string
+	var args []interface{}
+
+	if opts.DocumentationID != 0 || opts.Version != "" {
+		docIds, err := service.searchDocumentationIDs(opts.DocumentationID, opts.Version)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if len(docIds) == 0 {
+			return results, 0, nil
+		}
+
+		conditions = append(conditions, "sd.documentation_id IN ?")
+		args = append(args, docIds)
+	}
+
+	if opts.DocumentationIDs != nil {
+		if len(opts.DocumentationIDs) == 0 {
+			return results, 0, nil
+		}
+
+		conditions = append(conditions, "sd.documentation_id IN ?")
+		args = append(args, opts.DocumentationIDs)
+	}
+
+	if opts.AuthorID != 0 {
+		conditions = append(conditions, "sd.author_id = ?")
+		args = append(args, opts.AuthorID)
+	}
+
+	if opts.Kind != "" {
+		conditions = append(conditions, "sd.kind = ?")
+		args = append(args, opts.Kind)
+	}
+
+	filter := ""
+	if len(conditions) > 0 {
+		filter = " AND " + strings.Join(conditions, " AND ")
+	}
+
+	var source, match, selectRank, selectSnippet, tsQuery string
+
+	if strings.ToLower(service.DB.Dialector.Name()) == "postgres" {
+		source = "search_documents sd CROSS JOIN to_tsquery('simple', ?) q"
+		match = "sd.search_vector @@ q"
+		selectRank = "ts_rank(sd.search_vector, q)"
+		selectSnippet = "ts_headline('simple', concat_ws(' ', sd.title, sd.body), q, 'StartSel=" + searchMarkStart + ", StopSel=" + searchMarkStop + ", MaxWords=24, MinWords=8, MaxFragments=2')"
+		tsQuery = utils.BuildTSQuery(opts.Query)
+	} else {
+		source = "search_documents_fts JOIN search_documents sd ON sd.id = search_documents_fts.rowid"
+		match = "search_documents_fts MATCH ?"
+		selectRank = "-bm25(search_documents_fts, 10.0, 5.0, 1.0)"
+		selectSnippet = "snippet(search_documents_fts, -1, '" + searchMarkStart + "', '" + searchMarkStop + "', '…', 24)"
+		tsQuery = utils.BuildFTS5Query(opts.Query)
+	}
+
+	args = append([]interface{}{tsQuery}, args...)
+
+	var total int64
+	if err := service.DB.Raw("SELECT COUNT(*) FROM "+source+" WHERE "+match+filter, args...).Scan(&total).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed_to_search")
+	}
+
+	query := fmt.Sprintf(`SELECT sd.kind AS kind, sd.ref_id AS id, sd.documentation_id AS documentation_id,
+		d.version AS version, sd.author_id AS author_id, sd.title AS title, sd.slug AS slug,
+		%s AS snippet, %s AS search_rank
+		FROM %s LEFT JOIN documentations d ON d.id = sd.documentation_id
+		WHERE %s%s
+		ORDER BY search_rank DESC, sd.id ASC LIMIT ? OFFSET ?`, selectSnippet, selectRank, source, match, filter)
+
+	args = append(args, opts.Limit, opts.Offset)
+
+	if err := service.DB.Raw(query, args...).Scan(&results).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed_to_search")
+	}
+
+	for i := range results {
+		results[i].Snippet = searchMarks.Replace(html.EscapeString(results[i].Snippet))
+	}
+
+	return results, total, nil
+}
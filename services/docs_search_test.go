@@ -0,0 +1,176 @@
+package services
+
+import (
+	"strings"
+	"testing"
+
+	"git.difuse.io/Difuse/kalmia/db/models"
+)
+
+func TestSearch(t *testing.T) {
+	if TestDocService == nil {
+		t.Fatal("TestDocService is nil")
+	}
+
+	db := TestDocService.DB
+
+	doc := models.Documentation{Name: "Search Docs", Version: "1.0.0", BaseURL: "/search-docs", AuthorID: 1}
+	if err := db.Create(&doc).Error; err != nil {
+		t.Fatalf("Failed to create documentation: %v", err)
+	}
+
+	version := models.Documentation{Name: "Search Docs", Version: "2.0.0", BaseURL: "/search-docs", AuthorID: 1, ClonedFrom: &doc.ID}
+	if err := db.Create(&version).Error; err != nil {
+		t.Fatalf("Failed to create documentation version: %v", err)
+	}
+
+	content := `[{"id":"1","type":"paragraph","props":{},"content":[{"type":"text","text":"Kalmia supports webhooks for every build","styles":{}}],"children":[]}]`
+
+	pages := []models.Page{
+		{DocumentationID: doc.ID, Title: "Webhooks", Slug: "/webhooks", Content: content, AuthorID: 1},
+		{DocumentationID: doc.ID, Title: "Installation", Slug: "/installation", Content: `"[]"`, AuthorID: 2},
+		{DocumentationID: version.ID, Title: "Webhooks", Slug: "/webhooks", Content: content, AuthorID: 1},
+	}
+
+	for i := range pages {
+		if err := db.Create(&pages[i]).Error; err != nil {
+			t.Fatalf("Failed to create page: %v", err)
+		}
+		if err := indexPage(db, pages[i]); err != nil {
+			t.Fatalf("Failed to index page: %v", err)
+		}
+	}
+
+	group := models.PageGroup{DocumentationID: doc.ID, Name: "Webhook recipes", AuthorID: 2}
+	if err := db.Create(&group).Error; err != nil {
+		t.Fatalf("Failed to create page group: %v", err)
+	}
+	if err := indexPageGroup(db, group); err != nil {
+		t.Fatalf("Failed to index page group: %v", err)
+	}
+
+	t.Run("Matches titles and content across versions", func(t *testing.T) {
+		results, total, err := TestDocService.Search(SearchOptions{Query: "webhook"})
+		if err != nil {
+			t.Fatalf("Search returned an error: %v", err)
+		}
+
+		if total != 3 || len(results) != 3 {
+			t.Fatalf("Expected 3 results, got %d (total %d)", len(results), total)
+		}
+
+		if !strings.Contains(results[0].Snippet, "<mark>") {
+			t.Errorf("Expected highlighted snippet, got %q", results[0].Snippet)
+		}
+	})
+
+	t.Run("Filters by version", func(t *testing.T) {
+		results, _, err := TestDocService.Search(SearchOptions{Query: "webhooks", DocumentationID: doc.ID, Version: "2.0.0"})
+		if err != nil {
+			t.Fatalf("Search returned an error: %v", err)
+		}
+
+		if len(results) != 1 || results[0].DocumentationID != version.ID || results[0].Version != "2.0.0" {
+			t.Errorf("Expected one result from version 2.0.0, got %+v", results)
+		}
+	})
+
+	t.Run("Filters by author and kind", func(t *testing.T) {
+		results, _, err := TestDocService.Search(SearchOptions{Query: "webhook", AuthorID: 2, Kind: SearchKindPageGroup})
+		if err != nil {
+			t.Fatalf("Search returned an error: %v", err)
+		}
+
+		if len(results) != 1 || results[0].ID != group.ID {
+			t.Errorf("Expected the page group, got %+v", results)
+		}
+	})
+
+	t.Run("Removed pages are no longer found", func(t *testing.T) {
+		if err := removeFromSearchIndex(db, SearchKindPage, pages[0].ID, pages[2].ID); err != nil {
+			t.Fatalf("Failed to remove pages from index: %v", err)
+		}
+
+		results, _, err := TestDocService.Search(SearchOptions{Query: "builds"})
+		if err != nil {
+			t.Fatalf("Search returned an error: %v", err)
+		}
+
+		if len(results) != 0 {
+			t.Errorf("Expected no results, got %+v", results)
+		}
+	})
+
+	t.Run("Snippets are escaped", func(t *testing.T) {
+		page := models.Page{DocumentationID: doc.ID, Title: "Embeds", Slug: "/embeds", AuthorID: 1,
+			Content: `[{"id":"1","type":"paragraph","props":{},"content":[{"type":"text","text":"<img src=x onerror=alert(1)> iframes work too","styles":{}}],"children":[]}]`}
+		if err := db.Create(&page).Error; err != nil {
+			t.Fatalf("Failed to create page: %v", err)
+		}
+		if err := indexPage(db, page); err != nil {
+			t.Fatalf("Failed to index page: %v", err)
+		}
+
+		results, _, err := TestDocService.Search(SearchOptions{Query: "iframes"})
+		if err != nil {
+			t.Fatalf("Search returned an error: %v", err)
+		}
+
+		if len(results) != 1 {
+			t.Fatalf("Expected one result, got %+v", results)
+		}
+
+		snippet := results[0].Snippet
+		if strings.Contains(snippet, "<img") || !strings.Contains(snippet, "&lt;img") || !strings.Contains(snippet, "<mark>iframes</mark>") {
+			t.Errorf("Expected an escaped snippet with the match marked, got %q", snippet)
+		}
+	})
+
+	t.Run("Finds documentations until they're deleted", func(t *testing.T) {
+		var admin models.User
+		if err := db.Where("username = ?", "admin").First(&admin).Error; err != nil {
+			t.Fatalf("Failed to get admin: %v", err)
+		}
+
+		guide := models.Documentation{Name: "Search Docs", Version: "3.0.0", Description: "Deployment handbook", BaseURL: "/search-docs", AuthorID: 1, ClonedFrom: &doc.ID}
+		if err := db.Create(&guide).Error; err != nil {
+			t.Fatalf("Failed to create documentation version: %v", err)
+		}
+		if err := indexDocumentation(db, guide); err != nil {
+			t.Fatalf("Failed to index documentation: %v", err)
+		}
+
+		search := func() []SearchResult {
+			results, _, err := TestDocService.Search(SearchOptions{Query: "handbook", Kind: SearchKindDocumentation})
+			if err != nil {
+				t.Fatalf("Search returned an error: %v", err)
+			}
+			return results
+		}
+
+		if results := search(); len(results) != 1 || results[0].ID != guide.ID || results[0].Version != "3.0.0" {
+			t.Fatalf("Expected the documentation, got %+v", results)
+		}
+
+		if err := TestDocService.DeleteDocumentation(admin, guide.ID); err != nil {
+			t.Fatalf("DeleteDocumentation returned an error: %v", err)
+		}
+
+		if results := search(); len(results) != 0 {
+			t.Errorf("Expected no results after deleting, got %+v", results)
+		}
+
+		var item models.TrashItem
+		if err := db.Where("kind = ? AND ref_id = ?", ResourceDocumentation, guide.ID).First(&item).Error; err != nil {
+			t.Fatalf("Failed to get trash item: %v", err)
+		}
+
+		if err := TestDocService.RestoreTrashItem(admin, item.ID); err != nil {
+			t.Fatalf("RestoreTrashItem returned an error: %v", err)
+		}
+
+		if results := search(); len(results) != 1 || results[0].ID != guide.ID {
+			t.Errorf("Expected the restored documentation, got %+v", results)
+		}
+	})
+}
@@ -0,0 +1,286 @@
+package services
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"git.difuse.io/Difuse/kalmia/db/models"
+	"git.difuse.io/Difuse/kalmia/utils"
+)
+
+// The files written next to the RsPress output of a site.
+const (
+	SitemapFile = "sitemap.xml"
+	RobotsFile  = "robots.txt"
+	FeedFile    = "atom.xml"
+)
+
+// feedEntryLimit is how many recently updated pages the feed lists.
+const feedEntryLimit = 20
+
+type sitemapLink struct {
+	Rel      string `xml:"rel,attr"`
+	Hreflang string `xml:"hreflang,attr"`
+	Href     string `xml:"href,attr"`
+}
+
+type sitemapURL struct {
+	Loc     string        `xml:"loc"`
+	LastMod string        `xml:"lastmod,omitempty"`
+	Links   []sitemapLink `xml:"xhtml:link"`
+}
+
+type sitemapURLSet struct {
+	XMLName    xml.Name     `xml:"urlset"`
+	Xmlns      string       `xml:"xmlns,attr"`
+	XmlnsXhtml string       `xml:"xmlns:xhtml,attr"`
+	URLs       []sitemapURL `xml:"url"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+}
+
+type atomEntry struct {
+	Title   string   `xml:"title"`
+	ID      string   `xml:"id"`
+	Link    atomLink `xml:"link"`
+	Updated string   `xml:"updated"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"feed"`
+	Xmlns   string      `xml:"xmlns,attr"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Links   []atomLink  `xml:"link"`
+	Author  atomAuthor  `xml:"author"`
+	Updated string      `xml:"updated"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+// sitePage is a page as it is on the built site.
+type sitePage struct {
+	page models.Page
+	url  string
+}
+
+// url returns the full URL of a path of a version of a site.
+func (site *linkSite) url(version string, pagePath string) string {
+	prefix := site.siteURL + site.baseURL
+	if version != site.latest {
+		prefix += "/" + version
+	}
+
+	return prefix + pagePath
+}
+
+// sitePages lists every page on a site.
+func (site *linkSite) sitePages() []sitePage {
+	pages := make([]sitePage, 0)
+
+	for _, version := range site.versions {
+		for _, page := range version.pages {
+			pages = append(pages, sitePage{
+				page: page,
+				url:  site.url(version.version, version.pagePaths[page.ID]),
+			})
+		}
+	}
+
+	return pages
+}
+
+func siteTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+
+	return t.UTC().Format(time.RFC3339)
+}
+
+// sitemap lists every page of every version, in every language the site is
+// in. Each points search engines at its translations and at the copy of it in
+// the latest version as the default one.
+func (site *linkSite) sitemap() ([]byte, error) {
+	urlSet := sitemapURLSet{
+		Xmlns:      "http://www.sitemaps.org/schemas/sitemap/0.9",
+		XmlnsXhtml: "http://www.w3.org/1999/xhtml",
+	}
+
+	latestPaths := make(map[string]bool)
+	if latest, ok := site.byName[site.latest]; ok {
+		for _, pagePath := range latest.pagePaths {
+			latestPaths[pagePath] = true
+		}
+	}
+
+	urlSet.URLs = append(urlSet.URLs, sitemapURL{Loc: site.url(site.latest, "/")})
+
+	for _, version := range site.versions {
+		for _, page := range version.pages {
+			pagePath := version.pagePaths[page.ID]
+
+			locs := []string{site.url(version.version, pagePath)}
+			links := []sitemapLink{{Rel: "alternate", Hreflang: site.language, Href: locs[0]}}
+			for _, language := range site.languages {
+				localizedPath, ok := version.localizedPaths[language][page.ID]
+				if !ok {
+					continue
+				}
+
+				locs = append(locs, site.url(version.version, localizedPath))
+				links = append(links, sitemapLink{Rel: "alternate", Hreflang: language, Href: locs[len(locs)-1]})
+			}
+
+			if latestPaths[pagePath] {
+				links = append(links, sitemapLink{Rel: "alternate", Hreflang: "x-default", Href: site.url(site.latest, pagePath)})
+			}
+
+			for _, loc := range locs {
+				urlSet.URLs = append(urlSet.URLs, sitemapURL{
+					Loc:     loc,
+					LastMod: siteTime(page.UpdatedAt),
+					Links:   links,
+				})
+			}
+		}
+	}
+
+	content, err := xml.MarshalIndent(urlSet, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte(xml.Header), content...), nil
+}
+
+// feed lists the most recently updated pages of a site.
+func (site *linkSite) feed(title, author string) ([]byte, error) {
+	pages := site.sitePages()
+	sort.SliceStable(pages, func(i, j int) bool {
+		a, b := pages[i].page.UpdatedAt, pages[j].page.UpdatedAt
+		if a == nil || b == nil {
+			return b == nil && a != nil
+		}
+		return a.After(*b)
+	})
+
+	if len(pages) > feedEntryLimit {
+		pages = pages[:feedEntryLimit]
+	}
+
+	home := site.url(site.latest, "/")
+	feed := atomFeed{
+		Xmlns:  "http://www.w3.org/2005/Atom",
+		Title:  title,
+		ID:     home,
+		Author: atomAuthor{Name: author},
+		Links: []atomLink{
+			{Href: home},
+			{Href: site.siteURL + site.baseURL + "/" + FeedFile, Rel: "self"},
+		},
+		Entries: make([]atomEntry, 0, len(pages)),
+	}
+
+	for _, page := range pages {
+		feed.Entries = append(feed.Entries, atomEntry{
+			Title:   page.page.Title,
+			ID:      page.url,
+			Link:    atomLink{Href: page.url},
+			Updated: siteTime(page.page.UpdatedAt),
+		})
+	}
+
+	if len(feed.Entries) > 0 {
+		feed.Updated = feed.Entries[0].Updated
+	}
+	if feed.Updated == "" {
+		feed.Updated = time.Now().UTC().Format(time.RFC3339)
+	}
+
+	content, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte(xml.Header), content...), nil
+}
+
+// robots returns the robots.txt of a site, the documentation's own if it has
+// one.
+func (site *linkSite) robots(custom string) []byte {
+	if strings.TrimSpace(custom) != "" {
+		return []byte(strings.TrimRight(custom, "\n") + "\n")
+	}
+
+	return []byte(fmt.Sprintf("User-agent: *\nAllow: /\n\nSitemap: %s%s/%s\n", site.siteURL, site.baseURL, SitemapFile))
+}
+
+// writeSiteFiles writes the sitemap, robots.txt and feed of a site into its
+// build output, for the cache step to pick up with the rest of it. Sites that
+// need a login get none of them.
+func (service *DocService) writeSiteFiles(rootId uint, buildPath string) (string, error) {
+	root, err := service.GetDocumentation(rootId)
+	if err != nil {
+		return "", err
+	}
+
+	files := []string{SitemapFile, RobotsFile, FeedFile}
+
+	if root.RequireAuth {
+		for _, file := range files {
+			if err := os.Remove(filepath.Join(buildPath, file)); err != nil && !os.IsNotExist(err) {
+				return "", err
+			}
+		}
+
+		return "skipped, the documentation requires authentication\n", nil
+	}
+
+	site, err := service.newLinkSite(rootId, 0)
+	if err != nil {
+		return "", err
+	}
+
+	sitemap, err := site.sitemap()
+	if err != nil {
+		return "", err
+	}
+
+	author := root.OrganizationName
+	if author == "" {
+		author = root.Name
+	}
+
+	feed, err := site.feed(root.Name, author)
+	if err != nil {
+		return "", err
+	}
+
+	contents := map[string][]byte{
+		SitemapFile: sitemap,
+		RobotsFile:  site.robots(root.RobotsTxt),
+		FeedFile:    feed,
+	}
+
+	var output strings.Builder
+	for _, file := range files {
+		if err := utils.WriteToFile(filepath.Join(buildPath, file), string(contents[file])); err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&output, "wrote %s\n", file)
+	}
+
+	return output.String(), nil
+}
@@ -0,0 +1,156 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"git.difuse.io/Difuse/kalmia/db/models"
+)
+
+func TestWriteSiteFiles(t *testing.T) {
+	if TestDocService == nil {
+		t.Fatal("TestDocService is nil")
+	}
+
+	db := TestDocService.DB
+
+	var admin models.User
+	if err := db.Where("username = ?", "admin").First(&admin).Error; err != nil {
+		t.Fatalf("Failed to get admin: %v", err)
+	}
+
+	doc := models.Documentation{Name: "Indexed", Version: "1.0.0", BaseURL: "/indexed", URL: "https://docs.example.com", OrganizationName: "Example", AuthorID: admin.ID}
+	if err := db.Create(&doc).Error; err != nil {
+		t.Fatalf("Failed to create documentation: %v", err)
+	}
+
+	group := models.PageGroup{DocumentationID: doc.ID, Name: "Basics", AuthorID: admin.ID}
+	if _, err := TestDocService.CreatePageGroup(&group); err != nil {
+		t.Fatalf("CreatePageGroup returned an error: %v", err)
+	}
+	if err := TestDocService.PublishPageGroup(admin, group.ID); err != nil {
+		t.Fatalf("PublishPageGroup returned an error: %v", err)
+	}
+
+	published := models.Page{DocumentationID: doc.ID, PageGroupID: &group.ID, Title: "Setup", Slug: "/setup", Content: "[]", AuthorID: admin.ID}
+	draft := models.Page{DocumentationID: doc.ID, Title: "Draft", Slug: "/draft", Content: "[]", AuthorID: admin.ID}
+	for _, page := range []*models.Page{&published, &draft} {
+		if err := TestDocService.CreatePage(page); err != nil {
+			t.Fatalf("CreatePage returned an error: %v", err)
+		}
+	}
+	if err := TestDocService.PublishPage(admin, published.ID); err != nil {
+		t.Fatalf("PublishPage returned an error: %v", err)
+	}
+
+	buildPath := t.TempDir()
+
+	read := func(t *testing.T, file string) string {
+		t.Helper()
+
+		content, err := os.ReadFile(filepath.Join(buildPath, file))
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", file, err)
+		}
+		return string(content)
+	}
+
+	t.Run("Published pages are in the sitemap and feed", func(t *testing.T) {
+		if _, err := TestDocService.writeSiteFiles(doc.ID, buildPath); err != nil {
+			t.Fatalf("writeSiteFiles returned an error: %v", err)
+		}
+
+		sitemap := read(t, SitemapFile)
+		if !strings.Contains(sitemap, "<loc>https://docs.example.com/indexed/guides/basics/setup</loc>") {
+			t.Errorf("Expected the published page in the sitemap, got %s", sitemap)
+		}
+		if strings.Contains(sitemap, "/draft") {
+			t.Errorf("Expected no drafts in the sitemap, got %s", sitemap)
+		}
+		if !strings.Contains(sitemap, "<lastmod>") || !strings.Contains(sitemap, `hreflang="x-default"`) {
+			t.Errorf("Expected lastmod and hreflang entries in the sitemap, got %s", sitemap)
+		}
+
+		feed := read(t, FeedFile)
+		if !strings.Contains(feed, "<title>Setup</title>") || !strings.Contains(feed, "<name>Example</name>") {
+			t.Errorf("Expected the published page in the feed, got %s", feed)
+		}
+
+		robots := read(t, RobotsFile)
+		if !strings.Contains(robots, "Sitemap: https://docs.example.com/indexed/sitemap.xml") {
+			t.Errorf("Expected the default robots.txt, got %s", robots)
+		}
+	})
+
+	t.Run("Draft edits don't change the sitemap or feed", func(t *testing.T) {
+		if err := TestDocService.EditPage(admin, published.ID, "Setup, revised", "/setup", "", nil, nil, nil); err != nil {
+			t.Fatalf("EditPage returned an error: %v", err)
+		}
+
+		edited := time.Date(2099, 1, 1, 0, 0, 0, 0, time.UTC)
+		if err := db.Model(&models.Page{}).Where("id = ?", published.ID).UpdateColumn("updated_at", edited).Error; err != nil {
+			t.Fatalf("Failed to update page: %v", err)
+		}
+
+		if _, err := TestDocService.writeSiteFiles(doc.ID, buildPath); err != nil {
+			t.Fatalf("writeSiteFiles returned an error: %v", err)
+		}
+
+		if sitemap := read(t, SitemapFile); strings.Contains(sitemap, "2099") {
+			t.Errorf("Expected the published revision's time as lastmod, got %s", sitemap)
+		}
+
+		if feed := read(t, FeedFile); strings.Contains(feed, "2099") || strings.Contains(feed, "revised") {
+			t.Errorf("Expected the feed to list the published revision, got %s", feed)
+		}
+	})
+
+	t.Run("Older versions are under their version", func(t *testing.T) {
+		newer := models.Documentation{Name: "Indexed", Version: "2.0.0", BaseURL: "/indexed", URL: "https://docs.example.com", AuthorID: admin.ID, ClonedFrom: &doc.ID}
+		if err := db.Create(&newer).Error; err != nil {
+			t.Fatalf("Failed to create documentation version: %v", err)
+		}
+
+		if _, err := TestDocService.writeSiteFiles(doc.ID, buildPath); err != nil {
+			t.Fatalf("writeSiteFiles returned an error: %v", err)
+		}
+
+		sitemap := read(t, SitemapFile)
+		if !strings.Contains(sitemap, "<loc>https://docs.example.com/indexed/1.0.0/guides/basics/setup</loc>") {
+			t.Errorf("Expected the page under its version, got %s", sitemap)
+		}
+	})
+
+	t.Run("Documentations can have their own robots.txt", func(t *testing.T) {
+		if err := db.Model(&doc).Update("robots_txt", "User-agent: *\nDisallow: /").Error; err != nil {
+			t.Fatalf("Failed to update documentation: %v", err)
+		}
+
+		if _, err := TestDocService.writeSiteFiles(doc.ID, buildPath); err != nil {
+			t.Fatalf("writeSiteFiles returned an error: %v", err)
+		}
+
+		if robots := read(t, RobotsFile); robots != "User-agent: *\nDisallow: /\n" {
+			t.Errorf("Expected the custom robots.txt, got %q", robots)
+		}
+	})
+
+	t.Run("Documentations that need a login get none of it", func(t *testing.T) {
+		if err := db.Model(&doc).Update("require_auth", true).Error; err != nil {
+			t.Fatalf("Failed to update documentation: %v", err)
+		}
+
+		if _, err := TestDocService.writeSiteFiles(doc.ID, buildPath); err != nil {
+			t.Fatalf("writeSiteFiles returned an error: %v", err)
+		}
+
+		for _, file := range []string{SitemapFile, RobotsFile, FeedFile} {
+			if _, err := os.Stat(filepath.Join(buildPath, file)); !os.IsNotExist(err) {
+				t.Errorf("Expected %s to be removed, got %v", file, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,540 @@
+package services
+
+import (
+	"fmt"
+
+	"git.difuse.io/Difuse/kalmia/db/models"
+	"gorm.io/gorm"
+)
+
+// availablePageSlug keeps a slug if no other page of the documentation uses
+// it, otherwise it numbers it "<slug>-2", "<slug>-3" and so on until it's
+// free. Trashed pages hold on to their rows, so they're counted too.
+func availablePageSlug(tx *gorm.DB, docId uint, slug string, exceptId uint) (string, error) {
+	candidate := slug
+
+	for n := 2; ; n++ {
+		var count int64
+		if err := tx.Unscoped().Model(&models.Page{}).
+			Where("documentation_id = ? AND slug = ? AND id <> ?", docId, candidate, exceptId).
+			Count(&count).Error; err != nil {
+			return "", fmt.Errorf("failed_to_check_slug")
+		}
+
+		if count == 0 {
+			return candidate, nil
+		}
+
+		candidate = fmt.Sprintf("%s-%d", slug, n)
+	}
+}
+
+// publishedSlug returns the slug the published revision of a page copied or
+// moved into a documentation goes by there. When it's the page's draft slug
+// it follows that slug's renumbering, otherwise it's renumbered on its own.
+func publishedSlug(tx *gorm.DB, docId uint, pageId uint, draftSlug, newDraftSlug, revisionSlug string) (string, error) {
+	if revisionSlug == draftSlug {
+		return newDraftSlug, nil
+	}
+
+	return availablePageSlug(tx, docId, revisionSlug, pageId)
+}
+
+// checkTransferTarget makes sure pages can go into the documentation and,
+// when given, the page group of it.
+func checkTransferTarget(tx *gorm.DB, docId uint, groupId *uint) error {
+	var count int64
+	if err := tx.Model(&models.Documentation{}).Where("id = ?", docId).Count(&count).Error; err != nil {
+		return fmt.Errorf("failed_to_verify_documentation")
+	}
+	if count == 0 {
+		return fmt.Errorf("invalid_documentation_id")
+	}
+
+	if groupId != nil {
+		if err := tx.Model(&models.PageGroup{}).Where("id = ? AND documentation_id = ?", *groupId, docId).Count(&count).Error; err != nil {
+			return fmt.Errorf("failed_to_verify_page_group")
+		}
+		if count == 0 {
+			return fmt.Errorf("invalid_page_group_id")
+		}
+	}
+
+	return nil
+}
+
+// pageGroupSubtree returns a page group and all the groups under it, every
+// group before its children, and the pages in them.
+func pageGroupSubtree(tx *gorm.DB, id uint) ([]models.PageGroup, []models.Page, error) {
+	var root models.PageGroup
+	if err := tx.Preload("Editors").First(&root, id).Error; err != nil {
+		return nil, nil, fmt.Errorf("page_group_not_found")
+	}
+
+	groups := []models.PageGroup{root}
+	groupIds := []uint{root.ID}
+
+	for i := 0; i < len(groups); i++ {
+		var children []models.PageGroup
+		if err := tx.Preload("Editors").Where("parent_id = ?", groups[i].ID).Order("id ASC").Find(&children).Error; err != nil {
+			return nil, nil, fmt.Errorf("failed_to_find_child_page_groups")
+		}
+
+		for _, child := range children {
+			groups = append(groups, child)
+			groupIds = append(groupIds, child.ID)
+		}
+	}
+
+	var pages []models.Page
+	if err := tx.Preload("Editors").Where("page_group_id IN ?", groupIds).Order("id ASC").Find(&pages).Error; err != nil {
+		return nil, nil, fmt.Errorf("failed_to_fetch_pages")
+	}
+
+	return groups, pages, nil
+}
+
+// copyPage creates a copy of a page, with its editors, order and published
+// revision, in the documentation and page group given. The copy is only the
+// intro page of the group if the group doesn't have one yet.
+func copyPage(tx *gorm.DB, page models.Page, docId uint, groupId *uint, user models.User) (models.Page, error) {
+	slug, err := availablePageSlug(tx, docId, page.Slug, 0)
+	if err != nil {
+		return models.Page{}, err
+	}
+
+	isIntroPage := false
+	if page.IsIntroPage {
+		intros := tx.Model(&models.Page{}).Where("documentation_id = ? AND is_intro_page = ?", docId, true)
+		if groupId != nil {
+			intros = intros.Where("page_group_id = ?", *groupId)
+		} else {
+			intros = intros.Where("page_group_id IS NULL")
+		}
+
+		var count int64
+		if err := intros.Count(&count).Error; err != nil {
+			return models.Page{}, fmt.Errorf("failed_to_fetch_pages")
+		}
+		isIntroPage = count == 0
+	}
+
+	newPage := models.Page{
+		DocumentationID: docId,
+		PageGroupID:     groupId,
+		AuthorID:        page.AuthorID,
+		Title:           page.Title,
+		Slug:            slug,
+		Content:         page.Content,
+		Order:           page.Order,
+		IsIntroPage:     isIntroPage,
+		IsPage:          page.IsPage,
+		LastEditorID:    &user.ID,
+	}
+
+	if err := tx.Create(&newPage).Error; err != nil {
+		return models.Page{}, fmt.Errorf("failed_to_create_page")
+	}
+
+	// Create leaves false to the column's default of true.
+	if !page.IsPage {
+		if err := tx.Model(&newPage).UpdateColumn("is_page", false).Error; err != nil {
+			return models.Page{}, fmt.Errorf("failed_to_create_page")
+		}
+	}
+
+	for _, editor := range page.Editors {
+		if err := tx.Model(&newPage).Association("Editors").Append(&editor); err != nil {
+			return models.Page{}, fmt.Errorf("failed_to_add_editor")
+		}
+	}
+
+	if err := createPageRevision(tx, newPage, user.ID, nil); err != nil {
+		return models.Page{}, err
+	}
+
+	if err := copyPublishedRevision(tx, page, newPage); err != nil {
+		return models.Page{}, err
+	}
+
+	if err := indexPage(tx, newPage); err != nil {
+		return models.Page{}, err
+	}
+
+	return newPage, nil
+}
+
+// movePageRecords points a page, and what hangs off it, at another
+// documentation.
+func movePageRecords(tx *gorm.DB, pageId uint, docId uint) error {
+	if err := tx.Model(&models.PageRevision{}).Where("page_id = ?", pageId).Update("documentation_id", docId).Error; err != nil {
+		return fmt.Errorf("failed_to_move_page_revisions")
+	}
+
+	if err := tx.Model(&models.CommentThread{}).Where("page_id = ?", pageId).Update("documentation_id", docId).Error; err != nil {
+		return fmt.Errorf("failed_to_move_comment_threads")
+	}
+
+	if err := tx.Model(&models.EditLock{}).Where("kind = ? AND ref_id = ?", ResourcePage, pageId).Update("documentation_id", docId).Error; err != nil {
+		return fmt.Errorf("failed_to_move_lock")
+	}
+
+	return nil
+}
+
+// movePage moves a page into the documentation and page group given,
+// renumbering its slug, and the slug it's published under, if the
+// documentation already has it. Its translations go along.
+func movePage(tx *gorm.DB, page *models.Page, docId uint, groupId *uint) error {
+	slug, err := availablePageSlug(tx, docId, page.Slug, page.ID)
+	if err != nil {
+		return err
+	}
+
+	if page.PublishedRevisionID != nil && page.DocumentationID != docId {
+		var revision models.PageRevision
+		if err := tx.First(&revision, *page.PublishedRevisionID).Error; err != nil {
+			return fmt.Errorf("page_revision_not_found")
+		}
+
+		revisionSlug, err := publishedSlug(tx, docId, page.ID, page.Slug, slug, revision.Slug)
+		if err != nil {
+			return err
+		}
+
+		if revisionSlug != revision.Slug {
+			if err := tx.Model(&revision).UpdateColumn("slug", revisionSlug).Error; err != nil {
+				return fmt.Errorf("failed_to_move_page")
+			}
+		}
+	}
+
+	if err := tx.Model(&models.Page{}).Where("id = ?", page.ID).UpdateColumns(map[string]interface{}{
+		"documentation_id": docId,
+		"page_group_id":    groupId,
+		"slug":             slug,
+	}).Error; err != nil {
+		return fmt.Errorf("failed_to_move_page")
+	}
+
+	if page.DocumentationID != docId {
+		if err := movePageRecords(tx, page.ID, docId); err != nil {
+			return err
+		}
+
+		if err := moveTranslations(tx, page.ID, docId); err != nil {
+			return err
+		}
+	}
+
+	page.DocumentationID = docId
+	page.PageGroupID = groupId
+	page.Slug = slug
+
+	return indexPage(tx, *page)
+}
+
+// addTransferBuildTriggers rebuilds the sites a copy or move touched, each
+// of them once.
+func (service *DocService) addTransferBuildTriggers(docIds ...uint) error {
+	rebuilt := make(map[uint]bool)
+
+	for _, docId := range docIds {
+		rootId, err := rootDocumentationID(service.DB, docId)
+		if err != nil {
+			return fmt.Errorf("failed_to_get_documentation_id")
+		}
+
+		if rebuilt[rootId] {
+			continue
+		}
+		rebuilt[rootId] = true
+
+		if err := service.AddBuildTrigger(rootId, false); err != nil {
+			return fmt.Errorf("failed_to_update_write_build")
+		}
+	}
+
+	return nil
+}
+
+// CopyPage copies a page into a page group of the same or another
+// documentation, pageGroupId nil copies it to the top level.
+func (service *DocService) CopyPage(user models.User, id uint, docId uint, pageGroupId *uint) (models.Page, error) {
+	var page models.Page
+	if err := service.DB.Preload("Editors").First(&page, id).Error; err != nil {
+		return models.Page{}, fmt.Errorf("page_not_found")
+	}
+
+	if err := service.requireDocumentationRole(user.ID, page.DocumentationID, RoleViewer); err != nil {
+		return models.Page{}, err
+	}
+
+	if err := service.requireDocumentationRole(user.ID, docId, RoleEditor); err != nil {
+		return models.Page{}, err
+	}
+
+	var copied models.Page
+	err := service.DB.Transaction(func(tx *gorm.DB) error {
+		if err := checkTransferTarget(tx, docId, pageGroupId); err != nil {
+			return err
+		}
+
+		var err error
+		copied, err = copyPage(tx, page, docId, pageGroupId, user)
+		return err
+	})
+
+	if err != nil {
+		return models.Page{}, err
+	}
+
+	service.audit("page.copy", AuditEntityPage, copied.ID, nil, map[string]interface{}{"copiedFrom": page.ID, "page": pageAuditSummary(copied)})
+	service.emitWebhookEvent(docId, WebhookEventPageCreated, newWebhookPage(copied, docId, user.ID))
+
+	if err := service.addTransferBuildTriggers(page.DocumentationID, docId); err != nil {
+		return models.Page{}, err
+	}
+
+	return copied, nil
+}
+
+// MovePage moves a page into a page group of the same or another
+// documentation, pageGroupId nil moves it to the top level. Its revisions
+// and comment threads go with it.
+func (service *DocService) MovePage(user models.User, id uint, docId uint, pageGroupId *uint) (models.Page, error) {
+	var page models.Page
+	if err := service.DB.First(&page, id).Error; err != nil {
+		return models.Page{}, fmt.Errorf("page_not_found")
+	}
+
+	for _, requiredDocId := range []uint{page.DocumentationID, docId} {
+		if err := service.requireDocumentationRole(user.ID, requiredDocId, RoleEditor); err != nil {
+			return models.Page{}, err
+		}
+	}
+
+	if err := checkEditLock(service.DB, ResourcePage, page.ID, user.ID); err != nil {
+		return models.Page{}, err
+	}
+
+	before := pageAuditSummary(page)
+	sourceDocId := page.DocumentationID
+
+	err := service.DB.Transaction(func(tx *gorm.DB) error {
+		if err := checkTransferTarget(tx, docId, pageGroupId); err != nil {
+			return err
+		}
+
+		return movePage(tx, &page, docId, pageGroupId)
+	})
+
+	if err != nil {
+		return models.Page{}, err
+	}
+
+	service.audit("page.move", AuditEntityPage, page.ID, before, pageAuditSummary(page))
+
+	if err := service.addTransferBuildTriggers(sourceDocId, docId); err != nil {
+		return models.Page{}, err
+	}
+
+	return page, nil
+}
+
+// CopyPageGroup copies a page group with everything in it under a page group
+// of the same or another documentation, parentId nil copies it to the top
+// level. It returns the ID of the copy.
+func (service *DocService) CopyPageGroup(user models.User, id uint, docId uint, parentId *uint) (uint, error) {
+	sourceDocId, err := service.GetDocumentationIDOfPageGroup(id)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := service.requireDocumentationRole(user.ID, sourceDocId, RoleViewer); err != nil {
+		return 0, err
+	}
+
+	if err := service.requireDocumentationRole(user.ID, docId, RoleEditor); err != nil {
+		return 0, err
+	}
+
+	var copiedPages []models.Page
+	groupMap := make(map[uint]uint)
+
+	err = service.DB.Transaction(func(tx *gorm.DB) error {
+		if err := checkTransferTarget(tx, docId, parentId); err != nil {
+			if err.Error() == "invalid_page_group_id" {
+				return fmt.Errorf("invalid_parent_page_group_id")
+			}
+			return err
+		}
+
+		groups, pages, err := pageGroupSubtree(tx, id)
+		if err != nil {
+			return err
+		}
+
+		for i, group := range groups {
+			newParentId := parentId
+			if i > 0 {
+				mapped := groupMap[*group.ParentID]
+				newParentId = &mapped
+			}
+
+			newGroup := models.PageGroup{
+				DocumentationID: docId,
+				ParentID:        newParentId,
+				AuthorID:        group.AuthorID,
+				Name:            group.Name,
+				Order:           group.Order,
+				LastEditorID:    &user.ID,
+				PublishedName:   group.PublishedName,
+				PublishedAt:     group.PublishedAt,
+			}
+
+			if err := tx.Create(&newGroup).Error; err != nil {
+				return fmt.Errorf("failed_to_create_page_group")
+			}
+			groupMap[group.ID] = newGroup.ID
+
+			for _, editor := range group.Editors {
+				if err := tx.Model(&newGroup).Association("Editors").Append(&editor); err != nil {
+					return fmt.Errorf("failed_to_add_editor")
+				}
+			}
+
+			if err := indexPageGroup(tx, newGroup); err != nil {
+				return err
+			}
+		}
+
+		for _, page := range pages {
+			newGroupId := groupMap[*page.PageGroupID]
+
+			copied, err := copyPage(tx, page, docId, &newGroupId, user)
+			if err != nil {
+				return err
+			}
+			copiedPages = append(copiedPages, copied)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	service.audit("page_group.copy", AuditEntityPageGroup, groupMap[id], nil, map[string]interface{}{
+		"copiedFrom":      id,
+		"documentationId": docId,
+		"pageGroups":      len(groupMap),
+		"pages":           len(copiedPages),
+	})
+
+	for _, page := range copiedPages {
+		service.emitWebhookEvent(docId, WebhookEventPageCreated, newWebhookPage(page, docId, user.ID))
+	}
+
+	if err := service.addTransferBuildTriggers(sourceDocId, docId); err != nil {
+		return 0, err
+	}
+
+	return groupMap[id], nil
+}
+
+// MovePageGroup moves a page group with everything in it under a page group
+// of the same or another documentation, parentId nil moves it to the top
+// level. Slugs the target documentation already has are renumbered.
+func (service *DocService) MovePageGroup(user models.User, id uint, docId uint, parentId *uint) error {
+	groups, pages, err := pageGroupSubtree(service.DB, id)
+	if err != nil {
+		return err
+	}
+
+	root := groups[0]
+
+	for _, requiredDocId := range []uint{root.DocumentationID, docId} {
+		if err := service.requireDocumentationRole(user.ID, requiredDocId, RoleEditor); err != nil {
+			return err
+		}
+	}
+
+	for _, group := range groups {
+		if parentId != nil && *parentId == group.ID {
+			return fmt.Errorf("page_group_cannot_be_its_own_parent")
+		}
+
+		if err := checkEditLock(service.DB, ResourcePageGroup, group.ID, user.ID); err != nil {
+			return err
+		}
+	}
+
+	for _, page := range pages {
+		if err := checkEditLock(service.DB, ResourcePage, page.ID, user.ID); err != nil {
+			return err
+		}
+	}
+
+	before := pageGroupAuditSummary(root)
+	sourceDocId := root.DocumentationID
+
+	err = service.DB.Transaction(func(tx *gorm.DB) error {
+		if err := checkTransferTarget(tx, docId, parentId); err != nil {
+			if err.Error() == "invalid_page_group_id" {
+				return fmt.Errorf("invalid_parent_page_group_id")
+			}
+			return err
+		}
+
+		if err := tx.Model(&models.PageGroup{}).Where("id = ?", root.ID).UpdateColumns(map[string]interface{}{
+			"documentation_id": docId,
+			"parent_id":        parentId,
+		}).Error; err != nil {
+			return fmt.Errorf("failed_to_move_page_group")
+		}
+		root.ParentID = parentId
+
+		for i := range groups {
+			if groups[i].DocumentationID == docId {
+				continue
+			}
+
+			if err := tx.Model(&models.PageGroup{}).Where("id = ?", groups[i].ID).Update("documentation_id", docId).Error; err != nil {
+				return fmt.Errorf("failed_to_move_page_group")
+			}
+
+			if err := tx.Model(&models.EditLock{}).Where("kind = ? AND ref_id = ?", ResourcePageGroup, groups[i].ID).
+				Update("documentation_id", docId).Error; err != nil {
+				return fmt.Errorf("failed_to_move_lock")
+			}
+
+			groups[i].DocumentationID = docId
+			if err := indexPageGroup(tx, groups[i]); err != nil {
+				return err
+			}
+		}
+
+		for i := range pages {
+			if pages[i].DocumentationID == docId {
+				continue
+			}
+
+			if err := movePage(tx, &pages[i], docId, pages[i].PageGroupID); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	root.DocumentationID = docId
+	service.audit("page_group.move", AuditEntityPageGroup, root.ID, before, pageGroupAuditSummary(root))
+
+	return service.addTransferBuildTriggers(sourceDocId, docId)
+}
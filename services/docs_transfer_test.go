@@ -0,0 +1,285 @@
+package services
+
+import (
+	"testing"
+
+	"git.difuse.io/Difuse/kalmia/db/models"
+)
+
+func TestCopyAndMove(t *testing.T) {
+	if TestDocService == nil {
+		t.Fatal("TestDocService is nil")
+	}
+
+	db := TestDocService.DB
+
+	var admin, user models.User
+	if err := db.Where("username = ?", "admin").First(&admin).Error; err != nil {
+		t.Fatalf("Failed to get admin: %v", err)
+	}
+	if err := db.Where("username = ?", "user").First(&user).Error; err != nil {
+		t.Fatalf("Failed to get user: %v", err)
+	}
+
+	source := models.Documentation{Name: "Transfer source", Version: "1.0.0", BaseURL: "/transfer-source", AuthorID: admin.ID}
+	target := models.Documentation{Name: "Transfer target", Version: "1.0.0", BaseURL: "/transfer-target", AuthorID: admin.ID}
+	for _, doc := range []*models.Documentation{&source, &target} {
+		if err := db.Create(doc).Error; err != nil {
+			t.Fatalf("Failed to create documentation: %v", err)
+		}
+	}
+
+	if err := TestDocService.SetDocumentationMember(admin, source.ID, user.ID, RoleEditor); err != nil {
+		t.Fatalf("SetDocumentationMember returned an error: %v", err)
+	}
+
+	group := models.PageGroup{DocumentationID: source.ID, Name: "Guides", AuthorID: admin.ID}
+	if _, err := TestDocService.CreatePageGroup(&group); err != nil {
+		t.Fatalf("CreatePageGroup returned an error: %v", err)
+	}
+
+	child := models.PageGroup{DocumentationID: source.ID, ParentID: &group.ID, Name: "Advanced", AuthorID: admin.ID}
+	if _, err := TestDocService.CreatePageGroup(&child); err != nil {
+		t.Fatalf("CreatePageGroup returned an error: %v", err)
+	}
+
+	order := uint(4)
+	page := models.Page{DocumentationID: source.ID, PageGroupID: &group.ID, Title: "Install", Slug: "/install", Content: "[]", AuthorID: admin.ID, Order: &order}
+	if err := TestDocService.CreatePage(&page); err != nil {
+		t.Fatalf("CreatePage returned an error: %v", err)
+	}
+
+	nested := models.Page{DocumentationID: source.ID, PageGroupID: &child.ID, Title: "Tuning", Slug: "/tuning", Content: "[]", AuthorID: admin.ID}
+	if err := TestDocService.CreatePage(&nested); err != nil {
+		t.Fatalf("CreatePage returned an error: %v", err)
+	}
+
+	if err := TestDocService.EditPage(user, page.ID, "Install", "/install", "", nil, nil, nil); err != nil {
+		t.Fatalf("EditPage returned an error: %v", err)
+	}
+
+	taken := models.Page{DocumentationID: target.ID, Title: "Install elsewhere", Slug: "/install", Content: "[]", AuthorID: admin.ID}
+	if err := TestDocService.CreatePage(&taken); err != nil {
+		t.Fatalf("CreatePage returned an error: %v", err)
+	}
+
+	t.Run("Copying a page within its documentation renumbers the slug", func(t *testing.T) {
+		copied, err := TestDocService.CopyPage(admin, page.ID, source.ID, &group.ID)
+		if err != nil {
+			t.Fatalf("CopyPage returned an error: %v", err)
+		}
+
+		if copied.ID == page.ID || copied.Slug != "/install-2" {
+			t.Errorf("Expected a new page at /install-2, got %+v", copied)
+		}
+
+		stored, err := TestDocService.GetPage(copied.ID)
+		if err != nil {
+			t.Fatalf("GetPage returned an error: %v", err)
+		}
+
+		if stored.Order == nil || *stored.Order != order || len(stored.Editors) != 1 || stored.Editors[0].ID != user.ID {
+			t.Errorf("Expected the order and editors to carry over, got %+v", stored)
+		}
+	})
+
+	t.Run("Copying into another documentation needs editor access to it", func(t *testing.T) {
+		if _, err := TestDocService.CopyPage(user, page.ID, target.ID, nil); err == nil || err.Error() != "insufficient_documentation_role" {
+			t.Errorf("Expected insufficient_documentation_role, got %v", err)
+		}
+	})
+
+	t.Run("A target group has to be in the target documentation", func(t *testing.T) {
+		if _, err := TestDocService.CopyPage(admin, page.ID, target.ID, &group.ID); err == nil || err.Error() != "invalid_page_group_id" {
+			t.Errorf("Expected invalid_page_group_id, got %v", err)
+		}
+	})
+
+	t.Run("Copying a page group copies its subtree", func(t *testing.T) {
+		copyId, err := TestDocService.CopyPageGroup(admin, group.ID, target.ID, nil)
+		if err != nil {
+			t.Fatalf("CopyPageGroup returned an error: %v", err)
+		}
+
+		var groups []models.PageGroup
+		db.Where("documentation_id = ?", target.ID).Find(&groups)
+		if len(groups) != 2 {
+			t.Fatalf("Expected 2 page groups in the target, got %d", len(groups))
+		}
+
+		var copiedChild models.PageGroup
+		if err := db.Where("documentation_id = ? AND parent_id = ?", target.ID, copyId).First(&copiedChild).Error; err != nil {
+			t.Fatalf("Expected the child group under the copy: %v", err)
+		}
+
+		var pages []models.Page
+		db.Where("documentation_id = ?", target.ID).Order("slug ASC").Find(&pages)
+
+		slugs := make(map[string]uint)
+		for _, p := range pages {
+			if p.PageGroupID != nil {
+				slugs[p.Slug] = *p.PageGroupID
+			}
+		}
+
+		if slugs["/install-2"] != copyId || slugs["/tuning"] != copiedChild.ID {
+			t.Errorf("Expected the pages copied into their groups with free slugs, got %v", slugs)
+		}
+
+		var sourcePages int64
+		db.Model(&models.Page{}).Where("documentation_id = ?", source.ID).Count(&sourcePages)
+		if sourcePages != 3 {
+			t.Errorf("Expected the source to keep its pages, got %d", sourcePages)
+		}
+	})
+
+	t.Run("A page group can't move into itself", func(t *testing.T) {
+		if err := TestDocService.MovePageGroup(admin, group.ID, source.ID, &child.ID); err == nil || err.Error() != "page_group_cannot_be_its_own_parent" {
+			t.Errorf("Expected page_group_cannot_be_its_own_parent, got %v", err)
+		}
+	})
+
+	t.Run("Moving a page group takes its pages along", func(t *testing.T) {
+		if err := TestDocService.MovePageGroup(admin, child.ID, target.ID, nil); err != nil {
+			t.Fatalf("MovePageGroup returned an error: %v", err)
+		}
+
+		var moved models.Page
+		if err := db.First(&moved, nested.ID).Error; err != nil {
+			t.Fatalf("Failed to get page: %v", err)
+		}
+
+		if moved.DocumentationID != target.ID || moved.Slug != "/tuning-2" || moved.PageGroupID == nil || *moved.PageGroupID != child.ID {
+			t.Errorf("Expected the page in the target at /tuning-2, got %+v", moved)
+		}
+
+		var movedGroup models.PageGroup
+		db.First(&movedGroup, child.ID)
+		if movedGroup.DocumentationID != target.ID || movedGroup.ParentID != nil {
+			t.Errorf("Expected the group at the top of the target, got %+v", movedGroup)
+		}
+
+		var revisions int64
+		db.Model(&models.PageRevision{}).Where("page_id = ? AND documentation_id <> ?", nested.ID, target.ID).Count(&revisions)
+		if revisions != 0 {
+			t.Errorf("Expected the revisions to move along, %d didn't", revisions)
+		}
+	})
+
+	t.Run("Moving a locked page is refused", func(t *testing.T) {
+		if _, err := TestDocService.AcquireLock(user, ResourcePage, page.ID); err != nil {
+			t.Fatalf("AcquireLock returned an error: %v", err)
+		}
+
+		if _, err := TestDocService.MovePage(admin, page.ID, target.ID, nil); err == nil || err.Error() != "locked_by_another_user" {
+			t.Errorf("Expected locked_by_another_user, got %v", err)
+		}
+
+		if err := TestDocService.ReleaseLock(user, ResourcePage, page.ID); err != nil {
+			t.Fatalf("ReleaseLock returned an error: %v", err)
+		}
+	})
+
+	t.Run("Moving a page renumbers a slug the target has", func(t *testing.T) {
+		moved, err := TestDocService.MovePage(admin, page.ID, target.ID, nil)
+		if err != nil {
+			t.Fatalf("MovePage returned an error: %v", err)
+		}
+
+		if moved.ID != page.ID || moved.DocumentationID != target.ID || moved.PageGroupID != nil || moved.Slug != "/install-3" {
+			t.Errorf("Expected the page at the top of the target at /install-3, got %+v", moved)
+		}
+
+		if moved.Order == nil || *moved.Order != order {
+			t.Errorf("Expected the order to carry over, got %v", moved.Order)
+		}
+	})
+}
+
+func TestCopyAndMovePublishedPages(t *testing.T) {
+	if TestDocService == nil {
+		t.Fatal("TestDocService is nil")
+	}
+
+	db := TestDocService.DB
+
+	var admin models.User
+	if err := db.Where("username = ?", "admin").First(&admin).Error; err != nil {
+		t.Fatalf("Failed to get admin: %v", err)
+	}
+
+	source := models.Documentation{Name: "Published source", Version: "1.0.0", BaseURL: "/published-source", AuthorID: admin.ID}
+	target := models.Documentation{Name: "Published target", Version: "1.0.0", BaseURL: "/published-target", AuthorID: admin.ID}
+	for _, doc := range []*models.Documentation{&source, &target} {
+		if err := db.Create(doc).Error; err != nil {
+			t.Fatalf("Failed to create documentation: %v", err)
+		}
+	}
+
+	group := models.PageGroup{DocumentationID: source.ID, Name: "Guides", AuthorID: admin.ID}
+	if _, err := TestDocService.CreatePageGroup(&group); err != nil {
+		t.Fatalf("CreatePageGroup returned an error: %v", err)
+	}
+
+	page := models.Page{DocumentationID: source.ID, PageGroupID: &group.ID, Title: "Intro", Slug: "/intro", Content: "[]", AuthorID: admin.ID, IsIntroPage: true}
+	taken := models.Page{DocumentationID: target.ID, Title: "Intro", Slug: "/intro", Content: "[]", AuthorID: admin.ID}
+	for _, p := range []*models.Page{&page, &taken} {
+		if err := TestDocService.CreatePage(p); err != nil {
+			t.Fatalf("CreatePage returned an error: %v", err)
+		}
+		if err := TestDocService.PublishPage(admin, p.ID); err != nil {
+			t.Fatalf("PublishPage returned an error: %v", err)
+		}
+	}
+
+	uniquePaths := func(t *testing.T, docId uint) {
+		t.Helper()
+
+		var pages []models.Page
+		if err := db.Where("documentation_id = ?", docId).Find(&pages).Error; err != nil {
+			t.Fatalf("Failed to get pages: %v", err)
+		}
+
+		published, err := TestDocService.publishedPages(pages)
+		if err != nil {
+			t.Fatalf("publishedPages returned an error: %v", err)
+		}
+
+		seen := make(map[string]bool)
+		for _, p := range published {
+			if seen[p.Slug] {
+				t.Errorf("Expected every published path to be unique, %s is there twice", p.Slug)
+			}
+			seen[p.Slug] = true
+		}
+	}
+
+	t.Run("Copies are published under their own slug", func(t *testing.T) {
+		copied, err := TestDocService.CopyPage(admin, page.ID, source.ID, &group.ID)
+		if err != nil {
+			t.Fatalf("CopyPage returned an error: %v", err)
+		}
+
+		var stored models.Page
+		if err := db.First(&stored, copied.ID).Error; err != nil {
+			t.Fatalf("Failed to get page: %v", err)
+		}
+		if !stored.IsPage || stored.IsIntroPage {
+			t.Errorf("Expected a page that isn't a second intro of the group, got %+v", stored)
+		}
+
+		uniquePaths(t, source.ID)
+	})
+
+	t.Run("Moved pages are published under their new slug", func(t *testing.T) {
+		moved, err := TestDocService.MovePage(admin, page.ID, target.ID, nil)
+		if err != nil {
+			t.Fatalf("MovePage returned an error: %v", err)
+		}
+		if moved.Slug != "/intro-2" {
+			t.Errorf("Expected the page at /intro-2, got %s", moved.Slug)
+		}
+
+		uniquePaths(t, target.ID)
+	})
+}
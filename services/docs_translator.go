@@ -0,0 +1,299 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"git.difuse.io/Difuse/kalmia/config"
+	"git.difuse.io/Difuse/kalmia/db/models"
+	"git.difuse.io/Difuse/kalmia/logger"
+	"git.difuse.io/Difuse/kalmia/utils"
+	"go.uber.org/zap"
+)
+
+// The machine translation providers the config can pick.
+const (
+	TranslatorLibreTranslate = "libretranslate"
+	TranslatorPseudo         = "pseudo"
+	TranslatorNoop           = "noop"
+)
+
+const (
+	libreTranslateBatchSize        = 50
+	libreTranslateMaxResponseBytes = 4 << 20
+)
+
+// Translator machine translates texts from one language into another. What
+// it returns has a translation for every text, in the same order.
+type Translator interface {
+	Translate(ctx context.Context, texts []string, source, target string) ([]string, error)
+}
+
+// NoopTranslator returns texts as they are.
+type NoopTranslator struct{}
+
+func (NoopTranslator) Translate(ctx context.Context, texts []string, source, target string) ([]string, error) {
+	return append([]string(nil), texts...), nil
+}
+
+var pseudoLetters = strings.NewReplacer(
+	"a", "á", "e", "é", "i", "í", "o", "ö", "u", "ü", "y", "ý", "c", "ç", "n", "ñ",
+	"A", "Å", "E", "É", "I", "Î", "O", "Ø", "U", "Û", "Y", "Ý", "C", "Ç", "N", "Ñ",
+)
+
+// PseudoTranslator pseudo-localizes texts, accenting their letters and
+// bracketing them, so what's been through translation stands out without a
+// translation service.
+type PseudoTranslator struct{}
+
+func (PseudoTranslator) Translate(ctx context.Context, texts []string, source, target string) ([]string, error) {
+	translated := make([]string, 0, len(texts))
+	for _, text := range texts {
+		translated = append(translated, "["+pseudoLetters.Replace(text)+"]")
+	}
+
+	return translated, nil
+}
+
+// LibreTranslator translates through the API of LibreTranslate, or any
+// service compatible with it.
+type LibreTranslator struct {
+	URL    string
+	APIKey string
+	Client *http.Client
+}
+
+type libreTranslateRequest struct {
+	Q      []string `json:"q"`
+	Source string   `json:"source"`
+	Target string   `json:"target"`
+	Format string   `json:"format"`
+	APIKey string   `json:"api_key,omitempty"`
+}
+
+type libreTranslateResponse struct {
+	TranslatedText []string `json:"translatedText"`
+	Error          string   `json:"error"`
+}
+
+func (translator *LibreTranslator) Translate(ctx context.Context, texts []string, source, target string) ([]string, error) {
+	translated := make([]string, 0, len(texts))
+
+	for start := 0; start < len(texts); start += libreTranslateBatchSize {
+		end := min(start+libreTranslateBatchSize, len(texts))
+
+		batch, err := translator.translateBatch(ctx, texts[start:end], source, target)
+		if err != nil {
+			return nil, err
+		}
+
+		translated = append(translated, batch...)
+	}
+
+	return translated, nil
+}
+
+func (translator *LibreTranslator) translateBatch(ctx context.Context, texts []string, source, target string) ([]string, error) {
+	body, err := json.Marshal(libreTranslateRequest{
+		Q:      texts,
+		Source: source,
+		Target: target,
+		Format: "text",
+		APIKey: translator.APIKey,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(translator.URL, "/")+"/translate", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	client := translator.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	var response libreTranslateResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, libreTranslateMaxResponseBytes)).Decode(&response); err != nil {
+		return nil, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, response.Error)
+	}
+
+	if len(response.TranslatedText) != len(texts) {
+		return nil, fmt.Errorf("got %d translations for %d texts", len(response.TranslatedText), len(texts))
+	}
+
+	return response.TranslatedText, nil
+}
+
+// NewTranslator returns the translator the config asks for, nil when there's
+// none.
+func NewTranslator(cfg config.Translation) (Translator, error) {
+	switch cfg.Provider {
+	case "":
+		return nil, nil
+	case TranslatorNoop:
+		return NoopTranslator{}, nil
+	case TranslatorPseudo:
+		return PseudoTranslator{}, nil
+	case TranslatorLibreTranslate:
+		if cfg.URL == "" {
+			return nil, fmt.Errorf("translator_url_required")
+		}
+
+		return &LibreTranslator{
+			URL:    cfg.URL,
+			APIKey: cfg.APIKey,
+			Client: &http.Client{Timeout: time.Duration(cfg.TimeoutSeconds) * time.Second},
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown_translator")
+	}
+}
+
+// translator returns the translator of the service, the one the config asks
+// for unless one is set.
+func (service *DocService) translator() (Translator, error) {
+	if service.Translator != nil {
+		return service.Translator, nil
+	}
+
+	if config.ParsedConfig == nil {
+		return nil, fmt.Errorf("translator_not_configured")
+	}
+
+	translator, err := NewTranslator(config.ParsedConfig.Translation)
+	if err != nil {
+		return nil, err
+	}
+
+	if translator == nil {
+		return nil, fmt.Errorf("translator_not_configured")
+	}
+
+	return translator, nil
+}
+
+// translatePage machine translates the title and the text of the content of
+// a page, in one go.
+func translatePage(ctx context.Context, translator Translator, page models.Page, source, target string) (string, string, error) {
+	blocks, err := utils.ParseBlocks(page.Content)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid_page_content")
+	}
+
+	var title string
+	translate := func(texts []string) ([]string, error) {
+		translated, err := translator.Translate(ctx, append([]string{page.Title}, texts...), source, target)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(translated) != len(texts)+1 {
+			return nil, fmt.Errorf("got %d translations for %d texts", len(translated), len(texts)+1)
+		}
+
+		title = translated[0]
+		return translated[1:], nil
+	}
+
+	if err := utils.ReplaceBlockTexts(blocks, translate); err != nil {
+		return "", "", err
+	}
+
+	// Content without any text to translate still has a title.
+	if title == "" {
+		if _, err := translate(nil); err != nil {
+			return "", "", err
+		}
+	}
+
+	if len(blocks) == 0 {
+		return title, page.Content, nil
+	}
+
+	content, err := json.Marshal(blocks)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid_page_content")
+	}
+
+	return title, string(content), nil
+}
+
+// PrefillPageTranslation machine translates a page into one of the languages
+// of its documentation, as a draft of its translation. A translation that's
+// already there is edited, so what it had is kept in its revisions.
+func (service *DocService) PrefillPageTranslation(ctx context.Context, user models.User, id uint, language string) (models.Page, error) {
+	var source models.Page
+	if err := service.DB.First(&source, id).Error; err != nil {
+		return models.Page{}, fmt.Errorf("page_not_found")
+	}
+
+	if source.TranslationOfID != nil {
+		return models.Page{}, fmt.Errorf("page_is_a_translation")
+	}
+
+	if err := service.requireDocumentationRole(user.ID, source.DocumentationID, RoleEditor); err != nil {
+		return models.Page{}, err
+	}
+
+	sourceLanguage, languages, err := service.siteLanguages(source.DocumentationID)
+	if err != nil {
+		return models.Page{}, err
+	}
+
+	if !isTranslatedLanguage(languages, language) {
+		return models.Page{}, fmt.Errorf("invalid_language")
+	}
+
+	translator, err := service.translator()
+	if err != nil {
+		return models.Page{}, err
+	}
+
+	title, content, err := translatePage(ctx, translator, source, sourceLanguage, language)
+	if err != nil {
+		if err.Error() == "invalid_page_content" {
+			return models.Page{}, err
+		}
+
+		logger.Error("Failed to translate page", zap.Uint("page_id", source.ID), zap.String("language", language), zap.Error(err))
+		return models.Page{}, fmt.Errorf("translation_failed")
+	}
+
+	var translation models.Page
+	err = service.DB.Where("translation_of_id = ? AND language = ?", source.ID, language).First(&translation).Error
+	if err != nil {
+		return service.CreatePageTranslation(user, source.ID, language, title, content)
+	}
+
+	if err := service.EditPage(user, translation.ID, title, translation.Slug, content, nil, nil, nil); err != nil {
+		return models.Page{}, err
+	}
+
+	if err := service.DB.First(&translation, translation.ID).Error; err != nil {
+		return models.Page{}, fmt.Errorf("page_not_found")
+	}
+
+	return translation, nil
+}
@@ -0,0 +1,148 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"git.difuse.io/Difuse/kalmia/config"
+	"git.difuse.io/Difuse/kalmia/db/models"
+)
+
+func TestLibreTranslator(t *testing.T) {
+	var requests []libreTranslateRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req libreTranslateRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || r.URL.Path != "/translate" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"bad request"}`))
+			return
+		}
+		requests = append(requests, req)
+
+		translated := make([]string, 0, len(req.Q))
+		for _, text := range req.Q {
+			translated = append(translated, req.Target+":"+text)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"translatedText": translated})
+	}))
+	defer server.Close()
+
+	translator, err := NewTranslator(config.Translation{Provider: TranslatorLibreTranslate, URL: server.URL + "/", APIKey: "key", TimeoutSeconds: 5})
+	if err != nil {
+		t.Fatalf("NewTranslator returned an error: %v", err)
+	}
+
+	texts := make([]string, libreTranslateBatchSize+1)
+	for i := range texts {
+		texts[i] = "text"
+	}
+
+	translated, err := translator.Translate(context.Background(), texts, "en", "de")
+	if err != nil {
+		t.Fatalf("Translate returned an error: %v", err)
+	}
+	if len(translated) != len(texts) || translated[0] != "de:text" {
+		t.Errorf("Expected every text translated, got %d: %v", len(translated), translated[:1])
+	}
+	if len(requests) != 2 || requests[0].APIKey != "key" || requests[0].Source != "en" {
+		t.Errorf("Expected 2 batched requests with the API key, got %+v", requests)
+	}
+
+	if _, err := NewTranslator(config.Translation{Provider: TranslatorLibreTranslate}); err == nil || err.Error() != "translator_url_required" {
+		t.Errorf("Expected translator_url_required, got %v", err)
+	}
+	if _, err := NewTranslator(config.Translation{Provider: "unknown"}); err == nil || err.Error() != "unknown_translator" {
+		t.Errorf("Expected unknown_translator, got %v", err)
+	}
+	if translator, err := NewTranslator(config.Translation{}); translator != nil || err != nil {
+		t.Errorf("Expected no translator, got %v (%v)", translator, err)
+	}
+}
+
+func TestPrefillPageTranslation(t *testing.T) {
+	if TestDocService == nil {
+		t.Fatal("TestDocService is nil")
+	}
+
+	db := TestDocService.DB
+
+	var admin models.User
+	if err := db.Where("username = ?", "admin").First(&admin).Error; err != nil {
+		t.Fatalf("Failed to get admin: %v", err)
+	}
+
+	doc := models.Documentation{Name: "Prefilled", Version: "1.0.0", BaseURL: "/prefilled", AuthorID: admin.ID}
+	if err := db.Create(&doc).Error; err != nil {
+		t.Fatalf("Failed to create documentation: %v", err)
+	}
+
+	source := models.Page{DocumentationID: doc.ID, Title: "Setup", Slug: "/setup", AuthorID: admin.ID, Content: `[
+		{"id":"a","type":"paragraph","props":{"textColor":"default"},"content":[{"type":"text","text":"Run it","styles":{}}],"children":[]},
+		{"id":"b","type":"procode","props":{"code":"make","language":"bash"},"content":[],"children":[]}
+	]`}
+	if err := TestDocService.CreatePage(&source); err != nil {
+		t.Fatalf("CreatePage returned an error: %v", err)
+	}
+
+	if err := TestDocService.SetDocumentationLanguages(admin, doc.ID, "en", []string{"de"}); err != nil {
+		t.Fatalf("SetDocumentationLanguages returned an error: %v", err)
+	}
+
+	service := *TestDocService
+	ctx := context.Background()
+
+	t.Run("A translator is needed", func(t *testing.T) {
+		if _, err := service.PrefillPageTranslation(ctx, admin, source.ID, "de"); err == nil || err.Error() != "translator_not_configured" {
+			t.Errorf("Expected translator_not_configured, got %v", err)
+		}
+	})
+
+	service.Translator = PseudoTranslator{}
+
+	t.Run("Pages are translated into a new draft", func(t *testing.T) {
+		if _, err := service.PrefillPageTranslation(ctx, admin, source.ID, "fr"); err == nil || err.Error() != "invalid_language" {
+			t.Errorf("Expected invalid_language, got %v", err)
+		}
+
+		translation, err := service.PrefillPageTranslation(ctx, admin, source.ID, "de")
+		if err != nil {
+			t.Fatalf("PrefillPageTranslation returned an error: %v", err)
+		}
+
+		if translation.Title != "[Sétüp]" {
+			t.Errorf("Expected a pseudo-localized title, got %s", translation.Title)
+		}
+		if !strings.Contains(translation.Content, `"text":"[Rüñ ít]"`) || !strings.Contains(translation.Content, `"code":"make"`) {
+			t.Errorf("Expected the text translated and the code left alone, got %s", translation.Content)
+		}
+		if translation.PublishedRevisionID != nil {
+			t.Errorf("Expected the translation to be a draft")
+		}
+	})
+
+	t.Run("Translations that are there are refreshed", func(t *testing.T) {
+		if err := TestDocService.EditPage(admin, source.ID, "Install", "/setup", "", nil, nil, nil); err != nil {
+			t.Fatalf("EditPage returned an error: %v", err)
+		}
+
+		translation, err := service.PrefillPageTranslation(ctx, admin, source.ID, "de")
+		if err != nil {
+			t.Fatalf("PrefillPageTranslation returned an error: %v", err)
+		}
+		if translation.Title != "[Îñstáll]" {
+			t.Errorf("Expected the translation to be refreshed, got %s", translation.Title)
+		}
+
+		statuses, err := service.GetTranslationStatus(doc.ID)
+		if err != nil {
+			t.Fatalf("GetTranslationStatus returned an error: %v", err)
+		}
+		if len(statuses) != 1 || statuses[0].Status != TranslationStatusUpToDate {
+			t.Errorf("Expected the translation to be up to date, got %+v", statuses)
+		}
+	})
+}
@@ -0,0 +1,586 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"git.difuse.io/Difuse/kalmia/config"
+	"git.difuse.io/Difuse/kalmia/db/models"
+	"git.difuse.io/Difuse/kalmia/logger"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// trashSlugPrefix moves the slugs of trashed pages out of the way, slugs are
+// unique per documentation and trashed pages keep their rows.
+func trashSlugPrefix(trashId uint) string {
+	return fmt.Sprintf("/.trash/%d", trashId)
+}
+
+func trashRetention() time.Duration {
+	days := 30
+	if config.ParsedConfig != nil && config.ParsedConfig.Trash.RetentionDays > 0 {
+		days = config.ParsedConfig.Trash.RetentionDays
+	}
+
+	return time.Duration(days) * 24 * time.Hour
+}
+
+func (service *DocService) createTrashItem(tx *gorm.DB, kind string, refId uint, docId uint, title string) (models.TrashItem, error) {
+	rootId, err := rootDocumentationID(tx, docId)
+	if err != nil {
+		return models.TrashItem{}, fmt.Errorf("documentation_not_found")
+	}
+
+	now := time.Now().UTC()
+	item := models.TrashItem{
+		Kind:                kind,
+		RefID:               refId,
+		DocumentationID:     docId,
+		RootDocumentationID: rootId,
+		Title:               title,
+		DeletedAt:           now,
+		PurgeAt:             now.Add(trashRetention()),
+	}
+
+	if service.actor != nil {
+		item.DeletedByID = service.actor.UserID
+	}
+
+	if err := tx.Omit("DeletedBy").Create(&item).Error; err != nil {
+		return models.TrashItem{}, fmt.Errorf("failed_to_create_trash_item")
+	}
+
+	return item, nil
+}
+
+// trashPages moves the live pages matching the query into a trash item.
+func trashPages(tx *gorm.DB, trashId uint, query string, args ...interface{}) error {
+	var pageIds []uint
+	if err := tx.Model(&models.Page{}).Where(query, args...).Pluck("id", &pageIds).Error; err != nil {
+		return fmt.Errorf("failed_to_fetch_pages")
+	}
+
+	if len(pageIds) == 0 {
+		return nil
+	}
+
+	if err := tx.Model(&models.Page{}).Where("id IN ?", pageIds).UpdateColumns(map[string]interface{}{
+		"trash_item_id": trashId,
+		"slug":          gorm.Expr("? || slug", trashSlugPrefix(trashId)),
+	}).Error; err != nil {
+		return fmt.Errorf("failed_to_delete_page")
+	}
+
+	if err := tx.Where("id IN ?", pageIds).Delete(&models.Page{}).Error; err != nil {
+		return fmt.Errorf("failed_to_delete_page")
+	}
+
+	if err := removeFromSearchIndex(tx, SearchKindPage, pageIds...); err != nil {
+		return err
+	}
+
+	return removeEditLocks(tx, ResourcePage, pageIds...)
+}
+
+// GetTrash lists what was deleted from a documentation and its versions,
+// most recent first. Without a documentation it lists the whole trash.
+func (service *DocService) GetTrash(docId uint) ([]models.TrashItem, error) {
+	query := service.DB.Preload("DeletedBy", selectReviewUser)
+
+	if docId != 0 {
+		rootId, err := rootDocumentationID(service.DB, docId)
+		if err != nil {
+			return nil, fmt.Errorf("documentation_not_found")
+		}
+		query = query.Where("root_documentation_id = ?", rootId)
+	}
+
+	items := make([]models.TrashItem, 0)
+	if err := query.Order("deleted_at DESC").Order("id DESC").Find(&items).Error; err != nil {
+		return nil, fmt.Errorf("failed_to_get_trash")
+	}
+
+	return items, nil
+}
+
+func (service *DocService) getTrashItem(db *gorm.DB, id uint) (models.TrashItem, error) {
+	var item models.TrashItem
+	if err := db.First(&item, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return models.TrashItem{}, fmt.Errorf("trash_item_not_found")
+		}
+		return models.TrashItem{}, fmt.Errorf("failed_to_get_trash_item")
+	}
+
+	return item, nil
+}
+
+// trashItemRole is the role needed to restore or purge a trash item, the one
+// needed to delete it in the first place.
+func trashItemRole(item models.TrashItem) string {
+	if item.Kind == ResourceDocumentation {
+		return RoleOwner
+	}
+
+	return RoleMaintainer
+}
+
+// checkTrashParents makes sure what a trash item was in still exists, so
+// restoring it puts it back where it was.
+func checkTrashParents(tx *gorm.DB, item models.TrashItem) error {
+	var documentationId uint
+	var parentGroupId *uint
+
+	switch item.Kind {
+	case ResourcePage:
+		var page models.Page
+		if err := tx.Unscoped().Select("id", "documentation_id", "page_group_id").First(&page, item.RefID).Error; err != nil {
+			return fmt.Errorf("page_not_found")
+		}
+		documentationId, parentGroupId = page.DocumentationID, page.PageGroupID
+	case ResourcePageGroup:
+		var group models.PageGroup
+		if err := tx.Unscoped().Select("id", "documentation_id", "parent_id").First(&group, item.RefID).Error; err != nil {
+			return fmt.Errorf("page_group_not_found")
+		}
+		documentationId, parentGroupId = group.DocumentationID, group.ParentID
+	case ResourceDocumentation:
+		var doc models.Documentation
+		if err := tx.Unscoped().Select("id", "cloned_from").First(&doc, item.RefID).Error; err != nil {
+			return fmt.Errorf("documentation_not_found")
+		}
+
+		if doc.ClonedFrom != nil && *doc.ClonedFrom != doc.ID {
+			var count int64
+			if err := tx.Model(&models.Documentation{}).Where("id = ?", *doc.ClonedFrom).Count(&count).Error; err != nil {
+				return fmt.Errorf("failed_to_verify_documentation")
+			}
+			if count == 0 {
+				return fmt.Errorf("restore_parent_first")
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown_resource")
+	}
+
+	var count int64
+	if err := tx.Model(&models.Documentation{}).Where("id = ?", documentationId).Count(&count).Error; err != nil {
+		return fmt.Errorf("failed_to_verify_documentation")
+	}
+	if count == 0 {
+		return fmt.Errorf("restore_parent_first")
+	}
+
+	if parentGroupId != nil {
+		if err := tx.Model(&models.PageGroup{}).Where("id = ?", *parentGroupId).Count(&count).Error; err != nil {
+			return fmt.Errorf("failed_to_verify_parent_page_group")
+		}
+		if count == 0 {
+			return fmt.Errorf("restore_parent_first")
+		}
+	}
+
+	return nil
+}
+
+// restoreReparentedVersions moves the versions that were moved up when a
+// documentation was deleted back under it. Those moved again since are left
+// where they are.
+func restoreReparentedVersions(tx *gorm.DB, item models.TrashItem) error {
+	if len(item.ReparentedVersions) == 0 {
+		return nil
+	}
+
+	var doc models.Documentation
+	if err := tx.Select("id", "cloned_from").First(&doc, item.RefID).Error; err != nil {
+		return fmt.Errorf("documentation_not_found")
+	}
+
+	for childId, clonedFrom := range item.ReparentedVersions {
+		query := tx.Unscoped().Model(&models.Documentation{}).Where("id = ?", childId)
+		if doc.ClonedFrom == nil {
+			query = query.Where("cloned_from IS NULL")
+		} else {
+			query = query.Where("cloned_from = ?", *doc.ClonedFrom)
+		}
+
+		if err := query.UpdateColumn("cloned_from", clonedFrom).Error; err != nil {
+			return fmt.Errorf("failed_to_restore_documentation")
+		}
+	}
+
+	return nil
+}
+
+// RestoreTrashItem puts a deleted page, page group or documentation back with
+// everything that was deleted along with it, in its original place and order.
+func (service *DocService) RestoreTrashItem(user models.User, id uint) error {
+	item, err := service.getTrashItem(service.DB, id)
+	if err != nil {
+		return err
+	}
+
+	if err := service.requireDocumentationRole(user.ID, item.DocumentationID, trashItemRole(item)); err != nil {
+		return err
+	}
+
+	prefix := trashSlugPrefix(item.ID)
+
+	err = service.DB.Transaction(func(tx *gorm.DB) error {
+		if err := checkTrashParents(tx, item); err != nil {
+			return err
+		}
+
+		var pages []models.Page
+		if err := tx.Unscoped().Where("trash_item_id = ?", item.ID).Find(&pages).Error; err != nil {
+			return fmt.Errorf("failed_to_fetch_pages")
+		}
+
+		for i := range pages {
+			pages[i].Slug = strings.TrimPrefix(pages[i].Slug, prefix)
+
+			var slugCount int64
+			if err := tx.Model(&models.Page{}).
+				Where("documentation_id = ? AND slug = ?", pages[i].DocumentationID, pages[i].Slug).
+				Count(&slugCount).Error; err != nil {
+				return fmt.Errorf("failed_to_check_slug")
+			}
+
+			if slugCount > 0 {
+				return fmt.Errorf("slug_already_in_use")
+			}
+		}
+
+		var groups []models.PageGroup
+		if err := tx.Unscoped().Where("trash_item_id = ?", item.ID).Find(&groups).Error; err != nil {
+			return fmt.Errorf("failed_to_fetch_page_groups")
+		}
+
+		if err := tx.Unscoped().Model(&models.Documentation{}).Where("trash_item_id = ?", item.ID).
+			UpdateColumns(map[string]interface{}{"deleted_at": nil, "trash_item_id": nil}).Error; err != nil {
+			return fmt.Errorf("failed_to_restore_documentation")
+		}
+
+		if item.Kind == ResourceDocumentation {
+			var doc models.Documentation
+			if err := tx.First(&doc, item.RefID).Error; err != nil {
+				return fmt.Errorf("documentation_not_found")
+			}
+
+			if err := indexDocumentation(tx, doc); err != nil {
+				return err
+			}
+		}
+
+		if err := restoreReparentedVersions(tx, item); err != nil {
+			return err
+		}
+
+		if err := tx.Unscoped().Model(&models.PageGroup{}).Where("trash_item_id = ?", item.ID).
+			UpdateColumns(map[string]interface{}{"deleted_at": nil, "trash_item_id": nil}).Error; err != nil {
+			return fmt.Errorf("failed_to_restore_page_groups")
+		}
+
+		if err := tx.Unscoped().Model(&models.Page{}).Where("trash_item_id = ?", item.ID).
+			UpdateColumns(map[string]interface{}{
+				"deleted_at":    nil,
+				"trash_item_id": nil,
+				"slug":          gorm.Expr("SUBSTR(slug, ?)", len(prefix)+1),
+			}).Error; err != nil {
+			return fmt.Errorf("failed_to_restore_pages")
+		}
+
+		for _, page := range pages {
+			if err := indexPage(tx, page); err != nil {
+				return err
+			}
+		}
+
+		for _, group := range groups {
+			if err := indexPageGroup(tx, group); err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Delete(&models.TrashItem{}, item.ID).Error; err != nil {
+			return fmt.Errorf("failed_to_delete_trash_item")
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	service.audit(item.Kind+".restore", item.Kind, item.RefID, nil, map[string]interface{}{"trashItemId": item.ID, "title": item.Title})
+
+	if item.Kind == ResourceDocumentation && item.RootDocumentationID == item.RefID {
+		// The site was taken down with the documentation, it has to be set
+		// up again before it can be built.
+		go func() {
+			if err := service.InitRsPress(item.RefID); err != nil {
+				logger.Error("Failed to initialize restored documentation", zap.Uint("doc_id", item.RefID), zap.Error(err))
+				return
+			}
+
+			if err := service.AddBuildTrigger(item.RefID, false); err != nil {
+				logger.Error("Failed to add build trigger", zap.Uint("doc_id", item.RefID), zap.Error(err))
+			}
+		}()
+		return nil
+	}
+
+	if err := service.AddBuildTrigger(item.RootDocumentationID, false); err != nil {
+		return fmt.Errorf("failed_to_update_write_build")
+	}
+
+	return nil
+}
+
+// purgePages deletes trashed pages for good, with their translations,
+// revisions, comment threads and the redirects that follow them.
+func purgePages(tx *gorm.DB, pageIds []uint) error {
+	if len(pageIds) == 0 {
+		return nil
+	}
+
+	var translationIds []uint
+	if err := tx.Unscoped().Model(&models.Page{}).Where("translation_of_id IN ?", pageIds).Pluck("id", &translationIds).Error; err != nil {
+		return fmt.Errorf("failed_to_get_translations")
+	}
+
+	if err := purgePages(tx, translationIds); err != nil {
+		return err
+	}
+
+	if err := tx.Exec("DELETE FROM page_editors WHERE page_id IN ?", pageIds).Error; err != nil {
+		return fmt.Errorf("failed_to_clear_page_associations")
+	}
+
+	if err := tx.Where("page_id IN ?", pageIds).Delete(&models.PageRevision{}).Error; err != nil {
+		return fmt.Errorf("failed_to_delete_page_revisions")
+	}
+
+	if err := deleteCommentThreads(tx, "page_id IN ?", pageIds); err != nil {
+		return err
+	}
+
+	if err := tx.Where("page_id IN ?", pageIds).Delete(&models.PagePath{}).Error; err != nil {
+		return fmt.Errorf("failed_to_delete_page_paths")
+	}
+
+	if err := tx.Where("page_id IN ? AND automatic = ?", pageIds, true).Delete(&models.Redirect{}).Error; err != nil {
+		return fmt.Errorf("failed_to_delete_redirects")
+	}
+
+	if err := tx.Unscoped().Where("id IN ?", pageIds).Delete(&models.Page{}).Error; err != nil {
+		return fmt.Errorf("failed_to_delete_page")
+	}
+
+	return nil
+}
+
+// nestedTrashItems returns the trash items that were deleted from inside
+// what a trash item holds before it was deleted itself. They can't be
+// restored without it, so they're purged with it.
+func nestedTrashItems(tx *gorm.DB, item models.TrashItem) ([]uint, error) {
+	nested := make([]uint, 0)
+	collect := func(query *gorm.DB) error {
+		var ids []uint
+		if err := query.Where("trash_item_id <> ?", item.ID).Distinct().Pluck("trash_item_id", &ids).Error; err != nil {
+			return fmt.Errorf("failed_to_get_trash")
+		}
+		nested = append(nested, ids...)
+		return nil
+	}
+
+	switch item.Kind {
+	case ResourcePageGroup:
+		groupIds := tx.Unscoped().Model(&models.PageGroup{}).Select("id").Where("trash_item_id = ?", item.ID)
+
+		if err := collect(tx.Unscoped().Model(&models.Page{}).Where("page_group_id IN (?)", groupIds)); err != nil {
+			return nil, err
+		}
+
+		if err := collect(tx.Unscoped().Model(&models.PageGroup{}).Where("parent_id IN (?)", groupIds)); err != nil {
+			return nil, err
+		}
+	case ResourceDocumentation:
+		if err := collect(tx.Unscoped().Model(&models.Page{}).Where("documentation_id = ?", item.RefID)); err != nil {
+			return nil, err
+		}
+
+		if err := collect(tx.Unscoped().Model(&models.PageGroup{}).Where("documentation_id = ?", item.RefID)); err != nil {
+			return nil, err
+		}
+
+		if err := collect(tx.Unscoped().Model(&models.Documentation{}).Where("cloned_from = ?", item.RefID)); err != nil {
+			return nil, err
+		}
+	}
+
+	return nested, nil
+}
+
+func purgeTrashItem(tx *gorm.DB, item models.TrashItem) error {
+	nested, err := nestedTrashItems(tx, item)
+	if err != nil {
+		return err
+	}
+
+	for _, nestedId := range nested {
+		var nestedItem models.TrashItem
+		if err := tx.First(&nestedItem, nestedId).Error; err != nil {
+			continue
+		}
+
+		if err := purgeTrashItem(tx, nestedItem); err != nil {
+			return err
+		}
+	}
+
+	var pageIds []uint
+	if err := tx.Unscoped().Model(&models.Page{}).Where("trash_item_id = ?", item.ID).Pluck("id", &pageIds).Error; err != nil {
+		return fmt.Errorf("failed_to_fetch_pages")
+	}
+
+	if err := purgePages(tx, pageIds); err != nil {
+		return err
+	}
+
+	var groupIds []uint
+	if err := tx.Unscoped().Model(&models.PageGroup{}).Where("trash_item_id = ?", item.ID).Pluck("id", &groupIds).Error; err != nil {
+		return fmt.Errorf("failed_to_fetch_page_groups")
+	}
+
+	if len(groupIds) > 0 {
+		if err := tx.Exec("DELETE FROM pagegroup_editors WHERE page_group_id IN ?", groupIds).Error; err != nil {
+			return fmt.Errorf("failed_to_clear_editors")
+		}
+
+		if err := tx.Unscoped().Where("id IN ?", groupIds).Delete(&models.PageGroup{}).Error; err != nil {
+			return fmt.Errorf("failed_to_delete_page_group")
+		}
+	}
+
+	if item.Kind == ResourceDocumentation {
+		var doc models.Documentation
+		if err := tx.Unscoped().Select("id", "cloned_from").Where("trash_item_id = ?", item.ID).First(&doc).Error; err == nil {
+			if err := tx.Exec("DELETE FROM documentation_editors WHERE documentation_id = ?", doc.ID).Error; err != nil {
+				return fmt.Errorf("failed_to_clear_documentation_editors_association")
+			}
+
+			// Members and grants are kept on the root documentation only.
+			if doc.ClonedFrom == nil {
+				if err := tx.Where("documentation_id = ?", doc.ID).Delete(&models.DocumentationMember{}).Error; err != nil {
+					return fmt.Errorf("failed_to_delete_documentation_members")
+				}
+
+				if err := tx.Where("documentation_id = ?", doc.ID).Delete(&models.DocumentationGroupGrant{}).Error; err != nil {
+					return fmt.Errorf("failed_to_delete_documentation_group_grants")
+				}
+			}
+
+			if err := tx.Where("documentation_id = ?", doc.ID).Delete(&models.Redirect{}).Error; err != nil {
+				return fmt.Errorf("failed_to_delete_redirects")
+			}
+
+			if err := tx.Unscoped().Delete(&models.Documentation{}, doc.ID).Error; err != nil {
+				return fmt.Errorf("failed_to_delete_documentation")
+			}
+		}
+	}
+
+	if err := tx.Delete(&models.TrashItem{}, item.ID).Error; err != nil {
+		return fmt.Errorf("failed_to_delete_trash_item")
+	}
+
+	return nil
+}
+
+// PurgeTrashItem deletes a trash item for good, before its time is up.
+func (service *DocService) PurgeTrashItem(user models.User, id uint) error {
+	item, err := service.getTrashItem(service.DB, id)
+	if err != nil {
+		return err
+	}
+
+	if err := service.requireDocumentationRole(user.ID, item.DocumentationID, trashItemRole(item)); err != nil {
+		return err
+	}
+
+	if err := service.DB.Transaction(func(tx *gorm.DB) error {
+		return purgeTrashItem(tx, item)
+	}); err != nil {
+		return err
+	}
+
+	service.audit(item.Kind+".purge", item.Kind, item.RefID, map[string]interface{}{"trashItemId": item.ID, "title": item.Title}, nil)
+
+	return nil
+}
+
+const trashPurgerPoll = time.Hour
+
+// TrashPurger deletes trash items for good once their retention is over.
+type TrashPurger struct {
+	service *DocService
+}
+
+func NewTrashPurger(service *DocService) *TrashPurger {
+	return &TrashPurger{service: service}
+}
+
+// Start runs the purger until ctx is cancelled.
+func (purger *TrashPurger) Start(ctx context.Context) {
+	for {
+		if _, err := purger.RunOnce(); err != nil {
+			logger.Error("Trash purger failed", zap.Error(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(trashPurgerPoll):
+		}
+	}
+}
+
+// RunOnce purges the trash items that are due and returns how many it
+// purged.
+func (purger *TrashPurger) RunOnce() (int, error) {
+	service := purger.service
+
+	var items []models.TrashItem
+	if err := service.DB.Where("purge_at <= ?", time.Now().UTC()).Order("id ASC").Find(&items).Error; err != nil {
+		return 0, fmt.Errorf("failed_to_get_trash")
+	}
+
+	purged := 0
+	var firstErr error
+
+	for _, item := range items {
+		err := service.DB.Transaction(func(tx *gorm.DB) error {
+			return purgeTrashItem(tx, item)
+		})
+
+		if err != nil {
+			logger.Error("Failed to purge trash item", zap.Uint("trash_item_id", item.ID), zap.Error(err))
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+
+		service.audit(item.Kind+".purge", item.Kind, item.RefID, map[string]interface{}{"trashItemId": item.ID, "title": item.Title}, nil)
+		purged++
+	}
+
+	return purged, firstErr
+}
@@ -0,0 +1,266 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"git.difuse.io/Difuse/kalmia/db/models"
+)
+
+func TestTrash(t *testing.T) {
+	if TestDocService == nil {
+		t.Fatal("TestDocService is nil")
+	}
+
+	db := TestDocService.DB
+
+	var admin, user models.User
+	if err := db.Where("username = ?", "admin").First(&admin).Error; err != nil {
+		t.Fatalf("Failed to get admin: %v", err)
+	}
+	if err := db.Where("username = ?", "user").First(&user).Error; err != nil {
+		t.Fatalf("Failed to get user: %v", err)
+	}
+
+	doc := models.Documentation{Name: "Trash", Version: "1.0.0", BaseURL: "/trash", AuthorID: admin.ID}
+	if err := db.Create(&doc).Error; err != nil {
+		t.Fatalf("Failed to create documentation: %v", err)
+	}
+
+	if err := TestDocService.SetDocumentationMember(admin, doc.ID, user.ID, RoleEditor); err != nil {
+		t.Fatalf("SetDocumentationMember returned an error: %v", err)
+	}
+
+	group := models.PageGroup{DocumentationID: doc.ID, Name: "Guides", AuthorID: admin.ID}
+	if _, err := TestDocService.CreatePageGroup(&group); err != nil {
+		t.Fatalf("CreatePageGroup returned an error: %v", err)
+	}
+
+	order := uint(3)
+	page := models.Page{DocumentationID: doc.ID, PageGroupID: &group.ID, Title: "Setup", Slug: "/setup", Content: "[]", AuthorID: admin.ID, Order: &order}
+	if err := TestDocService.CreatePage(&page); err != nil {
+		t.Fatalf("CreatePage returned an error: %v", err)
+	}
+
+	trashItem := func(t *testing.T, kind string, id uint) models.TrashItem {
+		t.Helper()
+		var item models.TrashItem
+		if err := db.Where("kind = ? AND ref_id = ?", kind, id).First(&item).Error; err != nil {
+			t.Fatalf("Failed to get trash item: %v", err)
+		}
+		return item
+	}
+
+	t.Run("Deleting a page group trashes its pages", func(t *testing.T) {
+		if err := TestDocService.DeletePageGroup(admin, group.ID); err != nil {
+			t.Fatalf("DeletePageGroup returned an error: %v", err)
+		}
+
+		if _, err := TestDocService.GetPage(page.ID); err == nil {
+			t.Error("Expected the page to be gone")
+		}
+
+		items, err := TestDocService.GetTrash(doc.ID)
+		if err != nil {
+			t.Fatalf("GetTrash returned an error: %v", err)
+		}
+
+		if len(items) != 1 || items[0].Kind != ResourcePageGroup || items[0].Title != "Guides" {
+			t.Fatalf("Expected the page group in the trash, got %+v", items)
+		}
+
+		if !items[0].PurgeAt.After(time.Now().Add(24 * time.Hour)) {
+			t.Errorf("Expected the item to be kept for the retention period, purge at %v", items[0].PurgeAt)
+		}
+
+		reused := models.Page{DocumentationID: doc.ID, Title: "Setup again", Slug: "/setup", Content: "[]", AuthorID: admin.ID}
+		if err := TestDocService.CreatePage(&reused); err != nil {
+			t.Fatalf("Expected the slug to be free, got %v", err)
+		}
+
+		item := trashItem(t, ResourcePageGroup, group.ID)
+		if err := TestDocService.RestoreTrashItem(admin, item.ID); err == nil || err.Error() != "slug_already_in_use" {
+			t.Errorf("Expected slug_already_in_use, got %v", err)
+		}
+
+		if err := TestDocService.DeletePage(admin, reused.ID); err != nil {
+			t.Fatalf("DeletePage returned an error: %v", err)
+		}
+	})
+
+	t.Run("Only maintainers restore", func(t *testing.T) {
+		item := trashItem(t, ResourcePageGroup, group.ID)
+		if err := TestDocService.RestoreTrashItem(user, item.ID); err == nil || err.Error() != "insufficient_documentation_role" {
+			t.Errorf("Expected insufficient_documentation_role, got %v", err)
+		}
+	})
+
+	t.Run("Restoring puts everything back in place", func(t *testing.T) {
+		item := trashItem(t, ResourcePageGroup, group.ID)
+		if err := TestDocService.RestoreTrashItem(admin, item.ID); err != nil {
+			t.Fatalf("RestoreTrashItem returned an error: %v", err)
+		}
+
+		restored, err := TestDocService.GetPage(page.ID)
+		if err != nil {
+			t.Fatalf("GetPage returned an error: %v", err)
+		}
+
+		if restored.Slug != "/setup" || restored.PageGroupID == nil || *restored.PageGroupID != group.ID || restored.Order == nil || *restored.Order != order {
+			t.Errorf("Expected the page back in its group and order, got %+v", restored)
+		}
+
+		if _, err := TestDocService.getTrashItem(db, item.ID); err == nil || err.Error() != "trash_item_not_found" {
+			t.Errorf("Expected the trash item to be gone, got %v", err)
+		}
+	})
+
+	t.Run("A page can't come back before its group", func(t *testing.T) {
+		extra := models.Page{DocumentationID: doc.ID, PageGroupID: &group.ID, Title: "Extra", Slug: "/extra", Content: "[]", AuthorID: admin.ID}
+		if err := TestDocService.CreatePage(&extra); err != nil {
+			t.Fatalf("CreatePage returned an error: %v", err)
+		}
+
+		if err := TestDocService.DeletePage(admin, extra.ID); err != nil {
+			t.Fatalf("DeletePage returned an error: %v", err)
+		}
+
+		if err := TestDocService.DeletePageGroup(admin, group.ID); err != nil {
+			t.Fatalf("DeletePageGroup returned an error: %v", err)
+		}
+
+		pageItem := trashItem(t, ResourcePage, extra.ID)
+		if err := TestDocService.RestoreTrashItem(admin, pageItem.ID); err == nil || err.Error() != "restore_parent_first" {
+			t.Errorf("Expected restore_parent_first, got %v", err)
+		}
+
+		groupItem := trashItem(t, ResourcePageGroup, group.ID)
+		if err := TestDocService.RestoreTrashItem(admin, groupItem.ID); err != nil {
+			t.Fatalf("RestoreTrashItem returned an error: %v", err)
+		}
+
+		if err := TestDocService.RestoreTrashItem(admin, pageItem.ID); err != nil {
+			t.Fatalf("RestoreTrashItem returned an error: %v", err)
+		}
+
+		if _, err := TestDocService.GetPage(extra.ID); err != nil {
+			t.Errorf("Expected the page to be back, got %v", err)
+		}
+	})
+
+	t.Run("The purger removes expired items", func(t *testing.T) {
+		if err := TestDocService.DeletePage(admin, page.ID); err != nil {
+			t.Fatalf("DeletePage returned an error: %v", err)
+		}
+
+		item := trashItem(t, ResourcePage, page.ID)
+		if err := db.Model(&item).Update("purge_at", time.Now().UTC().Add(-time.Minute)).Error; err != nil {
+			t.Fatalf("Failed to expire trash item: %v", err)
+		}
+
+		if _, err := NewTrashPurger(TestDocService).RunOnce(); err != nil {
+			t.Fatalf("RunOnce returned an error: %v", err)
+		}
+
+		var count int64
+		db.Unscoped().Model(&models.Page{}).Where("id = ?", page.ID).Count(&count)
+		if count != 0 {
+			t.Errorf("Expected the page to be purged, got %d rows", count)
+		}
+
+		if _, err := TestDocService.getTrashItem(db, item.ID); err == nil {
+			t.Error("Expected the trash item to be gone")
+		}
+	})
+
+	t.Run("Versions are trashed and restored", func(t *testing.T) {
+		version := models.Documentation{Name: "Trash", Version: "2.0.0", BaseURL: "/trash", AuthorID: admin.ID, ClonedFrom: &doc.ID}
+		if err := db.Create(&version).Error; err != nil {
+			t.Fatalf("Failed to create version: %v", err)
+		}
+
+		versionPage := models.Page{DocumentationID: version.ID, Title: "New", Slug: "/new", Content: "[]", AuthorID: admin.ID}
+		if err := TestDocService.CreatePage(&versionPage); err != nil {
+			t.Fatalf("CreatePage returned an error: %v", err)
+		}
+
+		if err := TestDocService.DeleteDocumentation(admin, version.ID); err != nil {
+			t.Fatalf("DeleteDocumentation returned an error: %v", err)
+		}
+
+		if _, err := TestDocService.GetDocumentation(version.ID); err == nil {
+			t.Error("Expected the version to be gone")
+		}
+
+		item := trashItem(t, ResourceDocumentation, version.ID)
+		if item.RootDocumentationID != doc.ID {
+			t.Errorf("Expected the version's trash to belong to the root, got %d", item.RootDocumentationID)
+		}
+
+		if err := TestDocService.RestoreTrashItem(user, item.ID); err == nil || err.Error() != "insufficient_documentation_role" {
+			t.Errorf("Expected insufficient_documentation_role, got %v", err)
+		}
+
+		if err := TestDocService.RestoreTrashItem(admin, item.ID); err != nil {
+			t.Fatalf("RestoreTrashItem returned an error: %v", err)
+		}
+
+		restored, err := TestDocService.GetPage(versionPage.ID)
+		if err != nil {
+			t.Fatalf("GetPage returned an error: %v", err)
+		}
+
+		if restored.Slug != "/new" {
+			t.Errorf("Expected the slug to be restored, got %s", restored.Slug)
+		}
+	})
+
+	t.Run("Restoring a middle version puts it back in the version chain", func(t *testing.T) {
+		middle := models.Documentation{Name: "Trash", Version: "3.0.0", BaseURL: "/trash", AuthorID: admin.ID, ClonedFrom: &doc.ID}
+		if err := db.Create(&middle).Error; err != nil {
+			t.Fatalf("Failed to create version: %v", err)
+		}
+
+		latest := models.Documentation{Name: "Trash", Version: "4.0.0", BaseURL: "/trash", AuthorID: admin.ID, ClonedFrom: &middle.ID}
+		if err := db.Create(&latest).Error; err != nil {
+			t.Fatalf("Failed to create version: %v", err)
+		}
+
+		clonedFrom := func(t *testing.T, id uint) uint {
+			t.Helper()
+			var stored models.Documentation
+			if err := db.Select("id", "cloned_from").First(&stored, id).Error; err != nil {
+				t.Fatalf("Failed to get documentation: %v", err)
+			}
+			if stored.ClonedFrom == nil {
+				return 0
+			}
+			return *stored.ClonedFrom
+		}
+
+		if err := TestDocService.DeleteDocumentation(admin, middle.ID); err != nil {
+			t.Fatalf("DeleteDocumentation returned an error: %v", err)
+		}
+
+		if parent := clonedFrom(t, latest.ID); parent != doc.ID {
+			t.Errorf("Expected the later version to move up to %d, got %d", doc.ID, parent)
+		}
+
+		item := trashItem(t, ResourceDocumentation, middle.ID)
+		if item.ReparentedVersions[latest.ID] != middle.ID {
+			t.Errorf("Expected the trash item to remember the later version, got %v", item.ReparentedVersions)
+		}
+
+		if err := TestDocService.RestoreTrashItem(admin, item.ID); err != nil {
+			t.Fatalf("RestoreTrashItem returned an error: %v", err)
+		}
+
+		if parent := clonedFrom(t, middle.ID); parent != doc.ID {
+			t.Errorf("Expected the restored version to stay under %d, got %d", doc.ID, parent)
+		}
+
+		if parent := clonedFrom(t, latest.ID); parent != middle.ID {
+			t.Errorf("Expected the later version back under %d, got %d", middle.ID, parent)
+		}
+	})
+}
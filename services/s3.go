@@ -2,13 +2,16 @@ package services
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"git.difuse.io/Difuse/kalmia/config"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
@@ -75,3 +78,118 @@ func UploadToS3Storage(file io.Reader, originalFilename, contentType string, par
 var newS3Client = func(sess *session.Session) s3iface.S3API {
 	return s3.New(sess)
 }
+
+func DownloadFromS3Storage(key string, parsedConfig *config.Config) ([]byte, string, error) {
+	sess, err := session.NewSession(&aws.Config{
+		Endpoint:         aws.String(parsedConfig.S3.Endpoint),
+		Region:           aws.String(parsedConfig.S3.Region),
+		Credentials:      credentials.NewStaticCredentials(parsedConfig.S3.AccessKeyId, parsedConfig.S3.SecretAccessKey, ""),
+		S3ForcePathStyle: aws.Bool(parsedConfig.S3.UsePathStyle),
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf("error creating AWS session: %v", err)
+	}
+
+	svc := newS3Client(sess)
+
+	result, err := svc.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(parsedConfig.S3.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf("error downloading from S3-compatible storage: %v", err)
+	}
+
+	defer result.Body.Close()
+
+	body, err := io.ReadAll(result.Body)
+	if err != nil {
+		return nil, "", fmt.Errorf("error reading object body: %v", err)
+	}
+
+	contentType := aws.StringValue(result.ContentType)
+	if contentType == "" {
+		contentType = mimetype.Detect(body).String()
+	}
+
+	return body, contentType, nil
+}
+
+// UploadedAssetKey returns the storage key of an asset URL produced by
+// UploadToS3Storage, or false if the URL doesn't point at our storage.
+func UploadedAssetKey(assetURL string, parsedConfig *config.Config) (string, bool) {
+	prefixes := []string{"/kal-api/file/get/"}
+
+	switch strings.Count(parsedConfig.S3.PublicUrlFormat, "%s") {
+	case 1:
+		prefixes = append(prefixes, fmt.Sprintf(parsedConfig.S3.PublicUrlFormat, ""))
+	case 2:
+		prefixes = append(prefixes, fmt.Sprintf(parsedConfig.S3.PublicUrlFormat, "uploads", ""))
+	}
+
+	for _, prefix := range prefixes {
+		if prefix != "" && strings.HasPrefix(assetURL, prefix) {
+			key := strings.TrimPrefix(assetURL, prefix)
+			if key != "" && !strings.Contains(key, "/") {
+				return key, true
+			}
+		}
+	}
+
+	return "", false
+}
+
+// DeleteFromS3Storage removes the object under key, if there is one.
+func DeleteFromS3Storage(key string, parsedConfig *config.Config) error {
+	sess, err := session.NewSession(&aws.Config{
+		Endpoint:         aws.String(parsedConfig.S3.Endpoint),
+		Region:           aws.String(parsedConfig.S3.Region),
+		Credentials:      credentials.NewStaticCredentials(parsedConfig.S3.AccessKeyId, parsedConfig.S3.SecretAccessKey, ""),
+		S3ForcePathStyle: aws.Bool(parsedConfig.S3.UsePathStyle),
+	})
+	if err != nil {
+		return fmt.Errorf("error creating AWS session: %v", err)
+	}
+
+	svc := newS3Client(sess)
+
+	_, err = svc.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(parsedConfig.S3.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return fmt.Errorf("error deleting from S3-compatible storage: %v", err)
+	}
+
+	return nil
+}
+
+// S3ObjectExists reports whether the storage still holds the object under
+// key.
+func S3ObjectExists(key string, parsedConfig *config.Config) (bool, error) {
+	sess, err := session.NewSession(&aws.Config{
+		Endpoint:         aws.String(parsedConfig.S3.Endpoint),
+		Region:           aws.String(parsedConfig.S3.Region),
+		Credentials:      credentials.NewStaticCredentials(parsedConfig.S3.AccessKeyId, parsedConfig.S3.SecretAccessKey, ""),
+		S3ForcePathStyle: aws.Bool(parsedConfig.S3.UsePathStyle),
+	})
+	if err != nil {
+		return false, fmt.Errorf("error creating AWS session: %v", err)
+	}
+
+	svc := newS3Client(sess)
+
+	_, err = svc.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(parsedConfig.S3.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		var awsErr awserr.Error
+		if errors.As(err, &awsErr) && (awsErr.Code() == "NotFound" || awsErr.Code() == s3.ErrCodeNoSuchKey) {
+			return false, nil
+		}
+		return false, fmt.Errorf("error checking S3-compatible storage: %v", err)
+	}
+
+	return true, nil
+}
@@ -0,0 +1,556 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"git.difuse.io/Difuse/kalmia/db/models"
+	"git.difuse.io/Difuse/kalmia/logger"
+	"git.difuse.io/Difuse/kalmia/utils"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	WebhookEventPageCreated        = "page.created"
+	WebhookEventPageEdited         = "page.edited"
+	WebhookEventPageDeleted        = "page.deleted"
+	WebhookEventPagePublished      = "page.published"
+	WebhookEventPageUnpublished    = "page.unpublished"
+	WebhookEventCommentCreated     = "comment.created"
+	WebhookEventVersionCreated     = "version.created"
+	WebhookEventBuildSucceeded     = "build.succeeded"
+	WebhookEventBuildFailed        = "build.failed"
+	WebhookEventGitDeployCompleted = "git_deploy.completed"
+)
+
+var WebhookEvents = []string{
+	WebhookEventPageCreated,
+	WebhookEventPageEdited,
+	WebhookEventPageDeleted,
+	WebhookEventPagePublished,
+	WebhookEventPageUnpublished,
+	WebhookEventCommentCreated,
+	WebhookEventVersionCreated,
+	WebhookEventBuildSucceeded,
+	WebhookEventBuildFailed,
+	WebhookEventGitDeployCompleted,
+}
+
+const (
+	WebhookDeliveryPending   = "pending"
+	WebhookDeliverySucceeded = "succeeded"
+	WebhookDeliveryFailed    = "failed"
+)
+
+const (
+	webhookMaxAttempts      = 8
+	webhookRetryBaseDelay   = 10 * time.Second
+	webhookRetryMaxDelay    = time.Hour
+	webhookDeliveryLease    = 2 * time.Minute
+	webhookDeliveryTimeout  = 10 * time.Second
+	webhookDispatcherPoll   = 2 * time.Second
+	webhookMaxResponseBytes = 4 << 10
+)
+
+type WebhookPayload struct {
+	Event           string      `json:"event"`
+	DocumentationID uint        `json:"documentationId"`
+	Timestamp       time.Time   `json:"timestamp"`
+	Data            interface{} `json:"data"`
+}
+
+type webhookPage struct {
+	ID              uint   `json:"id"`
+	DocumentationID uint   `json:"documentationId"`
+	PageGroupID     *uint  `json:"pageGroupId,omitempty"`
+	Title           string `json:"title"`
+	Slug            string `json:"slug"`
+	EditorID        uint   `json:"editorId,omitempty"`
+}
+
+func newWebhookPage(page models.Page, docId uint, editorId uint) webhookPage {
+	return webhookPage{
+		ID:              page.ID,
+		DocumentationID: docId,
+		PageGroupID:     page.PageGroupID,
+		Title:           page.Title,
+		Slug:            page.Slug,
+		EditorID:        editorId,
+	}
+}
+
+// SignWebhookPayload returns the value of the X-Kalmia-Signature header,
+// an HMAC-SHA256 of the raw request body.
+func SignWebhookPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func generateWebhookSecret() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(secret), nil
+}
+
+func validateWebhook(webhookURL string, events []string) error {
+	parsed, err := url.Parse(webhookURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("invalid_webhook_url")
+	}
+
+	for _, event := range events {
+		if !utils.ArrayContains(WebhookEvents, event) {
+			return fmt.Errorf("invalid_webhook_event")
+		}
+	}
+
+	return nil
+}
+
+func webhookSubscribes(webhook models.Webhook, event string) bool {
+	var events []string
+	if webhook.Events != "" {
+		if err := json.Unmarshal([]byte(webhook.Events), &events); err != nil {
+			return false
+		}
+	}
+
+	return len(events) == 0 || utils.ArrayContains(events, event)
+}
+
+func (service *DocService) GetWebhooks(documentationId uint) ([]models.Webhook, error) {
+	webhooks := make([]models.Webhook, 0)
+
+	query := service.DB.Order("id ASC")
+	if documentationId != 0 {
+		query = query.Where("documentation_id = ?", documentationId)
+	}
+
+	if err := query.Find(&webhooks).Error; err != nil {
+		return nil, fmt.Errorf("failed_to_get_webhooks")
+	}
+
+	return webhooks, nil
+}
+
+// CreateWebhook registers an endpoint. A nil documentationId subscribes it to
+// every documentation, no events subscribes it to every event, and an empty
+// secret has one generated.
+func (service *DocService) CreateWebhook(user models.User, documentationId *uint, webhookURL, secret, description string, events []string) (models.Webhook, error) {
+	if err := validateWebhook(webhookURL, events); err != nil {
+		return models.Webhook{}, err
+	}
+
+	if documentationId != nil && !service.IsDocIdValid(*documentationId) {
+		return models.Webhook{}, fmt.Errorf("documentation_not_found")
+	}
+
+	if secret == "" {
+		generated, err := generateWebhookSecret()
+		if err != nil {
+			return models.Webhook{}, fmt.Errorf("failed_to_generate_secret")
+		}
+		secret = generated
+	}
+
+	eventsJSON, err := json.Marshal(events)
+	if err != nil || len(events) == 0 {
+		eventsJSON = []byte("[]")
+	}
+
+	webhook := models.Webhook{
+		DocumentationID: documentationId,
+		URL:             webhookURL,
+		Secret:          secret,
+		Events:          string(eventsJSON),
+		Description:     description,
+		Active:          true,
+		AuthorID:        user.ID,
+	}
+
+	if err := service.DB.Create(&webhook).Error; err != nil {
+		return models.Webhook{}, fmt.Errorf("failed_to_create_webhook")
+	}
+
+	service.audit("webhook.create", AuditEntityWebhook, webhook.ID, nil, webhookAuditSummary(webhook))
+
+	return webhook, nil
+}
+
+func (service *DocService) EditWebhook(id uint, webhookURL, secret, description string, events []string, active *bool) error {
+	var webhook models.Webhook
+	if err := service.DB.First(&webhook, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("webhook_not_found")
+		}
+		return fmt.Errorf("failed_to_get_webhook")
+	}
+
+	before := webhookAuditSummary(webhook)
+
+	if webhookURL != "" {
+		webhook.URL = webhookURL
+	}
+
+	if err := validateWebhook(webhook.URL, events); err != nil {
+		return err
+	}
+
+	if secret != "" {
+		webhook.Secret = secret
+	}
+
+	if description != "" {
+		webhook.Description = description
+	}
+
+	if events != nil {
+		eventsJSON, err := json.Marshal(events)
+		if err != nil {
+			return fmt.Errorf("failed_to_marshal_events")
+		}
+		webhook.Events = string(eventsJSON)
+	}
+
+	if active != nil {
+		webhook.Active = *active
+	}
+
+	if err := service.DB.Save(&webhook).Error; err != nil {
+		return fmt.Errorf("failed_to_update_webhook")
+	}
+
+	after := webhookAuditSummary(webhook)
+	after["secretChanged"] = secret != ""
+	service.audit("webhook.edit", AuditEntityWebhook, webhook.ID, before, after)
+
+	return nil
+}
+
+func (service *DocService) DeleteWebhook(id uint) error {
+	var webhook models.Webhook
+	err := service.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&webhook, id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return fmt.Errorf("webhook_not_found")
+			}
+			return fmt.Errorf("failed_to_get_webhook")
+		}
+
+		if err := tx.Delete(&webhook).Error; err != nil {
+			return fmt.Errorf("failed_to_delete_webhook")
+		}
+
+		if err := tx.Where("webhook_id = ?", id).Delete(&models.WebhookDelivery{}).Error; err != nil {
+			return fmt.Errorf("failed_to_delete_webhook_deliveries")
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	service.audit("webhook.delete", AuditEntityWebhook, webhook.ID, webhookAuditSummary(webhook), nil)
+
+	return nil
+}
+
+type WebhookDeliveryOptions struct {
+	WebhookID uint
+	Status    string
+	Limit     int
+	Offset    int
+}
+
+func (service *DocService) GetWebhookDeliveries(opts WebhookDeliveryOptions) ([]models.WebhookDelivery, int64, error) {
+	if opts.Limit <= 0 || opts.Limit > 100 {
+		opts.Limit = 20
+	}
+
+	if opts.Offset < 0 {
+		opts.Offset = 0
+	}
+
+	query := service.DB.Model(&models.WebhookDelivery{})
+
+	if opts.WebhookID != 0 {
+		query = query.Where("webhook_id = ?", opts.WebhookID)
+	}
+
+	if opts.Status != "" {
+		query = query.Where("status = ?", opts.Status)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed_to_get_webhook_deliveries")
+	}
+
+	deliveries := make([]models.WebhookDelivery, 0)
+	if err := query.Order("id DESC").Limit(opts.Limit).Offset(opts.Offset).Find(&deliveries).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed_to_get_webhook_deliveries")
+	}
+
+	return deliveries, total, nil
+}
+
+func (service *DocService) GetWebhookDelivery(id uint) (models.WebhookDelivery, error) {
+	var delivery models.WebhookDelivery
+	if err := service.DB.First(&delivery, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return models.WebhookDelivery{}, fmt.Errorf("webhook_delivery_not_found")
+		}
+		return models.WebhookDelivery{}, fmt.Errorf("failed_to_get_webhook_delivery")
+	}
+
+	return delivery, nil
+}
+
+// emitWebhookEvent queues a delivery of event for every active webhook of the
+// documentation, its root documentation, or of all documentations. Failures
+// are only logged, they must not fail the change that caused the event.
+func (service *DocService) emitWebhookEvent(docId uint, event string, data interface{}) {
+	docIds := []uint{docId}
+	if rootId, err := service.GetRootParentID(docId); err == nil && rootId != 0 && rootId != docId {
+		docIds = append(docIds, rootId)
+	}
+
+	var webhooks []models.Webhook
+	if err := service.DB.
+		Where("active = ? AND (documentation_id IS NULL OR documentation_id IN ?)", true, docIds).
+		Find(&webhooks).Error; err != nil {
+		logger.Error("Failed to get webhooks", zap.String("event", event), zap.Error(err))
+		return
+	}
+
+	if len(webhooks) == 0 {
+		return
+	}
+
+	now := time.Now().UTC()
+
+	payload, err := json.Marshal(WebhookPayload{
+		Event:           event,
+		DocumentationID: docId,
+		Timestamp:       now,
+		Data:            data,
+	})
+	if err != nil {
+		logger.Error("Failed to marshal webhook payload", zap.String("event", event), zap.Error(err))
+		return
+	}
+
+	for _, webhook := range webhooks {
+		if !webhookSubscribes(webhook, event) {
+			continue
+		}
+
+		delivery := models.WebhookDelivery{
+			WebhookID:       webhook.ID,
+			DocumentationID: docId,
+			Event:           event,
+			Payload:         string(payload),
+			Status:          WebhookDeliveryPending,
+			NextAttemptAt:   &now,
+		}
+
+		if err := service.DB.Create(&delivery).Error; err != nil {
+			logger.Error("Failed to queue webhook delivery", zap.Uint("webhook_id", webhook.ID), zap.Error(err))
+		}
+	}
+}
+
+func webhookRetryDelay(attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+
+	delay := webhookRetryBaseDelay
+	for i := 1; i < attempt; i++ {
+		delay *= 2
+		if delay >= webhookRetryMaxDelay {
+			return webhookRetryMaxDelay
+		}
+	}
+
+	return delay
+}
+
+// WebhookDispatcher sends the queued webhook deliveries. A delivery is leased
+// by pushing its next attempt into the future before it is sent, so one that
+// was in flight when the process died is simply sent again later.
+type WebhookDispatcher struct {
+	service *DocService
+	client  *http.Client
+}
+
+func NewWebhookDispatcher(service *DocService) *WebhookDispatcher {
+	return &WebhookDispatcher{
+		service: service,
+		client:  &http.Client{Timeout: webhookDeliveryTimeout},
+	}
+}
+
+// Start sends deliveries until ctx is cancelled.
+func (dispatcher *WebhookDispatcher) Start(ctx context.Context) {
+	for {
+		sent, err := dispatcher.RunOnce()
+		if err != nil {
+			logger.Error("Webhook dispatcher failed to get deliveries", zap.Error(err))
+		}
+
+		if sent > 0 {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(webhookDispatcherPoll):
+		}
+	}
+}
+
+// RunOnce sends the deliveries that are due and returns how many were sent.
+func (dispatcher *WebhookDispatcher) RunOnce() (int, error) {
+	db := dispatcher.service.DB
+	now := time.Now().UTC()
+
+	var due []models.WebhookDelivery
+	if err := db.Where("status = ? AND next_attempt_at <= ?", WebhookDeliveryPending, now).
+		Order("next_attempt_at ASC").
+		Limit(20).
+		Find(&due).Error; err != nil {
+		return 0, err
+	}
+
+	sent := 0
+
+	for _, delivery := range due {
+		leased := db.Model(&models.WebhookDelivery{}).
+			Where("id = ? AND status = ? AND next_attempt_at <= ?", delivery.ID, WebhookDeliveryPending, now).
+			Updates(map[string]interface{}{
+				"next_attempt_at": now.Add(webhookDeliveryLease),
+				"attempts":        gorm.Expr("attempts + 1"),
+			})
+		if leased.Error != nil || leased.RowsAffected == 0 {
+			continue
+		}
+
+		delivery.Attempts++
+		dispatcher.deliver(delivery)
+		sent++
+	}
+
+	return sent, nil
+}
+
+func (dispatcher *WebhookDispatcher) deliver(delivery models.WebhookDelivery) {
+	db := dispatcher.service.DB
+
+	var webhook models.Webhook
+	if err := db.First(&webhook, delivery.WebhookID).Error; err != nil {
+		db.Model(&delivery).Updates(map[string]interface{}{
+			"status": WebhookDeliveryFailed,
+			"error":  "webhook_not_found",
+		})
+		return
+	}
+
+	body := []byte(delivery.Payload)
+	start := time.Now()
+
+	responseCode, responseBody, err := dispatcher.send(webhook, delivery, body)
+
+	updates := map[string]interface{}{
+		"response_code": responseCode,
+		"response_body": responseBody,
+		"duration_ms":   time.Since(start).Milliseconds(),
+		"error":         "",
+	}
+
+	if err == nil {
+		updates["status"] = WebhookDeliverySucceeded
+		updates["delivered_at"] = time.Now().UTC()
+	} else {
+		updates["error"] = err.Error()
+
+		if delivery.Attempts >= webhookMaxAttempts {
+			updates["status"] = WebhookDeliveryFailed
+		} else {
+			updates["next_attempt_at"] = time.Now().UTC().Add(webhookRetryDelay(delivery.Attempts))
+		}
+
+		logger.Warn("Webhook delivery failed",
+			zap.Uint("delivery_id", delivery.ID),
+			zap.Uint("webhook_id", webhook.ID),
+			zap.Int("attempt", delivery.Attempts),
+			zap.Error(err))
+	}
+
+	if err := db.Model(&delivery).Updates(updates).Error; err != nil {
+		logger.Error("Failed to save webhook delivery", zap.Uint("delivery_id", delivery.ID), zap.Error(err))
+	}
+}
+
+func (dispatcher *WebhookDispatcher) send(webhook models.Webhook, delivery models.WebhookDelivery, body []byte) (int, string, error) {
+	req, err := http.NewRequest(http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, "", err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Kalmia-Webhook")
+	req.Header.Set("X-Kalmia-Event", delivery.Event)
+	req.Header.Set("X-Kalmia-Delivery", strconv.FormatUint(uint64(delivery.ID), 10))
+	req.Header.Set("X-Kalmia-Signature", SignWebhookPayload(webhook.Secret, body))
+
+	resp, err := dispatcher.client.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+
+	defer resp.Body.Close()
+
+	responseBody, _ := io.ReadAll(io.LimitReader(resp.Body, webhookMaxResponseBytes))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, string(responseBody), fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	return resp.StatusCode, string(responseBody), nil
+}
+
+// webhookAuditSummary leaves the secret out of the audit log.
+func webhookAuditSummary(webhook models.Webhook) map[string]interface{} {
+	summary := map[string]interface{}{
+		"url":         webhook.URL,
+		"events":      webhook.Events,
+		"description": webhook.Description,
+		"active":      webhook.Active,
+	}
+
+	if webhook.DocumentationID != nil {
+		summary["documentationId"] = *webhook.DocumentationID
+	}
+
+	return summary
+}
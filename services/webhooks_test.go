@@ -0,0 +1,176 @@
+package services
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"git.difuse.io/Difuse/kalmia/db/models"
+)
+
+func TestWebhookRetryDelay(t *testing.T) {
+	tests := []struct {
+		attempt  int
+		expected time.Duration
+	}{
+		{0, 10 * time.Second},
+		{1, 10 * time.Second},
+		{2, 20 * time.Second},
+		{4, 80 * time.Second},
+		{20, time.Hour},
+	}
+
+	for _, test := range tests {
+		if got := webhookRetryDelay(test.attempt); got != test.expected {
+			t.Errorf("webhookRetryDelay(%d) = %v, expected %v", test.attempt, got, test.expected)
+		}
+	}
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	if TestDocService == nil {
+		t.Fatal("TestDocService is nil")
+	}
+
+	db := TestDocService.DB
+
+	// Deliveries queued by other tests have nowhere to go.
+	db.Model(&models.WebhookDelivery{}).Where("status = ?", WebhookDeliveryPending).Update("status", WebhookDeliveryFailed)
+
+	var mutex sync.Mutex
+	status := http.StatusInternalServerError
+	var received []*http.Request
+	var bodies [][]byte
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		received = append(received, r)
+		bodies = append(bodies, body)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	doc := models.Documentation{Name: "Webhooks", Version: "1.0.0", BaseURL: "/webhooks", AuthorID: 1}
+	if err := db.Create(&doc).Error; err != nil {
+		t.Fatalf("Failed to create documentation: %v", err)
+	}
+
+	user := models.User{ID: 1}
+
+	if _, err := TestDocService.CreateWebhook(user, &doc.ID, server.URL, "", "", []string{"page.renamed"}); err == nil {
+		t.Error("Expected an unknown event to be rejected")
+	}
+
+	webhook, err := TestDocService.CreateWebhook(user, &doc.ID, server.URL, "", "test", []string{WebhookEventPageCreated})
+	if err != nil {
+		t.Fatalf("CreateWebhook returned an error: %v", err)
+	}
+
+	if webhook.Secret == "" {
+		t.Fatal("Expected a generated secret")
+	}
+
+	webhooks, err := TestDocService.GetWebhooks(doc.ID)
+	if err != nil || len(webhooks) != 1 {
+		t.Fatalf("GetWebhooks returned %d webhooks, %v", len(webhooks), err)
+	}
+
+	listed, err := json.Marshal(webhooks)
+	if err != nil {
+		t.Fatalf("Failed to marshal webhooks: %v", err)
+	}
+
+	if strings.Contains(string(listed), webhook.Secret) || !strings.Contains(string(listed), `"secretSet":true`) {
+		t.Errorf("Expected the secret to be hidden from listings, got %s", listed)
+	}
+
+	page := models.Page{DocumentationID: doc.ID, Title: "Hooked", Slug: "/hooked", Content: "[]", AuthorID: 1}
+	if err := TestDocService.CreatePage(&page); err != nil {
+		t.Fatalf("CreatePage returned an error: %v", err)
+	}
+
+	if err := TestDocService.EditPage(user, page.ID, "Hooked", "/hooked", "[]", nil, nil, nil); err != nil {
+		t.Fatalf("EditPage returned an error: %v", err)
+	}
+
+	deliveries, total, err := TestDocService.GetWebhookDeliveries(WebhookDeliveryOptions{WebhookID: webhook.ID})
+	if err != nil {
+		t.Fatalf("GetWebhookDeliveries returned an error: %v", err)
+	}
+
+	if total != 1 || deliveries[0].Event != WebhookEventPageCreated {
+		t.Fatalf("Expected a single page.created delivery, got %d", total)
+	}
+
+	deliveryId := deliveries[0].ID
+	dispatcher := NewWebhookDispatcher(TestDocService)
+
+	t.Run("Retry", func(t *testing.T) {
+		sent, err := dispatcher.RunOnce()
+		if err != nil || sent != 1 {
+			t.Fatalf("Expected one delivery to be sent, got %d, %v", sent, err)
+		}
+
+		delivery, _ := TestDocService.GetWebhookDelivery(deliveryId)
+		if delivery.Status != WebhookDeliveryPending || delivery.Attempts != 1 || delivery.ResponseCode != http.StatusInternalServerError {
+			t.Fatalf("Expected a pending delivery after a failure, got %s after %d attempts (%d)", delivery.Status, delivery.Attempts, delivery.ResponseCode)
+		}
+
+		if delivery.NextAttemptAt == nil || !delivery.NextAttemptAt.After(time.Now().UTC()) {
+			t.Fatal("Expected the retry to be scheduled in the future")
+		}
+
+		if sent, _ := dispatcher.RunOnce(); sent != 0 {
+			t.Fatalf("Expected no delivery before the retry is due, got %d", sent)
+		}
+	})
+
+	t.Run("Success", func(t *testing.T) {
+		db.Model(&models.WebhookDelivery{}).Where("id = ?", deliveryId).Update("next_attempt_at", time.Now().UTC().Add(-time.Second))
+
+		mutex.Lock()
+		status = http.StatusOK
+		mutex.Unlock()
+
+		if sent, err := dispatcher.RunOnce(); err != nil || sent != 1 {
+			t.Fatalf("Expected one delivery to be sent, got %d, %v", sent, err)
+		}
+
+		delivery, _ := TestDocService.GetWebhookDelivery(deliveryId)
+		if delivery.Status != WebhookDeliverySucceeded || delivery.Attempts != 2 || delivery.DeliveredAt == nil {
+			t.Fatalf("Expected a succeeded delivery, got %s after %d attempts", delivery.Status, delivery.Attempts)
+		}
+
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		last := received[len(received)-1]
+		if last.Header.Get("X-Kalmia-Event") != WebhookEventPageCreated {
+			t.Errorf("Expected event header %s, got %s", WebhookEventPageCreated, last.Header.Get("X-Kalmia-Event"))
+		}
+
+		expected := SignWebhookPayload(webhook.Secret, bodies[len(bodies)-1])
+		if last.Header.Get("X-Kalmia-Signature") != expected {
+			t.Errorf("Expected signature %s, got %s", expected, last.Header.Get("X-Kalmia-Signature"))
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		if err := TestDocService.DeleteWebhook(webhook.ID); err != nil {
+			t.Fatalf("DeleteWebhook returned an error: %v", err)
+		}
+
+		if _, total, _ := TestDocService.GetWebhookDeliveries(WebhookDeliveryOptions{WebhookID: webhook.ID}); total != 0 {
+			t.Errorf("Expected deliveries to be deleted with the webhook, got %d", total)
+		}
+	})
+}
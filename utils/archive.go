@@ -0,0 +1,178 @@
+package utils
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	ArchiveFormatZip   = "zip"
+	ArchiveFormatTarGz = "tar.gz"
+)
+
+// ArchiveWriter writes files into a zip or tar.gz stream using the same calls,
+// so exporters don't need to care about the format.
+type ArchiveWriter interface {
+	WriteFile(name string, data []byte) error
+	Close() error
+}
+
+type zipArchiveWriter struct {
+	writer *zip.Writer
+}
+
+func (z *zipArchiveWriter) WriteFile(name string, data []byte) error {
+	entry, err := z.writer.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = entry.Write(data)
+	return err
+}
+
+func (z *zipArchiveWriter) Close() error {
+	return z.writer.Close()
+}
+
+type tarGzArchiveWriter struct {
+	gzip   *gzip.Writer
+	writer *tar.Writer
+}
+
+func (t *tarGzArchiveWriter) WriteFile(name string, data []byte) error {
+	if err := t.writer.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    int64(len(data)),
+		ModTime: time.Now(),
+	}); err != nil {
+		return err
+	}
+
+	_, err := t.writer.Write(data)
+	return err
+}
+
+func (t *tarGzArchiveWriter) Close() error {
+	if err := t.writer.Close(); err != nil {
+		return err
+	}
+	return t.gzip.Close()
+}
+
+func NewArchiveWriter(w io.Writer, format string) (ArchiveWriter, error) {
+	switch format {
+	case ArchiveFormatZip, "":
+		return &zipArchiveWriter{writer: zip.NewWriter(w)}, nil
+	case ArchiveFormatTarGz:
+		gz := gzip.NewWriter(w)
+		return &tarGzArchiveWriter{gzip: gz, writer: tar.NewWriter(gz)}, nil
+	default:
+		return nil, fmt.Errorf("unsupported archive format: %s", format)
+	}
+}
+
+// ExtractTarGz is the tar.gz counterpart of ExtractZip, with the same path
+// and size checks.
+func ExtractTarGz(src, dest string, maxBytes int64) error {
+	file, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return err
+	}
+
+	defer gz.Close()
+
+	reader := tar.NewReader(gz)
+	var written int64
+
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		target, err := SafeJoin(dest, header.Name)
+		if err != nil {
+			return err
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := MakeDir(target); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := MakeDir(filepath.Dir(target)); err != nil {
+				return err
+			}
+
+			out, err := os.Create(target)
+			if err != nil {
+				return err
+			}
+
+			var source io.Reader = reader
+			if maxBytes > 0 {
+				source = io.LimitReader(reader, maxBytes-written+1)
+			}
+
+			n, err := io.Copy(out, source)
+			out.Close()
+			if err != nil {
+				return err
+			}
+
+			written += n
+			if maxBytes > 0 && written > maxBytes {
+				return fmt.Errorf("archive exceeds the maximum extracted size")
+			}
+		}
+	}
+
+	return nil
+}
+
+// ExtractArchive extracts a zip or tar.gz archive, detected from its header.
+func ExtractArchive(src, dest string, maxBytes int64) error {
+	file, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+
+	magic, err := bufio.NewReader(file).Peek(4)
+	file.Close()
+	if err != nil {
+		return fmt.Errorf("unrecognized archive format")
+	}
+
+	switch {
+	case magic[0] == 'P' && magic[1] == 'K':
+		return ExtractZip(src, dest, maxBytes)
+	case magic[0] == 0x1f && magic[1] == 0x8b:
+		return ExtractTarGz(src, dest, maxBytes)
+	default:
+		return fmt.Errorf("unrecognized archive format")
+	}
+}
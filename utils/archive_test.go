@@ -0,0 +1,69 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestArchiveRoundTrip(t *testing.T) {
+	for _, format := range []string{ArchiveFormatZip, ArchiveFormatTarGz} {
+		t.Run(format, func(t *testing.T) {
+			tempDir := t.TempDir()
+			archivePath := filepath.Join(tempDir, "export")
+
+			file, err := os.Create(archivePath)
+			if err != nil {
+				t.Fatalf("Failed to create archive: %v", err)
+			}
+
+			writer, err := NewArchiveWriter(file, format)
+			if err != nil {
+				t.Fatalf("NewArchiveWriter returned an error: %v", err)
+			}
+
+			files := map[string]string{
+				"manifest.json":  `{"formatVersion":1}`,
+				"pages/1.json":   `[]`,
+				"assets/a.png":   "png",
+				"public/fav.ico": "ico",
+			}
+
+			for name, content := range files {
+				if err := writer.WriteFile(name, []byte(content)); err != nil {
+					t.Fatalf("WriteFile(%s) returned an error: %v", name, err)
+				}
+			}
+
+			if err := writer.Close(); err != nil {
+				t.Fatalf("Close returned an error: %v", err)
+			}
+			file.Close()
+
+			dest := filepath.Join(tempDir, "out")
+			if err := ExtractArchive(archivePath, dest, 0); err != nil {
+				t.Fatalf("ExtractArchive returned an error: %v", err)
+			}
+
+			for name, content := range files {
+				got, err := os.ReadFile(filepath.Join(dest, name))
+				if err != nil || string(got) != content {
+					t.Errorf("%s = %q, %v; want %q", name, got, err, content)
+				}
+			}
+		})
+	}
+
+	t.Run("Unknown format", func(t *testing.T) {
+		if _, err := NewArchiveWriter(nil, "rar"); err == nil {
+			t.Errorf("NewArchiveWriter expected an error for an unknown format")
+		}
+
+		path := filepath.Join(t.TempDir(), "plain.txt")
+		os.WriteFile(path, []byte("not an archive"), 0644)
+
+		if err := ExtractArchive(path, t.TempDir(), 0); err == nil {
+			t.Errorf("ExtractArchive expected an error for a plain file")
+		}
+	})
+}
@@ -0,0 +1,231 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+const (
+	BlockAdded     = "added"
+	BlockRemoved   = "removed"
+	BlockModified  = "modified"
+	BlockMoved     = "moved"
+	BlockUnchanged = "unchanged"
+)
+
+type BlockChange struct {
+	Change   string `json:"change"`
+	BlockID  string `json:"blockId"`
+	ParentID string `json:"parentId,omitempty"`
+	Depth    int    `json:"depth"`
+	OldIndex int    `json:"oldIndex"`
+	NewIndex int    `json:"newIndex"`
+	Old      *Block `json:"old,omitempty"`
+	New      *Block `json:"new,omitempty"`
+}
+
+type flatBlock struct {
+	key      string
+	parentID string
+	depth    int
+	index    int
+	block    Block
+}
+
+func ParseBlocks(content string) ([]Block, error) {
+	var blocks []Block
+
+	if content == "" || content == `"[]"` {
+		return blocks, nil
+	}
+
+	if err := json.Unmarshal([]byte(content), &blocks); err != nil {
+		return nil, err
+	}
+
+	return blocks, nil
+}
+
+// FindBlock looks for the block with the given id at any depth.
+func FindBlock(blocks []Block, id string) (Block, bool) {
+	for _, block := range blocks {
+		if block.ID == id {
+			return block, true
+		}
+
+		if found, ok := FindBlock(block.Children, id); ok {
+			return found, true
+		}
+	}
+
+	return Block{}, false
+}
+
+func flattenBlocks(blocks []Block, parentID string, depth int, out *[]flatBlock) {
+	for _, block := range blocks {
+		key := block.ID
+		if key == "" {
+			key = fmt.Sprintf("%s/%d", parentID, len(*out))
+		}
+
+		shallow := block
+		shallow.Children = nil
+
+		*out = append(*out, flatBlock{
+			key:      key,
+			parentID: parentID,
+			depth:    depth,
+			index:    len(*out),
+			block:    shallow,
+		})
+
+		flattenBlocks(block.Children, key, depth+1, out)
+	}
+}
+
+// DiffBlocks compares two BlockNote documents block by block, matching blocks
+// by their id. Nested children are compared as blocks of their own, so a
+// change inside a list item doesn't mark the whole list as modified. Changes
+// are returned in the order of the new document, followed by removed blocks
+// in their original order.
+func DiffBlocks(oldBlocks, newBlocks []Block) []BlockChange {
+	var oldFlat, newFlat []flatBlock
+	flattenBlocks(oldBlocks, "", 0, &oldFlat)
+	flattenBlocks(newBlocks, "", 0, &newFlat)
+
+	oldByKey := make(map[string]flatBlock, len(oldFlat))
+	for _, fb := range oldFlat {
+		oldByKey[fb.key] = fb
+	}
+
+	newKeys := make(map[string]bool, len(newFlat))
+	for _, fb := range newFlat {
+		newKeys[fb.key] = true
+	}
+
+	var commonOld, commonNew []string
+	for _, fb := range oldFlat {
+		if newKeys[fb.key] {
+			commonOld = append(commonOld, fb.key)
+		}
+	}
+	for _, fb := range newFlat {
+		if _, ok := oldByKey[fb.key]; ok {
+			commonNew = append(commonNew, fb.key)
+		}
+	}
+
+	inOrder := longestCommonSubsequence(commonOld, commonNew)
+
+	changes := make([]BlockChange, 0, len(newFlat))
+
+	for _, nb := range newFlat {
+		newBlock := nb.block
+		change := BlockChange{
+			BlockID:  nb.key,
+			ParentID: nb.parentID,
+			Depth:    nb.depth,
+			OldIndex: -1,
+			NewIndex: nb.index,
+			New:      &newBlock,
+		}
+
+		ob, existed := oldByKey[nb.key]
+		if !existed {
+			change.Change = BlockAdded
+			changes = append(changes, change)
+			continue
+		}
+
+		oldBlock := ob.block
+		change.OldIndex = ob.index
+		change.Old = &oldBlock
+
+		switch {
+		case !blocksEqual(ob.block, nb.block):
+			change.Change = BlockModified
+		case ob.parentID != nb.parentID || !inOrder[nb.key]:
+			change.Change = BlockMoved
+		default:
+			change.Change = BlockUnchanged
+			change.Old = nil
+		}
+
+		changes = append(changes, change)
+	}
+
+	var removed []BlockChange
+	for _, ob := range oldFlat {
+		if newKeys[ob.key] {
+			continue
+		}
+
+		oldBlock := ob.block
+		removed = append(removed, BlockChange{
+			Change:   BlockRemoved,
+			BlockID:  ob.key,
+			ParentID: ob.parentID,
+			Depth:    ob.depth,
+			OldIndex: ob.index,
+			NewIndex: -1,
+			Old:      &oldBlock,
+		})
+	}
+
+	sort.SliceStable(removed, func(i, j int) bool {
+		return removed[i].OldIndex < removed[j].OldIndex
+	})
+
+	return append(changes, removed...)
+}
+
+func blocksEqual(a, b Block) bool {
+	if a.Type != b.Type {
+		return false
+	}
+
+	aJSON, errA := json.Marshal(map[string]interface{}{"props": a.Props, "content": a.Content})
+	bJSON, errB := json.Marshal(map[string]interface{}{"props": b.Props, "content": b.Content})
+	if errA != nil || errB != nil {
+		return reflect.DeepEqual(a, b)
+	}
+
+	return string(aJSON) == string(bJSON)
+}
+
+func longestCommonSubsequence(a, b []string) map[string]bool {
+	lengths := make([][]int, len(a)+1)
+	for i := range lengths {
+		lengths[i] = make([]int, len(b)+1)
+	}
+
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lengths[i][j] = lengths[i+1][j+1] + 1
+			} else if lengths[i+1][j] >= lengths[i][j+1] {
+				lengths[i][j] = lengths[i+1][j]
+			} else {
+				lengths[i][j] = lengths[i][j+1]
+			}
+		}
+	}
+
+	result := make(map[string]bool)
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		if a[i] == b[j] {
+			result[a[i]] = true
+			i++
+			j++
+		} else if lengths[i+1][j] >= lengths[i][j+1] {
+			i++
+		} else {
+			j++
+		}
+	}
+
+	return result
+}
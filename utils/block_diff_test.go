@@ -0,0 +1,124 @@
+package utils
+
+import (
+	"testing"
+)
+
+func paragraph(id, text string) Block {
+	return Block{
+		ID:      id,
+		Type:    "paragraph",
+		Props:   map[string]interface{}{"textColor": "default"},
+		Content: []interface{}{map[string]interface{}{"type": "text", "text": text, "styles": map[string]interface{}{}}},
+	}
+}
+
+func changesByID(changes []BlockChange) map[string]string {
+	result := make(map[string]string)
+	for _, change := range changes {
+		result[change.BlockID] = change.Change
+	}
+	return result
+}
+
+func TestParseBlocks(t *testing.T) {
+	blocks, err := ParseBlocks(`[{"id":"a","type":"paragraph","props":{},"content":[],"children":[]}]`)
+	if err != nil {
+		t.Fatalf("ParseBlocks returned an error: %v", err)
+	}
+	if len(blocks) != 1 || blocks[0].ID != "a" {
+		t.Errorf("ParseBlocks() = %v, want one block with id a", blocks)
+	}
+
+	blocks, err = ParseBlocks(`"[]"`)
+	if err != nil || len(blocks) != 0 {
+		t.Errorf("ParseBlocks() on empty content = %v, %v", blocks, err)
+	}
+
+	if _, err := ParseBlocks(`not json`); err == nil {
+		t.Errorf("ParseBlocks() expected an error for invalid JSON")
+	}
+}
+
+func TestFindBlock(t *testing.T) {
+	nested := paragraph("b", "Nested")
+	parent := paragraph("a", "Parent")
+	parent.Children = []Block{nested}
+
+	if block, ok := FindBlock([]Block{parent}, "b"); !ok || block.ID != "b" {
+		t.Errorf("FindBlock() = %v, %v, want the nested block", block, ok)
+	}
+
+	if _, ok := FindBlock([]Block{parent}, "c"); ok {
+		t.Errorf("FindBlock() found a block that doesn't exist")
+	}
+}
+
+func TestDiffBlocks(t *testing.T) {
+	tests := []struct {
+		name     string
+		old      []Block
+		new      []Block
+		expected map[string]string
+	}{
+		{
+			name:     "Identical documents",
+			old:      []Block{paragraph("a", "one"), paragraph("b", "two")},
+			new:      []Block{paragraph("a", "one"), paragraph("b", "two")},
+			expected: map[string]string{"a": BlockUnchanged, "b": BlockUnchanged},
+		},
+		{
+			name:     "Added and removed blocks",
+			old:      []Block{paragraph("a", "one"), paragraph("b", "two")},
+			new:      []Block{paragraph("a", "one"), paragraph("c", "three")},
+			expected: map[string]string{"a": BlockUnchanged, "b": BlockRemoved, "c": BlockAdded},
+		},
+		{
+			name:     "Modified block",
+			old:      []Block{paragraph("a", "one")},
+			new:      []Block{paragraph("a", "uno")},
+			expected: map[string]string{"a": BlockModified},
+		},
+		{
+			name:     "Moved block",
+			old:      []Block{paragraph("a", "one"), paragraph("b", "two"), paragraph("c", "three")},
+			new:      []Block{paragraph("c", "three"), paragraph("a", "one"), paragraph("b", "two")},
+			expected: map[string]string{"a": BlockUnchanged, "b": BlockUnchanged, "c": BlockMoved},
+		},
+		{
+			name: "Nested child modified",
+			old: []Block{{
+				ID:       "list",
+				Type:     "bulletListItem",
+				Props:    map[string]interface{}{},
+				Content:  []interface{}{},
+				Children: []Block{paragraph("child", "before")},
+			}},
+			new: []Block{{
+				ID:       "list",
+				Type:     "bulletListItem",
+				Props:    map[string]interface{}{},
+				Content:  []interface{}{},
+				Children: []Block{paragraph("child", "after")},
+			}},
+			expected: map[string]string{"list": BlockUnchanged, "child": BlockModified},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changes := DiffBlocks(tt.old, tt.new)
+			got := changesByID(changes)
+
+			if len(got) != len(tt.expected) {
+				t.Fatalf("DiffBlocks() returned %d changes, want %d: %v", len(got), len(tt.expected), got)
+			}
+
+			for id, expected := range tt.expected {
+				if got[id] != expected {
+					t.Errorf("block %s: got %s, want %s", id, got[id], expected)
+				}
+			}
+		})
+	}
+}
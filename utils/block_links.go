@@ -0,0 +1,48 @@
+package utils
+
+// BlockLink is a link or an embedded file found in a block.
+type BlockLink struct {
+	BlockID string
+	URL     string
+	Asset   bool
+}
+
+// BlockLinks collects the links in the inline content of blocks, tables
+// included, and the files image, video, audio and file blocks embed. Child
+// blocks are searched too.
+func BlockLinks(blocks []Block) []BlockLink {
+	var links []BlockLink
+
+	for _, block := range blocks {
+		switch block.Type {
+		case "image", "video", "audio", "file":
+			if url, ok := block.Props["url"].(string); ok && url != "" {
+				links = append(links, BlockLink{BlockID: block.ID, URL: url, Asset: true})
+			}
+		}
+
+		collectInlineLinks(block.ID, block.Content, &links)
+		links = append(links, BlockLinks(block.Children)...)
+	}
+
+	return links
+}
+
+func collectInlineLinks(blockID string, content interface{}, links *[]BlockLink) {
+	switch v := content.(type) {
+	case []interface{}:
+		for _, item := range v {
+			collectInlineLinks(blockID, item, links)
+		}
+	case map[string]interface{}:
+		if v["type"] == "link" {
+			if href, ok := v["href"].(string); ok && href != "" {
+				*links = append(*links, BlockLink{BlockID: blockID, URL: href})
+			}
+		}
+
+		for _, value := range v {
+			collectInlineLinks(blockID, value, links)
+		}
+	}
+}
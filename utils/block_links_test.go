@@ -0,0 +1,40 @@
+package utils
+
+import (
+	"testing"
+)
+
+func TestBlockLinks(t *testing.T) {
+	blocks, err := ParseBlocks(`[
+		{"id":"a","type":"paragraph","props":{},"content":[
+			{"type":"text","text":"See ","styles":{}},
+			{"type":"link","href":"/guides/setup","content":[{"type":"text","text":"setup","styles":{}}]}
+		],"children":[
+			{"id":"b","type":"image","props":{"url":"/kal-api/file/get/upload-1.png"},"content":[],"children":[]}
+		]},
+		{"id":"c","type":"table","props":{},"content":{"type":"tableContent","rows":[
+			{"cells":[[{"type":"link","href":"https://example.com","content":[]}]]}
+		]},"children":[]},
+		{"id":"d","type":"video","props":{"url":""},"content":[],"children":[]}
+	]`)
+	if err != nil {
+		t.Fatalf("ParseBlocks returned an error: %v", err)
+	}
+
+	want := []BlockLink{
+		{BlockID: "a", URL: "/guides/setup"},
+		{BlockID: "b", URL: "/kal-api/file/get/upload-1.png", Asset: true},
+		{BlockID: "c", URL: "https://example.com"},
+	}
+
+	links := BlockLinks(blocks)
+	if len(links) != len(want) {
+		t.Fatalf("BlockLinks() = %v, want %v", links, want)
+	}
+
+	for i := range want {
+		if links[i] != want[i] {
+			t.Errorf("BlockLinks()[%d] = %v, want %v", i, links[i], want[i])
+		}
+	}
+}
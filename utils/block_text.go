@@ -0,0 +1,76 @@
+package utils
+
+import "strings"
+
+// codeBlockTypes are blocks whose content is code, which is left as it is.
+var codeBlockTypes = []string{"procode", "codeBlock"}
+
+// ReplaceBlockTexts hands the text of the inline content of blocks, tables,
+// links and child blocks included, to replace in one call, and puts what it
+// returns in its place. Code blocks, inline code, props and the structure of
+// the blocks are left alone.
+func ReplaceBlockTexts(blocks []Block, replace func(texts []string) ([]string, error)) error {
+	var nodes []map[string]interface{}
+	collectBlockTexts(blocks, &nodes)
+
+	if len(nodes) == 0 {
+		return nil
+	}
+
+	texts := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		texts = append(texts, node["text"].(string))
+	}
+
+	replaced, err := replace(texts)
+	if err != nil {
+		return err
+	}
+
+	for i, node := range nodes {
+		if i < len(replaced) {
+			node["text"] = replaced[i]
+		}
+	}
+
+	return nil
+}
+
+func collectBlockTexts(blocks []Block, nodes *[]map[string]interface{}) {
+	for _, block := range blocks {
+		if !ArrayContains(codeBlockTypes, block.Type) {
+			collectInlineTexts(block.Content, nodes)
+		}
+
+		collectBlockTexts(block.Children, nodes)
+	}
+}
+
+func collectInlineTexts(content interface{}, nodes *[]map[string]interface{}) {
+	switch v := content.(type) {
+	case []interface{}:
+		for _, item := range v {
+			collectInlineTexts(item, nodes)
+		}
+	case map[string]interface{}:
+		if v["type"] == "text" {
+			text, ok := v["text"].(string)
+			if !ok || strings.TrimSpace(text) == "" {
+				return
+			}
+
+			if styles, ok := v["styles"].(map[string]interface{}); ok && styles["code"] == true {
+				return
+			}
+
+			*nodes = append(*nodes, v)
+			return
+		}
+
+		for key, value := range v {
+			if key != "props" {
+				collectInlineTexts(value, nodes)
+			}
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package utils
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestReplaceBlockTexts(t *testing.T) {
+	blocks, err := ParseBlocks(`[
+		{"id":"a","type":"heading","props":{"level":2},"content":[{"type":"text","text":"Setup","styles":{}}],"children":[
+			{"id":"b","type":"paragraph","props":{"textColor":"red"},"content":[
+				{"type":"text","text":"Run ","styles":{}},
+				{"type":"text","text":"make build","styles":{"code":true}},
+				{"type":"text","text":" ","styles":{}},
+				{"type":"link","href":"/guides/setup","content":[{"type":"text","text":"now","styles":{"bold":true}}]}
+			],"children":[]}
+		]},
+		{"id":"c","type":"table","props":{},"content":{"type":"tableContent","rows":[
+			{"cells":[[{"type":"text","text":"cell","styles":{}}]]}
+		]},"children":[]},
+		{"id":"d","type":"procode","props":{"code":"echo hi","language":"bash"},"content":[{"type":"text","text":"echo hi","styles":{}}],"children":[]}
+	]`)
+	if err != nil {
+		t.Fatalf("ParseBlocks returned an error: %v", err)
+	}
+
+	var seen []string
+	err = ReplaceBlockTexts(blocks, func(texts []string) ([]string, error) {
+		seen = texts
+		replaced := make([]string, 0, len(texts))
+		for _, text := range texts {
+			replaced = append(replaced, strings.ToUpper(text))
+		}
+		return replaced, nil
+	})
+	if err != nil {
+		t.Fatalf("ReplaceBlockTexts returned an error: %v", err)
+	}
+
+	if len(seen) != 4 {
+		t.Errorf("Expected 4 texts to replace, got %q", seen)
+	}
+
+	content, err := json.Marshal(blocks)
+	if err != nil {
+		t.Fatalf("Failed to marshal blocks: %v", err)
+	}
+
+	for _, want := range []string{`"text":"SETUP"`, `"text":"RUN "`, `"text":"NOW"`, `"text":"CELL"`, `"text":"make build"`, `"text":"echo hi"`, `"href":"/guides/setup"`, `"textColor":"red"`} {
+		if !strings.Contains(string(content), want) {
+			t.Errorf("Expected %s in the blocks, got %s", want, content)
+		}
+	}
+}
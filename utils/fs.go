@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"archive/zip"
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
@@ -200,3 +201,93 @@ func IsEmptyDir(path string) (bool, error) {
 
 	return false, err
 }
+
+// SafeJoin joins a relative path onto base and refuses results that escape
+// base, e.g. through ".." segments or absolute paths inside archives.
+func SafeJoin(base, relative string) (string, error) {
+	target := filepath.Join(base, filepath.FromSlash(relative))
+
+	rel, err := filepath.Rel(base, target)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("path %q escapes %q", relative, base)
+	}
+
+	return target, nil
+}
+
+// ExtractZip unpacks the archive at src into dest. Entries that would land
+// outside dest are rejected, and extraction stops once more than maxBytes of
+// uncompressed data has been written (0 disables the limit).
+func ExtractZip(src, dest string, maxBytes int64) error {
+	reader, err := zip.OpenReader(src)
+	if err != nil {
+		return err
+	}
+
+	defer reader.Close()
+
+	var written int64
+
+	for _, file := range reader.File {
+		target, err := SafeJoin(dest, file.Name)
+		if err != nil {
+			return err
+		}
+
+		if file.FileInfo().IsDir() {
+			if err := MakeDir(target); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if !file.Mode().IsRegular() {
+			continue
+		}
+
+		if err := MakeDir(filepath.Dir(target)); err != nil {
+			return err
+		}
+
+		n, err := extractZipFile(file, target, maxBytes-written, maxBytes > 0)
+		if err != nil {
+			return err
+		}
+
+		written += n
+	}
+
+	return nil
+}
+
+func extractZipFile(file *zip.File, target string, remaining int64, limited bool) (int64, error) {
+	in, err := file.Open()
+	if err != nil {
+		return 0, err
+	}
+
+	defer in.Close()
+
+	out, err := os.Create(target)
+	if err != nil {
+		return 0, err
+	}
+
+	defer out.Close()
+
+	var source io.Reader = in
+	if limited {
+		source = io.LimitReader(in, remaining+1)
+	}
+
+	n, err := io.Copy(out, source)
+	if err != nil {
+		return n, err
+	}
+
+	if limited && n > remaining {
+		return n, fmt.Errorf("archive exceeds the maximum extracted size")
+	}
+
+	return n, nil
+}
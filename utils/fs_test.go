@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"archive/zip"
 	"bytes"
 	"crypto/sha256"
 	"encoding/hex"
@@ -447,3 +448,72 @@ func TestTree(t *testing.T) {
 		t.Errorf("Tree result does not match expected output.\nGot: %v\nExpected: %v", result, expected)
 	}
 }
+
+func writeTestZip(t *testing.T, path string, files map[string]string) {
+	t.Helper()
+
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Failed to create zip file: %v", err)
+	}
+	defer file.Close()
+
+	writer := zip.NewWriter(file)
+	for name, content := range files {
+		entry, err := writer.Create(name)
+		if err != nil {
+			t.Fatalf("Failed to add %s to zip: %v", name, err)
+		}
+		if _, err := entry.Write([]byte(content)); err != nil {
+			t.Fatalf("Failed to write %s to zip: %v", name, err)
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Failed to close zip writer: %v", err)
+	}
+}
+
+func TestExtractZip(t *testing.T) {
+	tempDir := t.TempDir()
+
+	t.Run("Extracts nested files", func(t *testing.T) {
+		zipPath := filepath.Join(tempDir, "ok.zip")
+		writeTestZip(t, zipPath, map[string]string{
+			"docs/index.md":       "# Hello",
+			"docs/guide/setup.md": "# Setup",
+		})
+
+		dest := filepath.Join(tempDir, "ok")
+		if err := ExtractZip(zipPath, dest, 0); err != nil {
+			t.Fatalf("ExtractZip returned an error: %v", err)
+		}
+
+		content, err := os.ReadFile(filepath.Join(dest, "docs", "guide", "setup.md"))
+		if err != nil || string(content) != "# Setup" {
+			t.Errorf("Extracted file content = %q, %v", content, err)
+		}
+	})
+
+	t.Run("Rejects entries escaping the destination", func(t *testing.T) {
+		zipPath := filepath.Join(tempDir, "slip.zip")
+		writeTestZip(t, zipPath, map[string]string{"../evil.md": "nope"})
+
+		if err := ExtractZip(zipPath, filepath.Join(tempDir, "slip"), 0); err == nil {
+			t.Errorf("ExtractZip expected an error for a path traversal entry")
+		}
+
+		if PathExists(filepath.Join(tempDir, "evil.md")) {
+			t.Errorf("ExtractZip wrote a file outside the destination")
+		}
+	})
+
+	t.Run("Enforces the size limit", func(t *testing.T) {
+		zipPath := filepath.Join(tempDir, "big.zip")
+		writeTestZip(t, zipPath, map[string]string{"big.md": string(bytes.Repeat([]byte("a"), 2048))})
+
+		if err := ExtractZip(zipPath, filepath.Join(tempDir, "big"), 1024); err == nil {
+			t.Errorf("ExtractZip expected an error when exceeding the size limit")
+		}
+	})
+}
@@ -0,0 +1,548 @@
+package utils
+
+import (
+	"bufio"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	east "github.com/yuin/goldmark/extension/ast"
+	"github.com/yuin/goldmark/text"
+)
+
+// AssetResolver maps an image/media reference found in Markdown to the URL
+// that should be stored in the block. Returning an error keeps the original
+// reference.
+type AssetResolver func(src string) (string, error)
+
+type MarkdownDocument struct {
+	Title       string
+	FrontMatter map[string]string
+	Blocks      []Block
+}
+
+var alertMarkerRegex = regexp.MustCompile(`^\[!(NOTE|TIP|IMPORTANT|WARNING|CAUTION)\]\s*`)
+
+var alertMarkerTypes = map[string]string{
+	"NOTE":      "info",
+	"TIP":       "success",
+	"IMPORTANT": "info",
+	"WARNING":   "warning",
+	"CAUTION":   "danger",
+}
+
+// SplitFrontMatter separates a leading YAML front matter block from the rest
+// of the document. Only flat "key: value" pairs are read, which is all the
+// importer needs (title, sidebar labels, ordering).
+func SplitFrontMatter(content string) (map[string]string, string) {
+	frontMatter := make(map[string]string)
+	normalized := strings.ReplaceAll(content, "\r\n", "\n")
+
+	if !strings.HasPrefix(normalized, "---\n") {
+		return frontMatter, content
+	}
+
+	end := strings.Index(normalized[4:], "\n---")
+	if end == -1 {
+		return frontMatter, content
+	}
+
+	header := normalized[4 : 4+end]
+	body := strings.TrimLeft(normalized[4+end+4:], "\n")
+
+	scanner := bufio.NewScanner(strings.NewReader(header))
+	for scanner.Scan() {
+		key, value, found := strings.Cut(scanner.Text(), ":")
+		if !found || strings.HasPrefix(key, " ") || strings.HasPrefix(key, "#") {
+			continue
+		}
+
+		value = strings.TrimSpace(value)
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			value = unquoted
+		} else {
+			value = strings.Trim(value, `'`)
+		}
+
+		frontMatter[strings.TrimSpace(key)] = value
+	}
+
+	return frontMatter, body
+}
+
+// MarkdownToBlocks converts Markdown (or MDX without JSX components) into the
+// BlockNote block tree used for page content.
+func MarkdownToBlocks(source []byte, resolve AssetResolver) (MarkdownDocument, error) {
+	frontMatter, body := SplitFrontMatter(string(source))
+	body = stripMDXModuleLines(body)
+
+	src := []byte(body)
+	md := goldmark.New(goldmark.WithExtensions(extension.GFM))
+	root := md.Parser().Parse(text.NewReader(src))
+
+	converter := markdownConverter{source: src, resolve: resolve}
+	blocks := converter.convertChildren(root)
+
+	title := frontMatter["title"]
+	if title == "" {
+		for _, block := range blocks {
+			if block.Type == "heading" && block.Props["level"] == 1 {
+				title = plainInlineText(block.Content)
+				break
+			}
+		}
+	}
+
+	return MarkdownDocument{Title: title, FrontMatter: frontMatter, Blocks: blocks}, nil
+}
+
+func stripMDXModuleLines(body string) string {
+	lines := strings.Split(body, "\n")
+	kept := make([]string, 0, len(lines))
+	inFence := false
+
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			inFence = !inFence
+		}
+
+		if !inFence && (strings.HasPrefix(line, "import ") || strings.HasPrefix(line, "export ")) {
+			continue
+		}
+
+		kept = append(kept, line)
+	}
+
+	return strings.Join(kept, "\n")
+}
+
+type markdownConverter struct {
+	source  []byte
+	resolve AssetResolver
+}
+
+func newBlock(blockType string, props map[string]interface{}, content interface{}) Block {
+	baseProps := map[string]interface{}{}
+
+	switch blockType {
+	case "procode", "table":
+	default:
+		baseProps["textColor"] = "default"
+		baseProps["textAlignment"] = "left"
+		if blockType != "alert" {
+			baseProps["backgroundColor"] = "default"
+		}
+	}
+
+	for key, value := range props {
+		baseProps[key] = value
+	}
+
+	if content == nil {
+		content = []interface{}{}
+	}
+
+	return Block{
+		ID:       uuid.NewString(),
+		Type:     blockType,
+		Props:    baseProps,
+		Content:  content,
+		Children: []Block{},
+	}
+}
+
+func (c *markdownConverter) convertChildren(node ast.Node) []Block {
+	blocks := make([]Block, 0)
+
+	for child := node.FirstChild(); child != nil; child = child.NextSibling() {
+		blocks = append(blocks, c.convertBlock(child)...)
+	}
+
+	return blocks
+}
+
+func (c *markdownConverter) convertBlock(node ast.Node) []Block {
+	switch n := node.(type) {
+	case *ast.Heading:
+		level := n.Level
+		if level > 3 {
+			level = 3
+		}
+		return []Block{newBlock("heading", map[string]interface{}{"level": level}, c.inlineContent(n))}
+
+	case *ast.Paragraph, *ast.TextBlock:
+		return c.convertParagraph(n)
+
+	case *ast.List:
+		return c.convertList(n)
+
+	case *ast.FencedCodeBlock:
+		return []Block{newBlock("procode", map[string]interface{}{
+			"language": string(n.Language(c.source)),
+			"code":     c.lines(n),
+		}, nil)}
+
+	case *ast.CodeBlock:
+		return []Block{newBlock("procode", map[string]interface{}{
+			"language": "",
+			"code":     c.lines(n),
+		}, nil)}
+
+	case *ast.Blockquote:
+		return []Block{c.convertBlockquote(n)}
+
+	case *ast.HTMLBlock:
+		raw := strings.TrimSpace(c.lines(n))
+		if raw == "" || strings.HasPrefix(raw, "<!--") {
+			return nil
+		}
+
+		if elementType, src, caption, ok := ExtractAssetFromHTML(raw); ok {
+			return []Block{c.mediaBlock(elementType, src, caption)}
+		}
+
+		return []Block{newBlock("paragraph", nil, []interface{}{textItem(raw, nil)})}
+
+	case *east.Table:
+		return []Block{c.convertTable(n)}
+
+	case *ast.ThematicBreak:
+		return nil
+
+	default:
+		return c.convertChildren(node)
+	}
+}
+
+func (c *markdownConverter) lines(node ast.Node) string {
+	var builder strings.Builder
+	lines := node.Lines()
+
+	for i := 0; i < lines.Len(); i++ {
+		segment := lines.At(i)
+		builder.Write(segment.Value(c.source))
+	}
+
+	return strings.TrimRight(builder.String(), "\n")
+}
+
+// convertParagraph splits images out of a paragraph into their own blocks,
+// since BlockNote has no inline images.
+func (c *markdownConverter) convertParagraph(node ast.Node) []Block {
+	blocks := make([]Block, 0, 1)
+	content := make([]interface{}, 0)
+
+	flush := func() {
+		if len(content) > 0 {
+			blocks = append(blocks, newBlock("paragraph", nil, trimInline(content)))
+			content = make([]interface{}, 0)
+		}
+	}
+
+	for child := node.FirstChild(); child != nil; child = child.NextSibling() {
+		if image, ok := child.(*ast.Image); ok {
+			flush()
+			blocks = append(blocks, c.mediaBlock("img", string(image.Destination), c.plainText(image)))
+			continue
+		}
+
+		c.inline(child, map[string]interface{}{}, &content)
+	}
+
+	flush()
+
+	return blocks
+}
+
+func (c *markdownConverter) convertList(list *ast.List) []Block {
+	blocks := make([]Block, 0)
+	blockType := "bulletListItem"
+	if list.IsOrdered() {
+		blockType = "numberedListItem"
+	}
+
+	for item := list.FirstChild(); item != nil; item = item.NextSibling() {
+		itemType := blockType
+		props := map[string]interface{}{}
+		content := make([]interface{}, 0)
+		children := make([]Block, 0)
+		first := true
+
+		for child := item.FirstChild(); child != nil; child = child.NextSibling() {
+			_, isText := child.(*ast.TextBlock)
+			_, isParagraph := child.(*ast.Paragraph)
+
+			if first && (isText || isParagraph) {
+				first = false
+
+				if checkBox, ok := child.FirstChild().(*east.TaskCheckBox); ok {
+					itemType = "checkListItem"
+					props["checked"] = checkBox.IsChecked
+				}
+
+				content = c.inlineContent(child)
+				continue
+			}
+
+			first = false
+			children = append(children, c.convertBlock(child)...)
+		}
+
+		block := newBlock(itemType, props, content)
+		block.Children = children
+		blocks = append(blocks, block)
+	}
+
+	return blocks
+}
+
+func (c *markdownConverter) convertBlockquote(node *ast.Blockquote) Block {
+	content := make([]interface{}, 0)
+
+	for child := node.FirstChild(); child != nil; child = child.NextSibling() {
+		if len(content) > 0 {
+			content = append(content, textItem("\n", nil))
+		}
+		c.inline(child, map[string]interface{}{}, &content)
+	}
+
+	alertType := "info"
+
+	if len(content) > 0 {
+		if first, ok := content[0].(map[string]interface{}); ok {
+			if text, ok := first["text"].(string); ok {
+				if match := alertMarkerRegex.FindStringSubmatch(text); match != nil {
+					alertType = alertMarkerTypes[match[1]]
+					first["text"] = strings.TrimLeft(text[len(match[0]):], "\n")
+				}
+			}
+		}
+	}
+
+	return newBlock("alert", map[string]interface{}{"type": alertType}, trimInline(content))
+}
+
+func (c *markdownConverter) convertTable(table *east.Table) Block {
+	rows := make([]interface{}, 0)
+
+	for row := table.FirstChild(); row != nil; row = row.NextSibling() {
+		cells := make([]interface{}, 0)
+
+		for cell := row.FirstChild(); cell != nil; cell = cell.NextSibling() {
+			cells = append(cells, c.inlineContent(cell))
+		}
+
+		rows = append(rows, map[string]interface{}{"cells": cells})
+	}
+
+	return newBlock("table", nil, map[string]interface{}{
+		"type": "tableContent",
+		"rows": rows,
+	})
+}
+
+func (c *markdownConverter) mediaBlock(elementType, src, caption string) Block {
+	url := src
+	if c.resolve != nil && !strings.HasPrefix(src, "http://") && !strings.HasPrefix(src, "https://") && !strings.HasPrefix(src, "data:") {
+		if resolved, err := c.resolve(src); err == nil {
+			url = resolved
+		}
+	}
+
+	blockType := "image"
+	mime := GetContentType(src)
+
+	switch {
+	case elementType == "video" || strings.HasPrefix(mime, "video/"):
+		blockType = "video"
+	case elementType == "audio" || strings.HasPrefix(mime, "audio/"):
+		blockType = "audio"
+	case elementType == "img" || elementType == "figure" || strings.HasPrefix(mime, "image/"):
+		blockType = "image"
+	default:
+		blockType = "file"
+	}
+
+	props := map[string]interface{}{
+		"url":     url,
+		"caption": caption,
+		"name":    "",
+	}
+
+	if blockType != "file" {
+		props["showPreview"] = true
+		props["previewWidth"] = 512
+	}
+
+	return newBlock(blockType, props, nil)
+}
+
+func (c *markdownConverter) inlineContent(node ast.Node) []interface{} {
+	content := make([]interface{}, 0)
+
+	for child := node.FirstChild(); child != nil; child = child.NextSibling() {
+		c.inline(child, map[string]interface{}{}, &content)
+	}
+
+	return trimInline(content)
+}
+
+func copyStyles(styles map[string]interface{}, key string) map[string]interface{} {
+	result := make(map[string]interface{}, len(styles)+1)
+	for k, v := range styles {
+		result[k] = v
+	}
+	result[key] = true
+	return result
+}
+
+func textItem(text string, styles map[string]interface{}) map[string]interface{} {
+	if styles == nil {
+		styles = map[string]interface{}{}
+	}
+
+	return map[string]interface{}{
+		"type":   "text",
+		"text":   text,
+		"styles": styles,
+	}
+}
+
+func (c *markdownConverter) inline(node ast.Node, styles map[string]interface{}, out *[]interface{}) {
+	switch n := node.(type) {
+	case *ast.Text:
+		value := string(n.Segment.Value(c.source))
+		if n.HardLineBreak() {
+			value += "\n"
+		} else if n.SoftLineBreak() {
+			value += " "
+		}
+		appendText(out, value, styles)
+
+	case *ast.String:
+		appendText(out, string(n.Value), styles)
+
+	case *ast.Emphasis:
+		style := "italic"
+		if n.Level >= 2 {
+			style = "bold"
+		}
+		for child := n.FirstChild(); child != nil; child = child.NextSibling() {
+			c.inline(child, copyStyles(styles, style), out)
+		}
+
+	case *east.Strikethrough:
+		for child := n.FirstChild(); child != nil; child = child.NextSibling() {
+			c.inline(child, copyStyles(styles, "strike"), out)
+		}
+
+	case *ast.CodeSpan:
+		appendText(out, c.plainText(n), copyStyles(styles, "code"))
+
+	case *ast.Link:
+		linkContent := make([]interface{}, 0)
+		for child := n.FirstChild(); child != nil; child = child.NextSibling() {
+			c.inline(child, styles, &linkContent)
+		}
+		*out = append(*out, map[string]interface{}{
+			"type":    "link",
+			"href":    string(n.Destination),
+			"content": linkContent,
+		})
+
+	case *ast.AutoLink:
+		url := string(n.URL(c.source))
+		*out = append(*out, map[string]interface{}{
+			"type":    "link",
+			"href":    url,
+			"content": []interface{}{textItem(string(n.Label(c.source)), styles)},
+		})
+
+	case *ast.Image:
+		appendText(out, c.plainText(n), styles)
+
+	case *ast.RawHTML, *east.TaskCheckBox:
+		return
+
+	default:
+		for child := n.FirstChild(); child != nil; child = child.NextSibling() {
+			c.inline(child, styles, out)
+		}
+	}
+}
+
+// appendText merges consecutive runs with identical styles so the stored
+// content stays close to what the editor itself would produce.
+func appendText(out *[]interface{}, value string, styles map[string]interface{}) {
+	if value == "" {
+		return
+	}
+
+	if len(*out) > 0 {
+		if last, ok := (*out)[len(*out)-1].(map[string]interface{}); ok && last["type"] == "text" {
+			if lastStyles, ok := last["styles"].(map[string]interface{}); ok && sameStyles(lastStyles, styles) {
+				last["text"] = last["text"].(string) + value
+				return
+			}
+		}
+	}
+
+	*out = append(*out, textItem(value, styles))
+}
+
+func sameStyles(a, b map[string]interface{}) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for key, value := range a {
+		if b[key] != value {
+			return false
+		}
+	}
+
+	return true
+}
+
+func trimInline(content []interface{}) []interface{} {
+	if len(content) == 0 {
+		return content
+	}
+
+	if last, ok := content[len(content)-1].(map[string]interface{}); ok && last["type"] == "text" {
+		last["text"] = strings.TrimRight(last["text"].(string), " \n")
+		if last["text"] == "" {
+			content = content[:len(content)-1]
+		}
+	}
+
+	return content
+}
+
+func (c *markdownConverter) plainText(node ast.Node) string {
+	var builder strings.Builder
+
+	for child := node.FirstChild(); child != nil; child = child.NextSibling() {
+		switch n := child.(type) {
+		case *ast.Text:
+			builder.Write(n.Segment.Value(c.source))
+		case *ast.String:
+			builder.Write(n.Value)
+		default:
+			builder.WriteString(c.plainText(n))
+		}
+	}
+
+	return builder.String()
+}
+
+func plainInlineText(content interface{}) string {
+	var builder strings.Builder
+	collectInlineText(content, &builder)
+	return builder.String()
+}
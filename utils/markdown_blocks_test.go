@@ -0,0 +1,110 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSplitFrontMatter(t *testing.T) {
+	frontMatter, body := SplitFrontMatter("---\ntitle: \"Getting Started\"\nsidebar_position: 2\n---\n\n# Body\n")
+
+	if frontMatter["title"] != "Getting Started" || frontMatter["sidebar_position"] != "2" {
+		t.Errorf("SplitFrontMatter() front matter = %v", frontMatter)
+	}
+
+	if body != "# Body\n" {
+		t.Errorf("SplitFrontMatter() body = %q", body)
+	}
+
+	frontMatter, body = SplitFrontMatter("# No front matter")
+	if len(frontMatter) != 0 || body != "# No front matter" {
+		t.Errorf("SplitFrontMatter() without front matter = %v, %q", frontMatter, body)
+	}
+}
+
+func TestMarkdownToBlocks(t *testing.T) {
+	source := strings.Join([]string{
+		"import Tabs from '@theme/Tabs'",
+		"",
+		"# Install Guide",
+		"",
+		"Run **this** and `that`, see [docs](https://example.com).",
+		"",
+		"![Diagram](./img/diagram.png)",
+		"",
+		"- one",
+		"  - nested",
+		"- [x] done",
+		"",
+		"1. first",
+		"",
+		"```go",
+		"fmt.Println(\"hi\")",
+		"```",
+		"",
+		"> [!WARNING]",
+		"> Be careful",
+		"",
+		"| A | B |",
+		"|---|---|",
+		"| 1 | 2 |",
+	}, "\n")
+
+	var resolved []string
+	doc, err := MarkdownToBlocks([]byte(source), func(src string) (string, error) {
+		resolved = append(resolved, src)
+		return "https://cdn.example.com/diagram.png", nil
+	})
+	if err != nil {
+		t.Fatalf("MarkdownToBlocks returned an error: %v", err)
+	}
+
+	if doc.Title != "Install Guide" {
+		t.Errorf("Title = %q, want %q", doc.Title, "Install Guide")
+	}
+
+	var types []string
+	for _, block := range doc.Blocks {
+		types = append(types, block.Type)
+	}
+
+	expectedTypes := []string{"heading", "paragraph", "image", "bulletListItem", "checkListItem", "numberedListItem", "procode", "alert", "table"}
+	if strings.Join(types, ",") != strings.Join(expectedTypes, ",") {
+		t.Fatalf("Block types = %v, want %v", types, expectedTypes)
+	}
+
+	paragraph := doc.Blocks[1].Content.([]interface{})
+	bold := paragraph[1].(map[string]interface{})
+	if bold["text"] != "this" || bold["styles"].(map[string]interface{})["bold"] != true {
+		t.Errorf("Expected bold run, got %v", bold)
+	}
+
+	link := paragraph[len(paragraph)-2].(map[string]interface{})
+	if link["type"] != "link" || link["href"] != "https://example.com" {
+		t.Errorf("Expected link, got %v", link)
+	}
+
+	if len(resolved) != 1 || resolved[0] != "./img/diagram.png" || doc.Blocks[2].Props["url"] != "https://cdn.example.com/diagram.png" {
+		t.Errorf("Image was not resolved: %v, %v", resolved, doc.Blocks[2].Props)
+	}
+
+	if len(doc.Blocks[3].Children) != 1 || doc.Blocks[3].Children[0].Type != "bulletListItem" {
+		t.Errorf("Expected nested list item, got %v", doc.Blocks[3].Children)
+	}
+
+	if doc.Blocks[4].Props["checked"] != true {
+		t.Errorf("Expected checked item, got %v", doc.Blocks[4].Props)
+	}
+
+	if doc.Blocks[6].Props["language"] != "go" || doc.Blocks[6].Props["code"] != `fmt.Println("hi")` {
+		t.Errorf("Unexpected code block props: %v", doc.Blocks[6].Props)
+	}
+
+	if doc.Blocks[7].Props["type"] != "warning" || BlocksToPlainText(doc.Blocks[7:8]) != "Be careful" {
+		t.Errorf("Unexpected alert block: %v %q", doc.Blocks[7].Props, BlocksToPlainText(doc.Blocks[7:8]))
+	}
+
+	if BlocksToPlainText(doc.Blocks[8:]) != "A B 1 2" {
+		t.Errorf("Unexpected table text: %q", BlocksToPlainText(doc.Blocks[8:]))
+	}
+}
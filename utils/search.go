@@ -0,0 +1,108 @@
+package utils
+
+import (
+	"strings"
+	"unicode"
+)
+
+// BlocksToPlainText flattens a block tree into the text a reader would see,
+// one block per line. Styling is dropped; code blocks and captions are kept
+// since they are often what people search for.
+func BlocksToPlainText(blocks []Block) string {
+	var builder strings.Builder
+	writeBlocksText(blocks, &builder)
+	return strings.TrimSpace(builder.String())
+}
+
+func writeBlocksText(blocks []Block, builder *strings.Builder) {
+	for _, block := range blocks {
+		start := builder.Len()
+
+		collectInlineText(block.Content, builder)
+
+		for _, prop := range []string{"code", "caption", "name"} {
+			if value, ok := block.Props[prop].(string); ok && value != "" {
+				if builder.Len() > start {
+					builder.WriteString(" ")
+				}
+				builder.WriteString(value)
+			}
+		}
+
+		if builder.Len() > start {
+			builder.WriteString("\n")
+		}
+
+		writeBlocksText(block.Children, builder)
+	}
+}
+
+func collectInlineText(content interface{}, builder *strings.Builder) {
+	switch v := content.(type) {
+	case string:
+		builder.WriteString(v)
+	case []interface{}:
+		for _, item := range v {
+			collectInlineText(item, builder)
+		}
+	case map[string]interface{}:
+		if text, ok := v["text"].(string); ok {
+			builder.WriteString(text)
+		}
+
+		if rows, ok := v["rows"].([]interface{}); ok {
+			for _, row := range rows {
+				if rowMap, ok := row.(map[string]interface{}); ok {
+					if cells, ok := rowMap["cells"].([]interface{}); ok {
+						for _, cell := range cells {
+							collectInlineText(cell, builder)
+							builder.WriteString(" ")
+						}
+					}
+				}
+			}
+		}
+
+		collectInlineText(v["content"], builder)
+	}
+}
+
+// SearchTerms splits free-form user input into lowercase words, dropping any
+// punctuation that would otherwise be interpreted as query syntax.
+func SearchTerms(input string) []string {
+	return strings.FieldsFunc(strings.ToLower(input), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// BuildFTS5Query turns user input into an SQLite FTS5 MATCH expression where
+// every term must match and the last term is treated as a prefix.
+func BuildFTS5Query(input string) string {
+	terms := SearchTerms(input)
+	quoted := make([]string, 0, len(terms))
+
+	for i, term := range terms {
+		q := `"` + term + `"`
+		if i == len(terms)-1 {
+			q += "*"
+		}
+		quoted = append(quoted, q)
+	}
+
+	return strings.Join(quoted, " AND ")
+}
+
+// BuildTSQuery is the Postgres to_tsquery counterpart of BuildFTS5Query.
+func BuildTSQuery(input string) string {
+	terms := SearchTerms(input)
+	parts := make([]string, 0, len(terms))
+
+	for i, term := range terms {
+		if i == len(terms)-1 {
+			term += ":*"
+		}
+		parts = append(parts, term)
+	}
+
+	return strings.Join(parts, " & ")
+}
@@ -0,0 +1,70 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBlocksToPlainText(t *testing.T) {
+	content := `[
+		{"id":"1","type":"heading","props":{"level":1},"content":[{"type":"text","text":"Getting started","styles":{"bold":true}}],"children":[]},
+		{"id":"2","type":"paragraph","props":{},"content":[{"type":"text","text":"Read the ","styles":{}},{"type":"link","href":"/docs","content":[{"type":"text","text":"docs","styles":{}}]}],"children":[
+			{"id":"3","type":"bulletListItem","props":{},"content":[{"type":"text","text":"nested item","styles":{}}],"children":[]}
+		]},
+		{"id":"4","type":"procode","props":{"code":"go run main.go","language":"go"},"content":[],"children":[]},
+		{"id":"5","type":"table","props":{},"content":{"type":"tableContent","rows":[{"cells":[[{"type":"text","text":"a","styles":{}}],[{"type":"text","text":"b","styles":{}}]]}]},"children":[]}
+	]`
+
+	blocks, err := ParseBlocks(content)
+	if err != nil {
+		t.Fatalf("ParseBlocks returned an error: %v", err)
+	}
+
+	expected := "Getting started\nRead the docs\nnested item\ngo run main.go\na b"
+	if got := BlocksToPlainText(blocks); got != expected {
+		t.Errorf("BlocksToPlainText() = %q, want %q", got, expected)
+	}
+}
+
+func TestSearchTerms(t *testing.T) {
+	got := SearchTerms(`Hello, "World" AND foo-bar*`)
+	expected := []string{"hello", "world", "and", "foo", "bar"}
+
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("SearchTerms() = %v, want %v", got, expected)
+	}
+}
+
+func TestBuildFTS5Query(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"", ""},
+		{"install", `"install"*`},
+		{`getting "started`, `"getting" AND "started"*`},
+	}
+
+	for _, tt := range tests {
+		if got := BuildFTS5Query(tt.input); got != tt.expected {
+			t.Errorf("BuildFTS5Query(%q) = %q, want %q", tt.input, got, tt.expected)
+		}
+	}
+}
+
+func TestBuildTSQuery(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"", ""},
+		{"install", "install:*"},
+		{"getting & started", "getting & started:*"},
+	}
+
+	for _, tt := range tests {
+		if got := BuildTSQuery(tt.input); got != tt.expected {
+			t.Errorf("BuildTSQuery(%q) = %q, want %q", tt.input, got, tt.expected)
+		}
+	}
+}
@@ -121,6 +121,8 @@ var contentTypes = map[string]string{
 	".css":  "text/css",
 	".js":   "application/javascript",
 	".json": "application/json",
+	".xml":  "application/xml",
+	".txt":  "text/plain",
 	".png":  "image/png",
 	".jpg":  "image/jpeg",
 	".jpeg": "image/jpeg",
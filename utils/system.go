@@ -12,8 +12,15 @@ import (
 )
 
 func RunNpmCommand(dir string, command string, args ...string) error {
+	_, err := RunNpmCommandOutput(dir, command, args...)
+	return err
+}
+
+// RunNpmCommandOutput is RunNpmCommand, but also returns the combined
+// stdout/stderr of every attempt.
+func RunNpmCommandOutput(dir string, command string, args ...string) (string, error) {
 	if _, err := os.Stat(dir); os.IsNotExist(err) {
-		return fmt.Errorf("directory '%s' does not exist", dir)
+		return "", fmt.Errorf("directory '%s' does not exist", dir)
 	}
 
 	fullCommand := append([]string{command}, args...)
@@ -21,67 +28,87 @@ func RunNpmCommand(dir string, command string, args ...string) error {
 
 	var output []byte
 	var err error
+	var log strings.Builder
 
 	for i := 0; i < maxRetries; i++ {
 		cmd := exec.Command("pnpm", fullCommand...)
 		cmd.Dir = dir
 
 		output, err = cmd.CombinedOutput()
+		writeCommandLog(&log, "pnpm", fullCommand, i+1, output)
 
 		if err == nil {
-			return nil
+			return log.String(), nil
 		}
 
 		if len(args) > 0 && args[0] == "install" {
 			nodeModulesPath := filepath.Join(dir, "node_modules")
 			if err := os.RemoveAll(nodeModulesPath); err != nil {
-				return fmt.Errorf("failed to remove node_modules: %w", err)
+				return log.String(), fmt.Errorf("failed to remove node_modules: %w", err)
 			}
 		}
 
 		if len(args) > 1 && args[1] == "build" {
 			buildTmpPath := filepath.Join(dir, "build_tmp")
 			if err := os.RemoveAll(buildTmpPath); err != nil {
-				return fmt.Errorf("failed to remove build_tmp: %w", err)
+				return log.String(), fmt.Errorf("failed to remove build_tmp: %w", err)
 			}
 		}
 	}
 
-	return fmt.Errorf("npm command '%s' failed after %d retries with output %s",
+	return log.String(), fmt.Errorf("npm command '%s' failed after %d retries with output %s",
 		strings.Join(fullCommand, " "),
 		maxRetries,
 		string(output))
 }
 
 func RunNpxCommand(dir string, command string, args ...string) error {
+	_, err := RunNpxCommandOutput(dir, command, args...)
+	return err
+}
+
+// RunNpxCommandOutput is RunNpxCommand, but also returns the combined
+// stdout/stderr of every attempt.
+func RunNpxCommandOutput(dir string, command string, args ...string) (string, error) {
 	if _, err := os.Stat(dir); os.IsNotExist(err) {
-		return fmt.Errorf("directory '%s' does not exist", dir)
+		return "", fmt.Errorf("directory '%s' does not exist", dir)
 	}
 
 	fullCommand := append([]string{command}, args...)
 	const maxRetries = 3
 
+	var log strings.Builder
+
 	for i := 0; i < maxRetries; i++ {
 		cmd := exec.Command("npx", fullCommand...)
 		cmd.Dir = dir
 
 		output, err := cmd.CombinedOutput()
+		writeCommandLog(&log, "npx", fullCommand, i+1, output)
 
 		if err == nil {
-			return nil
+			return log.String(), nil
 		}
 
 		if command == "rspress" && len(args) > 0 && args[0] == "build" {
 			buildTmpPath := filepath.Join(dir, "build_tmp")
 			if err := os.RemoveAll(buildTmpPath); err != nil {
-				return fmt.Errorf("failed to remove build_tmp: %w", err)
+				return log.String(), fmt.Errorf("failed to remove build_tmp: %w", err)
 			}
 		}
 
 		logger.Warn("npx command failed", zap.String("command", strings.Join(fullCommand, " ")), zap.Error(err), zap.String("output", string(output)))
 	}
 
-	return fmt.Errorf("npx command '%s' failed after %d retries", strings.Join(fullCommand, " "), maxRetries)
+	return log.String(), fmt.Errorf("npx command '%s' failed after %d retries", strings.Join(fullCommand, " "), maxRetries)
+}
+
+func writeCommandLog(log *strings.Builder, name string, command []string, attempt int, output []byte) {
+	fmt.Fprintf(log, "$ %s %s (attempt %d)\n", name, strings.Join(command, " "), attempt)
+	log.Write(output)
+	if len(output) > 0 && output[len(output)-1] != '\n' {
+		log.WriteByte('\n')
+	}
 }
 
 func NpmPing() bool {